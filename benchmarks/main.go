@@ -0,0 +1,162 @@
+// Command benchmarks times the collections and str stdlib against C
+// Each benchmark is a pair of programs in programs/, name.lts and name.c,
+// doing the same work and printing the same checksum. Both are built, their
+// outputs are compared, and each is run several times; the fastest run of
+// each is reported with the ratio between them:
+//
+//	$ go run ./benchmarks
+//	benchmark       C (ms)  Lotus (ms)  Lotus/C
+//	array             69.7       124.3    1.78x
+//	hashmap          149.5       163.2    1.09x
+//	str               28.4       186.0    6.54x
+//
+// A ratio above 1 means the Lotus program is slower. Run it from the module
+// root before and after a change to the optimizer or the stdlib to see what
+// the change did. Names given as arguments select benchmarks.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", "benchmarks/programs", "directory holding the benchmark programs")
+	lotus := flag.String("lotus", "", "Lotus compiler to use (default: build ./src)")
+	cc := flag.String("cc", "cc", "C compiler")
+	opt := flag.Int("O", 2, "optimization level for both compilers")
+	runs := flag.Int("runs", 5, "runs of each program; the fastest counts")
+	flag.Parse()
+
+	names, err := benchmarkNames(*dir, flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	work, err := os.MkdirTemp("", "lotus-bench")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(work)
+
+	if *lotus == "" {
+		*lotus = filepath.Join(work, "lotus")
+		if err := run("go", "build", "-o", *lotus, "./src"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: building the compiler: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	failed := false
+	fmt.Printf("%-12s  %8s  %10s  %7s\n", "benchmark", "C (ms)", "Lotus (ms)", "Lotus/C")
+	for _, name := range names {
+		cBin := filepath.Join(work, name+"_c")
+		lotusBin := filepath.Join(work, name+"_lotus")
+		level := fmt.Sprintf("-O%d", *opt)
+		if err := run(*cc, level, "-o", cBin, filepath.Join(*dir, name+".c")); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: building the C program: %v\n", name, err)
+			failed = true
+			continue
+		}
+		if err := run(*lotus, level, "-o", lotusBin, filepath.Join(*dir, name+".lts")); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: building the Lotus program: %v\n", name, err)
+			failed = true
+			continue
+		}
+
+		cTime, cOut, err := fastest(cBin, *runs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: running the C program: %v\n", name, err)
+			failed = true
+			continue
+		}
+		lotusTime, lotusOut, err := fastest(lotusBin, *runs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: running the Lotus program: %v\n", name, err)
+			failed = true
+			continue
+		}
+		if !bytes.Equal(cOut, lotusOut) {
+			fmt.Fprintf(os.Stderr, "%s: outputs differ: C printed %q, Lotus printed %q\n", name, cOut, lotusOut)
+			failed = true
+			continue
+		}
+		fmt.Printf("%-12s  %8.1f  %10.1f  %6.2fx\n", name, ms(cTime), ms(lotusTime),
+			float64(lotusTime)/float64(cTime))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// benchmarkNames returns the benchmarks in dir that have both programs,
+// limited to selected when it is not empty
+func benchmarkNames(dir string, selected []string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.lts"))
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	var names []string
+	for _, path := range matches {
+		name := strings.TrimSuffix(filepath.Base(path), ".lts")
+		if _, err := os.Stat(filepath.Join(dir, name+".c")); err == nil {
+			found[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no benchmarks in %s (run from the module root or pass -dir)", dir)
+	}
+	sort.Strings(names)
+	if len(selected) == 0 {
+		return names, nil
+	}
+	for _, name := range selected {
+		if !found[name] {
+			return nil, fmt.Errorf("no benchmark named %s", name)
+		}
+	}
+	return selected, nil
+}
+
+// run runs a build command, returning its output with the error
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v\n%s", err, out)
+	}
+	return err
+}
+
+// fastest runs bin n times and returns its shortest wall time and its output
+func fastest(bin string, n int) (time.Duration, []byte, error) {
+	var best time.Duration
+	var output []byte
+	for i := 0; i < max(n, 1); i++ {
+		start := time.Now()
+		out, err := exec.Command(bin).Output()
+		elapsed := time.Since(start)
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 0 || elapsed < best {
+			best = elapsed
+		}
+		output = out
+	}
+	return best, output, nil
+}
+
+// ms converts a duration to milliseconds
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,35 @@
+// A growable int array filled and read back, as collections::array_int_* is
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+struct array {
+	int64_t len, cap;
+	int64_t *data;
+};
+
+static int64_t push(struct array *a, int64_t v) {
+	if (a->len >= a->cap) {
+		return -1;
+	}
+	a->data[a->len++] = v;
+	return a->len;
+}
+
+static int64_t get(struct array *a, int64_t i) {
+	return i >= 0 && i < a->len ? a->data[i] : 0;
+}
+
+int main(void) {
+	int64_t n = 10000000;
+	struct array a = {0, n, malloc(n * sizeof(int64_t))};
+	for (int64_t i = 0; i < n; i++) {
+		push(&a, i);
+	}
+	int64_t sum = 0;
+	for (int64_t i = 0; i < n; i++) {
+		sum += get(&a, i);
+	}
+	printf("%lld\n", (long long)sum);
+	return 0;
+}
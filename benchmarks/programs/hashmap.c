@@ -0,0 +1,76 @@
+// An open addressing int -> int map with linear probing and a power-of-two
+// capacity that doubles as it fills, like collections::hashmap_int_*
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+struct map {
+	int64_t len, cap;
+	int64_t *keys, *vals;
+	char *used;
+};
+
+static uint64_t hash(int64_t key) {
+	uint64_t h = (uint64_t)key * 0x9e3779b97f4a7c15ull;
+	return h ^ (h >> 32);
+}
+
+static void put(struct map *m, int64_t key, int64_t val);
+
+static void grow(struct map *m) {
+	struct map old = *m;
+	m->cap *= 2;
+	m->len = 0;
+	m->keys = calloc(m->cap, sizeof *m->keys);
+	m->vals = calloc(m->cap, sizeof *m->vals);
+	m->used = calloc(m->cap, 1);
+	for (int64_t i = 0; i < old.cap; i++) {
+		if (old.used[i]) {
+			put(m, old.keys[i], old.vals[i]);
+		}
+	}
+	free(old.keys);
+	free(old.vals);
+	free(old.used);
+}
+
+static void put(struct map *m, int64_t key, int64_t val) {
+	if (4 * (m->len + 1) > 3 * m->cap) {
+		grow(m);
+	}
+	uint64_t i = hash(key) & (m->cap - 1);
+	while (m->used[i] && m->keys[i] != key) {
+		i = (i + 1) & (m->cap - 1);
+	}
+	if (!m->used[i]) {
+		m->used[i] = 1;
+		m->keys[i] = key;
+		m->len++;
+	}
+	m->vals[i] = val;
+}
+
+static int64_t get(struct map *m, int64_t key) {
+	uint64_t i = hash(key) & (m->cap - 1);
+	while (m->used[i]) {
+		if (m->keys[i] == key) {
+			return m->vals[i];
+		}
+		i = (i + 1) & (m->cap - 1);
+	}
+	return 0;
+}
+
+int main(void) {
+	int64_t n = 1000000;
+	struct map m = {0, 16, calloc(16, 8), calloc(16, 8), calloc(16, 1)};
+	for (int64_t i = 0; i < n; i++) {
+		put(&m, i * 7, i);
+	}
+	int64_t sum = 0;
+	for (int64_t i = 0; i < n; i++) {
+		sum += get(&m, i * 7);
+	}
+	printf("%lld\n", (long long)sum);
+	return 0;
+}
@@ -0,0 +1,24 @@
+// strlen, strcmp and strstr on the strings of str.lts. The strings are read
+// through volatile pointers so the work is not hoisted out of the loop.
+#include <stdint.h>
+#include <stdio.h>
+#include <string.h>
+
+static const char *volatile text_v = "the quick brown fox jumps over the lazy dog";
+static const char *volatile word_v = "lazy";
+static const char *volatile other_v = "the quick brown fox jumps over the lazy cat";
+
+int main(void) {
+	int64_t n = 2000000;
+	int64_t sum = 0;
+	for (int64_t i = 0; i < n; i++) {
+		const char *text = text_v, *word = word_v, *other = other_v;
+		int64_t l = strlen(text);
+		int c = strcmp(text, other);
+		const char *at = strstr(text, word);
+		int64_t f = at ? at - text : -1;
+		sum += l + (c > 0) - (c < 0) + f;
+	}
+	printf("%lld\n", (long long)sum);
+	return 0;
+}
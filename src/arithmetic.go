@@ -78,6 +78,10 @@ func (cg *CodeGenerator) generateBinaryOp(binop *BinaryOp) {
 
 // generateUnaryOp generates assembly for unary operations
 func (cg *CodeGenerator) generateUnaryOp(unop *UnaryOp) {
+	if unop.Operator == TokenAmpersand {
+		cg.generateReference(&Reference{Target: unop.Operand})
+		return
+	}
 	cg.generateExpressionToReg(unop.Operand, "rax")
 
 	switch unop.Operator {
@@ -87,8 +91,6 @@ func (cg *CodeGenerator) generateUnaryOp(unop *UnaryOp) {
 		cg.textSection.WriteString("    testq %rax, %rax\n")
 		cg.textSection.WriteString("    setzb %al\n")
 		cg.textSection.WriteString("    movzbl %al, %eax\n")
-	case TokenAmpersand:
-		// Unary & (address-of) - handled in references.go
 	case TokenStar:
 		// Unary * (dereference) - handled in references.go
 	case TokenTilde:
@@ -162,6 +164,12 @@ func (cg *CodeGenerator) generateExpressionToReg(expr ASTNode, reg string) {
 	switch e := expr.(type) {
 	case *IntLiteral:
 		cg.textSection.WriteString(fmt.Sprintf("    movq $%d, %%%s\n", e.Value, reg))
+	case *FloatLiteral:
+		cg.textSection.WriteString(fmt.Sprintf("    movq %s(%%rip), %%%s\n", cg.floatLabel(e.Value), reg))
+	case *FloatOp:
+		cg.generateFloatToReg(e, reg)
+	case *FloatConversion:
+		cg.generateFloatConversion(e, reg)
 	case *StringLiteral:
 		// Emit string to data section and load address
 		label, _ := emitStringLiteral(cg, e.Value)
@@ -186,6 +194,14 @@ func (cg *CodeGenerator) generateExpressionToReg(expr ASTNode, reg string) {
 		if reg != "rax" {
 			cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, %%%s\n", reg))
 		}
+	case *Conversion:
+		cg.generateConversion(e, reg)
+	case *Include:
+		cg.generateInclude(e, reg)
+	case *OutlinedArg:
+		cg.textSection.WriteString(fmt.Sprintf("    movq %s(%%rip), %%%s\n", e.Slot, reg))
+	case *Unwrap:
+		cg.generateUnwrap(e, reg)
 	case *UnaryOp:
 		cg.generateUnaryOp(e)
 		if reg != "rax" {
@@ -201,6 +217,11 @@ func (cg *CodeGenerator) generateExpressionToReg(expr ASTNode, reg string) {
 		if reg != "rax" {
 			cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, %%%s\n", reg))
 		}
+	case *MapLiteral:
+		cg.generateMapLiteral(e)
+		if reg != "rax" {
+			cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, %%%s\n", reg))
+		}
 	case *FieldAccess:
 		cg.generateFieldAccess(e)
 		if reg != "rax" {
@@ -266,5 +287,15 @@ func (cg *CodeGenerator) generateExpressionToReg(expr ASTNode, reg string) {
 		if reg != "rax" {
 			cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, %%%s\n", reg))
 		}
+	case *StackBuffer:
+		cg.generateStackBuffer(e)
+		if reg != "rax" {
+			cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, %%%s\n", reg))
+		}
+	case *LabelAddress:
+		cg.generateLabelAddress(e)
+		if reg != "rax" {
+			cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, %%%s\n", reg))
+		}
 	}
 }
@@ -40,42 +40,6 @@ type DynamicArray struct {
 	ElemSize int   // size of each element
 }
 
-// generateArrayLiteral generates assembly for array literals
-func (cg *CodeGenerator) generateArrayLiteral(arr *ArrayLiteral) {
-	elemSize := getTypeSizeFromToken(arr.ElemType)
-	totalSize := len(arr.Elements) * elemSize
-
-	// Allocate memory for array on heap
-	cg.textSection.WriteString(fmt.Sprintf("    movq $%d, %%rdi\n", totalSize))
-	cg.textSection.WriteString("    call malloc@PLT\n")
-	cg.textSection.WriteString("    pushq %rax\n") // Save array pointer
-
-	// Fill array with elements
-	for i, elem := range arr.Elements {
-		// Evaluate element
-		cg.generateExpressionToReg(elem, "rcx")
-
-		// Get array pointer
-		cg.textSection.WriteString("    movq (%rsp), %rax\n")
-
-		// Store element at offset
-		offset := i * elemSize
-		switch elemSize {
-		case 1:
-			cg.textSection.WriteString(fmt.Sprintf("    movb %%cl, %d(%%rax)\n", offset))
-		case 2:
-			cg.textSection.WriteString(fmt.Sprintf("    movw %%cx, %d(%%rax)\n", offset))
-		case 4:
-			cg.textSection.WriteString(fmt.Sprintf("    movl %%ecx, %d(%%rax)\n", offset))
-		case 8:
-			cg.textSection.WriteString(fmt.Sprintf("    movq %%rcx, %d(%%rax)\n", offset))
-		}
-	}
-
-	// Pop array pointer back to rax
-	cg.textSection.WriteString("    popq %rax\n")
-}
-
 // generateArrayAccess generates assembly for array indexing
 func (cg *CodeGenerator) generateArrayAccess(access *ArrayAccess) {
 	// Get array base address into rax
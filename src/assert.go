@@ -0,0 +1,67 @@
+package main
+
+import "fmt"
+
+// assert.go - Assertions
+// assert(cond, msg) documents an invariant and checks it at run time:
+//
+//	assert(i < collections::heap_int_len(h), "heap index in bounds");
+//
+// When cond is 0 the program writes where and why to stderr and exits with
+// status 1, as an unwrapped null does:
+//
+//	heap.lts:12: assertion failed: heap index in bounds
+//
+// msg is any string expression, evaluated only when the assertion fails.
+// Under -release an assert compiles to nothing and neither argument is
+// evaluated, so cond must not be relied on for its side effects.
+
+// isAssert reports whether call is the assert builtin rather than a user
+// function of that name
+func isAssert(call *FunctionCall) bool {
+	return call.Name == "assert" && UserDefinedFunctions[call.Name] == nil
+}
+
+// checkAssert reports an assert that is not given a condition and a message
+func (sa *SemanticAnalyzer) checkAssert(call *FunctionCall) {
+	if len(call.Args) != 2 {
+		loc := call.NameLoc
+		sa.diagnostics.AddErrorWithCode(string(ErrInvalidOperation), CategorySemantic,
+			fmt.Sprintf("'assert' takes a condition and a message, got %d argument(s)", len(call.Args)),
+			sa.filePath, loc.Line, loc.Column, sa.getSourceLine(loc.Line))
+	}
+}
+
+// generateAssert checks an assertion, unless building with -release
+func (cg *CodeGenerator) generateAssert(call *FunctionCall) {
+	if cg.release || len(call.Args) != 2 {
+		return
+	}
+	start := cg.textSection.Len()
+	cg.useAsserts()
+	okLabel := cg.getLabel("assert_ok")
+	where, _ := emitStringLiteral(cg, fmt.Sprintf("%s:%d: assertion failed: ", cg.diagnostics.FilePath, call.Loc().Line))
+	newline, _ := emitStringLiteral(cg, "\n")
+
+	cg.generateConditionToReg(call.Args[0], "rax")
+	asm := cg.asm()
+	asm.TestRegReg("rax", "rax")
+	asm.Jcc("nz", okLabel)
+	asm.LeaLabel(where, "rsi")
+	asm.CallRuntime("puts")
+	cg.generateExpressionToReg(call.Args[1], "rsi")
+	asm.CallRuntime("puts")
+	asm.LeaLabel(newline, "rsi")
+	asm.CallRuntime("puts")
+	asm.MovImmReg(1, "rdi")
+	asm.LoadSyscall("exit")
+	asm.Syscall()
+	asm.Label(okLabel)
+	cg.noteSyscallOrigin(start, call.Name, call.NameLoc)
+	cg.checkFreestandingCall(call, cg.textSection.String()[start:])
+}
+
+// useAsserts appends the stderr print helpers assertions report with
+func (cg *CodeGenerator) useAsserts() {
+	cg.asserts = true
+}
@@ -26,6 +26,11 @@ func (b BaseNode) Loc() Location {
 	return b.Location
 }
 
+// setLoc records the source location; the parser calls it as nodes are built
+func (b *BaseNode) setLoc(l Location) {
+	b.Location = l
+}
+
 // ============================================================================
 // Statement Nodes
 // ============================================================================
@@ -33,7 +38,8 @@ func (b BaseNode) Loc() Location {
 // ReturnStatement represents a return statement with an optional value
 type ReturnStatement struct {
 	BaseNode
-	Value ASTNode
+	Value      ASTNode
+	Attributes []Attribute // @musttail
 }
 
 func (r *ReturnStatement) astNode() {}
@@ -41,9 +47,11 @@ func (r *ReturnStatement) astNode() {}
 // VariableDeclaration represents a variable declaration with type and initial value
 type VariableDeclaration struct {
 	BaseNode
-	Name  string
-	Type  TokenType
-	Value ASTNode
+	Name     string
+	Type     TokenType
+	TypeName string // Newtype the variable is declared with, or ""
+	Nullable bool   // Declared type? and may hold null
+	Value    ASTNode
 }
 
 func (v *VariableDeclaration) astNode() {}
@@ -52,9 +60,11 @@ func (v *VariableDeclaration) astNode() {}
 // Constants are immutable and their values must be compile-time evaluable
 type ConstantDeclaration struct {
 	BaseNode
-	Name  string
-	Type  TokenType
-	Value ASTNode
+	Name       string
+	Type       TokenType
+	TypeName   string // Newtype the constant is declared with, or ""
+	Value      ASTNode
+	Attributes []Attribute // @section, @align
 }
 
 func (c *ConstantDeclaration) astNode() {}
@@ -76,6 +86,8 @@ type ImportStatement struct {
 	Items      []string // Specific items to import, nil for all
 	Alias      string   // Optional alias name
 	IsWildcard bool     // true if use "module::*"
+	ModuleLoc  Location // Position of the module name string
+	Source     string   // Resolved file for a source module ("" for stdlib)
 }
 
 func (i *ImportStatement) astNode() {}
@@ -117,10 +129,10 @@ type BoolLiteral struct {
 func (b *BoolLiteral) astNode() {}
 
 // FloatLiteral represents a floating-point constant
-// Value is stored as int * 1000 for precision
+// Value holds the literal as a 64-bit IEEE 754 double
 type FloatLiteral struct {
 	BaseNode
-	Value int64
+	Value float64
 }
 
 func (f *FloatLiteral) astNode() {}
@@ -147,8 +159,9 @@ func (id *Identifier) astNode() {}
 // FunctionCall represents a function invocation with arguments
 type FunctionCall struct {
 	BaseNode
-	Name string
-	Args []ASTNode
+	Name    string
+	Args    []ASTNode
+	NameLoc Location // Position of the function name (after '::' when qualified)
 }
 
 func (f *FunctionCall) astNode() {}
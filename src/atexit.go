@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// atexit.go - Exit handler runtime
+// os.atexit registers a function to run when the program exits, whether main
+// returns, the top level ends or os.exit is called from anywhere. Once a
+// program registers one, every exit syscall in the program code is sent
+// through .lotus_rt_exit instead, which calls the handlers, last registered
+// first, and then exits with the status it was given. A handler is removed
+// from the list before it runs, so one that calls os.exit does not run again.
+// os.abort and the children run_capture and daemonize fork exit without them.
+// Under -instrument-functions the profile is written after the handlers run.
+
+// atexitMax is the number of handlers a program can register
+const atexitMax = 32
+
+// atexitRuntimeLabels sends the program's exit through the handlers
+var atexitRuntimeLabels = map[string]string{
+	"exit": ".lotus_rt_exit",
+}
+
+// useAtexit appends the exit handler runtime to the program
+func (cg *CodeGenerator) useAtexit() {
+	cg.atexit = true
+}
+
+// atexitData returns the handler count and list
+func atexitData() string {
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_atexit_count:\n    .quad 0\n")
+	b.WriteString(fmt.Sprintf(".lotus_atexit_handlers:\n    .zero %d\n", 8*atexitMax))
+	return b.String()
+}
+
+// atexitRuntime returns .lotus_rt_exit, which runs the handlers and exits
+// with the status in %rdi. Under -check-memory the program's exits call it
+// from the checking runtime's redirection, and it ends in the leak check.
+func (cg *CodeGenerator) atexitRuntime() string {
+	exitNr, _ := cg.target.Syscall("exit")
+	exit := fmt.Sprintf("movq $%d, %%rax  # syscall: exit\n    syscall", exitNr)
+	if cg.checkMemory {
+		exit = "call .lotus_mem_exit"
+	}
+	profile := ""
+	if cg.profiledFunctions != nil {
+		profile = "\n    call .lotus_prof_dump"
+	}
+	return fmt.Sprintf(`
+# ---- exit handlers ----
+.lotus_rt_exit:
+    pushq %%rdi
+.lotus_rt_exit_next:
+    movq .lotus_atexit_count(%%rip), %%rcx
+    testq %%rcx, %%rcx
+    jz .lotus_rt_exit_done
+    decq %%rcx
+    movq %%rcx, .lotus_atexit_count(%%rip)
+    leaq .lotus_atexit_handlers(%%rip), %%rdx
+    call *(%%rdx,%%rcx,8)
+    jmp .lotus_rt_exit_next
+.lotus_rt_exit_done:%s
+    popq %%rdi
+    %s
+`, profile, exit)
+}
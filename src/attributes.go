@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// attributes.go - Declaration attributes
+// Attributes precede a function or constant declaration (or, for @musttail, a
+// return statement) and adjust how it is emitted:
+//
+//	@section(".boot")
+//	@align(4096)
+//	fn int kentry() { ... }
+//
+// @section places the symbol in the named section so a linker script can
+// position it; @align aligns the symbol to a power-of-two byte boundary.
+// Function-only attributes:
+//
+//	@export(sym)  also define the function as global symbol sym (default: its name)
+//	@naked        emit no prologue or epilogue; the body gets no stack frame
+//	@noinline     never inline calls to the function
+//	@inline       prefer inlining calls to the function
+//	@cold         rarely called: placed in .text.unlikely, and if-branches that
+//	              call it are laid out out of line
+//
+// Statement attributes:
+//
+//	@musttail     the returned self-call must compile to a jump (see tailcall.go)
+
+// Attribute is one @name or @name(args) annotation on a declaration
+type Attribute struct {
+	Name string
+	Args []Token // String or integer literals
+	Loc  Location
+}
+
+// attributeTarget is a set of the constructs an attribute may annotate
+type attributeTarget int
+
+const (
+	onFunction attributeTarget = 1 << iota
+	onConstant
+	onReturn
+)
+
+// describe names the constructs in the set, for error messages
+func (t attributeTarget) describe() string {
+	var kinds []string
+	for _, k := range []struct {
+		target attributeTarget
+		name   string
+	}{{onFunction, "functions"}, {onConstant, "constants"}, {onReturn, "return statements"}} {
+		if t&k.target != 0 {
+			kinds = append(kinds, k.name)
+		}
+	}
+	return strings.Join(kinds, " and ")
+}
+
+// attributeSpec describes the arguments an attribute takes and what it annotates
+type attributeSpec struct {
+	args         []TokenType
+	optionalArgs bool // The arguments may be omitted entirely
+	appliesTo    attributeTarget
+	validate     func(args []Token) string // Returns a problem description, or ""
+}
+
+var attributeSpecs = map[string]attributeSpec{
+	"section": {args: []TokenType{TokenString}, appliesTo: onFunction | onConstant, validate: validateSectionName},
+	"align":   {args: []TokenType{TokenInt}, appliesTo: onFunction | onConstant, validate: validateAlignment},
+
+	"export":   {args: []TokenType{TokenIdentifier}, optionalArgs: true, appliesTo: onFunction, validate: validateSymbolName},
+	"naked":    {appliesTo: onFunction},
+	"noinline": {appliesTo: onFunction},
+	"inline":   {appliesTo: onFunction},
+	"cold":     {appliesTo: onFunction},
+
+	"musttail": {appliesTo: onReturn},
+}
+
+// maxAlignment caps @align; larger values are almost certainly typos
+const maxAlignment = 1 << 21
+
+func validateSectionName(args []Token) string {
+	name := args[0].Value
+	if name == "" {
+		return "section name must not be empty"
+	}
+	if strings.ContainsAny(name, " \t\n\",;#") {
+		return fmt.Sprintf("invalid section name %q", name)
+	}
+	return ""
+}
+
+func validateAlignment(args []Token) string {
+	n, err := strconv.Atoi(args[0].Value)
+	if err != nil || !isPowerOfTwo(n) || n > maxAlignment {
+		return fmt.Sprintf("alignment must be a power of two between 1 and %d, got %s", maxAlignment, args[0].Value)
+	}
+	return ""
+}
+
+func validateSymbolName(args []Token) string {
+	if len(args) > 0 && !isValidIdentifier(args[0].Value) {
+		return fmt.Sprintf("invalid symbol name %q", args[0].Value)
+	}
+	return ""
+}
+
+// attributeNames lists the known attributes, sorted
+func attributeNames() []string {
+	names := make([]string, 0, len(attributeSpecs))
+	for name := range attributeSpecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseAttributedDeclaration parses attributes and the declaration they annotate
+func (p *Parser) parseAttributedDeclaration() (ASTNode, error) {
+	first := p.current()
+	attrs, err := p.parseAttributes()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	var target attributeTarget
+	switch s := stmt.(type) {
+	case *FunctionDefinition:
+		target = onFunction
+		s.Attributes = attrs
+	case *GenericFunction:
+		target = onFunction
+		s.Attributes = attrs
+	case *ConstantDeclaration:
+		target = onConstant
+		s.Attributes = attrs
+	case *ReturnStatement:
+		target = onReturn
+		s.Attributes = attrs
+	default:
+		return nil, attributeError(Location{Line: first.Line, Column: first.Column},
+			"attributes must precede a function, constant, or return statement")
+	}
+
+	for _, attr := range attrs {
+		if applies := attributeSpecs[attr.Name].appliesTo; applies&target == 0 {
+			return nil, attributeError(attr.Loc, fmt.Sprintf("@%s applies only to %s", attr.Name, applies.describe()))
+		}
+	}
+	return stmt, nil
+}
+
+// parseAttributes parses a run of @name(args) annotations, each optionally
+// followed by newlines
+func (p *Parser) parseAttributes() ([]Attribute, error) {
+	var attrs []Attribute
+	seen := make(map[string]bool)
+
+	for p.current().Type == TokenAt {
+		at := p.current()
+		p.advance()
+
+		nameTok := p.current()
+		if nameTok.Type != TokenIdentifier {
+			return nil, p.formatErrorWithCode(ErrInvalidAttribute, "expected attribute name after '@', got "+TokenTypeName(nameTok.Type))
+		}
+		spec, known := attributeSpecs[nameTok.Value]
+		if !known {
+			err := p.newTokenError(ErrInvalidAttribute, fmt.Sprintf("unknown attribute '@%s'", nameTok.Value))
+			if match := suggestName(nameTok.Value, attributeNames()); match != "" {
+				err.WithSuggestion(fmt.Sprintf("did you mean '@%s'?", match)).WithFix(FixIt{
+					Line: nameTok.Line, Column: nameTok.Column,
+					EndLine: nameTok.Line, EndColumn: nameTok.Column + tokenWidth(nameTok),
+					Replacement: match,
+				})
+			}
+			return nil, err
+		}
+		if seen[nameTok.Value] {
+			return nil, p.newTokenError(ErrInvalidAttribute, fmt.Sprintf("duplicate attribute '@%s'", nameTok.Value))
+		}
+		seen[nameTok.Value] = true
+		p.advance()
+
+		attr := Attribute{Name: nameTok.Value, Loc: Location{Line: at.Line, Column: at.Column}}
+		if p.current().Type == TokenLParen {
+			p.advance()
+			for p.current().Type != TokenRParen {
+				tok := p.current()
+				if tok.Type != TokenString && tok.Type != TokenInt && tok.Type != TokenIdentifier {
+					return nil, p.formatErrorWithCode(ErrInvalidAttribute,
+						fmt.Sprintf("attribute arguments must be names or literals, got %s", TokenTypeName(tok.Type)))
+				}
+				attr.Args = append(attr.Args, tok)
+				p.advance()
+				if p.current().Type == TokenComma {
+					p.advance()
+				} else if p.current().Type != TokenRParen {
+					return nil, p.formatError(FormatExpectedToken(TokenRParen, p.current().Type, p.current().Value))
+				}
+			}
+			p.advance() // skip ')'
+		}
+
+		if err := checkAttributeArgs(attr, spec); err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+
+		for p.current().Type == TokenNewline {
+			p.advance()
+		}
+	}
+	return attrs, nil
+}
+
+// checkAttributeArgs verifies an attribute's argument count, types, and values
+func checkAttributeArgs(attr Attribute, spec attributeSpec) error {
+	if len(attr.Args) == 0 && spec.optionalArgs {
+		return nil
+	}
+	if len(attr.Args) != len(spec.args) {
+		return attributeError(attr.Loc, fmt.Sprintf("@%s takes %d argument(s), got %d", attr.Name, len(spec.args), len(attr.Args)))
+	}
+	for i, want := range spec.args {
+		if !attributeArgMatches(want, attr.Args[i].Type) {
+			return attributeError(attr.Loc, fmt.Sprintf("@%s argument %d must be a %s, got %s",
+				attr.Name, i+1, TokenTypeName(want), TokenTypeName(attr.Args[i].Type)))
+		}
+	}
+	if spec.validate != nil {
+		if problem := spec.validate(attr.Args); problem != "" {
+			return attributeError(attr.Loc, fmt.Sprintf("@%s: %s", attr.Name, problem))
+		}
+	}
+	return nil
+}
+
+// attributeArgMatches reports whether an argument token fits the expected type.
+// Where a name is expected, a string spelling the name is accepted too.
+func attributeArgMatches(want, got TokenType) bool {
+	return got == want || (want == TokenIdentifier && got == TokenString)
+}
+
+func attributeError(loc Location, msg string) *ParseError {
+	return NewParseError(ErrInvalidAttribute, msg, loc.Line, loc.Column)
+}
+
+// findAttribute returns the attribute with the given name, if present
+func findAttribute(attrs []Attribute, name string) (Attribute, bool) {
+	for _, attr := range attrs {
+		if attr.Name == name {
+			return attr, true
+		}
+	}
+	return Attribute{}, false
+}
+
+// HasAttribute reports whether the function carries the named attribute
+func (f *FunctionDefinition) HasAttribute(name string) bool {
+	_, ok := findAttribute(f.Attributes, name)
+	return ok
+}
+
+// ExportName returns the global symbol set by @export, or "" if not exported
+func (f *FunctionDefinition) ExportName() string {
+	attr, ok := findAttribute(f.Attributes, "export")
+	if !ok {
+		return ""
+	}
+	if len(attr.Args) == 0 {
+		return f.Name
+	}
+	return attr.Args[0].Value
+}
+
+// checkFunctionAttributes reports attribute combinations that cannot be honored
+func (sa *SemanticAnalyzer) checkFunctionAttributes(fn *FunctionDefinition) {
+	sa.checkSectionAttribute(fn.Attributes)
+	for _, attr := range fn.Attributes {
+		switch attr.Name {
+		case "naked":
+			switch {
+			case fn.Name == "main":
+				sa.attributeError(attr, "main cannot be @naked; its epilogue exits the program")
+			case len(fn.Parameters) > 0:
+				sa.attributeError(attr, fmt.Sprintf("@naked function '%s' cannot take parameters (they live in its stack frame)", fn.Name))
+			default:
+				if decl := firstLocalDeclaration(fn.Body); decl != nil {
+					sa.attributeError(attr, fmt.Sprintf("@naked function '%s' cannot declare local variable '%s'", fn.Name, decl.Name))
+				}
+			}
+		case "inline":
+			if fn.HasAttribute("noinline") {
+				sa.attributeError(attr, fmt.Sprintf("'%s' cannot be both @inline and @noinline", fn.Name))
+			}
+		case "export":
+			sym := fn.ExportName()
+			if sa.options != nil && sym == sa.options.EntrySymbol {
+				sa.attributeError(attr, fmt.Sprintf("@export(%s) conflicts with the program entry symbol", sym))
+			} else if prev, taken := sa.exports[sym]; taken {
+				sa.attributeError(attr, fmt.Sprintf("symbol '%s' is already exported by '%s'", sym, prev))
+			} else {
+				sa.exports[sym] = fn.Name
+			}
+		}
+	}
+}
+
+// checkSectionAttribute reports an @section name that is also the label of a
+// function, which is its name after a dot; as cannot define both
+func (sa *SemanticAnalyzer) checkSectionAttribute(attrs []Attribute) {
+	attr, ok := findAttribute(attrs, "section")
+	if !ok {
+		return
+	}
+	if name, ok := strings.CutPrefix(attr.Args[0].Value, "."); ok && sa.functions[name] {
+		sa.attributeError(attr, fmt.Sprintf("section name %q clashes with the label of function '%s'", attr.Args[0].Value, name))
+	}
+}
+
+func (sa *SemanticAnalyzer) attributeError(attr Attribute, message string) {
+	sa.diagnostics.AddErrorWithCode(string(ErrInvalidAttribute), CategorySemantic, message,
+		sa.filePath, attr.Loc.Line, attr.Loc.Column, sa.getSourceLine(attr.Loc.Line))
+}
+
+// firstLocalDeclaration finds the first variable declared anywhere in body
+func firstLocalDeclaration(body []ASTNode) *VariableDeclaration {
+	for _, node := range body {
+		var nested [][]ASTNode
+		switch n := node.(type) {
+		case *VariableDeclaration:
+			return n
+		case *IfStatement:
+			nested = [][]ASTNode{n.ThenBody, n.ElseBody}
+		case *WhileLoop:
+			nested = [][]ASTNode{n.Body}
+		case *ForLoop:
+			if decl, ok := n.Init.(*VariableDeclaration); ok {
+				return decl
+			}
+			nested = [][]ASTNode{n.Body}
+		}
+		for _, stmts := range nested {
+			if decl := firstLocalDeclaration(stmts); decl != nil {
+				return decl
+			}
+		}
+	}
+	return nil
+}
+
+// Section flags for code and data placed with @section
+const (
+	codeSectionFlags = "ax"
+	dataSectionFlags = "aw"
+)
+
+// placementDirectives returns the directives that move a symbol into its
+// @section (or, for @cold, the cold section) and @align placement, and the
+// directive that switches back.
+// Sections are declared once in buildFinalAssembly, with the combined flags
+// of everything placed in them.
+func (cg *CodeGenerator) placementDirectives(attrs []Attribute, flags string) (open, close string) {
+	name := ""
+	if section, ok := findAttribute(attrs, "section"); ok {
+		name = section.Args[0].Value
+	} else if _, ok := findAttribute(attrs, "cold"); ok {
+		name = coldSection
+	}
+	if name != "" {
+		cg.customSections[name] = mergeSectionFlags(cg.customSections[name], flags)
+		open = fmt.Sprintf("    .pushsection %s\n", name)
+		close = "    .popsection\n"
+	}
+	if align, ok := findAttribute(attrs, "align"); ok {
+		open += fmt.Sprintf("    .balign %s\n", align.Args[0].Value)
+	}
+	return open, close
+}
+
+// mergeSectionFlags combines two ELF section flag strings
+func mergeSectionFlags(a, b string) string {
+	merged := a
+	for _, f := range b {
+		if !strings.ContainsRune(merged, f) {
+			merged += string(f)
+		}
+	}
+	return merged
+}
+
+// sectionDeclarations declares each @section with its flags, in name order
+func (cg *CodeGenerator) sectionDeclarations() string {
+	names := make([]string, 0, len(cg.customSections))
+	for name := range cg.customSections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "    .section %s,\"%s\",@progbits\n", name, cg.customSections[name])
+	}
+	return b.String()
+}
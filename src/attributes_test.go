@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestSectionClashesWithFunctionLabel(t *testing.T) {
+	for _, decl := range []string{
+		"@section(\".boot\")\nfn int boot() {\n    ret 1;\n}\n",
+		"@section(\".boot\")\nconst int MAGIC = 7;\nfn int boot() {\n    ret MAGIC;\n}\n",
+	} {
+		msgs := errorMessages(analyze(t, decl+"fn int main() {\n    ret 0;\n}\n"))
+		want := `section name ".boot" clashes with the label of function 'boot'`
+		if len(msgs) != 1 || msgs[0] != want {
+			t.Errorf("%s: got errors %q, want %q", decl, msgs, want)
+		}
+	}
+
+	source := "@section(\".boot.text\")\nfn int boot() {\n    ret 1;\n}\nfn int main() {\n    ret boot();\n}\n"
+	if msgs := errorMessages(analyze(t, source)); len(msgs) > 0 {
+		t.Errorf("got errors %q", msgs)
+	}
+}
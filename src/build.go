@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// build.go - `lotus build` subcommand
+// Compiles the project described by lotus.toml, with command-line flags
+// overriding manifest values, or a single source file named on the command
+// line with the flags alone.
+
+func init() {
+	Subcommands["build"] = &Subcommand{
+		Name:    "build",
+		Summary: "build the project described by " + ManifestFile + " ([flags] [dir | file.lts])",
+		Run:     runBuild,
+	}
+}
+
+// runBuild implements `lotus build [flags] [dir | file.lts]`
+func runBuild(args []string) int {
+	opts, rest, err := ParseFlags(args)
+	if err != nil {
+		return 2
+	}
+	if len(rest) > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: lotus build [flags] [dir | file.lts]")
+		return 2
+	}
+
+	dir := "."
+	if len(rest) == 1 {
+		dir = rest[0]
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		compiler := NewCompiler(opts)
+		if err := compiler.CompileFile(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Compilation failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	path, err := FindManifest(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	manifest, err := LoadManifest(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := manifest.Apply(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if missing := manifest.MissingDependencies(); len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: dependencies not fetched: %s (run 'lotus get')\n", strings.Join(missing, ", "))
+		return 1
+	}
+
+	if opts.Verbose {
+		log.Printf("Manifest: %s (entry=%s output=%s target=%s O%d)",
+			path, manifest.EntryPath(), opts.OutPath, opts.Target, opts.OptLevel)
+	}
+
+	// The output usually goes in a directory of its own, as in bin/hello
+	if err := os.MkdirAll(filepath.Dir(opts.OutPath), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	compiler := NewCompiler(opts)
+	if err := compiler.CompileFile(manifest.EntryPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "Compilation failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
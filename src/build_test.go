@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildNestedOutput(t *testing.T) {
+	opts, _, err := ParseFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := LookupTarget(opts.Target)
+	if err == nil {
+		err = target.CheckToolchain()
+	}
+	if err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		ManifestFile: "[package]\nname = \"app\"\n\n[build]\noutput = \"bin/app\"\n",
+		"main.lts":   "fn int main() {\n    ret 0;\n}\n",
+	}
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := runBuild([]string{"-q", dir}); status != 0 {
+		t.Fatalf("lotus build exited with status %d", status)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bin", "app")); err != nil {
+		t.Error(err)
+	}
+}
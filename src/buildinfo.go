@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildinfo.go - Build information constants
+// A program can report how it was built through three string constants the
+// compiler declares for it:
+//
+//	BUILD_TIME        when it was compiled, UTC, as 2006-01-02T15:04:05Z
+//	GIT_COMMIT        the commit checked out in the source file's repository
+//	COMPILER_VERSION  the version of the compiler
+//
+// -X NAME=VALUE sets one instead, so a release script can stamp its own
+// commit or a fixed time. BUILD_TIME follows SOURCE_DATE_EPOCH when it is set,
+// for reproducible builds, and GIT_COMMIT is "unknown" outside a repository.
+// The constants are declared only in programs that mention them, and a file
+// declaring a constant of the same name keeps its own.
+
+// buildInfoNames lists the build information constants
+var buildInfoNames = []string{"BUILD_TIME", "GIT_COMMIT", "COMPILER_VERSION"}
+
+// parseBuildInfoFlag checks a -X NAME=VALUE setting
+func parseBuildInfoFlag(setting string) error {
+	name, _, ok := strings.Cut(setting, "=")
+	if !ok {
+		return fmt.Errorf("-X takes NAME=VALUE, got %q", setting)
+	}
+	if !containsString(buildInfoNames, name) {
+		return fmt.Errorf("-X sets one of %s, not %q (use -D to define other constants)",
+			strings.Join(buildInfoNames, ", "), name)
+	}
+	return nil
+}
+
+// buildInfoDeclarations declares the build information constants named in
+// sources (the token streams of the program's files) and not declared in
+// program. Values are looked up only for the constants used.
+func (c *Compiler) buildInfoDeclarations(sources [][]Token, program []ASTNode, inputPath string) []ASTNode {
+	declared := make(map[string]bool)
+	for _, stmt := range program {
+		if decl, ok := stmt.(*ConstantDeclaration); ok {
+			declared[decl.Name] = true
+		}
+	}
+	used := make(map[string]bool)
+	for _, tokens := range sources {
+		for _, tok := range tokens {
+			if tok.Type == TokenIdentifier && containsString(buildInfoNames, tok.Value) && !declared[tok.Value] {
+				used[tok.Value] = true
+			}
+		}
+	}
+
+	overrides := make(map[string]string)
+	for _, setting := range c.Options.BuildInfo {
+		name, value, _ := strings.Cut(setting, "=")
+		overrides[name] = value // The last setting of a name wins
+	}
+	var decls []ASTNode
+	for _, name := range buildInfoNames {
+		if !used[name] {
+			continue
+		}
+		value, ok := overrides[name]
+		if !ok {
+			value = buildInfoValue(name, inputPath)
+		}
+		decls = append(decls, &ConstantDeclaration{Name: name, Type: TokenTypeString, Value: &StringLiteral{Value: value}})
+	}
+	return decls
+}
+
+// buildInfoValue works out the value of a build information constant
+func buildInfoValue(name, inputPath string) string {
+	switch name {
+	case "BUILD_TIME":
+		now := time.Now()
+		if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+			now = time.Unix(epoch, 0)
+		}
+		return now.UTC().Format(time.RFC3339)
+	case "GIT_COMMIT":
+		out, err := exec.Command("git", "-C", filepath.Dir(inputPath), "rev-parse", "HEAD").Output()
+		if commit := strings.TrimSpace(string(out)); err == nil && commit != "" {
+			return commit
+		}
+		return "unknown"
+	case "COMPILER_VERSION":
+		return CompilerVersion
+	}
+	return ""
+}
@@ -137,8 +137,13 @@ func (cg *CodeGenerator) generateClassLiteral(lit *ClassLiteral) {
 	cg.textSection.WriteString("    call malloc@PLT\n")
 	cg.textSection.WriteString("    pushq %rax\n") // Save class instance pointer
 
-	// Initialize each field
-	for fieldName, valueExpr := range lit.Fields {
+	// Initialize each field, in declaration order so output is reproducible
+	declared := make([]string, len(classDef.Fields))
+	for i, field := range classDef.Fields {
+		declared[i] = field.Name
+	}
+	for _, fieldName := range literalFieldOrder(lit.Fields, declared) {
+		valueExpr := lit.Fields[fieldName]
 		// Find field definition
 		var fieldDef *ClassField
 		for i := range classDef.Fields {
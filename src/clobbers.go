@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// clobbers.go - Register clobber check (-check-clobbers)
+// The kernel overwrites %rcx and %r11 on every syscall, and code generators
+// that kept a pointer or a count in one of them across a write have been
+// fixed by hand more than once (see the comments in stdlib.go). This check
+// runs over the finished assembly and follows, through each straight-line
+// run of instructions, which registers each instruction reads and writes. A
+// read of a register a syscall clobbered, before anything has set it again,
+// is reported as a warning naming the stdlib call, function or runtime the
+// code came from.
+//
+// The check is conservative: a label, jump, call or ret ends what it knows,
+// since another path may reach the next instruction with the register set.
+// It is meant for compiler development, so it reports and never fails a build.
+
+// syscallClobbers are the registers a syscall overwrites besides %rax
+var syscallClobbers = []string{"rcx", "r11"}
+
+// syscallArgs are the registers a syscall reads
+var syscallArgs = []string{"rax", "rdi", "rsi", "rdx", "r10", "r8", "r9"}
+
+// registerFamilies maps each general purpose register name to its 64-bit name
+var registerFamilies = func() map[string]string {
+	families := make(map[string]string)
+	for name := range registerWidths {
+		families[name] = name
+	}
+	for _, r := range []string{"ax", "bx", "cx", "dx"} {
+		families["r"+r], families["e"+r], families[r] = "r"+r, "r"+r, "r"+r
+		families[r[:1]+"l"], families[r[:1]+"h"] = "r"+r, "r"+r
+	}
+	for _, r := range []string{"si", "di", "bp", "sp"} {
+		families["r"+r], families["e"+r], families[r], families[r+"l"] = "r"+r, "r"+r, "r"+r, "r"+r
+	}
+	for i := 8; i <= 15; i++ {
+		r := fmt.Sprintf("r%d", i)
+		families[r+"d"], families[r+"w"], families[r+"b"] = r, r, r
+	}
+	return families
+}()
+
+// ClobberReport is a read of a register a syscall overwrote
+type ClobberReport struct {
+	Register string // 64-bit name
+	Where    string // Code the read came from
+	Instr    string // Instruction reading it
+}
+
+// checkClobbers reports the reads of clobbered registers in code, attributing
+// each with where(offset of the reading line)
+func checkClobbers(code string, where func(offset int) string) []ClobberReport {
+	var reports []ClobberReport
+	clobbered := make(map[string]bool)
+	offset := 0
+	for _, line := range strings.Split(code, "\n") {
+		lineOffset := offset
+		offset += len(line) + 1
+		instr := strings.TrimSpace(line)
+		if j := strings.Index(instr, "#"); j >= 0 {
+			instr = strings.TrimSpace(instr[:j])
+		}
+		if instr == "" || strings.HasPrefix(instr, ".") && !strings.HasSuffix(instr, ":") {
+			continue // Blank, comment or directive
+		}
+
+		reads, writes, control := registerEffects(instr)
+		for _, r := range reads {
+			if r != "" && clobbered[r] {
+				reports = append(reports, ClobberReport{Register: r, Where: where(lineOffset), Instr: instr})
+				delete(clobbered, r) // One report per clobber
+			}
+		}
+		for _, r := range writes {
+			delete(clobbered, r)
+		}
+		switch control {
+		case "syscall":
+			for _, r := range syscallClobbers {
+				clobbered[r] = true
+			}
+		case "end":
+			clobbered = make(map[string]bool)
+		}
+	}
+	return reports
+}
+
+// registerEffects returns the registers instr reads and writes, as 64-bit
+// names, and "syscall" or "end" when it is one or ends a straight-line run
+func registerEffects(instr string) (reads, writes []string, control string) {
+	if strings.HasSuffix(instr, ":") {
+		return nil, nil, "end"
+	}
+	mnemonic, rest, _ := strings.Cut(instr, " ")
+	mnemonic = strings.TrimSpace(mnemonic)
+	operands := splitOperands(strings.TrimSpace(rest))
+
+	switch {
+	case mnemonic == "syscall":
+		return syscallArgs, []string{"rax"}, "syscall"
+	case mnemonic == "call", mnemonic == "ret", mnemonic == "jmp", mnemonic == "hlt", mnemonic == "ud2":
+		return nil, nil, "end"
+	case strings.HasPrefix(mnemonic, "j"):
+		return nil, nil, "" // Conditional jump: the fall-through path continues
+	case strings.HasPrefix(mnemonic, "rep"):
+		return []string{"rcx", "rsi", "rdi", "rax"}, []string{"rcx", "rsi", "rdi"}, ""
+	case mnemonic == "cqto", mnemonic == "cqo", mnemonic == "cltd", mnemonic == "cdq":
+		return []string{"rax"}, []string{"rdx"}, ""
+	case mnemonic == "cltq", mnemonic == "cdqe":
+		return []string{"rax"}, []string{"rax"}, ""
+	case len(operands) == 0:
+		return nil, nil, ""
+	}
+
+	// Registers used to form memory addresses are always read
+	for _, op := range operands {
+		if strings.Contains(op, "(") {
+			reads = append(reads, operandRegisters(op[strings.Index(op, "("):])...)
+		}
+	}
+	dest := operands[len(operands)-1]
+	for _, op := range operands[:len(operands)-1] {
+		if !strings.Contains(op, "(") {
+			reads = append(reads, operandRegisters(op)...)
+		}
+	}
+	destReg := ""
+	if !strings.Contains(dest, "(") {
+		if regs := operandRegisters(dest); len(regs) == 1 {
+			destReg = regs[0]
+		}
+	}
+
+	base := baseMnemonic(mnemonic)
+	switch {
+	case base == "mul" || base == "imul" && len(operands) == 1, base == "div", base == "idiv":
+		reads = append(reads, destReg, "rax", "rdx")
+		return reads, []string{"rax", "rdx"}, ""
+	case base == "push" || base == "cmp" || base == "test" || base == "bt":
+		return append(reads, destReg), nil, ""
+	case base == "pop":
+		return reads, []string{destReg}, ""
+	case (base == "xor" || base == "sub") && len(operands) == 2 && operands[0] == operands[1]:
+		return nil, []string{destReg}, "" // Zeroing idiom
+	case strings.HasPrefix(mnemonic, "mov") && !strings.HasPrefix(mnemonic, "movs") || // Not the string op
+		strings.HasPrefix(mnemonic, "movs") && len(operands) == 2, // movslq and friends
+		strings.HasPrefix(mnemonic, "lea"), strings.HasPrefix(mnemonic, "set"),
+		strings.HasPrefix(mnemonic, "cvt"), base == "imul" && len(operands) == 3:
+		return reads, []string{destReg}, ""
+	}
+	// Anything else reads and writes its destination
+	return append(reads, destReg), []string{destReg}, ""
+}
+
+// baseMnemonic strips the size suffix from the mnemonics registerEffects
+// treats specially
+func baseMnemonic(mnemonic string) string {
+	for _, base := range []string{"mul", "imul", "div", "idiv", "push", "pop", "cmp", "test", "bt", "xor", "sub"} {
+		if mnemonic == base || len(mnemonic) == len(base)+1 && strings.HasPrefix(mnemonic, base) &&
+			strings.ContainsRune("bwlq", rune(mnemonic[len(base)])) {
+			return base
+		}
+	}
+	return mnemonic
+}
+
+// splitOperands splits an operand list at the commas outside parentheses
+func splitOperands(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var operands []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				operands = append(operands, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(operands, strings.TrimSpace(s[start:]))
+}
+
+// operandRegisters returns the general purpose registers named in an
+// operand, as 64-bit names
+func operandRegisters(op string) []string {
+	var regs []string
+	for _, part := range strings.Split(op, "%")[1:] {
+		end := strings.IndexFunc(part, func(c rune) bool {
+			return !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9')
+		})
+		if end >= 0 {
+			part = part[:end]
+		}
+		if family, ok := registerFamilies[part]; ok {
+			regs = append(regs, family)
+		}
+	}
+	return regs
+}
+
+// reportClobbers warns about the reads of clobbered registers in code,
+// attributed with where
+func (cg *CodeGenerator) reportClobbers(code string, where func(offset int) string) {
+	for _, r := range checkClobbers(code, where) {
+		cg.diagnostics.AddWarningWithCategory(CategoryGeneral,
+			fmt.Sprintf("check-clobbers: %s reads %%%s after a syscall overwrote it: %s", r.Where, r.Register, r.Instr),
+			cg.diagnostics.FilePath, 0, 0, "")
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"os"
+	"slices"
 	"strings"
 )
 
@@ -26,7 +29,12 @@ func escapeAssemblyString(s string) string {
 		case '\\':
 			result.WriteString("\\\\")
 		default:
-			result.WriteRune(ch)
+			if ch < 0x20 || ch == 0x7f {
+				// Other control characters, such as ESC, as octal escapes
+				result.WriteString(fmt.Sprintf("\\%03o", ch))
+			} else {
+				result.WriteRune(ch)
+			}
 		}
 	}
 	return result.String()
@@ -59,9 +67,67 @@ type CodeGenerator struct {
 	// Diagnostic and error reporting
 	diagnostics *DiagnosticManager
 
+	// Target being compiled for (syscall numbers, freestanding exit)
+	target     *Target
+	entryLabel string // Global symbol of the startup stub
+
+	customSections    map[string]string // @section name -> ELF flags
+	tables            []*LookupTable    // Tables emitted into .rodata, in first-use order
+	initFunctions     []string          // Init blocks called at startup, in order (init.go)
+	includes          []*Include        // Embedded files emitted into .rodata, in first-use order
+	definedLabels     map[string]bool   // Labels defined through an Emitter (emitter.go)
+	deflate           bool              // Append the DEFLATE runtime (compress, http gzip bodies)
+	distance          bool              // Append the edit distance runtime (str.distance, str.similar)
+	glob              bool              // Append the wildcard matching runtime (str.glob, file.glob)
+	entryStack        bool              // Save the startup stack pointer, where argv and envp live
+	shutdown          bool              // Append the shutdown signal handler (os.catch_shutdown)
+	atexit            bool              // Run exit handlers before exiting (os.atexit)
+	asserts           bool              // Append the stderr print helpers for failed asserts
+	release           bool              // Compile asserts out (-release)
+	instrument        bool              // Profile each function (-instrument-functions)
+	profiledFunctions []string          // Profile table records, in order
+	sampleProfile     bool              // Sample the stack on SIGPROF (-profile-sample)
+	sampledFunctions  []sampledFunction // Address ranges the sample handler names
+	rlHistory         bool              // Reserve the rl module's history array pointer
+	metrics           bool              // Reserve the metrics table (metrics module)
+	log               bool              // Append the logging runtime and its settings (log module)
+	msgpack           bool              // Append the MessagePack runtime (msgpack module)
+	pb                bool              // Append the Protocol Buffers runtime (pb module)
+	floats            bool              // Append the float formatting runtime (float.go)
+	optLevel          int               // -O level; tail calls need 1 or more
+	stackProbe        bool              // Probe each page of large frames (-stack-probe)
+	stackFrames       []*StackFrame     // Stack accounting, in generation order
+
+	checkMemory      bool            // Route stdlib allocations through the checking runtime (-check-memory)
+	memcheckSites    []memcheckSite  // Allocation sites the checking runtime reports lines for
+	memStats         bool            // Count allocations for mem.stats without -check-memory
+	traceStdlib      bool            // Log each stdlib call to stderr (-trace-stdlib)
+	checkClobbers    bool            // Warn about reads of syscall-clobbered registers (-check-clobbers)
+	seccomp          bool            // Install a filter allowing only the program's syscalls (-seccomp)
+	libs             []string        // Libraries the program links (-l)
+	missingLibs      map[string]bool // Libraries already reported as not linked
+	syscallOrigins   []syscallOrigin // Code each span of the text section came from
+	builtinLoc       Location        // Name of the stdlib or print call being generated
+	debugLines       bool            // Emit .loc line directives (-g)
+	debugFile        string          // Source file of the code being generated
+	debugFiles       []string        // Files named by .file directives, numbered from 1
+	lastDebugFile    int             // File and line of the last .loc emitted
+	lastDebugLine    int
+	syscallSites     []SyscallSite // Every syscall in the final assembly
+	coverageFile     string        // Counter file mapped at startup (lotus test -cover)
+	coverageCounters int           // Number of coverage counters referenced
+
+	outlineThreshold  int                          // Outline stdlib functions this many instructions long, 0 never (outline.go)
+	inlineOnly        map[string]bool              // Stdlib functions always expanded in place (-inline)
+	outlinedByName    map[string]*outlinedFunction // Outlining decisions by module::function, nil for in place
+	outlinedFunctions []*outlinedFunction          // Emitted after the program, in first-use order
+	stdlibSizes       []*StdlibSize                // Code emitted per stdlib function, for -print-size
+
 	// Function generation context
-	inFunction               bool   // true when generating inside a function body
-	currentFunctionReturnLbl string // label to jump to for function returns
+	inFunction               bool                // true when generating inside a function body
+	currentFunction          *FunctionDefinition // function being generated, if any
+	currentFunctionReturnLbl string              // label to jump to for function returns
+	currentFunctionBodyLbl   string              // label after the prologue, for tail calls
 }
 
 // NewCodeGenerator creates and initializes a new code generator instance.
@@ -77,6 +143,9 @@ func NewCodeGenerator() *CodeGenerator {
 		labelCount:               0,
 		exitCode:                 0,
 		diagnostics:              NewDiagnosticManager(),
+		target:                   Targets[0],
+		entryLabel:               EntryPointLabel,
+		customSections:           make(map[string]string),
 		inFunction:               false,
 		currentFunctionReturnLbl: "",
 	}
@@ -93,25 +162,109 @@ func GenerateAssembly(tokens []Token) (string, error) {
 		return "", fmt.Errorf("parse error: %w", err)
 	}
 
-	// Phase 2: Optimize AST (constant folding, strength reduction, etc.)
-	statements = OptimizeAST(statements)
+	opts := &CompilerOptions{OptLevel: 1, Target: DefaultTarget}
+	return GenerateProgram(statements, NewDiagnosticManager(), opts), nil
+}
+
+// GenerateProgram optimizes a parsed program and lowers it to assembly for
+// opts.Target. Codegen-time problems (such as unknown imports) are reported to
+// diagnostics. An opts.OptLevel of 0 skips the AST and peephole optimizers.
+func GenerateProgram(statements []ASTNode, diagnostics *DiagnosticManager, opts *CompilerOptions) string {
+	optLevel := opts.OptLevel
 
-	// Phase 3: Generate code from optimized AST
+	// Float arithmetic is set apart first, so the integer passes leave it be
+	statements = LowerFloats(statements)
+
+	// Phase 2: Optimize AST (constant folding, strength reduction, etc.),
+	// then loops, repeated expressions and allocations at -O2 and above
+	if optLevel > 0 {
+		statements = OptimizeAST(statements)
+	}
+	var remarks *OptRemarks
+	if opts.PrintOptRemarks {
+		remarks = &OptRemarks{}
+	}
+	if optLevel >= 2 {
+		statements = OptimizeLoops(statements, remarks)
+		statements = EliminateCommonSubexpressions(statements, remarks)
+		if !opts.CheckMemory {
+			statements = StackAllocate(statements, remarks)
+		}
+	}
+	if remarks != nil {
+		PrintOptRemarks(os.Stderr, diagnostics.FilePath, remarks)
+	}
+
+	// Phase 3: Generate code from optimized AST. Labels are numbered and
+	// functions registered afresh, so a second program compiled in the same
+	// process comes out as it would alone.
+	labelCounter = 0
+	clear(UserDefinedFunctions)
 	gen := NewCodeGenerator()
+	gen.diagnostics = diagnostics
+	if target, err := LookupTarget(opts.Target); err == nil {
+		gen.target = target
+	}
+	if opts.EntrySymbol != "" {
+		gen.entryLabel = opts.EntrySymbol
+	}
+	gen.optLevel = optLevel
+	gen.stackProbe = opts.StackProbe
+	gen.checkMemory = opts.CheckMemory
+	gen.traceStdlib = opts.TraceStdlib
+	gen.checkClobbers = opts.CheckClobbers
+	gen.release = opts.Release
+	gen.instrument = opts.InstrumentFunctions
+	gen.sampleProfile = opts.ProfileSample
+	gen.outlineThreshold = opts.OutlineThreshold
+	gen.inlineOnly = make(map[string]bool)
+	for _, name := range opts.InlineFunctions {
+		gen.inlineOnly[name] = true
+	}
+	gen.seccomp = opts.Seccomp
+	gen.libs = opts.Libs
+	gen.debugLines = opts.DebugLines
+	gen.debugFile = diagnostics.FilePath
+	gen.coverageFile = opts.CoverageFile
 	gen.dataSection.WriteString(DataSectionDirective + "\n")
 
+	// Register functions up front so calls may precede definitions
+	for _, stmt := range statements {
+		if fn, ok := stmt.(*FunctionDefinition); ok {
+			UserDefinedFunctions[fn.Name] = fn
+			if fn.Init {
+				gen.initFunctions = append(gen.initFunctions, fn.Name)
+			}
+		}
+	}
+
 	for _, stmt := range statements {
 		gen.generateStatement(stmt)
 	}
+	if opts.PrintStackUsage {
+		PrintStackUsage(os.Stderr, gen.stackFrames)
+	}
 
 	// Phase 4: Apply peephole optimizations to generated assembly
 	assembly := gen.buildFinalAssembly()
-	return ApplyPeepholeOptimizations(assembly), nil
+	if opts.PrintSyscalls {
+		PrintSyscallReport(os.Stderr, gen.syscallSites)
+	}
+	if opts.PrintSize {
+		PrintSizeReport(os.Stderr, gen.stdlibSizes)
+	}
+	if optLevel == 0 {
+		return assembly
+	}
+	return ApplyPeepholeOptimizations(assembly)
 }
 
 // generateStatement dispatches AST nodes to their appropriate code generation methods.
 // This is the main router for statement-level code generation.
 func (cg *CodeGenerator) generateStatement(stmt ASTNode) {
+	if _, isFunc := stmt.(*FunctionDefinition); cg.debugLines && !isFunc {
+		cg.lineDirective(stmt.Loc())
+	}
 	switch s := stmt.(type) {
 	case *ImportStatement:
 		cg.generateImportStatement(s)
@@ -151,6 +304,12 @@ func (cg *CodeGenerator) generateStatement(stmt ASTNode) {
 		cg.generateTryStatement(s)
 	case *ThrowStatement:
 		cg.generateThrowStatement(s)
+	case *CoverageCounter:
+		cg.generateCoverageCounter(s)
+	case *LabelStatement:
+		cg.generateLabelStatement(s)
+	case *GotoStatement:
+		cg.generateGotoStatement(s)
 	}
 }
 
@@ -175,8 +334,9 @@ func (cg *CodeGenerator) generateVariableDeclaration(decl *VariableDeclaration)
 		}
 	case TokenTypeFloat:
 		if lit, ok := decl.Value.(*FloatLiteral); ok {
-			cg.textSection.WriteString(fmt.Sprintf("    # float %s\n", decl.Name))
-			cg.textSection.WriteString(fmt.Sprintf("    movq $%d, -%d(%%rbp)\n", lit.Value, cg.stackOffset))
+			cg.textSection.WriteString(fmt.Sprintf("    # float %s = %g\n", decl.Name, lit.Value))
+			cg.textSection.WriteString(fmt.Sprintf("    movsd %s(%%rip), %%xmm0\n", cg.floatLabel(lit.Value)))
+			cg.textSection.WriteString(fmt.Sprintf("    movsd %%xmm0, -%d(%%rbp)\n", cg.stackOffset))
 			return
 		}
 	case TokenTypeBool:
@@ -204,17 +364,21 @@ func (cg *CodeGenerator) generateVariableDeclaration(decl *VariableDeclaration)
 		}
 	}
 
-	// Fallback: evaluate expression into rax and store
+	// Fallback: evaluate expression into rax and store. The value may reserve
+	// frame space of its own (a stack buffer), so note the slot first.
+	slot := cg.stackOffset
 	cg.textSection.WriteString(fmt.Sprintf("    # %s = <expr>\n", decl.Name))
 	cg.generateExpressionToReg(decl.Value, "rax")
-	cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, -%d(%%rbp)\n", cg.stackOffset))
+	cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, -%d(%%rbp)\n", slot))
 }
 
 // generateImportStatement processes an import/use statement
 // This function loads and registers imported modules and functions
 func (cg *CodeGenerator) generateImportStatement(stmt *ImportStatement) {
 	if err := cg.imports.ProcessImport(stmt); err != nil {
-		cg.diagnostics.AddError(fmt.Sprintf("Import error: %v", err), "", 0, 0, "")
+		loc := stmt.Loc()
+		cg.diagnostics.AddErrorWithCode(string(ErrModuleNotFound), CategorySemantic,
+			fmt.Sprintf("import error: %v", err), cg.diagnostics.FilePath, loc.Line, loc.Column, "")
 		return
 	}
 
@@ -232,7 +396,7 @@ func (cg *CodeGenerator) generateConstantDeclaration(decl *ConstantDeclaration)
 		if lit, ok := decl.Value.(*IntLiteral); ok {
 			// Generate a label for the constant in data section
 			label := fmt.Sprintf(".const_%s", decl.Name)
-			cg.dataSection.WriteString(fmt.Sprintf("%s:\n    .quad %d\n", label, lit.Value))
+			cg.emitConstantData(decl, fmt.Sprintf("%s:\n    .quad %d\n", label, lit.Value))
 
 			// Store constant metadata for later reference
 			cg.constants[decl.Name] = Variable{
@@ -251,7 +415,7 @@ func (cg *CodeGenerator) generateConstantDeclaration(decl *ConstantDeclaration)
 			}
 			// Generate a label for the constant in data section
 			label := fmt.Sprintf(".const_%s", decl.Name)
-			cg.dataSection.WriteString(fmt.Sprintf("%s:\n    .quad %d\n", label, boolVal))
+			cg.emitConstantData(decl, fmt.Sprintf("%s:\n    .quad %d\n", label, boolVal))
 
 			cg.constants[decl.Name] = Variable{
 				Name:   decl.Name,
@@ -261,12 +425,26 @@ func (cg *CodeGenerator) generateConstantDeclaration(decl *ConstantDeclaration)
 
 			cg.textSection.WriteString(fmt.Sprintf("    # const bool %s = %v\n", decl.Name, lit.Value))
 		}
+	case TokenTypeFloat:
+		if lit, ok := decl.Value.(*FloatLiteral); ok {
+			// The double's bits, loaded like any other 64-bit constant
+			label := fmt.Sprintf(".const_%s", decl.Name)
+			cg.emitConstantData(decl, fmt.Sprintf("%s:\n    .quad 0x%x\n", label, math.Float64bits(lit.Value)))
+
+			cg.constants[decl.Name] = Variable{
+				Name:   decl.Name,
+				Type:   decl.Type,
+				Offset: -1,
+			}
+
+			cg.textSection.WriteString(fmt.Sprintf("    # const float %s = %g\n", decl.Name, lit.Value))
+		}
 	case TokenTypeString:
 		if lit, ok := decl.Value.(*StringLiteral); ok {
 			// String constants are already stored as labels
 			label := fmt.Sprintf(".const_%s", decl.Name)
 			escapedStr := escapeAssemblyString(lit.Value)
-			cg.dataSection.WriteString(fmt.Sprintf("%s:\n    .asciz \"%s\"\n", label, escapedStr))
+			cg.emitConstantData(decl, fmt.Sprintf("%s:\n    .asciz \"%s\"\n", label, escapedStr))
 
 			cg.constants[decl.Name] = Variable{
 				Name:   decl.Name,
@@ -280,10 +458,29 @@ func (cg *CodeGenerator) generateConstantDeclaration(decl *ConstantDeclaration)
 	}
 }
 
+// emitConstantData writes a constant's data, honoring @section and @align
+func (cg *CodeGenerator) emitConstantData(decl *ConstantDeclaration, data string) {
+	open, close := cg.placementDirectives(decl.Attributes, dataSectionFlags)
+	cg.dataSection.WriteString(open + data + close)
+}
+
 // generateReturnStatement processes a return statement and sets the exit code.
 // Currently only handles integer literals; more complex expressions will be supported later.
 func (cg *CodeGenerator) generateReturnStatement(ret *ReturnStatement) {
 	if cg.inFunction {
+		musttail, isMusttail := findAttribute(ret.Attributes, "musttail")
+		if isMusttail || cg.optLevel > 0 {
+			problem := cg.tailCallProblem(ret)
+			if problem == "" {
+				cg.generateTailCall(ret.Value.(*FunctionCall))
+				return
+			}
+			if isMusttail {
+				cg.diagnostics.AddErrorWithCode(string(ErrInvalidAttribute), CategorySemantic,
+					"@musttail: "+problem, cg.diagnostics.FilePath, musttail.Loc.Line, musttail.Loc.Column, "")
+			}
+		}
+
 		// Evaluate return expression into RAX if provided
 		if ret.Value != nil {
 			cg.generateExpressionToReg(ret.Value, "rax")
@@ -314,10 +511,20 @@ func (cg *CodeGenerator) generateFunctionCall(call *FunctionCall) {
 		return
 	}
 
+	// likely(x) and unlikely(x) only steer branch layout; the value is x
+	if arg, hint := branchHint(call); hint != 0 {
+		cg.generateExpressionToReg(arg, "rax")
+		return
+	}
+	if isAssert(call) {
+		cg.generateAssert(call)
+		return
+	}
+
 	// Check imported stdlib functions
 	if cg.imports != nil {
 		if fn, ok := cg.imports.ImportedFunctions[call.Name]; ok && fn != nil {
-			fn.CodeGen(cg, call.Args)
+			cg.generateStdlibCall(call, fn)
 			return
 		}
 	}
@@ -328,9 +535,20 @@ func (cg *CodeGenerator) generateFunctionCall(call *FunctionCall) {
 		if len(parts) == 2 {
 			moduleName := parts[0]
 			funcName := parts[1]
+			if cg.imports != nil && cg.imports.SourceModules[moduleName] {
+				// Source module functions live in the program's namespace
+				if cg.generateUserFunctionCall(&FunctionCall{Name: funcName, Args: call.Args}) {
+					return
+				}
+			}
+			if cg.imports != nil {
+				if resolved, ok := cg.imports.ImportedModules[moduleName]; ok {
+					moduleName = resolved // Alias from use "module" as alias
+				}
+			}
 			// Look up the module and function using GetModuleFunction
 			if fn := GetModuleFunction(moduleName, funcName); fn != nil {
-				fn.CodeGen(cg, call.Args)
+				cg.generateStdlibCall(call, fn)
 				return
 			}
 		}
@@ -338,7 +556,7 @@ func (cg *CodeGenerator) generateFunctionCall(call *FunctionCall) {
 
 	// Check if it's a registered print function
 	if printFunc, ok := RegisteredPrintFunctions[call.Name]; ok {
-		printFunc.CodeGen(cg, call.Args)
+		cg.generateBuiltinCall(call, printFunc.CodeGen)
 		return
 	}
 
@@ -346,25 +564,249 @@ func (cg *CodeGenerator) generateFunctionCall(call *FunctionCall) {
 	cg.textSection.WriteString(fmt.Sprintf("    # Unknown function call: %s\n", call.Name))
 }
 
+// generateBuiltinCall emits a stdlib or print function call. Freestanding
+// targets have no OS, so a call whose code needs a syscall is an error.
+func (cg *CodeGenerator) generateBuiltinCall(call *FunctionCall, gen func(*CodeGenerator, []ASTNode)) {
+	start := cg.textSection.Len()
+	cg.builtinLoc = call.NameLoc
+	gen(cg, call.Args)
+	cg.noteSyscallOrigin(start, call.Name, call.NameLoc)
+	cg.checkFreestandingCall(call, cg.textSection.String()[start:])
+}
+
+// checkFreestandingCall reports a call that runs code making system calls
+// in freestanding mode
+func (cg *CodeGenerator) checkFreestandingCall(call *FunctionCall, code string) {
+	if cg.target.Freestanding() && strings.Contains(code, "syscall") {
+		loc := call.NameLoc
+		cg.diagnostics.AddErrorWithCode(string(ErrRequiresOS), CategorySemantic,
+			fmt.Sprintf("'%s' makes system calls and is unavailable in freestanding mode", call.Name),
+			cg.diagnostics.FilePath, loc.Line, loc.Column, "")
+	}
+}
+
+// requireModuleLibs reports a call into a stdlib module whose library the
+// program does not link, once per library
+func (cg *CodeGenerator) requireModuleLibs(call *FunctionCall, fn *StdlibFunction) {
+	for _, lib := range GetModuleLibs(fn.Module) {
+		if slices.Contains(cg.libs, lib) || cg.missingLibs[lib] {
+			continue
+		}
+		if cg.missingLibs == nil {
+			cg.missingLibs = make(map[string]bool)
+		}
+		cg.missingLibs[lib] = true
+		loc := call.NameLoc
+		cg.diagnostics.AddErrorWithCode(string(ErrMissingLibrary), CategorySemantic,
+			fmt.Sprintf("'%s' calls into lib%s; link it with -l %s", call.Name, lib, lib),
+			cg.diagnostics.FilePath, loc.Line, loc.Column, "")
+	}
+}
+
 // buildFinalAssembly constructs the complete assembly program with proper sections and entry point.
 // It combines the data section, text section, and generates the program prologue and epilogue.
 func (cg *CodeGenerator) buildFinalAssembly() string {
+	// Startup code run before the program
+	var startup strings.Builder
+	if cg.entryStack {
+		startup.WriteString(fmt.Sprintf("    movq %%rbp, %s(%%rip)\n\n", EntryStackLabel))
+	}
+	if cg.coverageCounters > 0 {
+		startup.WriteString(cg.coverageSetup())
+		startup.WriteString("\n")
+	}
+	if cg.checkMemory {
+		startup.WriteString(cg.memcheckSetup())
+		startup.WriteString("\n")
+	}
+	if cg.sampleProfile {
+		startup.WriteString(cg.sampleSetup())
+		startup.WriteString("\n")
+	}
+	startup.WriteString(cg.initCalls())
+
+	// Program code (function bodies and statements)
+	var code strings.Builder
+	code.WriteString(cg.textSection.String())
+	code.WriteString("\n")
+
+	// Program epilogue - exit syscall (only when no user-defined main)
+	if _, exists := UserDefinedFunctions["main"]; !exists {
+		code.WriteString("    # Exit program\n")
+		code.WriteString(fmt.Sprintf("    movq $%d, %%rdi  # exit code\n", cg.exitCode))
+		code.WriteString(cg.exitSequence())
+	}
+	code.WriteString(cg.outlinedCode(code.Len()))
+	program := code.String()
+
+	// Runtimes appended after the program
+	memRuntime, memRuntimeName, printRuntime := "", "", ""
+	if cg.checkMemory {
+		memRuntime, memRuntimeName = cg.memcheckRuntime(), "check-memory runtime"
+	} else if cg.memStats {
+		memRuntime, memRuntimeName = cg.memstatRuntime(), "allocation counters"
+	}
+	if cg.checkMemory || cg.traceStdlib || cg.asserts {
+		printRuntime = cg.rtprintRuntime()
+	}
+	deflateRuntime := ""
+	if cg.deflate {
+		deflateRuntime = cg.deflateRuntime()
+	}
+	distanceRuntime := ""
+	if cg.distance {
+		distanceRuntime = cg.distanceRuntime()
+	}
+	globRuntime := ""
+	if cg.glob {
+		globRuntime = cg.globRuntime()
+	}
+	logRuntime := ""
+	if cg.log {
+		logRuntime = cg.logRuntime()
+	}
+	msgpackRuntime := ""
+	if cg.msgpack {
+		msgpackRuntime = cg.msgpackRuntime()
+	}
+	pbRuntime := ""
+	if cg.pb {
+		pbRuntime = cg.pbRuntime()
+	}
+	floatRuntime := ""
+	if cg.floats {
+		floatRuntime = cg.floatRuntime()
+	}
+	shutdownRuntime := ""
+	if cg.shutdown {
+		shutdownRuntime = cg.shutdownRuntime()
+	}
+	atexitRuntime := ""
+	if cg.atexit {
+		atexitRuntime = cg.atexitRuntime()
+	}
+	profileRuntime := ""
+	if cg.profiledFunctions != nil {
+		profileRuntime = cg.profileRuntime()
+	}
+	sampleRuntime := ""
+	if cg.sampleProfile {
+		sampleRuntime = cg.sampleRuntime()
+	}
+
+	cg.syscallSites = cg.auditSyscalls(startup.String(), "startup")
+	cg.syscallSites = append(cg.syscallSites, cg.auditProgramSyscalls(program)...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(memRuntime, memRuntimeName)...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(printRuntime, "stderr print helpers")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(deflateRuntime, "DEFLATE runtime")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(distanceRuntime, "edit distance runtime")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(globRuntime, "wildcard matching runtime")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(logRuntime, "logging runtime")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(msgpackRuntime, "MessagePack runtime")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(pbRuntime, "Protocol Buffers runtime")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(floatRuntime, "float formatting runtime")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(shutdownRuntime, "shutdown handler")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(atexitRuntime, "exit handlers")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(profileRuntime, "function profiling")...)
+	cg.syscallSites = append(cg.syscallSites, cg.auditSyscalls(sampleRuntime, "sampling profiler")...)
+	if cg.checkClobbers {
+		cg.reportClobbers(startup.String(), func(int) string { return "startup" })
+		cg.reportClobbers(program, cg.syscallOriginAt)
+		cg.reportClobbers(memRuntime, func(int) string { return memRuntimeName })
+		cg.reportClobbers(printRuntime, func(int) string { return "stderr print helpers" })
+		cg.reportClobbers(deflateRuntime, func(int) string { return "DEFLATE runtime" })
+		cg.reportClobbers(distanceRuntime, func(int) string { return "edit distance runtime" })
+		cg.reportClobbers(globRuntime, func(int) string { return "wildcard matching runtime" })
+		cg.reportClobbers(logRuntime, func(int) string { return "logging runtime" })
+		cg.reportClobbers(msgpackRuntime, func(int) string { return "MessagePack runtime" })
+		cg.reportClobbers(pbRuntime, func(int) string { return "Protocol Buffers runtime" })
+		cg.reportClobbers(floatRuntime, func(int) string { return "float formatting runtime" })
+		cg.reportClobbers(shutdownRuntime, func(int) string { return "shutdown handler" })
+		cg.reportClobbers(atexitRuntime, func(int) string { return "exit handlers" })
+		cg.reportClobbers(profileRuntime, func(int) string { return "function profiling" })
+		cg.reportClobbers(sampleRuntime, func(int) string { return "sampling profiler" })
+	}
+
+	if cg.checkMemory {
+		program = cg.redirectMemorySyscalls(program)
+	} else if cg.memStats {
+		program = cg.redirectSyscalls(program, memstatRuntimeLabels, "counted", nil)
+	}
+	if cg.atexit && !cg.checkMemory {
+		program = cg.redirectSyscalls(program, atexitRuntimeLabels, "handlers, then", nil)
+	}
+
 	var b strings.Builder
 
+	// Sections named by @section, declared before anything is placed in them
+	b.WriteString(cg.sectionDeclarations())
+	b.WriteString(cg.debugFileDirectives())
+
 	// Data section with constants and strings
 	b.WriteString(cg.dataSection.String())
+	if cg.entryStack {
+		b.WriteString(fmt.Sprintf("%s:\n    .quad 0\n", EntryStackLabel))
+	}
+	if cg.coverageCounters > 0 {
+		b.WriteString(cg.coverageData())
+	}
+	if cg.checkMemory {
+		b.WriteString(cg.memcheckData())
+	} else if cg.memStats {
+		b.WriteString(memstatData())
+	}
+	if cg.checkMemory || cg.traceStdlib || cg.asserts {
+		b.WriteString(rtprintData())
+	}
+	if cg.deflate {
+		b.WriteString(deflateData())
+	}
+	if cg.shutdown {
+		b.WriteString(shutdownData())
+	}
+	if cg.atexit {
+		b.WriteString(atexitData())
+	}
+	if cg.profiledFunctions != nil {
+		b.WriteString(cg.profileData())
+	}
+	if cg.sampleProfile {
+		b.WriteString(cg.sampleData())
+	}
+	if cg.rlHistory {
+		b.WriteString(fmt.Sprintf("%s:\n    .quad 0\n", rlHistoryLabel))
+	}
+	if cg.metrics {
+		b.WriteString(metricsData())
+	}
+	if cg.log {
+		b.WriteString(logData())
+	}
+	if cg.msgpack {
+		b.WriteString(msgpackData())
+	}
+	if cg.seccomp {
+		b.WriteString(cg.seccompFilter(cg.syscallSites))
+	}
+	b.WriteString(cg.tableData())
+	b.WriteString(cg.includeData())
 	b.WriteString("\n")
 
 	// Text section with code
-	b.WriteString(fmt.Sprintf("%s %s\n", GlobalDirective, EntryPointLabel))
+	b.WriteString(fmt.Sprintf("%s %s\n", GlobalDirective, cg.entryLabel))
 	b.WriteString(TextSectionDirective + "\n")
-	b.WriteString(EntryPointLabel + ":\n")
+	b.WriteString(cg.entryLabel + ":\n")
 
 	// Program prologue
 	b.WriteString("    # Program start\n")
 	b.WriteString("    movq %rsp, %rbp\n") // Set up base pointer
 	b.WriteString("    subq $256, %rsp\n") // Allocate stack space (256 bytes for locals)
 	b.WriteString("\n")
+	if cg.seccomp {
+		b.WriteString(cg.seccompSetup())
+		b.WriteString("\n")
+	}
+	b.WriteString(startup.String())
 
 	// Call user-defined main if present
 	if _, exists := UserDefinedFunctions["main"]; exists {
@@ -373,17 +815,29 @@ func (cg *CodeGenerator) buildFinalAssembly() string {
 		b.WriteString("\n")
 	}
 
-	// Program code (function bodies and statements)
-	b.WriteString(cg.textSection.String())
-	b.WriteString("\n")
+	b.WriteString(program)
+	b.WriteString(memRuntime)
+	b.WriteString(printRuntime)
+	b.WriteString(deflateRuntime)
+	b.WriteString(distanceRuntime)
+	b.WriteString(globRuntime)
+	b.WriteString(logRuntime)
+	b.WriteString(msgpackRuntime)
+	b.WriteString(pbRuntime)
+	b.WriteString(floatRuntime)
+	b.WriteString(shutdownRuntime)
+	b.WriteString(atexitRuntime)
+	b.WriteString(profileRuntime)
+	b.WriteString(sampleRuntime)
+	return b.String()
+}
 
-	// Program epilogue - exit syscall (only when no user-defined main)
-	if _, exists := UserDefinedFunctions["main"]; !exists {
-		b.WriteString("    # Exit program\n")
-		b.WriteString(fmt.Sprintf("    movq $%d, %%rax  # syscall: exit\n", SyscallExit))
-		b.WriteString(fmt.Sprintf("    movq $%d, %%rdi  # exit code\n", cg.exitCode))
-		b.WriteString("    syscall\n")
+// exitSequence returns the instructions that end the program with the status
+// in %rdi: the exit syscall on an OS target, or a halt loop when freestanding
+func (cg *CodeGenerator) exitSequence() string {
+	if nr, ok := cg.target.Syscall("exit"); ok {
+		return fmt.Sprintf("    movq $%d, %%rax  # syscall: exit\n    syscall\n", nr)
 	}
-
-	return b.String()
+	halt := cg.getLabel("halt")
+	return fmt.Sprintf("    # No OS to return to: halt\n%s:\n    hlt\n    jmp %s\n", halt, halt)
 }
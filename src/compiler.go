@@ -1,14 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 // compiler.go - High-level compilation pipeline orchestration
@@ -16,8 +19,10 @@ import (
 
 // Compiler encapsulates the complete compilation pipeline
 type Compiler struct {
-	Options *CompilerOptions  // Configuration and command-line options
-	Stats   *CompilationStats // Compilation statistics
+	Options  *CompilerOptions  // Configuration and command-line options
+	Stats    *CompilationStats // Compilation statistics
+	Coverage *CoverageMap      // Instrumented blocks, when Options.CoverageFile is set
+	Modules  []*SourceModule   // Source modules the program loaded
 }
 
 // NewCompiler creates a new compiler instance with the given options
@@ -63,13 +68,36 @@ func (c *Compiler) CompileFile(inputPath string) error {
 		return nil
 	}
 
-	// Phase 3: Syntax analysis and code generation
+	target, err := LookupTarget(c.Options.Target)
+	if err != nil {
+		return err
+	}
+	if !target.Codegen {
+		return fmt.Errorf("target %s: code generation for %s is not implemented yet (Lotus emits x86_64 assembly only)",
+			target.Triple, target.Arch)
+	}
+
+	// Phase 3: Syntax analysis, semantic checks, and code generation
 	codegenStart := time.Now()
-	asm, err := GenerateAssembly(tokens)
+	diagnostics := c.newDiagnosticManager(inputPath, string(contents))
+	asm, ok := c.generate(tokens, diagnostics, inputPath, string(contents))
 	codegenDuration := time.Since(codegenStart)
-	if err != nil {
+	if ok && target.Freestanding() && !diagnostics.HasErrors() && strings.Contains(asm, "syscall") {
+		diagnostics.AddErrorWithCode(string(ErrRequiresOS), CategoryGeneral,
+			"generated code contains system calls, which are unavailable in freestanding mode", diagnostics.FilePath, 0, 0, "")
+	}
+	if err := c.reportDiagnostics(diagnostics); err != nil {
 		return err
 	}
+	if !ok || diagnostics.HasErrors() {
+		return fmt.Errorf("%d error(s)", diagnostics.ErrorCount)
+	}
+	if c.Options.SymbolIndexDir != "" {
+		if err := c.writeSymbolIndexes(inputPath, contents); err != nil {
+			return err
+		}
+	}
+	asm += metadataSection(c.buildMetadata(target, inputPath, contents))
 	asmLines := strings.Count(asm, "\n")
 	c.Stats.RecordCodegen(codegenDuration, asmLines, len(asm), 0, 0)
 
@@ -80,13 +108,27 @@ func (c *Compiler) CompileFile(inputPath string) error {
 		return err
 	}
 
+	// Handle object output mode (-emit=obj)
+	if c.Options.Emit == "obj" {
+		err := c.writeObject(asm, inputPath)
+		c.printStats()
+		return err
+	}
+
 	// Phase 4: Assemble and link to binary
-	if err := c.buildBinary(asm); err != nil {
+	build := c.buildBinary
+	if c.Options.Linker == "builtin" {
+		build = c.linkBinary
+	}
+	if err := build(asm, inputPath, target); err != nil {
 		return err
 	}
 
 	// Phase 5: Optionally run the compiled binary (--run flag)
 	if c.Options.RunAfterBuild {
+		if !target.CanRunOnHost() {
+			return fmt.Errorf("cannot run a %s binary on this host; built %s", target.Triple, c.Options.OutPath)
+		}
 		c.printStats()
 		return c.runBinary()
 	}
@@ -95,6 +137,172 @@ func (c *Compiler) CompileFile(inputPath string) error {
 	return nil
 }
 
+// newDiagnosticManager configures a diagnostic manager from the compiler options
+func (c *Compiler) newDiagnosticManager(inputPath, source string) *DiagnosticManager {
+	dm := NewDiagnosticManager()
+	dm.MaxErrors = c.Options.MaxErrors
+	dm.TreatWarnErr = c.Options.Werror
+	dm.SuppressWarns = c.Options.NoWarn
+	dm.UseColor = c.Options.ColorOutput && !c.Options.NoColor
+	dm.FilePath = c.displayPath(inputPath)
+	dm.SetSourceLines(dm.FilePath, source)
+	return dm
+}
+
+// displayPath returns the path used in diagnostics, honoring -trimpath
+func (c *Compiler) displayPath(path string) string {
+	if c.Options.Trimpath == "" {
+		return path
+	}
+	trimmed := strings.TrimPrefix(path, c.Options.Trimpath)
+	return strings.TrimPrefix(trimmed, string(filepath.Separator))
+}
+
+// generate parses, analyzes, and lowers the token stream. Problems are
+// recorded in diagnostics; ok is false when code generation was skipped.
+func (c *Compiler) generate(tokens []Token, diagnostics *DiagnosticManager, inputPath, source string) (string, bool) {
+	parser := NewParser(tokens)
+	statements, err := parser.Parse()
+	if err != nil {
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			diagnostics.AddDiagnostic(perr.Diagnostic(diagnostics.FilePath))
+		} else {
+			diagnostics.AddErrorWithCode("", CategorySyntax, err.Error(), diagnostics.FilePath, 0, 0, "")
+		}
+		return "", false
+	}
+
+	loader := NewModuleLoader(c.Options.IncludeDirs, diagnostics, c.displayPath)
+	loader.Load(statements, inputPath)
+	if diagnostics.HasErrors() {
+		return "", false
+	}
+	moduleDecls := loader.Declarations()
+	c.Modules = loader.Modules()
+
+	defines, err := defineDeclarations(c.Options.Defines)
+	if err != nil {
+		diagnostics.AddErrorWithCode("", CategoryGeneral, err.Error(), "", 0, 0, "")
+		return "", false
+	}
+	statements = append(defines, statements...)
+	sources := [][]Token{tokens}
+	for _, mod := range c.Modules {
+		sources = append(sources, Tokenize(mod.Source))
+	}
+	statements = append(c.buildInfoDeclarations(sources, append(moduleDecls, statements...), inputPath), statements...)
+
+	// Init blocks and overloads get names of their own before anything looks
+	// functions up
+	nameInitBlocks(append(moduleDecls, statements...))
+	statements = append(statements, ResolveOverloads(append(moduleDecls, statements...), diagnostics)...)
+	if diagnostics.HasErrors() {
+		return "", false
+	}
+
+	// Each file is analyzed on its own so diagnostics point at the right source
+	for _, mod := range loader.Modules() {
+		sa := NewSemanticAnalyzer(diagnostics, c.Options, c.displayPath(mod.Path), mod.Source)
+		sa.sourceDir = filepath.Dir(mod.Path)
+		sa.DeclareFunctions(moduleDecls)
+		sa.Analyze(mod.Statements)
+	}
+	sa := NewSemanticAnalyzer(diagnostics, c.Options, diagnostics.FilePath, source)
+	sa.sourceDir = filepath.Dir(inputPath)
+	sa.DeclareFunctions(moduleDecls)
+	sa.Analyze(statements)
+	if diagnostics.HasErrors() {
+		return "", false
+	}
+
+	// comptime blocks become literals before anything else sees the program
+	EvaluateComptime(append(moduleDecls, statements...), diagnostics)
+	if diagnostics.HasErrors() {
+		return "", false
+	}
+
+	if c.Options.CoverageFile != "" {
+		c.Coverage = &CoverageMap{}
+		for _, mod := range loader.Modules() {
+			c.Coverage.Instrument(mod.Statements, mod.Path, false)
+		}
+		// Test programs measure other files, not themselves
+		if !strings.HasSuffix(inputPath, TestFileSuffix) {
+			c.Coverage.Instrument(statements, inputPath, true)
+		}
+	}
+
+	program := append(moduleDecls, statements...)
+	return GenerateProgram(program, diagnostics, c.Options), true
+}
+
+// defineDeclarations turns -D NAME[=VALUE] entries into constant declarations.
+// Values default to 1; integers, true/false, and anything else (as a string)
+// are accepted. A later definition of the same name replaces an earlier one.
+func defineDeclarations(defines []string) ([]ASTNode, error) {
+	decls := make([]ASTNode, 0, len(defines))
+	index := make(map[string]int)
+	for _, def := range defines {
+		name, value, hasValue := strings.Cut(def, "=")
+		if !isValidIdentifier(name) {
+			return nil, fmt.Errorf("invalid define %q: %q is not an identifier", def, name)
+		}
+		if !hasValue {
+			value = "1"
+		}
+
+		decl := &ConstantDeclaration{Name: name}
+		if n, err := strconv.Atoi(value); err == nil {
+			decl.Type, decl.Value = TokenTypeInt, &IntLiteral{Value: n}
+		} else if value == "true" || value == "false" {
+			decl.Type, decl.Value = TokenTypeBool, &BoolLiteral{Value: value == "true"}
+		} else {
+			decl.Type, decl.Value = TokenTypeString, &StringLiteral{Value: value}
+		}
+
+		if i, seen := index[name]; seen {
+			decls[i] = decl
+			continue
+		}
+		index[name] = len(decls)
+		decls = append(decls, decl)
+	}
+	return decls, nil
+}
+
+// isValidIdentifier reports whether s is a Lotus identifier
+func isValidIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// reportDiagnostics emits collected diagnostics as text on stderr or, with
+// -json-diagnostics, as a JSON document on stdout (or -diagnostics-out)
+func (c *Compiler) reportDiagnostics(dm *DiagnosticManager) error {
+	if !c.Options.JSONDiagnostics {
+		dm.Print()
+		return nil
+	}
+
+	if c.Options.DiagnosticsOut == "" {
+		return dm.PrintJSON(os.Stdout)
+	}
+	f, err := os.Create(c.Options.DiagnosticsOut)
+	if err != nil {
+		return fmt.Errorf("failed to create diagnostics file: %w", err)
+	}
+	defer f.Close()
+	return dm.PrintJSON(f)
+}
+
 // printStats outputs timing and statistics if enabled
 func (c *Compiler) printStats() {
 	c.Stats.Finalize()
@@ -168,18 +376,80 @@ func (c *Compiler) writeAssembly(asm string) error {
 	return nil
 }
 
+// writeObject assembles the program with the built-in assembler and writes a
+// relocatable object, naming the file as writeAssembly does with .o
+func (c *Compiler) writeObject(asm, inputPath string) error {
+	objOut := c.Options.OutPath
+	if objOut == "a.out" {
+		objOut = "a.o"
+	} else if filepath.Ext(objOut) == "" {
+		objOut = objOut + ".o"
+	}
+
+	assembleStart := time.Now()
+	obj, err := Assemble(sourceFileDirective(inputPath) + asm)
+	c.Stats.RecordAssemble(time.Since(assembleStart))
+	if err != nil {
+		return fmt.Errorf("assembly failed: %w", err)
+	}
+	if err := WriteObjectFile(obj, objOut); err != nil {
+		return fmt.Errorf("failed to write object file: %w", err)
+	}
+
+	if info, statErr := os.Stat(objOut); statErr == nil {
+		c.Stats.RecordLink(0, objOut, int(info.Size()))
+	}
+	if c.Options.Verbose {
+		log.Printf("Object written to: %s", objOut)
+	}
+	return nil
+}
+
+// sourceFileDirective names the source file in the object's symbol table
+func sourceFileDirective(inputPath string) string {
+	return fmt.Sprintf("    .file \"%s\"\n", escapeAssemblyString(filepath.Base(inputPath)))
+}
+
 // buildBinary assembles and links the assembly to produce an executable binary
-func (c *Compiler) buildBinary(asm string) error {
-	// Write assembly to temporary file
-	tmpAsm := filepath.Join(os.TempDir(), "lotus_tmp.s")
+func (c *Compiler) buildBinary(asm, inputPath string, target *Target) error {
+	if err := target.CheckToolchain(); err != nil {
+		return err
+	}
+
+	// Write assembly to a private temporary directory, so concurrent builds
+	// cannot overwrite each other's file
+	tmpDir, err := os.MkdirTemp("", "lotus-build")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir) // Clean up temp files
+	tmpAsm := filepath.Join(tmpDir, "lotus_tmp.s")
+
+	// Without a .file directive the assembler names the object after the
+	// driver's randomly named temporary file in the symbol table, and no two
+	// builds of the same program would be byte-identical
+	asm = sourceFileDirective(inputPath) + asm
 	if err := os.WriteFile(tmpAsm, []byte(asm), 0644); err != nil {
 		return fmt.Errorf("failed to write temporary assembly: %w", err)
 	}
-	defer os.Remove(tmpAsm) // Clean up temp file
 
-	// Invoke GCC to assemble and link
+	// Invoke the target's compiler driver to assemble and link
 	assembleStart := time.Now()
-	cmd := exec.Command("gcc", "-nostartfiles", "-no-pie", "-o", c.Options.OutPath, tmpAsm)
+	args := append([]string(nil), target.LinkFlags...)
+	if c.Options.Sysroot != "" {
+		args = append(args, "--sysroot="+c.Options.Sysroot)
+	}
+	if c.Options.LinkerScript != "" {
+		args = append(args, "-T", c.Options.LinkerScript)
+	}
+	if c.Options.EntrySymbol != "" && c.Options.EntrySymbol != EntryPointLabel {
+		args = append(args, "-Wl,-e,"+c.Options.EntrySymbol)
+	}
+	args = append(args, "-o", c.Options.OutPath, tmpAsm)
+	for _, lib := range c.Options.Libs {
+		args = append(args, "-l"+lib)
+	}
+	cmd := exec.Command(target.Driver, args...)
 
 	if c.Options.Verbose {
 		log.Printf("Assembling: %s", strings.Join(cmd.Args, " "))
@@ -213,6 +483,32 @@ func (c *Compiler) buildBinary(asm string) error {
 	return nil
 }
 
+// linkBinary assembles the program with the built-in assembler and links it
+// with the built-in linker, without running the target's toolchain
+func (c *Compiler) linkBinary(asm, inputPath string, target *Target) error {
+	assembleStart := time.Now()
+	obj, err := Assemble(sourceFileDirective(inputPath) + asm)
+	c.Stats.RecordAssemble(time.Since(assembleStart))
+	if err != nil {
+		return fmt.Errorf("assembly failed: %w", err)
+	}
+
+	linkStart := time.Now()
+	if err := LinkExecutable(obj, c.Options.EntrySymbol, c.Options.OutPath); err != nil {
+		return fmt.Errorf("link failed: %w", err)
+	}
+	size := 0
+	if info, statErr := os.Stat(c.Options.OutPath); statErr == nil {
+		size = int(info.Size())
+	}
+	c.Stats.RecordLink(time.Since(linkStart), c.Options.OutPath, size)
+
+	if c.Options.Verbose {
+		log.Printf("Binary written to: %s (built-in linker)", c.Options.OutPath)
+	}
+	return nil
+}
+
 // runBinary executes the compiled binary and streams its output
 func (c *Compiler) runBinary() error {
 	if c.Options.Verbose {
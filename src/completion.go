@@ -0,0 +1,338 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completion.go - Shell completion script generation
+// Emits bash, zsh, and fish completion scripts built from the live flag set,
+// the subcommand registry, and the documentation section list, so completions
+// never drift from what ParseFlags actually accepts.
+
+// completionShells lists the shells `lotus completion` can target
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// Value hints for flags that take an argument; flags not listed complete nothing
+const (
+	completeFile = "file"
+	completeDir  = "dir"
+	completeDocs = "docs"
+)
+
+var completionValueHints = map[string]string{
+	"o":               completeFile,
+	"I":               completeDir,
+	"trimpath":        completeDir,
+	"docs-section":    completeDocs,
+	"diagnostics-out": completeFile,
+	"sysroot":         completeDir,
+	"T":               completeFile,
+}
+
+// completionFlag is the subset of flag metadata the script generators need
+type completionFlag struct {
+	Name      string // Flag name without dashes
+	Usage     string // Help text from the FlagSet
+	TakesArg  bool   // false for boolean flags
+	ValueHint string // completeFile, completeDir, completeDocs, or ""
+	Long      bool   // true for GNU-style "--name" aliases
+}
+
+func init() {
+	Subcommands["completion"] = &Subcommand{
+		Name:    "completion",
+		Summary: "print a shell completion script (bash, zsh, fish)",
+		Args:    completionShells,
+		Run:     runCompletion,
+	}
+}
+
+// runCompletion implements `lotus completion <shell>`
+func runCompletion(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: lotus completion <%s>\n", strings.Join(completionShells, "|"))
+		return 2
+	}
+	if err := WriteCompletion(os.Stdout, args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// WriteCompletion writes the completion script for shell to w
+func WriteCompletion(w io.Writer, shell string) error {
+	flags := collectCompletionFlags()
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, flags)
+	case "zsh":
+		writeZshCompletion(w, flags)
+	case "fish":
+		writeFishCompletion(w, flags)
+	default:
+		return fmt.Errorf("unsupported shell %q (expected one of: %s)", shell, strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+// collectCompletionFlags enumerates every registered flag plus its long aliases
+func collectCompletionFlags() []completionFlag {
+	fs := newFlagSet(&CompilerOptions{})
+	var flags []completionFlag
+	byName := make(map[string]completionFlag)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		cf := completionFlag{
+			Name:      f.Name,
+			Usage:     strings.ReplaceAll(f.Usage, "`", ""),
+			TakesArg:  !isBoolFlag(f),
+			ValueHint: completionValueHints[f.Name],
+		}
+		flags = append(flags, cf)
+		byName[f.Name] = cf
+	})
+
+	aliases := make([]string, 0, len(longFlagAliases))
+	for long := range longFlagAliases {
+		aliases = append(aliases, long)
+	}
+	sort.Strings(aliases)
+	for _, long := range aliases {
+		target, ok := byName[strings.TrimPrefix(longFlagAliases[long], "-")]
+		if !ok {
+			continue
+		}
+		target.Name = strings.TrimPrefix(long, "--")
+		target.Long = true
+		flags = append(flags, target)
+	}
+
+	return flags
+}
+
+// isBoolFlag reports whether a flag is a boolean switch (takes no value)
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// subcommandsWithoutArgs returns subcommands whose arguments complete as file names
+func subcommandsWithoutArgs() []string {
+	var names []string
+	for _, name := range SubcommandNames() {
+		if len(Subcommands[name].Args) == 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// flagSpelling returns the flag as typed on the command line
+func (cf completionFlag) flagSpelling() string {
+	if cf.Long {
+		return "--" + cf.Name
+	}
+	return "-" + cf.Name
+}
+
+func writeBashCompletion(w io.Writer, flags []completionFlag) {
+	var all, fileFlags, dirFlags, docsFlags, argFlags []string
+	for _, f := range flags {
+		all = append(all, f.flagSpelling())
+		if !f.TakesArg {
+			continue
+		}
+		switch f.ValueHint {
+		case completeFile:
+			fileFlags = append(fileFlags, f.flagSpelling())
+		case completeDir:
+			dirFlags = append(dirFlags, f.flagSpelling())
+		case completeDocs:
+			docsFlags = append(docsFlags, f.flagSpelling())
+		default:
+			argFlags = append(argFlags, f.flagSpelling())
+		}
+	}
+
+	fmt.Fprintln(w, "# bash completion for lotus")
+	fmt.Fprintln(w, "# Install: lotus completion bash > /etc/bash_completion.d/lotus")
+	fmt.Fprintln(w, "_lotus() {")
+	fmt.Fprintln(w, "    local cur prev")
+	fmt.Fprintln(w, "    cur=\"${COMP_WORDS[COMP_CWORD]}\"")
+	fmt.Fprintln(w, "    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"")
+	fmt.Fprintln(w)
+
+	// Subcommand arguments
+	fmt.Fprintln(w, "    if [[ $COMP_CWORD -ge 2 ]]; then")
+	fmt.Fprintln(w, "        case \"${COMP_WORDS[1]}\" in")
+	for _, name := range SubcommandNames() {
+		sub := Subcommands[name]
+		if len(sub.Args) > 0 {
+			fmt.Fprintf(w, "            %s)\n", name)
+			fmt.Fprintf(w, "                COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(sub.Args, " "))
+			fmt.Fprintln(w, "                return ;;")
+		}
+	}
+	if open := subcommandsWithoutArgs(); len(open) > 0 {
+		fmt.Fprintf(w, "            %s)\n", strings.Join(open, "|"))
+		fmt.Fprintln(w, "                COMPREPLY=( $(compgen -f -- \"$cur\") )")
+		fmt.Fprintln(w, "                return ;;")
+	}
+	fmt.Fprintln(w, "        esac")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w)
+
+	// Flag values
+	fmt.Fprintln(w, "    case \"$prev\" in")
+	if len(docsFlags) > 0 {
+		fmt.Fprintf(w, "        %s)\n", strings.Join(docsFlags, "|"))
+		fmt.Fprintf(w, "            COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(AvailableSections, " "))
+		fmt.Fprintln(w, "            return ;;")
+	}
+	if len(fileFlags) > 0 {
+		fmt.Fprintf(w, "        %s)\n", strings.Join(fileFlags, "|"))
+		fmt.Fprintln(w, "            COMPREPLY=( $(compgen -f -- \"$cur\") )")
+		fmt.Fprintln(w, "            return ;;")
+	}
+	if len(dirFlags) > 0 {
+		fmt.Fprintf(w, "        %s)\n", strings.Join(dirFlags, "|"))
+		fmt.Fprintln(w, "            COMPREPLY=( $(compgen -d -- \"$cur\") )")
+		fmt.Fprintln(w, "            return ;;")
+	}
+	if len(argFlags) > 0 {
+		fmt.Fprintf(w, "        %s)\n", strings.Join(argFlags, "|"))
+		fmt.Fprintln(w, "            return ;;")
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w)
+
+	// Flags, subcommands, and source files
+	fmt.Fprintln(w, "    if [[ \"$cur\" == -* ]]; then")
+	fmt.Fprintf(w, "        COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(all, " "))
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "    if [[ $COMP_CWORD -eq 1 ]]; then")
+	fmt.Fprintf(w, "        COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(SubcommandNames(), " "))
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "    COMPREPLY+=( $(compgen -f -X '!*.lts' -- \"$cur\") $(compgen -d -- \"$cur\") )")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -F _lotus lotus")
+}
+
+// zshEscape escapes help text for use inside an _arguments '[...]' description
+func zshEscape(s string) string {
+	r := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+	return r.Replace(s)
+}
+
+func writeZshCompletion(w io.Writer, flags []completionFlag) {
+	fmt.Fprintln(w, "#compdef lotus")
+	fmt.Fprintln(w, "# zsh completion for lotus")
+	fmt.Fprintln(w, "# Install: lotus completion zsh > \"${fpath[1]}/_lotus\"")
+	fmt.Fprintln(w, "_lotus() {")
+	fmt.Fprintln(w, "    local -a commands")
+	fmt.Fprintln(w, "    commands=(")
+	for _, name := range SubcommandNames() {
+		fmt.Fprintf(w, "        '%s:%s'\n", name, zshEscape(Subcommands[name].Summary))
+	}
+	fmt.Fprintln(w, "    )")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "    if (( CURRENT > 2 )); then")
+	fmt.Fprintln(w, "        case \"$words[2]\" in")
+	for _, name := range SubcommandNames() {
+		sub := Subcommands[name]
+		if len(sub.Args) > 0 {
+			fmt.Fprintf(w, "            %s) _values '%s' %s; return ;;\n", name, name, strings.Join(sub.Args, " "))
+		}
+	}
+	if open := subcommandsWithoutArgs(); len(open) > 0 {
+		fmt.Fprintf(w, "            %s) _files; return ;;\n", strings.Join(open, "|"))
+	}
+	fmt.Fprintln(w, "        esac")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "    _arguments -s \\")
+	for _, f := range flags {
+		spec := fmt.Sprintf("'%s[%s]", f.flagSpelling(), zshEscape(f.Usage))
+		if f.TakesArg {
+			switch f.ValueHint {
+			case completeFile:
+				spec += ":file:_files"
+			case completeDir:
+				spec += ":directory:_files -/"
+			case completeDocs:
+				spec += fmt.Sprintf(":section:(%s)", strings.Join(AvailableSections, " "))
+			default:
+				spec += ":value: "
+			}
+		}
+		fmt.Fprintf(w, "        %s' \\\n", spec)
+	}
+	fmt.Fprintln(w, "        '1: :->first' \\")
+	fmt.Fprintln(w, "        '*:source file:_files -g \"*.lts\"'")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "    case $state in")
+	fmt.Fprintln(w, "        first)")
+	fmt.Fprintln(w, "            _describe -t commands 'lotus command' commands")
+	fmt.Fprintln(w, "            _files -g '*.lts'")
+	fmt.Fprintln(w, "            ;;")
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "_lotus \"$@\"")
+}
+
+// fishEscape escapes text for a single-quoted fish string
+func fishEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(s)
+}
+
+func writeFishCompletion(w io.Writer, flags []completionFlag) {
+	names := SubcommandNames()
+
+	fmt.Fprintln(w, "# fish completion for lotus")
+	fmt.Fprintln(w, "# Install: lotus completion fish > ~/.config/fish/completions/lotus.fish")
+	fmt.Fprintln(w, "complete -c lotus -f")
+	fmt.Fprintln(w)
+
+	for _, name := range names {
+		sub := Subcommands[name]
+		fmt.Fprintf(w, "complete -c lotus -n '__fish_use_subcommand' -a %s -d '%s'\n", name, fishEscape(sub.Summary))
+		if len(sub.Args) > 0 {
+			fmt.Fprintf(w, "complete -c lotus -n '__fish_seen_subcommand_from %s' -a '%s'\n", name, strings.Join(sub.Args, " "))
+		}
+	}
+	fmt.Fprintln(w)
+
+	for _, f := range flags {
+		opt := "-o"
+		if f.Long {
+			opt = "-l"
+		}
+		line := fmt.Sprintf("complete -c lotus %s %s", opt, f.Name)
+		if f.TakesArg {
+			switch f.ValueHint {
+			case completeFile:
+				line += " -r -F"
+			case completeDir:
+				line += " -r -a '(__fish_complete_directories)'"
+			case completeDocs:
+				line += fmt.Sprintf(" -x -a '%s'", strings.Join(AvailableSections, " "))
+			default:
+				line += " -x"
+			}
+		}
+		line += fmt.Sprintf(" -d '%s'", fishEscape(f.Usage))
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "complete -c lotus -n 'not __fish_seen_subcommand_from %s' -k -a '(__fish_complete_suffix .lts)'\n", strings.Join(names, " "))
+}
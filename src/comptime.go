@@ -0,0 +1,153 @@
+package main
+
+// comptime.go - comptime { } blocks
+// A comptime block is an expression whose body the compiler runs with the
+// interpreter; the value it returns is baked into the program as a literal:
+//
+//	const int FACT10 = comptime {
+//	    int f = 1;
+//	    for (int i = 2; i <= 10; i += 1) { f *= i; }
+//	    return f;
+//	};
+//
+// The body can use constants, functions and the math module, but not the
+// variables of the code around it, which only exist at run time.
+
+// ComptimeBlock is a block evaluated during compilation
+type ComptimeBlock struct {
+	BaseNode
+	Body []ASTNode
+}
+
+func (c *ComptimeBlock) astNode() {}
+
+// parseComptimeBlock parses comptime { ... }
+func (p *Parser) parseComptimeBlock() (*ComptimeBlock, error) {
+	if err := p.expect(TokenComptime); err != nil {
+		return nil, err
+	}
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &ComptimeBlock{Body: body}, nil
+}
+
+// EvaluateComptime replaces every comptime block in program with the value it
+// returns. Blocks that fail are reported to diagnostics.
+func EvaluateComptime(program []ASTNode, dm *DiagnosticManager) {
+	ce := &comptimeEvaluator{in: NewInterpreter(program), dm: dm, file: dm.FilePath}
+	for i, node := range program {
+		program[i] = ce.node(node)
+	}
+}
+
+// comptimeEvaluator walks a program evaluating comptime blocks
+type comptimeEvaluator struct {
+	in   *Interpreter
+	dm   *DiagnosticManager
+	file string // File being walked, for diagnostics
+}
+
+// evaluate runs one block and returns the literal that replaces it
+func (ce *comptimeEvaluator) evaluate(block *ComptimeBlock) ASTNode {
+	v, err := ce.in.RunBlock(block)
+	if err != nil {
+		loc := block.Loc()
+		if e, ok := err.(*EvalError); ok && e.Loc.Line > 0 {
+			loc = e.Loc
+		}
+		msg := err.Error()
+		if e, ok := err.(*EvalError); ok {
+			msg = e.Msg
+		}
+		ce.dm.AddErrorWithCode(string(ErrComptime), CategorySemantic, "comptime: "+msg, ce.file, loc.Line, loc.Column, "")
+		return &IntLiteral{Value: 0}
+	}
+	lit := v.Literal()
+	setLocation(lit, Token{Line: block.Line, Column: block.Column})
+	return lit
+}
+
+// body walks a list of statements in place
+func (ce *comptimeEvaluator) body(nodes []ASTNode) {
+	for i, node := range nodes {
+		nodes[i] = ce.node(node)
+	}
+}
+
+// node walks a statement or expression, returning its replacement
+func (ce *comptimeEvaluator) node(node ASTNode) ASTNode {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *ComptimeBlock:
+		return ce.evaluate(n)
+	case *FunctionDefinition:
+		saved := ce.file
+		if n.File != "" {
+			ce.file = n.File
+		}
+		ce.body(n.Body)
+		ce.file = saved
+	case *VariableDeclaration:
+		n.Value = ce.node(n.Value)
+	case *ConstantDeclaration:
+		n.Value = ce.node(n.Value)
+	case *Assignment:
+		n.Value = ce.node(n.Value)
+	case *CompoundAssignment:
+		n.Value = ce.node(n.Value)
+	case *ReturnStatement:
+		n.Value = ce.node(n.Value)
+	case *ThrowStatement:
+		n.Exception = ce.node(n.Exception)
+	case *IfStatement:
+		n.Condition = ce.node(n.Condition)
+		ce.body(n.ThenBody)
+		ce.body(n.ElseBody)
+	case *WhileLoop:
+		n.Condition = ce.node(n.Condition)
+		ce.body(n.Body)
+	case *ForLoop:
+		n.Init = ce.node(n.Init)
+		n.Condition = ce.node(n.Condition)
+		n.Update = ce.node(n.Update)
+		ce.body(n.Body)
+	case *TryStatement:
+		ce.body(n.TryBlock)
+		for _, clause := range n.CatchClauses {
+			ce.body(clause.Body)
+		}
+		ce.body(n.FinallyBlock)
+	case *FunctionCall:
+		ce.body(n.Args)
+	case *MethodCall:
+		ce.body(n.Args)
+	case *BinaryOp:
+		n.Left, n.Right = ce.node(n.Left), ce.node(n.Right)
+	case *BitwiseOp:
+		n.Left, n.Right = ce.node(n.Left), ce.node(n.Right)
+	case *Comparison:
+		n.Left, n.Right = ce.node(n.Left), ce.node(n.Right)
+	case *LogicalOp:
+		n.Left, n.Right = ce.node(n.Left), ce.node(n.Right)
+	case *UnaryOp:
+		n.Operand = ce.node(n.Operand)
+	case *Conversion:
+		n.Value = ce.node(n.Value)
+	case *Unwrap:
+		n.Value = ce.node(n.Value)
+	case *TernaryOp:
+		n.Condition = ce.node(n.Condition)
+		n.TrueExpr, n.FalseExpr = ce.node(n.TrueExpr), ce.node(n.FalseExpr)
+	case *ArrayLiteral:
+		ce.body(n.Elements)
+	case *MapLiteral:
+		ce.body(n.Keys)
+		ce.body(n.Values)
+	case *ArrayAccess:
+		n.Index = ce.node(n.Index)
+	}
+	return node
+}
@@ -29,6 +29,10 @@ const (
 
 	// EntryPointLabel is the standard entry point for x86-64 programs
 	EntryPointLabel = "_start"
+
+	// EntryStackLabel holds the stack pointer the program started with, which
+	// points at argc, then argv and envp
+	EntryStackLabel = ".lotus_entry_sp"
 )
 
 // System call numbers for x86-64 Linux
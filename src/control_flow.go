@@ -62,7 +62,48 @@ type TernaryOp struct {
 
 func (t *TernaryOp) astNode() {}
 
-// generateIfStatement generates assembly for if/else statements
+// Branch hints given by the likely() and unlikely() intrinsics
+const (
+	hintUnlikely = -1
+	hintLikely   = 1
+)
+
+// coldSection holds branches and @cold functions expected to run rarely,
+// so they stay out of the way of the code around them
+const coldSection = ".text.unlikely"
+
+// branchHint returns the argument of a likely(x) or unlikely(x) call and the
+// hint it gives, or expr itself and 0
+func branchHint(expr ASTNode) (ASTNode, int) {
+	call, ok := expr.(*FunctionCall)
+	if !ok || len(call.Args) != 1 || UserDefinedFunctions[call.Name] != nil {
+		return expr, 0
+	}
+	switch call.Name {
+	case "likely":
+		return call.Args[0], hintLikely
+	case "unlikely":
+		return call.Args[0], hintUnlikely
+	}
+	return expr, 0
+}
+
+// callsCold reports whether body calls a function marked @cold
+func callsCold(body []ASTNode) bool {
+	cold := false
+	walkLoopNodes(body, func(node ASTNode) {
+		if call, ok := node.(*FunctionCall); ok {
+			if fn := UserDefinedFunctions[call.Name]; fn != nil && fn.HasAttribute("cold") {
+				cold = true
+			}
+		}
+	})
+	return cold
+}
+
+// generateIfStatement generates assembly for if/else statements. A branch
+// that is unlikely, by hint or because it calls a @cold function, is moved
+// to the cold section, leaving the other branch as the fall-through path.
 func (cg *CodeGenerator) generateIfStatement(ifStmt *IfStatement) {
 	ifLabel := cg.getLabel("if_then")
 	endLabel := cg.getLabel("if_end")
@@ -71,8 +112,39 @@ func (cg *CodeGenerator) generateIfStatement(ifStmt *IfStatement) {
 		elseLabel = cg.getLabel("if_else")
 	}
 
+	cond, hint := branchHint(ifStmt.Condition)
+	if hint == 0 {
+		switch thenCold, elseCold := callsCold(ifStmt.ThenBody), callsCold(ifStmt.ElseBody); {
+		case thenCold && !elseCold:
+			hint = hintUnlikely
+		case elseCold && !thenCold:
+			hint = hintLikely
+		}
+	}
+
 	// Evaluate condition into rax
-	cg.generateConditionToReg(ifStmt.Condition, "rax")
+	cg.generateConditionToReg(cond, "rax")
+
+	switch {
+	case hint == hintUnlikely:
+		// Else body falls through; the then body runs out of line
+		cg.textSection.WriteString(fmt.Sprintf("    testq %%rax, %%rax\n    jnz %s\n", ifLabel))
+		for _, stmt := range ifStmt.ElseBody {
+			cg.generateStatement(stmt)
+		}
+		cg.generateColdBlock(ifLabel, ifStmt.ThenBody, endLabel)
+		cg.textSection.WriteString(fmt.Sprintf("%s:\n", endLabel))
+		return
+	case hint == hintLikely && elseLabel != "":
+		// Then body falls through; the else body runs out of line
+		cg.textSection.WriteString(fmt.Sprintf("    testq %%rax, %%rax\n    jz %s\n", elseLabel))
+		for _, stmt := range ifStmt.ThenBody {
+			cg.generateStatement(stmt)
+		}
+		cg.generateColdBlock(elseLabel, ifStmt.ElseBody, endLabel)
+		cg.textSection.WriteString(fmt.Sprintf("%s:\n", endLabel))
+		return
+	}
 
 	// Jump to else or end if condition is false
 	if len(ifStmt.ElseBody) > 0 {
@@ -100,6 +172,19 @@ func (cg *CodeGenerator) generateIfStatement(ifStmt *IfStatement) {
 	cg.textSection.WriteString(fmt.Sprintf("%s:\n", endLabel))
 }
 
+// generateColdBlock emits body at label in the cold section, jumping back to
+// resume when it completes
+func (cg *CodeGenerator) generateColdBlock(label string, body []ASTNode, resume string) {
+	cg.customSections[coldSection] = mergeSectionFlags(cg.customSections[coldSection], codeSectionFlags)
+	cg.textSection.WriteString(fmt.Sprintf("    .pushsection %s\n", coldSection))
+	cg.textSection.WriteString(fmt.Sprintf("%s:\n", label))
+	for _, stmt := range body {
+		cg.generateStatement(stmt)
+	}
+	cg.textSection.WriteString(fmt.Sprintf("    jmp %s\n", resume))
+	cg.textSection.WriteString("    .popsection\n")
+}
+
 // generateWhileLoop generates assembly for while loops
 func (cg *CodeGenerator) generateWhileLoop(whileLoop *WhileLoop) {
 	loopLabel := cg.getLabel("while_loop")
@@ -276,18 +361,9 @@ func (cg *CodeGenerator) generateConditionToReg(cond ASTNode, reg string) {
 
 // Label management
 var labelCounter int = 0
-var generatedLabels = make(map[string]bool)
 
 func (cg *CodeGenerator) getLabel(prefix string) string {
 	label := fmt.Sprintf(".%s_%d", prefix, labelCounter)
 	labelCounter++
 	return label
 }
-
-func (cg *CodeGenerator) hasLabel(label string) bool {
-	return generatedLabels[label]
-}
-
-func (cg *CodeGenerator) markLabel(label string) {
-	generatedLabels[label] = true
-}
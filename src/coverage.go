@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// coverage.go - Statement coverage instrumentation
+// Function bodies are split into blocks: runs of statements that execute
+// together, ending at each statement that branches or returns. A counter is
+// bumped at the start of every block. The counters live in a file the program
+// maps shared at startup, so the counts are on disk however it exits, and the
+// CoverageMap built alongside ties each counter back to its statements.
+
+// CoverageSymbol is the data label holding the address of the counter array
+const CoverageSymbol = ".cov_counters"
+
+// CoverageCounter marks the start of an instrumented block
+type CoverageCounter struct {
+	BaseNode
+	Index int
+}
+
+func (c *CoverageCounter) astNode() {}
+
+// CoverageBlock is the set of statements one counter stands for
+type CoverageBlock struct {
+	File  string
+	Stmts []Location // Start of each statement in the block
+}
+
+// CoverageMap lists the blocks of an instrumented program in counter order
+type CoverageMap struct {
+	Blocks []CoverageBlock
+}
+
+// Instrument inserts block counters into the functions declared in a file.
+// A module's own main is skipped, since it is never part of the program.
+func (m *CoverageMap) Instrument(statements []ASTNode, file string, root bool) {
+	for _, stmt := range statements {
+		if fn, ok := stmt.(*FunctionDefinition); ok && (root || fn.Name != "main") {
+			fn.Body = m.instrumentBody(fn.Body, file)
+		}
+	}
+}
+
+// instrumentBody puts a counter in front of each block of body, and
+// instruments the bodies nested in its statements
+func (m *CoverageMap) instrumentBody(body []ASTNode, file string) []ASTNode {
+	result := make([]ASTNode, 0, len(body)+1)
+	var block *CoverageBlock
+	for _, stmt := range body {
+		if block == nil {
+			counter := &CoverageCounter{Index: len(m.Blocks)}
+			counter.setLoc(stmt.Loc())
+			m.Blocks = append(m.Blocks, CoverageBlock{File: file})
+			block = &m.Blocks[len(m.Blocks)-1]
+			result = append(result, counter)
+		}
+		block.Stmts = append(block.Stmts, stmt.Loc())
+		result = append(result, stmt)
+
+		// Anything after a branch may run a different number of times
+		if m.instrumentNested(stmt, file) {
+			block = nil
+		}
+	}
+	return result
+}
+
+// instrumentNested instruments the bodies inside stmt, reporting whether
+// stmt ends the block it is in
+func (m *CoverageMap) instrumentNested(stmt ASTNode, file string) bool {
+	switch s := stmt.(type) {
+	case *IfStatement:
+		s.ThenBody = m.instrumentBody(s.ThenBody, file)
+		s.ElseBody = m.instrumentBody(s.ElseBody, file)
+	case *WhileLoop:
+		s.Body = m.instrumentBody(s.Body, file)
+	case *ForLoop:
+		s.Body = m.instrumentBody(s.Body, file)
+	case *TryStatement:
+		s.TryBlock = m.instrumentBody(s.TryBlock, file)
+		for _, clause := range s.CatchClauses {
+			clause.Body = m.instrumentBody(clause.Body, file)
+		}
+		s.FinallyBlock = m.instrumentBody(s.FinallyBlock, file)
+	case *ReturnStatement, *ThrowStatement:
+	default:
+		return false
+	}
+	return true
+}
+
+// generateCoverageCounter bumps a block's counter
+func (cg *CodeGenerator) generateCoverageCounter(c *CoverageCounter) {
+	if c.Index >= cg.coverageCounters {
+		cg.coverageCounters = c.Index + 1
+	}
+	cg.textSection.WriteString(fmt.Sprintf("    # coverage block %d\n", c.Index))
+	cg.textSection.WriteString(fmt.Sprintf("    movq %s(%%rip), %%r11\n", CoverageSymbol))
+	cg.textSection.WriteString(fmt.Sprintf("    incq %d(%%r11)\n", c.Index*8))
+}
+
+// coverageData returns the counter storage: a pointer to the counters, which
+// starts at an in-memory array used when the counter file cannot be mapped
+func (cg *CodeGenerator) coverageData() string {
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	fmt.Fprintf(&b, "%s:\n    .quad .cov_fallback\n", CoverageSymbol)
+	fmt.Fprintf(&b, ".cov_fallback:\n    .zero %d\n", cg.coverageCounters*8)
+	if cg.coverageFile != "" {
+		fmt.Fprintf(&b, ".cov_path:\n    .asciz \"%s\"\n", escapeAssemblyString(cg.coverageFile))
+	}
+	return b.String()
+}
+
+// coverageSetup returns the startup code that maps the counter file over the
+// in-memory counters. Without an OS, or if the file cannot be opened and
+// mapped, counting goes to the in-memory array instead.
+func (cg *CodeGenerator) coverageSetup() string {
+	lbl1 := cg.getLabel("coverage_setup")
+	openNr, hasOpen := cg.target.Syscall("open")
+	mmapNr, hasMmap := cg.target.Syscall("mmap")
+	closeNr, hasClose := cg.target.Syscall("close")
+	if cg.coverageFile == "" || !hasOpen || !hasMmap || !hasClose {
+		return ""
+	}
+
+	done := cg.getLabel("cov_done")
+	var b strings.Builder
+	b.WriteString("    # Coverage: map the counter file shared\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rax  # syscall: open\n", openNr)
+	b.WriteString("    leaq .cov_path(%rip), %rdi\n")
+	b.WriteString("    movq $2, %rsi  # O_RDWR\n")
+	b.WriteString("    xorq %rdx, %rdx\n")
+	b.WriteString("    syscall\n")
+	b.WriteString("    testq %rax, %rax\n")
+	fmt.Fprintf(&b, "    js %s\n", done)
+	b.WriteString("    movq %rax, %r8  # fd\n")
+	b.WriteString("    pushq %rax\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rax  # syscall: mmap\n", mmapNr)
+	b.WriteString("    xorq %rdi, %rdi\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rsi\n", cg.coverageCounters*8)
+	b.WriteString("    movq $3, %rdx  # PROT_READ|PROT_WRITE\n")
+	b.WriteString("    movq $1, %r10  # MAP_SHARED\n")
+	b.WriteString("    xorq %r9, %r9\n")
+	b.WriteString("    syscall\n")
+	b.WriteString("    cmpq $-4096, %rax\n")
+	fmt.Fprintf(&b, "    ja %s\n", lbl1)
+	fmt.Fprintf(&b, "    movq %%rax, %s(%%rip)\n", CoverageSymbol)
+	fmt.Fprintf(&b, "%s:\n", lbl1)
+	b.WriteString("    popq %rdi\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rax  # syscall: close\n", closeNr)
+	b.WriteString("    syscall\n")
+	fmt.Fprintf(&b, "%s:\n", done)
+	return b.String()
+}
+
+// CreateCoverageFile makes a zeroed counter file for m's blocks
+func CreateCoverageFile(path string, m *CoverageMap) error {
+	return os.WriteFile(path, make([]byte, len(m.Blocks)*8), 0644)
+}
+
+// ReadCoverageCounts reads the counters a run left in the counter file
+func ReadCoverageCounts(path string, m *CoverageMap) ([]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(m.Blocks)*8 {
+		return nil, fmt.Errorf("coverage file %s is truncated", path)
+	}
+	counts := make([]uint64, len(m.Blocks))
+	for i := range counts {
+		counts[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return counts, nil
+}
+
+// CoverageProfile accumulates statement execution counts across runs
+type CoverageProfile struct {
+	files map[string]map[Location]uint64
+}
+
+// NewCoverageProfile creates an empty profile
+func NewCoverageProfile() *CoverageProfile {
+	return &CoverageProfile{files: make(map[string]map[Location]uint64)}
+}
+
+// Add records the counts of one run of the program m describes
+func (p *CoverageProfile) Add(m *CoverageMap, counts []uint64) {
+	for i, block := range m.Blocks {
+		stmts := p.files[block.File]
+		if stmts == nil {
+			stmts = make(map[Location]uint64)
+			p.files[block.File] = stmts
+		}
+		for _, loc := range block.Stmts {
+			stmts[loc] += counts[i]
+		}
+	}
+}
+
+// Files returns the profiled files in sorted order
+func (p *CoverageProfile) Files() []string {
+	files := make([]string, 0, len(p.files))
+	for file := range p.files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Statements returns how many of a file's statements ran, and how many it has
+func (p *CoverageProfile) Statements(file string) (covered, total int) {
+	for _, count := range p.files[file] {
+		if count > 0 {
+			covered++
+		}
+		total++
+	}
+	return covered, total
+}
+
+// PrintCoverageSummary writes the percentage of statements run, per file and overall
+func PrintCoverageSummary(w io.Writer, p *CoverageProfile) {
+	allCovered, allTotal := 0, 0
+	for _, file := range p.Files() {
+		covered, total := p.Statements(file)
+		allCovered += covered
+		allTotal += total
+		fmt.Fprintf(w, "coverage: %s %s\n", file, formatCoverage(covered, total))
+	}
+	fmt.Fprintf(w, "coverage: total %s\n", formatCoverage(allCovered, allTotal))
+}
+
+// formatCoverage renders a statement ratio as a percentage
+func formatCoverage(covered, total int) string {
+	if total == 0 {
+		return "(no statements)"
+	}
+	return fmt.Sprintf("%.1f%% of statements (%d/%d)", 100*float64(covered)/float64(total), covered, total)
+}
+
+// PrintAnnotatedSource writes file with each line that starts a statement
+// prefixed by its execution count, or ##### if it never ran
+func PrintAnnotatedSource(w io.Writer, p *CoverageProfile, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lines := make(map[int]uint64)
+	hasStmt := make(map[int]bool)
+	for loc, count := range p.files[file] {
+		hasStmt[loc.Line] = true
+		if count > lines[loc.Line] {
+			lines[loc.Line] = count
+		}
+	}
+
+	covered, total := p.Statements(file)
+	fmt.Fprintf(w, "\n=== %s: %s ===\n", file, formatCoverage(covered, total))
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		mark := ""
+		if hasStmt[n] {
+			mark = "#####"
+			if lines[n] > 0 {
+				mark = fmt.Sprint(lines[n])
+			}
+		}
+		fmt.Fprintf(w, "%9s | %4d | %s\n", mark, n, scanner.Text())
+	}
+	return scanner.Err()
+}
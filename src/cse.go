@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cse.go - Common subexpression elimination at -O2 and above
+// Within each straight-line run of statements in a function, an expression
+// computed more than once from the same inputs is computed once into a
+// temporary, and the repeats read the temporary. Candidates are arithmetic
+// on locals (except division, which can trap), field loads through a local,
+// and collection element reads (array_int_get, hashmap_int_get,
+// hashmap_str_get) whose arguments are themselves candidates.
+//
+// A value stays available until a statement may change it: assigning one of
+// the variables it reads, or, for loads, a store through a pointer or a call
+// that may write memory. Control flow ends the run; an if's condition takes
+// part, but nothing is carried past the if, and loop bodies are runs of
+// their own. Operands of &&, || and ?: may not be evaluated, so they can
+// reuse a value but never supply its first copy. The temporary is computed
+// just before the statement holding the first copy, which is only allowed
+// when nothing earlier in that statement can change it. Locals whose address
+// is taken are never candidates.
+
+const cseTempPrefix = "cse."
+
+// cseReads are the stdlib calls treated as loads of the memory they are given
+var cseReads = map[string]bool{
+	"collections.array_int_get":   true,
+	"collections.hashmap_int_get": true,
+	"collections.hashmap_str_get": true,
+}
+
+// cseNoWrites are stdlib calls, beyond cseReads and the math module, known
+// to leave the program's memory as it was
+var cseNoWrites = map[string]bool{
+	"io.print": true, "io.println": true, "io.printf": true, "io.fprintf": true,
+	"file.write": true, "str.len": true, "str.compare": true, "collections.array_int_len": true,
+}
+
+// cseOperators spell the operators candidates may use
+var cseOperators = map[TokenType]string{
+	TokenPlus: "+", TokenMinus: "-", TokenStar: "*",
+	TokenAmpersand: "&", TokenPipe: "|", TokenCaret: "^", TokenLShift: "<<", TokenRShift: ">>",
+	TokenTilde: "~", TokenExclaim: "!",
+}
+
+// cseValue is an expression available in the current run
+type cseValue struct {
+	key     string
+	expr    ASTNode         // First copy, which becomes the temporary's value
+	stmt    int             // Index of the statement holding the first copy
+	names   map[string]bool // Variables the value reads
+	load    bool            // The value reads memory
+	repeats []ASTNode       // Later copies, to be replaced
+}
+
+// cseOptimizer holds the per-function facts the rewrite relies on
+type cseOptimizer struct {
+	*stdlibResolver
+	fn      string
+	locals  map[string]bool // Parameters and locals whose address is never taken
+	temps   int
+	remarks *OptRemarks
+}
+
+// EliminateCommonSubexpressions computes repeated expressions once in every
+// function
+func EliminateCommonSubexpressions(statements []ASTNode, remarks *OptRemarks) []ASTNode {
+	resolver := newStdlibResolver(statements)
+	for _, stmt := range statements {
+		if fn, ok := stmt.(*FunctionDefinition); ok {
+			if c := newCSEOptimizer(fn, resolver, remarks); c != nil {
+				fn.Body = c.optimizeRun(fn.Body)
+			}
+		}
+	}
+	return statements
+}
+
+// newCSEOptimizer collects the locals of fn, or returns nil if the body
+// contains a node the pass does not understand
+func newCSEOptimizer(fn *FunctionDefinition, resolver *stdlibResolver, remarks *OptRemarks) *cseOptimizer {
+	c := &cseOptimizer{stdlibResolver: resolver, fn: fn.Name, locals: make(map[string]bool), remarks: remarks}
+	addressed := make(map[string]bool)
+	for _, param := range fn.Parameters {
+		c.locals[param.Name] = true
+	}
+	ok := walkLoopNodes(fn.Body, func(node ASTNode) {
+		switch n := node.(type) {
+		case *VariableDeclaration:
+			c.locals[n.Name] = true
+		case *Reference:
+			if id, ok := n.Target.(*Identifier); ok {
+				addressed[id.Name] = true
+			}
+		case *UnaryOp:
+			if id, ok := n.Operand.(*Identifier); ok && n.Operator == TokenAmpersand {
+				addressed[id.Name] = true
+			}
+		case *FieldAccess:
+			if id, ok := n.Object.(*Identifier); ok && !n.IsPointer {
+				addressed[id.Name] = true
+			}
+		}
+	})
+	if !ok {
+		return nil
+	}
+	for name := range addressed {
+		delete(c.locals, name)
+	}
+	return c
+}
+
+// key spells expr's value in source form, or returns "" if expr is not one
+// the pass can reason about. load is set when the value reads memory.
+func (c *cseOptimizer) key(expr ASTNode) (key string, load bool) {
+	switch e := expr.(type) {
+	case *IntLiteral:
+		return fmt.Sprintf("%d", e.Value), false
+	case *Identifier:
+		if c.locals[e.Name] {
+			return e.Name, false
+		}
+	case *BinaryOp:
+		return c.binaryKey(e.Left, e.Operator, e.Right)
+	case *BitwiseOp:
+		return c.binaryKey(e.Left, e.Operator, e.Right)
+	case *UnaryOp:
+		if op, ok := cseOperators[e.Operator]; ok && e.Operator != TokenAmpersand {
+			if operand, load := c.key(e.Operand); operand != "" {
+				return op + operand, load
+			}
+		}
+	case *FieldAccess:
+		if id, ok := e.Object.(*Identifier); ok && e.IsPointer && c.locals[id.Name] {
+			return id.Name + "->" + e.FieldName, true
+		}
+	case *FunctionCall:
+		if !cseReads[c.calleeName(e)] {
+			break
+		}
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			if args[i], _ = c.key(arg); args[i] == "" {
+				return "", false
+			}
+		}
+		return fmt.Sprintf("%s(%s)", e.Name, strings.Join(args, ", ")), true
+	}
+	return "", false
+}
+
+// binaryKey spells a two-operand candidate
+func (c *cseOptimizer) binaryKey(left ASTNode, op TokenType, right ASTNode) (string, bool) {
+	spelled, ok := cseOperators[op]
+	if !ok || op == TokenTilde || op == TokenExclaim {
+		return "", false
+	}
+	l, leftLoad := c.key(left)
+	r, rightLoad := c.key(right)
+	if l == "" || r == "" {
+		return "", false
+	}
+	return fmt.Sprintf("(%s %s %s)", l, spelled, r), leftLoad || rightLoad
+}
+
+// writesMemory reports whether evaluating expr may store to memory
+func (r *stdlibResolver) writesMemory(expr ASTNode) bool {
+	writes := false
+	walkLoopNodes([]ASTNode{expr}, func(node ASTNode) {
+		call, ok := node.(*FunctionCall)
+		if !ok {
+			return
+		}
+		name := r.calleeName(call)
+		if !cseReads[name] && !cseNoWrites[name] && !collectionHeaderLoads[name] && !strings.HasPrefix(name, "math.") {
+			writes = true
+		}
+	})
+	return writes
+}
+
+// runExpressions returns the expressions a statement evaluates before any
+// effect of its own, or false if the statement ends the run
+func runExpressions(stmt ASTNode) ([]ASTNode, bool) {
+	switch s := stmt.(type) {
+	case *VariableDeclaration:
+		return []ASTNode{s.Value}, true
+	case *Assignment:
+		if access, ok := s.Target.(*ArrayAccess); ok {
+			return []ASTNode{access.Index, s.Value}, true
+		}
+		return []ASTNode{s.Value}, true
+	case *CompoundAssignment:
+		return []ASTNode{s.Value}, true
+	case *ReturnStatement:
+		return []ASTNode{s.Value}, true
+	case *FunctionCall:
+		return s.Args, true
+	case *CoverageCounter:
+		return nil, true
+	}
+	return nil, false
+}
+
+// optimizeRun rewrites a statement list, and the lists nested in it
+func (c *cseOptimizer) optimizeRun(body []ASTNode) []ASTNode {
+	var live, done []*cseValue
+	retire := func(match func(*cseValue) bool) {
+		kept := live[:0]
+		for _, v := range live {
+			if match(v) {
+				done = append(done, v)
+			} else {
+				kept = append(kept, v)
+			}
+		}
+		live = kept
+	}
+	all := func(*cseValue) bool { return true }
+	loads := func(v *cseValue) bool { return v.load }
+	reading := func(name string) func(*cseValue) bool {
+		return func(v *cseValue) bool { return v.names[name] }
+	}
+
+	for i, stmt := range body {
+		exprs, straight := runExpressions(stmt)
+		switch s := stmt.(type) {
+		case *IfStatement:
+			exprs = []ASTNode{s.Condition}
+			s.ThenBody = c.optimizeRun(s.ThenBody)
+			s.ElseBody = c.optimizeRun(s.ElseBody)
+		case *WhileLoop:
+			s.Body = c.optimizeRun(s.Body)
+		case *ForLoop:
+			s.Body = c.optimizeRun(s.Body)
+		}
+
+		writes := false
+		if call, ok := stmt.(*FunctionCall); ok {
+			writes = c.writesMemory(call)
+		}
+		for _, expr := range exprs {
+			writes = writes || c.writesMemory(expr)
+		}
+		var visit func(expr ASTNode, conditional bool)
+		visit = func(expr ASTNode, conditional bool) {
+			if expr == nil {
+				return
+			}
+			switch expr.(type) {
+			case *IntLiteral, *Identifier:
+				return
+			}
+			if key, load := c.key(expr); key != "" && !(load && writes) {
+				for _, v := range live {
+					if v.key == key {
+						v.repeats = append(v.repeats, expr)
+						return
+					}
+				}
+				if !conditional {
+					live = append(live, &cseValue{key: key, expr: expr, stmt: i, names: readNames(expr), load: load})
+				}
+			}
+			switch e := expr.(type) {
+			case *LogicalOp:
+				visit(e.Left, conditional)
+				visit(e.Right, true)
+			case *TernaryOp:
+				visit(e.Condition, conditional)
+				visit(e.TrueExpr, true)
+				visit(e.FalseExpr, true)
+			default:
+				children, _ := nodeChildren(expr)
+				for _, child := range children {
+					visit(child, conditional)
+				}
+			}
+		}
+		for _, expr := range exprs {
+			visit(expr, false)
+		}
+
+		if !straight {
+			retire(all)
+			continue
+		}
+		if writes {
+			retire(loads)
+		}
+		switch s := stmt.(type) {
+		case *VariableDeclaration:
+			retire(reading(s.Name))
+		case *Assignment:
+			if id, ok := s.Target.(*Identifier); ok {
+				retire(reading(id.Name))
+			} else {
+				retire(loads)
+			}
+		case *CompoundAssignment:
+			if id, ok := s.Target.(*Identifier); ok {
+				retire(reading(id.Name))
+			} else {
+				retire(loads)
+			}
+		}
+	}
+	retire(all)
+	return c.rewriteRun(body, done)
+}
+
+// unparenthesized drops the parentheses around a whole binary key
+func unparenthesized(key string) string {
+	if strings.HasPrefix(key, "(") && strings.HasSuffix(key, ")") {
+		return key[1 : len(key)-1]
+	}
+	return key
+}
+
+// readNames returns the variables expr reads
+func readNames(expr ASTNode) map[string]bool {
+	names := make(map[string]bool)
+	walkLoopNodes([]ASTNode{expr}, func(node ASTNode) {
+		if id, ok := node.(*Identifier); ok {
+			names[id.Name] = true
+		}
+	})
+	return names
+}
+
+// rewriteRun replaces the repeated values of a run with temporaries, each
+// declared ahead of the statement holding its first copy
+func (c *cseOptimizer) rewriteRun(body []ASTNode, values []*cseValue) []ASTNode {
+	replace := make(map[ASTNode]string)
+	decls := make(map[int][]ASTNode)
+	var temps []*VariableDeclaration
+	for _, v := range values {
+		if len(v.repeats) == 0 {
+			continue
+		}
+		name := fmt.Sprintf("%s%d", cseTempPrefix, c.temps)
+		c.temps++
+		replace[v.expr] = name
+		for _, repeat := range v.repeats {
+			replace[repeat] = name
+		}
+		decl := &VariableDeclaration{Name: name, Type: TokenTypeInt, Value: v.expr}
+		decl.Location = body[v.stmt].Loc()
+		temps = append(temps, decl)
+		// A value found inside another from the same statement was found
+		// after it, and must be computed before it
+		decls[v.stmt] = append([]ASTNode{decl}, decls[v.stmt]...)
+		c.remarks.add(OptRemark{Pass: "cse", Function: c.fn, Line: decl.Line,
+			Message: fmt.Sprintf("%s computed once for %d uses", unparenthesized(v.key), len(v.repeats)+1),
+			Saved:   len(v.repeats)})
+	}
+	if len(temps) == 0 {
+		return body
+	}
+
+	var substitute func(ASTNode) ASTNode
+	substitute = func(expr ASTNode) ASTNode {
+		if name, ok := replace[expr]; ok {
+			return &Identifier{Name: name}
+		}
+		return mapSubexpressions(expr, substitute)
+	}
+	for _, decl := range temps {
+		decl.Value = mapSubexpressions(decl.Value, substitute)
+	}
+	body = mapStatementExpressions(body, substitute)
+
+	result := make([]ASTNode, 0, len(body)+len(temps))
+	for i, stmt := range body {
+		result = append(result, decls[i]...)
+		result = append(result, stmt)
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// debuginfo.go - Source line tables (-g)
+// Each statement is preceded by a .loc directive naming its file and line.
+// The assembler turns these into the DWARF line table that debuggers and
+// `lotus disasm` use to map instructions back to source. Functions are also
+// given an ELF type and size so they show up as whole symbols.
+
+// lineDirective marks the start of a statement's code
+func (cg *CodeGenerator) lineDirective(loc Location) {
+	if loc.Line == 0 {
+		return
+	}
+	file := cg.debugFileNumber(cg.debugFile)
+	if file == cg.lastDebugFile && loc.Line == cg.lastDebugLine {
+		return
+	}
+	cg.lastDebugFile, cg.lastDebugLine = file, loc.Line
+	cg.textSection.WriteString(fmt.Sprintf("    .loc %d %d %d\n", file, loc.Line, loc.Column))
+}
+
+// debugFileNumber returns the .file number of path, numbering files in the
+// order they are first used
+func (cg *CodeGenerator) debugFileNumber(path string) int {
+	for i, f := range cg.debugFiles {
+		if f == path {
+			return i + 1
+		}
+	}
+	cg.debugFiles = append(cg.debugFiles, path)
+	return len(cg.debugFiles)
+}
+
+// debugFileDirectives declares the files the .loc directives refer to
+func (cg *CodeGenerator) debugFileDirectives() string {
+	var b strings.Builder
+	for i, path := range cg.debugFiles {
+		fmt.Fprintf(&b, "    .file %d \"%s\"\n", i+1, escapeAssemblyString(path))
+	}
+	return b.String()
+}
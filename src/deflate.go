@@ -0,0 +1,870 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// deflate.go - DEFLATE (RFC 1951) and gzip (RFC 1952) runtime
+// The compress module and http's gzip decoding call these routines, which are
+// appended to the program once when either is used. They work only in the
+// buffers they are given and make no system calls.
+//
+//	.lotus_rt_inflate  in %rdi, in_len %rsi, out %rdx, out_cap %rcx, gzip %r8
+//	.lotus_rt_gzip     in %rdi, in_len %rsi, out %rdx, out_cap %rcx
+//
+// Both return the bytes written in %rax or a negative errno: -EINVAL for data
+// that is not valid DEFLATE or gzip, -EBADMSG for a gzip checksum mismatch and
+// -ENOBUFS when the output does not fit. Both preserve %rbx, %rbp and
+// %r12-%r15.
+//
+// The compressor matches repeated strings greedily through a hash of the last
+// position each 3-byte prefix was seen at and codes them with the fixed
+// Huffman codes, falling back to stored blocks when that is no smaller.
+
+// Inflate frame, addressed from %rbp below the five saved registers
+const (
+	inflateLenCode  = 0    // lit/len code: count[16] then symbol[288], as shorts
+	inflateDistCode = 608  // distance code: count[16] then symbol[32]
+	inflateLengths  = 704  // code lengths being read, shorts[320]
+	inflateGzip     = 1376 // gzip framing flag
+	inflateLast     = 1384 // BFINAL of the current block
+	inflateNLen     = 1392 // lit/len codes in a dynamic block
+	inflateNDist    = 1400 // distance codes in a dynamic block
+	inflateFrame    = 1408
+)
+
+// Deflate frame: the match hash table and the current match
+const (
+	deflateHashBits = 12
+	deflateHash     = 0     // last position of each 3-byte hash, int32[4096]
+	deflateLen      = 16384 // length of the match being written
+	deflateDist     = 16392 // distance of the match being written
+	deflateFrame    = 16400
+)
+
+// deflateMaxStored is the most one stored block holds
+const deflateMaxStored = 65535
+
+// useDeflate appends the DEFLATE runtime to the program
+func (cg *CodeGenerator) useDeflate() {
+	cg.deflate = true
+	cg.useTable(crc32Table)
+}
+
+// deflateData returns the tables the routines share
+func deflateData() string {
+	var b strings.Builder
+	b.WriteString("    .section .rodata\n")
+	b.WriteString(".lotus_rt_lbase:\n    .short 3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258\n")
+	b.WriteString(".lotus_rt_lext:\n    .byte 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0\n")
+	b.WriteString(".lotus_rt_dbase:\n    .short 1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577\n")
+	b.WriteString(".lotus_rt_dext:\n    .byte 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13\n")
+	b.WriteString(".lotus_rt_clorder:\n    .byte 16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15\n")
+	b.WriteString(DataSectionDirective + "\n")
+	return b.String()
+}
+
+// deflateRuntime returns the inflate and gzip routines
+func (cg *CodeGenerator) deflateRuntime() string {
+	inflateBase := -(40 + inflateFrame)
+	deflateBase := -(40 + deflateFrame)
+	hash := fmt.Sprintf(`    movzwl (%%r8,%%r10), %%eax
+    movzbl 2(%%r8,%%r10), %%ecx
+    shll $16, %%ecx
+    orl %%ecx, %%eax
+    imull $0x9e3779b1, %%eax, %%eax
+    shrl $%d, %%eax`, 32-deflateHashBits)
+	return cg.localLabels("lotus_rt_deflate", strings.NewReplacer(
+		"{frame}", fmt.Sprint(inflateFrame),
+		"{lencode}", fmt.Sprint(inflateBase+inflateLenCode),
+		"{distcode}", fmt.Sprint(inflateBase+inflateDistCode),
+		"{lengths}", fmt.Sprint(inflateBase+inflateLengths),
+		"{gzip}", fmt.Sprint(inflateBase+inflateGzip),
+		"{last}", fmt.Sprint(inflateBase+inflateLast),
+		"{nlen}", fmt.Sprint(inflateBase+inflateNLen),
+		"{ndist}", fmt.Sprint(inflateBase+inflateNDist),
+		"{dframe}", fmt.Sprint(deflateFrame),
+		"{dhash}", fmt.Sprint(deflateBase+deflateHash),
+		"{dlen}", fmt.Sprint(deflateBase+deflateLen),
+		"{ddist}", fmt.Sprint(deflateBase+deflateDist),
+		"{hashsize}", fmt.Sprint(1<<deflateHashBits),
+		"{maxstored}", fmt.Sprint(deflateMaxStored),
+		"{hash}", hash,
+	).Replace(`
+# ---- DEFLATE runtime ----
+
+# Inflate state: input at %r8 up to %r9, output from %r12 at %r14 up to %r13,
+# bit buffer %rbx holding %r15 bits. Errors unwind through %rbp.
+.lotus_rt_inflate:
+    pushq %rbp
+    movq %rsp, %rbp
+    pushq %rbx
+    pushq %r12
+    pushq %r13
+    pushq %r14
+    pushq %r15
+    subq ${frame}, %rsp
+    movq %r8, {gzip}(%rbp)
+    movq %rdi, %r8
+    leaq (%rdi,%rsi), %r9
+    movq %rdx, %r12
+    movq %rdx, %r14
+    leaq (%rdx,%rcx), %r13
+    xorl %ebx, %ebx
+    xorl %r15d, %r15d
+    cmpq $0, {gzip}(%rbp)
+    je .lotus_rt_inflate_block
+    call .lotus_rt_inflate_gzip_header
+.lotus_rt_inflate_block:
+    movl $1, %edx
+    call .lotus_rt_inflate_bits
+    movq %rax, {last}(%rbp)
+    movl $2, %edx
+    call .lotus_rt_inflate_bits
+    testq %rax, %rax
+    je .lotus_rt_inflate_stored
+    cmpq $1, %rax
+    je .lotus_rt_inflate_fixed
+    cmpq $2, %rax
+    je .lotus_rt_inflate_dynamic
+    jmp .lotus_rt_inflate_bad
+.lotus_rt_inflate_next:
+    cmpq $0, {last}(%rbp)
+    je .lotus_rt_inflate_block
+    cmpq $0, {gzip}(%rbp)
+    je .lotus_rt_inflate_done
+    call .lotus_rt_inflate_gzip_trailer
+.lotus_rt_inflate_done:
+    movq %r14, %rax
+    subq %r12, %rax
+.lotus_rt_inflate_return:
+    leaq -40(%rbp), %rsp
+    popq %r15
+    popq %r14
+    popq %r13
+    popq %r12
+    popq %rbx
+    popq %rbp
+    ret
+.lotus_rt_inflate_bad:
+    movq $-22, %rax  # EINVAL
+    jmp .lotus_rt_inflate_return
+.lotus_rt_inflate_full:
+    movq $-105, %rax  # ENOBUFS
+    jmp .lotus_rt_inflate_return
+.lotus_rt_inflate_badsum:
+    movq $-74, %rax  # EBADMSG
+    jmp .lotus_rt_inflate_return
+
+# Stored block: LEN and its complement, then LEN bytes, from the next byte
+.lotus_rt_inflate_stored:
+    xorl %ebx, %ebx
+    xorl %r15d, %r15d
+    leaq 4(%r8), %rax
+    cmpq %r9, %rax
+    ja .lotus_rt_inflate_bad
+    movzwl (%r8), %ecx
+    movzwl 2(%r8), %eax
+    addq $4, %r8
+    notl %eax
+    andl $0xffff, %eax
+    cmpl %eax, %ecx
+    jne .lotus_rt_inflate_bad
+    leaq (%r8,%rcx), %rax
+    cmpq %r9, %rax
+    ja .lotus_rt_inflate_bad
+    leaq (%r14,%rcx), %rax
+    cmpq %r13, %rax
+    ja .lotus_rt_inflate_full
+    movq %r8, %rsi
+    movq %r14, %rdi
+    rep movsb
+    movq %rsi, %r8
+    movq %rdi, %r14
+    jmp .lotus_rt_inflate_next
+
+# Fixed Huffman block
+.lotus_rt_inflate_fixed:
+    leaq {lengths}(%rbp), %rdi
+    movl $8, %eax
+    movl $144, %ecx
+    rep stosw
+    movl $9, %eax
+    movl $112, %ecx
+    rep stosw
+    movl $7, %eax
+    movl $24, %ecx
+    rep stosw
+    movl $8, %eax
+    movl $8, %ecx
+    rep stosw
+    movl $5, %eax
+    movl $30, %ecx
+    rep stosw
+    leaq {lencode}(%rbp), %rdi
+    leaq {lengths}(%rbp), %rsi
+    movl $288, %edx
+    call .lotus_rt_inflate_construct
+    leaq {distcode}(%rbp), %rdi
+    leaq {lengths}+576(%rbp), %rsi
+    movl $30, %edx
+    call .lotus_rt_inflate_construct
+    call .lotus_rt_inflate_codes
+    jmp .lotus_rt_inflate_next
+
+# Dynamic Huffman block: the code lengths, themselves Huffman coded
+.lotus_rt_inflate_dynamic:
+    movl $5, %edx
+    call .lotus_rt_inflate_bits
+    addq $257, %rax
+    movq %rax, {nlen}(%rbp)
+    movl $5, %edx
+    call .lotus_rt_inflate_bits
+    incq %rax
+    movq %rax, {ndist}(%rbp)
+    movl $4, %edx
+    call .lotus_rt_inflate_bits
+    leaq 4(%rax), %r10
+    cmpq $286, {nlen}(%rbp)
+    ja .lotus_rt_inflate_bad
+    cmpq $30, {ndist}(%rbp)
+    ja .lotus_rt_inflate_bad
+    leaq {lengths}(%rbp), %rdi
+    xorl %eax, %eax
+    movl $19, %ecx
+    rep stosw
+    xorl %r11d, %r11d
+.lotus_rt_inflate_clen:
+    cmpq %r10, %r11
+    jae .lotus_rt_inflate_clen_done
+    movl $3, %edx
+    call .lotus_rt_inflate_bits
+    leaq .lotus_rt_clorder(%rip), %rdi
+    movzbq (%rdi,%r11), %rcx
+    movw %ax, {lengths}(%rbp,%rcx,2)
+    incq %r11
+    jmp .lotus_rt_inflate_clen
+.lotus_rt_inflate_clen_done:
+    leaq {lencode}(%rbp), %rdi
+    leaq {lengths}(%rbp), %rsi
+    movl $19, %edx
+    call .lotus_rt_inflate_construct
+    testq %rax, %rax
+    jnz .lotus_rt_inflate_bad
+    xorl %r10d, %r10d
+.lotus_rt_inflate_len:
+    movq {nlen}(%rbp), %rax
+    addq {ndist}(%rbp), %rax
+    cmpq %rax, %r10
+    jae .lotus_rt_inflate_len_done
+    leaq {lencode}(%rbp), %rdi
+    call .lotus_rt_inflate_decode
+    cmpq $16, %rax
+    jae .lotus_rt_inflate_repeat
+    movw %ax, {lengths}(%rbp,%r10,2)
+    incq %r10
+    jmp .lotus_rt_inflate_len
+.lotus_rt_inflate_repeat:
+    xorl %edi, %edi
+    cmpq $16, %rax
+    jne .lotus_rt_inflate_zeros
+    testq %r10, %r10
+    jz .lotus_rt_inflate_bad
+    movzwq {lengths}-2(%rbp,%r10,2), %rdi
+    movl $2, %edx
+    call .lotus_rt_inflate_bits
+    addq $3, %rax
+    jmp .lotus_rt_inflate_fill
+.lotus_rt_inflate_zeros:
+    cmpq $17, %rax
+    jne .lotus_rt_inflate_long_zeros
+    movl $3, %edx
+    call .lotus_rt_inflate_bits
+    addq $3, %rax
+    jmp .lotus_rt_inflate_fill
+.lotus_rt_inflate_long_zeros:
+    movl $7, %edx
+    call .lotus_rt_inflate_bits
+    addq $11, %rax
+.lotus_rt_inflate_fill:
+    leaq (%r10,%rax), %rcx
+    movq {nlen}(%rbp), %rdx
+    addq {ndist}(%rbp), %rdx
+    cmpq %rdx, %rcx
+    ja .lotus_rt_inflate_bad
+.lotus_rt_inflate_fill_loop:
+    movw %di, {lengths}(%rbp,%r10,2)
+    incq %r10
+    decq %rax
+    jnz .lotus_rt_inflate_fill_loop
+    jmp .lotus_rt_inflate_len
+.lotus_rt_inflate_len_done:
+    cmpw $0, {lengths}+512(%rbp)  # no end-of-block code
+    je .lotus_rt_inflate_bad
+    leaq {lencode}(%rbp), %rdi
+    leaq {lengths}(%rbp), %rsi
+    movq {nlen}(%rbp), %rdx
+    call .lotus_rt_inflate_construct
+    call .lotus_rt_inflate_check_code
+    leaq {distcode}(%rbp), %rdi
+    movq {nlen}(%rbp), %rax
+    leaq {lengths}(%rbp,%rax,2), %rsi
+    movq {ndist}(%rbp), %rdx
+    call .lotus_rt_inflate_construct
+    call .lotus_rt_inflate_check_code
+    call .lotus_rt_inflate_codes
+    jmp .lotus_rt_inflate_next
+
+# A code built by construct from %rdx lengths for table %rdi, with %rax codes
+# left over, may only be incomplete when it has a single one-bit code
+.lotus_rt_inflate_check_code:
+    testq %rax, %rax
+    js .lotus_rt_inflate_bad
+    jz {l1}
+    movzwq (%rdi), %rax
+    movzwq 2(%rdi), %rcx
+    addq %rcx, %rax
+    cmpq %rdx, %rax
+    jne .lotus_rt_inflate_bad
+{l1}:
+    ret
+
+# Decode lit/len and distance symbols until end of block
+.lotus_rt_inflate_codes:
+    leaq {lencode}(%rbp), %rdi
+    call .lotus_rt_inflate_decode
+    cmpq $256, %rax
+    jae .lotus_rt_inflate_codes_match
+    cmpq %r13, %r14
+    jae .lotus_rt_inflate_full
+    movb %al, (%r14)
+    incq %r14
+    jmp .lotus_rt_inflate_codes
+.lotus_rt_inflate_codes_match:
+    je .lotus_rt_inflate_codes_end
+    subq $257, %rax
+    cmpq $29, %rax
+    jae .lotus_rt_inflate_bad
+    movq %rax, %rdi
+    leaq .lotus_rt_lext(%rip), %rcx
+    movzbq (%rcx,%rdi), %rdx
+    call .lotus_rt_inflate_bits
+    leaq .lotus_rt_lbase(%rip), %rcx
+    movzwq (%rcx,%rdi,2), %r10
+    addq %rax, %r10
+    leaq {distcode}(%rbp), %rdi
+    call .lotus_rt_inflate_decode
+    cmpq $30, %rax
+    jae .lotus_rt_inflate_bad
+    movq %rax, %rdi
+    leaq .lotus_rt_dext(%rip), %rcx
+    movzbq (%rcx,%rdi), %rdx
+    call .lotus_rt_inflate_bits
+    leaq .lotus_rt_dbase(%rip), %rcx
+    movzwq (%rcx,%rdi,2), %rdx
+    addq %rax, %rdx
+    movq %r14, %rax
+    subq %r12, %rax
+    cmpq %rax, %rdx  # reaches back before the output
+    ja .lotus_rt_inflate_bad
+    leaq (%r14,%r10), %rax
+    cmpq %r13, %rax
+    ja .lotus_rt_inflate_full
+    movq %r14, %rsi
+    subq %rdx, %rsi
+.lotus_rt_inflate_copy:
+    movb (%rsi), %al
+    movb %al, (%r14)
+    incq %rsi
+    incq %r14
+    decq %r10
+    jnz .lotus_rt_inflate_copy
+    jmp .lotus_rt_inflate_codes
+.lotus_rt_inflate_codes_end:
+    ret
+
+# Take %rdx bits (at most 13) from the input into %rax. Clobbers rcx, rsi.
+.lotus_rt_inflate_bits:
+    cmpq %rdx, %r15
+    jae {l2}
+    cmpq %r9, %r8
+    jae .lotus_rt_inflate_bad
+    movzbq (%r8), %rsi
+    incq %r8
+    movq %r15, %rcx
+    shlq %cl, %rsi
+    orq %rsi, %rbx
+    addq $8, %r15
+    jmp .lotus_rt_inflate_bits
+{l2}:
+    movq %rbx, %rax
+    movq %rdx, %rcx
+    shrq %cl, %rbx
+    subq %rdx, %r15
+    movl $1, %esi
+    shlq %cl, %rsi
+    decq %rsi
+    andq %rsi, %rax
+    ret
+
+# Decode one symbol with the code at %rdi into %rax, a bit at a time.
+# Clobbers rcx, rdx, rsi, r11.
+.lotus_rt_inflate_decode:
+    xorl %edx, %edx  # code
+    xorl %esi, %esi  # first code of this length
+    xorl %r11d, %r11d  # index of that code's symbol
+    movl $1, %ecx
+{l3}:
+    testq %r15, %r15
+    jnz {l4}
+    cmpq %r9, %r8
+    jae .lotus_rt_inflate_bad
+    movzbq (%r8), %rbx
+    incq %r8
+    movl $8, %r15d
+{l4}:
+    movq %rbx, %rax
+    andl $1, %eax
+    shrq $1, %rbx
+    decq %r15
+    orq %rax, %rdx
+    movzwq (%rdi,%rcx,2), %rax
+    addq %rax, %rsi
+    cmpq %rsi, %rdx
+    jb {l5}
+    addq %rax, %r11
+    shlq $1, %rsi
+    shlq $1, %rdx
+    incq %rcx
+    cmpq $15, %rcx
+    jbe {l3}
+    jmp .lotus_rt_inflate_bad
+{l5}:
+    subq %rsi, %rdx
+    addq %rax, %rdx
+    addq %r11, %rdx
+    movzwq 32(%rdi,%rdx,2), %rax
+    ret
+
+# Build the canonical code at %rdi from the %rdx shorts at %rsi. Leaves the
+# codes left unused in %rax: 0 when complete, negative when over-subscribed.
+# Clobbers rcx, r11.
+.lotus_rt_inflate_construct:
+    subq $40, %rsp  # offsets[16], then the result
+    xorl %eax, %eax
+    movq %rax, (%rdi)
+    movq %rax, 8(%rdi)
+    movq %rax, 16(%rdi)
+    movq %rax, 24(%rdi)
+    xorl %ecx, %ecx
+{l6}:
+    cmpq %rdx, %rcx
+    jae {l7}
+    movzwq (%rsi,%rcx,2), %rax
+    incw (%rdi,%rax,2)
+    incq %rcx
+    jmp {l6}
+{l7}:
+    movzwq (%rdi), %rax
+    cmpq %rdx, %rax
+    jne {l8}
+    xorl %eax, %eax  # no codes at all
+    jmp {l14}
+{l8}:
+    movl $1, %eax
+    movl $1, %ecx
+{l9}:
+    shlq $1, %rax
+    movzwq (%rdi,%rcx,2), %r11
+    subq %r11, %rax
+    js {l14}
+    incq %rcx
+    cmpq $15, %rcx
+    jbe {l9}
+    movq %rax, 32(%rsp)
+    movw $0, 2(%rsp)
+    movl $1, %ecx
+{l10}:
+    movzwl (%rsp,%rcx,2), %r11d
+    addw (%rdi,%rcx,2), %r11w
+    movw %r11w, 2(%rsp,%rcx,2)
+    incq %rcx
+    cmpq $14, %rcx
+    jbe {l10}
+    xorl %ecx, %ecx
+{l11}:
+    cmpq %rdx, %rcx
+    jae {l13}
+    movzwq (%rsi,%rcx,2), %r11
+    testq %r11, %r11
+    jz {l12}
+    movzwq (%rsp,%r11,2), %rax
+    movw %cx, 32(%rdi,%rax,2)
+    incw (%rsp,%r11,2)
+{l12}:
+    incq %rcx
+    jmp {l11}
+{l13}:
+    movq 32(%rsp), %rax
+{l14}:
+    addq $40, %rsp
+    ret
+
+# Skip the gzip member header
+.lotus_rt_inflate_gzip_header:
+    leaq 10(%r8), %rax
+    cmpq %r9, %rax
+    ja .lotus_rt_inflate_bad
+    cmpw $0x8b1f, (%r8)
+    jne .lotus_rt_inflate_bad
+    cmpb $8, 2(%r8)  # deflate
+    jne .lotus_rt_inflate_bad
+    movzbq 3(%r8), %rdx  # flags
+    addq $10, %r8
+    testb $4, %dl  # FEXTRA
+    jz {l15}
+    leaq 2(%r8), %rax
+    cmpq %r9, %rax
+    ja .lotus_rt_inflate_bad
+    movzwq (%r8), %rax
+    leaq 2(%r8,%rax), %r8
+    cmpq %r9, %r8
+    ja .lotus_rt_inflate_bad
+{l15}:
+    testb $8, %dl  # FNAME
+    jz {l17}
+{l16}:
+    cmpq %r9, %r8
+    jae .lotus_rt_inflate_bad
+    movb (%r8), %al
+    incq %r8
+    testb %al, %al
+    jnz {l16}
+{l17}:
+    testb $16, %dl  # FCOMMENT
+    jz {l19}
+{l18}:
+    cmpq %r9, %r8
+    jae .lotus_rt_inflate_bad
+    movb (%r8), %al
+    incq %r8
+    testb %al, %al
+    jnz {l18}
+{l19}:
+    testb $2, %dl  # FHCRC
+    jz {l20}
+    addq $2, %r8
+    cmpq %r9, %r8
+    ja .lotus_rt_inflate_bad
+{l20}:
+    ret
+
+# Check the CRC-32 and length that follow the member's deflate data
+.lotus_rt_inflate_gzip_trailer:
+    xorl %ebx, %ebx
+    xorl %r15d, %r15d
+    leaq 8(%r8), %rax
+    cmpq %r9, %rax
+    ja .lotus_rt_inflate_bad
+    movq %r14, %rcx
+    subq %r12, %rcx
+    cmpl 4(%r8), %ecx
+    jne .lotus_rt_inflate_badsum
+    movq %r12, %rsi
+    call .lotus_rt_crc32
+    cmpl (%r8), %eax
+    jne .lotus_rt_inflate_badsum
+    addq $8, %r8
+    ret
+
+# CRC-32 of the %rcx bytes at %rsi into %eax. Clobbers rcx, rdx, rsi, rdi.
+.lotus_rt_crc32:
+    movl $0xffffffff, %eax
+    leaq .lotus_table_crc32(%rip), %rdi
+{l21}:
+    testq %rcx, %rcx
+    jz {l22}
+    movzbl (%rsi), %edx
+    xorb %al, %dl
+    shrl $8, %eax
+    xorl (%rdi,%rdx,4), %eax
+    incq %rsi
+    decq %rcx
+    jmp {l21}
+{l22}:
+    notl %eax
+    ret
+
+# Deflate state: input at %r8, %r9 bytes, position %r10; output as inflate's
+.lotus_rt_gzip:
+    pushq %rbp
+    movq %rsp, %rbp
+    pushq %rbx
+    pushq %r12
+    pushq %r13
+    pushq %r14
+    pushq %r15
+    subq ${dframe}, %rsp
+    movq %rdi, %r8
+    movq %rsi, %r9
+    movq %rdx, %r12
+    leaq (%rdx,%rcx), %r13
+    leaq 10(%rdx), %r14
+    cmpq %r13, %r14
+    ja .lotus_rt_gzip_full
+    movl $0x00088b1f, (%r12)  # magic, deflate, no flags
+    movl $0, 4(%r12)  # no mtime
+    movw $0x0300, 8(%r12)  # no extra flags, Unix
+    leaq {dhash}(%rbp), %rdi
+    movl $-1, %eax
+    movl ${hashsize}, %ecx
+    rep stosl
+    xorl %ebx, %ebx
+    xorl %r15d, %r15d
+    movl $3, %eax  # BFINAL, fixed Huffman
+    movl $3, %edx
+    call .lotus_rt_gzip_put
+    xorl %r10d, %r10d
+.lotus_rt_gzip_loop:
+    cmpq %r9, %r10
+    jae .lotus_rt_gzip_end
+    leaq 3(%r10), %rax
+    cmpq %r9, %rax
+    ja .lotus_rt_gzip_literal
+{hash}
+    movslq {dhash}(%rbp,%rax,4), %rsi
+    movl %r10d, {dhash}(%rbp,%rax,4)
+    testq %rsi, %rsi
+    js .lotus_rt_gzip_literal
+    movq %r10, %rdx
+    subq %rsi, %rdx
+    cmpq $32768, %rdx
+    ja .lotus_rt_gzip_literal
+    movq %r9, %rcx
+    subq %r10, %rcx
+    cmpq $258, %rcx
+    jbe {l23}
+    movl $258, %ecx
+{l23}:
+    leaq (%r8,%rsi), %rdi
+    leaq (%r8,%r10), %r11
+    xorl %eax, %eax
+{l24}:
+    cmpq %rcx, %rax
+    jae {l25}
+    movb (%rdi,%rax), %sil
+    cmpb %sil, (%r11,%rax)
+    jne {l25}
+    incq %rax
+    jmp {l24}
+{l25}:
+    cmpq $3, %rax
+    jb .lotus_rt_gzip_literal
+    movq %rax, {dlen}(%rbp)
+    movq %rdx, {ddist}(%rbp)
+    call .lotus_rt_gzip_match
+    movq {dlen}(%rbp), %rdi
+{l26}:
+    decq %rdi
+    jz {l27}
+    incq %r10
+    leaq 3(%r10), %rax
+    cmpq %r9, %rax
+    ja {l26}
+{hash}
+    movl %r10d, {dhash}(%rbp,%rax,4)
+    jmp {l26}
+{l27}:
+    incq %r10
+    jmp .lotus_rt_gzip_loop
+.lotus_rt_gzip_literal:
+    movzbl (%r8,%r10), %eax
+    call .lotus_rt_gzip_sym
+    incq %r10
+    jmp .lotus_rt_gzip_loop
+.lotus_rt_gzip_end:
+    movl $256, %eax  # end of block
+    call .lotus_rt_gzip_sym
+    testq %r15, %r15
+    jz {l28}
+    xorl %eax, %eax
+    movl $8, %edx
+    subq %r15, %rdx
+    call .lotus_rt_gzip_put
+{l28}:
+    movq %r9, %rax  # the stored form's size
+    addq ${maxstored}-1, %rax
+    xorl %edx, %edx
+    movl ${maxstored}, %ecx
+    divq %rcx
+    testq %rax, %rax
+    jnz {l29}
+    movl $1, %eax
+{l29}:
+    leaq (%rax,%rax,4), %rax
+    addq %r9, %rax
+    movq %r14, %rcx
+    subq %r12, %rcx
+    subq $10, %rcx
+    cmpq %rax, %rcx
+    ja .lotus_rt_gzip_stored
+    jmp .lotus_rt_gzip_trailer
+
+# The compressed form did not fit: store the input instead
+.lotus_rt_gzip_overflow:
+    leaq -40-{dframe}(%rbp), %rsp
+.lotus_rt_gzip_stored:
+    leaq 10(%r12), %r14
+    xorl %r10d, %r10d
+{l30}:
+    movq %r9, %rcx
+    subq %r10, %rcx
+    cmpq ${maxstored}, %rcx
+    jbe {l31}
+    movl ${maxstored}, %ecx
+{l31}:
+    leaq 5(%r14,%rcx), %rax
+    cmpq %r13, %rax
+    ja .lotus_rt_gzip_full
+    leaq (%r10,%rcx), %rax
+    cmpq %r9, %rax
+    sete %al  # BFINAL on the last block
+    movb %al, (%r14)
+    movw %cx, 1(%r14)
+    movl %ecx, %eax
+    notl %eax
+    movw %ax, 3(%r14)
+    addq $5, %r14
+    leaq (%r8,%r10), %rsi
+    movq %r14, %rdi
+    addq %rcx, %r10
+    rep movsb
+    movq %rdi, %r14
+    cmpq %r9, %r10
+    jb {l30}
+
+.lotus_rt_gzip_trailer:
+    leaq 8(%r14), %rax
+    cmpq %r13, %rax
+    ja .lotus_rt_gzip_full
+    movq %r8, %rsi
+    movq %r9, %rcx
+    call .lotus_rt_crc32
+    movl %eax, (%r14)
+    movl %r9d, 4(%r14)
+    addq $8, %r14
+    movq %r14, %rax
+    subq %r12, %rax
+.lotus_rt_gzip_return:
+    leaq -40(%rbp), %rsp
+    popq %r15
+    popq %r14
+    popq %r13
+    popq %r12
+    popq %rbx
+    popq %rbp
+    ret
+.lotus_rt_gzip_full:
+    movq $-105, %rax  # ENOBUFS
+    jmp .lotus_rt_gzip_return
+
+# Write the match of {dlen} bytes {ddist} back. Clobbers rax, rcx, rdx, rsi,
+# rdi, r11.
+.lotus_rt_gzip_match:
+    leaq .lotus_rt_lbase(%rip), %rsi
+    movl $28, %edi
+{l32}:
+    movzwq (%rsi,%rdi,2), %rax
+    cmpq {dlen}(%rbp), %rax
+    jbe {l33}
+    decq %rdi
+    jmp {l32}
+{l33}:
+    leaq 257(%rdi), %rax
+    call .lotus_rt_gzip_sym
+    leaq .lotus_rt_lbase(%rip), %rsi
+    movq {dlen}(%rbp), %rax
+    movzwq (%rsi,%rdi,2), %rcx
+    subq %rcx, %rax
+    leaq .lotus_rt_lext(%rip), %rsi
+    movzbq (%rsi,%rdi), %rdx
+    call .lotus_rt_gzip_put
+    leaq .lotus_rt_dbase(%rip), %rsi
+    movl $29, %edi
+{l34}:
+    movzwq (%rsi,%rdi,2), %rax
+    cmpq {ddist}(%rbp), %rax
+    jbe {l35}
+    decq %rdi
+    jmp {l34}
+{l35}:
+    movq %rdi, %rax
+    movl $5, %edx
+    call .lotus_rt_gzip_huff
+    leaq .lotus_rt_dbase(%rip), %rsi
+    movq {ddist}(%rbp), %rax
+    movzwq (%rsi,%rdi,2), %rcx
+    subq %rcx, %rax
+    leaq .lotus_rt_dext(%rip), %rsi
+    movzbq (%rsi,%rdi), %rdx
+    jmp .lotus_rt_gzip_put
+
+# Write lit/len symbol %rax with its fixed Huffman code. Clobbers rcx, rdx,
+# rsi, r11.
+.lotus_rt_gzip_sym:
+    cmpq $144, %rax
+    jae {l36}
+    addq $0x30, %rax
+    movl $8, %edx
+    jmp .lotus_rt_gzip_huff
+{l36}:
+    cmpq $256, %rax
+    jae {l37}
+    addq $0x100, %rax
+    movl $9, %edx
+    jmp .lotus_rt_gzip_huff
+{l37}:
+    cmpq $280, %rax
+    jae {l38}
+    subq $256, %rax
+    movl $7, %edx
+    jmp .lotus_rt_gzip_huff
+{l38}:
+    subq $88, %rax
+    movl $8, %edx
+
+# Write the %rdx-bit Huffman code %rax, most significant bit first
+.lotus_rt_gzip_huff:
+    xorl %esi, %esi
+    movq %rdx, %rcx
+{l39}:
+    shlq $1, %rsi
+    movq %rax, %r11
+    andl $1, %r11d
+    orq %r11, %rsi
+    shrq $1, %rax
+    decq %rcx
+    jnz {l39}
+    movq %rsi, %rax
+
+# Write the low %rdx bits of %rax, least significant first. Clobbers rcx.
+.lotus_rt_gzip_put:
+    movq %r15, %rcx
+    shlq %cl, %rax
+    orq %rax, %rbx
+    addq %rdx, %r15
+{l40}:
+    cmpq $8, %r15
+    jb {l41}
+    cmpq %r13, %r14
+    jae .lotus_rt_gzip_overflow
+    movb %bl, (%r14)
+    incq %r14
+    shrq $8, %rbx
+    subq $8, %r15
+    jmp {l40}
+{l41}:
+    ret
+`))
+}
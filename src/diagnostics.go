@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
@@ -46,6 +48,17 @@ type Diagnostic struct {
 	Context    string   // Source line(s) for context
 	Suggestion string   // Suggested fix
 	Notes      []string // Additional notes/hints
+	Fixes      []FixIt  // Machine-applicable edits implementing Suggestion
+}
+
+// FixIt is a source edit that resolves a diagnostic. Positions are 1-based
+// and EndColumn is exclusive, matching the JSON range format.
+type FixIt struct {
+	Line        int
+	Column      int
+	EndLine     int
+	EndColumn   int
+	Replacement string
 }
 
 // DiagnosticManager collects and reports compiler diagnostics
@@ -58,6 +71,7 @@ type DiagnosticManager struct {
 	SuppressWarns bool                // -w: suppress warnings
 	UseColor      bool                // Enable colored output
 	SourceLines   map[string][]string // Cache of source file lines
+	FilePath      string              // File currently being compiled (used by codegen-time diagnostics)
 }
 
 // NewDiagnosticManager creates a new diagnostic manager with defaults
@@ -98,6 +112,9 @@ func (dm *DiagnosticManager) AddErrorWithCode(code string, category DiagnosticCa
 		return // Stop collecting after max errors
 	}
 
+	if context == "" {
+		context = dm.getSourceLine(filePath, line)
+	}
 	dm.Diagnostics = append(dm.Diagnostics, Diagnostic{
 		Level:    DiagnosticError,
 		Category: category,
@@ -161,6 +178,32 @@ func (dm *DiagnosticManager) AddWarningWithCategory(category DiagnosticCategory,
 	})
 }
 
+// AddDiagnostic adds a fully populated diagnostic, applying -w, -Werror, and
+// the max-errors limit the same way the specialised helpers do
+func (dm *DiagnosticManager) AddDiagnostic(diag Diagnostic) {
+	switch diag.Level {
+	case DiagnosticError:
+		if dm.ErrorCount >= dm.MaxErrors {
+			return
+		}
+		dm.ErrorCount++
+	case DiagnosticWarning:
+		if dm.SuppressWarns {
+			return
+		}
+		if dm.TreatWarnErr {
+			diag.Level = DiagnosticError
+			dm.ErrorCount++
+		} else {
+			dm.WarnCount++
+		}
+	}
+	if diag.Context == "" && diag.Line > 0 {
+		diag.Context = dm.getSourceLine(diag.FilePath, diag.Line)
+	}
+	dm.Diagnostics = append(dm.Diagnostics, diag)
+}
+
 func (dm *DiagnosticManager) HasErrors() bool {
 	return dm.ErrorCount > 0
 }
@@ -266,7 +309,7 @@ func (dm *DiagnosticManager) printDiagnostic(diag Diagnostic) {
 
 	// Print location and message
 	if diag.FilePath != "" {
-		fmt.Fprintf(os.Stderr, "%s%s:%d:%d:%s %s%s%s:%s %s\n",
+		fmt.Fprintf(os.Stderr, "%s%s:%d:%d:%s %s%s%s: %s%s\n",
 			boldCode, diag.FilePath, diag.Line, diag.Column, resetColor,
 			colorCode, levelStr, resetColor,
 			codeStr, diag.Message)
@@ -347,3 +390,115 @@ func (dm *DiagnosticManager) AddHint(message, filePath string, line, column int,
 		Context:  context,
 	})
 }
+
+// String returns the lowercase name used for the level in text and JSON output
+func (l DiagnosticLevel) String() string {
+	switch l {
+	case DiagnosticError:
+		return "error"
+	case DiagnosticWarning:
+		return "warning"
+	case DiagnosticInfo:
+		return "info"
+	case DiagnosticHint:
+		return "hint"
+	default:
+		return "unknown"
+	}
+}
+
+// JSON diagnostic schema (version 1). Positions are 1-based; range ends are exclusive.
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type jsonRange struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonFix struct {
+	Range       jsonRange `json:"range"`
+	Replacement string    `json:"replacement"`
+}
+
+type jsonDiagnostic struct {
+	File       string    `json:"file"`
+	Range      jsonRange `json:"range"`
+	Severity   string    `json:"severity"`
+	Code       string    `json:"code,omitempty"`
+	Category   string    `json:"category,omitempty"`
+	Message    string    `json:"message"`
+	Suggestion string    `json:"suggestion,omitempty"`
+	Notes      []string  `json:"notes,omitempty"`
+	Fixes      []jsonFix `json:"fixes,omitempty"`
+}
+
+type jsonReport struct {
+	Version     int              `json:"version"`
+	Errors      int              `json:"errors"`
+	Warnings    int              `json:"warnings"`
+	Diagnostics []jsonDiagnostic `json:"diagnostics"`
+}
+
+// diagnosticRange computes the reported span, defaulting to a single column
+func diagnosticRange(diag Diagnostic) jsonRange {
+	endLine := diag.EndLine
+	if endLine == 0 {
+		endLine = diag.Line
+	}
+	endCol := diag.EndColumn
+	if endCol <= diag.Column && endLine == diag.Line {
+		endCol = diag.Column + 1
+	}
+	return jsonRange{
+		Start: jsonPosition{Line: diag.Line, Column: diag.Column},
+		End:   jsonPosition{Line: endLine, Column: endCol},
+	}
+}
+
+// jsonFixes converts fix-its to their JSON form
+func jsonFixes(fixes []FixIt) []jsonFix {
+	if len(fixes) == 0 {
+		return nil
+	}
+	out := make([]jsonFix, 0, len(fixes))
+	for _, fix := range fixes {
+		out = append(out, jsonFix{
+			Range: jsonRange{
+				Start: jsonPosition{Line: fix.Line, Column: fix.Column},
+				End:   jsonPosition{Line: fix.EndLine, Column: fix.EndColumn},
+			},
+			Replacement: fix.Replacement,
+		})
+	}
+	return out
+}
+
+// PrintJSON writes all diagnostics to w as a single JSON document
+func (dm *DiagnosticManager) PrintJSON(w io.Writer) error {
+	report := jsonReport{
+		Version:     1,
+		Errors:      dm.ErrorCount,
+		Warnings:    dm.WarnCount,
+		Diagnostics: make([]jsonDiagnostic, 0, len(dm.Diagnostics)),
+	}
+	for _, diag := range dm.Diagnostics {
+		report.Diagnostics = append(report.Diagnostics, jsonDiagnostic{
+			File:       diag.FilePath,
+			Range:      diagnosticRange(diag),
+			Severity:   diag.Level.String(),
+			Code:       diag.Code,
+			Category:   string(diag.Category),
+			Message:    diag.Message,
+			Suggestion: diag.Suggestion,
+			Notes:      diag.Notes,
+			Fixes:      jsonFixes(diag.Fixes),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"debug/elf"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// disasm.go - `lotus disasm` annotated disassembly
+// Prints the instructions of a binary, or of a .s file kept with -S, with each
+// Lotus source line shown above the code generated for it. Lines come from the
+// table -g adds: .loc directives in a .s file, the DWARF line table in a
+// binary. Binaries are disassembled with objdump.
+
+func init() {
+	Subcommands["disasm"] = &Subcommand{
+		Name:    "disasm",
+		Summary: "disassemble a binary or .s file, interleaving source lines ([-fn name] file)",
+		Run:     runDisasm,
+	}
+}
+
+// disasmLine is the source position of an address in a binary
+type disasmLine struct {
+	addr uint64
+	file string
+	line int
+	end  bool // End of a sequence: the address has no source line
+}
+
+var (
+	objdumpSymbol = regexp.MustCompile(`^[0-9a-f]+ <(.+)>:$`)
+	objdumpInsn   = regexp.MustCompile(`^\s*([0-9a-f]+):\s*(.*)$`)
+	asmFile       = regexp.MustCompile(`^\s*\.file\s+(\d+)\s+"(.*)"`)
+	asmLoc        = regexp.MustCompile(`^\s*\.loc\s+(\d+)\s+(\d+)`)
+)
+
+// runDisasm implements `lotus disasm [-fn name] binary|file.s`
+func runDisasm(args []string) int {
+	fs := flag.NewFlagSet("lotus disasm", flag.ContinueOnError)
+	fn := fs.String("fn", "", "only show `function`")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: lotus disasm [-fn name] binary|file.s")
+		return 2
+	}
+
+	path := fs.Arg(0)
+	var err error
+	if strings.HasSuffix(path, ".s") {
+		err = disasmAssembly(os.Stdout, path, *fn)
+	} else {
+		err = disasmBinary(os.Stdout, path, *fn)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// sourceLines caches source files for printing lines from them
+type sourceLines map[string][]string
+
+// text returns line n of file, or "" if the file cannot be read
+func (s sourceLines) text(file string, n int) string {
+	lines, ok := s[file]
+	if !ok {
+		if data, err := os.ReadFile(file); err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		s[file] = lines
+	}
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[n-1])
+}
+
+// printSourceLine writes the heading shown above a source line's code
+func (s sourceLines) printSourceLine(w io.Writer, file, display string, n int) {
+	fmt.Fprintf(w, "  %s:%d", display, n)
+	if text := s.text(file, n); text != "" {
+		fmt.Fprintf(w, "  %s", text)
+	}
+	fmt.Fprintln(w)
+}
+
+// disasmAssembly prints a .s file, skipping directives, with source lines
+// taken from its .loc directives
+func disasmAssembly(w io.Writer, path, fn string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+
+	// .file paths are relative to where the compiler ran, which is usually
+	// where the .s file was written
+	files := make(map[string]string)
+	for _, line := range lines {
+		if m := asmFile.FindStringSubmatch(line); m != nil {
+			files[m[1]] = m[2]
+		}
+	}
+	resolve := func(file string) string {
+		if _, err := os.Stat(file); err != nil && !filepath.IsAbs(file) {
+			return filepath.Join(filepath.Dir(path), file)
+		}
+		return file
+	}
+
+	var label string
+	if fn != "" {
+		if !strings.Contains(string(data), "@function") {
+			return fmt.Errorf("%s has no function symbols; -fn needs a build with -g", path)
+		}
+		label = asmFunctionLabel(lines, fn)
+		if label == "" {
+			return fmt.Errorf("no function %s in %s", fn, path)
+		}
+	}
+
+	source := make(sourceLines)
+	inside := fn == ""
+	lastFile, lastLine := "", 0
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !inside {
+			inside = trimmed == label+":"
+			if !inside {
+				continue
+			}
+		}
+		if m := asmLoc.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			if file := files[m[1]]; file != lastFile || n != lastLine {
+				lastFile, lastLine = file, n
+				source.printSourceLine(w, resolve(file), file, n)
+			}
+			continue
+		}
+		if fn != "" && strings.HasPrefix(trimmed, ".size "+label+",") {
+			break
+		}
+		if trimmed == "" || (strings.HasPrefix(trimmed, ".") && !strings.HasSuffix(trimmed, ":")) {
+			continue
+		}
+		if strings.HasSuffix(trimmed, ":") {
+			fmt.Fprintln(w, trimmed)
+		} else {
+			fmt.Fprintf(w, "      %s\n", trimmed)
+		}
+	}
+	return nil
+}
+
+// asmFunctionLabel returns the label of function fn in a .s file, or ""
+func asmFunctionLabel(lines []string, fn string) string {
+	for _, line := range lines {
+		fields := strings.Fields(strings.ReplaceAll(line, ",", " "))
+		if len(fields) == 3 && fields[0] == ".type" && fields[2] == "@function" &&
+			(fields[1] == fn || fields[1] == "."+fn) {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// disasmBinary prints objdump's disassembly of a binary with source lines
+// taken from its DWARF line table
+func disasmBinary(w io.Writer, path, fn string) error {
+	if _, err := exec.LookPath("objdump"); err != nil {
+		return fmt.Errorf("'objdump' not found in PATH; it is required to disassemble binaries")
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	args := []string{"-d", "--no-show-raw-insn"}
+	if fn != "" {
+		start, end, err := elfFunctionRange(f, fn)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		args = append(args, fmt.Sprintf("--start-address=0x%x", start), fmt.Sprintf("--stop-address=0x%x", end))
+	}
+	table, compDir := readLineTable(f)
+	if table == nil {
+		fmt.Fprintf(os.Stderr, "Note: %s has no line table; build it with -g to see source lines\n", path)
+	}
+
+	cmd := exec.Command("objdump", append(args, path)...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	source := make(sourceLines)
+	var last *disasmLine
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := objdumpSymbol.FindStringSubmatch(line); m != nil {
+			fmt.Fprintf(w, "\n%s:\n", m[1])
+			continue
+		}
+		m := objdumpInsn.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		addr, _ := strconv.ParseUint(m[1], 16, 64)
+		if at := lookupLine(table, addr); at != nil && (last == nil || at.file != last.file || at.line != last.line) {
+			display := at.file
+			if rel, err := filepath.Rel(compDir, at.file); err == nil && !strings.HasPrefix(rel, "..") {
+				display = rel
+			}
+			source.printSourceLine(w, at.file, display, at.line)
+			last = at
+		}
+		fmt.Fprintf(w, "    %6x:  %s\n", addr, strings.TrimSpace(m[2]))
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("objdump: %v", err)
+	}
+	return scanner.Err()
+}
+
+// elfFunctionRange returns the addresses spanned by function fn, which only
+// -g builds record
+func elfFunctionRange(f *elf.File, fn string) (uint64, uint64, error) {
+	symbols, err := f.Symbols()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, sym := range symbols {
+		if sym.Name != fn && sym.Name != "."+fn {
+			continue
+		}
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Size == 0 {
+			return 0, 0, fmt.Errorf("the size of %s is not recorded; -fn needs a build with -g", fn)
+		}
+		return sym.Value, sym.Value + sym.Size, nil
+	}
+	return 0, 0, fmt.Errorf("no function %s", fn)
+}
+
+// readLineTable returns the rows of a binary's DWARF line table sorted by
+// address, and the directory it was compiled in, or nil if it has none
+func readLineTable(f *elf.File) ([]disasmLine, string) {
+	d, err := f.DWARF()
+	if err != nil {
+		return nil, ""
+	}
+	var table []disasmLine
+	compDir := ""
+	r := d.Reader()
+	for {
+		cu, err := r.Next()
+		if err != nil || cu == nil {
+			break
+		}
+		if cu.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		if dir, ok := cu.Val(dwarf.AttrCompDir).(string); ok && compDir == "" {
+			compDir = dir
+		}
+		lr, err := d.LineReader(cu)
+		if err != nil || lr == nil {
+			continue
+		}
+		var entry dwarf.LineEntry
+		for lr.Next(&entry) == nil {
+			row := disasmLine{addr: entry.Address, line: entry.Line, end: entry.EndSequence}
+			if entry.File != nil {
+				row.file = entry.File.Name
+			}
+			table = append(table, row)
+		}
+	}
+	sort.SliceStable(table, func(i, j int) bool { return table[i].addr < table[j].addr })
+	return table, compDir
+}
+
+// lookupLine returns the line table row covering addr, or nil
+func lookupLine(table []disasmLine, addr uint64) *disasmLine {
+	i := sort.Search(len(table), func(i int) bool { return table[i].addr > addr })
+	if i == 0 || table[i-1].end {
+		return nil
+	}
+	return &table[i-1]
+}
@@ -0,0 +1,109 @@
+package main
+
+// distance.go - Edit distance runtime
+// str.distance and str.similar call this routine, which is appended to the
+// program once when either is used:
+//
+//	.lotus_rt_distance  a %rdi, b %rsi
+//
+// It returns in %rax the Levenshtein distance between the two strings, the
+// fewest single-byte insertions, deletions and substitutions that turn one
+// into the other, as the compiler's "did you mean" suggestions measure it.
+// Only two rows of the table are kept, on the stack, 8 bytes for each byte of
+// the shorter string. It makes no system calls and preserves %rbx, %rbp and
+// %r12-%r15.
+
+// useDistance appends the edit distance runtime to the program
+func (cg *CodeGenerator) useDistance() {
+	cg.distance = true
+}
+
+// distanceRuntime returns .lotus_rt_distance
+func (cg *CodeGenerator) distanceRuntime() string {
+	return cg.localLabels("lotus_rt_distance", `
+# ---- edit distance ----
+.lotus_rt_distance:
+    pushq %rbp
+    movq %rsp, %rbp
+    pushq %r12
+    pushq %r13
+    pushq %r14
+    pushq %r15
+    # %r12 = len(a), %r13 = len(b)
+    xorq %r12, %r12
+{l1}:
+    cmpb $0, (%rdi,%r12)
+    je {l2}
+    incq %r12
+    jmp {l1}
+{l2}:
+    xorq %r13, %r13
+{l3}:
+    cmpb $0, (%rsi,%r13)
+    je {l4}
+    incq %r13
+    jmp {l3}
+{l4}:
+    # The rows run along the shorter string
+    cmpq %r12, %r13
+    jbe {l5}
+    xchgq %rdi, %rsi
+    xchgq %r12, %r13
+{l5}:
+    # %r14 = previous row, %r15 = current row, len(b)+1 quads each
+    leaq 8(,%r13,8), %rcx
+    subq %rcx, %rsp
+    movq %rsp, %r14
+    subq %rcx, %rsp
+    movq %rsp, %r15
+    xorq %rcx, %rcx
+{l6}:
+    movq %rcx, (%r14,%rcx,8)
+    incq %rcx
+    cmpq %r13, %rcx
+    jbe {l6}
+    xorq %r8, %r8  # row i-1
+{l7}:
+    cmpq %r12, %r8
+    jae {l10}
+    leaq 1(%r8), %rax
+    movq %rax, (%r15)
+    movzbl (%rdi,%r8), %r9d
+    xorq %rcx, %rcx  # column j-1
+{l8}:
+    cmpq %r13, %rcx
+    jae {l9}
+    # Substitution, or a match
+    xorl %r10d, %r10d
+    cmpb (%rsi,%rcx), %r9b
+    setne %r10b
+    movq (%r14,%rcx,8), %rax
+    addq %r10, %rax
+    # Deletion
+    movq 8(%r14,%rcx,8), %rdx
+    incq %rdx
+    cmpq %rax, %rdx
+    cmovbq %rdx, %rax
+    # Insertion
+    movq (%r15,%rcx,8), %rdx
+    incq %rdx
+    cmpq %rax, %rdx
+    cmovbq %rdx, %rax
+    movq %rax, 8(%r15,%rcx,8)
+    incq %rcx
+    jmp {l8}
+{l9}:
+    xchgq %r14, %r15
+    incq %r8
+    jmp {l7}
+{l10}:
+    movq (%r14,%r13,8), %rax
+    leaq -32(%rbp), %rsp
+    popq %r15
+    popq %r14
+    popq %r13
+    popq %r12
+    popq %rbp
+    ret
+`)
+}
@@ -511,6 +511,15 @@ Multiple Parameters:
       ret a + b;
   }
 
+Variadic Parameters (trailing arguments as a read-only array_int):
+  fn int sum(int... xs) {
+      int total = 0;
+      for (int i = 0; i < collections::array_int_len(xs); i += 1) {
+          total += collections::array_int_get(xs, i);
+      }
+      ret total;
+  }
+
 Calling Functions:
   result: int = square(5);
   sum: int = add(10, 20);
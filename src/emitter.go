@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// emitter.go - Checked assembly output
+// Code generation has written instructions as formatted strings, so a wrong
+// register name, an operand of the wrong width or a label defined twice only
+// showed up when the assembler rejected the output, or not at all. An Emitter
+// builds each instruction from typed parts instead and checks them as it
+// goes: registers must exist, moves must have operands of one width,
+// immediates that do not fit an instruction get the form that takes them,
+// condition codes must be real, and a label can only be defined once.
+//
+//	asm := cg.asm()
+//	asm.MovImmReg(exitNr, "rax")
+//	asm.Jcc("nz", okLabel)
+//	asm.Label(okLabel)
+//
+// A problem is reported as an internal compiler error at the point the
+// instruction is built, and the instruction is left out. Registers are named
+// without the %, as elsewhere in the code generator.
+
+// Emitter writes checked instructions to a section of the program
+type Emitter struct {
+	out *strings.Builder
+	cg  *CodeGenerator
+}
+
+// asm returns an Emitter writing to the text section
+func (cg *CodeGenerator) asm() *Emitter {
+	return cg.emitterFor(&cg.textSection)
+}
+
+// emitterFor returns an Emitter writing to out
+func (cg *CodeGenerator) emitterFor(out *strings.Builder) *Emitter {
+	if cg.definedLabels == nil {
+		cg.definedLabels = make(map[string]bool)
+	}
+	return &Emitter{out: out, cg: cg}
+}
+
+// registerWidths gives the width in bytes of each general purpose register
+var registerWidths = func() map[string]int {
+	widths := make(map[string]int)
+	for _, r := range []string{"ax", "bx", "cx", "dx", "si", "di", "bp", "sp"} {
+		widths["r"+r] = 8
+		widths["e"+r] = 4
+		widths[r] = 2
+	}
+	for _, r := range []string{"al", "bl", "cl", "dl", "sil", "dil", "bpl", "spl"} {
+		widths[r] = 1
+	}
+	for i := 8; i <= 15; i++ {
+		widths[fmt.Sprintf("r%d", i)] = 8
+		widths[fmt.Sprintf("r%dd", i)] = 4
+		widths[fmt.Sprintf("r%dw", i)] = 2
+		widths[fmt.Sprintf("r%db", i)] = 1
+	}
+	return widths
+}()
+
+// widthSuffixes is the AT&T size suffix for each operand width
+var widthSuffixes = map[int]string{1: "b", 2: "w", 4: "l", 8: "q"}
+
+// conditionCodes are the conditions Jcc and Setcc accept
+var conditionCodes = map[string]bool{
+	"e": true, "ne": true, "z": true, "nz": true, "s": true, "ns": true, "o": true, "no": true,
+	"l": true, "le": true, "g": true, "ge": true, "b": true, "be": true, "a": true, "ae": true,
+}
+
+var labelPattern = regexp.MustCompile(`^\.?[A-Za-z_][A-Za-z0-9_.$]*$`)
+
+// fail reports an instruction the emitter refuses to write
+func (e *Emitter) fail(format string, args ...interface{}) {
+	e.cg.diagnostics.AddErrorWithCode("", CategoryGeneral,
+		"internal error: "+fmt.Sprintf(format, args...), e.cg.diagnostics.FilePath, 0, 0, "")
+}
+
+// reg checks a register name and returns its width, or 0 when it is not one
+func (e *Emitter) reg(name string) int {
+	width, ok := registerWidths[name]
+	if !ok {
+		e.fail("no register named %q", name)
+	}
+	return width
+}
+
+// label checks a label name
+func (e *Emitter) label(name string) bool {
+	if !labelPattern.MatchString(name) {
+		e.fail("malformed label %q", name)
+		return false
+	}
+	return true
+}
+
+func (e *Emitter) line(format string, args ...interface{}) {
+	e.out.WriteString("    " + fmt.Sprintf(format, args...) + "\n")
+}
+
+// Comment writes a comment line
+func (e *Emitter) Comment(text string) {
+	e.line("# %s", strings.ReplaceAll(text, "\n", " "))
+}
+
+// Label defines a label, which must not have been defined before
+func (e *Emitter) Label(name string) {
+	if !e.label(name) {
+		return
+	}
+	if e.cg.definedLabels[name] {
+		e.fail("label %s defined twice", name)
+		return
+	}
+	e.cg.definedLabels[name] = true
+	e.out.WriteString(name + ":\n")
+}
+
+// MovRegReg copies src to dst, registers of the same width
+func (e *Emitter) MovRegReg(src, dst string) {
+	sw, dw := e.reg(src), e.reg(dst)
+	if sw == 0 || dw == 0 {
+		return
+	}
+	if sw != dw {
+		e.fail("mov from %d-byte %s to %d-byte %s", sw, src, dw, dst)
+		return
+	}
+	e.line("mov%s %%%s, %%%s", widthSuffixes[sw], src, dst)
+}
+
+// MovImmReg loads a constant into dst, with movabsq when it does not fit in
+// the sign-extended 32 bits movq takes
+func (e *Emitter) MovImmReg(imm int, dst string) {
+	w := e.reg(dst)
+	switch {
+	case w == 0:
+	case w == 8 && (imm < math.MinInt32 || imm > math.MaxInt32):
+		e.line("movabsq $%d, %%%s", imm, dst)
+	case w < 8 && (imm < -(1<<(8*w-1)) || imm >= 1<<(8*w)):
+		e.fail("constant %d does not fit in %d-byte %s", imm, w, dst)
+	default:
+		e.line("mov%s $%d, %%%s", widthSuffixes[w], imm, dst)
+	}
+}
+
+// Load copies the value at disp(base) into dst, at dst's width
+func (e *Emitter) Load(disp int, base, dst string) {
+	bw, dw := e.reg(base), e.reg(dst)
+	if bw == 0 || dw == 0 {
+		return
+	}
+	if bw != 8 {
+		e.fail("address register %s is not 64-bit", base)
+		return
+	}
+	e.line("mov%s %d(%%%s), %%%s", widthSuffixes[dw], disp, base, dst)
+}
+
+// Store copies src to disp(base), at src's width
+func (e *Emitter) Store(src string, disp int, base string) {
+	sw, bw := e.reg(src), e.reg(base)
+	if sw == 0 || bw == 0 {
+		return
+	}
+	if bw != 8 {
+		e.fail("address register %s is not 64-bit", base)
+		return
+	}
+	e.line("mov%s %%%s, %d(%%%s)", widthSuffixes[sw], src, disp, base)
+}
+
+// LeaLabel loads the address of a label into dst
+func (e *Emitter) LeaLabel(label, dst string) {
+	if !e.label(label) {
+		return
+	}
+	if w := e.reg(dst); w != 0 && w != 8 {
+		e.fail("address loaded into %d-byte %s", w, dst)
+		return
+	}
+	e.line("leaq %s(%%rip), %%%s", label, dst)
+}
+
+// subRegister returns the width-byte register within 64-bit reg: ecx, cx
+// or cl for rcx and width 4, 2 or 1
+func subRegister(reg string, width int) string {
+	for name, w := range registerWidths {
+		if w == width && registerFamilies[name] == reg {
+			return name
+		}
+	}
+	return reg
+}
+
+// ByteSwap reverses the order of the bytes of reg, at its width. A 2-byte
+// swap also zeroes the register above them, as the 4- and 8-byte ones do.
+func (e *Emitter) ByteSwap(reg string) {
+	switch w := e.reg(reg); w {
+	case 0:
+	case 2:
+		e.line("rolw $8, %%%s", reg)
+		e.line("movzwl %%%s, %%%s", reg, subRegister(registerFamilies[reg], 4))
+	case 4, 8:
+		e.line("bswap%s %%%s", widthSuffixes[w], reg)
+	default:
+		e.fail("byte swap of 1-byte %s", reg)
+	}
+}
+
+// TestRegReg sets the flags from a & b
+func (e *Emitter) TestRegReg(a, b string) {
+	aw, bw := e.reg(a), e.reg(b)
+	if aw == 0 || bw == 0 {
+		return
+	}
+	if aw != bw {
+		e.fail("test of %d-byte %s with %d-byte %s", aw, a, bw, b)
+		return
+	}
+	e.line("test%s %%%s, %%%s", widthSuffixes[aw], a, b)
+}
+
+// CmpImmReg sets the flags from reg - imm
+func (e *Emitter) CmpImmReg(imm int, reg string) {
+	if w := e.reg(reg); w != 0 {
+		if imm < math.MinInt32 || imm > math.MaxInt32 {
+			e.fail("cmp with constant %d, which needs a register", imm)
+			return
+		}
+		e.line("cmp%s $%d, %%%s", widthSuffixes[w], imm, reg)
+	}
+}
+
+// Jmp jumps to a label
+func (e *Emitter) Jmp(label string) {
+	if e.label(label) {
+		e.line("jmp %s", label)
+	}
+}
+
+// Jcc jumps to a label when condition cond holds: e, ne, l, ge, ...
+func (e *Emitter) Jcc(cond, label string) {
+	if !conditionCodes[cond] {
+		e.fail("no condition code %q", cond)
+		return
+	}
+	if e.label(label) {
+		e.line("j%s %s", cond, label)
+	}
+}
+
+// Call calls a function by label
+func (e *Emitter) Call(label string) {
+	if e.label(label) {
+		e.line("call %s", label)
+	}
+}
+
+// CallRuntime calls a routine of the appended runtime: .lotus_rt_<name>
+func (e *Emitter) CallRuntime(name string) {
+	e.Call(".lotus_rt_" + name)
+}
+
+// Syscall makes the system call whose number is in rax
+func (e *Emitter) Syscall() {
+	e.line("syscall")
+}
+
+// LoadSyscall loads the target's number for syscall name into rax. On a
+// freestanding target, where every syscall is already an error, it writes a
+// comment in its place.
+func (e *Emitter) LoadSyscall(name string) {
+	nr, ok := e.cg.target.Syscall(name)
+	switch {
+	case !ok && e.cg.target.Freestanding():
+		e.Comment("syscall: " + name)
+	case !ok:
+		e.fail("%s has no %s system call", e.cg.target.Triple, name)
+	case nr == 0:
+		e.line("xorq %%rax, %%rax  # syscall: %s", name)
+	default:
+		e.line("movq $%d, %%rax  # syscall: %s", nr, name)
+	}
+}
+
+// Ret returns from the current function
+func (e *Emitter) Ret() {
+	e.line("ret")
+}
+
+// localLabelPlaceholder matches a local label in a runtime template
+var localLabelPlaceholder = regexp.MustCompile(`\{l[0-9]+\}`)
+
+// localLabels gives each placeholder {l1}, {l2}, ... in code a label from
+// getLabel. Runtimes are written out as whole templates, so their local
+// labels are placeholders rather than getLabel calls of their own.
+func (cg *CodeGenerator) localLabels(prefix, code string) string {
+	labels := make(map[string]string)
+	return localLabelPlaceholder.ReplaceAllStringFunc(code, func(placeholder string) string {
+		if labels[placeholder] == "" {
+			labels[placeholder] = cg.getLabel(prefix)
+		}
+		return labels[placeholder]
+	})
+}
@@ -106,6 +106,8 @@ func (cg *CodeGenerator) generateTryStatement(stmt *TryStatement) {
 
 // generateThrowStatement generates code for throwing an exception
 func (cg *CodeGenerator) generateThrowStatement(stmt *ThrowStatement) {
+	start := cg.textSection.Len()
+	defer cg.noteSyscallOrigin(start, "throw", stmt.Loc())
 	cg.textSection.WriteString("    # Throw exception\n")
 
 	// Evaluate exception expression to rax
@@ -115,7 +117,7 @@ func (cg *CodeGenerator) generateThrowStatement(stmt *ThrowStatement) {
 	// In a full implementation, this would unwind the stack to the nearest catch
 	cg.textSection.WriteString("    # Exception thrown - exiting with error code\n")
 	cg.textSection.WriteString("    movq %rax, %rdi\n")
-	cg.textSection.WriteString("    movq $60, %rax\n")
+	cg.asm().LoadSyscall("exit")
 	cg.textSection.WriteString("    syscall\n")
 }
 
@@ -140,7 +142,7 @@ func (cg *CodeGenerator) generateNullCheck(expr ASTNode) {
 	cg.textSection.WriteString(fmt.Sprintf("%s:\n", checkLabel))
 	cg.textSection.WriteString("    # Null pointer exception\n")
 	cg.textSection.WriteString("    movq $1, %rdi  # Exit code 1 for null pointer\n")
-	cg.textSection.WriteString("    movq $60, %rax\n")
+	cg.asm().LoadSyscall("exit")
 	cg.textSection.WriteString("    syscall\n")
 
 	cg.textSection.WriteString(fmt.Sprintf("%s:\n", okLabel))
@@ -21,6 +21,7 @@ const (
 	ErrInvalidExpression   ErrorCode = "E0105"
 	ErrMissingFunctionBody ErrorCode = "E0106"
 	ErrInvalidDeclaration  ErrorCode = "E0107"
+	ErrInvalidAttribute    ErrorCode = "E0108"
 
 	// Semantic errors (E02xx)
 	ErrUndefinedVariable ErrorCode = "E0201"
@@ -28,16 +29,28 @@ const (
 	ErrRedefinition      ErrorCode = "E0203"
 	ErrTypeMismatch      ErrorCode = "E0204"
 	ErrInvalidOperation  ErrorCode = "E0205"
+	ErrUndefinedLabel    ErrorCode = "E0206"
+	ErrArgumentCount     ErrorCode = "E0207"
 
 	// Type errors (E03xx)
 	ErrIncompatibleTypes ErrorCode = "E0301"
 	ErrInvalidCast       ErrorCode = "E0302"
 	ErrArrayIndexType    ErrorCode = "E0303"
+	ErrPossiblyNull      ErrorCode = "E0304"
 
 	// Import errors (E04xx)
 	ErrModuleNotFound      ErrorCode = "E0401"
 	ErrFunctionNotExported ErrorCode = "E0402"
 	ErrCircularImport      ErrorCode = "E0403"
+
+	// Target errors (E05xx)
+	ErrRequiresOS     ErrorCode = "E0501"
+	ErrDynamicSyscall ErrorCode = "E0502"
+	ErrMissingLibrary ErrorCode = "E0503"
+
+	// Compile-time evaluation errors (E06xx)
+	ErrComptime ErrorCode = "E0601"
+	ErrInclude  ErrorCode = "E0602"
 )
 
 // TokenTypeName returns a human-readable name for a token type
@@ -79,6 +92,7 @@ func TokenTypeName(t TokenType) string {
 		TokenPipe:       "'|'",
 		TokenCaret:      "'^'",
 		TokenTilde:      "'~'",
+		TokenAt:         "'@'",
 		TokenLShift:     "'<<'",
 		TokenRShift:     "'>>'",
 		TokenFn:         "'fn'",
@@ -91,6 +105,8 @@ func TokenTypeName(t TokenType) string {
 		TokenConst:      "'const'",
 		TokenUse:        "'use'",
 		TokenAs:         "'as'",
+		TokenComptime:   "'comptime'",
+		TokenGoto:       "'goto'",
 		TokenStruct:     "'struct'",
 		TokenEnum:       "'enum'",
 		TokenClass:      "'class'",
@@ -127,13 +143,19 @@ type ParseError struct {
 	FilePath   string
 	Line       int
 	Column     int
+	EndColumn  int    // Column just past the offending token (0 if unknown)
 	Context    string // The source line
 	Suggestion string
 	Notes      []string
+	Fixes      []FixIt // Machine-applicable edits for the suggestion
 }
 
 func (e *ParseError) Error() string {
-	return e.Message
+	msg := fmt.Sprintf("[%s] line %d, col %d: %s", e.Code, e.Line, e.Column, e.Message)
+	if e.Suggestion != "" {
+		msg += "\n  help: " + e.Suggestion
+	}
+	return msg
 }
 
 // NewParseError creates a detailed parse error
@@ -146,6 +168,25 @@ func NewParseError(code ErrorCode, msg string, line, col int) *ParseError {
 	}
 }
 
+// Diagnostic converts the parse error into a diagnostic for filePath
+func (e *ParseError) Diagnostic(filePath string) Diagnostic {
+	return Diagnostic{
+		Level:      DiagnosticError,
+		Category:   CategorySyntax,
+		Code:       string(e.Code),
+		Message:    e.Message,
+		FilePath:   filePath,
+		Line:       e.Line,
+		Column:     e.Column,
+		EndLine:    e.Line,
+		EndColumn:  e.EndColumn,
+		Context:    e.Context,
+		Suggestion: e.Suggestion,
+		Fixes:      e.Fixes,
+		Notes:      e.Notes,
+	}
+}
+
 // WithContext adds source context to the error
 func (e *ParseError) WithContext(context string) *ParseError {
 	e.Context = context
@@ -158,6 +199,12 @@ func (e *ParseError) WithSuggestion(suggestion string) *ParseError {
 	return e
 }
 
+// WithFix attaches a machine-applicable edit to the error
+func (e *ParseError) WithFix(fix FixIt) *ParseError {
+	e.Fixes = append(e.Fixes, fix)
+	return e
+}
+
 // WithNote adds a note to the error
 func (e *ParseError) WithNote(note string) *ParseError {
 	e.Notes = append(e.Notes, note)
@@ -197,7 +244,7 @@ func SuggestForMissingBrace(openOrClose string) string {
 func SuggestForTypo(typo string) string {
 	keywords := []string{
 		"fn", "ret", "return", "if", "elif", "else", "while", "for",
-		"break", "continue", "use", "const", "true", "false", "nil",
+		"break", "continue", "use", "const", "comptime", "goto", "true", "false", "nil",
 		"int", "int8", "int16", "int32", "int64",
 		"uint", "uint8", "uint16", "uint32", "uint64",
 		"float", "float32", "float64", "string", "bool", "void",
@@ -248,6 +295,16 @@ Common causes:
 Every opening brace must have a matching closing brace.
 Check your function bodies, if statements, and loops.`,
 
+		ErrInvalidAttribute: `An attribute is unknown, misplaced, or has bad arguments.
+Attributes go on the lines before a function or constant:
+  @section(".boot")  // Place the symbol in a named section
+  @align(4096)       // Align the symbol to a power of two
+  @export(kentry)    // Also define a global symbol (functions only)
+  fn int kentry() { ... }
+@naked functions get no stack frame, so they cannot take parameters
+or declare local variables. @musttail goes before a return of a
+self-recursive call and requires it to compile to a jump.`,
+
 		ErrUndefinedVariable: `A variable was used before it was declared.
 In Lotus, variables must be declared before use:
   int x = 42;  // Declaration
@@ -259,6 +316,26 @@ Check that:
   - The module containing the function is imported
   - The function name is spelled correctly`,
 
+		ErrUndefinedLabel: `A goto or label address (&&name) names a label the function does
+not define. Labels belong to the function they appear in, so a goto
+cannot reach a label in another function:
+  loop:
+      n -= 1;
+      if n > 0 { goto loop; }`,
+
+		ErrArgumentCount: `A call passes more or fewer arguments than the function takes. A
+variadic function (one whose last parameter is type... name) takes at
+least one argument per parameter before it:
+  fn int twice(int x) { ret x * 2; }
+  twice(4);      // not twice(4, 2)`,
+
+		ErrPossiblyNull: `A value that may be null is used where a value is needed. Keep
+results of functions that can return null in a nullable (type?) variable
+and check it first, or unwrap it with ! to stop the program if it is null:
+  string? copy = str::copy(s);
+  if (copy == null) { ret -12; }
+  println(copy);`,
+
 		ErrTypeMismatch: `The types in an expression don't match.
 Lotus is statically typed - ensure both sides of an
 operation have compatible types.`,
@@ -268,6 +345,34 @@ Check that:
   - The module name is spelled correctly
   - The module is part of the standard library
   - Custom modules are in the include path`,
+
+		ErrRequiresOS: `The called function makes Linux system calls, but the program
+is being built freestanding (-freestanding or -target x86_64-none)
+where no operating system is present. Use pure functions (math, hash,
+num, most of str) or implement the operation for your platform.`,
+
+		ErrDynamicSyscall: `A -seccomp build allows exactly the system calls the compiler can
+see the program make. This one takes its number from a register set
+at run time, so it cannot be put in the filter. Build without -seccomp,
+or avoid the code that makes it.`,
+
+		ErrMissingLibrary: `The called function belongs to a stdlib module that wraps a C
+library, and the program is not linked against it. Pass -l with the
+library name, or list it under [build] libs in lotus.toml:
+  lotus -l sqlite3 app.lts`,
+
+		ErrComptime: `A comptime block could not be evaluated during compilation.
+Its body runs inside the compiler, so it can only use constants,
+functions and math module calls, and must end by returning a value:
+  const int N = comptime { int n = 1; n = n << 10; return n; };
+Variables of the surrounding code exist only at run time, and memory,
+I/O and pointers are not available.`,
+
+		ErrInclude: `A file named by include_bytes or include_str could not be embedded.
+The path is relative to the source file that names it, and the file is
+read when compiling. include_str embeds text, so the file may not
+contain NUL bytes; embed binary files with include_bytes:
+  int logo = include_bytes("assets/logo.png");`,
 	}
 
 	if text, ok := help[code]; ok {
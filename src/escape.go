@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// escape.go - Stack allocation of non-escaping buffers at -O2 and above
+// A local initialized from mem::malloc or mem::mmap with a small constant
+// size gets a zeroed slot in its function's frame instead of a fresh
+// mapping, when the analysis can show the pointer never leaves the function:
+//
+//   - it is only indexed, dereferenced, compared, or passed to stdlib
+//     functions that use the memory for the length of the call (printing,
+//     file reads and writes, hashing, memcpy/memset, string inspection)
+//   - it is never copied to another variable, returned, stored, passed to a
+//     user function, reassigned or has its address taken
+//
+// mem::free and mem::munmap statements on such a pointer are dropped, since
+// the frame goes away with the function. A declaration inside a loop reuses
+// the same slot on every iteration, which is safe because the previous
+// iteration's pointer cannot have been kept anywhere. Functions containing
+// constructs the pass does not model are left alone, as is every allocation
+// under -check-memory, which needs real blocks to guard.
+//
+// Nothing else moves. Strings built by str::concat and other stdlib calls
+// are allocated inside the call at a size known only at run time, and
+// arrays, even literals and array_int_new with a constant capacity, are
+// array_int objects that push may grow, so both stay on the heap.
+
+const (
+	stackBufferMax    = 4096  // Largest allocation moved to the stack
+	stackBufferBudget = 65536 // Most buffer bytes added to one frame
+)
+
+// StackBuffer is a zeroed buffer in the enclosing function's frame that
+// stands in for a heap allocation the escape analysis proved local
+type StackBuffer struct {
+	BaseNode
+	Size int // Bytes, a multiple of 16
+}
+
+func (s *StackBuffer) astNode() {}
+
+// stackSafeCalls are the stdlib functions that only touch a pointer argument
+// during the call. The value is true for functions that return the pointer
+// they were given, which may then only be called as statements.
+var stackSafeCalls = map[string]bool{
+	"mem.memcpy": true, "mem.memset": true, "mem.sizeof": false, "mem.equal": false,
+	"io.print": false, "io.println": false, "io.printf": false, "io.fprintf": false, "io.hexdump": false,
+	"io.debug": false, "io.print_array": false, "io.print_map": false, "io.print_map_str": false, "io.print_set": false,
+	"file.open": false, "file.read": false, "file.write": false, "file.stat": false, "file.exists": false, "file.glob": false,
+	"str.len": false, "str.compare": false, "str.equals": false, "str.indexOf": false, "str.contains": false,
+	"str.startsWith": false, "str.endsWith": false, "str.trimLeft": false, "str.trimRight": false,
+	"str.trimChars": false, "str.toLower_inplace": true, "str.toUpper_inplace": true, "str.trim_inplace": true,
+	"str.distance": false, "str.similar": false, "str.glob": false,
+	"hash.crc32": false, "hash.fnv1a": false, "hash.djb2": false, "hash.murmur": false,
+	"hash.sha256": false, "hash.md5": false,
+}
+
+// stdlibResolver resolves calls to stdlib functions the way code
+// generation will, for passes that run before it
+type stdlibResolver struct {
+	imports   *ImportContext
+	userFuncs map[string]bool
+}
+
+// escapeAnalysis finds the allocations of one program that can move
+type escapeAnalysis struct {
+	*stdlibResolver
+	remarks *OptRemarks
+}
+
+// newStdlibResolver reads the imports and function names of a program
+func newStdlibResolver(statements []ASTNode) *stdlibResolver {
+	r := &stdlibResolver{imports: NewImportContext(), userFuncs: make(map[string]bool)}
+	for _, stmt := range statements {
+		switch s := stmt.(type) {
+		case *ImportStatement:
+			_ = r.imports.ProcessImport(s) // Bad imports are reported by codegen
+		case *FunctionDefinition:
+			r.userFuncs[s.Name] = true
+		}
+	}
+	return r
+}
+
+// StackAllocate moves provably non-escaping small allocations in every
+// function onto the stack
+func StackAllocate(statements []ASTNode, remarks *OptRemarks) []ASTNode {
+	ea := &escapeAnalysis{stdlibResolver: newStdlibResolver(statements), remarks: remarks}
+	for _, stmt := range statements {
+		if fn, ok := stmt.(*FunctionDefinition); ok {
+			ea.allocateFunction(fn)
+		}
+	}
+	return statements
+}
+
+// callee returns the stdlib function a call resolves to, or nil for user
+// functions and names that resolve to nothing
+func (r *stdlibResolver) callee(name string) *StdlibFunction {
+	if r.userFuncs[name] {
+		return nil
+	}
+	if module, fn, ok := strings.Cut(name, "::"); ok {
+		if r.imports.SourceModules[module] {
+			return nil
+		}
+		if resolved, ok := r.imports.ImportedModules[module]; ok {
+			module = resolved
+		}
+		return GetModuleFunction(module, fn)
+	}
+	return r.imports.ImportedFunctions[name]
+}
+
+// calleeName returns "module.function" for a call into the stdlib, or ""
+func (r *stdlibResolver) calleeName(call *FunctionCall) string {
+	if fn := r.callee(call.Name); fn != nil {
+		return fn.Module + "." + fn.Name
+	}
+	return ""
+}
+
+// calleeIs reports whether call resolves to one of the module.function names
+func (r *stdlibResolver) calleeIs(call *FunctionCall, names ...string) bool {
+	name := r.calleeName(call)
+	return name != "" && slices.Contains(names, name)
+}
+
+// allocateFunction rewrites the qualifying allocations of fn
+func (ea *escapeAnalysis) allocateFunction(fn *FunctionDefinition) {
+	declared := make(map[string]int)
+	var candidates []*VariableDeclaration
+	if !walkLoopNodes(fn.Body, func(node ASTNode) {
+		if decl, ok := node.(*VariableDeclaration); ok {
+			declared[decl.Name]++
+			if ea.stackAllocSize(decl.Value) > 0 {
+				candidates = append(candidates, decl)
+			}
+		}
+	}) {
+		return
+	}
+	for _, param := range fn.Parameters {
+		declared[param.Name]++
+	}
+
+	budget := stackBufferBudget
+	for _, decl := range candidates {
+		size := (ea.stackAllocSize(decl.Value) + 15) &^ 15
+		if declared[decl.Name] != 1 || size > budget || ea.escapesIn(fn.Body, decl.Name, decl) {
+			continue
+		}
+		budget -= size
+		ea.remarks.add(OptRemark{Pass: "stack", Function: fn.Name, Line: decl.Line,
+			Message: fmt.Sprintf("%s: %d-byte allocation moved to the stack", decl.Name, size), Saved: 1})
+		decl.Value = &StackBuffer{BaseNode: BaseNode{Location: decl.Value.Loc()}, Size: size}
+		fn.Body = ea.dropFrees(fn.Body, decl.Name)
+	}
+}
+
+// stackAllocSize returns the size of a mem::malloc or mem::mmap call small
+// enough for the stack, or 0
+func (ea *escapeAnalysis) stackAllocSize(value ASTNode) int {
+	call, ok := value.(*FunctionCall)
+	if !ok || len(call.Args) != 1 || !ea.calleeIs(call, "mem.malloc", "mem.mmap") {
+		return 0
+	}
+	if lit, ok := call.Args[0].(*IntLiteral); ok && lit.Value > 0 && lit.Value <= stackBufferMax {
+		return lit.Value
+	}
+	return 0
+}
+
+// isName reports whether node is a bare use of the variable name
+func isName(node ASTNode, name string) bool {
+	id, ok := node.(*Identifier)
+	return ok && id.Name == name
+}
+
+// escapesIn reports whether any statement in body lets name's value out,
+// apart from its own declaration decl
+func (ea *escapeAnalysis) escapesIn(body []ASTNode, name string, decl *VariableDeclaration) bool {
+	for _, stmt := range body {
+		if ea.escapes(stmt, name, decl, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapes reports whether node uses name in a way that may outlive the
+// function. stmt is true when node is a statement of its own.
+func (ea *escapeAnalysis) escapes(node ASTNode, name string, decl *VariableDeclaration, stmt bool) bool {
+	if node == nil {
+		return false
+	}
+	switch n := node.(type) {
+	case *Identifier:
+		return n.Name == name
+	case *VariableDeclaration:
+		if n == decl {
+			return false
+		}
+		return ea.escapes(n.Value, name, decl, false)
+	case *Assignment:
+		if isName(n.Target, name) {
+			return true
+		}
+		return ea.escapes(n.Target, name, decl, false) || ea.escapes(n.Value, name, decl, false)
+	case *ArrayAccess:
+		if isName(n.Array, name) {
+			return ea.escapes(n.Index, name, decl, false)
+		}
+	case *Dereference:
+		if isName(n.Pointer, name) {
+			return false
+		}
+	case *Comparison:
+		return !isName(n.Left, name) && ea.escapes(n.Left, name, decl, false) ||
+			!isName(n.Right, name) && ea.escapes(n.Right, name, decl, false)
+	case *FunctionCall:
+		return ea.callEscapes(n, name, decl, stmt)
+	case *IfStatement:
+		return ea.escapes(n.Condition, name, decl, false) ||
+			ea.escapesIn(n.ThenBody, name, decl) || ea.escapesIn(n.ElseBody, name, decl)
+	case *WhileLoop:
+		return ea.escapes(n.Condition, name, decl, false) || ea.escapesIn(n.Body, name, decl)
+	case *ForLoop:
+		return ea.escapes(n.Init, name, decl, true) || ea.escapes(n.Condition, name, decl, false) ||
+			ea.escapes(n.Update, name, decl, true) || ea.escapesIn(n.Body, name, decl)
+	}
+	children, _ := nodeChildren(node)
+	for _, child := range children {
+		if ea.escapes(child, name, decl, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// callEscapes reports whether a call lets name's value out, either by
+// receiving it when it may keep it, or through one of its other arguments
+func (ea *escapeAnalysis) callEscapes(call *FunctionCall, name string, decl *VariableDeclaration, stmt bool) bool {
+	returnsArg, safe := stackSafeCalls[ea.calleeName(call)]
+	if stmt && ea.isFreeOf(call, name) {
+		safe, returnsArg = true, false
+	}
+	for _, arg := range call.Args {
+		if isName(arg, name) {
+			if !safe || returnsArg && !stmt {
+				return true
+			}
+			continue
+		}
+		if ea.escapes(arg, name, decl, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFreeOf reports whether call is mem::free(name) or mem::munmap(name, n)
+// with an operand that has no side effects to keep
+func (ea *escapeAnalysis) isFreeOf(call *FunctionCall, name string) bool {
+	if len(call.Args) == 0 || !isName(call.Args[0], name) || !ea.calleeIs(call, "mem.free", "mem.munmap") {
+		return false
+	}
+	for _, arg := range call.Args[1:] {
+		switch arg.(type) {
+		case *IntLiteral, *Identifier:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// dropFrees removes the mem::free and mem::munmap statements on name from
+// body and its nested blocks
+func (ea *escapeAnalysis) dropFrees(body []ASTNode, name string) []ASTNode {
+	result := body[:0]
+	for _, stmt := range body {
+		switch s := stmt.(type) {
+		case *FunctionCall:
+			if ea.isFreeOf(s, name) {
+				continue
+			}
+		case *IfStatement:
+			s.ThenBody = ea.dropFrees(s.ThenBody, name)
+			s.ElseBody = ea.dropFrees(s.ElseBody, name)
+		case *WhileLoop:
+			s.Body = ea.dropFrees(s.Body, name)
+		case *ForLoop:
+			s.Body = ea.dropFrees(s.Body, name)
+		}
+		result = append(result, stmt)
+	}
+	return result
+}
+
+// stackBufferBytes returns the frame space the stack buffers in body need
+func stackBufferBytes(body []ASTNode) int {
+	total := 0
+	walkLoopNodes(body, func(node ASTNode) {
+		if buf, ok := node.(*StackBuffer); ok {
+			total += buf.Size
+		}
+	})
+	return total
+}
+
+// generateStackBuffer reserves buf in the frame and zeroes it on every
+// evaluation, as a fresh mapping would be, leaving its address in rax
+func (cg *CodeGenerator) generateStackBuffer(buf *StackBuffer) {
+	cg.stackOffset += buf.Size
+	cg.textSection.WriteString(fmt.Sprintf("    # stack buffer of %d bytes\n", buf.Size))
+	cg.textSection.WriteString(fmt.Sprintf("    leaq -%d(%%rbp), %%rdi\n", cg.stackOffset))
+	cg.textSection.WriteString(fmt.Sprintf("    movq $%d, %%rcx\n", buf.Size/8))
+	cg.textSection.WriteString("    xorl %eax, %eax\n")
+	cg.textSection.WriteString("    rep stosq\n")
+	cg.textSection.WriteString(fmt.Sprintf("    leaq -%d(%%rbp), %%rax\n", cg.stackOffset))
+}
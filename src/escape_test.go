@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// stackRemarks runs the escape analysis on source, returning its remarks
+func stackRemarks(t *testing.T, source string) []string {
+	t.Helper()
+	statements, err := NewParser(Tokenize(source)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remarks := &OptRemarks{}
+	StackAllocate(statements, remarks)
+	var msgs []string
+	for _, r := range remarks.remarks {
+		msgs = append(msgs, r.Message)
+	}
+	return msgs
+}
+
+// Only constant-size mem::malloc and mem::mmap buffers move. The results of
+// str::concat and the like, and arrays built by a literal or by
+// collections::array_int_new with a constant capacity, stay on the heap
+// however little they hold and however locally they are used.
+func TestStackAllocation(t *testing.T) {
+	msgs := stackRemarks(t, `fn int main() {
+    int buf = mem::malloc(64);
+    string joined = str::concat("ab", "cd");
+    int fixed = collections::array_int_new(4);
+    int lit = [1, 2, 3];
+    mem::memset(buf, 0, 64);
+    println(joined);
+    collections::array_int_push(fixed, 1);
+    println(collections::array_int_get(fixed, 0));
+    println(collections::array_int_len(lit));
+    mem::free(buf);
+    ret 0;
+}
+`)
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0], "buf: 64-byte allocation moved to the stack") {
+		t.Errorf("got remarks %q, want only buf moved to the stack", msgs)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// eval.go - `lotus eval` expression evaluation
+// Evaluates an expression with the interpreter that runs comptime blocks and
+// prints the result. With -f, the functions and constants of a file, and of
+// the source modules it uses, are in scope:
+//
+//	$ lotus eval -f util.lts 'twice(21) + math::abs(-1)'
+//	43
+
+func init() {
+	Subcommands["eval"] = &Subcommand{
+		Name:    "eval",
+		Summary: "evaluate an expression at compile time ([-f file.lts] expr)",
+		Run:     runEval,
+	}
+}
+
+// runEval implements `lotus eval [-f file.lts] expr`
+func runEval(args []string) int {
+	fs := flag.NewFlagSet("lotus eval", flag.ContinueOnError)
+	file := fs.String("f", "", "make the functions and constants of `file` available")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: lotus eval [-f file.lts] expr")
+		return 2
+	}
+
+	var program []ASTNode
+	if *file != "" {
+		var err error
+		if program, err = loadEvalProgram(*file); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	expr, err := parseEvalExpression(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	v, err := NewInterpreter(program).Eval(expr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Println(v)
+	return 0
+}
+
+// parseEvalExpression parses text as a single expression
+func parseEvalExpression(text string) (ASTNode, error) {
+	tokens := Tokenize(text)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("invalid expression")
+	}
+	p := NewParser(tokens)
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	for p.current().Type == TokenSemi || p.current().Type == TokenNewline {
+		p.advance()
+	}
+	if p.current().Type != TokenEOF {
+		return nil, p.formatError(FormatUnexpectedToken(p.current().Type, p.current().Value, "after expression"))
+	}
+	return expr, nil
+}
+
+// loadEvalProgram parses path and the source modules it uses
+func loadEvalProgram(path string) ([]ASTNode, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens := Tokenize(string(contents))
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: tokenization failed", path)
+	}
+	statements, err := NewParser(tokens).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	dm := NewDiagnosticManager()
+	dm.FilePath = filepath.Clean(path)
+	dm.SetSourceLines(dm.FilePath, string(contents))
+	loader := NewModuleLoader(nil, dm, func(p string) string { return p })
+	loader.Load(statements, path)
+	if dm.HasErrors() {
+		dm.Print()
+		return nil, fmt.Errorf("%d error(s) loading %s", dm.ErrorCount, path)
+	}
+	program := append(loader.Declarations(), statements...)
+	nameInitBlocks(program)
+	return program, nil
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
 // flags.go - Command-line flag parsing and compiler options
@@ -15,6 +16,8 @@ type CompilerOptions struct {
 	Verbose       bool     // Enable verbose logging (-v)
 	TokenDump     bool     // Print tokens and exit (-td, --token-dump)
 	PrintAsm      bool     // Emit assembly instead of binary (-S)
+	Emit          string   // Output kind: exe, asm or obj (-emit)
+	DebugLines    bool     // Emit a source line table (-g)
 	RunAfterBuild bool     // Build and run the binary (-run)
 	Trimpath      string   // Remove prefix from recorded file paths (--trimpath)
 	ShowVersion   bool     // Print version and exit (--version)
@@ -42,22 +45,140 @@ type CompilerOptions struct {
 	MaxErrors      int  // Maximum errors before stopping (--max-errors)
 	ColorOutput    bool // Enable colored output (--color)
 	NoColor        bool // Disable colored output (--no-color)
+
+	// Machine-readable diagnostics
+	JSONDiagnostics bool   // Emit diagnostics as JSON instead of text (-json-diagnostics)
+	DiagnosticsOut  string // Destination for JSON diagnostics, default stdout (-diagnostics-out)
+	SymbolIndexDir  string // Directory for per-module JSON symbol indexes (-symbol-index)
+
+	// Build configuration (also settable from lotus.toml)
+	Target    string   // Target triple or alias, see Targets (-target)
+	Sysroot   string   // Root of the target's headers and libraries (-sysroot)
+	OptLevel  int      // 0 disables the AST and peephole optimizers (-O)
+	Defines   []string // NAME[=VALUE] constants injected into the program (-D)
+	BuildInfo []string // NAME=VALUE settings of the build information constants (-X)
+	Libs      []string // Extra libraries passed to the linker (-l)
+	Release   bool     // Compile assert() out (-release)
+
+	// Freestanding / bare-metal builds
+	Freestanding bool   // No OS: reject syscalls, halt instead of exit (-freestanding)
+	LinkerScript string // Linker script passed to the linker (-T)
+	EntrySymbol  string // Name of the generated entry point (-entry)
+	Linker       string // system (the target's compiler driver) or builtin (-linker)
+
+	// Stack safety
+	StackProbe      bool // Touch each page of large frames as they are allocated (-stack-probe)
+	PrintStackUsage bool // Report per-function stack usage (-print-stack-usage)
+
+	// Optimizer reports
+	PrintOptRemarks bool // Report what the -O2 passes rewrote (-print-opt-remarks)
+
+	// Code generator self-checks
+	CheckClobbers bool // Warn about registers read after a syscall overwrote them (-check-clobbers)
+
+	// Code size
+	OutlineThreshold int      // Outline stdlib functions this many instructions long, 0 never (-outline-threshold)
+	InlineFunctions  []string // module::function names always expanded in place (-inline)
+	PrintSize        bool     // Report the code each stdlib function added (-print-size)
+
+	// Runtime debugging
+	CheckMemory bool // Guard, track and leak-check stdlib allocations (-check-memory)
+	TraceStdlib bool // Log stdlib calls, arguments and results to stderr (-trace-stdlib)
+
+	// Profiling
+	InstrumentFunctions bool // Count calls and cycles per function into lotus.prof (-instrument-functions)
+	ProfileSample       bool // Write sampled stacks to lotus.folded (-profile-sample)
+
+	// System call audit
+	PrintSyscalls bool // Report the system calls the binary can make (-print-syscalls)
+	Seccomp       bool // Embed a seccomp filter allowing only those calls (-seccomp)
+
+	// Coverage instrumentation, set by lotus test -cover
+	CoverageFile string // Counter file the program maps at startup
+
+	setFlags map[string]bool // Flags given explicitly on the command line
+}
+
+// IsSet reports whether the named flag was given explicitly on the command line
+func (o *CompilerOptions) IsSet(name string) bool {
+	return o.setFlags[name]
 }
 
 // Version is the current compiler version
 const Version = CompilerVersion
 
+// longFlagAliases maps GNU-style spellings to the flag names registered on the FlagSet.
+// Shell completion also reads this table so both spellings are offered.
+var longFlagAliases = map[string]string{
+	"--token-dump":   "-token-dump",
+	"--ast-dump":     "-ast-dump",
+	"--stats":        "-stats",
+	"--quiet":        "-quiet",
+	"--timing":       "-timing",
+	"--docs":         "-docs",
+	"--docs-section": "-docs-section",
+}
+
 // ParseFlags parses command-line arguments and returns compiler options.
 // Returns (options, positional args, error)
-func ParseFlags() (*CompilerOptions, []string, error) {
+func ParseFlags(raw []string) (*CompilerOptions, []string, error) {
 	opts := &CompilerOptions{}
+	fs := newFlagSet(opts)
+
+	// Normalize args to accept various flag formats
+	norm := make([]string, 0, len(raw))
+	for _, a := range raw {
+		if alias, ok := longFlagAliases[a]; ok {
+			norm = append(norm, alias)
+		} else {
+			norm = append(norm, splitAttachedValue(fs, a))
+		}
+	}
 
+	if err := fs.Parse(norm); err != nil {
+		return nil, nil, err
+	}
+	if err := validateBuildOptions(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return nil, nil, err
+	}
+
+	opts.setFlags = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		opts.setFlags[f.Name] = true
+	})
+
+	return opts, fs.Args(), nil
+}
+
+// attachedValueFlags are single-letter flags that accept compiler-style
+// attached values (-O0, -DDEBUG, -Tlink.ld, -lm)
+const attachedValueFlags = "ODTl"
+
+// splitAttachedValue rewrites -O2 as -O=2 so the flag package accepts it,
+// leaving alone flags such as -linker that only start with one of the letters
+func splitAttachedValue(fs *flag.FlagSet, arg string) string {
+	if len(arg) > 2 && arg[0] == '-' && strings.IndexByte(attachedValueFlags, arg[1]) >= 0 && arg[2] != '=' {
+		name, _, _ := strings.Cut(arg[1:], "=")
+		if fs.Lookup(name) != nil {
+			return arg
+		}
+		return arg[:2] + "=" + arg[2:]
+	}
+	return arg
+}
+
+// newFlagSet registers every compiler flag against opts.
+// It is shared by ParseFlags and by tooling that needs to enumerate flags.
+func newFlagSet(opts *CompilerOptions) *flag.FlagSet {
 	fs := flag.NewFlagSet("lotus", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
 
 	// Output options
 	fs.StringVar(&opts.OutPath, "o", "a.out", "write output to `file`")
 	fs.BoolVar(&opts.PrintAsm, "S", false, "emit assembly to -o path (or a.s)")
+	fs.StringVar(&opts.Emit, "emit", "exe", "output `kind`: exe, asm (as -S) or obj (relocatable object to -o path or a.o, without an external assembler)")
+	fs.BoolVar(&opts.DebugLines, "g", false, "emit a source line table for debuggers and lotus disasm")
 
 	// Debug options
 	fs.BoolVar(&opts.Verbose, "v", false, "enable verbose logging")
@@ -71,6 +192,25 @@ func ParseFlags() (*CompilerOptions, []string, error) {
 	fs.BoolVar(&opts.Quiet, "q", false, "suppress non-error output")
 	fs.BoolVar(&opts.Quiet, "quiet", false, "suppress non-error output")
 	fs.BoolVar(&opts.TimingInfo, "timing", false, "show detailed phase timing")
+	fs.BoolVar(&opts.PrintStackUsage, "print-stack-usage", false, "report per-function stack usage")
+	fs.BoolVar(&opts.PrintOptRemarks, "print-opt-remarks", false, "report what the -O2 passes rewrote and how many operations they eliminated")
+	fs.BoolVar(&opts.CheckClobbers, "check-clobbers", false, "warn where generated code reads %rcx or %r11 after a syscall overwrote it (compiler debugging)")
+	fs.IntVar(&opts.OutlineThreshold, "outline-threshold", DefaultOutlineThreshold, "emit stdlib functions of at least `n` instructions once and call them (0 expands every call in place)")
+	fs.Func("inline", "always expand stdlib function `module::function` in place (repeatable)", func(val string) error {
+		if err := parseInlineFlag(val); err != nil {
+			return err
+		}
+		opts.InlineFunctions = append(opts.InlineFunctions, val)
+		return nil
+	})
+	fs.BoolVar(&opts.PrintSize, "print-size", false, "report the instructions each stdlib function added, at call sites and outlined")
+	fs.BoolVar(&opts.StackProbe, "stack-probe", false, "probe each page of large stack frames so overflows hit the guard page")
+	fs.BoolVar(&opts.CheckMemory, "check-memory", false, "check stdlib allocations for overflows, use after munmap and leaks at run time")
+	fs.BoolVar(&opts.PrintSyscalls, "print-syscalls", false, "report the system calls the binary can make and where they are made")
+	fs.BoolVar(&opts.Seccomp, "seccomp", false, "install a seccomp filter at startup that kills the program on any other system call")
+	fs.BoolVar(&opts.TraceStdlib, "trace-stdlib", false, "log each stdlib call with its arguments and result to stderr")
+	fs.BoolVar(&opts.ProfileSample, "profile-sample", false, "sample the call stack every millisecond of CPU time into lotus.folded, for flame graphs")
+	fs.BoolVar(&opts.InstrumentFunctions, "instrument-functions", false, "count calls and cycles per function and write them to lotus.prof at exit")
 
 	// Execution options
 	fs.BoolVar(&opts.RunAfterBuild, "run", false, "build and run the compiled binary")
@@ -102,15 +242,49 @@ func ParseFlags() (*CompilerOptions, []string, error) {
 	fs.IntVar(&opts.MaxErrors, "max-errors", 20, "maximum number of errors before stopping")
 	fs.BoolVar(&opts.ColorOutput, "color", true, "enable colored output")
 	fs.BoolVar(&opts.NoColor, "no-color", false, "disable colored output")
+	fs.BoolVar(&opts.JSONDiagnostics, "json-diagnostics", false, "emit diagnostics as JSON (to stdout, or -diagnostics-out)")
+	fs.StringVar(&opts.DiagnosticsOut, "diagnostics-out", "", "write JSON diagnostics to `file` instead of stdout")
+	fs.StringVar(&opts.SymbolIndexDir, "symbol-index", "", "write a JSON index of each module's functions, constants and types to `dir`")
+
+	// Build configuration
+	fs.StringVar(&opts.Target, "target", DefaultTarget, "target `triple` ("+strings.Join(TargetNames(), ", ")+")")
+	fs.StringVar(&opts.Sysroot, "sysroot", "", "use `dir` as the target's system root when linking")
+	fs.IntVar(&opts.OptLevel, "O", 1, "optimization `level` (0 disables AST and peephole optimization, 2 adds loop optimization, CSE and stack buffers)")
+	fs.Func("D", "define constant `NAME[=VALUE]` (repeatable)", func(val string) error {
+		opts.Defines = append(opts.Defines, val)
+		return nil
+	})
+	fs.Func("X", "set build information constant `NAME=VALUE` (BUILD_TIME, GIT_COMMIT, COMPILER_VERSION)", func(val string) error {
+		if err := parseBuildInfoFlag(val); err != nil {
+			return err
+		}
+		opts.BuildInfo = append(opts.BuildInfo, val)
+		return nil
+	})
+	fs.BoolVar(&opts.Release, "release", false, "compile assert() checks out")
+	fs.BoolVar(&opts.Freestanding, "freestanding", false, "build without an OS (implies -target x86_64-none)")
+	fs.StringVar(&opts.LinkerScript, "T", "", "link with linker `script`")
+	fs.StringVar(&opts.EntrySymbol, "entry", EntryPointLabel, "entry point `symbol`")
+	fs.StringVar(&opts.Linker, "linker", "system", "link with `kind`: system (the target's compiler driver) or builtin (static executable, without as or ld)")
+	fs.Func("l", "link with `lib` (repeatable)", func(val string) error {
+		if val != "" {
+			opts.Libs = append(opts.Libs, val)
+		}
+		return nil
+	})
 
 	fs.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage: lotus [flags] <file>")
+		fmt.Fprintln(os.Stderr, "       lotus <command> [args]")
+		printSubcommands(os.Stderr)
 		fmt.Fprintln(os.Stderr, "\nFlags:")
 		fs.PrintDefaults()
 		fmt.Fprintln(os.Stderr, "\nExamples:")
 		fmt.Fprintln(os.Stderr, "  lotus program.lts              # Compile to a.out")
 		fmt.Fprintln(os.Stderr, "  lotus -o myapp program.lts     # Compile to myapp")
 		fmt.Fprintln(os.Stderr, "  lotus -S program.lts           # Generate assembly")
+		fmt.Fprintln(os.Stderr, "  lotus -emit=obj program.lts    # Generate an object file (a.o)")
+		fmt.Fprintln(os.Stderr, "  lotus -linker=builtin program.lts  # Link without as or ld")
 		fmt.Fprintln(os.Stderr, "  lotus -run program.lts         # Compile and run")
 		fmt.Fprintln(os.Stderr, "  lotus -td program.lts          # Dump tokens")
 		fmt.Fprintln(os.Stderr, "  lotus --stats program.lts      # Show compilation stats")
@@ -121,35 +295,107 @@ func ParseFlags() (*CompilerOptions, []string, error) {
 		fmt.Fprintln(os.Stderr, "  lotus -docs-section stdlib     # Show stdlib docs")
 		fmt.Fprintln(os.Stderr, "  lotus -Wall program.lts        # Enable all warnings")
 		fmt.Fprintln(os.Stderr, "  lotus -Werror program.lts      # Warnings as errors")
+		fmt.Fprintln(os.Stderr, "  lotus -json-diagnostics prog.lts  # Diagnostics as JSON on stdout")
+		fmt.Fprintln(os.Stderr, "  lotus -O0 -D DEBUG program.lts # Unoptimized, with DEBUG = 1")
+		fmt.Fprintln(os.Stderr, "  lotus -target linux-arm64 -sysroot /opt/arm64 program.lts")
+		fmt.Fprintln(os.Stderr, "  lotus -freestanding -T link.ld -entry kstart kernel.lts")
+		fmt.Fprintln(os.Stderr, "  lotus build                    # Build the project in lotus.toml")
+		fmt.Fprintln(os.Stderr, "  lotus script tool.lts args     # Build into the cache and run")
+		fmt.Fprintln(os.Stderr, "  lotus completion bash          # Print bash completion script")
 	}
 
-	// Normalize args to accept various flag formats
-	raw := os.Args[1:]
-	norm := make([]string, 0, len(raw))
-	for _, a := range raw {
-		switch a {
-		case "--token-dump":
-			norm = append(norm, "-token-dump")
-		case "--ast-dump":
-			norm = append(norm, "-ast-dump")
-		case "--stats":
-			norm = append(norm, "-stats")
-		case "--quiet":
-			norm = append(norm, "-quiet")
-		case "--timing":
-			norm = append(norm, "-timing")
-		case "--docs":
-			norm = append(norm, "-docs")
-		case "--docs-section":
-			norm = append(norm, "-docs-section")
-		default:
-			norm = append(norm, a)
+	return fs
+}
+
+// DefaultTarget is the target triple used when -target is not given
+const DefaultTarget = "x86_64-linux"
+
+// validateBuildOptions checks build configuration values after flags or a
+// manifest have been applied, normalizing target aliases to their triple
+func validateBuildOptions(opts *CompilerOptions) error {
+	target, err := LookupTarget(opts.Target)
+	if err != nil {
+		return err
+	}
+	if opts.Freestanding && !target.Freestanding() {
+		// -freestanding selects the bare-metal variant of the architecture
+		if target, err = freestandingTarget(target.Arch); err != nil {
+			return err
 		}
 	}
+	opts.Target = target.Triple
+	opts.Freestanding = target.Freestanding()
 
-	if err := fs.Parse(norm); err != nil {
-		return nil, nil, err
+	if opts.EntrySymbol == "" {
+		opts.EntrySymbol = EntryPointLabel
 	}
-
-	return opts, fs.Args(), nil
+	if !isValidIdentifier(opts.EntrySymbol) {
+		return fmt.Errorf("invalid entry symbol %q", opts.EntrySymbol)
+	}
+	if opts.CheckMemory {
+		if problem := checkMemorySupported(target); problem != "" {
+			return fmt.Errorf("%s", problem)
+		}
+	}
+	if opts.Seccomp {
+		if problem := seccompSupported(target); problem != "" {
+			return fmt.Errorf("%s", problem)
+		}
+	}
+	if opts.TraceStdlib {
+		if problem := traceStdlibSupported(target); problem != "" {
+			return fmt.Errorf("%s", problem)
+		}
+	}
+	if opts.InstrumentFunctions {
+		if problem := instrumentSupported(target); problem != "" {
+			return fmt.Errorf("%s", problem)
+		}
+	}
+	if opts.ProfileSample {
+		if problem := sampleSupported(target); problem != "" {
+			return fmt.Errorf("%s", problem)
+		}
+	}
+	switch opts.Emit {
+	case "", "exe":
+		opts.Emit = "exe"
+	case "asm":
+		opts.PrintAsm = true
+	case "obj":
+		if opts.PrintAsm {
+			return fmt.Errorf("-S cannot be combined with -emit=obj")
+		}
+		if opts.RunAfterBuild {
+			return fmt.Errorf("-run needs an executable, not -emit=obj")
+		}
+		if target.Arch != "x86_64" {
+			return fmt.Errorf("the built-in assembler of -emit=obj targets x86_64, not %s", target.Triple)
+		}
+	default:
+		return fmt.Errorf("invalid -emit kind %q (expected exe, asm or obj)", opts.Emit)
+	}
+	switch opts.Linker {
+	case "", "system":
+		opts.Linker = "system"
+	case "builtin":
+		if target.Arch != "x86_64" {
+			return fmt.Errorf("the built-in linker targets x86_64, not %s", target.Triple)
+		}
+		if len(opts.Libs) > 0 {
+			return fmt.Errorf("-l %s needs the system linker; the built-in one links no libraries", opts.Libs[0])
+		}
+		if opts.LinkerScript != "" {
+			return fmt.Errorf("-T needs the system linker; the built-in one takes no linker script")
+		}
+	default:
+		return fmt.Errorf("invalid -linker kind %q (expected system or builtin)", opts.Linker)
+	}
+	if opts.OutlineThreshold < 0 {
+		return fmt.Errorf("invalid outline threshold %d (expected 0 or more)", opts.OutlineThreshold)
+	}
+	if opts.OptLevel < 0 || opts.OptLevel > 3 {
+		return fmt.Errorf("invalid optimization level %d (expected 0-3)", opts.OptLevel)
+	}
+	return nil
 }
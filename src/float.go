@@ -0,0 +1,640 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// float.go - Floating-point values
+// float (and its alias float64) holds a 64-bit IEEE 754 double. Like every
+// other value it lives in a 64-bit slot, so variables, parameters, return
+// values and constants carry the double's bits and move as integers do;
+// only arithmetic, comparison and conversion use the SSE registers:
+//
+//	float r = 2.5;
+//	float area = 3.14159 * r * r;     // mulsd
+//	int whole = int(area);           // cvttsd2si: 19
+//	if area > 19.5 { println(area); } // ucomisd; prints 19.634937
+//
+// Before the AST optimizers run, LowerFloats rewrites arithmetic and
+// comparisons with a float operand into FloatOp nodes, so the integer
+// folding and strength reduction passes never see them, turns float() and
+// int() between the two into FloatConversion nodes, and converts integer
+// values used as floats: a literal becomes a float literal, anything else is
+// converted. Code generation evaluates a FloatOp tree in %xmm0 up to
+// %xmm14, one register per level of nesting, with %xmm15 as scratch. Calls
+// clobber every SSE register, so when the right operand contains one the
+// left is kept on the stack while it runs, as is any operand nested deeper
+// than the registers go.
+
+// FloatOp is arithmetic (+ - * /) or a comparison on doubles, or negation
+// when Left is nil. Comparisons produce 0 or 1, as integer ones do.
+type FloatOp struct {
+	BaseNode
+	Left     ASTNode
+	Operator TokenType
+	Right    ASTNode
+}
+
+func (f *FloatOp) astNode() {}
+
+// FloatConversion converts between a float and an integer: an integer Value
+// to a float when ToFloat is set, otherwise a float Value truncated toward
+// zero. Other conversions only change a value's type.
+type FloatConversion struct {
+	BaseNode
+	Value   ASTNode
+	ToFloat bool
+}
+
+func (f *FloatConversion) astNode() {}
+
+// floatRegisters is the number of SSE registers FloatOp trees are evaluated
+// in; the last one, %xmm15, is scratch
+const floatRegisters = 15
+
+// floatPrecision is the number of decimals print and println show, before
+// trailing zeros are trimmed
+const floatPrecision = 6
+
+// floatMaxPrecision is the most decimals %.Nf prints; a double holds no more
+// than this many significant digits
+const floatMaxPrecision = 15
+
+// isFloatComparison reports whether op compares rather than computes
+func isFloatComparison(op TokenType) bool {
+	switch op {
+	case TokenEqual, TokenNotEqual, TokenLess, TokenLessEq, TokenGreater, TokenGreaterEq:
+		return true
+	}
+	return false
+}
+
+// floatLowerer rewrites float arithmetic in a program into FloatOp nodes
+type floatLowerer struct {
+	returns   map[string]TokenType       // Function name -> return type
+	params    map[string][]FunctionParam // Function name -> parameters
+	constants map[string]TokenType       // Constant name -> type
+	vars      map[string]TokenType       // Variables of the function being walked
+	ret       TokenType                  // Return type of the function being walked
+}
+
+// LowerFloats rewrites arithmetic and comparisons on floats into FloatOp
+// nodes and converts integers given where floats are expected
+func LowerFloats(statements []ASTNode) []ASTNode {
+	fl := &floatLowerer{
+		returns:   make(map[string]TokenType),
+		params:    make(map[string][]FunctionParam),
+		constants: make(map[string]TokenType),
+		vars:      make(map[string]TokenType),
+	}
+	for _, stmt := range statements {
+		switch n := stmt.(type) {
+		case *FunctionDefinition:
+			fl.returns[n.Name] = n.ReturnType
+			fl.params[n.Name] = n.Parameters
+		case *ConstantDeclaration:
+			fl.constants[n.Name] = n.Type
+		}
+	}
+	fl.lowerBody(statements)
+	return statements
+}
+
+// lowerBody lowers each statement of body in place
+func (fl *floatLowerer) lowerBody(body []ASTNode) {
+	for i, stmt := range body {
+		body[i] = fl.lowerStatement(stmt)
+	}
+}
+
+// lowerStatement lowers the expressions of stmt and the statements inside it
+func (fl *floatLowerer) lowerStatement(stmt ASTNode) ASTNode {
+	switch s := stmt.(type) {
+	case nil:
+		return nil
+	case *FunctionDefinition:
+		savedVars, savedRet := fl.vars, fl.ret
+		fl.vars = make(map[string]TokenType)
+		for _, param := range s.Parameters {
+			fl.vars[param.Name] = param.Type
+			if param.Variadic {
+				fl.vars[param.Name] = TokenTypeInt // The array's address
+			}
+		}
+		fl.ret = s.ReturnType
+		fl.lowerBody(s.Body)
+		fl.vars, fl.ret = savedVars, savedRet
+	case *VariableDeclaration:
+		s.Value = fl.lower(s.Value)
+		if s.Type == TokenTypeFloat {
+			s.Value = fl.toFloat(s.Value)
+		}
+		fl.vars[s.Name] = s.Type
+	case *ConstantDeclaration:
+		s.Value = fl.lower(s.Value)
+		if s.Type == TokenTypeFloat {
+			s.Value = fl.toFloat(s.Value)
+		}
+	case *Assignment:
+		s.Target = fl.lower(s.Target)
+		s.Value = fl.lower(s.Value)
+		if fl.typeOf(s.Target) == TokenTypeFloat {
+			s.Value = fl.toFloat(s.Value)
+		}
+	case *CompoundAssignment:
+		s.Value = fl.lower(s.Value)
+		op, ok := map[TokenType]TokenType{
+			TokenPlusEq: TokenPlus, TokenMinusEq: TokenMinus, TokenStarEq: TokenStar, TokenSlashEq: TokenSlash,
+		}[s.Operator]
+		if id, isVar := s.Target.(*Identifier); ok && isVar && fl.typeOf(id) == TokenTypeFloat {
+			// x += y is x = x + y, which the SSE code computes whole
+			value := &FloatOp{Left: &Identifier{BaseNode: id.BaseNode, Name: id.Name}, Operator: op, Right: fl.toFloat(s.Value)}
+			return &Assignment{BaseNode: s.BaseNode, Target: s.Target, Value: value}
+		}
+	case *ReturnStatement:
+		s.Value = fl.lower(s.Value)
+		if fl.ret == TokenTypeFloat && s.Value != nil {
+			s.Value = fl.toFloat(s.Value)
+		}
+	case *IfStatement:
+		s.Condition = fl.lower(s.Condition)
+		fl.lowerBody(s.ThenBody)
+		fl.lowerBody(s.ElseBody)
+	case *WhileLoop:
+		s.Condition = fl.lower(s.Condition)
+		fl.lowerBody(s.Body)
+	case *ForLoop:
+		s.Init = fl.lowerStatement(s.Init)
+		s.Condition = fl.lower(s.Condition)
+		s.Update = fl.lowerStatement(s.Update)
+		fl.lowerBody(s.Body)
+	case *TryStatement:
+		fl.lowerBody(s.TryBlock)
+		for _, clause := range s.CatchClauses {
+			fl.lowerBody(clause.Body)
+		}
+		fl.lowerBody(s.FinallyBlock)
+	case *ComptimeBlock:
+		fl.lowerBody(s.Body)
+	default:
+		return fl.lower(stmt)
+	}
+	return stmt
+}
+
+// lower rewrites the float arithmetic in expr, innermost first
+func (fl *floatLowerer) lower(expr ASTNode) ASTNode {
+	if expr == nil {
+		return nil
+	}
+	expr = mapSubexpressions(expr, fl.lower)
+	switch e := expr.(type) {
+	case *BinaryOp:
+		if e.Operator != TokenPercent && fl.floatOperands(e.Left, e.Right) {
+			return &FloatOp{BaseNode: e.BaseNode, Left: fl.toFloat(e.Left), Operator: e.Operator, Right: fl.toFloat(e.Right)}
+		}
+	case *Comparison:
+		if fl.floatOperands(e.Left, e.Right) {
+			return &FloatOp{BaseNode: e.BaseNode, Left: fl.toFloat(e.Left), Operator: e.Operator, Right: fl.toFloat(e.Right)}
+		}
+	case *Conversion:
+		from := fl.typeOf(e.Value)
+		switch {
+		case e.Type == TokenTypeFloat && from != TokenTypeFloat:
+			return &FloatConversion{BaseNode: e.BaseNode, Value: e.Value, ToFloat: true}
+		case IsIntegerType(e.Type) && from == TokenTypeFloat:
+			return &FloatConversion{BaseNode: e.BaseNode, Value: e.Value}
+		}
+	case *UnaryOp:
+		if e.Operator == TokenMinus && fl.typeOf(e.Operand) == TokenTypeFloat {
+			if lit, ok := e.Operand.(*FloatLiteral); ok {
+				return &FloatLiteral{BaseNode: e.BaseNode, Value: -lit.Value}
+			}
+			return &FloatOp{BaseNode: e.BaseNode, Operator: TokenMinus, Right: e.Operand}
+		}
+	case *FunctionCall:
+		params := fl.params[e.Name]
+		for i, arg := range e.Args {
+			if i < len(params) && params[i].Type == TokenTypeFloat && !params[i].Variadic {
+				e.Args[i] = fl.toFloat(arg)
+			}
+		}
+	}
+	return expr
+}
+
+// floatOperands reports whether an operator on left and right works on
+// floats: one of them is a float and the other a float or an integer
+func (fl *floatLowerer) floatOperands(left, right ASTNode) bool {
+	l, r := fl.typeOf(left), fl.typeOf(right)
+	if l != TokenTypeFloat && r != TokenTypeFloat {
+		return false
+	}
+	numeric := func(t TokenType) bool { return t == TokenTypeFloat || typeClass(t) == "integer" }
+	return numeric(l) && numeric(r)
+}
+
+// toFloat returns expr as a float: integer literals become float literals and
+// other integers are converted. A value of unknown type is taken to hold a
+// float's bits already.
+func (fl *floatLowerer) toFloat(expr ASTNode) ASTNode {
+	switch e := expr.(type) {
+	case *IntLiteral:
+		return &FloatLiteral{BaseNode: e.BaseNode, Value: float64(e.Value)}
+	case *CharLiteral:
+		return expr
+	}
+	if typeClass(fl.typeOf(expr)) == "integer" {
+		return &FloatConversion{BaseNode: BaseNode{expr.Loc()}, Value: expr, ToFloat: true}
+	}
+	return expr
+}
+
+// typeOf returns the type of expr, or TokenEOF when it cannot be worked out
+// without running the program
+func (fl *floatLowerer) typeOf(expr ASTNode) TokenType {
+	switch e := expr.(type) {
+	case *IntLiteral, *CharLiteral:
+		return TokenTypeInt
+	case *FloatLiteral:
+		return TokenTypeFloat
+	case *StringLiteral:
+		return TokenTypeString
+	case *BoolLiteral, *Comparison, *LogicalOp:
+		return TokenTypeBool
+	case *FloatOp:
+		if isFloatComparison(e.Operator) {
+			return TokenTypeBool
+		}
+		return TokenTypeFloat
+	case *FloatConversion:
+		if e.ToFloat {
+			return TokenTypeFloat
+		}
+		return TokenTypeInt
+	case *Conversion:
+		return e.Type
+	case *Unwrap:
+		return fl.typeOf(e.Value)
+	case *Identifier:
+		if t, ok := fl.vars[e.Name]; ok {
+			return t
+		}
+		if t, ok := fl.constants[e.Name]; ok {
+			return t
+		}
+	case *FunctionCall:
+		if t, ok := fl.returns[e.Name]; ok {
+			return t
+		}
+	case *BinaryOp:
+		if IsIntegerType(fl.typeOf(e.Left)) && IsIntegerType(fl.typeOf(e.Right)) {
+			return TokenTypeInt
+		}
+	case *UnaryOp:
+		switch e.Operator {
+		case TokenMinus, TokenTilde:
+			return fl.typeOf(e.Operand)
+		case TokenExclaim:
+			return TokenTypeBool
+		}
+	}
+	return TokenEOF
+}
+
+// ---- Code generation ----
+
+// floatValue reports whether expr produces a float's bits
+func (cg *CodeGenerator) floatValue(expr ASTNode) bool {
+	switch e := expr.(type) {
+	case *FloatLiteral:
+		return true
+	case *FloatOp:
+		return !isFloatComparison(e.Operator)
+	case *FloatConversion:
+		return e.ToFloat
+	case *Conversion:
+		return e.Type == TokenTypeFloat
+	case *Unwrap:
+		return cg.floatValue(e.Value)
+	case *Identifier:
+		if v, ok := cg.variables[e.Name]; ok {
+			return v.Type == TokenTypeFloat
+		}
+		if c, ok := cg.constants[e.Name]; ok {
+			return c.Type == TokenTypeFloat
+		}
+	case *FunctionCall:
+		if fn, ok := UserDefinedFunctions[e.Name]; ok {
+			return fn.ReturnType == TokenTypeFloat
+		}
+	case *TernaryOp:
+		return cg.floatValue(e.TrueExpr) || cg.floatValue(e.FalseExpr)
+	}
+	return false
+}
+
+// floatLabel places a double in the data section and returns its label
+func (cg *CodeGenerator) floatLabel(value float64) string {
+	label := fmt.Sprintf(".float%d", cg.stringCount)
+	cg.stringCount++
+	cg.dataSection.WriteString(fmt.Sprintf("%s:\n    .quad 0x%x  # %g\n", label, math.Float64bits(value), value))
+	return label
+}
+
+// generateFloatToReg evaluates a FloatOp into reg: the double's bits for
+// arithmetic, 0 or 1 for a comparison
+func (cg *CodeGenerator) generateFloatToReg(op *FloatOp, reg string) {
+	if !isFloatComparison(op.Operator) {
+		cg.generateFloat(op, 0)
+		cg.textSection.WriteString(fmt.Sprintf("    movq %%xmm0, %%%s\n", reg))
+		return
+	}
+	cg.generateFloatOperands(op, 0)
+	// ucomisd sets the flags as an unsigned compare of its destination with
+	// its source, and sets PF when either is NaN. NaN is unequal to
+	// everything; a, ae, b and be are false for it already once the operands
+	// are ordered so that only a and ae are tested.
+	w := cg.textSection.WriteString
+	switch op.Operator {
+	case TokenEqual, TokenNotEqual:
+		done := cg.getLabel("float_unordered")
+		w("    ucomisd %xmm1, %xmm0\n")
+		if op.Operator == TokenEqual {
+			w("    movl $0, %eax\n")
+			w(fmt.Sprintf("    jp %s\n", done))
+			w("    sete %al\n")
+		} else {
+			w("    movl $1, %eax\n")
+			w(fmt.Sprintf("    jp %s\n", done))
+			w("    setne %al\n")
+		}
+		w(fmt.Sprintf("%s:\n", done))
+	case TokenLess:
+		w("    ucomisd %xmm0, %xmm1\n    seta %al\n    movzbl %al, %eax\n")
+	case TokenLessEq:
+		w("    ucomisd %xmm0, %xmm1\n    setae %al\n    movzbl %al, %eax\n")
+	case TokenGreater:
+		w("    ucomisd %xmm1, %xmm0\n    seta %al\n    movzbl %al, %eax\n")
+	case TokenGreaterEq:
+		w("    ucomisd %xmm1, %xmm0\n    setae %al\n    movzbl %al, %eax\n")
+	}
+	if reg != "rax" {
+		w(fmt.Sprintf("    movq %%rax, %%%s\n", reg))
+	}
+}
+
+// generateFloat evaluates a float expression into %xmmN, using only the
+// registers from it up and %xmm15. It clobbers %rax.
+func (cg *CodeGenerator) generateFloat(expr ASTNode, n int) {
+	w := cg.textSection.WriteString
+	switch e := expr.(type) {
+	case *FloatLiteral:
+		w(fmt.Sprintf("    movsd %s(%%rip), %%xmm%d\n", cg.floatLabel(e.Value), n))
+		return
+	case *Identifier:
+		if v, ok := cg.variables[e.Name]; ok && v.Type == TokenTypeFloat {
+			w(fmt.Sprintf("    movsd -%d(%%rbp), %%xmm%d\n", v.Offset, n))
+			return
+		}
+		if c, ok := cg.constants[e.Name]; ok && c.Type == TokenTypeFloat {
+			w(fmt.Sprintf("    movsd .const_%s(%%rip), %%xmm%d\n", c.Name, n))
+			return
+		}
+	case *FloatConversion:
+		if e.ToFloat {
+			cg.generateExpressionToReg(e.Value, "rax")
+			w(fmt.Sprintf("    cvtsi2sdq %%rax, %%xmm%d\n", n))
+			return
+		}
+	case *FloatOp:
+		if e.Left == nil {
+			// -x flips the sign bit, which also negates zero and NaN
+			cg.generateFloat(e.Right, n)
+			w(fmt.Sprintf("    movq %%xmm%d, %%rax\n    btcq $63, %%rax\n    movq %%rax, %%xmm%d\n", n, n))
+			return
+		}
+		if !isFloatComparison(e.Operator) {
+			cg.generateFloatOperands(e, n)
+			mnem := map[TokenType]string{TokenPlus: "addsd", TokenMinus: "subsd", TokenStar: "mulsd", TokenSlash: "divsd"}[e.Operator]
+			w(fmt.Sprintf("    %s %%xmm%d, %%xmm%d\n", mnem, n+1, n))
+			return
+		}
+	}
+	// Anything else produces the bits in a general register
+	cg.generateExpressionToReg(expr, "rax")
+	w(fmt.Sprintf("    movq %%rax, %%xmm%d\n", n))
+}
+
+// generateFloatOperands evaluates op's left operand into %xmmN and its right
+// into %xmmN+1
+func (cg *CodeGenerator) generateFloatOperands(op *FloatOp, n int) {
+	w := cg.textSection.WriteString
+	cg.generateFloat(op.Left, n)
+	if n+1 < floatRegisters && !floatCalls(op.Right) {
+		cg.generateFloat(op.Right, n+1)
+		return
+	}
+	w(fmt.Sprintf("    subq $8, %%rsp\n    movsd %%xmm%d, (%%rsp)\n", n))
+	cg.generateFloat(op.Right, n)
+	w(fmt.Sprintf("    movsd %%xmm%d, %%xmm15\n", n))
+	w(fmt.Sprintf("    movsd (%%rsp), %%xmm%d\n    addq $8, %%rsp\n", n))
+	w(fmt.Sprintf("    movsd %%xmm15, %%xmm%d\n", n+1))
+}
+
+// floatCalls reports whether evaluating expr may call a function, which
+// clobbers every SSE register. Kinds the AST passes cannot see inside count
+// as calls.
+func floatCalls(expr ASTNode) bool {
+	calls := false
+	ok := walkLoopNodes([]ASTNode{expr}, func(node ASTNode) {
+		switch node.(type) {
+		case *FunctionCall, *MethodCall:
+			calls = true
+		}
+	})
+	return calls || !ok
+}
+
+// generateFloatConversion evaluates a FloatConversion into reg: cvtsi2sd for
+// float(int), truncating cvttsd2si for int(float)
+func (cg *CodeGenerator) generateFloatConversion(c *FloatConversion, reg string) {
+	if c.ToFloat {
+		cg.generateFloat(c, 0)
+		cg.textSection.WriteString(fmt.Sprintf("    movq %%xmm0, %%%s\n", reg))
+		return
+	}
+	cg.generateFloat(c.Value, 0)
+	cg.textSection.WriteString(fmt.Sprintf("    cvttsd2siq %%xmm0, %%%s\n", reg))
+}
+
+// ---- Printing ----
+
+// emitPrintFloat writes a float expression to stdout with prec decimals,
+// trimming trailing zeros after the first when trim is set
+func emitPrintFloat(cg *CodeGenerator, expr ASTNode, prec int, trim bool) {
+	cg.floats = true
+	w := cg.textSection.WriteString
+	w("    # print float\n")
+	cg.generateFloat(expr, 0)
+	trimFlag := 0
+	if trim {
+		trimFlag = 1
+	}
+	w("    subq $64, %rsp\n")
+	w("    movq %rsp, %rdi\n")
+	w(fmt.Sprintf("    movq $%d, %%rsi\n", min(prec, floatMaxPrecision)))
+	w(fmt.Sprintf("    movq $%d, %%rdx\n", trimFlag))
+	w("    call .lotus_rt_fmt_float\n")
+	w("    movq %rax, %rdx\n")
+	w("    movq %rsp, %rsi\n")
+	w("    movq $1, %rdi\n")
+	cg.asm().LoadSyscall("write")
+	w("    syscall\n")
+	w("    addq $64, %rsp\n")
+}
+
+// floatRuntime returns the routine that formats doubles for printing.
+//
+//	.lotus_rt_fmt_float  writes %xmm0 in decimal to the buffer at %rdi,
+//	                     which holds 64 bytes, with %rsi decimals (at most
+//	                     17), trimming trailing zeros after the first when
+//	                     %rdx is not 0, and returns the length in %rax
+//
+// Values of 1e18 and up are written as d.ddde+NN; infinities as inf and -inf
+// and NaN as NaN. It clobbers %rcx, %rdx, %rsi, %rdi, %r8-%r11, %xmm0 and
+// %xmm1.
+func (cg *CodeGenerator) floatRuntime() string {
+	return cg.localLabels("lotus_rt_float", `
+# ---- Float formatting ----
+.lotus_rt_fmt_float:
+    movq %rdi, %r8
+    movq %rsi, %r10
+    movq %rdx, %r11
+    xorq %r9, %r9
+    movq %xmm0, %rax
+    btrq $63, %rax
+    jnc {l1}
+    movb $'-', (%rdi)
+    incq %rdi
+{l1}:
+    movq %rax, %xmm0
+    movq %rax, %rcx
+    shrq $52, %rcx
+    cmpq $0x7ff, %rcx
+    jne {l3}
+    shlq $12, %rax
+    jnz {l2}
+    movb $'i', (%rdi)
+    movb $'n', 1(%rdi)
+    movb $'f', 2(%rdi)
+    addq $3, %rdi
+    jmp {l11}
+{l2}:
+    movq %r8, %rdi
+    movb $'N', (%rdi)
+    movb $'a', 1(%rdi)
+    movb $'N', 2(%rdi)
+    addq $3, %rdi
+    jmp {l11}
+{l3}:
+    # Scale values too large for the integer part into [1, 10)
+    movq $1000000000, %rax
+    imulq %rax, %rax
+    cvtsi2sdq %rax, %xmm1
+    ucomisd %xmm1, %xmm0
+    jb {l5}
+    movq $10, %rax
+    cvtsi2sdq %rax, %xmm1
+{l4}:
+    divsd %xmm1, %xmm0
+    incq %r9
+    ucomisd %xmm1, %xmm0
+    jae {l4}
+{l5}:
+    # Integer part in %rax, decimals rounded in %rdx
+    cvttsd2siq %xmm0, %rax
+    cvtsi2sdq %rax, %xmm1
+    subsd %xmm1, %xmm0
+    movq $1, %rcx
+    movq %r10, %rdx
+{l6}:
+    testq %rdx, %rdx
+    jz {l7}
+    imulq $10, %rcx, %rcx
+    decq %rdx
+    jmp {l6}
+{l7}:
+    cvtsi2sdq %rcx, %xmm1
+    mulsd %xmm1, %xmm0
+    cvtsd2siq %xmm0, %rdx
+    cmpq %rcx, %rdx
+    jb {l8}
+    subq %rcx, %rdx
+    incq %rax
+    testq %r9, %r9
+    jz {l8}
+    cmpq $10, %rax
+    jne {l8}
+    movq $1, %rax
+    incq %r9
+{l8}:
+    # The decimals plus 10^decimals have a leading 1 where the point goes
+    leaq (%rdx,%rcx), %rsi
+    call .lotus_rt_fmt_uint
+    testq %r10, %r10
+    jz {l10}
+    movq %rdi, %rcx
+    movq %rsi, %rax
+    call .lotus_rt_fmt_uint
+    movb $'.', (%rcx)
+    testq %r11, %r11
+    jz {l10}
+{l9}:
+    cmpb $'0', -1(%rdi)
+    jne {l10}
+    cmpb $'.', -2(%rdi)
+    je {l10}
+    decq %rdi
+    jmp {l9}
+{l10}:
+    testq %r9, %r9
+    jz {l11}
+    movb $'e', (%rdi)
+    movb $'+', 1(%rdi)
+    addq $2, %rdi
+    movq %r9, %rax
+    call .lotus_rt_fmt_uint
+{l11}:
+    movq %rdi, %rax
+    subq %r8, %rax
+    ret
+
+# Writes %rax in decimal at %rdi and moves %rdi past it
+.lotus_rt_fmt_uint:
+    pushq %rcx
+    pushq %rdx
+    pushq %rsi
+    movq $10, %rsi
+    xorq %rcx, %rcx
+{l12}:
+    xorq %rdx, %rdx
+    divq %rsi
+    addq $'0', %rdx
+    pushq %rdx
+    incq %rcx
+    testq %rax, %rax
+    jnz {l12}
+{l13}:
+    popq %rax
+    movb %al, (%rdi)
+    incq %rdi
+    decq %rcx
+    jnz {l13}
+    popq %rsi
+    popq %rdx
+    popq %rcx
+    ret
+`)
+}
@@ -5,18 +5,26 @@ import "fmt"
 // FunctionDefinition represents a user-defined function
 type FunctionDefinition struct {
 	BaseNode
-	Name       string
-	Parameters []FunctionParam
-	ReturnType TokenType
-	Body       []ASTNode
+	Name           string
+	Parameters     []FunctionParam
+	ReturnType     TokenType
+	ReturnName     string // Newtype the result is declared with, or ""
+	ReturnNullable bool   // Declared type? and may return null
+	Body           []ASTNode
+	Attributes     []Attribute // @section, @align, ...
+	File           string      // Defining source module, or "" for the file being compiled
+	Init           bool        // An init block, run before main (init.go)
 }
 
 func (f *FunctionDefinition) astNode() {}
 
 // FunctionParam represents a function parameter
 type FunctionParam struct {
-	Name string
-	Type TokenType
+	Name     string
+	Type     TokenType
+	TypeName string // Newtype the parameter is declared with, or ""
+	Nullable bool   // Declared type? and may be passed null
+	Variadic bool   // Takes the trailing arguments as an array (type... name)
 }
 
 // FunctionContext holds information about a function during code generation
@@ -41,6 +49,9 @@ func (cg *CodeGenerator) calculateStackSize(funcDef *FunctionDefinition) int {
 	// Count local variables in the function body
 	stackNeeded += cg.countLocalVariablesInBody(funcDef.Body) * 8
 
+	// Buffers the escape analysis moved off the heap
+	stackNeeded += stackBufferBytes(funcDef.Body)
+
 	// Ensure 16-byte alignment for x86-64 ABI (add padding if needed)
 	if stackNeeded%16 != 0 {
 		stackNeeded += 16 - (stackNeeded % 16)
@@ -92,17 +103,42 @@ func (cg *CodeGenerator) generateFunctionDefinition(funcDef *FunctionDefinition)
 	UserDefinedFunctions[funcDef.Name] = funcDef
 
 	// Generate the function assembly
+	start := cg.textSection.Len()
 	funcLabel := cg.getFunctionLabel(funcDef.Name)
 	returnLabel := cg.getLabel("return")
 
 	// Calculate required stack size
 	stackSize := cg.calculateStackSize(funcDef)
+	naked := funcDef.HasAttribute("naked")
 
+	openPlacement, closePlacement := cg.placementDirectives(funcDef.Attributes, codeSectionFlags)
+	cg.textSection.WriteString(openPlacement)
+	if sym := funcDef.ExportName(); sym != "" {
+		cg.textSection.WriteString(fmt.Sprintf("%s %s\n", GlobalDirective, sym))
+		cg.textSection.WriteString(fmt.Sprintf("%s:\n", sym))
+	}
+	savedDebugFile := cg.debugFile
+	if funcDef.File != "" {
+		cg.debugFile = funcDef.File
+	}
+	if cg.debugLines {
+		cg.textSection.WriteString(fmt.Sprintf("    .type %s, @function\n", funcLabel))
+	}
 	cg.textSection.WriteString(fmt.Sprintf("%s:\n", funcLabel))
-	cg.textSection.WriteString("    # Function prologue\n")
-	cg.textSection.WriteString("    pushq %rbp\n")
-	cg.textSection.WriteString("    movq %rsp, %rbp\n")
-	cg.textSection.WriteString(fmt.Sprintf("    subq $%d, %%rsp\n", stackSize)) // Dynamic stack allocation
+	if cg.debugLines {
+		cg.lineDirective(funcDef.Loc())
+	}
+	endLabel := ""
+	if cg.sampleProfile {
+		endLabel = cg.sampleFunction(funcDef.Name, funcLabel)
+	}
+	if !naked {
+		cg.textSection.WriteString("    # Function prologue\n")
+		cg.textSection.WriteString("    pushq %rbp\n")
+		cg.textSection.WriteString("    movq %rsp, %rbp\n")
+		cg.textSection.WriteString(cg.stackAllocation(stackSize)) // Dynamic stack allocation
+	}
+	bodyStart := cg.textSection.Len()
 
 	// Save current state and create new scope
 	savedVars := cg.variables
@@ -111,8 +147,17 @@ func (cg *CodeGenerator) generateFunctionDefinition(funcDef *FunctionDefinition)
 	cg.stackOffset = 0
 	savedInFunction := cg.inFunction
 	savedReturnLbl := cg.currentFunctionReturnLbl
+	savedFunction := cg.currentFunction
+	savedBodyLbl := cg.currentFunctionBodyLbl
 	cg.inFunction = true
+	cg.currentFunction = funcDef
 	cg.currentFunctionReturnLbl = returnLabel
+	cg.currentFunctionBodyLbl = ""
+
+	profiled := -1
+	if cg.instrument && !naked {
+		profiled = cg.profileFunction(funcDef.Name)
+	}
 
 	// Set up parameters (System V AMD64 ABI: rdi, rsi, rdx, rcx, r8, r9)
 	paramRegs := []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
@@ -128,36 +173,70 @@ func (cg *CodeGenerator) generateFunctionDefinition(funcDef *FunctionDefinition)
 		}
 	}
 
+	if profiled >= 0 {
+		cg.textSection.WriteString(cg.profileCall(profiled, "enter"))
+	}
+
+	// Self tail calls jump back here with new parameter values
+	if hasSelfTailCall(funcDef.Body, funcDef.Name) {
+		cg.currentFunctionBodyLbl = cg.getLabel("body")
+		cg.textSection.WriteString(fmt.Sprintf("%s:\n", cg.currentFunctionBodyLbl))
+	}
+
 	// Generate function body
 	for _, stmt := range funcDef.Body {
 		cg.generateStatement(stmt)
 	}
 
+	frame := stackSize + 16 // Saved %rbp and return address
+	if naked {
+		frame = 8
+	}
+	cg.stackFrames = append(cg.stackFrames, measureStack(funcDef.Name, frame, cg.textSection.String()[bodyStart:]))
+
 	// Function epilogue
 	cg.textSection.WriteString(fmt.Sprintf("%s:\n", returnLabel))
-	cg.textSection.WriteString("    # Function epilogue\n")
-	if funcDef.Name == "main" {
-		// Exit directly from main using return value in rax
-		cg.textSection.WriteString("    # Exit from main\n")
-		cg.textSection.WriteString("    movq %rax, %rdi\n")
-		cg.textSection.WriteString("    movq $60, %rax\n")
-		cg.textSection.WriteString("    syscall\n")
-	} else {
-		cg.textSection.WriteString("    movq %rbp, %rsp\n") // Restore stack pointer
-		cg.textSection.WriteString("    popq %rbp\n")
+	if profiled >= 0 {
+		cg.textSection.WriteString(cg.profileCall(profiled, "exit"))
+	}
+	if naked {
+		// No frame to tear down; return straight to the caller
 		cg.textSection.WriteString("    ret\n")
+	} else {
+		cg.textSection.WriteString("    # Function epilogue\n")
+		if funcDef.Name == "main" {
+			// Exit directly from main using return value in rax
+			cg.textSection.WriteString("    # Exit from main\n")
+			cg.textSection.WriteString("    movq %rax, %rdi\n")
+			cg.textSection.WriteString(cg.exitSequence())
+		} else {
+			cg.textSection.WriteString("    movq %rbp, %rsp\n") // Restore stack pointer
+			cg.textSection.WriteString("    popq %rbp\n")
+			cg.textSection.WriteString("    ret\n")
+		}
+	}
+	if endLabel != "" {
+		cg.textSection.WriteString(fmt.Sprintf("%s:\n", endLabel))
 	}
+	if cg.debugLines {
+		cg.textSection.WriteString(fmt.Sprintf("    .size %s, .-%s\n", funcLabel, funcLabel))
+	}
+	cg.textSection.WriteString(closePlacement)
+	cg.noteSyscallOrigin(start, "", Location{})
 
 	// Restore variable scope
 	cg.variables = savedVars
 	cg.stackOffset = savedStackOffset
 	cg.inFunction = savedInFunction
 	cg.currentFunctionReturnLbl = savedReturnLbl
+	cg.currentFunction = savedFunction
+	cg.currentFunctionBodyLbl = savedBodyLbl
+	cg.debugFile = savedDebugFile
 }
 
 // generateUserFunctionCall generates assembly for calling a user-defined function
 func (cg *CodeGenerator) generateUserFunctionCall(funcCall *FunctionCall) bool {
-	_, exists := UserDefinedFunctions[funcCall.Name]
+	fn, exists := UserDefinedFunctions[funcCall.Name]
 	if !exists {
 		return false
 	}
@@ -165,8 +244,20 @@ func (cg *CodeGenerator) generateUserFunctionCall(funcCall *FunctionCall) bool {
 	// System V AMD64 ABI: rdi, rsi, rdx, rcx, r8, r9
 	paramRegs := []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
 
+	// Trailing arguments of a variadic function go in an array in this frame
+	args := funcCall.Args
+	varargs := 0
+	if fn.Variadic() {
+		fixed := len(fn.Parameters) - 1
+		var extra []ASTNode
+		if len(args) > fixed {
+			args, extra = args[:fixed], args[fixed:]
+		}
+		varargs = cg.generateVarargs(extra)
+	}
+
 	// Evaluate arguments and place in registers
-	for i, arg := range funcCall.Args {
+	for i, arg := range args {
 		if i >= len(paramRegs) {
 			// Additional args go on stack
 			cg.generateExpressionToReg(arg, "rax")
@@ -176,12 +267,19 @@ func (cg *CodeGenerator) generateUserFunctionCall(funcCall *FunctionCall) bool {
 		}
 	}
 
+	if varargs > 0 {
+		cg.textSection.WriteString(fmt.Sprintf("    movq %%rsp, %%%s\n", paramRegs[len(fn.Parameters)-1]))
+	}
+
 	// Call function
 	funcLabel := cg.getFunctionLabel(funcCall.Name)
 	cg.textSection.WriteString(fmt.Sprintf("    call %s\n", funcLabel))
+	if varargs > 0 {
+		cg.textSection.WriteString(fmt.Sprintf("    addq $%d, %%rsp\n", varargs))
+	}
 
 	// Clean up stack if there were extra arguments
-	extraArgs := len(funcCall.Args) - len(paramRegs)
+	extraArgs := len(args) - len(paramRegs)
 	if extraArgs > 0 {
 		cg.textSection.WriteString(fmt.Sprintf("    addq $%d, %%rsp\n", extraArgs*8))
 	}
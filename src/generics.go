@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// generics.go - Generic functions
+// A function can take type parameters, named in angle brackets after its
+// name and used anywhere a type can appear:
+//
+//	fn T max<T>(T a, T b) {
+//	    if a > b { ret a; }
+//	    ret b;
+//	}
+//
+// A generic function is kept as tokens. Each call works out the type
+// parameters from the types of its arguments and is pointed at a copy of the
+// function with them substituted, compiled like any other (max.int,
+// max.string), so there is no run-time cost. The body is only checked when a
+// call instantiates it. Calls are resolved alongside overloads, in
+// overload.go.
+//
+// Only functions take type parameters. Structs cannot, as the parser does not
+// accept struct declarations at all yet; generic structs would follow the
+// same scheme once it does, keyed by the type arguments at each use.
+
+// GenericFunction is a function with type parameters, kept as the tokens
+// from fn to its closing brace until a call fixes the parameters
+type GenericFunction struct {
+	BaseNode
+	Name       string
+	TypeParams []string
+	ParamTypes []string // Type parameter each parameter is declared with, or ""
+	Variadic   bool     // Last parameter takes the trailing arguments
+	Tokens     []Token  // fn, return type, name, then ( ... } without the <...>
+	Attributes []Attribute
+	File       string // Defining source module, or "" for the file being compiled
+}
+
+func (g *GenericFunction) astNode() {}
+
+// isGenericFunction reports whether the fn at the current position declares
+// type parameters: fn type name <
+func (p *Parser) isGenericFunction() bool {
+	if p.pos+3 >= len(p.tokens) {
+		return false
+	}
+	ret, name, open := p.tokens[p.pos+1], p.tokens[p.pos+2], p.tokens[p.pos+3]
+	return (isTypeToken(ret.Type) || ret.Type == TokenIdentifier) &&
+		name.Type == TokenIdentifier && open.Type == TokenLess
+}
+
+// parseGenericFunction reads a generic function's header and keeps its
+// tokens for instantiation
+func (p *Parser) parseGenericFunction() (*GenericFunction, error) {
+	fnTok, retTok, nameTok := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	p.pos += 4 // fn type name <
+	gf := &GenericFunction{Name: nameTok.Value}
+
+	for {
+		if p.current().Type != TokenIdentifier {
+			return nil, p.formatErrorWithCode(ErrExpectedToken, "expected type parameter name, got "+TokenTypeName(p.current().Type))
+		}
+		gf.TypeParams = append(gf.TypeParams, p.current().Value)
+		p.advance()
+		if p.current().Type != TokenComma {
+			break
+		}
+		p.advance()
+	}
+	if err := p.expect(TokenGreater); err != nil {
+		return nil, err
+	}
+	if retTok.Type == TokenIdentifier && !gf.isTypeParam(retTok.Value) {
+		return nil, p.formatErrorWithCode(ErrExpectedToken, MsgMissingReturnType+", got "+TokenTypeName(retTok.Type))
+	}
+
+	// Parameters: type [...] name, ...
+	body := p.pos
+	if err := p.expect(TokenLParen); err != nil {
+		return nil, err
+	}
+	for p.current().Type != TokenRParen && p.current().Type != TokenEOF {
+		paramType := ""
+		if p.current().Type == TokenIdentifier && gf.isTypeParam(p.current().Value) {
+			paramType = p.current().Value
+		}
+		gf.ParamTypes = append(gf.ParamTypes, paramType)
+		for p.current().Type != TokenComma && p.current().Type != TokenRParen && p.current().Type != TokenEOF {
+			gf.Variadic = gf.Variadic || p.current().Type == TokenDot
+			p.advance()
+		}
+		if p.current().Type == TokenComma {
+			p.advance()
+		}
+	}
+	if err := p.expect(TokenRParen); err != nil {
+		return nil, err
+	}
+
+	// Body, up to the matching brace
+	if p.current().Type != TokenLBrace {
+		return nil, p.expect(TokenLBrace)
+	}
+	depth := 0
+	for {
+		switch p.current().Type {
+		case TokenLBrace:
+			depth++
+		case TokenRBrace:
+			depth--
+		case TokenEOF:
+			return nil, p.expect(TokenRBrace)
+		}
+		p.advance()
+		if depth == 0 {
+			break
+		}
+	}
+	gf.Tokens = append([]Token{fnTok, retTok, nameTok}, p.tokens[body:p.pos]...)
+	return gf, nil
+}
+
+// isTypeParam reports whether name is one of g's type parameters
+func (g *GenericFunction) isTypeParam(name string) bool {
+	for _, tp := range g.TypeParams {
+		if tp == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inferTypes works out g's type parameters from the types of a call's
+// arguments, with TokenEOF for arguments of unknown type
+func (g *GenericFunction) inferTypes(args []TokenType) (map[string]TokenType, error) {
+	if n := len(g.ParamTypes); len(args) != n && !(g.Variadic && len(args) >= n-1) {
+		return nil, fmt.Errorf("'%s' takes %d argument(s), got %d", g.Name, n, len(args))
+	}
+	bound := make(map[string]TokenType, len(g.TypeParams))
+	for i, tp := range g.ParamTypes {
+		if tp == "" || i >= len(args) || args[i] == TokenEOF {
+			continue
+		}
+		prev, ok := bound[tp]
+		switch {
+		case !ok:
+			bound[tp] = args[i]
+		case prev != args[i] && !(IsIntegerType(prev) && IsIntegerType(args[i])):
+			return nil, fmt.Errorf("%s is %s for one argument of '%s' and %s for another",
+				tp, TokenValue(Token{Type: prev}), g.Name, TokenValue(Token{Type: args[i]}))
+		}
+	}
+	for _, tp := range g.TypeParams {
+		if _, ok := bound[tp]; !ok {
+			return nil, fmt.Errorf("cannot work out %s for '%s' from its arguments", tp, g.Name)
+		}
+	}
+	return bound, nil
+}
+
+// instanceName is the name of g instantiated with types: max.int
+func (g *GenericFunction) instanceName(types map[string]TokenType) string {
+	parts := []string{g.Name}
+	for _, tp := range g.TypeParams {
+		parts = append(parts, TokenValue(Token{Type: types[tp]}))
+	}
+	return strings.Join(parts, ".")
+}
+
+// instantiate parses a copy of g with its type parameters replaced by types
+func (g *GenericFunction) instantiate(types map[string]TokenType) (*FunctionDefinition, error) {
+	tokens := make([]Token, len(g.Tokens), len(g.Tokens)+1)
+	copy(tokens, g.Tokens)
+	for i, tok := range tokens {
+		if t, ok := types[tok.Value]; ok && tok.Type == TokenIdentifier {
+			tokens[i].Type, tokens[i].Value = t, ""
+		}
+	}
+	tokens[2].Value = g.instanceName(types)
+	tokens = append(tokens, Token{Type: TokenEOF})
+
+	stmt, err := NewParser(tokens).parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	fn := stmt.(*FunctionDefinition)
+	fn.Attributes = g.Attributes
+	fn.File = g.File
+	return fn, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// get.go - `lotus get` dependency fetching
+// Clones each [dependencies] entry of lotus.toml into vendor/<name>, checks out
+// the requested version, and records the resolved commit in lotus.lock so later
+// fetches reproduce the same tree. `use "<name>";` then resolves to
+// vendor/<name>/lib.lts through the module search path.
+
+// LockFile records the exact revision fetched for each dependency
+const LockFile = "lotus.lock"
+
+// LockEntry is one dependency pinned in lotus.lock
+type LockEntry struct {
+	Name    string
+	Git     string
+	Version string // Version requested in lotus.toml when the entry was written
+	Rev     string // Resolved commit hash
+}
+
+func init() {
+	Subcommands["get"] = &Subcommand{
+		Name:    "get",
+		Summary: "fetch " + ManifestFile + " dependencies into " + VendorDir + "/ and pin them in " + LockFile + " ([-update] [dir])",
+		Run:     runGet,
+	}
+}
+
+// runGet implements `lotus get [-update] [-v] [dir]`
+func runGet(args []string) int {
+	fs := flag.NewFlagSet("lotus get", flag.ContinueOnError)
+	update := fs.Bool("update", false, "resolve versions again instead of using "+LockFile)
+	verbose := fs.Bool("v", false, "print git commands")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: lotus get [-update] [-v] [dir]")
+		return 2
+	}
+
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+
+	path, err := FindManifest(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	manifest, err := LoadManifest(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	lockPath := filepath.Join(manifest.Dir, LockFile)
+	locked, err := ReadLockFile(lockPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if len(manifest.Dependencies) == 0 {
+		fmt.Println("No dependencies declared in " + path)
+		return 0
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: 'git' not found in PATH; it is required to fetch dependencies")
+		return 1
+	}
+
+	fetcher := &gitFetcher{verbose: *verbose}
+	entries := make([]LockEntry, 0, len(manifest.Dependencies))
+	for _, dep := range manifest.Dependencies {
+		pinned := ""
+		if entry, ok := locked[dep.Name]; ok && !*update && entry.Git == dep.Git && entry.Version == dep.Version {
+			pinned = entry.Rev
+		}
+
+		rev, err := fetcher.fetch(dep, filepath.Join(manifest.VendorPath(), dep.Name), pinned)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", dep.Name, err)
+			return 1
+		}
+		fmt.Printf("%s %s -> %s\n", dep.Name, displayVersion(dep.Version), shortRev(rev))
+		entries = append(entries, LockEntry{Name: dep.Name, Git: dep.Git, Version: dep.Version, Rev: rev})
+	}
+
+	if err := WriteLockFile(lockPath, entries); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// gitFetcher runs the git commands that populate vendor/
+type gitFetcher struct {
+	verbose bool
+}
+
+// fetch makes dest a checkout of dep at pinned (if set) or dep.Version and
+// returns the commit hash that ended up checked out
+func (g *gitFetcher) fetch(dep Dependency, dest, pinned string) (string, error) {
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return "", err
+		}
+		// The URL comes after -- so that git never reads it as an option
+		if _, err := g.git("", "clone", "--quiet", "--", dep.Git, dest); err != nil {
+			return "", err
+		}
+	} else {
+		origin, err := g.git(dest, "remote", "get-url", "origin")
+		if err != nil {
+			return "", fmt.Errorf("%s exists but is not a git checkout; remove it to fetch again", dest)
+		}
+		if origin != dep.Git {
+			return "", fmt.Errorf("%s is a checkout of %s, not %s; remove it to fetch again", dest, origin, dep.Git)
+		}
+		if _, err := g.git(dest, "fetch", "--quiet", "--tags", "origin"); err != nil {
+			return "", err
+		}
+	}
+
+	want := pinned
+	if want == "" {
+		rev, err := g.resolve(dest, dep.Version)
+		if err != nil {
+			return "", err
+		}
+		want = rev
+	}
+	if _, err := g.git(dest, "checkout", "--quiet", "--detach", want); err != nil {
+		return "", err
+	}
+	return g.git(dest, "rev-parse", "HEAD")
+}
+
+// resolve turns a tag, branch, or commit into a commit hash. Branches are
+// looked up on origin so a fetch picks up new commits.
+func (g *gitFetcher) resolve(dir, version string) (string, error) {
+	candidates := []string{"origin/HEAD"}
+	if version != "" {
+		candidates = []string{"refs/tags/" + version, "origin/" + version, version}
+	}
+	for _, ref := range candidates {
+		if rev, err := g.git(dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err == nil {
+			return rev, nil
+		}
+	}
+	return "", fmt.Errorf("version %q not found in repository", displayVersion(version))
+}
+
+// git runs a git command (in dir when set) and returns its trimmed output
+func (g *gitFetcher) git(dir string, args ...string) (string, error) {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	if g.verbose {
+		fmt.Fprintf(os.Stderr, "git %s\n", strings.Join(args, " "))
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[len(args)-1], msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func displayVersion(version string) string {
+	if version == "" {
+		return "(default branch)"
+	}
+	return version
+}
+
+func shortRev(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
+
+// ReadLockFile loads lotus.lock; a missing file yields an empty set
+func ReadLockFile(path string) (map[string]LockEntry, error) {
+	entries := make(map[string]LockEntry)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := parseTOML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	for name, keys := range doc {
+		if name == "" {
+			continue
+		}
+		entry := LockEntry{Name: name}
+		for key, val := range keys {
+			var dst *string
+			switch key {
+			case "git":
+				dst = &entry.Git
+			case "version":
+				dst = &entry.Version
+			case "rev":
+				dst = &entry.Rev
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key %s", path, val.line, key)
+			}
+			if err := setString(dst, name+"."+key, val.value); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, val.line, err)
+			}
+		}
+		entries[name] = entry
+	}
+	return entries, nil
+}
+
+// WriteLockFile writes entries to path sorted by name
+func WriteLockFile(path string, entries []LockEntry) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	var b strings.Builder
+	b.WriteString("# " + LockFile + " - generated by `lotus get`; do not edit by hand\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "\n[%s]\n", e.Name)
+		fmt.Fprintf(&b, "git = %q\n", e.Git)
+		fmt.Fprintf(&b, "version = %q\n", e.Version)
+		fmt.Fprintf(&b, "rev = %q\n", e.Rev)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
@@ -0,0 +1,125 @@
+package main
+
+// glob.go - Wildcard matching runtime
+// str.glob and file.glob call this routine, which is appended to the program
+// once when either is used:
+//
+//	.lotus_rt_glob  pattern %rdi, string %rsi
+//
+// It returns 1 in %rax if the whole string matches the pattern, else 0. In
+// the pattern * matches any run of characters, ? any one character, and a
+// class such as [abc], [a-z] or [!0-9] (also [^0-9]) one character in, or
+// not in, the set; a ] first in a class, or a - first or last, stands for
+// itself. An unterminated class matches nothing. Every other character
+// matches itself. A failed match backs up only to the last *, so the time
+// is at most the product of the lengths. It makes no system calls and
+// clobbers %rcx, %rdx, %rdi, %rsi and %r8-%r11.
+
+// useGlob appends the wildcard matching runtime to the program
+func (cg *CodeGenerator) useGlob() {
+	cg.glob = true
+}
+
+// globRuntime returns .lotus_rt_glob
+func (cg *CodeGenerator) globRuntime() string {
+	return cg.localLabels("lotus_rt_glob", `
+# ---- wildcard matching ----
+# %r8 is the pattern after the last *, %r9 where the string was then
+.lotus_rt_glob:
+    xorq %r8, %r8
+{l1}:
+    movzbl (%rsi), %eax
+    testl %eax, %eax
+    jz {l13}
+    movzbl (%rdi), %edx
+    cmpl $42, %edx  # *
+    jne {l2}
+    incq %rdi
+    movq %rdi, %r8
+    movq %rsi, %r9
+    jmp {l1}
+{l2}:
+    cmpl $63, %edx  # ?
+    je {l3}
+    cmpl $91, %edx  # [
+    je {l4}
+    cmpl %eax, %edx
+    jne {l12}
+{l3}:
+    incq %rdi
+    incq %rsi
+    jmp {l1}
+{l4}:
+    # Class: %r10 bit 0 is negation, bit 1 a member matched
+    leaq 1(%rdi), %rcx
+    xorl %r10d, %r10d
+    cmpb $33, (%rcx)  # !
+    je {l5}
+    cmpb $94, (%rcx)  # ^
+    jne {l6}
+{l5}:
+    movl $1, %r10d
+    incq %rcx
+{l6}:
+    movzbl (%rcx), %edx  # A ] here is a member
+    jmp {l8}
+{l7}:
+    movzbl (%rcx), %edx
+    cmpl $93, %edx  # ]
+    je {l11}
+{l8}:
+    testl %edx, %edx
+    jz {l12}
+    incq %rcx
+    movl %edx, %r11d
+    cmpb $45, (%rcx)  # -
+    jne {l9}
+    movzbl 1(%rcx), %r11d
+    cmpl $93, %r11d  # A - before ] is a member
+    je {l10}
+    testl %r11d, %r11d
+    jz {l12}
+    addq $2, %rcx
+{l9}:
+    cmpl %edx, %eax
+    jb {l7}
+    cmpl %r11d, %eax
+    ja {l7}
+    orl $2, %r10d
+    jmp {l7}
+{l10}:
+    movl %edx, %r11d
+    jmp {l9}
+{l11}:
+    movl %r10d, %edx
+    shrl $1, %edx
+    xorl %r10d, %edx
+    testl $1, %edx
+    jz {l12}
+    leaq 1(%rcx), %rdi
+    incq %rsi
+    jmp {l1}
+{l12}:
+    # Mismatch: let the last * take one more character
+    testq %r8, %r8
+    jz {l15}
+    movq %r8, %rdi
+    incq %r9
+    movq %r9, %rsi
+    jmp {l1}
+{l13}:
+    # The string is used up: only *s may be left of the pattern
+    cmpb $42, (%rdi)
+    jne {l14}
+    incq %rdi
+    jmp {l13}
+{l14}:
+    xorl %eax, %eax
+    cmpb $0, (%rdi)
+    sete %al
+    ret
+{l15}:
+    xorl %eax, %eax
+    ret
+`)
+}
@@ -0,0 +1,188 @@
+package main
+
+import "fmt"
+
+// goto.go - Labels, goto and computed goto
+// A label names a point in a function, and goto jumps to it. &&name is the
+// label's address as an int, and goto *expr jumps to an address computed at
+// run time, so a bytecode interpreter can dispatch through a table with one
+// indirect jump per instruction instead of a compare ladder:
+//
+//	int table = [&&op_push, &&op_add, &&op_halt];
+//	goto *collections::array_int_get(table, code[pc]);
+//	op_add:
+//	    ...
+//	    goto *collections::array_int_get(table, code[pc]);
+//
+// Labels are scoped to the function (or the top-level code) that declares
+// them, wherever they appear in its blocks. A label address is only
+// meaningful as the target of a goto in the same function.
+
+// LabelStatement marks a jump target: name:
+type LabelStatement struct {
+	BaseNode
+	Name string
+}
+
+func (l *LabelStatement) astNode() {}
+
+// GotoStatement jumps to a label: goto name; or to an address: goto *expr;
+type GotoStatement struct {
+	BaseNode
+	Label  string  // Set for a direct goto
+	Target ASTNode // Set for a computed goto
+}
+
+func (g *GotoStatement) astNode() {}
+
+// LabelAddress is the address of a label in the current function: &&name
+type LabelAddress struct {
+	BaseNode
+	Name string
+}
+
+func (l *LabelAddress) astNode() {}
+
+// parseGotoStatement parses goto name or goto *expr
+func (p *Parser) parseGotoStatement() (*GotoStatement, error) {
+	if err := p.expect(TokenGoto); err != nil {
+		return nil, err
+	}
+	if p.current().Type == TokenStar {
+		p.advance()
+		target, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		return &GotoStatement{Target: target}, nil
+	}
+	if p.current().Type != TokenIdentifier {
+		return nil, p.formatError(FormatExpectedToken(TokenIdentifier, p.current().Type, p.current().Value) + " after 'goto'")
+	}
+	name := p.current().Value
+	p.advance()
+	return &GotoStatement{Label: name}, nil
+}
+
+// parseLabelAddress parses &&name
+func (p *Parser) parseLabelAddress() (*LabelAddress, error) {
+	start := p.current()
+	if err := p.expect(TokenAnd); err != nil {
+		return nil, err
+	}
+	if p.current().Type != TokenIdentifier {
+		return nil, p.formatError(FormatExpectedToken(TokenIdentifier, p.current().Type, p.current().Value) + " after '&&'")
+	}
+	name := p.current().Value
+	p.advance()
+	addr := &LabelAddress{Name: name}
+	setLocation(addr, start)
+	return addr, nil
+}
+
+// checkLabels reports duplicate labels in body and gotos or label addresses
+// naming labels it does not declare. Nested function definitions have
+// labels of their own and are skipped.
+func (sa *SemanticAnalyzer) checkLabels(body []ASTNode) {
+	declared := make(map[string]int)
+	var uses []ASTNode
+	walkLabelScope(body, func(node ASTNode) {
+		switch n := node.(type) {
+		case *LabelStatement:
+			if line, ok := declared[n.Name]; ok {
+				sa.labelError(ErrRedefinition, n, fmt.Sprintf("label '%s' is already defined at line %d", n.Name, line))
+				return
+			}
+			declared[n.Name] = n.Loc().Line
+		case *GotoStatement, *LabelAddress:
+			uses = append(uses, n)
+		}
+	})
+	for _, node := range uses {
+		name := ""
+		switch n := node.(type) {
+		case *GotoStatement:
+			name = n.Label
+		case *LabelAddress:
+			name = n.Name
+		}
+		if _, ok := declared[name]; name != "" && !ok {
+			sa.labelError(ErrUndefinedLabel, node, fmt.Sprintf("undefined label '%s'", name))
+		}
+	}
+}
+
+// labelError reports a label problem at node
+func (sa *SemanticAnalyzer) labelError(code ErrorCode, node ASTNode, message string) {
+	loc := node.Loc()
+	if loc.Line == 0 {
+		loc.Line = sa.currentLine
+	}
+	sa.diagnostics.AddErrorWithCode(string(code), CategorySemantic, message,
+		sa.filePath, loc.Line, loc.Column, sa.getSourceLine(loc.Line))
+}
+
+// walkLabelScope calls visit on every statement and expression of body that
+// shares its label scope
+func walkLabelScope(body []ASTNode, visit func(ASTNode)) {
+	for _, node := range body {
+		walkLabelNode(node, visit)
+	}
+}
+
+func walkLabelNode(node ASTNode, visit func(ASTNode)) {
+	if node == nil {
+		return
+	}
+	visit(node)
+	switch n := node.(type) {
+	case *FunctionDefinition:
+		return
+	case *GotoStatement:
+		walkLabelNode(n.Target, visit)
+	case *TryStatement:
+		walkLabelScope(n.TryBlock, visit)
+		for _, clause := range n.CatchClauses {
+			walkLabelScope(clause.Body, visit)
+		}
+		walkLabelScope(n.FinallyBlock, visit)
+	default:
+		if children, ok := nodeChildren(node); ok {
+			for _, child := range children {
+				walkLabelNode(child, visit)
+			}
+		}
+	}
+}
+
+// labelSymbol is the assembly label for name in the function being
+// generated. The dot keeps it apart from every function label.
+func (cg *CodeGenerator) labelSymbol(name string) string {
+	scope := "top"
+	if cg.currentFunction != nil {
+		scope = cg.currentFunction.Name
+	}
+	return fmt.Sprintf(".label.%s.%s", scope, name)
+}
+
+// generateLabelStatement places a label
+func (cg *CodeGenerator) generateLabelStatement(label *LabelStatement) {
+	cg.textSection.WriteString(fmt.Sprintf("%s:\n", cg.labelSymbol(label.Name)))
+}
+
+// generateGotoStatement jumps to a label, or to the address its target
+// evaluates to
+func (cg *CodeGenerator) generateGotoStatement(g *GotoStatement) {
+	if g.Target == nil {
+		cg.textSection.WriteString(fmt.Sprintf("    jmp %s\n", cg.labelSymbol(g.Label)))
+		return
+	}
+	cg.textSection.WriteString("    # computed goto\n")
+	cg.generateExpressionToReg(g.Target, "rax")
+	cg.textSection.WriteString("    jmp *%rax\n")
+}
+
+// generateLabelAddress leaves the address of a label in rax
+func (cg *CodeGenerator) generateLabelAddress(addr *LabelAddress) {
+	cg.textSection.WriteString(fmt.Sprintf("    leaq %s(%%rip), %%rax\n", cg.labelSymbol(addr.Name)))
+}
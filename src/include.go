@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// include.go - Embedding files at compile time
+// include_bytes("logo.png") and include_str("page.html") read a file while
+// compiling and place its contents in .rodata, so the binary needs nothing
+// beside it at run time:
+//
+//	string page = include_str("page.html");
+//	int page_len = mem::sizeof(include_str("page.html"));
+//	io::write(1, page, page_len);
+//
+// Either is the address of the contents, and mem::sizeof of either is their
+// length, fixed when compiling: the (ptr, len) pair the stdlib's data
+// functions take. A file embedded more than once is stored once. Paths are
+// relative to the source file naming them. The contents are followed by a
+// NUL the length does not count, so include_str is an ordinary string; it
+// rejects files that contain NUL bytes, which include_bytes accepts.
+
+// Include embeds a file's contents: include_bytes(path), include_str(path)
+type Include struct {
+	BaseNode
+	Path string // As written
+	Text bool   // include_str
+	File string // Resolved path, set during semantic analysis
+	Data []byte // Contents, read during semantic analysis
+}
+
+func (i *Include) astNode() {}
+
+// isInclude reports whether include_bytes( or include_str( comes next
+func (p *Parser) isInclude() bool {
+	tok := p.current()
+	return tok.Type == TokenIdentifier && (tok.Value == "include_bytes" || tok.Value == "include_str") &&
+		p.peek().Type == TokenLParen
+}
+
+// parseInclude parses include_bytes("path") or include_str("path")
+func (p *Parser) parseInclude() (*Include, error) {
+	tok := p.current()
+	p.advance()
+	if err := p.expect(TokenLParen); err != nil {
+		return nil, err
+	}
+	if p.current().Type != TokenString {
+		return nil, p.formatErrorWithCode(ErrExpectedToken, tok.Value+" takes a file path in quotes, got "+TokenTypeName(p.current().Type))
+	}
+	inc := &Include{Path: p.current().Value, Text: tok.Value == "include_str"}
+	setLocation(inc, tok)
+	p.advance()
+	if err := p.expect(TokenRParen); err != nil {
+		return nil, err
+	}
+	return inc, nil
+}
+
+// loadInclude reads the file inc names, relative to the directory of the
+// source file being analyzed
+func (sa *SemanticAnalyzer) loadInclude(inc *Include) {
+	name := "include_bytes"
+	if inc.Text {
+		name = "include_str"
+	}
+	inc.File = inc.Path
+	if !filepath.IsAbs(inc.File) {
+		inc.File = filepath.Join(sa.sourceDir, inc.File)
+	}
+	data, err := os.ReadFile(inc.File)
+	if err != nil {
+		sa.includeError(inc, fmt.Sprintf("%s cannot read %q: %v", name, inc.Path, unwrapPathError(err)))
+		return
+	}
+	if inc.Text && bytes.IndexByte(data, 0) >= 0 {
+		sa.includeError(inc, fmt.Sprintf("%q contains NUL bytes, so it cannot be a string; embed it with include_bytes", inc.Path))
+		return
+	}
+	inc.Data = data
+}
+
+// unwrapPathError drops the operation and path os errors repeat
+func unwrapPathError(err error) error {
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
+
+func (sa *SemanticAnalyzer) includeError(inc *Include, message string) {
+	loc := inc.Loc()
+	sa.diagnostics.AddErrorWithCode(string(ErrInclude), CategorySemantic, message,
+		sa.filePath, loc.Line, loc.Column, sa.getSourceLine(loc.Line))
+}
+
+// useInclude returns the label of inc's contents, which are emitted with the
+// program
+func (cg *CodeGenerator) useInclude(inc *Include) string {
+	for i, used := range cg.includes {
+		if used.File == inc.File {
+			return fmt.Sprintf(".lotus_include_%d", i)
+		}
+	}
+	cg.includes = append(cg.includes, inc)
+	return fmt.Sprintf(".lotus_include_%d", len(cg.includes)-1)
+}
+
+// generateInclude loads the address of inc's contents into reg
+func (cg *CodeGenerator) generateInclude(inc *Include, reg string) {
+	cg.asm().LeaLabel(cg.useInclude(inc), reg)
+}
+
+// includeData returns the .rodata holding every embedded file
+func (cg *CodeGenerator) includeData() string {
+	if len(cg.includes) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("    .section .rodata\n")
+	for i, inc := range cg.includes {
+		fmt.Fprintf(&b, "    # %s\n.lotus_include_%d:\n", inc.Path, i)
+		data := append(inc.Data[:len(inc.Data):len(inc.Data)], 0)
+		for j := 0; j < len(data); j += 16 {
+			values := make([]string, 0, 16)
+			for _, c := range data[j:min(j+16, len(data))] {
+				values = append(values, fmt.Sprintf("0x%02x", c))
+			}
+			fmt.Fprintf(&b, "    .byte %s\n", strings.Join(values, ", "))
+		}
+	}
+	b.WriteString(DataSectionDirective + "\n")
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// init.go - Static initializers
+// A file may contain top-level init blocks, run once before main:
+//
+//	init {
+//	    crc::build_table();
+//	}
+//
+// Each block becomes a function of its own (init.0, init.1, ...) that the
+// entry point calls before main. Blocks run in dependency order: those of a
+// module before those of every module that imports it, and within a file in
+// the order written.
+// Variables declared in a block are local to it; a block can return early
+// with ret, and its value is ignored.
+
+// isInitBlock reports whether an init block starts here: init {
+func (p *Parser) isInitBlock() bool {
+	return p.current().Type == TokenIdentifier && p.current().Value == "init" && p.peek().Type == TokenLBrace
+}
+
+// parseInitBlock parses init { ... } into the function that runs it. The
+// function is named once the whole program is known, by nameInitBlocks.
+func (p *Parser) parseInitBlock() (*FunctionDefinition, error) {
+	start := p.current()
+	p.advance()
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	fn := &FunctionDefinition{Name: "init", ReturnType: TokenTypeInt, Body: body, Init: true}
+	setLocation(fn, start)
+	return fn, nil
+}
+
+// nameInitBlocks gives the init blocks of program, which lists modules in
+// dependency order before the file being compiled, their function names
+func nameInitBlocks(program []ASTNode) {
+	n := 0
+	for _, stmt := range program {
+		if fn, ok := stmt.(*FunctionDefinition); ok && fn.Init {
+			fn.Name = fmt.Sprintf("init.%d", n)
+			n++
+		}
+	}
+}
+
+// initCalls returns the entry point's calls to the init blocks, in order
+func (cg *CodeGenerator) initCalls() string {
+	if len(cg.initFunctions) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	asm := cg.emitterFor(&b)
+	asm.Comment("Run init blocks")
+	for _, name := range cg.initFunctions {
+		asm.Call(cg.getFunctionLabel(name))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
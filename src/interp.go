@@ -0,0 +1,821 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// interp.go - AST interpreter for compile-time evaluation
+// Runs Lotus code inside the compiler, for `comptime { }` blocks and
+// `lotus eval`. Integers, bools, chars and strings are supported, along with
+// user functions, constants and the pure math functions. Arithmetic matches
+// the generated code: 64-bit wrapping integers, truncating division and
+// logical right shifts. Anything that needs the machine at run time, such as
+// memory, I/O or pointers, is an error.
+
+// Limits on each evaluation, so a runaway loop or recursion fails the
+// build instead of hanging it
+const (
+	InterpMaxSteps = 10_000_000
+	InterpMaxDepth = 1000
+)
+
+// ValueKind is the type of an interpreter value
+type ValueKind int
+
+const (
+	ValueVoid ValueKind = iota // No value: a call that returned nothing
+	ValueInt
+	ValueBool
+	ValueChar
+	ValueString
+	ValueNull
+)
+
+// Value is a value computed by the interpreter. Ints, bools (0 or 1) and
+// chars (the code point) are held in Int; strings in Str.
+type Value struct {
+	Kind ValueKind
+	Int  int
+	Str  string
+}
+
+// IntValue returns an int value
+func IntValue(n int) Value {
+	return Value{Kind: ValueInt, Int: n}
+}
+
+// BoolValue returns a bool value
+func BoolValue(b bool) Value {
+	if b {
+		return Value{Kind: ValueBool, Int: 1}
+	}
+	return Value{Kind: ValueBool}
+}
+
+// String formats v the way a program would print it
+func (v Value) String() string {
+	switch v.Kind {
+	case ValueInt:
+		return strconv.Itoa(v.Int)
+	case ValueBool:
+		return strconv.FormatBool(v.Int != 0)
+	case ValueChar:
+		return string(rune(v.Int))
+	case ValueString:
+		return v.Str
+	case ValueNull:
+		return "null"
+	}
+	return "(no value)"
+}
+
+// Literal returns the AST literal for v, for baking it into the program
+func (v Value) Literal() ASTNode {
+	switch v.Kind {
+	case ValueBool:
+		return &BoolLiteral{Value: v.Int != 0}
+	case ValueChar:
+		return &CharLiteral{Value: string(rune(v.Int))}
+	case ValueString:
+		return &StringLiteral{Value: v.Str}
+	case ValueNull:
+		return &NullLiteral{}
+	}
+	return &IntLiteral{Value: v.Int}
+}
+
+// truthy reports whether v passes a condition, as a nonzero register would
+func (v Value) truthy() bool {
+	switch v.Kind {
+	case ValueString:
+		return true
+	case ValueNull, ValueVoid:
+		return false
+	}
+	return v.Int != 0
+}
+
+// EvalError is a failure to evaluate code at compile time
+type EvalError struct {
+	Loc Location
+	Msg string
+}
+
+func (e *EvalError) Error() string {
+	if e.Loc.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Loc.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+// flow is how a statement finished
+type flow int
+
+const (
+	flowNext flow = iota
+	flowReturn
+)
+
+// Interpreter evaluates Lotus code at compile time
+type Interpreter struct {
+	functions map[string]*FunctionDefinition
+	constants map[string]*ConstantDeclaration
+	imports   map[string]string // Import alias -> stdlib module, or "" for a source module
+	mathNames map[string]bool   // math functions imported unqualified
+
+	globals  map[string]Value // Constants evaluated so far
+	pending  map[string]bool  // Constants being evaluated, to catch cycles
+	scopes   []map[string]Value
+	result   Value // Value of the last return
+	steps    int
+	depth    int
+	maxSteps int
+}
+
+// NewInterpreter returns an interpreter that knows the functions, constants
+// and imports declared at the top level of program
+func NewInterpreter(program []ASTNode) *Interpreter {
+	in := &Interpreter{
+		functions: make(map[string]*FunctionDefinition),
+		constants: make(map[string]*ConstantDeclaration),
+		imports:   make(map[string]string),
+		mathNames: make(map[string]bool),
+		globals:   make(map[string]Value),
+		pending:   make(map[string]bool),
+		maxSteps:  InterpMaxSteps,
+	}
+	for _, node := range program {
+		switch n := node.(type) {
+		case *FunctionDefinition:
+			in.functions[n.Name] = n
+		case *ConstantDeclaration:
+			in.constants[n.Name] = n
+		case *ImportStatement:
+			in.declareImport(n)
+		}
+	}
+	return in
+}
+
+// declareImport records the names an import makes callable
+func (in *Interpreter) declareImport(imp *ImportStatement) {
+	alias := imp.Alias
+	if alias == "" {
+		alias = imp.Module
+	}
+	if imp.Source != "" {
+		in.imports[alias] = ""
+		return
+	}
+	in.imports[alias] = imp.Module
+	if imp.Module != "math" {
+		return
+	}
+	if len(imp.Items) > 0 && !imp.IsWildcard {
+		for _, item := range imp.Items {
+			in.mathNames[item] = true
+		}
+		return
+	}
+	for name := range StandardLibrary["math"].Functions {
+		in.mathNames[name] = true
+	}
+}
+
+// Eval evaluates an expression that can only see constants and functions
+func (in *Interpreter) Eval(expr ASTNode) (Value, error) {
+	if in.scopes == nil {
+		in.steps = 0
+	}
+	saved := in.scopes
+	in.scopes = []map[string]Value{{}}
+	defer func() { in.scopes = saved }()
+	return in.eval(expr)
+}
+
+// Call calls the function name with args
+func (in *Interpreter) Call(name string, args ...Value) (Value, error) {
+	fn, ok := in.functions[name]
+	if !ok {
+		return Value{}, &EvalError{Msg: fmt.Sprintf("unknown function '%s'", name)}
+	}
+	if in.scopes == nil {
+		in.steps = 0
+	}
+	return in.callFunction(fn, args, Location{})
+}
+
+// RunBlock runs the body of a comptime block and returns the value it returns
+func (in *Interpreter) RunBlock(block *ComptimeBlock) (Value, error) {
+	if in.scopes == nil {
+		in.steps = 0
+	}
+	saved := in.scopes
+	in.scopes = []map[string]Value{{}}
+	defer func() { in.scopes = saved }()
+
+	f, err := in.execBlock(block.Body)
+	if err != nil {
+		return Value{}, err
+	}
+	if f != flowReturn || in.result.Kind == ValueVoid {
+		return Value{}, in.errorf(block, "comptime block must return a value")
+	}
+	return in.result, nil
+}
+
+// errorf returns an EvalError at node
+func (in *Interpreter) errorf(node ASTNode, format string, args ...interface{}) error {
+	var loc Location
+	if node != nil {
+		loc = node.Loc()
+	}
+	return &EvalError{Loc: loc, Msg: fmt.Sprintf(format, args...)}
+}
+
+// step counts one unit of work against the limit
+func (in *Interpreter) step(node ASTNode) error {
+	in.steps++
+	if in.steps > in.maxSteps {
+		return in.errorf(node, "evaluation did not finish within %d steps", in.maxSteps)
+	}
+	return nil
+}
+
+// lookup finds a variable or constant
+func (in *Interpreter) lookup(id *Identifier) (Value, error) {
+	for i := len(in.scopes) - 1; i >= 0; i-- {
+		if v, ok := in.scopes[i][id.Name]; ok {
+			return v, nil
+		}
+	}
+	if v, ok := in.globals[id.Name]; ok {
+		return v, nil
+	}
+	decl, ok := in.constants[id.Name]
+	if !ok {
+		return Value{}, in.errorf(id, "'%s' is not known at compile time", id.Name)
+	}
+	if in.pending[id.Name] {
+		return Value{}, in.errorf(id, "constant '%s' depends on itself", id.Name)
+	}
+
+	// Constants are evaluated on first use, with nothing else in scope
+	in.pending[id.Name] = true
+	defer delete(in.pending, id.Name)
+	saved := in.scopes
+	in.scopes = []map[string]Value{{}}
+	v, err := in.eval(decl.Value)
+	in.scopes = saved
+	if err != nil {
+		return Value{}, err
+	}
+	in.globals[id.Name] = v
+	return v, nil
+}
+
+// assign sets an existing variable
+func (in *Interpreter) assign(target ASTNode, v Value) error {
+	id, ok := target.(*Identifier)
+	if !ok {
+		return in.errorf(target, "only variables can be assigned at compile time")
+	}
+	for i := len(in.scopes) - 1; i >= 0; i-- {
+		if _, ok := in.scopes[i][id.Name]; ok {
+			in.scopes[i][id.Name] = v
+			return nil
+		}
+	}
+	if _, ok := in.constants[id.Name]; ok {
+		return in.errorf(target, "cannot assign to constant '%s'", id.Name)
+	}
+	return in.errorf(target, "'%s' is not known at compile time", id.Name)
+}
+
+// execBlock runs statements in a new scope
+func (in *Interpreter) execBlock(body []ASTNode) (flow, error) {
+	in.scopes = append(in.scopes, map[string]Value{})
+	defer func() { in.scopes = in.scopes[:len(in.scopes)-1] }()
+	for _, stmt := range body {
+		f, err := in.exec(stmt)
+		if err != nil || f == flowReturn {
+			return f, err
+		}
+	}
+	return flowNext, nil
+}
+
+// exec runs one statement
+func (in *Interpreter) exec(node ASTNode) (flow, error) {
+	if err := in.step(node); err != nil {
+		return flowNext, err
+	}
+	switch n := node.(type) {
+	case *VariableDeclaration:
+		v, err := in.evalValue(n.Value)
+		if err != nil {
+			return flowNext, err
+		}
+		in.scopes[len(in.scopes)-1][n.Name] = v
+	case *ConstantDeclaration:
+		v, err := in.evalValue(n.Value)
+		if err != nil {
+			return flowNext, err
+		}
+		in.scopes[len(in.scopes)-1][n.Name] = v
+	case *Assignment:
+		v, err := in.evalValue(n.Value)
+		if err != nil {
+			return flowNext, err
+		}
+		return flowNext, in.assign(n.Target, v)
+	case *CompoundAssignment:
+		current, err := in.evalValue(n.Target)
+		if err != nil {
+			return flowNext, err
+		}
+		operand, err := in.evalValue(n.Value)
+		if err != nil {
+			return flowNext, err
+		}
+		ops := map[TokenType]TokenType{TokenPlusEq: TokenPlus, TokenMinusEq: TokenMinus,
+			TokenStarEq: TokenStar, TokenSlashEq: TokenSlash, TokenPercentEq: TokenPercent}
+		v, err := in.arithmetic(n, ops[n.Operator], current, operand)
+		if err != nil {
+			return flowNext, err
+		}
+		return flowNext, in.assign(n.Target, v)
+	case *IncrementOp:
+		_, err := in.eval(n)
+		return flowNext, err
+	case *ReturnStatement:
+		in.result = Value{}
+		if n.Value != nil {
+			v, err := in.evalValue(n.Value)
+			if err != nil {
+				return flowNext, err
+			}
+			in.result = v
+		}
+		return flowReturn, nil
+	case *IfStatement:
+		cond, err := in.evalValue(n.Condition)
+		if err != nil {
+			return flowNext, err
+		}
+		if cond.truthy() {
+			return in.execBlock(n.ThenBody)
+		}
+		return in.execBlock(n.ElseBody)
+	case *WhileLoop:
+		for {
+			cond, err := in.evalValue(n.Condition)
+			if err != nil || !cond.truthy() {
+				return flowNext, err
+			}
+			if f, err := in.execBlock(n.Body); err != nil || f == flowReturn {
+				return f, err
+			}
+		}
+	case *ForLoop:
+		return in.execFor(n)
+	case *FunctionCall:
+		_, err := in.eval(n)
+		return flowNext, err
+	default:
+		return flowNext, in.errorf(node, "%s cannot be run at compile time", nodeDescription(node))
+	}
+	return flowNext, nil
+}
+
+// execFor runs a for loop, whose init variable is scoped to the loop
+func (in *Interpreter) execFor(loop *ForLoop) (flow, error) {
+	in.scopes = append(in.scopes, map[string]Value{})
+	defer func() { in.scopes = in.scopes[:len(in.scopes)-1] }()
+	if loop.Init != nil {
+		if _, err := in.exec(loop.Init); err != nil {
+			return flowNext, err
+		}
+	}
+	for {
+		if loop.Condition != nil {
+			cond, err := in.evalValue(loop.Condition)
+			if err != nil || !cond.truthy() {
+				return flowNext, err
+			}
+		}
+		if f, err := in.execBlock(loop.Body); err != nil || f == flowReturn {
+			return f, err
+		}
+		if loop.Update != nil {
+			if _, err := in.exec(loop.Update); err != nil {
+				return flowNext, err
+			}
+		}
+	}
+}
+
+// evalValue evaluates an expression that must produce a value
+func (in *Interpreter) evalValue(node ASTNode) (Value, error) {
+	v, err := in.eval(node)
+	if err == nil && v.Kind == ValueVoid {
+		return Value{}, in.errorf(node, "%s has no value", nodeDescription(node))
+	}
+	return v, err
+}
+
+// evalInt evaluates an expression used as an integer; chars and bools are
+// integers in the generated code
+func (in *Interpreter) evalInt(node ASTNode) (int, error) {
+	v, err := in.evalValue(node)
+	if err != nil {
+		return 0, err
+	}
+	if v.Kind == ValueString || v.Kind == ValueNull {
+		return 0, in.errorf(node, "expected an integer, got %s", v.Kind)
+	}
+	return v.Int, nil
+}
+
+// eval evaluates an expression
+func (in *Interpreter) eval(node ASTNode) (Value, error) {
+	if err := in.step(node); err != nil {
+		return Value{}, err
+	}
+	switch n := node.(type) {
+	case *IntLiteral:
+		return IntValue(n.Value), nil
+	case *BoolLiteral:
+		return BoolValue(n.Value), nil
+	case *CharLiteral:
+		return Value{Kind: ValueChar, Int: int([]rune(n.Value)[0])}, nil
+	case *StringLiteral:
+		return Value{Kind: ValueString, Str: n.Value}, nil
+	case *Include:
+		if !n.Text {
+			return Value{}, in.errorf(n, "include_bytes has no value at compile time; use include_str")
+		}
+		return Value{Kind: ValueString, Str: string(n.Data)}, nil
+	case *NullLiteral:
+		return Value{Kind: ValueNull}, nil
+	case *Identifier:
+		return in.lookup(n)
+	case *BinaryOp:
+		left, err := in.evalValue(n.Left)
+		if err != nil {
+			return Value{}, err
+		}
+		right, err := in.evalValue(n.Right)
+		if err != nil {
+			return Value{}, err
+		}
+		return in.arithmetic(n, n.Operator, left, right)
+	case *BitwiseOp:
+		left, err := in.evalInt(n.Left)
+		if err != nil {
+			return Value{}, err
+		}
+		right, err := in.evalInt(n.Right)
+		if err != nil {
+			return Value{}, err
+		}
+		return IntValue(bitwise(n.Operator, left, right)), nil
+	case *UnaryOp:
+		return in.evalUnary(n)
+	case *Conversion:
+		return in.evalValue(n.Value)
+	case *Unwrap:
+		return in.evalUnwrap(n)
+	case *Comparison:
+		return in.evalComparison(n)
+	case *LogicalOp:
+		left, err := in.evalValue(n.Left)
+		if err != nil {
+			return Value{}, err
+		}
+		if (n.Operator == TokenAnd) != left.truthy() {
+			return BoolValue(left.truthy()), nil
+		}
+		right, err := in.evalValue(n.Right)
+		if err != nil {
+			return Value{}, err
+		}
+		return BoolValue(right.truthy()), nil
+	case *TernaryOp:
+		cond, err := in.evalValue(n.Condition)
+		if err != nil {
+			return Value{}, err
+		}
+		if cond.truthy() {
+			return in.evalValue(n.TrueExpr)
+		}
+		return in.evalValue(n.FalseExpr)
+	case *IncrementOp:
+		old, err := in.evalInt(n.Operand)
+		if err != nil {
+			return Value{}, err
+		}
+		updated := old + 1
+		if n.Operator == TokenMinusMinus {
+			updated = old - 1
+		}
+		if err := in.assign(n.Operand, IntValue(updated)); err != nil {
+			return Value{}, err
+		}
+		if n.IsPrefix {
+			return IntValue(updated), nil
+		}
+		return IntValue(old), nil
+	case *FunctionCall:
+		return in.evalCall(n)
+	case *ComptimeBlock:
+		return in.RunBlock(n)
+	}
+	return Value{}, in.errorf(node, "%s cannot be evaluated at compile time", nodeDescription(node))
+}
+
+// arithmetic applies + - * / % to integers
+func (in *Interpreter) arithmetic(node ASTNode, op TokenType, left, right Value) (Value, error) {
+	for _, v := range []Value{left, right} {
+		if v.Kind == ValueString || v.Kind == ValueNull {
+			return Value{}, in.errorf(node, "operator %s needs integers, got %s", TokenTypeName(op), v.Kind)
+		}
+	}
+	a, b := left.Int, right.Int
+	switch op {
+	case TokenPlus:
+		return IntValue(a + b), nil
+	case TokenMinus:
+		return IntValue(a - b), nil
+	case TokenStar:
+		return IntValue(a * b), nil
+	case TokenSlash, TokenPercent:
+		if b == 0 {
+			return Value{}, in.errorf(node, "division by zero")
+		}
+		if op == TokenSlash {
+			return IntValue(a / b), nil
+		}
+		return IntValue(a % b), nil
+	}
+	return Value{}, in.errorf(node, "unsupported operator %s", TokenTypeName(op))
+}
+
+// bitwise applies & | ^ << >>. Shift counts are taken mod 64 and >> is a
+// logical shift, as in the generated code.
+func bitwise(op TokenType, a, b int) int {
+	switch op {
+	case TokenAmpersand:
+		return a & b
+	case TokenPipe:
+		return a | b
+	case TokenCaret:
+		return a ^ b
+	case TokenLShift:
+		return a << (uint(b) & 63)
+	case TokenRShift:
+		return int(uint64(a) >> (uint(b) & 63))
+	}
+	return 0
+}
+
+func (in *Interpreter) evalUnary(n *UnaryOp) (Value, error) {
+	switch n.Operator {
+	case TokenMinus, TokenTilde:
+		v, err := in.evalInt(n.Operand)
+		if err != nil {
+			return Value{}, err
+		}
+		if n.Operator == TokenMinus {
+			return IntValue(-v), nil
+		}
+		return IntValue(^v), nil
+	case TokenExclaim:
+		v, err := in.evalValue(n.Operand)
+		if err != nil {
+			return Value{}, err
+		}
+		return BoolValue(!v.truthy()), nil
+	}
+	return Value{}, in.errorf(n, "pointers cannot be used at compile time")
+}
+
+func (in *Interpreter) evalComparison(n *Comparison) (Value, error) {
+	left, err := in.evalValue(n.Left)
+	if err != nil {
+		return Value{}, err
+	}
+	right, err := in.evalValue(n.Right)
+	if err != nil {
+		return Value{}, err
+	}
+	if left.Kind == ValueString || right.Kind == ValueString {
+		// The generated code compares string addresses, which are not known yet
+		return Value{}, in.errorf(n, "strings cannot be compared at compile time")
+	}
+	a, b := left.Int, right.Int
+	switch n.Operator {
+	case TokenEqual:
+		return BoolValue(a == b), nil
+	case TokenNotEqual:
+		return BoolValue(a != b), nil
+	case TokenLess:
+		return BoolValue(a < b), nil
+	case TokenLessEq:
+		return BoolValue(a <= b), nil
+	case TokenGreater:
+		return BoolValue(a > b), nil
+	case TokenGreaterEq:
+		return BoolValue(a >= b), nil
+	}
+	return Value{}, in.errorf(n, "unsupported comparison %s", TokenTypeName(n.Operator))
+}
+
+// evalCall calls a user function or a math function
+func (in *Interpreter) evalCall(call *FunctionCall) (Value, error) {
+	name := call.Name
+	module := ""
+	if alias, fn, ok := strings.Cut(name, "::"); ok {
+		stdlib, imported := in.imports[alias]
+		if !imported {
+			if _, ok := StandardLibrary[alias]; !ok {
+				return Value{}, in.errorf(call, "module '%s' is not imported", alias)
+			}
+			stdlib = alias
+		}
+		name, module = fn, stdlib
+	} else if _, ok := in.functions[name]; !ok && in.mathNames[name] {
+		module = "math"
+	}
+
+	args := make([]Value, len(call.Args))
+	for i, arg := range call.Args {
+		v, err := in.evalValue(arg)
+		if err != nil {
+			return Value{}, err
+		}
+		args[i] = v
+	}
+
+	switch module {
+	case "":
+		fn, ok := in.functions[name]
+		if !ok {
+			return Value{}, in.errorf(call, "'%s' cannot be called at compile time", call.Name)
+		}
+		return in.callFunction(fn, args, call.Loc())
+	case "math":
+		return in.callMath(call, name, args)
+	}
+	return Value{}, in.errorf(call, "%s::%s cannot be called at compile time", module, name)
+}
+
+// callFunction runs a user function with its parameters bound to args
+func (in *Interpreter) callFunction(fn *FunctionDefinition, args []Value, at Location) (Value, error) {
+	if fn.Variadic() {
+		return Value{}, &EvalError{Loc: at, Msg: fmt.Sprintf("variadic function %s cannot be called at compile time", fn.Name)}
+	}
+	if len(args) != len(fn.Parameters) {
+		return Value{}, &EvalError{Loc: at, Msg: fmt.Sprintf("%s takes %d arguments, got %d", fn.Name, len(fn.Parameters), len(args))}
+	}
+	if in.depth >= InterpMaxDepth {
+		return Value{}, &EvalError{Loc: at, Msg: fmt.Sprintf("calls nested more than %d deep", InterpMaxDepth)}
+	}
+	params := make(map[string]Value, len(args))
+	for i, param := range fn.Parameters {
+		params[param.Name] = args[i]
+	}
+
+	// The callee sees only its parameters, constants and functions
+	saved := in.scopes
+	in.scopes = []map[string]Value{params}
+	in.depth++
+	f, err := in.execBlock(fn.Body)
+	in.depth--
+	in.scopes = saved
+	if err != nil {
+		return Value{}, err
+	}
+	if f != flowReturn {
+		return Value{}, nil
+	}
+	result := in.result
+	in.result = Value{}
+	return result, nil
+}
+
+// callMath evaluates a math module function as its generated code would
+func (in *Interpreter) callMath(call *FunctionCall, name string, args []Value) (Value, error) {
+	fn, ok := StandardLibrary["math"].Functions[name]
+	if !ok {
+		return Value{}, in.errorf(call, "math has no function '%s'", name)
+	}
+	if len(args) != fn.NumArgs {
+		return Value{}, in.errorf(call, "math::%s takes %d arguments, got %d", name, fn.NumArgs, len(args))
+	}
+	n := make([]int, len(args))
+	for i, arg := range args {
+		if arg.Kind == ValueString || arg.Kind == ValueNull {
+			return Value{}, in.errorf(call, "math::%s needs integers, got %s", name, arg.Kind)
+		}
+		n[i] = arg.Int
+	}
+
+	switch name {
+	case "abs":
+		if n[0] < 0 {
+			return IntValue(-n[0]), nil
+		}
+		return IntValue(n[0]), nil
+	case "min":
+		if n[1] < n[0] {
+			return IntValue(n[1]), nil
+		}
+		return IntValue(n[0]), nil
+	case "max":
+		if n[1] > n[0] {
+			return IntValue(n[1]), nil
+		}
+		return IntValue(n[0]), nil
+	case "sqrt":
+		if n[0] < 0 {
+			return IntValue(-1), nil
+		}
+		return IntValue(int(math.Sqrt(float64(n[0])))), nil
+	case "pow":
+		if n[1] < 0 {
+			return IntValue(0), nil
+		}
+		result, base := 1, n[0]
+		for e := n[1]; e > 0; e >>= 1 {
+			if e&1 != 0 {
+				result *= base
+			}
+			base *= base
+		}
+		return IntValue(result), nil
+	case "floor", "ceil", "round":
+		return IntValue(n[0]), nil
+	case "gcd", "lcm":
+		// Unsigned division, as in the generated code
+		a, b := uint64(n[0]), uint64(n[1])
+		for b != 0 {
+			a, b = b, a%b
+		}
+		if name == "gcd" {
+			return IntValue(int(a)), nil
+		}
+		if a == 0 {
+			return Value{}, in.errorf(call, "division by zero")
+		}
+		return IntValue(int(uint64(n[0])/a) * n[1]), nil
+	}
+	return Value{}, in.errorf(call, "math::%s cannot be called at compile time", name)
+}
+
+// String names a value kind in messages
+func (k ValueKind) String() string {
+	switch k {
+	case ValueInt:
+		return "int"
+	case ValueBool:
+		return "bool"
+	case ValueChar:
+		return "char"
+	case ValueString:
+		return "string"
+	case ValueNull:
+		return "null"
+	}
+	return "no value"
+}
+
+// nodeDescription names a node in messages
+func nodeDescription(node ASTNode) string {
+	switch n := node.(type) {
+	case *FunctionCall:
+		return fmt.Sprintf("call to %s", n.Name)
+	case *FunctionDefinition:
+		return "function definition"
+	case *ComptimeBlock:
+		return "comptime block"
+	case *FloatLiteral:
+		return "float"
+	case *ImportStatement:
+		return "use statement"
+	}
+	name := fmt.Sprintf("%T", node)
+	name = strings.TrimPrefix(name, "*main.")
+	// Split CamelCase into words: ArrayAccess -> array access
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToLower(b.String())
+}
@@ -9,16 +9,18 @@ type TokenType int
 // Token type constants - organized by category
 const (
 	// Keywords and control flow
-	TokenRet    TokenType = iota
-	TokenReturn           // return
-	TokenConst            // const
-	TokenIf               // if
-	TokenElse             // else
-	TokenWhile            // while
-	TokenFor              // for
-	TokenFn               // fn
-	TokenUse              // use (imports)
-	TokenAs               // as (aliasing)
+	TokenRet      TokenType = iota
+	TokenReturn             // return
+	TokenConst              // const
+	TokenIf                 // if
+	TokenElse               // else
+	TokenWhile              // while
+	TokenFor                // for
+	TokenFn                 // fn
+	TokenUse                // use (imports)
+	TokenAs                 // as (aliasing)
+	TokenComptime           // comptime (compile-time evaluation)
+	TokenGoto               // goto
 
 	// Literals
 	TokenInt        // integer literal
@@ -113,6 +115,7 @@ const (
 	TokenColon    // :
 	TokenArrow    // ->
 	TokenQuestion // ?
+	TokenAt       // @
 	TokenNewline  // newline
 	TokenAssign   // =
 
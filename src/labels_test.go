@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Code must take its labels from getLabel rather than use GNU as numeric
+// local labels: once fragments are inlined, reordered or outlined, a 1f can
+// bind to the 1: of a neighbouring fragment and still assemble. These tests
+// look for them in everything the code generator can emit.
+
+// numericLabelPattern matches a GNU as local label, defined (1:) or referenced
+// (jne 1f, jmp 2b)
+var numericLabelPattern = regexp.MustCompile(`^\s*[0-9]+:|^\s*[a-z]+\s+[0-9]+[fb]\s*(#.*)?$`)
+
+// lintLocalLabels reports each line of code, from what, that uses a numeric
+// local label
+func lintLocalLabels(t *testing.T, what, code string) {
+	t.Helper()
+	for i, line := range strings.Split(code, "\n") {
+		if numericLabelPattern.MatchString(line) {
+			t.Errorf("%s, line %d: numeric local label: %s", what, i+1, strings.TrimSpace(line))
+		}
+	}
+}
+
+func TestRuntimeLabels(t *testing.T) {
+	cg := NewCodeGenerator()
+	cg.coverageFile = "cover.out"
+	cg.profiledFunctions = []string{"main"}
+	cg.initFunctions = []string{"init.0"}
+	for name, emit := range map[string]func() string{
+		"check-memory runtime":      cg.memcheckRuntime,
+		"check-memory startup":      cg.memcheckSetup,
+		"allocation counters":       cg.memstatRuntime,
+		"stderr print helpers":      cg.rtprintRuntime,
+		"DEFLATE runtime":           cg.deflateRuntime,
+		"edit distance runtime":     cg.distanceRuntime,
+		"wildcard matching runtime": cg.globRuntime,
+		"logging runtime":           cg.logRuntime,
+		"MessagePack runtime":       cg.msgpackRuntime,
+		"Protocol Buffers runtime":  cg.pbRuntime,
+		"float formatting runtime":  cg.floatRuntime,
+		"shutdown handler":          cg.shutdownRuntime,
+		"exit handlers":             cg.atexitRuntime,
+		"function profiling":        cg.profileRuntime,
+		"sampling profiler":         cg.sampleRuntime,
+		"sampling profiler startup": cg.sampleSetup,
+		"coverage startup":          cg.coverageSetup,
+		"init calls":                cg.initCalls,
+	} {
+		code := emit()
+		if strings.TrimSpace(code) == "" {
+			t.Errorf("%s: nothing emitted", name)
+		}
+		lintLocalLabels(t, name, code)
+	}
+}
+
+// TestStdlibLabels emits every stdlib function with integer arguments, as
+// many as it takes, or one for a variadic function. Some expect other forms
+// of argument and give up; they are covered by the fixtures.
+func TestStdlibLabels(t *testing.T) {
+	var names []string
+	for module, m := range StandardLibrary {
+		for name := range m.Functions {
+			names = append(names, module+"::"+name)
+		}
+	}
+	sort.Strings(names)
+	emitted := 0
+	for _, qualified := range names {
+		module, name, _ := strings.Cut(qualified, "::")
+		fn := StandardLibrary[module].Functions[name]
+		args := make([]ASTNode, max(fn.NumArgs, 1))
+		for i := range args {
+			args[i] = &IntLiteral{Value: i + 1}
+		}
+		cg := NewCodeGenerator()
+		cg.stackOffset = -64
+		if emitStdlib(cg, fn, args) {
+			emitted++
+			lintLocalLabels(t, qualified, cg.textSection.String())
+		}
+	}
+	if emitted < len(names)/2 {
+		t.Errorf("only %d of %d stdlib functions could be emitted", emitted, len(names))
+	}
+}
+
+// emitStdlib runs fn's code generator, reporting whether it finished
+func emitStdlib(cg *CodeGenerator, fn *StdlibFunction, args []ASTNode) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	fn.CodeGen(cg, args)
+	return !cg.diagnostics.HasErrors()
+}
+
+// TestFixtureLabels compiles the example programs and test fixtures with the
+// flags that add runtimes and instrumentation
+func TestFixtureLabels(t *testing.T) {
+	fixtures, _ := filepath.Glob(filepath.Join("..", "examples", "*.lts"))
+	more, _ := filepath.Glob(filepath.Join("testdata", "*.lts"))
+	fixtures = append(fixtures, more...)
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures")
+	}
+	for _, path := range fixtures {
+		for _, flags := range [][]string{
+			{"-O0"}, {"-O2"}, {"-check-memory"}, {"-trace-stdlib"}, {"-profile-sample"},
+			{"-instrument-functions"}, {"-stack-probe"}, {"-g"},
+		} {
+			out := filepath.Join(t.TempDir(), "out.s")
+			opts, _, err := ParseFlags(append(flags, "-S", "-q", "-o", out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := NewCompiler(opts).CompileFile(path); err != nil {
+				t.Errorf("%s %v: %v", path, flags, err)
+				continue
+			}
+			asm, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lintLocalLabels(t, fmt.Sprintf("%s %v", filepath.Base(path), flags), string(asm))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import "fmt"
+
+// layouts.go - Field accessors for the records stdlib calls fill in
+// file::stat and time::gmtime write into a buffer the program passes them,
+// and reading a field meant knowing its offset and width. Each such record
+// is described here once, and every field gets an accessor in the module
+// that fills it in:
+//
+//	int st = mem::malloc(file::stat_sizeof());
+//	file::stat("notes.txt", st);
+//	int size = file::stat_size(st);
+//	int mode = file::stat_mode(st);
+//
+// Accessors are named <prefix>_<field> and take the buffer; <prefix>_sizeof()
+// is the size to allocate. Fields narrower than 8 bytes are zero-extended,
+// and fields kept in network byte order are returned in host order. The
+// language has no struct declarations yet; the layouts are the fields of the
+// StatInfo, Tm and SockAddr types these buffers will become.
+
+// recordField is one field of a stdlib record
+type recordField struct {
+	Name    string
+	Offset  int
+	Size    int  // 2, 4 or 8 bytes
+	Network bool // Stored in network byte order
+}
+
+// recordLayout is a record a stdlib call reads or writes through a pointer
+type recordLayout struct {
+	Type   string // Name of the struct type it will become
+	Module string
+	Prefix string // Of the accessor names
+	Size   int
+	Fields []recordField
+}
+
+var recordLayouts = []recordLayout{
+	{
+		Type: "StatInfo", Module: "file", Prefix: "stat", Size: 144, // struct stat, x86-64 Linux
+		Fields: []recordField{
+			{"dev", 0, 8, false}, {"ino", 8, 8, false}, {"nlink", 16, 8, false},
+			{"mode", 24, 4, false}, {"uid", 28, 4, false}, {"gid", 32, 4, false},
+			{"rdev", 40, 8, false}, {"size", 48, 8, false}, {"blksize", 56, 8, false},
+			{"blocks", 64, 8, false}, {"atime", 72, 8, false}, {"mtime", 88, 8, false},
+			{"ctime", 104, 8, false},
+		},
+	},
+	{
+		Type: "Tm", Module: "time", Prefix: "tm", Size: 72, // As gmtime and localtime write it
+		Fields: []recordField{
+			{"sec", 0, 8, false}, {"min", 8, 8, false}, {"hour", 16, 8, false},
+			{"mday", 24, 8, false}, {"mon", 32, 8, false}, {"year", 40, 8, false},
+			{"wday", 48, 8, false}, {"yday", 56, 8, false}, {"isdst", 64, 8, false},
+		},
+	},
+	{
+		Type: "SockAddr", Module: "net", Prefix: "sockaddr", Size: 16, // struct sockaddr_in
+		Fields: []recordField{
+			{"family", 0, 2, false}, {"port", 2, 2, true}, {"ip", 4, 4, true},
+		},
+	},
+}
+
+func init() {
+	for _, layout := range recordLayouts {
+		functions := StandardLibrary[layout.Module].Functions
+		sizeof := layout.Prefix + "_sizeof"
+		functions[sizeof] = &StdlibFunction{Name: sizeof, Module: layout.Module, NumArgs: 0,
+			CodeGen: generateRecordSize(layout.Size)}
+		for _, field := range layout.Fields {
+			name := layout.Prefix + "_" + field.Name
+			functions[name] = &StdlibFunction{Name: name, Module: layout.Module, NumArgs: 1,
+				CodeGen: generateRecordField(field)}
+		}
+	}
+}
+
+// generateRecordSize returns the code generator of <prefix>_sizeof()
+func generateRecordSize(size int) func(*CodeGenerator, []ASTNode) {
+	return func(cg *CodeGenerator, args []ASTNode) {
+		cg.asm().MovImmReg(size, "rax")
+	}
+}
+
+// generateRecordField returns the code generator of the accessor of field
+func generateRecordField(field recordField) func(*CodeGenerator, []ASTNode) {
+	return func(cg *CodeGenerator, args []ASTNode) {
+		if len(args) != 1 {
+			cg.textSection.WriteString("    xorq %rax, %rax\n")
+			return
+		}
+		cg.generateExpressionToReg(args[0], "rax")
+		switch field.Size {
+		case 2:
+			cg.textSection.WriteString(fmt.Sprintf("    movzwl %d(%%rax), %%eax\n", field.Offset))
+		case 4:
+			cg.textSection.WriteString(fmt.Sprintf("    movl %d(%%rax), %%eax\n", field.Offset))
+		default:
+			cg.textSection.WriteString(fmt.Sprintf("    movq %d(%%rax), %%rax\n", field.Offset))
+		}
+		if field.Network {
+			byteOrder(cg, "rax", 8*field.Size)
+		}
+	}
+}
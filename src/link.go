@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// link.go - Built-in static linker
+// Link turns the ObjectFile the built-in assembler produced into a static,
+// non-PIE x86-64 executable, so -linker=builtin builds need neither as nor
+// ld. The program's allocated sections go into up to three loadable
+// segments, code (with the ELF and program headers in front of it), read-only
+// data, and data followed by .bss, each starting on a new page at
+// linkBase plus its file offset, as ld lays them out. Every relocation is
+// resolved against the final addresses; the program is one object, so a
+// symbol it does not define is an error rather than something to look for in
+// a library. The symbol table, .lotus.meta and any -g line table are kept,
+// with their relocations applied, for lotus disasm, lotus inspect and
+// debuggers.
+
+const (
+	linkBase     = 0x400000 // Address of the first segment, as ld uses for x86-64
+	linkPageSize = 0x1000
+)
+
+// linkSegment is a loadable segment of the executable
+type linkSegment struct {
+	Flags    elf.ProgFlag
+	Sections []*ObjSection
+	Offset   uint64 // File offset, and linkBase plus it the address
+	FileSize uint64
+	MemSize  uint64
+}
+
+// linkLayout places an object's sections in the executable
+type linkLayout struct {
+	obj      *ObjectFile
+	sections []*ObjSection // Allocated ones in segment order, then the rest
+	segments []*linkSegment
+	addr     map[*ObjSection]uint64
+	offset   map[*ObjSection]uint64
+	end      uint64 // File size up to the section contents' end
+}
+
+// LinkExecutable links obj and writes the executable to path
+func LinkExecutable(obj *ObjectFile, entry, path string) error {
+	exe, err := Link(obj, entry)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, exe, 0755)
+}
+
+// Link lays out obj as an executable starting at the symbol entry
+func Link(obj *ObjectFile, entry string) ([]byte, error) {
+	l := &linkLayout{obj: obj, addr: make(map[*ObjSection]uint64), offset: make(map[*ObjSection]uint64)}
+	var text, rodata, data, rest []*ObjSection
+	for _, s := range obj.Sections {
+		switch {
+		case s.Flags&elf.SHF_ALLOC == 0:
+			rest = append(rest, s)
+		case s.Executable():
+			text = append(text, s)
+		case s.Flags&elf.SHF_WRITE == 0:
+			rodata = append(rodata, s)
+		default:
+			data = append(data, s)
+		}
+	}
+	if len(obj.Lines) > 0 {
+		rest = append(rest, obj.debugSections()...)
+	}
+	// .bss takes no file space, so it goes after everything else writable
+	sort.SliceStable(data, func(i, j int) bool {
+		return data[i].Type != elf.SHT_NOBITS && data[j].Type == elf.SHT_NOBITS
+	})
+
+	// The headers come first in the first segment, so its sections start
+	// after them
+	groups := []struct {
+		flags    elf.ProgFlag
+		sections []*ObjSection
+	}{
+		{elf.PF_R | elf.PF_X, text},
+		{elf.PF_R, rodata},
+		{elf.PF_R | elf.PF_W, data},
+	}
+	phnum := 1 // PT_GNU_STACK
+	for _, g := range groups {
+		if len(g.sections) > 0 {
+			phnum++
+		}
+	}
+	offset := uint64(64 + 56*phnum)
+	for _, g := range groups {
+		if len(g.sections) == 0 {
+			continue
+		}
+		seg := &linkSegment{Flags: g.flags, Sections: g.sections}
+		if len(l.segments) > 0 {
+			offset = alignUp(offset, linkPageSize)
+			seg.Offset = offset
+		}
+		for _, s := range g.sections {
+			offset = alignUp(offset, s.Align)
+			l.addr[s] = linkBase + offset
+			l.offset[s] = offset
+			if s.Type == elf.SHT_NOBITS {
+				offset += s.Size
+				continue
+			}
+			offset += uint64(len(s.Data))
+			seg.FileSize = offset - seg.Offset
+		}
+		seg.MemSize = offset - seg.Offset
+		offset = seg.Offset + seg.FileSize
+		l.segments = append(l.segments, seg)
+		l.sections = append(l.sections, g.sections...)
+	}
+	for _, s := range rest {
+		offset = alignUp(offset, s.Align)
+		l.offset[s] = offset
+		if s.Type != elf.SHT_NOBITS {
+			offset += uint64(len(s.Data))
+		}
+	}
+	l.sections = append(l.sections, rest...)
+	l.end = offset
+
+	var start *ObjSymbol
+	for _, sym := range obj.Symbols {
+		if sym.Name == entry && sym.Section != nil {
+			start = sym
+		}
+	}
+	if start == nil {
+		return nil, fmt.Errorf("entry symbol %q is not defined", entry)
+	}
+
+	contents := make(map[*ObjSection][]byte, len(l.sections))
+	var undefined []string
+	seen := make(map[string]bool)
+	for _, s := range l.sections {
+		if s.Type == elf.SHT_NOBITS {
+			continue
+		}
+		data := append([]byte(nil), s.Data...)
+		for _, r := range s.Relocs {
+			if r.Symbol.Section == nil {
+				if !seen[r.Symbol.Name] {
+					seen[r.Symbol.Name] = true
+					undefined = append(undefined, r.Symbol.Name)
+				}
+				continue
+			}
+			if err := l.relocate(data, s, r); err != nil {
+				return nil, err
+			}
+		}
+		contents[s] = data
+	}
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("undefined reference to %s (the built-in linker links no libraries; use -linker=system)",
+			strings.Join(undefined, ", "))
+	}
+	return l.write(contents, l.symbolAddress(start)), nil
+}
+
+// symbolAddress returns where sym ends up; non-allocated sections start at 0
+func (l *linkLayout) symbolAddress(sym *ObjSymbol) uint64 {
+	return l.addr[sym.Section] + sym.Value
+}
+
+// relocate applies r to data, the contents of s
+func (l *linkLayout) relocate(data []byte, s *ObjSection, r ObjReloc) error {
+	value := int64(l.symbolAddress(r.Symbol)) + r.Addend
+	place := l.addr[s] + r.Offset
+	switch r.Type {
+	case elf.R_X86_64_64:
+		binary.LittleEndian.PutUint64(data[r.Offset:], uint64(value))
+		return nil
+	case elf.R_X86_64_32:
+		if value < 0 || value > math.MaxUint32 {
+			return l.truncated(s, r)
+		}
+	case elf.R_X86_64_32S:
+		if value < math.MinInt32 || value > math.MaxInt32 {
+			return l.truncated(s, r)
+		}
+	case elf.R_X86_64_PC32, elf.R_X86_64_PLT32:
+		// The program is static, so a call through the PLT is a direct one
+		value -= int64(place)
+		if value < math.MinInt32 || value > math.MaxInt32 {
+			return l.truncated(s, r)
+		}
+	default:
+		return fmt.Errorf("%s+%#x: unsupported relocation %v against %q", s.Name, r.Offset, r.Type, r.Symbol.Name)
+	}
+	binary.LittleEndian.PutUint32(data[r.Offset:], uint32(value))
+	return nil
+}
+
+// truncated reports a relocation whose value does not fit its field
+func (l *linkLayout) truncated(s *ObjSection, r ObjReloc) error {
+	return fmt.Errorf("%s+%#x: relocation %v against %q does not fit", s.Name, r.Offset, r.Type, r.Symbol.Name)
+}
+
+// write lays out the headers, the linked contents, the symbol table and the
+// section headers
+func (l *linkLayout) write(contents map[*ObjSection][]byte, entry uint64) []byte {
+	obj := l.obj
+
+	// Symbol table: the null symbol, the file, the local symbols and then
+	// the global ones, at their final addresses
+	index := make(map[*ObjSection]int, len(l.sections))
+	for i, s := range l.sections {
+		index[s] = i + 1
+	}
+	symbols := []*ObjSymbol{{}}
+	if obj.SourceFile != "" {
+		symbols = append(symbols, &ObjSymbol{Name: obj.SourceFile, Type: elf.STT_FILE})
+	}
+	var globals []*ObjSymbol
+	for _, sym := range obj.Symbols {
+		if sym.Global {
+			globals = append(globals, sym)
+		} else {
+			symbols = append(symbols, sym)
+		}
+	}
+	firstGlobal := len(symbols)
+	symbols = append(symbols, globals...)
+
+	strtab := newStringTable()
+	var symtab bytes.Buffer
+	for i, sym := range symbols {
+		var entry elf.Sym64
+		if i > 0 {
+			entry.Name = strtab.add(sym.Name)
+			bind := elf.STB_LOCAL
+			if sym.Global {
+				bind = elf.STB_GLOBAL
+			}
+			entry.Info = elf.ST_INFO(bind, sym.Type)
+			entry.Size = sym.Size
+			if sym.Type == elf.STT_FILE {
+				entry.Shndx = uint16(elf.SHN_ABS)
+			} else {
+				entry.Shndx = uint16(index[sym.Section])
+				entry.Value = l.symbolAddress(sym)
+			}
+		}
+		binary.Write(&symtab, binary.LittleEndian, entry)
+	}
+
+	// Section headers: the null one, the program's sections, then the
+	// symbol, string and section name tables after the contents
+	shstrtab := newStringTable()
+	headers := []elf.Section64{{}}
+	for _, s := range l.sections {
+		size := uint64(len(s.Data))
+		if s.Type == elf.SHT_NOBITS {
+			size = s.Size
+		}
+		align := s.Align
+		if align == 0 {
+			align = 1
+		}
+		headers = append(headers, elf.Section64{
+			Name: shstrtab.add(s.Name), Type: uint32(s.Type), Flags: uint64(s.Flags),
+			Addr: l.addr[s], Off: l.offset[s], Size: size, Addralign: align,
+		})
+	}
+	tables := [][]byte{symtab.Bytes(), strtab.Bytes(), nil}
+	offset := alignUp(l.end, 8)
+	headers = append(headers, elf.Section64{
+		Name: shstrtab.add(".symtab"), Type: uint32(elf.SHT_SYMTAB), Off: offset, Size: uint64(symtab.Len()),
+		Link: uint32(len(headers) + 1), Info: uint32(firstGlobal), Addralign: 8, Entsize: 24,
+	})
+	offset += uint64(symtab.Len())
+	headers = append(headers, elf.Section64{
+		Name: shstrtab.add(".strtab"), Type: uint32(elf.SHT_STRTAB), Off: offset, Size: uint64(strtab.Len()), Addralign: 1,
+	})
+	offset += uint64(strtab.Len())
+	headers = append(headers, elf.Section64{
+		Name: shstrtab.add(".shstrtab"), Type: uint32(elf.SHT_STRTAB), Off: offset, Addralign: 1,
+	})
+	headers[len(headers)-1].Size = uint64(shstrtab.Len())
+	tables[2] = shstrtab.Bytes()
+	offset += uint64(shstrtab.Len())
+	shoff := alignUp(offset, 8)
+
+	progs := make([]elf.Prog64, 0, len(l.segments)+1)
+	for _, seg := range l.segments {
+		progs = append(progs, elf.Prog64{
+			Type: uint32(elf.PT_LOAD), Flags: uint32(seg.Flags), Off: seg.Offset,
+			Vaddr: linkBase + seg.Offset, Paddr: linkBase + seg.Offset,
+			Filesz: seg.FileSize, Memsz: seg.MemSize, Align: linkPageSize,
+		})
+	}
+	// A non-executable stack, as ld gives programs that ask for one
+	progs = append(progs, elf.Prog64{Type: uint32(elf.PT_GNU_STACK), Flags: uint32(elf.PF_R | elf.PF_W), Align: 16})
+
+	header := elf.Header64{
+		Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_X86_64), Version: uint32(elf.EV_CURRENT),
+		Entry: entry, Phoff: 64, Shoff: shoff, Ehsize: 64, Phentsize: 56, Phnum: uint16(len(progs)),
+		Shentsize: 64, Shnum: uint16(len(headers)), Shstrndx: uint16(len(headers) - 1),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	header.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+
+	out := make([]byte, shoff+64*uint64(len(headers)))
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, header)
+	binary.Write(&b, binary.LittleEndian, progs)
+	copy(out, b.Bytes())
+	for _, s := range l.sections {
+		copy(out[l.offset[s]:], contents[s])
+	}
+	n := len(headers) - len(tables)
+	for i, t := range tables {
+		copy(out[headers[n+i].Off:], t)
+	}
+	b.Reset()
+	binary.Write(&b, binary.LittleEndian, headers)
+	copy(out[shoff:], b.Bytes())
+	return out
+}
@@ -0,0 +1,157 @@
+package main
+
+import "fmt"
+
+// literals.go - Collection literals
+// Array and map literals build collections-module values in place of the
+// usual new + push/put sequences:
+//
+//	int primes = [2, 3, 5, 7];           // array_int with capacity 4
+//	int ages = {"ann": 31, "bob": 27};   // hashmap_str
+//	int squares = {1: 1, 2: 4, 3: 9};    // hashmap_int
+//
+// A map literal is keyed by strings when its first key is a string literal,
+// variable or constant, and by ints otherwise. An array literal's capacity is
+// its length; grow it with array_int_reserve before pushing more.
+
+// MapLiteral represents a map literal {key: value, ...}
+type MapLiteral struct {
+	BaseNode
+	Keys   []ASTNode
+	Values []ASTNode
+}
+
+func (m *MapLiteral) astNode() {}
+
+// skipLiteralNewlines lets collection literals span lines
+func (p *Parser) skipLiteralNewlines() {
+	for p.current().Type == TokenNewline {
+		p.advance()
+	}
+}
+
+// parseArrayLiteral parses [elem, ...]
+func (p *Parser) parseArrayLiteral() (*ArrayLiteral, error) {
+	if err := p.expect(TokenLBracket); err != nil {
+		return nil, err
+	}
+	lit := &ArrayLiteral{ElemType: TokenTypeInt}
+	p.skipLiteralNewlines()
+	for p.current().Type != TokenRBracket {
+		elem, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		lit.Elements = append(lit.Elements, elem)
+		p.skipLiteralNewlines()
+		if p.current().Type != TokenComma {
+			break
+		}
+		p.advance()
+		p.skipLiteralNewlines()
+	}
+	if err := p.expect(TokenRBracket); err != nil {
+		return nil, err
+	}
+	return lit, nil
+}
+
+// parseMapLiteral parses {key: value, ...}
+func (p *Parser) parseMapLiteral() (*MapLiteral, error) {
+	if err := p.expect(TokenLBrace); err != nil {
+		return nil, err
+	}
+	lit := &MapLiteral{}
+	p.skipLiteralNewlines()
+	for p.current().Type != TokenRBrace {
+		key, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(TokenColon); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		lit.Keys = append(lit.Keys, key)
+		lit.Values = append(lit.Values, value)
+		p.skipLiteralNewlines()
+		if p.current().Type != TokenComma {
+			break
+		}
+		p.advance()
+		p.skipLiteralNewlines()
+	}
+	if len(lit.Keys) == 0 && p.current().Type == TokenRBrace {
+		return nil, p.formatErrorWithCode(ErrExpectedToken, "empty map literal has no key type; use hashmap_int_new or hashmap_str_new")
+	}
+	if err := p.expect(TokenRBrace); err != nil {
+		return nil, err
+	}
+	return lit, nil
+}
+
+// generateArrayLiteral builds an array_int holding the literal's elements
+func (cg *CodeGenerator) generateArrayLiteral(arr *ArrayLiteral) {
+	collectionsAlloc(cg, &IntLiteral{Value: len(arr.Elements)})
+	collectionsInitHeader(cg)
+	cg.textSection.WriteString("    pushq %rax\n") // Save array pointer
+	for i, elem := range arr.Elements {
+		cg.generateExpressionToReg(elem, "rcx")
+		cg.textSection.WriteString("    movq (%rsp), %rax\n")
+		cg.textSection.WriteString(fmt.Sprintf("    movq %%rcx, %d(%%rax)\n", collectionsHeaderSize+8*i))
+	}
+	cg.textSection.WriteString("    popq %rax\n")
+	cg.textSection.WriteString(fmt.Sprintf("    movq $%d, (%%rax)\n", len(arr.Elements)))
+}
+
+// generateMapLiteral builds a hashmap_str or hashmap_int holding the
+// literal's entries, sized so that filling it does not rehash
+func (cg *CodeGenerator) generateMapLiteral(m *MapLiteral) {
+	strKeys := cg.isStringExpr(m.Keys[0])
+	capacity := []ASTNode{&IntLiteral{Value: 2 * len(m.Keys)}}
+	if strKeys {
+		generateCollectionsHashmapStrNew(cg, capacity)
+	} else {
+		generateCollectionsHashmapIntNew(cg, capacity)
+	}
+	cg.textSection.WriteString("    pushq %rax\n") // Save map pointer
+	for i, key := range m.Keys {
+		cg.generateExpressionToReg(m.Values[i], "rax")
+		cg.textSection.WriteString("    pushq %rax\n")
+		if strKeys {
+			cg.generateExpressionToReg(key, "r12")
+			cg.textSection.WriteString("    popq %r13\n")
+			cg.textSection.WriteString("    movq (%rsp), %rbx\n")
+			hashmapStrPut(cg)
+		} else {
+			cg.generateExpressionToReg(key, "rcx")
+			cg.textSection.WriteString("    popq %r15\n")
+			cg.textSection.WriteString("    movq (%rsp), %rbx\n")
+			hashmapIntPut(cg)
+		}
+	}
+	cg.textSection.WriteString("    popq %rax\n")
+}
+
+// isStringExpr reports whether expr is a string literal, variable or constant
+func (cg *CodeGenerator) isStringExpr(expr ASTNode) bool {
+	switch e := expr.(type) {
+	case *StringLiteral:
+		return true
+	case *Identifier:
+		if v, ok := cg.variables[e.Name]; ok {
+			return v.Type == TokenTypeString
+		}
+		if c, ok := cg.constants[e.Name]; ok {
+			return c.Type == TokenTypeString
+		}
+	case *Conversion:
+		return e.Type == TokenTypeString
+	case *Include:
+		return e.Text
+	}
+	return false
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// log.go - Structured logging runtime
+// log.debug, log.info, log.warn and log.error push their message and
+// key-value fields and call .lotus_rt_log, which is appended to the program
+// once when any of them is used:
+//
+//	.lotus_rt_log  level %rdi, message %rsi, field count %rdx
+//
+// The message is at (%rsi) and each field below it: the key at -8, 1 at -16
+// if the value is a string (else 0) and the value at -24, the next field 24
+// bytes further down. A message under the level set with log.set_level is
+// dropped. The others are written to stderr as one line, by default
+//
+//	2026-10-17T05:35:12.345Z INFO message key="value" n=42
+//
+// and after log.set_format_json as one JSON object
+//
+//	{"time":"2026-10-17T05:35:12.345Z","level":"info","msg":"message","key":"value","n":42}
+//
+// The time is UTC, to the millisecond. Strings are escaped as JSON strings
+// in both formats, so a line never breaks. A line is at most logLineMax
+// bytes and is written with one write, so lines from several threads do not
+// mix; the fields that do not fit are left out and a message that does not
+// fit is cut short. It returns 0 or -errno from the write in %rax, and
+// clobbers %rcx, %rdx, %rdi, %rsi and %r8-%r11.
+
+// Log levels, the order log.set_level compares them in
+const (
+	logDebug = 0
+	logInfo  = 1
+	logWarn  = 2
+	logError = 3
+)
+
+// logLineMax is the longest line written, PIPE_BUF so writes to a pipe are atomic
+const logLineMax = 4096
+
+// logReserve is kept free for the message's closing quote and the end of
+// the line
+const logReserve = 3
+
+// logLevelNames are the level names in JSON, then in text lines
+var logLevelNames = [2][4]string{
+	{"debug", "info", "warn", "error"},
+	{"DEBUG", "INFO", "WARN", "ERROR"},
+}
+
+// useLog appends the logging runtime and its settings to the program
+func (cg *CodeGenerator) useLog() {
+	cg.log = true
+}
+
+// logData returns the format and level settings and the text the lines are
+// made of
+func logData() string {
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_log_json:\n    .quad 0\n")
+	b.WriteString(fmt.Sprintf(".lotus_log_level:\n    .quad %d\n", logInfo))
+	b.WriteString(".lotus_log_levels:\n")
+	for _, names := range logLevelNames {
+		for _, name := range names {
+			b.WriteString(fmt.Sprintf("    .ascii \"%s\"\n    .zero %d\n", name, 8-len(name)))
+		}
+	}
+	b.WriteString(".lotus_log_json_time:\n    .ascii \"{\\\"time\\\":\\\"\"\n")
+	b.WriteString(".lotus_log_json_level:\n    .ascii \"\\\",\\\"level\\\":\\\"\"\n")
+	b.WriteString(".lotus_log_json_msg:\n    .ascii \"\\\",\\\"msg\\\":\\\"\"\n")
+	return b.String()
+}
+
+// logRuntime returns .lotus_rt_log and the helpers it writes a line with
+func (cg *CodeGenerator) logRuntime() string {
+	clockNr, _ := cg.target.Syscall("clock_gettime")
+	writeNr, _ := cg.target.Syscall("write")
+	return cg.localLabels("lotus_rt_log", fmt.Sprintf(`
+# ---- structured logging ----
+# %%r12 is the line, %%r13 the end of what is written and %%r10 how far the
+# fields may go; -8(%%rbp) is set once something did not fit and -16(%%rbp)
+# is where the field being written started
+.lotus_rt_log:
+    xorl %%eax, %%eax
+    cmpq .lotus_log_level(%%rip), %%rdi
+    jl {l16}
+    pushq %%rbx
+    pushq %%r12
+    pushq %%r13
+    pushq %%r14
+    pushq %%r15
+    pushq %%rbp
+    movq %%rsp, %%rbp
+    subq $%[3]d, %%rsp
+    movq %%rdi, %%r14
+    movq %%rsi, %%r15
+    movq %%rdx, %%rbx
+    movq $0, -8(%%rbp)
+    movq %%rsp, %%r12
+    movq %%rsp, %%r13
+    movq $%[1]d, %%rax  # syscall: clock_gettime
+    xorl %%edi, %%edi  # CLOCK_REALTIME
+    leaq -32(%%rbp), %%rsi
+    syscall
+    cmpq $0, .lotus_log_json(%%rip)
+    je {l1}
+    leaq .lotus_log_json_time(%%rip), %%rsi
+    movl $9, %%ecx
+    movq %%r13, %%rdi
+    rep movsb
+    movq %%rdi, %%r13
+{l1}:
+    # Days since 1970 to a date, after Howard Hinnant's civil_from_days:
+    # %%r9 is the year, %%rsi the month, %%rdi the day and %%r8 the second
+    movq -32(%%rbp), %%rax
+    xorl %%edx, %%edx
+    movl $86400, %%ecx
+    divq %%rcx
+    movq %%rdx, %%r8
+    addq $719468, %%rax  # days since 0000-03-01
+    xorl %%edx, %%edx
+    movl $146097, %%ecx
+    divq %%rcx
+    movq %%rax, %%r9  # 400 year era
+    movq %%rdx, %%rdi  # day of the era
+    movq %%rdi, %%rax
+    xorl %%edx, %%edx
+    movl $1460, %%ecx
+    divq %%rcx
+    movq %%rdi, %%rsi
+    subq %%rax, %%rsi
+    movq %%rdi, %%rax
+    xorl %%edx, %%edx
+    movl $36524, %%ecx
+    divq %%rcx
+    addq %%rax, %%rsi
+    movq %%rdi, %%rax
+    xorl %%edx, %%edx
+    movl $146096, %%ecx
+    divq %%rcx
+    subq %%rax, %%rsi
+    movq %%rsi, %%rax
+    xorl %%edx, %%edx
+    movl $365, %%ecx
+    divq %%rcx
+    movq %%rax, %%rsi  # year of the era
+    imulq $400, %%r9
+    addq %%rsi, %%r9
+    imulq $365, %%rsi, %%rcx
+    movq %%rsi, %%rax
+    shrq $2, %%rax
+    addq %%rax, %%rcx
+    movq %%rsi, %%rax
+    xorl %%edx, %%edx
+    movl $100, %%r11d
+    divq %%r11
+    subq %%rax, %%rcx
+    subq %%rcx, %%rdi  # day of the year, from March
+    leaq 2(%%rdi,%%rdi,4), %%rax
+    xorl %%edx, %%edx
+    movl $153, %%ecx
+    divq %%rcx
+    movq %%rax, %%rsi  # month, from March
+    imulq $153, %%rsi, %%rax
+    addq $2, %%rax
+    xorl %%edx, %%edx
+    movl $5, %%ecx
+    divq %%rcx
+    subq %%rax, %%rdi
+    incq %%rdi
+    addq $3, %%rsi
+    cmpq $12, %%rsi
+    jbe {l2}
+    subq $12, %%rsi
+    incq %%r9
+{l2}:
+    movq %%r9, %%rax
+    movl $4, %%ecx
+    call .lotus_rt_log_digits
+    movb $45, (%%r13)  # -
+    incq %%r13
+    movq %%rsi, %%rax
+    movl $2, %%ecx
+    call .lotus_rt_log_digits
+    movb $45, (%%r13)  # -
+    incq %%r13
+    movq %%rdi, %%rax
+    movl $2, %%ecx
+    call .lotus_rt_log_digits
+    movb $84, (%%r13)  # T
+    incq %%r13
+    movq %%r8, %%rax
+    xorl %%edx, %%edx
+    movl $3600, %%ecx
+    divq %%rcx
+    movq %%rdx, %%r8
+    movl $2, %%ecx
+    call .lotus_rt_log_digits
+    movb $58, (%%r13)  # :
+    incq %%r13
+    movq %%r8, %%rax
+    xorl %%edx, %%edx
+    movl $60, %%ecx
+    divq %%rcx
+    movq %%rdx, %%r8
+    movl $2, %%ecx
+    call .lotus_rt_log_digits
+    movb $58, (%%r13)  # :
+    incq %%r13
+    movq %%r8, %%rax
+    movl $2, %%ecx
+    call .lotus_rt_log_digits
+    movb $46, (%%r13)  # .
+    incq %%r13
+    movq -24(%%rbp), %%rax
+    xorl %%edx, %%edx
+    movl $1000000, %%ecx
+    divq %%rcx
+    movl $3, %%ecx
+    call .lotus_rt_log_digits
+    movb $90, (%%r13)  # Z
+    incq %%r13
+
+    # The level and the message
+    leaq %[4]d(%%r12), %%r10
+    movq .lotus_log_json(%%rip), %%rax
+    leaq .lotus_log_levels(%%rip), %%rsi
+    leaq (%%rsi,%%r14,8), %%rsi
+    movq %%rax, %%r14  # from here, whether the line is JSON
+    testq %%r14, %%r14
+    jz {l3}
+    pushq %%rsi
+    leaq .lotus_log_json_level(%%rip), %%rsi
+    movl $11, %%ecx
+    call .lotus_rt_log_raw
+    popq %%rsi
+    call .lotus_rt_log_str
+    leaq .lotus_log_json_msg(%%rip), %%rsi
+    movl $9, %%ecx
+    call .lotus_rt_log_raw
+    jmp {l4}
+{l3}:
+    addq $32, %%rsi
+    movl $32, %%eax
+    call .lotus_rt_log_char
+    call .lotus_rt_log_str
+    movl $32, %%eax
+    call .lotus_rt_log_char
+{l4}:
+    movq (%%r15), %%rsi
+    call .lotus_rt_log_str
+    testq %%r14, %%r14
+    jz {l5}
+    movb $34, (%%r13)  # the message's closing quote is always written
+    incq %%r13
+{l5}:
+    cmpq $0, -8(%%rbp)
+    jne {l12}
+
+    # The fields, each left out if it does not fit, with the rest
+{l6}:
+    testq %%rbx, %%rbx
+    jz {l12}
+    movq %%r13, -16(%%rbp)
+    movl $32, %%eax  # space
+    testq %%r14, %%r14
+    jz {l7}
+    movl $44, %%eax  # ,
+    call .lotus_rt_log_char
+    movl $34, %%eax  # "
+{l7}:
+    call .lotus_rt_log_char
+    movq -8(%%r15), %%rsi
+    call .lotus_rt_log_str
+    movl $61, %%eax  # =
+    testq %%r14, %%r14
+    jz {l8}
+    movl $34, %%eax  # "
+    call .lotus_rt_log_char
+    movl $58, %%eax  # :
+{l8}:
+    call .lotus_rt_log_char
+    cmpq $0, -16(%%r15)
+    je {l9}
+    movl $34, %%eax
+    call .lotus_rt_log_char
+    movq -24(%%r15), %%rsi
+    call .lotus_rt_log_str
+    movl $34, %%eax
+    call .lotus_rt_log_char
+    jmp {l10}
+{l9}:
+    movq -24(%%r15), %%rax
+    call .lotus_rt_log_int
+{l10}:
+    cmpq $0, -8(%%rbp)
+    jne {l11}
+    subq $24, %%r15
+    decq %%rbx
+    jmp {l6}
+{l11}:
+    movq -16(%%rbp), %%r13
+{l12}:
+    testq %%r14, %%r14
+    jz {l13}
+    movb $125, (%%r13)  # }
+    incq %%r13
+{l13}:
+    movb $10, (%%r13)
+    incq %%r13
+
+    # One write for the line, unless stderr takes less at a time
+    movq %%r12, %%rsi
+    movq %%r13, %%rdx
+    subq %%r12, %%rdx
+{l14}:
+    movl $2, %%edi
+    movq $%[2]d, %%rax  # syscall: write
+    syscall
+    cmpq $-4, %%rax  # EINTR
+    je {l14}
+    testq %%rax, %%rax
+    js {l15}
+    addq %%rax, %%rsi
+    subq %%rax, %%rdx
+    jnz {l14}
+    xorl %%eax, %%eax
+{l15}:
+    movq %%rbp, %%rsp
+    popq %%rbp
+    popq %%r15
+    popq %%r14
+    popq %%r13
+    popq %%r12
+    popq %%rbx
+{l16}:
+    ret
+
+# %%rax in %%rcx decimal digits, unchecked; clobbers %%rdx, %%r10 and %%r11
+.lotus_rt_log_digits:
+    addq %%rcx, %%r13
+    movq %%r13, %%r11
+    movl $10, %%r10d
+{l17}:
+    xorl %%edx, %%edx
+    divq %%r10
+    addb $48, %%dl
+    decq %%r11
+    movb %%dl, (%%r11)
+    decq %%rcx
+    jnz {l17}
+    ret
+
+# The byte in %%al
+.lotus_rt_log_char:
+    cmpq %%r10, %%r13
+    jae {l18}
+    movb %%al, (%%r13)
+    incq %%r13
+    ret
+{l18}:
+    movq $1, -8(%%rbp)
+    ret
+
+# %%rcx bytes from %%rsi
+.lotus_rt_log_raw:
+    leaq (%%r13,%%rcx), %%rdx
+    cmpq %%r10, %%rdx
+    ja {l19}
+    movq %%r13, %%rdi
+    rep movsb
+    movq %%rdi, %%r13
+    ret
+{l19}:
+    movq $1, -8(%%rbp)
+    ret
+
+# The string at %%rsi, escaped as in JSON; a null pointer is empty
+.lotus_rt_log_str:
+    testq %%rsi, %%rsi
+    jz {l28}
+{l20}:
+    movzbl (%%rsi), %%eax
+    testl %%eax, %%eax
+    jz {l28}
+    incq %%rsi
+    cmpl $34, %%eax  # "
+    je {l21}
+    cmpl $92, %%eax  # backslash
+    je {l21}
+    cmpl $10, %%eax
+    je {l22}
+    cmpl $13, %%eax
+    je {l23}
+    cmpl $9, %%eax
+    je {l24}
+    cmpl $32, %%eax
+    jb {l25}
+    cmpq %%r10, %%r13
+    jae {l27}
+    movb %%al, (%%r13)
+    incq %%r13
+    jmp {l20}
+{l21}:
+    leaq 2(%%r13), %%rdx
+    cmpq %%r10, %%rdx
+    ja {l27}
+    movb $92, (%%r13)
+    movb %%al, 1(%%r13)
+    addq $2, %%r13
+    jmp {l20}
+{l22}:
+    movl $110, %%eax  # n
+    jmp {l21}
+{l23}:
+    movl $114, %%eax  # r
+    jmp {l21}
+{l24}:
+    movl $116, %%eax  # t
+    jmp {l21}
+{l25}:
+    # Other control characters as \u00XX
+    leaq 6(%%r13), %%rdx
+    cmpq %%r10, %%rdx
+    ja {l27}
+    movl $0x3030755c, (%%r13)
+    movl %%eax, %%edx
+    shrl $4, %%edx
+    addl $48, %%edx
+    movb %%dl, 4(%%r13)
+    andl $15, %%eax
+    cmpl $10, %%eax
+    jb {l26}
+    addl $39, %%eax  # a-f
+{l26}:
+    addl $48, %%eax
+    movb %%al, 5(%%r13)
+    addq $6, %%r13
+    jmp {l20}
+{l27}:
+    movq $1, -8(%%rbp)
+{l28}:
+    ret
+
+# %%rax in decimal
+.lotus_rt_log_int:
+    subq $24, %%rsp
+    leaq 24(%%rsp), %%rsi
+    movq %%rax, %%r8
+    testq %%rax, %%rax
+    jns {l29}
+    negq %%rax
+{l29}:
+    xorl %%edx, %%edx
+    movl $10, %%ecx
+    divq %%rcx
+    addb $48, %%dl
+    decq %%rsi
+    movb %%dl, (%%rsi)
+    testq %%rax, %%rax
+    jnz {l29}
+    testq %%r8, %%r8
+    jns {l30}
+    decq %%rsi
+    movb $45, (%%rsi)  # -
+{l30}:
+    leaq 24(%%rsp), %%rcx
+    subq %%rsi, %%rcx
+    call .lotus_rt_log_raw
+    addq $24, %%rsp
+    ret
+`, clockNr, writeNr, logLineMax+32, logLineMax-logReserve))
+}
@@ -0,0 +1,732 @@
+package main
+
+import "fmt"
+
+// loopopt.go - Loop optimizations applied at -O2 and above
+// Three rewrites run over each loop in a function body, innermost loops first:
+//
+//   - Strength reduction: for an induction variable i that only ever changes
+//     by a constant step, i*K and i<<k are replaced with a temporary that
+//     starts at i*K before the loop and is bumped by K*step next to every
+//     update of i, turning a multiply per iteration into an add.
+//   - Header loads: a collection length read in the loop condition, as in
+//     i < collections::array_int_len(a), is loaded once ahead of the loop
+//     when the loop never assigns a and neither stores through a pointer nor
+//     calls anything that may write memory. Only the condition is searched,
+//     outside the operands of &&, || and ?:, since it is evaluated before
+//     the first iteration; a load in the body might never have run.
+//   - Loop-invariant code motion: arithmetic on integer locals that the loop
+//     never assigns is computed once into a temporary ahead of the loop.
+//
+// Temporaries are ordinary locals whose names cannot be written in source.
+// Only 64-bit integer locals whose address is never taken are considered, so
+// no store through a pointer or call can change them behind the pass's back.
+// Functions containing constructs the pass does not model are left alone.
+// Each rewrite is recorded for -print-opt-remarks.
+
+const (
+	licmTempPrefix = "licm."
+	ivTempPrefix   = "iv."
+)
+
+// collectionHeaderLoads are the stdlib calls that read one word of a
+// collection's header and nothing else
+var collectionHeaderLoads = map[string]bool{
+	"collections.array_int_len": true, "collections.stack_int_len": true,
+	"collections.queue_int_len": true, "collections.deque_int_len": true,
+	"collections.heap_int_len": true, "collections.hashmap_int_len": true,
+	"collections.hashset_int_len": true, "collections.hashmap_str_len": true,
+	"collections.hashset_str_len": true, "collections.sortedset_int_len": true,
+	"collections.sortedmap_int_len": true,
+}
+
+// loopOptimizer holds the per-function facts the loop rewrites rely on
+type loopOptimizer struct {
+	*stdlibResolver
+	fn        string
+	intLocals map[string]bool // Parameters and locals the pass may reason about
+	temps     int
+	remarks   *OptRemarks
+}
+
+// ivUse is one strength-reduced multiple of an induction variable
+type ivUse struct {
+	temp  string
+	scale int
+}
+
+// OptimizeLoops applies strength reduction and loop-invariant code motion to
+// the loops of every function
+func OptimizeLoops(statements []ASTNode, remarks *OptRemarks) []ASTNode {
+	resolver := newStdlibResolver(statements)
+	for _, stmt := range statements {
+		if fn, ok := stmt.(*FunctionDefinition); ok {
+			if lo := newLoopOptimizer(fn, resolver, remarks); lo != nil {
+				fn.Body = lo.optimizeBody(fn.Body)
+			}
+		}
+	}
+	return statements
+}
+
+// newLoopOptimizer collects the integer locals of fn, or returns nil if the
+// body contains a node the pass does not understand
+func newLoopOptimizer(fn *FunctionDefinition, resolver *stdlibResolver, remarks *OptRemarks) *loopOptimizer {
+	lo := &loopOptimizer{stdlibResolver: resolver, fn: fn.Name, intLocals: make(map[string]bool), remarks: remarks}
+	excluded := make(map[string]bool)
+	declare := func(name string, typ TokenType) {
+		if typ != TokenTypeInt && typ != TokenTypeInt64 {
+			excluded[name] = true
+		}
+		lo.intLocals[name] = true
+	}
+
+	for _, param := range fn.Parameters {
+		declare(param.Name, param.Type)
+	}
+	ok := walkLoopNodes(fn.Body, func(node ASTNode) {
+		switch n := node.(type) {
+		case *VariableDeclaration:
+			declare(n.Name, n.Type)
+		case *ArrayDeclaration:
+			excluded[n.Name] = true
+		case *Reference:
+			if id, ok := n.Target.(*Identifier); ok {
+				excluded[id.Name] = true
+			}
+		case *UnaryOp:
+			if id, ok := n.Operand.(*Identifier); ok && n.Operator == TokenAmpersand {
+				excluded[id.Name] = true
+			}
+		case *FieldAccess:
+			if id, ok := n.Object.(*Identifier); ok && !n.IsPointer {
+				excluded[id.Name] = true
+			}
+		}
+	})
+	if !ok {
+		return nil
+	}
+	for name := range excluded {
+		delete(lo.intLocals, name)
+	}
+	return lo
+}
+
+// walkLoopNodes calls visit on every statement and expression in nodes. It
+// returns false if it meets a node kind it cannot see inside.
+func walkLoopNodes(nodes []ASTNode, visit func(ASTNode)) bool {
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		visit(node)
+		children, ok := nodeChildren(node)
+		if !ok || !walkLoopNodes(children, visit) {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeChildren returns the statements and expressions directly inside node,
+// or false if node is a kind the AST passes cannot see inside
+func nodeChildren(node ASTNode) ([]ASTNode, bool) {
+	switch n := node.(type) {
+	case *IntLiteral, *FloatLiteral, *BoolLiteral, *StringLiteral, *CharLiteral, *NullLiteral, *Identifier, *CoverageCounter, *StackBuffer, *Include:
+		return nil, true
+	case *BinaryOp:
+		return []ASTNode{n.Left, n.Right}, true
+	case *FloatOp:
+		return []ASTNode{n.Left, n.Right}, true
+	case *FloatConversion:
+		return []ASTNode{n.Value}, true
+	case *BitwiseOp:
+		return []ASTNode{n.Left, n.Right}, true
+	case *Comparison:
+		return []ASTNode{n.Left, n.Right}, true
+	case *LogicalOp:
+		return []ASTNode{n.Left, n.Right}, true
+	case *UnaryOp:
+		return []ASTNode{n.Operand}, true
+	case *Conversion:
+		return []ASTNode{n.Value}, true
+	case *Unwrap:
+		return []ASTNode{n.Value}, true
+	case *TernaryOp:
+		return []ASTNode{n.Condition, n.TrueExpr, n.FalseExpr}, true
+	case *FunctionCall:
+		return n.Args, true
+	case *ArrayAccess:
+		return []ASTNode{n.Array, n.Index}, true
+	case *ArrayLiteral:
+		return n.Elements, true
+	case *MapLiteral:
+		return append(append([]ASTNode{}, n.Keys...), n.Values...), true
+	case *Reference:
+		return []ASTNode{n.Target}, true
+	case *Dereference:
+		return []ASTNode{n.Pointer}, true
+	case *FieldAccess:
+		return []ASTNode{n.Object}, true
+	case *VariableDeclaration:
+		return []ASTNode{n.Value}, true
+	case *ConstantDeclaration:
+		return []ASTNode{n.Value}, true
+	case *ArrayDeclaration:
+		return append([]ASTNode{n.Size}, n.Initial...), true
+	case *Assignment:
+		return []ASTNode{n.Target, n.Value}, true
+	case *CompoundAssignment:
+		return []ASTNode{n.Target, n.Value}, true
+	case *ReturnStatement:
+		return []ASTNode{n.Value}, true
+	case *IfStatement:
+		return append(append([]ASTNode{n.Condition}, n.ThenBody...), n.ElseBody...), true
+	case *WhileLoop:
+		return append([]ASTNode{n.Condition}, n.Body...), true
+	case *ForLoop:
+		return append([]ASTNode{n.Init, n.Condition, n.Update}, n.Body...), true
+	}
+	return nil, false
+}
+
+// optimizeBody rewrites the loops in a statement list, returning the list
+// with any temporaries declared ahead of the loops that use them
+func (lo *loopOptimizer) optimizeBody(body []ASTNode) []ASTNode {
+	result := make([]ASTNode, 0, len(body))
+	for _, stmt := range body {
+		switch s := stmt.(type) {
+		case *IfStatement:
+			s.ThenBody = lo.optimizeBody(s.ThenBody)
+			s.ElseBody = lo.optimizeBody(s.ElseBody)
+		case *WhileLoop:
+			s.Body = lo.optimizeBody(s.Body)
+			result = append(result, lo.optimizeLoop(s, nil)...)
+			continue
+		case *ForLoop:
+			s.Body = lo.optimizeBody(s.Body)
+			result = append(result, lo.optimizeLoop(s, s.Init)...)
+			continue
+		}
+		result = append(result, stmt)
+	}
+	return result
+}
+
+// optimizeLoop applies the rewrites to one loop. init is a for loop's
+// initializer, which runs once and so is not part of the loop proper.
+func (lo *loopOptimizer) optimizeLoop(loop ASTNode, init ASTNode) []ASTNode {
+	var preheader []ASTNode
+	preheader = append(preheader, lo.reduceInductionVariables(loop)...)
+	preheader = append(preheader, lo.hoistHeaderLoads(loop, init)...)
+	preheader = append(preheader, lo.hoistInvariants(loop, init)...)
+	if len(preheader) == 0 {
+		return []ASTNode{loop}
+	}
+
+	// The temporaries are initialized after a for loop's initializer, which
+	// moves out in front of them
+	var result []ASTNode
+	if fl, ok := loop.(*ForLoop); ok && fl.Init != nil {
+		result = append(result, fl.Init)
+		fl.Init = nil
+	}
+	result = append(result, preheader...)
+	return append(result, loop)
+}
+
+// loopParts returns the nodes evaluated on every iteration of a loop
+func loopParts(loop ASTNode) []ASTNode {
+	switch l := loop.(type) {
+	case *WhileLoop:
+		return append([]ASTNode{l.Condition}, l.Body...)
+	case *ForLoop:
+		return append([]ASTNode{l.Condition, l.Update}, l.Body...)
+	}
+	return nil
+}
+
+// assignedIn returns the names nodes declare or assign
+func assignedIn(nodes []ASTNode) map[string]bool {
+	assigned := make(map[string]bool)
+	walkLoopNodes(nodes, func(node ASTNode) {
+		switch n := node.(type) {
+		case *VariableDeclaration:
+			assigned[n.Name] = true
+		case *ArrayDeclaration:
+			assigned[n.Name] = true
+		case *Assignment:
+			if id, ok := n.Target.(*Identifier); ok {
+				assigned[id.Name] = true
+			}
+		case *CompoundAssignment:
+			if id, ok := n.Target.(*Identifier); ok {
+				assigned[id.Name] = true
+			}
+		}
+	})
+	return assigned
+}
+
+// newTemp declares a fresh temporary initialized to value
+func (lo *loopOptimizer) newTemp(prefix string, value ASTNode) *VariableDeclaration {
+	name := fmt.Sprintf("%s%d", prefix, lo.temps)
+	lo.temps++
+	return &VariableDeclaration{Name: name, Type: TokenTypeInt, Value: value}
+}
+
+// remark records a rewrite of loop that replaced uses copies of an
+// expression with a temporary
+func (lo *loopOptimizer) remark(pass string, loop ASTNode, uses int, message string) {
+	lo.remarks.add(OptRemark{Pass: pass, Function: lo.fn, Line: loop.Loc().Line, Message: message, Saved: uses})
+}
+
+// ---- Strength reduction ----
+
+// inductionStep returns the constant i changes by if node is an update of the
+// form i += c, i -= c, i = i + c or i = i - c
+func inductionStep(node ASTNode, name string) (int, bool) {
+	switch n := node.(type) {
+	case *CompoundAssignment:
+		id, ok := n.Target.(*Identifier)
+		lit, isLit := n.Value.(*IntLiteral)
+		if !ok || id.Name != name || !isLit {
+			return 0, false
+		}
+		switch n.Operator {
+		case TokenPlusEq:
+			return lit.Value, true
+		case TokenMinusEq:
+			return -lit.Value, true
+		}
+	case *Assignment:
+		id, ok := n.Target.(*Identifier)
+		sum, isSum := n.Value.(*BinaryOp)
+		if !ok || id.Name != name || !isSum {
+			return 0, false
+		}
+		left, leftIsVar := sum.Left.(*Identifier)
+		right, rightIsLit := sum.Right.(*IntLiteral)
+		if leftIsVar && rightIsLit && left.Name == name {
+			switch sum.Operator {
+			case TokenPlus:
+				return right.Value, true
+			case TokenMinus:
+				return -right.Value, true
+			}
+		}
+		if lit, ok := sum.Left.(*IntLiteral); ok && sum.Operator == TokenPlus {
+			if v, ok := sum.Right.(*Identifier); ok && v.Name == name {
+				return lit.Value, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// inductionVariables returns the locals a loop changes only by constant
+// steps. Any other assignment to a variable disqualifies it.
+func (lo *loopOptimizer) inductionVariables(loop ASTNode) map[string]bool {
+	ivs := make(map[string]bool)
+	disqualified := make(map[string]bool)
+	walkLoopNodes(loopParts(loop), func(node ASTNode) {
+		var name string
+		switch n := node.(type) {
+		case *VariableDeclaration:
+			disqualified[n.Name] = true
+			return
+		case *Assignment:
+			if id, ok := n.Target.(*Identifier); ok {
+				name = id.Name
+			}
+		case *CompoundAssignment:
+			if id, ok := n.Target.(*Identifier); ok {
+				name = id.Name
+			}
+		}
+		if name == "" {
+			return
+		}
+		if _, ok := inductionStep(node, name); ok && lo.intLocals[name] {
+			ivs[name] = true
+		} else {
+			disqualified[name] = true
+		}
+	})
+	for name := range disqualified {
+		delete(ivs, name)
+	}
+	return ivs
+}
+
+// scaledInduction matches i*K, K*i and i<<k, returning i and the multiplier
+func scaledInduction(expr ASTNode, ivs map[string]bool) (string, int, bool) {
+	switch e := expr.(type) {
+	case *BinaryOp:
+		if e.Operator != TokenStar {
+			return "", 0, false
+		}
+		id, idOk := e.Left.(*Identifier)
+		lit, litOk := e.Right.(*IntLiteral)
+		if !idOk || !litOk {
+			id, idOk = e.Right.(*Identifier)
+			lit, litOk = e.Left.(*IntLiteral)
+		}
+		if idOk && litOk && ivs[id.Name] && lit.Value != 0 && lit.Value != 1 {
+			return id.Name, lit.Value, true
+		}
+	case *BitwiseOp:
+		id, idOk := e.Left.(*Identifier)
+		lit, litOk := e.Right.(*IntLiteral)
+		if e.Operator == TokenLShift && idOk && litOk && ivs[id.Name] && lit.Value > 0 && lit.Value < 63 {
+			return id.Name, 1 << lit.Value, true
+		}
+	}
+	return "", 0, false
+}
+
+// reduceInductionVariables replaces multiples of the loop's induction
+// variables with temporaries, returning their declarations
+func (lo *loopOptimizer) reduceInductionVariables(loop ASTNode) []ASTNode {
+	ivs := lo.inductionVariables(loop)
+	if len(ivs) == 0 {
+		return nil
+	}
+
+	var decls []ASTNode
+	var keys []string
+	uses := make(map[string][]ivUse)
+	temps := make(map[string]string)
+	replaced := make(map[string]int)
+	var reduce func(ASTNode) ASTNode
+	reduce = func(expr ASTNode) ASTNode {
+		name, scale, ok := scaledInduction(expr, ivs)
+		if !ok {
+			return mapSubexpressions(expr, reduce)
+		}
+		key := fmt.Sprintf("%s * %d", name, scale)
+		replaced[key]++
+		temp, seen := temps[key]
+		if !seen {
+			keys = append(keys, key)
+			decl := lo.newTemp(ivTempPrefix, optimizeExpression(&BinaryOp{
+				Left:     &Identifier{Name: name},
+				Operator: TokenStar,
+				Right:    &IntLiteral{Value: scale},
+			}))
+			temp = decl.Name
+			temps[key] = temp
+			decls = append(decls, decl)
+			uses[name] = append(uses[name], ivUse{temp: temp, scale: scale})
+		}
+		return &Identifier{Name: temp}
+	}
+
+	switch l := loop.(type) {
+	case *WhileLoop:
+		l.Condition = reduce(l.Condition)
+		l.Body = mapStatementExpressions(l.Body, reduce)
+		l.Body = bumpInductionTemps(l.Body, uses)
+	case *ForLoop:
+		l.Condition = reduce(l.Condition)
+		l.Body = mapStatementExpressions(l.Body, reduce)
+		l.Body = bumpInductionTemps(l.Body, uses)
+		// The update runs after the body; with no continue in the language,
+		// bumping at the end of the body keeps the temporaries in step
+		l.Body = append(l.Body, inductionBumps(l.Update, uses)...)
+	}
+	for _, key := range keys {
+		lo.remark("iv", loop, replaced[key], fmt.Sprintf("%s replaced by a temporary stepped with the loop", key))
+	}
+	return decls
+}
+
+// inductionBumps returns the updates keeping each temporary equal to its
+// multiple of the variable that stmt steps
+func inductionBumps(stmt ASTNode, uses map[string][]ivUse) []ASTNode {
+	var bumps []ASTNode
+	for name, list := range uses {
+		step, ok := inductionStep(stmt, name)
+		if !ok {
+			continue
+		}
+		for _, use := range list {
+			bumps = append(bumps, &CompoundAssignment{
+				Target:   &Identifier{Name: use.temp},
+				Operator: TokenPlusEq,
+				Value:    &IntLiteral{Value: step * use.scale},
+			})
+		}
+	}
+	return bumps
+}
+
+// bumpInductionTemps follows every induction variable update in body, at any
+// depth, with the matching temporary updates
+func bumpInductionTemps(body []ASTNode, uses map[string][]ivUse) []ASTNode {
+	result := make([]ASTNode, 0, len(body))
+	for _, stmt := range body {
+		switch s := stmt.(type) {
+		case *IfStatement:
+			s.ThenBody = bumpInductionTemps(s.ThenBody, uses)
+			s.ElseBody = bumpInductionTemps(s.ElseBody, uses)
+		case *WhileLoop:
+			s.Body = bumpInductionTemps(s.Body, uses)
+		case *ForLoop:
+			// An initializer stepping the variable runs once, before the
+			// condition first reads the temporaries
+			result = append(result, inductionBumps(s.Init, uses)...)
+			s.Body = bumpInductionTemps(s.Body, uses)
+			s.Body = append(s.Body, inductionBumps(s.Update, uses)...)
+		}
+		result = append(result, stmt)
+		result = append(result, inductionBumps(stmt, uses)...)
+	}
+	return result
+}
+
+// ---- Loop-invariant code motion ----
+
+// invariant reports whether expr is side-effect free integer arithmetic over
+// literals and locals the loop does not assign
+func (lo *loopOptimizer) invariant(expr ASTNode, assigned map[string]bool) bool {
+	switch e := expr.(type) {
+	case *IntLiteral:
+		return true
+	case *Identifier:
+		return lo.intLocals[e.Name] && !assigned[e.Name]
+	case *BinaryOp:
+		switch e.Operator {
+		case TokenPlus, TokenMinus, TokenStar:
+			return lo.invariant(e.Left, assigned) && lo.invariant(e.Right, assigned)
+		}
+	case *BitwiseOp:
+		return lo.invariant(e.Left, assigned) && lo.invariant(e.Right, assigned)
+	case *UnaryOp:
+		switch e.Operator {
+		case TokenMinus, TokenTilde:
+			return lo.invariant(e.Operand, assigned)
+		}
+	}
+	return false
+}
+
+// exprKey renders an invariant expression so repeats can share a temporary
+func exprKey(expr ASTNode) string {
+	switch e := expr.(type) {
+	case *IntLiteral:
+		return fmt.Sprintf("%d", e.Value)
+	case *Identifier:
+		return e.Name
+	case *BinaryOp:
+		return fmt.Sprintf("(%s %s %s)", exprKey(e.Left), cseOperators[e.Operator], exprKey(e.Right))
+	case *BitwiseOp:
+		return fmt.Sprintf("(%s %s %s)", exprKey(e.Left), cseOperators[e.Operator], exprKey(e.Right))
+	case *UnaryOp:
+		return cseOperators[e.Operator] + exprKey(e.Operand)
+	}
+	return ""
+}
+
+// hoistInvariants moves invariant computations in front of the loop,
+// returning the temporaries' declarations
+func (lo *loopOptimizer) hoistInvariants(loop ASTNode, init ASTNode) []ASTNode {
+	assigned := assignedIn(append([]ASTNode{init}, loopParts(loop)...))
+
+	var decls []ASTNode
+	var keys []string
+	temps := make(map[string]string)
+	replaced := make(map[string]int)
+	var hoist func(ASTNode) ASTNode
+	hoist = func(expr ASTNode) ASTNode {
+		switch expr.(type) {
+		case *BinaryOp, *BitwiseOp, *UnaryOp:
+			if lo.invariant(expr, assigned) {
+				key := exprKey(expr)
+				replaced[key]++
+				temp, seen := temps[key]
+				if !seen {
+					decl := lo.newTemp(licmTempPrefix, expr)
+					temp = decl.Name
+					temps[key] = temp
+					keys = append(keys, key)
+					decls = append(decls, decl)
+				}
+				return &Identifier{Name: temp}
+			}
+		}
+		return mapSubexpressions(expr, hoist)
+	}
+
+	switch l := loop.(type) {
+	case *WhileLoop:
+		l.Condition = hoist(l.Condition)
+		l.Body = mapStatementExpressions(l.Body, hoist)
+	case *ForLoop:
+		l.Condition = hoist(l.Condition)
+		l.Body = mapStatementExpressions(l.Body, hoist)
+	}
+	for _, key := range keys {
+		lo.remark("licm", loop, replaced[key], fmt.Sprintf("%s hoisted out of the loop", unparenthesized(key)))
+	}
+	return decls
+}
+
+// ---- Header loads ----
+
+// writesInLoop reports whether nodes may store to memory: through a pointer,
+// an element or a field, or in a call
+func (lo *loopOptimizer) writesInLoop(nodes []ASTNode) bool {
+	writes := false
+	walkLoopNodes(nodes, func(node ASTNode) {
+		var target ASTNode
+		switch n := node.(type) {
+		case *Assignment:
+			target = n.Target
+		case *CompoundAssignment:
+			target = n.Target
+		case *FunctionCall:
+			writes = writes || lo.writesMemory(n)
+			return
+		default:
+			return
+		}
+		if _, ok := target.(*Identifier); !ok {
+			writes = true
+		}
+	})
+	return writes
+}
+
+// hoistHeaderLoads moves collection length reads out of the loop condition,
+// returning the temporaries' declarations
+func (lo *loopOptimizer) hoistHeaderLoads(loop ASTNode, init ASTNode) []ASTNode {
+	parts := loopParts(loop)
+	if lo.writesInLoop(parts) {
+		return nil
+	}
+	assigned := assignedIn(append([]ASTNode{init}, parts...))
+
+	var decls []ASTNode
+	var keys []string
+	temps := make(map[string]string)
+	replaced := make(map[string]int)
+	var hoist func(ASTNode) ASTNode
+	hoist = func(expr ASTNode) ASTNode {
+		switch e := expr.(type) {
+		case *LogicalOp:
+			e.Left = hoist(e.Left)
+			return e
+		case *TernaryOp:
+			e.Condition = hoist(e.Condition)
+			return e
+		case *FunctionCall:
+			if len(e.Args) != 1 || !collectionHeaderLoads[lo.calleeName(e)] {
+				break
+			}
+			id, ok := e.Args[0].(*Identifier)
+			if !ok || !lo.intLocals[id.Name] || assigned[id.Name] {
+				break
+			}
+			key := fmt.Sprintf("%s(%s)", e.Name, id.Name)
+			replaced[key]++
+			temp, seen := temps[key]
+			if !seen {
+				decl := lo.newTemp(licmTempPrefix, e)
+				temp = decl.Name
+				temps[key] = temp
+				keys = append(keys, key)
+				decls = append(decls, decl)
+				// Arithmetic on the length can now be hoisted too
+				lo.intLocals[temp] = true
+			}
+			return &Identifier{Name: temp}
+		}
+		return mapSubexpressions(expr, hoist)
+	}
+
+	switch l := loop.(type) {
+	case *WhileLoop:
+		l.Condition = hoist(l.Condition)
+	case *ForLoop:
+		l.Condition = hoist(l.Condition)
+	}
+	for _, key := range keys {
+		lo.remark("licm", loop, replaced[key], fmt.Sprintf("%s loaded once before the loop", key))
+	}
+	return decls
+}
+
+// ---- Rewriting helpers ----
+
+// mapSubexpressions applies f to each operand of expr in place
+func mapSubexpressions(expr ASTNode, f func(ASTNode) ASTNode) ASTNode {
+	switch e := expr.(type) {
+	case *BinaryOp:
+		e.Left, e.Right = f(e.Left), f(e.Right)
+	case *FloatOp:
+		if e.Left != nil {
+			e.Left = f(e.Left)
+		}
+		e.Right = f(e.Right)
+	case *FloatConversion:
+		e.Value = f(e.Value)
+	case *BitwiseOp:
+		e.Left, e.Right = f(e.Left), f(e.Right)
+	case *Comparison:
+		e.Left, e.Right = f(e.Left), f(e.Right)
+	case *LogicalOp:
+		e.Left, e.Right = f(e.Left), f(e.Right)
+	case *UnaryOp:
+		e.Operand = f(e.Operand)
+	case *Conversion:
+		e.Value = f(e.Value)
+	case *Unwrap:
+		e.Value = f(e.Value)
+	case *TernaryOp:
+		e.Condition, e.TrueExpr, e.FalseExpr = f(e.Condition), f(e.TrueExpr), f(e.FalseExpr)
+	case *FunctionCall:
+		for i, arg := range e.Args {
+			e.Args[i] = f(arg)
+		}
+	case *ArrayAccess:
+		e.Index = f(e.Index)
+	case *Dereference:
+		e.Pointer = f(e.Pointer)
+	}
+	return expr
+}
+
+// mapStatementExpressions applies f to the expressions of body's statements,
+// including those of nested blocks, but not to the variables they assign
+func mapStatementExpressions(body []ASTNode, f func(ASTNode) ASTNode) []ASTNode {
+	for i, stmt := range body {
+		switch s := stmt.(type) {
+		case *VariableDeclaration:
+			s.Value = f(s.Value)
+		case *Assignment:
+			if _, ok := s.Target.(*Identifier); !ok {
+				s.Target = mapSubexpressions(s.Target, f)
+			}
+			s.Value = f(s.Value)
+		case *CompoundAssignment:
+			s.Value = f(s.Value)
+		case *ReturnStatement:
+			s.Value = f(s.Value)
+		case *FunctionCall:
+			body[i] = mapSubexpressions(s, f)
+		case *IfStatement:
+			s.Condition = f(s.Condition)
+			s.ThenBody = mapStatementExpressions(s.ThenBody, f)
+			s.ElseBody = mapStatementExpressions(s.ElseBody, f)
+		case *WhileLoop:
+			s.Condition = f(s.Condition)
+			s.Body = mapStatementExpressions(s.Body, f)
+		case *ForLoop:
+			s.Condition = f(s.Condition)
+			s.Body = mapStatementExpressions(s.Body, f)
+		}
+	}
+	return body
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// loopRemarks runs the loop optimizations on source, returning their remarks
+func loopRemarks(t *testing.T, source string) []string {
+	t.Helper()
+	statements, err := NewParser(Tokenize(source)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remarks := &OptRemarks{}
+	OptimizeLoops(statements, remarks)
+	var msgs []string
+	for _, r := range remarks.remarks {
+		msgs = append(msgs, fmt.Sprintf("%d: [%s] %s", r.Line, r.Pass, r.Message))
+	}
+	return msgs
+}
+
+func TestLoopRemarks(t *testing.T) {
+	msgs := loopRemarks(t, `fn int sum(int a, int n) {
+    int total = 0;
+    int i = 0;
+    while i < collections::array_int_len(a) {
+        total = total + collections::array_int_get(a, i) * (n + 1);
+        i = i + 1;
+    }
+    for (int j = 0; j < n; j += 1) {
+        total = total + j * 16;
+    }
+    ret total;
+}
+`)
+	want := []string{
+		"4: [licm] collections::array_int_len(a) loaded once before the loop",
+		"4: [licm] n + 1 hoisted out of the loop",
+		"8: [iv] j * 16 replaced by a temporary stepped with the loop",
+	}
+	if !slices.Equal(msgs, want) {
+		t.Errorf("got remarks %q, want %q", msgs, want)
+	}
+}
+
+// A length is only loaded ahead of a loop that cannot change it, and only
+// from the part of the condition that always runs
+func TestHeaderLoadsStayInLoop(t *testing.T) {
+	for _, loop := range []string{
+		"while i < collections::array_int_len(a) {\n        collections::array_int_push(a, i);\n        i = i + 1;\n    }",
+		"while i < collections::array_int_len(a) {\n        mem::memset(a, 0, 8);\n        i = i + 1;\n    }",
+		"while i < collections::array_int_len(a) {\n        a = collections::array_int_new(4);\n        i = i + 1;\n    }",
+		"while a != 0 && i < collections::array_int_len(a) {\n        i = i + 1;\n    }",
+	} {
+		if msgs := loopRemarks(t, "fn int f(int a) {\n    int i = 0;\n    "+loop+"\n    ret i;\n}\n"); len(msgs) > 0 {
+			t.Errorf("%s: got remarks %q", loop, msgs)
+		}
+	}
+}
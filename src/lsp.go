@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lsp.go - Language server (`lotus lsp`)
+// Speaks the Language Server Protocol, JSON-RPC messages framed by
+// Content-Length headers, on stdin and stdout, for editors to start as:
+//
+//	lotus lsp
+//
+// Documents are synchronized in full: the editor sends the whole text on
+// open and on each change, and the server keeps it until the document is
+// closed. Files that are not open, the modules a document uses among them,
+// are read from disk. The server answers textDocument/semanticTokens/full
+// from semtokens.go and textDocument/rename from rename.go, which looks
+// through every .lts file of the workspace for references; other requests
+// get a MethodNotFound error and other notifications are ignored.
+
+func init() {
+	Subcommands["lsp"] = &Subcommand{
+		Name:    "lsp",
+		Summary: "run the language server on stdin and stdout",
+		Run:     runLSP,
+	}
+}
+
+// JSON-RPC error codes
+const (
+	lspParseError     = -32700
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+	lspRequestFailed  = -32803
+)
+
+// lspMessage is a request, response or notification; requests carry an ID
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text,omitempty"`
+}
+
+type lspDocumentParams struct {
+	TextDocument   lspTextDocument `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position lspPosition `json:"position"`
+	NewName  string      `json:"newName"`
+}
+
+// lspPosition is 0-based, with the character offset in UTF-16 code units
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// lspServer holds the open documents between messages
+type lspServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]string // Text of open documents, by URI
+	root     string            // Workspace directory, or "" when none was given
+	shutdown bool              // shutdown was requested; exit ends the server
+}
+
+// runLSP implements `lotus lsp`
+func runLSP(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lotus lsp")
+		return 2
+	}
+	s := &lspServer{in: bufio.NewReader(os.Stdin), out: os.Stdout, docs: make(map[string]string)}
+	return s.serve()
+}
+
+// serve handles messages until exit or the end of input, returning the exit
+// status: 0 only when shutdown came first, as the protocol asks
+func (s *lspServer) serve() int {
+	for {
+		body, err := s.readMessage()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "lotus lsp: %v\n", err)
+			}
+			return 1
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.reply(nil, nil, &lspError{Code: lspParseError, Message: err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID != nil {
+			s.reply(msg.ID, result, rerr)
+		}
+	}
+}
+
+// readMessage returns the body of the next message
+func (s *lspServer) readMessage() ([]byte, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("bad Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// reply sends the response to the request id
+func (s *lspServer) reply(id json.RawMessage, result any, rerr *lspError) {
+	resp := map[string]any{"jsonrpc": "2.0", "id": id}
+	if id == nil {
+		resp["id"] = nil
+	}
+	if rerr != nil {
+		resp["error"] = rerr
+	} else {
+		resp["result"] = result
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// handle dispatches a message, returning the result for a request
+func (s *lspServer) handle(msg lspMessage) (any, *lspError) {
+	var params lspDocumentParams
+	if len(msg.Params) > 0 && strings.HasPrefix(msg.Method, "textDocument/") {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: err.Error()}
+		}
+	}
+	uri := params.TextDocument.URI
+	switch msg.Method {
+	case "initialize":
+		var init struct {
+			RootURI string `json:"rootUri"`
+		}
+		if json.Unmarshal(msg.Params, &init) == nil && init.RootURI != "" {
+			s.root, _ = uriPath(init.RootURI)
+		}
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": 1, // Full
+				"semanticTokensProvider": map[string]any{
+					"legend": map[string]any{"tokenTypes": semanticTokenTypes, "tokenModifiers": semanticTokenModifiers},
+					"full":   true,
+				},
+				"renameProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "lotus", "version": CompilerVersion},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		s.docs[uri] = params.TextDocument.Text
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uri] = params.ContentChanges[n-1].Text
+		}
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+	case "textDocument/semanticTokens/full":
+		path, err := uriPath(uri)
+		if err != nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: err.Error()}
+		}
+		source, err := s.read(path)
+		if err != nil {
+			return nil, &lspError{Code: lspRequestFailed, Message: err.Error()}
+		}
+		tokens, _ := classifySource(source, path, s.resolver())
+		return map[string]any{"data": encodeSemanticTokens(source, tokens)}, nil
+	case "textDocument/rename":
+		path, err := uriPath(uri)
+		if err != nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: err.Error()}
+		}
+		edit, err := s.rename(path, params.Position, params.NewName)
+		if err != nil {
+			return nil, &lspError{Code: lspRequestFailed, Message: err.Error()}
+		}
+		return edit, nil
+	default:
+		if msg.ID != nil {
+			return nil, &lspError{Code: lspMethodNotFound, Message: "unsupported method " + msg.Method}
+		}
+	}
+	return nil, nil
+}
+
+// uriPath returns the path of a file URI
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", fmt.Errorf("not a file URI: %s", uri)
+	}
+	return u.Path, nil
+}
+
+// read returns the text of the file at path, as the editor has it when the
+// document is open
+func (s *lspServer) read(path string) (string, error) {
+	for uri, text := range s.docs {
+		if p, err := uriPath(uri); err == nil && p == path {
+			return text, nil
+		}
+	}
+	data, err := os.ReadFile(path)
+	return string(data), err
+}
+
+// resolver finds modules as the compiler does, in the vendor directory of a
+// workspace that holds a project, and reads open documents as the editor has
+// them
+func (s *lspServer) resolver() *moduleResolver {
+	var searchDirs []string
+	if s.root != "" {
+		if _, err := FindManifest(s.root); err == nil {
+			searchDirs = append(searchDirs, filepath.Join(s.root, VendorDir))
+		}
+	}
+	return newModuleResolver(searchDirs, s.read)
+}
+
+// rename renames what the identifier at pos in the file at path names,
+// returning the edits as a WorkspaceEdit
+func (s *lspServer) rename(path string, pos lspPosition, newName string) (any, error) {
+	roots := []string{path}
+	dir := s.root
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return nil
+		case d.IsDir() && p != dir && (d.Name() == VendorDir || strings.HasPrefix(d.Name(), ".")):
+			return filepath.SkipDir
+		case !d.IsDir() && filepath.Ext(p) == ".lts" && p != path:
+			roots = append(roots, p)
+		}
+		return nil
+	})
+	rs, err := newRenameSet(roots, s.resolver())
+	if err != nil {
+		return nil, err
+	}
+	files, err := rs.renameAt(path, pos.Line, pos.Character, newName)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string][]any)
+	for _, f := range files {
+		lines := strings.Split(f.Source, "\n")
+		edits := make([]any, len(f.Names))
+		for i, tok := range f.Names {
+			line := lines[tok.Line-1]
+			start := lspPosition{Line: tok.Line - 1, Character: utf16Column(line, tok.Column)}
+			end := lspPosition{Line: tok.Line - 1, Character: utf16Column(line, tok.Column+len([]rune(tok.Value)))}
+			edits[i] = map[string]any{"range": map[string]any{"start": start, "end": end}, "newText": newName}
+		}
+		changes[(&url.URL{Scheme: "file", Path: f.Path}).String()] = edits
+	}
+	return map[string]any{"changes": changes}, nil
+}
@@ -4,19 +4,52 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 )
 
 // main.go - Entry point for the Lotus compiler
 // This file handles command-line parsing, version display, and compilation orchestration.
 
+// Subcommand describes a `lotus <name> ...` command that runs instead of a compile
+type Subcommand struct {
+	Name    string
+	Summary string                  // One-line description shown in usage and completions
+	Args    []string                // Fixed argument words offered by shell completion
+	Run     func(args []string) int // Receives arguments after the subcommand name
+}
+
+// Subcommands maps command names to their implementations
+var Subcommands = make(map[string]*Subcommand)
+
+// SubcommandNames returns registered subcommand names in sorted order
+func SubcommandNames() []string {
+	names := make([]string, 0, len(Subcommands))
+	for name := range Subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func main() {
 	os.Exit(run())
 }
 
 // run orchestrates CLI parsing and compilation, returning a process exit code.
 func run() int {
+	// Phase 0: Dispatch subcommands (lotus <command> ...), and #! scripts,
+	// which the kernel runs as lotus <file> [args]
+	if len(os.Args) > 1 {
+		if sub, ok := Subcommands[os.Args[1]]; ok {
+			return sub.Run(os.Args[2:])
+		}
+		if isScript(os.Args[1]) {
+			return runScript(os.Args[1:])
+		}
+	}
+
 	// Phase 1: Parse command-line flags
-	opts, args, err := ParseFlags()
+	opts, args, err := ParseFlags(os.Args[1:])
 	if err != nil {
 		// Flag parsing errors already printed to stderr
 		return 2
@@ -52,5 +85,17 @@ func run() int {
 
 func printUsage(w io.Writer) {
 	fmt.Fprintln(w, "Usage: lotus [flags] <file>")
+	fmt.Fprintln(w, "       lotus <command> [args]")
 	fmt.Fprintln(w, "Run 'lotus -h' for help")
 }
+
+// printSubcommands lists registered subcommands with their summaries
+func printSubcommands(w io.Writer) {
+	if len(Subcommands) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nCommands:")
+	for _, name := range SubcommandNames() {
+		fmt.Fprintf(w, "  %-12s %s\n", name, Subcommands[name].Summary)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// manifest.go - Project manifest (lotus.toml) loading
+// A manifest describes how to build a project so `lotus build` needs no flags.
+//
+//	[package]
+//	name = "hello"
+//	entry = "src/main.lts"
+//
+//	[build]
+//	output = "bin/hello"
+//	target = "x86_64-linux"
+//	opt-level = 1
+//	defines = ["DEBUG", "VERSION=2"]
+//	libs = ["m"]
+//	freestanding = false
+//	linker-script = "link.ld"
+//	entry = "_start"
+//	linker = "builtin"
+//
+//	[dependencies]
+//	json = { git = "https://example.com/lotus-json.git", version = "v1.2.0" }
+
+// ManifestFile is the file name `lotus build` looks for
+const ManifestFile = "lotus.toml"
+
+// Manifest holds the settings read from lotus.toml
+type Manifest struct {
+	Path     string   // Location of the manifest file
+	Dir      string   // Project root; relative paths resolve against it
+	Name     string   // [package] name
+	Entry    string   // [package] entry, source file to compile
+	Output   string   // [build] output, binary path
+	Target   string   // [build] target
+	OptLevel *int     // [build] opt-level (nil when not given)
+	Defines  []string // [build] defines, NAME[=VALUE]
+	Libs     []string // [build] libs, passed to the linker as -l
+
+	Freestanding bool   // [build] freestanding, build without an OS
+	LinkerScript string // [build] linker-script, passed to the linker as -T
+	EntrySymbol  string // [build] entry, entry point symbol
+	Linker       string // [build] linker, system or builtin
+
+	Dependencies []Dependency // [dependencies], sorted by name
+}
+
+// Dependency is a source package fetched into vendor/ by `lotus get`
+type Dependency struct {
+	Name    string // Module name used in `use "name"`
+	Git     string // Repository URL
+	Version string // Tag, branch, or commit; empty means the default branch
+}
+
+// VendorDir is where `lotus get` places dependencies, relative to the manifest
+const VendorDir = "vendor"
+
+// LoadManifest reads and validates the manifest at path
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := parseTOML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+
+	// Set keys in file order, so the first bad one is the one reported
+	type entry struct {
+		section, key string
+		val          tomlValue
+	}
+	var entries []entry
+	for section, keys := range doc {
+		for key, val := range keys {
+			entries = append(entries, entry{section, key, val})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].val.line < entries[j].val.line })
+
+	m := &Manifest{Path: path, Dir: filepath.Dir(path)}
+	for _, e := range entries {
+		if err := m.set(e.section, e.key, e.val.value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, e.val.line, err)
+		}
+	}
+
+	sort.Slice(m.Dependencies, func(i, j int) bool {
+		return m.Dependencies[i].Name < m.Dependencies[j].Name
+	})
+
+	if m.Entry == "" {
+		m.Entry = "main.lts"
+	}
+	if m.Output == "" {
+		m.Output = m.Name
+	}
+	if m.Output == "" {
+		m.Output = "a.out"
+	}
+	return m, nil
+}
+
+// FindManifest returns the manifest path for a project directory
+func FindManifest(dir string) (string, error) {
+	path := filepath.Join(dir, ManifestFile)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("no %s found in %s", ManifestFile, dir)
+	}
+	return path, nil
+}
+
+// set assigns one manifest key, checking the value's type
+func (m *Manifest) set(section, key string, value any) error {
+	if section == "dependencies" {
+		return m.addDependency(key, value)
+	}
+
+	qualified := section + "." + key
+	switch qualified {
+	case "package.name":
+		return setString(&m.Name, qualified, value)
+	case "package.entry":
+		return setString(&m.Entry, qualified, value)
+	case "build.output":
+		return setString(&m.Output, qualified, value)
+	case "build.target":
+		return setString(&m.Target, qualified, value)
+	case "build.opt-level":
+		n, ok := value.(int)
+		if !ok {
+			return fmt.Errorf("%s must be an integer", qualified)
+		}
+		m.OptLevel = &n
+		return nil
+	case "build.defines":
+		return setStrings(&m.Defines, qualified, value)
+	case "build.libs":
+		return setStrings(&m.Libs, qualified, value)
+	case "build.freestanding":
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("%s must be a boolean", qualified)
+		}
+		m.Freestanding = b
+		return nil
+	case "build.linker-script":
+		return setString(&m.LinkerScript, qualified, value)
+	case "build.entry":
+		return setString(&m.EntrySymbol, qualified, value)
+	case "build.linker":
+		return setString(&m.Linker, qualified, value)
+	}
+	return fmt.Errorf("unknown key %s", qualified)
+}
+
+// addDependency records a [dependencies] entry of the form
+// name = { git = "url", version = "ref" }
+func (m *Manifest) addDependency(name string, value any) error {
+	if !isValidIdentifier(name) {
+		return fmt.Errorf("dependency name %q is not a valid module name", name)
+	}
+	table, ok := value.(map[string]any)
+	if !ok {
+		return fmt.Errorf("dependency %s must be an inline table { git = \"...\", version = \"...\" }", name)
+	}
+
+	dep := Dependency{Name: name}
+	for key, v := range table {
+		switch key {
+		case "git":
+			if err := setString(&dep.Git, name+".git", v); err != nil {
+				return err
+			}
+		case "version":
+			if err := setString(&dep.Version, name+".version", v); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown key %s in dependency %s", key, name)
+		}
+	}
+	if dep.Git == "" {
+		return fmt.Errorf("dependency %s is missing a git URL", name)
+	}
+	// git would take either for an option, such as --upload-pack running a
+	// command of the manifest's choosing
+	if strings.HasPrefix(dep.Git, "-") {
+		return fmt.Errorf("dependency %s has a git URL starting with '-': %q", name, dep.Git)
+	}
+	if strings.HasPrefix(dep.Version, "-") {
+		return fmt.Errorf("dependency %s has a version starting with '-': %q", name, dep.Version)
+	}
+	m.Dependencies = append(m.Dependencies, dep)
+	return nil
+}
+
+func setString(dst *string, key string, value any) error {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s must be a string", key)
+	}
+	*dst = s
+	return nil
+}
+
+func setStrings(dst *[]string, key string, value any) error {
+	list, ok := value.([]any)
+	if !ok {
+		return fmt.Errorf("%s must be an array of strings", key)
+	}
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return fmt.Errorf("%s must be an array of strings", key)
+		}
+		*dst = append(*dst, s)
+	}
+	return nil
+}
+
+// Apply fills options from the manifest. Flags given on the command line win;
+// manifest defines and libs come before any added with -D and -l.
+func (m *Manifest) Apply(opts *CompilerOptions) error {
+	if !opts.IsSet("o") {
+		opts.OutPath = m.resolve(m.Output)
+	}
+	if !opts.IsSet("target") && m.Target != "" {
+		opts.Target = m.Target
+	}
+	if !opts.IsSet("O") && m.OptLevel != nil {
+		opts.OptLevel = *m.OptLevel
+	}
+	if !opts.IsSet("freestanding") && m.Freestanding {
+		opts.Freestanding = true
+	}
+	if !opts.IsSet("T") && m.LinkerScript != "" {
+		opts.LinkerScript = m.resolve(m.LinkerScript)
+	}
+	if !opts.IsSet("entry") && m.EntrySymbol != "" {
+		opts.EntrySymbol = m.EntrySymbol
+	}
+	if !opts.IsSet("linker") && m.Linker != "" {
+		opts.Linker = m.Linker
+	}
+	opts.Defines = append(append([]string(nil), m.Defines...), opts.Defines...)
+	opts.Libs = append(append([]string(nil), m.Libs...), opts.Libs...)
+	if len(m.Dependencies) > 0 {
+		opts.IncludeDirs = append(opts.IncludeDirs, m.VendorPath())
+	}
+
+	if err := validateBuildOptions(opts); err != nil {
+		return fmt.Errorf("%s: %w", m.Path, err)
+	}
+	return nil
+}
+
+// EntryPath returns the entry source file relative to the working directory
+func (m *Manifest) EntryPath() string {
+	return m.resolve(m.Entry)
+}
+
+// VendorPath returns the directory holding fetched dependencies
+func (m *Manifest) VendorPath() string {
+	return m.resolve(VendorDir)
+}
+
+// MissingDependencies lists dependencies that have not been fetched yet
+func (m *Manifest) MissingDependencies() []string {
+	var missing []string
+	for _, dep := range m.Dependencies {
+		if _, err := os.Stat(filepath.Join(m.VendorPath(), dep.Name)); err != nil {
+			missing = append(missing, dep.Name)
+		}
+	}
+	return missing
+}
+
+func (m *Manifest) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(m.Dir, path)
+}
+
+// ============================================================================
+// Minimal TOML reader
+// Supports [tables], bare or quoted keys, strings, integers, booleans, and
+// single-line arrays and inline tables of those values. Comments start with '#'.
+// ============================================================================
+
+// tomlValue is a parsed value with the line it came from
+type tomlValue struct {
+	value any
+	line  int
+}
+
+// parseTOML parses the supported TOML subset into section -> key -> value.
+// Keys before the first table header belong to the "" section.
+func parseTOML(src string) (map[string]map[string]tomlValue, error) {
+	doc := map[string]map[string]tomlValue{"": {}}
+	section := ""
+
+	for i, raw := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripTOMLComment(raw))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("%d: malformed table header %q", lineNo, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("%d: empty table name", lineNo)
+			}
+			if _, exists := doc[section]; !exists {
+				doc[section] = make(map[string]tomlValue)
+			}
+			continue
+		}
+
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%d: expected key = value", lineNo)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		if key == "" {
+			return nil, fmt.Errorf("%d: missing key", lineNo)
+		}
+		if _, dup := doc[section][key]; dup {
+			return nil, fmt.Errorf("%d: duplicate key %q", lineNo, key)
+		}
+
+		value, err := parseTOMLValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s: %w", lineNo, key, err)
+		}
+		doc[section][key] = tomlValue{value: value, line: lineNo}
+	}
+	return doc, nil
+}
+
+// stripTOMLComment removes a trailing '#' comment that is not inside a string
+func stripTOMLComment(line string) string {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case '#':
+			if !inString {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// parseTOMLValue parses a string, integer, boolean, or array literal
+func parseTOMLValue(text string) (any, error) {
+	switch {
+	case text == "":
+		return nil, fmt.Errorf("missing value")
+	case strings.HasPrefix(text, `"`):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", text)
+		}
+		return s, nil
+	case text == "true" || text == "false":
+		return text == "true", nil
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("arrays must be closed on the same line")
+		}
+		return parseTOMLArray(text[1 : len(text)-1])
+	case strings.HasPrefix(text, "{"):
+		if !strings.HasSuffix(text, "}") {
+			return nil, fmt.Errorf("inline tables must be closed on the same line")
+		}
+		return parseTOMLInlineTable(text[1 : len(text)-1])
+	}
+
+	n, err := strconv.Atoi(strings.ReplaceAll(text, "_", ""))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported value %s", text)
+	}
+	return n, nil
+}
+
+// parseTOMLArray parses the comma-separated body of an array literal
+func parseTOMLArray(body string) ([]any, error) {
+	parts, err := splitTOMLItems(body)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]any, 0, len(parts))
+	for _, part := range parts {
+		v, err := parseTOMLValue(part)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+// parseTOMLInlineTable parses the body of { key = value, ... }
+func parseTOMLInlineTable(body string) (map[string]any, error) {
+	parts, err := splitTOMLItems(body)
+	if err != nil {
+		return nil, err
+	}
+	table := make(map[string]any, len(parts))
+	for _, part := range parts {
+		key, text, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key = value in inline table")
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		if _, dup := table[key]; dup {
+			return nil, fmt.Errorf("duplicate key %q in inline table", key)
+		}
+		v, err := parseTOMLValue(strings.TrimSpace(text))
+		if err != nil {
+			return nil, err
+		}
+		table[key] = v
+	}
+	return table, nil
+}
+
+// splitTOMLItems splits an array or inline table body on top-level commas,
+// skipping empty items so a trailing comma is allowed
+func splitTOMLItems(body string) ([]string, error) {
+	var items []string
+	var current strings.Builder
+	inString := false
+	depth := 0
+
+	flush := func() {
+		if text := strings.TrimSpace(current.String()); text != "" {
+			items = append(items, text)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '\\' && inString && i+1 < len(body):
+			current.WriteByte(c)
+			i++
+			c = body[i]
+		case c == '"':
+			inString = !inString
+		case !inString && (c == '[' || c == '{'):
+			depth++
+		case !inString && (c == ']' || c == '}'):
+			depth--
+		case c == ',' && !inString && depth == 0:
+			flush()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if inString {
+		return nil, fmt.Errorf("unterminated string")
+	}
+	flush()
+	return items, nil
+}
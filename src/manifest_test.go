@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadManifestText writes text to a manifest in a new directory and loads it
+func loadManifestText(t *testing.T, text string) (*Manifest, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ManifestFile)
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadManifest(path)
+}
+
+func TestManifestRejectsOptionLikeDependencies(t *testing.T) {
+	for _, dep := range []string{
+		`util = { git = "--upload-pack=touch /tmp/pwned", version = "v1" }`,
+		`util = { git = "-u", version = "v1" }`,
+		`util = { git = "https://example.com/util.git", version = "--output=/tmp/pwned" }`,
+	} {
+		_, err := loadManifestText(t, "[package]\nname = \"app\"\n\n[dependencies]\n"+dep+"\n")
+		if err == nil || !strings.Contains(err.Error(), "starting with '-'") {
+			t.Errorf("%s: got error %v, want one about a leading '-'", dep, err)
+		}
+	}
+
+	m, err := loadManifestText(t, "[package]\nname = \"app\"\n\n[dependencies]\nutil = { git = \"https://example.com/util.git\", version = \"v1\" }\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Dependencies) != 1 || m.Dependencies[0].Git != "https://example.com/util.git" {
+		t.Errorf("got dependencies %+v", m.Dependencies)
+	}
+}
+
+func TestManifestReportsFirstBadKey(t *testing.T) {
+	text := "[package]\nname = \"app\"\ncolour = \"red\"\n\n[build]\nopt-level = \"fast\"\n"
+	for range 20 {
+		_, err := loadManifestText(t, text)
+		if err == nil || !strings.Contains(err.Error(), ":3: unknown key") {
+			t.Fatalf("got error %v, want the unknown key on line 3", err)
+		}
+	}
+}
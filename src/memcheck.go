@@ -0,0 +1,657 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// memcheck.go - Memory debugging mode (-check-memory)
+// The standard library allocates with inline mmap/munmap system calls. In this
+// mode each of those calls, and each exit, is redirected to a checking runtime
+// appended to the program:
+//
+//   - Every private anonymous mapping gets an inaccessible guard page on both
+//     sides, and the block is placed against the upper guard page so a load or
+//     store past its end faults immediately. The few bytes of slack around the
+//     block are filled with a pattern that is verified when the block is
+//     unmapped. Shared mappings, which other processes see, are left alone.
+//   - Blocks are recorded in a registry. Unmapping a block makes it
+//     inaccessible rather than returning it, so later use of it faults too.
+//   - A SIGSEGV handler looks the faulting address up in the registry and
+//     reports an overflow, underflow or use after free, with the block's size
+//     and allocation site.
+//   - At exit, blocks still mapped are reported as leaks. Each report names
+//     the source line of the call that allocated the block.
+//
+// Checking costs a registry scan per munmap and two pages per allocation, so
+// it is a debugging aid only.
+
+// Registry geometry: each entry is base, total, user, size, state and site
+const (
+	memcheckEntrySize  = 48
+	memcheckMaxBlocks  = 65536
+	memcheckPageSize   = 4096
+	memcheckRedzone    = 0xfa // Pattern filling the slack around a block
+	memcheckFailStatus = 1    // Exit status after a memory error
+)
+
+// memcheckSyscalls are the system calls the checking runtime makes
+var memcheckSyscalls = []string{"mmap", "munmap", "mprotect", "rt_sigaction", "rt_sigreturn", "write", "exit"}
+
+// memcheckRuntimeLabels are the runtime entry points that replace a syscall
+var memcheckRuntimeLabels = map[string]string{
+	"mmap":   ".lotus_mem_mmap",
+	"munmap": ".lotus_mem_munmap",
+	"exit":   ".lotus_mem_exit",
+}
+
+// checkMemorySupported reports why target cannot run the checking runtime,
+// or "" if it can
+func checkMemorySupported(target *Target) string {
+	for _, name := range memcheckSyscalls {
+		if _, ok := target.Syscall(name); !ok {
+			return fmt.Sprintf("-check-memory needs the %s system call, which %s does not have", name, target.Triple)
+		}
+	}
+	return ""
+}
+
+// memcheckSite is a place the program calls the mmap replacement from
+type memcheckSite struct {
+	label string // Address the call returns to, which the registry records
+	line  int    // Source line of the stdlib call that allocates
+}
+
+// redirectMemorySyscalls replaces each mmap, munmap and exit syscall in code
+// with a call into the checking runtime, and labels the return address of
+// each mmap call with its source line for the reports
+func (cg *CodeGenerator) redirectMemorySyscalls(code string) string {
+	labels := memcheckRuntimeLabels
+	if cg.atexit {
+		// Exit handlers first; their runtime ends in .lotus_mem_exit
+		labels = map[string]string{"exit": atexitRuntimeLabels["exit"]}
+		for name, label := range memcheckRuntimeLabels {
+			if name != "exit" {
+				labels[name] = label
+			}
+		}
+	}
+	return cg.redirectSyscalls(code, labels, "checked", func(offset int) string {
+		origin := cg.originAt(offset)
+		if origin == nil || origin.line == 0 {
+			return ""
+		}
+		site := memcheckSite{label: fmt.Sprintf(".lotus_mem_site_%d", len(cg.memcheckSites)), line: origin.line}
+		cg.memcheckSites = append(cg.memcheckSites, site)
+		return site.label
+	})
+}
+
+// redirectSyscalls replaces each syscall in code named in labels with a call
+// to the runtime entry point it maps to. A syscall is recognized by the
+// number last loaded into %rax within the same straight-line run. When
+// mmapSite returns a label for the offset of an mmap call, the label is
+// placed after the call.
+func (cg *CodeGenerator) redirectSyscalls(code string, labels map[string]string, note string, mmapSite func(offset int) string) string {
+	byNumber := make(map[int]string)
+	for name := range labels {
+		if nr, ok := cg.target.Syscall(name); ok {
+			byNumber[nr] = name
+		}
+	}
+
+	lines := strings.Split(code, "\n")
+	offsets := make([]int, len(lines))
+	offset := 0
+	for i, line := range lines {
+		offsets[i] = offset
+		offset += len(line) + 1
+	}
+	syscallNumbers(lines, func(i, nr int) {
+		if name, ok := byNumber[nr]; ok {
+			lines[i] = fmt.Sprintf("    call %s  # %s %s", labels[name], note, name)
+			if name == "mmap" && mmapSite != nil {
+				if label := mmapSite(offsets[i]); label != "" {
+					lines[i] += "\n" + label + ":"
+				}
+			}
+		}
+	})
+	return strings.Join(lines, "\n")
+}
+
+// writesRax reports whether an instruction's destination is %rax or part of it
+func writesRax(instr string) bool {
+	if strings.HasPrefix(instr, "#") || instr == "" {
+		return false
+	}
+	if i := strings.Index(instr, "#"); i >= 0 {
+		instr = strings.TrimSpace(instr[:i])
+	}
+	operands := instr
+	if i := strings.IndexAny(instr, " \t"); i >= 0 {
+		operands = instr[i:]
+	}
+	dest := operands
+	if i := strings.LastIndex(operands, ","); i >= 0 {
+		dest = operands[i+1:]
+	}
+	switch strings.TrimSpace(dest) {
+	case "%rax", "%eax", "%ax", "%al", "%ah":
+		return true
+	}
+	return strings.HasPrefix(instr, "cqto") || strings.HasPrefix(instr, "cltq") ||
+		strings.HasPrefix(instr, "div") || strings.HasPrefix(instr, "idiv") ||
+		strings.HasPrefix(instr, "mul") || strings.HasPrefix(instr, "rdtsc")
+}
+
+// memcheckData returns the registry pointers, signal action, messages and
+// allocation site table
+func (cg *CodeGenerator) memcheckData() string {
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_mem_table:\n    .quad 0\n")
+	b.WriteString(".lotus_mem_count:\n    .quad 0\n")
+	b.WriteString(".lotus_mem_sigaction:\n")
+	b.WriteString("    .quad .lotus_mem_segv\n")
+	b.WriteString("    .quad 0x04000004  # SA_RESTORER | SA_SIGINFO\n")
+	b.WriteString("    .quad .lotus_mem_restorer\n")
+	b.WriteString("    .quad 0\n")
+	messages := []struct{ label, text string }{
+		{"prefix", "check-memory: "},
+		{"overflow", "heap-buffer-overflow"},
+		{"underflow", "heap-buffer-underflow"},
+		{"use_after_free", "use after munmap"},
+		{"double_free", "munmap of a block already unmapped"},
+		{"corrupt", "write to the redzone around a block"},
+		{"segv", "segmentation fault"},
+		{"at", " at 0x"},
+		{"pc", " (pc 0x"},
+		{"close", ")"},
+		{"block", "  block of "},
+		{"bytes_at", " bytes at 0x"},
+		{"alloc_pc", ", allocated at pc 0x"},
+		{"newline", "\n"},
+		{"leak", "leak: "},
+		{"leak_total", " block(s) leaked, "},
+		{"bytes", " bytes\n"},
+		{"live", "live: "},
+		{"line", " (line "},
+	}
+	for _, m := range messages {
+		fmt.Fprintf(&b, ".lotus_mem_msg_%s:\n    .asciz \"%s\"\n", m.label, escapeAssemblyString(m.text))
+	}
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_mem_sites:\n")
+	for _, site := range cg.memcheckSites {
+		fmt.Fprintf(&b, "    .quad %s, %d\n", site.label, site.line)
+	}
+	b.WriteString(".lotus_mem_sites_end:\n")
+	return b.String()
+}
+
+// memcheckSetup returns the startup code that maps the registry and installs
+// the fault handler
+func (cg *CodeGenerator) memcheckSetup() string {
+	return cg.memcheckExpand(`    # check-memory: block registry and fault handler
+    movq ${mmap}, %rax
+    xorq %rdi, %rdi
+    movq ${table_bytes}, %rsi
+    movq $3, %rdx
+    movq $34, %r10
+    movq $-1, %r8
+    xorq %r9, %r9
+    syscall
+    cmpq $-4096, %rax
+    ja .lotus_mem_setup_handler
+    movq %rax, .lotus_mem_table(%rip)
+.lotus_mem_setup_handler:
+    movq ${rt_sigaction}, %rax
+    movq $11, %rdi  # SIGSEGV
+    leaq .lotus_mem_sigaction(%rip), %rsi
+    xorq %rdx, %rdx
+    movq $8, %r10
+    syscall
+`)
+}
+
+// memcheckRuntime returns the checking runtime. The mmap, munmap and exit
+// replacements preserve every register a syscall does.
+func (cg *CodeGenerator) memcheckRuntime() string {
+	return cg.localLabels("lotus_rt_memcheck", cg.memcheckExpand(`
+# ---- check-memory runtime ----
+
+# mmap replacement: private anonymous mappings become guarded, registered
+# blocks; shared ones must stay shared, so they pass through
+.lotus_mem_mmap:
+    testq $32, %r10  # MAP_ANONYMOUS
+    jz .lotus_mem_mmap_raw
+    testq $1, %r10  # MAP_SHARED
+    jnz .lotus_mem_mmap_raw
+    testq %rsi, %rsi
+    jz .lotus_mem_mmap_raw
+    cmpq $0, .lotus_mem_table(%rip)
+    je .lotus_mem_mmap_raw
+    cmpq ${max_blocks}, .lotus_mem_count(%rip)
+    jae .lotus_mem_mmap_raw
+    pushq %rdi
+    pushq %rsi
+    pushq %rdx
+    pushq %r8
+    pushq %r9
+    pushq %r10
+    pushq %rbx
+    pushq %r12
+    pushq %r13
+    movq %rsi, %r12
+    addq $15, %r12
+    andq $-16, %r12  # block span, 16-byte aligned
+    leaq {page_mask}(%r12), %r13
+    andq $-{page}, %r13
+    addq ${two_pages}, %r13  # total with a guard page at each end
+    movq ${mmap}, %rax
+    xorq %rdi, %rdi
+    movq %r13, %rsi
+    movq $3, %rdx
+    movq $34, %r10
+    movq $-1, %r8
+    xorq %r9, %r9
+    syscall
+    cmpq $-4096, %rax
+    ja .lotus_mem_mmap_done  # fail as mmap would
+    movq %rax, %r9  # base
+    movq ${mprotect}, %rax
+    movq %r9, %rdi
+    movq ${page}, %rsi
+    xorq %rdx, %rdx  # PROT_NONE
+    syscall
+    movq ${mprotect}, %rax
+    leaq -{page}(%r9,%r13), %rdi
+    movq ${page}, %rsi
+    xorq %rdx, %rdx
+    syscall
+    movq .lotus_mem_count(%rip), %rbx
+    imulq ${entry}, %rbx
+    addq .lotus_mem_table(%rip), %rbx
+    movq %r9, 0(%rbx)
+    movq %r13, 8(%rbx)
+    leaq -{page}(%r9,%r13), %rax
+    subq %r12, %rax
+    movq %rax, 16(%rbx)  # block, against the upper guard page
+    movq 56(%rsp), %rcx
+    movq %rcx, 24(%rbx)  # requested size
+    movq $1, 32(%rbx)  # live
+    movq 72(%rsp), %rcx
+    movq %rcx, 40(%rbx)  # allocation site
+    incq .lotus_mem_count(%rip)
+    call .lotus_mem_fill_slack
+    movq 16(%rbx), %rax
+.lotus_mem_mmap_done:
+    popq %r13
+    popq %r12
+    popq %rbx
+    popq %r10
+    popq %r9
+    popq %r8
+    popq %rdx
+    popq %rsi
+    popq %rdi
+    ret
+.lotus_mem_mmap_raw:
+    movq ${mmap}, %rax
+    syscall
+    ret
+
+# munmap replacement: registered blocks are made inaccessible, not returned
+.lotus_mem_munmap:
+    pushq %rbx
+    pushq %rdx
+    pushq %rdi
+    pushq %rsi
+    call .lotus_mem_find_block
+    testq %rbx, %rbx
+    jz .lotus_mem_munmap_raw
+    cmpq $2, 32(%rbx)
+    je .lotus_mem_double_free
+    call .lotus_mem_check_slack
+    testq %rax, %rax
+    jnz .lotus_mem_corrupt
+    movq ${mprotect}, %rax
+    movq 0(%rbx), %rdi
+    movq 8(%rbx), %rsi
+    xorq %rdx, %rdx
+    syscall
+    movq $2, 32(%rbx)  # unmapped
+    xorq %rax, %rax
+    popq %rsi
+    popq %rdi
+    popq %rdx
+    popq %rbx
+    ret
+.lotus_mem_munmap_raw:
+    popq %rsi
+    popq %rdi
+    popq %rdx
+    popq %rbx
+    movq ${munmap}, %rax
+    syscall
+    ret
+.lotus_mem_double_free:
+    leaq .lotus_mem_msg_double_free(%rip), %rsi
+    movq 16(%rbx), %r14
+    xorq %r15, %r15
+    jmp .lotus_mem_fail
+.lotus_mem_corrupt:
+    leaq .lotus_mem_msg_corrupt(%rip), %rsi
+    movq 16(%rbx), %r14
+    xorq %r15, %r15
+    jmp .lotus_mem_fail
+
+# exit replacement: report blocks never unmapped, then exit
+.lotus_mem_exit:
+    movq %rdi, %rbp  # status
+    xorq %r12, %r12  # leaked blocks
+    xorq %r13, %r13  # leaked bytes
+    movq .lotus_mem_table(%rip), %rbx
+    movq .lotus_mem_count(%rip), %r14
+    imulq ${entry}, %r14
+    addq %rbx, %r14
+.lotus_mem_exit_scan:
+    cmpq %r14, %rbx
+    jae .lotus_mem_exit_done
+    cmpq $1, 32(%rbx)
+    jne .lotus_mem_exit_next
+    incq %r12
+    addq 24(%rbx), %r13
+    leaq .lotus_mem_msg_prefix(%rip), %rsi
+    call .lotus_rt_puts
+    leaq .lotus_mem_msg_leak(%rip), %rsi
+    call .lotus_rt_puts
+    call .lotus_mem_put_block
+.lotus_mem_exit_next:
+    addq ${entry}, %rbx
+    jmp .lotus_mem_exit_scan
+.lotus_mem_exit_done:
+    testq %r12, %r12
+    jz .lotus_mem_exit_now
+    leaq .lotus_mem_msg_prefix(%rip), %rsi
+    call .lotus_rt_puts
+    movq %r12, %rdi
+    call .lotus_rt_putdec
+    leaq .lotus_mem_msg_leak_total(%rip), %rsi
+    call .lotus_rt_puts
+    movq %r13, %rdi
+    call .lotus_rt_putdec
+    leaq .lotus_mem_msg_bytes(%rip), %rsi
+    call .lotus_rt_puts
+.lotus_mem_exit_now:
+    movq %rbp, %rdi
+    movq ${exit}, %rax
+    syscall
+
+# SIGSEGV handler: classify the fault against the registry and exit
+.lotus_mem_segv:
+    movq 16(%rsi), %r14  # si_addr
+    movq 168(%rdx), %r15  # faulting pc from the signal context
+    movq %r14, %rdi
+    call .lotus_mem_find_containing
+    leaq .lotus_mem_msg_segv(%rip), %rsi
+    testq %rbx, %rbx
+    jz .lotus_mem_fail
+    leaq .lotus_mem_msg_use_after_free(%rip), %rsi
+    cmpq $2, 32(%rbx)
+    je .lotus_mem_fail
+    leaq .lotus_mem_msg_overflow(%rip), %rsi
+    cmpq 16(%rbx), %r14
+    jae .lotus_mem_fail
+    leaq .lotus_mem_msg_underflow(%rip), %rsi
+    jmp .lotus_mem_fail
+
+# Report: %rsi = what happened, %r14 = address, %r15 = pc or 0,
+# %rbx = block or 0. Does not return.
+.lotus_mem_fail:
+    pushq %rsi
+    leaq .lotus_mem_msg_prefix(%rip), %rsi
+    call .lotus_rt_puts
+    popq %rsi
+    call .lotus_rt_puts
+    leaq .lotus_mem_msg_at(%rip), %rsi
+    call .lotus_rt_puts
+    movq %r14, %rdi
+    call .lotus_rt_puthex
+    testq %r15, %r15
+    jz {l1}
+    leaq .lotus_mem_msg_pc(%rip), %rsi
+    call .lotus_rt_puts
+    movq %r15, %rdi
+    call .lotus_rt_puthex
+    leaq .lotus_mem_msg_close(%rip), %rsi
+    call .lotus_rt_puts
+{l1}:
+    leaq .lotus_mem_msg_newline(%rip), %rsi
+    call .lotus_rt_puts
+    testq %rbx, %rbx
+    jz {l2}
+    leaq .lotus_mem_msg_block(%rip), %rsi
+    call .lotus_rt_puts
+    call .lotus_mem_put_block
+{l2}:
+    movq ${fail_status}, %rdi
+    movq ${exit}, %rax
+    syscall
+
+.lotus_mem_restorer:
+    movq ${rt_sigreturn}, %rax
+    syscall
+
+# Print block %rbx: its size, address and allocation site, and a newline
+.lotus_mem_put_block:
+    movq 24(%rbx), %rdi
+    call .lotus_rt_putdec
+    leaq .lotus_mem_msg_bytes_at(%rip), %rsi
+    call .lotus_rt_puts
+    movq 16(%rbx), %rdi
+    call .lotus_rt_puthex
+    leaq .lotus_mem_msg_alloc_pc(%rip), %rsi
+    call .lotus_rt_puts
+    movq 40(%rbx), %rdi
+    call .lotus_rt_puthex
+    leaq .lotus_mem_sites(%rip), %r8
+{l3}:
+    leaq .lotus_mem_sites_end(%rip), %rax
+    cmpq %rax, %r8
+    jae {l5}
+    movq 40(%rbx), %rax
+    cmpq %rax, 0(%r8)
+    je {l4}
+    addq $16, %r8
+    jmp {l3}
+{l4}:
+    pushq %r8
+    leaq .lotus_mem_msg_line(%rip), %rsi
+    call .lotus_rt_puts
+    popq %r8
+    movq 8(%r8), %rdi
+    call .lotus_rt_putdec
+    leaq .lotus_mem_msg_close(%rip), %rsi
+    call .lotus_rt_puts
+{l5}:
+    leaq .lotus_mem_msg_newline(%rip), %rsi
+    call .lotus_rt_puts
+    ret
+
+# mem.stats: fill the four quads at %rdi with the bytes allocated, freed and
+# live, and the number of blocks, from the registry. Clobbers %rax, %rcx,
+# %rdx, %r8, %r9.
+.lotus_mem_stats:
+    xorq %rax, %rax
+    xorq %rcx, %rcx
+    movq .lotus_mem_table(%rip), %rdx
+    movq .lotus_mem_count(%rip), %r8
+    imulq ${entry}, %r8
+    addq %rdx, %r8
+{l6}:
+    cmpq %r8, %rdx
+    jae {l8}
+    movq 24(%rdx), %r9
+    addq %r9, %rax
+    cmpq $2, 32(%rdx)
+    jne {l7}
+    addq %r9, %rcx
+{l7}:
+    addq ${entry}, %rdx
+    jmp {l6}
+{l8}:
+    movq %rax, 0(%rdi)
+    movq %rcx, 8(%rdi)
+    subq %rcx, %rax
+    movq %rax, 16(%rdi)
+    movq .lotus_mem_count(%rip), %rax
+    movq %rax, 24(%rdi)
+    ret
+
+# mem.dump_leaks: report each live block; %rax = how many. Clobbers the
+# registers the print helpers do and %r8.
+.lotus_mem_dump:
+    pushq %rbx
+    pushq %r12
+    pushq %r13
+    xorq %r12, %r12
+    movq .lotus_mem_table(%rip), %rbx
+    movq .lotus_mem_count(%rip), %r13
+    imulq ${entry}, %r13
+    addq %rbx, %r13
+{l9}:
+    cmpq %r13, %rbx
+    jae {l11}
+    cmpq $1, 32(%rbx)
+    jne {l10}
+    incq %r12
+    leaq .lotus_mem_msg_prefix(%rip), %rsi
+    call .lotus_rt_puts
+    leaq .lotus_mem_msg_live(%rip), %rsi
+    call .lotus_rt_puts
+    call .lotus_mem_put_block
+{l10}:
+    addq ${entry}, %rbx
+    jmp {l9}
+{l11}:
+    movq %r12, %rax
+    popq %r13
+    popq %r12
+    popq %rbx
+    ret
+
+# Find the block whose address is %rdi: %rbx = entry or 0. Clobbers %rax.
+.lotus_mem_find_block:
+    movq .lotus_mem_count(%rip), %rax
+    imulq ${entry}, %rax
+    addq .lotus_mem_table(%rip), %rax
+{l12}:
+    cmpq .lotus_mem_table(%rip), %rax
+    jbe {l13}
+    subq ${entry}, %rax
+    cmpq 16(%rax), %rdi
+    jne {l12}
+    movq %rax, %rbx
+    ret
+{l13}:
+    xorq %rbx, %rbx
+    ret
+
+# Find the mapping containing %rdi, guard pages included: %rbx = entry or 0.
+# Clobbers %rax and %rcx.
+.lotus_mem_find_containing:
+    movq .lotus_mem_count(%rip), %rax
+    imulq ${entry}, %rax
+    addq .lotus_mem_table(%rip), %rax
+{l14}:
+    cmpq .lotus_mem_table(%rip), %rax
+    jbe {l15}
+    subq ${entry}, %rax
+    movq 0(%rax), %rcx
+    cmpq %rcx, %rdi
+    jb {l14}
+    addq 8(%rax), %rcx
+    cmpq %rcx, %rdi
+    jae {l14}
+    movq %rax, %rbx
+    ret
+{l15}:
+    xorq %rbx, %rbx
+    ret
+
+# Fill the slack around block %rbx with the redzone pattern
+.lotus_mem_fill_slack:
+    pushq %rdi
+    call .lotus_mem_slack_below
+    rep stosb
+    call .lotus_mem_slack_above
+    rep stosb
+    popq %rdi
+    ret
+
+# %rax = 0 if the slack around block %rbx is intact
+.lotus_mem_check_slack:
+    pushq %rdi
+    call .lotus_mem_slack_below
+    jrcxz {l16}
+    repe scasb
+    jne {l18}
+{l16}:
+    call .lotus_mem_slack_above
+    jrcxz {l17}
+    repe scasb
+    jne {l18}
+{l17}:
+    popq %rdi
+    xorq %rax, %rax
+    ret
+{l18}:
+    popq %rdi
+    movq $1, %rax
+    ret
+
+# Slack between the lower guard page and block %rbx: %rdi = start,
+# %rcx = length, %al = pattern
+.lotus_mem_slack_below:
+    movq 0(%rbx), %rdi
+    addq ${page}, %rdi
+    movq 16(%rbx), %rcx
+    subq %rdi, %rcx
+    movb ${redzone}, %al
+    ret
+
+# Slack between the end of block %rbx and the upper guard page
+.lotus_mem_slack_above:
+    movq 16(%rbx), %rdi
+    addq 24(%rbx), %rdi
+    movq 0(%rbx), %rcx
+    addq 8(%rbx), %rcx
+    subq ${page}, %rcx
+    subq %rdi, %rcx
+    movb ${redzone}, %al
+    ret
+`))
+}
+
+// memcheckExpand fills the runtime templates' {name} placeholders with
+// syscall numbers and layout constants
+func (cg *CodeGenerator) memcheckExpand(template string) string {
+	pairs := []string{
+		"{table_bytes}", strconv.Itoa(memcheckEntrySize * memcheckMaxBlocks),
+		"{max_blocks}", strconv.Itoa(memcheckMaxBlocks),
+		"{entry}", strconv.Itoa(memcheckEntrySize),
+		"{page}", strconv.Itoa(memcheckPageSize),
+		"{page_mask}", strconv.Itoa(memcheckPageSize - 1),
+		"{two_pages}", strconv.Itoa(2 * memcheckPageSize),
+		"{redzone}", strconv.Itoa(memcheckRedzone),
+		"{fail_status}", strconv.Itoa(memcheckFailStatus),
+	}
+	for _, name := range memcheckSyscalls {
+		nr, _ := cg.target.Syscall(name)
+		pairs = append(pairs, "{"+name+"}", strconv.Itoa(nr))
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
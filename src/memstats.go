@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// memstats.go - Allocation counters behind mem.stats
+// Without -check-memory, a program that calls mem.stats has each mmap and
+// munmap syscall it makes redirected to the wrappers here, which make the
+// call and add each successful anonymous mapping and each unmapping to
+// running totals. With -check-memory the checking runtime's block registry
+// holds the same numbers and mem.stats reads them from there instead.
+//
+//	.lotus_memstat_stats  out %rdi: allocated, freed, live, mappings
+
+// memstatRuntimeLabels are the wrappers that replace a syscall
+var memstatRuntimeLabels = map[string]string{
+	"mmap":   ".lotus_memstat_mmap",
+	"munmap": ".lotus_memstat_munmap",
+}
+
+// useMemStats counts the program's allocations
+func (cg *CodeGenerator) useMemStats() {
+	cg.memStats = true
+}
+
+// memstatData returns the counters
+func memstatData() string {
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_memstat_allocated:\n    .quad 0\n")
+	b.WriteString(".lotus_memstat_freed:\n    .quad 0\n")
+	b.WriteString(".lotus_memstat_maps:\n    .quad 0\n")
+	return b.String()
+}
+
+// memstatRuntime returns the counting wrappers and the routine mem.stats
+// calls. The wrappers preserve every register a syscall does.
+func (cg *CodeGenerator) memstatRuntime() string {
+	mmapNr, _ := cg.target.Syscall("mmap")
+	munmapNr, _ := cg.target.Syscall("munmap")
+	return cg.localLabels("lotus_rt_memstat", fmt.Sprintf(`
+# ---- allocation counters ----
+.lotus_memstat_mmap:
+    movq $%d, %%rax  # mmap
+    syscall
+    testq $32, %%r10  # MAP_ANONYMOUS
+    jz {l1}
+    cmpq $-4096, %%rax
+    ja {l1}
+    addq %%rsi, .lotus_memstat_allocated(%%rip)
+    incq .lotus_memstat_maps(%%rip)
+{l1}:
+    ret
+.lotus_memstat_munmap:
+    movq $%d, %%rax  # munmap
+    syscall
+    testq %%rax, %%rax
+    jnz {l2}
+    addq %%rsi, .lotus_memstat_freed(%%rip)
+{l2}:
+    ret
+
+# Fill the four quads at %%rdi. Clobbers %%rax, %%rcx.
+.lotus_memstat_stats:
+    movq .lotus_memstat_allocated(%%rip), %%rax
+    movq %%rax, 0(%%rdi)
+    movq .lotus_memstat_freed(%%rip), %%rcx
+    movq %%rcx, 8(%%rdi)
+    subq %%rcx, %%rax
+    movq %%rax, 16(%%rdi)
+    movq .lotus_memstat_maps(%%rip), %%rax
+    movq %%rax, 24(%%rdi)
+    ret
+`, mmapNr, munmapNr))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// meta.go - Build metadata embedded in binaries, and `lotus inspect`
+// Every binary carries a .lotus.meta ELF note recording the compiler version,
+// target, code-affecting flags and a hash of each source file, so a bug report
+// or a build cache can tell exactly how an artifact was produced. The note is
+// not loaded at run time. Its descriptor is a list of key=value lines.
+
+// MetaSection is the ELF section holding the build metadata note
+const MetaSection = ".lotus.meta"
+
+const (
+	metaNoteName = "Lotus"
+	metaNoteType = 1
+)
+
+func init() {
+	Subcommands["inspect"] = &Subcommand{
+		Name:    "inspect",
+		Summary: "show the build metadata of a binary (binary)",
+		Run:     runInspect,
+	}
+}
+
+// buildMetadata returns the key=value lines describing a build of inputPath
+func (c *Compiler) buildMetadata(target *Target, inputPath string, source []byte) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "compiler=%s\n", CompilerVersion)
+	fmt.Fprintf(&b, "target=%s\n", target.Triple)
+	fmt.Fprintf(&b, "flags=%s\n", strings.Join(metadataFlags(c.Options), " "))
+	fmt.Fprintf(&b, "source=%s sha256:%x\n", c.displayPath(inputPath), sha256.Sum256(source))
+	for _, mod := range c.Modules {
+		fmt.Fprintf(&b, "module=%s %s sha256:%x\n", mod.Name, c.displayPath(mod.Path), sha256.Sum256([]byte(mod.Source)))
+	}
+	return b.String()
+}
+
+// metadataFlags lists the options that change the generated code, in a
+// fixed order
+func metadataFlags(opts *CompilerOptions) []string {
+	flags := []string{fmt.Sprintf("-O%d", opts.OptLevel)}
+	bools := []struct {
+		set  bool
+		flag string
+	}{
+		{opts.Freestanding, "-freestanding"},
+		{opts.Release, "-release"},
+		{opts.StackProbe, "-stack-probe"},
+		{opts.DebugLines, "-g"},
+		{opts.CheckMemory, "-check-memory"},
+		{opts.TraceStdlib, "-trace-stdlib"},
+		{opts.InstrumentFunctions, "-instrument-functions"},
+		{opts.ProfileSample, "-profile-sample"},
+		{opts.Seccomp, "-seccomp"},
+		{opts.CoverageFile != "", "-cover"},
+	}
+	for _, f := range bools {
+		if f.set {
+			flags = append(flags, f.flag)
+		}
+	}
+	if opts.EntrySymbol != "" && opts.EntrySymbol != EntryPointLabel {
+		flags = append(flags, "-entry="+opts.EntrySymbol)
+	}
+	if opts.OutlineThreshold != DefaultOutlineThreshold {
+		flags = append(flags, fmt.Sprintf("-outline-threshold=%d", opts.OutlineThreshold))
+	}
+	for _, name := range opts.InlineFunctions {
+		flags = append(flags, "-inline="+name)
+	}
+	for _, define := range opts.Defines {
+		flags = append(flags, "-D"+define)
+	}
+	for _, setting := range opts.BuildInfo {
+		flags = append(flags, "-X"+setting)
+	}
+	for _, lib := range opts.Libs {
+		flags = append(flags, "-l"+lib)
+	}
+	return flags
+}
+
+// metadataSection returns the assembly for the note holding desc
+func metadataSection(desc string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n    .section %s,\"\",@note\n", MetaSection)
+	b.WriteString("    .balign 4\n")
+	fmt.Fprintf(&b, "    .long %d  # name size\n", len(metaNoteName)+1)
+	fmt.Fprintf(&b, "    .long %d  # descriptor size\n", len(desc))
+	fmt.Fprintf(&b, "    .long %d  # type\n", metaNoteType)
+	fmt.Fprintf(&b, "    .asciz \"%s\"\n", metaNoteName)
+	b.WriteString("    .balign 4\n")
+	fmt.Fprintf(&b, "    .ascii \"%s\"\n", escapeAssemblyString(desc))
+	b.WriteString("    .balign 4\n")
+	return b.String()
+}
+
+// ReadMetadata returns the metadata lines embedded in the binary at path
+func ReadMetadata(path string) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	section := f.Section(MetaSection)
+	if section == nil {
+		return nil, fmt.Errorf("%s has no %s section (not built by lotus, or stripped)", path, MetaSection)
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	// An ELF note: name size, descriptor size and type, then the name and
+	// descriptor, each padded to 4 bytes
+	for len(data) >= 12 {
+		nameSize := int(f.ByteOrder.Uint32(data[0:]))
+		descSize := int(f.ByteOrder.Uint32(data[4:]))
+		noteType := f.ByteOrder.Uint32(data[8:])
+		nameEnd := 12 + align4(nameSize)
+		if nameEnd+descSize > len(data) {
+			break
+		}
+		name := string(bytes.TrimRight(data[12:12+nameSize], "\x00"))
+		if name == metaNoteName && noteType == metaNoteType {
+			desc := strings.TrimSuffix(string(data[nameEnd:nameEnd+descSize]), "\n")
+			return strings.Split(desc, "\n"), nil
+		}
+		data = data[nameEnd+align4(descSize):]
+	}
+	return nil, fmt.Errorf("%s: malformed %s section", path, MetaSection)
+}
+
+// align4 rounds n up to a multiple of 4
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// runInspect implements `lotus inspect binary`
+func runInspect(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: lotus inspect binary")
+		return 2
+	}
+	lines, err := ReadMetadata(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	width := 0
+	for _, line := range lines {
+		if key, _, _ := strings.Cut(line, "="); len(key) > width {
+			width = len(key)
+		}
+	}
+	for _, line := range lines {
+		key, value, _ := strings.Cut(line, "=")
+		fmt.Printf("%-*s  %s\n", width+1, key+":", value)
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+// methods.go - Receiver functions and method call syntax
+// A function can name a receiver in parentheses after fn, and be called with
+// a dot on a value of the receiver's type:
+//
+//	fn (int arr) int sum() {
+//	    int total = 0;
+//	    for (int i = 0; i < collections::array_int_len(arr); i += 1) {
+//	        total += collections::array_int_get(arr, i);
+//	    }
+//	    ret total;
+//	}
+//
+//	int total = values.sum();
+//
+// Both are sugar. The receiver becomes the function's first parameter, and
+// x.name(args) is the call name(x, args), so a method is an ordinary function
+// that can also be called the plain way. Methods of different receiver types
+// may share a name; the call goes to the one the receiver fits, as with any
+// overload (overload.go). A stdlib module name before a dot is still the
+// misspelt module::func it always was.
+
+// parseReceiver parses the receiver of a method definition: (type name)
+func (p *Parser) parseReceiver() (FunctionParam, error) {
+	if err := p.expect(TokenLParen); err != nil {
+		return FunctionParam{}, err
+	}
+	if !isTypeToken(p.current().Type) {
+		return FunctionParam{}, p.formatErrorWithCode(ErrExpectedToken, "expected receiver type, got "+TokenTypeName(p.current().Type))
+	}
+	recvType, recvTypeName := p.current().Type, p.current().Value
+	p.advance()
+	if p.current().Type != TokenIdentifier {
+		return FunctionParam{}, p.formatErrorWithCode(ErrExpectedToken, "expected receiver name, got "+TokenTypeName(p.current().Type))
+	}
+	recvName := p.current().Value
+	p.advance()
+	if err := p.expect(TokenRParen); err != nil {
+		return FunctionParam{}, err
+	}
+	return FunctionParam{Name: recvName, Type: recvType, TypeName: recvTypeName}, nil
+}
+
+// isMethodCall reports whether .name( comes next
+func (p *Parser) isMethodCall() bool {
+	return p.current().Type == TokenDot && p.peek().Type == TokenIdentifier &&
+		p.pos+2 < len(p.tokens) && p.tokens[p.pos+2].Type == TokenLParen
+}
+
+// parseMethodCalls turns each .name(args) after receiver into the call
+// name(receiver, args), so x.a().b() is b(a(x))
+func (p *Parser) parseMethodCalls(receiver ASTNode) (ASTNode, error) {
+	for p.isMethodCall() {
+		p.advance() // skip '.'
+		call, err := p.parseFunctionCall()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append([]ASTNode{receiver}, call.Args...)
+		call.Location = receiver.Loc()
+		receiver = call
+	}
+	return receiver, nil
+}
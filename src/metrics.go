@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// metrics.go - Metrics registry
+// The metrics module keeps every counter, gauge and histogram the program
+// creates in one table in the data section, in the order they were created,
+// so metrics.render_prometheus can list them all and nothing has to be freed.
+// Updates use locked instructions and are safe from several threads; creating
+// metrics is not.
+
+// metricsMax is the number of metrics a program can create
+const metricsMax = 64
+
+// Metric kinds
+const (
+	metricCounter   = 1
+	metricGauge     = 2
+	metricHistogram = 3
+)
+
+// A metric's record in the table
+const (
+	metricKind    = 0
+	metricValue   = 8  // the count or level, or a histogram's sum
+	metricCount   = 16 // observations, for a histogram
+	metricName    = 24
+	metricNameMax = 63
+	metricBuckets = metricName + metricNameMax + 1 // observations per bucket, not cumulative
+	metricSize    = metricBuckets + 8*12
+)
+
+// metricBounds are the upper bounds of the histogram buckets, meant for
+// milliseconds; larger observations only count towards +Inf
+var metricBounds = [12]int{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// useMetrics reserves the metrics table in the data section
+func (cg *CodeGenerator) useMetrics() {
+	cg.metrics = true
+}
+
+// metricsData returns the metric count and table
+func metricsData() string {
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_metrics_count:\n    .quad 0\n")
+	b.WriteString(fmt.Sprintf(".lotus_metrics:\n    .zero %d\n", metricSize*metricsMax))
+	return b.String()
+}
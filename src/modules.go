@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// modules.go - Source module resolution
+// `use "name";` with a name that is not a standard library module loads Lotus
+// source instead. The resolver looks for name.lts, then name/lib.lts (a
+// package), in the importing file's directory, each -I directory, and the
+// project's vendor directory. Modules contribute their declarations (functions,
+// constants, and type definitions) to one flat program namespace.
+
+// SourceModule is a parsed source file reached through `use`
+type SourceModule struct {
+	Name       string    // Name as written in the use statement
+	Path       string    // Resolved file path
+	Source     string    // File contents, for diagnostics
+	Statements []ASTNode // Parsed top-level statements
+}
+
+// ModuleLoader resolves and parses source modules, following their imports
+type ModuleLoader struct {
+	SearchDirs  []string // Searched after the importing file's directory
+	diagnostics *DiagnosticManager
+	pathFor     func(string) string // Maps a file path to its diagnostic display path
+
+	root     string                   // Path of the program being compiled
+	modules  []*SourceModule          // Loaded modules in dependency order
+	byPath   map[string]*SourceModule // Loaded modules by resolved path
+	visiting map[string]bool          // Modules on the current import chain
+	defined  map[string]string        // Function name -> file that defines it
+}
+
+// NewModuleLoader creates a loader that reports problems to diagnostics
+func NewModuleLoader(searchDirs []string, diagnostics *DiagnosticManager, pathFor func(string) string) *ModuleLoader {
+	return &ModuleLoader{
+		SearchDirs:  searchDirs,
+		diagnostics: diagnostics,
+		pathFor:     pathFor,
+		byPath:      make(map[string]*SourceModule),
+		visiting:    make(map[string]bool),
+		defined:     make(map[string]string),
+	}
+}
+
+// errModuleNotFound is returned by Resolve when no candidate file exists
+var errModuleNotFound = errors.New("module not found")
+
+// Resolve finds the source file for a module name imported from fromDir
+func (ml *ModuleLoader) Resolve(name, fromDir string) (string, error) {
+	if name == "" || strings.Contains(name, "..") || filepath.IsAbs(name) {
+		return "", fmt.Errorf("invalid module name %q", name)
+	}
+
+	dirs := append([]string{fromDir}, ml.SearchDirs...)
+	for _, dir := range dirs {
+		candidates := []string{
+			filepath.Join(dir, name+".lts"),
+			filepath.Join(dir, name, "lib.lts"),
+		}
+		for _, candidate := range candidates {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate, nil
+			}
+		}
+	}
+	return "", errModuleNotFound
+}
+
+// Load resolves the source-module imports of the root program at rootPath,
+// recursively. Resolved imports are marked with their Source path so later
+// phases skip the standard library lookup for them.
+func (ml *ModuleLoader) Load(statements []ASTNode, rootPath string) {
+	rootPath = filepath.Clean(rootPath)
+	ml.root = rootPath
+	ml.recordFunctions(statements, rootPath)
+	ml.visiting[rootPath] = true
+	ml.loadImports(statements, rootPath)
+}
+
+// Modules returns the loaded modules, dependencies first
+func (ml *ModuleLoader) Modules() []*SourceModule {
+	return ml.modules
+}
+
+// Declarations returns the declarations contributed by every loaded module
+func (ml *ModuleLoader) Declarations() []ASTNode {
+	var decls []ASTNode
+	for _, mod := range ml.modules {
+		for _, stmt := range mod.Statements {
+			if isModuleDeclaration(stmt) {
+				decls = append(decls, stmt)
+			}
+		}
+	}
+	return decls
+}
+
+// loadImports loads every source module imported by statements in file
+func (ml *ModuleLoader) loadImports(statements []ASTNode, file string) {
+	for _, stmt := range statements {
+		imp, ok := stmt.(*ImportStatement)
+		if !ok {
+			continue
+		}
+		if _, isStdlib := StandardLibrary[imp.Module]; isStdlib {
+			continue
+		}
+
+		// Point diagnostics at the name inside the quotes
+		nameLoc := Location{Line: imp.ModuleLoc.Line, Column: imp.ModuleLoc.Column + 1}
+
+		path, err := ml.Resolve(imp.Module, filepath.Dir(file))
+		if errors.Is(err, errModuleNotFound) {
+			continue // Reported as an unknown module by semantic analysis
+		}
+		if err != nil {
+			ml.errorAt(file, nameLoc, ErrModuleNotFound, err.Error())
+			continue
+		}
+		imp.Source = path
+
+		if ml.visiting[path] {
+			ml.errorAt(file, nameLoc, ErrModuleNotFound,
+				fmt.Sprintf("import cycle: '%s' is already being imported", ml.pathFor(path)))
+			continue
+		}
+		if _, loaded := ml.byPath[path]; loaded {
+			continue
+		}
+		ml.loadModule(imp.Module, path)
+	}
+}
+
+// loadModule parses one module, loads its own imports, then records it
+func (ml *ModuleLoader) loadModule(name, path string) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		ml.diagnostics.AddErrorWithCode(string(ErrModuleNotFound), CategoryGeneral,
+			fmt.Sprintf("failed to read module '%s': %v", name, err), ml.pathFor(path), 0, 0, "")
+		return
+	}
+
+	display := ml.pathFor(path)
+	ml.diagnostics.SetSourceLines(display, string(contents))
+
+	statements, err := NewParser(Tokenize(string(contents))).Parse()
+	if err != nil {
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			ml.diagnostics.AddDiagnostic(perr.Diagnostic(display))
+		} else {
+			ml.diagnostics.AddErrorWithCode("", CategorySyntax, err.Error(), display, 0, 0, "")
+		}
+		return
+	}
+
+	for _, stmt := range statements {
+		switch fn := stmt.(type) {
+		case *FunctionDefinition:
+			fn.File = display
+		case *GenericFunction:
+			fn.File = display
+		}
+	}
+	mod := &SourceModule{Name: name, Path: path, Source: string(contents), Statements: statements}
+	ml.byPath[path] = mod
+	ml.recordFunctions(statements, path)
+
+	ml.visiting[path] = true
+	ml.loadImports(statements, path)
+	delete(ml.visiting, path)
+
+	ml.modules = append(ml.modules, mod)
+}
+
+// recordFunctions reports functions defined in more than one file, since
+// all modules share a single namespace
+func (ml *ModuleLoader) recordFunctions(statements []ASTNode, file string) {
+	for _, stmt := range statements {
+		fn, ok := stmt.(*FunctionDefinition)
+		if !ok || (fn.Name == "main" && file != ml.root) {
+			continue // A module's own main is dropped, not merged
+		}
+		if fn.Init {
+			continue // Named apart later, by nameInitBlocks
+		}
+		if prev, exists := ml.defined[fn.Name]; exists && prev != file {
+			ml.errorAt(file, fn.Loc(), ErrRedefinition,
+				fmt.Sprintf("function '%s' is already defined in %s", fn.Name, ml.pathFor(prev)))
+			continue
+		}
+		ml.defined[fn.Name] = file
+	}
+}
+
+func (ml *ModuleLoader) errorAt(file string, loc Location, code ErrorCode, message string) {
+	ml.diagnostics.AddErrorWithCode(string(code), CategorySemantic, message, ml.pathFor(file), loc.Line, loc.Column, "")
+}
+
+// isModuleDeclaration reports whether a module statement is merged into the
+// program. Top-level code and a module's own main are not.
+func isModuleDeclaration(stmt ASTNode) bool {
+	switch s := stmt.(type) {
+	case *FunctionDefinition:
+		return s.Name != "main"
+	case *ImportStatement, *ConstantDeclaration, *StructDefinition, *EnumDefinition, *ClassDefinition, *GenericFunction:
+		return true
+	}
+	return false
+}
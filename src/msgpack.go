@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// msgpack.go - MessagePack runtime
+// The msgpack module's functions call these routines, which are appended to
+// the program once when any of them is used. Each works on the buffer from
+// %rdi up to %rsi, moves %rdi past what it wrote or read, and returns 0 in
+// %rax, or -ENOSPC when an encoded value does not fit, -ENODATA when the
+// buffer ends inside a value and -EBADMSG when the value is of another type:
+//
+//	.lotus_rt_mp_put_int   writes the integer in %rax in its shortest form
+//	.lotus_rt_mp_put_head  writes a header for %rax items, of a string when
+//	                       %rdx is 0, an array when 1 and a map when 2
+//	.lotus_rt_mp_put_str   writes the string at %rax, a null pointer as ""
+//	.lotus_rt_mp_get_int   reads an integer into %rdx
+//	.lotus_rt_mp_get_head  reads a header of the kind in %rdx, as put_head
+//	                       writes them, leaving the item count in %rdx
+//	.lotus_rt_mp_skip      steps over one value, an array or map whole
+//
+// A uint64 over the int range reads back negative, as Lotus ints do. They
+// clobber %rcx, %rdx and %r8-%r10.
+
+// Item kinds of .lotus_rt_mp_put_head and .lotus_rt_mp_get_head
+const (
+	msgpackStr   = 0
+	msgpackArray = 1
+	msgpackMap   = 2
+)
+
+// Kinds msgpack.kind reports for the next value
+const (
+	msgpackKindInt   = 1
+	msgpackKindStr   = 2
+	msgpackKindArray = 3
+	msgpackKindMap   = 4
+	msgpackKindNil   = 5
+	msgpackKindBool  = 6
+	msgpackKindOther = 7 // floats, binary and extension types
+)
+
+// msgpackKeyMax is the longest map key msgpack.decode_hashmap_str accepts
+const msgpackKeyMax = 255
+
+// How .lotus_rt_mp_skip steps over the value after each tag from 0xc0 up:
+// a payload of n bytes, a payload after an n byte length (with a type byte
+// for an extension), or an array or map of an n byte count
+const (
+	msgpackSkipFixed = iota
+	msgpackSkipSized
+	msgpackSkipExt
+	msgpackSkipArray
+	msgpackSkipMap
+	msgpackSkipBad
+)
+
+var msgpackSkipTable = [32][2]int{
+	{msgpackSkipFixed, 0}, {msgpackSkipBad, 0}, {msgpackSkipFixed, 0}, {msgpackSkipFixed, 0}, // nil, unused, false, true
+	{msgpackSkipSized, 1}, {msgpackSkipSized, 2}, {msgpackSkipSized, 4}, // bin 8, 16, 32
+	{msgpackSkipExt, 1}, {msgpackSkipExt, 2}, {msgpackSkipExt, 4}, // ext 8, 16, 32
+	{msgpackSkipFixed, 4}, {msgpackSkipFixed, 8}, // float 32, 64
+	{msgpackSkipFixed, 1}, {msgpackSkipFixed, 2}, {msgpackSkipFixed, 4}, {msgpackSkipFixed, 8}, // uint 8-64
+	{msgpackSkipFixed, 1}, {msgpackSkipFixed, 2}, {msgpackSkipFixed, 4}, {msgpackSkipFixed, 8}, // int 8-64
+	{msgpackSkipFixed, 2}, {msgpackSkipFixed, 3}, {msgpackSkipFixed, 5}, {msgpackSkipFixed, 9}, {msgpackSkipFixed, 17}, // fixext 1-16
+	{msgpackSkipSized, 1}, {msgpackSkipSized, 2}, {msgpackSkipSized, 4}, // str 8, 16, 32
+	{msgpackSkipArray, 2}, {msgpackSkipArray, 4}, // array 16, 32
+	{msgpackSkipMap, 2}, {msgpackSkipMap, 4}, // map 16, 32
+}
+
+// useMsgpack appends the MessagePack runtime to the program
+func (cg *CodeGenerator) useMsgpack() {
+	cg.msgpack = true
+}
+
+// msgpackData returns the table .lotus_rt_mp_skip reads
+func msgpackData() string {
+	var b strings.Builder
+	b.WriteString(".lotus_mp_skip:\n")
+	for _, e := range msgpackSkipTable {
+		b.WriteString(fmt.Sprintf("    .byte %d, %d\n", e[0], e[1]))
+	}
+	return b.String()
+}
+
+// msgpackRuntime returns the encoding and decoding routines
+func (cg *CodeGenerator) msgpackRuntime() string {
+	return cg.localLabels("lotus_rt_msgpack", fmt.Sprintf(`
+# ---- MessagePack ----
+.lotus_rt_mp_put_int:
+    cmpq $-32, %%rax
+    jl {l1}
+    cmpq $127, %%rax
+    jg {l1}
+    movb %%al, %%dl  # fixint
+    xorl %%ecx, %%ecx
+    jmp .lotus_rt_mp_put_be
+{l1}:
+    testq %%rax, %%rax
+    js {l2}
+    movb $0xcc, %%dl  # uint 8
+    movl $1, %%ecx
+    cmpq $0xff, %%rax
+    jbe .lotus_rt_mp_put_be
+    movb $0xcd, %%dl
+    movl $2, %%ecx
+    cmpq $0xffff, %%rax
+    jbe .lotus_rt_mp_put_be
+    movb $0xce, %%dl
+    movl $4, %%ecx
+    movl $0xffffffff, %%r8d
+    cmpq %%r8, %%rax
+    jbe .lotus_rt_mp_put_be
+    movb $0xcf, %%dl
+    movl $8, %%ecx
+    jmp .lotus_rt_mp_put_be
+{l2}:
+    movb $0xd0, %%dl  # int 8
+    movl $1, %%ecx
+    cmpq $-128, %%rax
+    jge .lotus_rt_mp_put_be
+    movb $0xd1, %%dl
+    movl $2, %%ecx
+    cmpq $-32768, %%rax
+    jge .lotus_rt_mp_put_be
+    movb $0xd2, %%dl
+    movl $4, %%ecx
+    cmpq $-2147483648, %%rax
+    jge .lotus_rt_mp_put_be
+    movb $0xd3, %%dl
+    movl $8, %%ecx
+    jmp .lotus_rt_mp_put_be
+
+.lotus_rt_mp_put_head:
+    testq %%rdx, %%rdx
+    jnz {l3}
+    movb %%al, %%dl
+    orb $0xa0, %%dl  # fixstr
+    xorl %%ecx, %%ecx
+    cmpq $32, %%rax
+    jb .lotus_rt_mp_put_be
+    movb $0xd9, %%dl
+    movl $1, %%ecx
+    cmpq $0x100, %%rax
+    jb .lotus_rt_mp_put_be
+    movb $0xda, %%dl
+    movl $2, %%ecx
+    cmpq $0x10000, %%rax
+    jb .lotus_rt_mp_put_be
+    movb $0xdb, %%dl
+    movl $4, %%ecx
+    jmp .lotus_rt_mp_put_be
+{l3}:
+    cmpq $%[1]d, %%rdx
+    jne {l4}
+    movb %%al, %%dl
+    orb $0x90, %%dl  # fixarray
+    xorl %%ecx, %%ecx
+    cmpq $16, %%rax
+    jb .lotus_rt_mp_put_be
+    movb $0xdc, %%dl
+    movl $2, %%ecx
+    cmpq $0x10000, %%rax
+    jb .lotus_rt_mp_put_be
+    movb $0xdd, %%dl
+    movl $4, %%ecx
+    jmp .lotus_rt_mp_put_be
+{l4}:
+    movb %%al, %%dl
+    orb $0x80, %%dl  # fixmap
+    xorl %%ecx, %%ecx
+    cmpq $16, %%rax
+    jb .lotus_rt_mp_put_be
+    movb $0xde, %%dl
+    movl $2, %%ecx
+    cmpq $0x10000, %%rax
+    jb .lotus_rt_mp_put_be
+    movb $0xdf, %%dl
+    movl $4, %%ecx
+
+# The tag in %%dl, then the low %%rcx bytes of %%rax, most significant first
+.lotus_rt_mp_put_be:
+    leaq 1(%%rdi,%%rcx), %%r8
+    cmpq %%rsi, %%r8
+    ja {l7}
+    movb %%dl, (%%rdi)
+    movq %%r8, %%rdi
+    jmp {l6}
+{l5}:
+    decq %%r8
+    movb %%al, (%%r8)
+    shrq $8, %%rax
+    decq %%rcx
+{l6}:
+    testq %%rcx, %%rcx
+    jnz {l5}
+    xorl %%eax, %%eax
+    ret
+{l7}:
+    movq $-28, %%rax  # ENOSPC
+    ret
+
+.lotus_rt_mp_put_str:
+    movq %%rax, %%r9
+    xorl %%eax, %%eax
+    testq %%r9, %%r9
+    jz {l9}
+{l8}:
+    cmpb $0, (%%r9,%%rax)
+    je {l9}
+    incq %%rax
+    jmp {l8}
+{l9}:
+    movq %%rax, %%r10
+    xorl %%edx, %%edx
+    call .lotus_rt_mp_put_head
+    testq %%rax, %%rax
+    jnz {l11}
+    leaq (%%rdi,%%r10), %%r8
+    cmpq %%rsi, %%r8
+    ja {l10}
+    movq %%r10, %%rcx
+    pushq %%rsi
+    movq %%r9, %%rsi
+    rep movsb
+    popq %%rsi
+    ret
+{l10}:
+    movq $-28, %%rax  # ENOSPC
+{l11}:
+    ret
+
+.lotus_rt_mp_get_int:
+    cmpq %%rsi, %%rdi
+    jae {l15}
+    movzbl (%%rdi), %%eax
+    cmpl $0x7f, %%eax
+    ja {l12}
+    movq %%rax, %%rdx  # positive fixint
+    incq %%rdi
+    xorl %%eax, %%eax
+    ret
+{l12}:
+    cmpl $0xe0, %%eax
+    jb {l13}
+    movsbq (%%rdi), %%rdx  # negative fixint
+    incq %%rdi
+    xorl %%eax, %%eax
+    ret
+{l13}:
+    cmpl $0xcc, %%eax
+    jb {l16}
+    cmpl $0xd3, %%eax
+    ja {l16}
+    movl %%eax, %%r10d  # uint 0xcc-0xcf and int 0xd0-0xd3 of 1, 2, 4, 8 bytes
+    leal -0xcc(%%rax), %%ecx
+    andl $3, %%ecx
+    movl $1, %%r9d
+    shll %%cl, %%r9d
+    movl %%r9d, %%ecx
+    incq %%rdi
+    call .lotus_rt_mp_get_be
+    testq %%rax, %%rax
+    jnz {l17}
+    cmpl $0xd0, %%r10d
+    jb {l14}
+    movl $64, %%ecx
+    shll $3, %%r9d
+    subl %%r9d, %%ecx
+    shlq %%cl, %%rdx
+    sarq %%cl, %%rdx
+{l14}:
+    xorl %%eax, %%eax
+    ret
+{l15}:
+    movq $-61, %%rax  # ENODATA
+    ret
+{l16}:
+    movq $-74, %%rax  # EBADMSG
+{l17}:
+    ret
+
+# %%rcx bytes at %%rdi into %%rdx, most significant first
+.lotus_rt_mp_get_be:
+    leaq (%%rdi,%%rcx), %%rax
+    cmpq %%rsi, %%rax
+    ja {l20}
+    xorl %%edx, %%edx
+    jmp {l19}
+{l18}:
+    shlq $8, %%rdx
+    movzbl (%%rdi), %%eax
+    orq %%rax, %%rdx
+    incq %%rdi
+    decq %%rcx
+{l19}:
+    testq %%rcx, %%rcx
+    jnz {l18}
+    xorl %%eax, %%eax
+    ret
+{l20}:
+    movq $-61, %%rax  # ENODATA
+    ret
+
+.lotus_rt_mp_get_head:
+    cmpq %%rsi, %%rdi
+    jae {l26}
+    movzbl (%%rdi), %%eax
+    incq %%rdi
+    testq %%rdx, %%rdx
+    jnz {l22}
+    movl %%eax, %%edx
+    andl $0xe0, %%edx
+    cmpl $0xa0, %%edx
+    jne {l21}
+    andl $0x1f, %%eax  # fixstr
+    movq %%rax, %%rdx
+    xorl %%eax, %%eax
+    ret
+{l21}:
+    movl $1, %%ecx
+    cmpl $0xd9, %%eax
+    je .lotus_rt_mp_get_be
+    movl $2, %%ecx
+    cmpl $0xda, %%eax
+    je .lotus_rt_mp_get_be
+    movl $4, %%ecx
+    cmpl $0xdb, %%eax
+    je .lotus_rt_mp_get_be
+    jmp {l25}
+{l22}:
+    movl $0x90, %%r8d  # fixarray
+    movl $0xdc, %%r9d
+    cmpq $%[1]d, %%rdx
+    je {l23}
+    movl $0x80, %%r8d  # fixmap
+    movl $0xde, %%r9d
+{l23}:
+    movl %%eax, %%edx
+    andl $0xf0, %%edx
+    cmpl %%r8d, %%edx
+    jne {l24}
+    andl $0x0f, %%eax
+    movq %%rax, %%rdx
+    xorl %%eax, %%eax
+    ret
+{l24}:
+    movl $2, %%ecx
+    cmpl %%r9d, %%eax
+    je .lotus_rt_mp_get_be
+    incl %%r9d
+    movl $4, %%ecx
+    cmpl %%r9d, %%eax
+    je .lotus_rt_mp_get_be
+{l25}:
+    movq $-74, %%rax  # EBADMSG
+    ret
+{l26}:
+    movq $-61, %%rax  # ENODATA
+    ret
+
+# %%r10 is the number of values still to step over
+.lotus_rt_mp_skip:
+    movl $1, %%r10d
+{l27}:
+    testq %%r10, %%r10
+    jz {l38}
+    decq %%r10
+    cmpq %%rsi, %%rdi
+    jae {l36}
+    movzbl (%%rdi), %%eax
+    incq %%rdi
+    cmpl $0x7f, %%eax
+    jbe {l27}  # positive fixint
+    cmpl $0xe0, %%eax
+    jae {l27}  # negative fixint
+    cmpl $0x8f, %%eax
+    ja {l28}
+    andl $0x0f, %%eax  # fixmap
+    leaq (%%r10,%%rax,2), %%r10
+    jmp {l27}
+{l28}:
+    cmpl $0x9f, %%eax
+    ja {l29}
+    andl $0x0f, %%eax  # fixarray
+    addq %%rax, %%r10
+    jmp {l27}
+{l29}:
+    cmpl $0xbf, %%eax
+    ja {l30}
+    andl $0x1f, %%eax  # fixstr
+    addq %%rax, %%rdi
+    jmp {l35}
+{l30}:
+    leaq .lotus_mp_skip(%%rip), %%r8
+    subl $0xc0, %%eax
+    movzbl (%%r8,%%rax,2), %%r9d
+    movzbl 1(%%r8,%%rax,2), %%ecx
+    cmpl $%[2]d, %%r9d
+    jne {l31}
+    addq %%rcx, %%rdi
+    jmp {l35}
+{l31}:
+    cmpl $%[6]d, %%r9d
+    je {l37}
+    call .lotus_rt_mp_get_be
+    testq %%rax, %%rax
+    jnz {l39}
+    cmpl $%[3]d, %%r9d
+    jne {l32}
+    addq %%rdx, %%rdi
+    jmp {l35}
+{l32}:
+    cmpl $%[4]d, %%r9d
+    jne {l33}
+    leaq 1(%%rdi,%%rdx), %%rdi
+    jmp {l35}
+{l33}:
+    cmpl $%[5]d, %%r9d
+    jne {l34}
+    addq %%rdx, %%r10
+    jmp {l27}
+{l34}:
+    leaq (%%r10,%%rdx,2), %%r10  # map
+    jmp {l27}
+{l35}:
+    cmpq %%rsi, %%rdi
+    jbe {l27}
+{l36}:
+    movq $-61, %%rax  # ENODATA
+    ret
+{l37}:
+    movq $-74, %%rax  # EBADMSG
+    ret
+{l38}:
+    xorl %%eax, %%eax
+{l39}:
+    ret
+`, msgpackArray, msgpackSkipFixed, msgpackSkipSized, msgpackSkipExt, msgpackSkipArray, msgpackSkipBad))
+}
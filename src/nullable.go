@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nullable.go - Nullable types
+// Some functions return 0 (null) to say they have nothing: str::copy and
+// str::concat when they cannot allocate, collections::hashmap_str_get when
+// the key is missing, and user functions declared with a ? after the return
+// type. Their results can only be kept in a variable or parameter whose type
+// also ends in ?, and such a value must be checked before it is used:
+//
+//	string? name = str::copy(input);
+//	if (name == null) {
+//	    ret -12;
+//	}
+//	println(name); // checked above
+//
+// Comparing a nullable value with null or 0, or testing it alone, checks it
+// in the code the test guards: the body of if (v != null), the else of
+// if (v == null), the right side of v != null && ..., and the rest of the
+// block after an if (v == null) whose body always leaves. Assigning it a
+// value that may be null unchecks it. Anywhere else, v! unwraps it: the
+// program stops with an error if v is null.
+
+// Unwrap asserts that a nullable value is not null: v!
+type Unwrap struct {
+	BaseNode
+	Value ASTNode
+}
+
+func (u *Unwrap) astNode() {}
+
+// parseNullable consumes the ? marking a nullable type, reporting whether
+// there was one
+func (p *Parser) parseNullable() bool {
+	if p.current().Type != TokenQuestion {
+		return false
+	}
+	p.advance()
+	return true
+}
+
+// parseUnwraps wraps value in an Unwrap for each ! after it
+func (p *Parser) parseUnwraps(value ASTNode) ASTNode {
+	for p.current().Type == TokenExclaim {
+		tok := p.current()
+		p.advance()
+		u := &Unwrap{Value: value}
+		setLocation(u, tok)
+		value = u
+	}
+	return value
+}
+
+// nullableValue describes expr for messages when it may be null here, or
+// returns "" when it cannot be
+func (sa *SemanticAnalyzer) nullableValue(expr ASTNode) string {
+	switch e := expr.(type) {
+	case *NullLiteral:
+		return "null"
+	case *Identifier:
+		if info := sa.lookupSymbol(e.Name); info != nil && info.Nullable && !info.Checked {
+			return "'" + e.Name + "'"
+		}
+	case *Conversion:
+		return sa.nullableValue(e.Value)
+	case *FunctionCall:
+		if fn := sa.calledFunction(e); fn != nil {
+			if fn.ReturnNullable {
+				name, _, _ := strings.Cut(fn.Name, ".") // Overloads are mangled
+				return "'" + name + "'"
+			}
+		} else if fn := sa.stdlibCallee(e); fn != nil && fn.Nullable {
+			return fmt.Sprintf("'%s::%s'", fn.Module, fn.Name)
+		}
+	}
+	return ""
+}
+
+// checkNotNull reports expr used as a value when it may be null
+func (sa *SemanticAnalyzer) checkNotNull(expr ASTNode, context string) {
+	what := sa.nullableValue(expr)
+	if what == "" {
+		return
+	}
+	message := fmt.Sprintf("%s may be null %s; check it against null first or unwrap it with !", what, context)
+	if _, ok := expr.(*FunctionCall); ok {
+		message = fmt.Sprintf("%s can return null; keep the result in a nullable (type?) variable and check it, or unwrap it with !", what)
+	}
+	loc := expr.Loc()
+	if call, ok := expr.(*FunctionCall); ok && call.NameLoc.Line != 0 {
+		loc = call.NameLoc
+	}
+	if loc.Line == 0 {
+		loc.Line = sa.currentLine
+	}
+	sa.diagnostics.AddErrorWithCode(string(ErrPossiblyNull), CategorySemantic, message,
+		sa.filePath, loc.Line, loc.Column, sa.getSourceLine(loc.Line))
+}
+
+// stdlibCallee returns the stdlib function call resolves to, or nil when it
+// calls something else
+func (sa *SemanticAnalyzer) stdlibCallee(call *FunctionCall) *StdlibFunction {
+	module, name, qualified := strings.Cut(call.Name, "::")
+	if !qualified {
+		if !sa.importedFuncs[call.Name] || sa.functions[call.Name] {
+			return nil
+		}
+		for _, mod := range sa.imports {
+			if m, ok := StandardLibrary[mod]; ok && m.Functions[call.Name] != nil {
+				return m.Functions[call.Name]
+			}
+		}
+		return nil
+	}
+	if sa.sourceMods[module] {
+		return nil
+	}
+	if resolved, isAlias := sa.imports[module]; isAlias {
+		module = resolved
+	}
+	if m, ok := StandardLibrary[module]; ok {
+		return m.Functions[name]
+	}
+	return nil
+}
+
+// nullChecks returns the nullable variables cond proves are not null when it
+// is true, and those it proves are not null when it is false
+func (sa *SemanticAnalyzer) nullChecks(cond ASTNode) (ifTrue, ifFalse []*SymbolInfo) {
+	switch c := cond.(type) {
+	case *Identifier:
+		if info := sa.lookupSymbol(c.Name); info != nil && info.Nullable {
+			return []*SymbolInfo{info}, nil
+		}
+	case *UnaryOp:
+		if c.Operator == TokenExclaim {
+			ifTrue, ifFalse = sa.nullChecks(c.Operand)
+			return ifFalse, ifTrue
+		}
+	case *Comparison:
+		if c.Operator != TokenEqual && c.Operator != TokenNotEqual {
+			return nil, nil
+		}
+		ident, ok := c.Left.(*Identifier)
+		other := c.Right
+		if !ok {
+			ident, ok = c.Right.(*Identifier)
+			other = c.Left
+		}
+		info := (*SymbolInfo)(nil)
+		if ok {
+			info = sa.lookupSymbol(ident.Name)
+		}
+		if info == nil || !info.Nullable || !isNullConstant(other) {
+			return nil, nil
+		}
+		if c.Operator == TokenNotEqual {
+			return []*SymbolInfo{info}, nil
+		}
+		return nil, []*SymbolInfo{info}
+	case *LogicalOp:
+		leftTrue, leftFalse := sa.nullChecks(c.Left)
+		rightTrue, rightFalse := sa.nullChecks(c.Right)
+		if c.Operator == TokenAnd {
+			return append(leftTrue, rightTrue...), nil
+		}
+		return nil, append(leftFalse, rightFalse...)
+	}
+	return nil, nil
+}
+
+// isNullConstant reports whether expr is null or 0
+func isNullConstant(expr ASTNode) bool {
+	switch e := expr.(type) {
+	case *NullLiteral:
+		return true
+	case *IntLiteral:
+		return e.Value == 0
+	}
+	return false
+}
+
+// markChecked marks symbols as checked and returns a function undoing it
+func markChecked(symbols []*SymbolInfo) func() {
+	var marked []*SymbolInfo
+	for _, info := range symbols {
+		if !info.Checked {
+			info.Checked = true
+			marked = append(marked, info)
+		}
+	}
+	return func() {
+		for _, info := range marked {
+			info.Checked = false
+		}
+	}
+}
+
+// analyzeGuarded analyzes body with the variables in checked known not to
+// be null
+func (sa *SemanticAnalyzer) analyzeGuarded(body []ASTNode, checked []*SymbolInfo) {
+	undo := markChecked(checked)
+	sa.pushScope()
+	for _, stmt := range body {
+		sa.analyzeNode(stmt)
+	}
+	sa.popScope()
+	undo()
+}
+
+// guardRest keeps the variables in checked known not to be null for the
+// rest of the current block, after an if whose body always leaves it
+func (sa *SemanticAnalyzer) guardRest(checked []*SymbolInfo) {
+	if len(sa.guards) > 0 {
+		undo := markChecked(checked)
+		top := len(sa.guards) - 1
+		sa.guards[top] = append(sa.guards[top], undo)
+	}
+}
+
+// alwaysLeaves reports whether body cannot finish by falling off its end
+func alwaysLeaves(body []ASTNode) bool {
+	if len(body) == 0 {
+		return false
+	}
+	switch s := body[len(body)-1].(type) {
+	case *ReturnStatement, *ThrowStatement, *GotoStatement:
+		return true
+	case *IfStatement:
+		return alwaysLeaves(s.ThenBody) && alwaysLeaves(s.ElseBody)
+	}
+	return false
+}
+
+// generateUnwrap evaluates u's value into reg, stopping the program with an
+// error if it is null
+func (cg *CodeGenerator) generateUnwrap(u *Unwrap, reg string) {
+	okLabel := cg.getLabel("unwrap_ok")
+	msg := fmt.Sprintf("%s:%d: unwrapped a null value\n", cg.diagnostics.FilePath, u.Loc().Line)
+	label, length := emitStringLiteral(cg, msg)
+	writeNr, _ := cg.target.Syscall("write")
+	exitNr, _ := cg.target.Syscall("exit")
+
+	cg.generateExpressionToReg(u.Value, "rax")
+	asm := cg.asm()
+	asm.TestRegReg("rax", "rax")
+	asm.Jcc("nz", okLabel)
+	asm.MovImmReg(writeNr, "rax")
+	asm.MovImmReg(2, "rdi")
+	asm.LeaLabel(label, "rsi")
+	asm.MovImmReg(length, "rdx")
+	asm.Syscall()
+	asm.MovImmReg(exitNr, "rax")
+	asm.MovImmReg(1, "rdi")
+	asm.Syscall()
+	asm.Label(okLabel)
+	if reg != "rax" {
+		asm.MovRegReg("rax", reg)
+	}
+}
+
+// checkNullArguments reports arguments that may be null passed to anything
+// but a nullable parameter of a user function
+func (sa *SemanticAnalyzer) checkNullArguments(call *FunctionCall) {
+	fn := sa.calledFunction(call)
+	for i, arg := range call.Args {
+		if fn != nil && i < len(fn.Parameters) && fn.Parameters[i].Nullable {
+			continue
+		}
+		sa.checkNotNull(arg, "as an argument")
+	}
+}
+
+// evalUnwrap evaluates v! at compile time
+func (in *Interpreter) evalUnwrap(u *Unwrap) (Value, error) {
+	v, err := in.evalValue(u.Value)
+	if err != nil {
+		return Value{}, err
+	}
+	if v.Kind == ValueNull || v.Kind == ValueInt && v.Int == 0 {
+		return Value{}, in.errorf(u, "unwrapped a null value")
+	}
+	return v, nil
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// objfile.go - Relocatable ELF objects
+// The built-in assembler produces an ObjectFile: sections of bytes, the
+// symbols defined in them or referenced from them, and the relocations that
+// patch those references once addresses are known. WriteELF writes it as an
+// ELF64 relocatable object, as GNU as would, which any ELF linker accepts.
+// With -g the .loc rows also become a DWARF line table, in .debug_line, and
+// the compile unit in .debug_info that points debuggers at it.
+
+// ObjectFile is an assembled program before linking
+type ObjectFile struct {
+	Sections   []*ObjSection
+	Symbols    []*ObjSymbol // Defined ones by section and offset, then undefined ones
+	SourceFile string       // Name from the .file directive, if any
+	Lines      []ObjLine    // Source positions from .loc directives
+	LineFiles  []string     // Files the line rows refer to, numbered from 1
+}
+
+// ObjSection is a section of an object
+type ObjSection struct {
+	Name   string
+	Type   elf.SectionType
+	Flags  elf.SectionFlag
+	Align  uint64
+	Data   []byte // Empty for SHT_NOBITS; Size gives the length
+	Size   uint64
+	Relocs []ObjReloc
+	Symbol *ObjSymbol // Section symbol relocations against local symbols use
+}
+
+// ObjSymbol is a symbol of an object
+type ObjSymbol struct {
+	Name    string
+	Section *ObjSection // nil when undefined
+	Value   uint64      // Offset in Section
+	Size    uint64
+	Type    elf.SymType
+	Global  bool
+}
+
+// ObjReloc patches the bytes at Offset with Symbol's address plus Addend
+type ObjReloc struct {
+	Offset uint64
+	Type   elf.R_X86_64
+	Symbol *ObjSymbol
+	Addend int64
+}
+
+// ObjLine maps an offset in a code section to a source position
+type ObjLine struct {
+	Section *ObjSection
+	Offset  uint64
+	File    int // Index into LineFiles, from 1
+	Line    int
+	Column  int
+}
+
+// Executable reports whether the section holds code
+func (s *ObjSection) Executable() bool {
+	return s.Flags&elf.SHF_EXECINSTR != 0
+}
+
+// WriteObjectFile writes obj to path as an ELF relocatable object
+func WriteObjectFile(obj *ObjectFile, path string) error {
+	var b bytes.Buffer
+	if err := obj.WriteELF(&b); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0644)
+}
+
+// WriteELF writes the object as ELF64, little-endian, for x86-64
+func (obj *ObjectFile) WriteELF(w io.Writer) error {
+	sections := append([]*ObjSection(nil), obj.Sections...)
+	if len(obj.Lines) > 0 {
+		sections = append(sections, obj.debugSections()...)
+	}
+
+	// Symbol table: the null symbol, the file, one symbol per section, the
+	// local symbols and then the global ones, as ELF requires
+	symbols := []*ObjSymbol{{}}
+	if obj.SourceFile != "" {
+		symbols = append(symbols, &ObjSymbol{Name: obj.SourceFile, Type: elf.STT_FILE})
+	}
+	for _, s := range sections {
+		if s.Symbol == nil {
+			s.Symbol = &ObjSymbol{Section: s, Type: elf.STT_SECTION}
+		}
+		symbols = append(symbols, s.Symbol)
+	}
+	var globals []*ObjSymbol
+	for _, sym := range obj.Symbols {
+		if sym.Global || sym.Section == nil {
+			globals = append(globals, sym)
+		} else {
+			symbols = append(symbols, sym)
+		}
+	}
+	firstGlobal := len(symbols)
+	symbols = append(symbols, globals...)
+
+	symIndex := make(map[*ObjSymbol]int, len(symbols))
+	for i, sym := range symbols {
+		symIndex[sym] = i
+	}
+	secIndex := make(map[*ObjSection]int)
+	index := 1
+	for _, s := range sections {
+		secIndex[s] = index
+		index++
+		if len(s.Relocs) > 0 {
+			index++ // Its .rela section follows it
+		}
+	}
+
+	strtab := newStringTable()
+	var symtab bytes.Buffer
+	for i, sym := range symbols {
+		var entry elf.Sym64
+		if i > 0 {
+			entry.Name = strtab.add(sym.Name)
+			bind := elf.STB_LOCAL
+			if sym.Global || (sym.Section == nil && sym.Type != elf.STT_FILE) {
+				bind = elf.STB_GLOBAL
+			}
+			entry.Info = elf.ST_INFO(bind, sym.Type)
+			entry.Value, entry.Size = sym.Value, sym.Size
+			switch {
+			case sym.Type == elf.STT_FILE:
+				entry.Shndx = uint16(elf.SHN_ABS)
+			case sym.Section != nil:
+				entry.Shndx = uint16(secIndex[sym.Section])
+			}
+		}
+		binary.Write(&symtab, binary.LittleEndian, entry)
+	}
+
+	// Section headers, in file order, with each section's contents
+	shstrtab := newStringTable()
+	headers := []elf.Section64{{}}
+	var contents [][]byte
+	for _, s := range sections {
+		data := s.Data
+		size := uint64(len(data))
+		if s.Type == elf.SHT_NOBITS {
+			size, data = s.Size, nil
+		}
+		align := s.Align
+		if align == 0 {
+			align = 1
+		}
+		headers = append(headers, elf.Section64{
+			Name: shstrtab.add(s.Name), Type: uint32(s.Type), Flags: uint64(s.Flags),
+			Size: size, Addralign: align,
+		})
+		contents = append(contents, data)
+		if len(s.Relocs) == 0 {
+			continue
+		}
+		var rela bytes.Buffer
+		for _, r := range s.Relocs {
+			sym, ok := symIndex[r.Symbol]
+			if !ok {
+				return fmt.Errorf("relocation in %s against unknown symbol %q", s.Name, r.Symbol.Name)
+			}
+			binary.Write(&rela, binary.LittleEndian, elf.Rela64{
+				Off: r.Offset, Info: elf.R_INFO(uint32(sym), uint32(r.Type)), Addend: r.Addend,
+			})
+		}
+		headers = append(headers, elf.Section64{
+			Name: shstrtab.add(".rela" + s.Name), Type: uint32(elf.SHT_RELA), Flags: uint64(elf.SHF_INFO_LINK),
+			Size: uint64(rela.Len()), Link: uint32(index), Info: uint32(secIndex[s]), Addralign: 8, Entsize: 24,
+		})
+		contents = append(contents, rela.Bytes())
+	}
+	headers = append(headers, elf.Section64{
+		Name: shstrtab.add(".symtab"), Type: uint32(elf.SHT_SYMTAB), Size: uint64(symtab.Len()),
+		Link: uint32(index + 1), Info: uint32(firstGlobal), Addralign: 8, Entsize: 24,
+	})
+	contents = append(contents, symtab.Bytes())
+	headers = append(headers, elf.Section64{
+		Name: shstrtab.add(".strtab"), Type: uint32(elf.SHT_STRTAB), Size: uint64(strtab.Len()), Addralign: 1,
+	})
+	contents = append(contents, strtab.Bytes())
+	headers = append(headers, elf.Section64{
+		Name: shstrtab.add(".shstrtab"), Type: uint32(elf.SHT_STRTAB), Addralign: 1,
+	})
+	headers[len(headers)-1].Size = uint64(shstrtab.Len())
+	contents = append(contents, shstrtab.Bytes())
+
+	// Contents follow the ELF header, each at its alignment, and the
+	// section header table comes last
+	offset := uint64(64)
+	for i, data := range contents {
+		h := &headers[i+1]
+		offset = alignUp(offset, h.Addralign)
+		h.Off = offset
+		offset += uint64(len(data))
+	}
+	shoff := alignUp(offset, 8)
+
+	header := elf.Header64{
+		Type: uint16(elf.ET_REL), Machine: uint16(elf.EM_X86_64), Version: uint32(elf.EV_CURRENT),
+		Shoff: shoff, Ehsize: 64, Shentsize: 64, Shnum: uint16(len(headers)), Shstrndx: uint16(len(headers) - 1),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	header.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, header)
+	for i, data := range contents {
+		b.Write(make([]byte, int(headers[i+1].Off)-b.Len()))
+		b.Write(data)
+	}
+	b.Write(make([]byte, int(shoff)-b.Len()))
+	for _, h := range headers {
+		binary.Write(&b, binary.LittleEndian, h)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// alignUp rounds n up to a multiple of align
+func alignUp(n, align uint64) uint64 {
+	if align <= 1 {
+		return n
+	}
+	return (n + align - 1) / align * align
+}
+
+// stringTable builds an ELF string table, which starts with an empty string
+type stringTable struct {
+	b       bytes.Buffer
+	offsets map[string]uint32
+}
+
+func newStringTable() *stringTable {
+	t := &stringTable{offsets: map[string]uint32{"": 0}}
+	t.b.WriteByte(0)
+	return t
+}
+
+// add returns the offset of s, adding it if it is new
+func (t *stringTable) add(s string) uint32 {
+	if off, ok := t.offsets[s]; ok {
+		return off
+	}
+	off := uint32(t.b.Len())
+	t.b.WriteString(s)
+	t.b.WriteByte(0)
+	t.offsets[s] = off
+	return off
+}
+
+func (t *stringTable) Len() int      { return t.b.Len() }
+func (t *stringTable) Bytes() []byte { return t.b.Bytes() }
+
+// DWARF constants of the line table and compile unit
+const (
+	dwarfLineBase   = -5
+	dwarfLineRange  = 14
+	dwarfOpcodeBase = 13
+	dwarfLangAsm    = 0x8001 // DW_LANG_Mips_Assembler, as GNU as records
+
+	dwarfAdvancePC   = 2 // Standard opcodes
+	dwarfAdvanceLine = 3
+	dwarfSetFile     = 4
+	dwarfSetColumn   = 5
+	dwarfConstAddPC  = 8
+	dwarfEndSequence = 1 // Extended opcodes
+	dwarfSetAddress  = 2
+)
+
+// debugSections builds .debug_line from the line rows, and a compile unit
+// in .debug_info and .debug_abbrev that refers to it
+func (obj *ObjectFile) debugSections() []*ObjSection {
+	line := &ObjSection{Name: ".debug_line", Type: elf.SHT_PROGBITS, Align: 1}
+	info := &ObjSection{Name: ".debug_info", Type: elf.SHT_PROGBITS, Align: 1}
+	abbrev := &ObjSection{Name: ".debug_abbrev", Type: elf.SHT_PROGBITS, Align: 1}
+	for _, s := range []*ObjSection{line, info, abbrev} {
+		s.Symbol = &ObjSymbol{Section: s, Type: elf.STT_SECTION}
+	}
+
+	// Header: instruction length, operations per instruction, is_stmt and
+	// the special opcode parameters, the standard opcode lengths, no include
+	// directories and the files
+	var header bytes.Buffer
+	header.Write([]byte{1, 1, 1, 256 + dwarfLineBase, dwarfLineRange, dwarfOpcodeBase})
+	header.Write([]byte{0, 1, 1, 1, 1, 0, 0, 0, 1, 0, 0, 1})
+	header.WriteByte(0)
+	for _, f := range obj.LineFiles {
+		header.WriteString(f)
+		header.Write([]byte{0, 0, 0, 0})
+	}
+	header.WriteByte(0)
+
+	// One sequence per section, in the order the rows came
+	var program bytes.Buffer
+	var relocs []ObjReloc
+	rows := append([]ObjLine(nil), obj.Lines...)
+	sort.SliceStable(rows, func(i, j int) bool {
+		return obj.sectionIndex(rows[i].Section) < obj.sectionIndex(rows[j].Section)
+	})
+	for start := 0; start < len(rows); {
+		end := start
+		for end < len(rows) && rows[end].Section == rows[start].Section {
+			end++
+		}
+		sec := rows[start].Section
+		program.Write([]byte{0, 9, dwarfSetAddress})
+		relocs = append(relocs, ObjReloc{Type: elf.R_X86_64_64, Symbol: sec.Symbol, Addend: int64(rows[start].Offset), Offset: uint64(program.Len())})
+		binary.Write(&program, binary.LittleEndian, uint64(0))
+		addr, file, lineNo, col := rows[start].Offset, 1, 1, 0
+		for _, r := range rows[start:end] {
+			if r.File != file {
+				program.WriteByte(dwarfSetFile)
+				writeULEB128(&program, uint64(r.File))
+				file = r.File
+			}
+			if r.Column != col {
+				program.WriteByte(dwarfSetColumn)
+				writeULEB128(&program, uint64(r.Column))
+				col = r.Column
+			}
+			writeLineAdvance(&program, r.Line-lineNo, r.Offset-addr)
+			addr, lineNo = r.Offset, r.Line
+		}
+		if size := uint64(len(sec.Data)); size > addr {
+			program.WriteByte(dwarfAdvancePC)
+			writeULEB128(&program, size-addr)
+		}
+		program.Write([]byte{0, 1, dwarfEndSequence})
+		start = end
+	}
+
+	// unit_length, version, header_length, header, program
+	var table bytes.Buffer
+	binary.Write(&table, binary.LittleEndian, uint32(2+4+header.Len()+program.Len()))
+	binary.Write(&table, binary.LittleEndian, uint16(4))
+	binary.Write(&table, binary.LittleEndian, uint32(header.Len()))
+	table.Write(header.Bytes())
+	for _, r := range relocs {
+		r.Offset += uint64(table.Len())
+		line.Relocs = append(line.Relocs, r)
+	}
+	table.Write(program.Bytes())
+	line.Data = table.Bytes()
+
+	// The compile unit covers .text, names the source and points at the
+	// line table
+	abbrev.Data = []byte{
+		1, 0x11, 0, // Abbreviation 1, DW_TAG_compile_unit, no children
+		0x10, 0x17, // DW_AT_stmt_list, DW_FORM_sec_offset
+		0x11, 0x01, // DW_AT_low_pc, DW_FORM_addr
+		0x12, 0x07, // DW_AT_high_pc, DW_FORM_data8 (a length)
+		0x03, 0x08, // DW_AT_name, DW_FORM_string
+		0x1b, 0x08, // DW_AT_comp_dir, DW_FORM_string
+		0x25, 0x08, // DW_AT_producer, DW_FORM_string
+		0x13, 0x05, // DW_AT_language, DW_FORM_data2
+		0, 0, 0,
+	}
+	text := obj.Section(".text")
+	name := obj.SourceFile
+	if name == "" && len(obj.LineFiles) > 0 {
+		name = obj.LineFiles[0]
+	}
+	compDir, _ := os.Getwd()
+
+	var unit bytes.Buffer
+	binary.Write(&unit, binary.LittleEndian, uint16(4))
+	info.Relocs = append(info.Relocs, ObjReloc{Offset: 6, Type: elf.R_X86_64_32, Symbol: abbrev.Symbol})
+	binary.Write(&unit, binary.LittleEndian, uint32(0))
+	unit.WriteByte(8)
+	unit.WriteByte(1)
+	info.Relocs = append(info.Relocs, ObjReloc{Offset: uint64(4 + unit.Len()), Type: elf.R_X86_64_32, Symbol: line.Symbol})
+	binary.Write(&unit, binary.LittleEndian, uint32(0))
+	if text != nil {
+		info.Relocs = append(info.Relocs, ObjReloc{Offset: uint64(4 + unit.Len()), Type: elf.R_X86_64_64, Symbol: text.Symbol})
+	}
+	binary.Write(&unit, binary.LittleEndian, uint64(0))
+	var textSize uint64
+	if text != nil {
+		textSize = uint64(len(text.Data))
+	}
+	binary.Write(&unit, binary.LittleEndian, textSize)
+	for _, s := range []string{name, compDir, "Lotus " + CompilerVersion} {
+		unit.WriteString(s)
+		unit.WriteByte(0)
+	}
+	binary.Write(&unit, binary.LittleEndian, uint16(dwarfLangAsm))
+	info.Data = binary.LittleEndian.AppendUint32(nil, uint32(unit.Len()))
+	info.Data = append(info.Data, unit.Bytes()...)
+
+	return []*ObjSection{line, info, abbrev}
+}
+
+// Section returns the section called name, or nil
+func (obj *ObjectFile) Section(name string) *ObjSection {
+	for _, s := range obj.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// sectionIndex returns the position of s among the object's sections
+func (obj *ObjectFile) sectionIndex(s *ObjSection) int {
+	for i, sec := range obj.Sections {
+		if sec == s {
+			return i
+		}
+	}
+	return len(obj.Sections)
+}
+
+// writeLineAdvance moves the line table on by lines and bytes and appends
+// a row, with a special opcode where one fits
+func writeLineAdvance(b *bytes.Buffer, lines int, advance uint64) {
+	if lines < dwarfLineBase || lines >= dwarfLineBase+dwarfLineRange {
+		b.WriteByte(dwarfAdvanceLine)
+		writeSLEB128(b, int64(lines))
+		lines = 0
+	}
+	opcode := uint64(lines-dwarfLineBase) + dwarfLineRange*advance + dwarfOpcodeBase
+	if opcode > 255 {
+		// DW_LNS_const_add_pc moves as far as special opcode 255 does
+		constAddPC := uint64(255-dwarfOpcodeBase) / dwarfLineRange
+		if advance >= constAddPC && opcode-constAddPC*dwarfLineRange <= 255 {
+			b.WriteByte(dwarfConstAddPC)
+			opcode -= constAddPC * dwarfLineRange
+		} else {
+			b.WriteByte(dwarfAdvancePC)
+			writeULEB128(b, advance)
+			opcode = uint64(lines-dwarfLineBase) + dwarfOpcodeBase
+		}
+	}
+	b.WriteByte(byte(opcode))
+}
+
+// writeULEB128 appends n as an unsigned LEB128 number
+func writeULEB128(b *bytes.Buffer, n uint64) {
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n != 0 {
+			c |= 0x80
+		}
+		b.WriteByte(c)
+		if n == 0 {
+			return
+		}
+	}
+}
+
+// writeSLEB128 appends n as a signed LEB128 number
+func writeSLEB128(b *bytes.Buffer, n int64) {
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if (n == 0 && c&0x40 == 0) || (n == -1 && c&0x40 != 0) {
+			b.WriteByte(c)
+			return
+		}
+		b.WriteByte(c | 0x80)
+	}
+}
@@ -9,6 +9,8 @@ package main
 // - Peephole optimizations: Local optimizations on small patterns
 
 import (
+	"fmt"
+	"io"
 	"math/bits"
 )
 
@@ -135,6 +137,14 @@ func optimizeExpression(expr ASTNode) ASTNode {
 
 		return e
 
+	case *Conversion:
+		// Checked already; the value has the type's representation
+		return optimizeExpression(e.Value)
+
+	case *Unwrap:
+		e.Value = optimizeExpression(e.Value)
+		return e
+
 	case *UnaryOp:
 		e.Operand = optimizeExpression(e.Operand)
 
@@ -507,3 +517,53 @@ func (s *OptimizationStats) String() string {
 	}
 	return ""
 }
+
+// OptRemark records one rewrite made by an -O2 pass (-print-opt-remarks)
+type OptRemark struct {
+	Pass     string // "iv", "licm", "cse" or "stack"
+	Function string
+	Line     int
+	Message  string
+	Saved    int // Evaluations or allocations the rewrite removed
+}
+
+// optRemarkTotals describes what each pass's Saved counts, for the summary
+var optRemarkTotals = map[string]string{
+	"iv":    "multiplications per iteration replaced by adds",
+	"licm":  "evaluations per iteration moved out of loops",
+	"cse":   "repeated evaluations eliminated",
+	"stack": "heap allocations moved to the stack",
+}
+
+// OptRemarks collects the remarks of one compilation. Adding to a nil
+// collector does nothing, so passes need not check whether remarks are on.
+type OptRemarks struct {
+	remarks []OptRemark
+}
+
+func (r *OptRemarks) add(remark OptRemark) {
+	if r != nil {
+		r.remarks = append(r.remarks, remark)
+	}
+}
+
+// PrintOptRemarks writes each remark with its source line, then the number
+// of operations each pass eliminated
+func PrintOptRemarks(w io.Writer, file string, r *OptRemarks) {
+	fmt.Fprintf(w, "\n=== Optimization Remarks ===\n")
+	var passes []string
+	saved := make(map[string]int)
+	for _, remark := range r.remarks {
+		fmt.Fprintf(w, "  %s:%d: %s: [%s] %s\n", file, remark.Line, remark.Function, remark.Pass, remark.Message)
+		if _, seen := saved[remark.Pass]; !seen {
+			passes = append(passes, remark.Pass)
+		}
+		saved[remark.Pass] += remark.Saved
+	}
+	if len(passes) == 0 {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+	for _, pass := range passes {
+		fmt.Fprintf(w, "  %s: %s: %d\n", pass, optRemarkTotals[pass], saved[pass])
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// outline.go - Outlining of large stdlib functions
+// A stdlib call is normally expanded in place, which suits the short ones
+// but repeats hundreds of instructions at every call to net::resolve or
+// collections::hashmap_int_put, whose resize loop comes along each time.
+// A function whose expansion reaches the threshold (-outline-threshold,
+// DefaultOutlineThreshold instructions) is instead emitted once, after the
+// program, and called:
+//
+//	    # net::resolve (outlined)
+//	    <hostname into %rax>
+//	    pushq %rax
+//	    <out pointer into %rax>
+//	    movq %rax, .lotus_outlined_net_resolve_arg1(%rip)
+//	    popq .lotus_outlined_net_resolve_arg0(%rip)
+//	    call .lotus_outlined_net_resolve
+//
+// The arguments are evaluated at the call site, in order, and left in slots
+// the body loads them from, so the body is the code the expansion would be
+// with each argument replaced by a load. It clobbers the same registers.
+//
+// -inline module::function keeps one function expanded in place. Functions
+// whose code depends on the form of their arguments, such as str::len of a
+// literal, or that work on the caller's frame, as mem::stackalloc does, are
+// marked Inline in the stdlib table and never outlined, nor are variadic
+// functions. Under -check-memory nothing is outlined, so leak
+// reports keep the line of each allocating call. -print-size reports the
+// code each stdlib function added.
+
+// DefaultOutlineThreshold is the size, in instructions, from which a stdlib
+// function is outlined
+const DefaultOutlineThreshold = 100
+
+// OutlinedArg stands for an argument of an outlined stdlib function
+type OutlinedArg struct {
+	BaseNode
+	Slot string // Label of the slot the call site stores the value in
+}
+
+func (a *OutlinedArg) astNode() {}
+
+// outlinedFunction is a stdlib function emitted once and called
+type outlinedFunction struct {
+	name  string   // module::function
+	label string   // Entry point
+	slots []string // Argument slots, in argument order
+	body  string
+}
+
+// StdlibSize is the code the calls to one stdlib function added
+type StdlibSize struct {
+	Name         string // module::function
+	Calls        int
+	Instructions int // At the call sites, argument evaluation included
+	Outlined     int // In the outlined body, or 0 when expanded in place
+}
+
+// parseInlineFlag checks a -inline module::function setting
+func parseInlineFlag(name string) error {
+	module, function, ok := strings.Cut(name, "::")
+	if !ok || GetModuleFunction(module, function) == nil {
+		return fmt.Errorf("-inline takes a stdlib function as module::function, got %q", name)
+	}
+	return nil
+}
+
+// countInstructions counts the instructions in code, leaving out labels,
+// directives and comments
+func countInstructions(code string) int {
+	n := 0
+	for _, line := range strings.Split(code, "\n") {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, ":"); i >= 0 && !strings.ContainsAny(line[:i], " \t(") {
+			line = strings.TrimSpace(line[i+1:]) // Label, perhaps followed by an instruction
+		}
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, ".") {
+			n++
+		}
+	}
+	return n
+}
+
+// outline returns the outlined form of fn for a call with nargs arguments,
+// generating it on first use, or nil when the call is expanded in place
+func (cg *CodeGenerator) outline(fn *StdlibFunction, nargs int) *outlinedFunction {
+	name := fn.Module + "::" + fn.Name
+	if cg.outlineThreshold <= 0 || cg.checkMemory || fn.Inline || fn.NumArgs < 0 ||
+		nargs != fn.NumArgs || cg.inlineOnly[name] {
+		return nil
+	}
+	if o, decided := cg.outlinedByName[name]; decided {
+		return o
+	}
+	if cg.outlinedByName == nil {
+		cg.outlinedByName = make(map[string]*outlinedFunction)
+	}
+
+	o := &outlinedFunction{name: name, label: ".lotus_outlined_" + fn.Module + "_" + fn.Name}
+	args := make([]ASTNode, nargs)
+	for i := range args {
+		o.slots = append(o.slots, fmt.Sprintf("%s_arg%d", o.label, i))
+		args[i] = &OutlinedArg{Slot: o.slots[i]}
+	}
+
+	// Measured on a generator of its own, with the label numbers it used
+	// handed back, so that a function left in place changes nothing
+	scratch := NewCodeGenerator()
+	scratch.target, scratch.optLevel, scratch.stackProbe = cg.target, cg.optLevel, cg.stackProbe
+	labels := labelCounter
+	fn.CodeGen(scratch, args)
+	labelCounter = labels
+	if countInstructions(scratch.textSection.String()) < cg.outlineThreshold {
+		cg.outlinedByName[name] = nil
+		return nil
+	}
+
+	// The body is generated aside and placed after the program
+	saved := cg.textSection
+	cg.textSection = strings.Builder{}
+	fn.CodeGen(cg, args)
+	o.body = cg.textSection.String()
+	cg.textSection = saved
+	for _, slot := range o.slots {
+		cg.dataSection.WriteString(fmt.Sprintf("%s:\n    .quad 0\n", slot))
+	}
+	cg.outlinedByName[name] = o
+	cg.outlinedFunctions = append(cg.outlinedFunctions, o)
+	return o
+}
+
+// generateOutlinedCall evaluates the arguments of call into the slots of o
+// and calls it
+func (cg *CodeGenerator) generateOutlinedCall(call *FunctionCall, o *outlinedFunction) {
+	cg.textSection.WriteString(fmt.Sprintf("    # %s (outlined)\n", o.name))
+	last := len(call.Args) - 1
+	for i, arg := range call.Args {
+		cg.generateExpressionToReg(arg, "rax")
+		if i < last {
+			cg.textSection.WriteString("    pushq %rax\n")
+		} else {
+			cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, %s(%%rip)\n", o.slots[i]))
+		}
+	}
+	for i := last - 1; i >= 0; i-- {
+		cg.textSection.WriteString(fmt.Sprintf("    popq %s(%%rip)\n", o.slots[i]))
+	}
+	cg.asm().Call(o.label)
+	cg.checkFreestandingCall(call, o.body)
+}
+
+// outlinedCode returns the outlined functions, to be placed at offset base
+// of the program code. The frame around each body returns with the stack
+// pointer restored whatever the body leaves in it.
+func (cg *CodeGenerator) outlinedCode(base int) string {
+	var b strings.Builder
+	for _, o := range cg.outlinedFunctions {
+		start := base + b.Len()
+		fmt.Fprintf(&b, "    # %s, outlined\n", o.name)
+		b.WriteString(o.label + ":\n")
+		b.WriteString("    pushq %rbp\n")
+		b.WriteString("    movq %rsp, %rbp\n")
+		b.WriteString(o.body)
+		b.WriteString("    movq %rbp, %rsp\n")
+		b.WriteString("    popq %rbp\n")
+		b.WriteString("    ret\n\n")
+		cg.syscallOrigins = append(cg.syscallOrigins,
+			syscallOrigin{start: start, end: base + b.Len(), function: o.name + " (outlined)"})
+	}
+	return b.String()
+}
+
+// noteStdlibSize records the code a call to fn emitted at its call site
+func (cg *CodeGenerator) noteStdlibSize(fn *StdlibFunction, code string) {
+	name := fn.Module + "::" + fn.Name
+	var size *StdlibSize
+	for _, s := range cg.stdlibSizes {
+		if s.Name == name {
+			size = s
+		}
+	}
+	if size == nil {
+		size = &StdlibSize{Name: name}
+		cg.stdlibSizes = append(cg.stdlibSizes, size)
+	}
+	size.Calls++
+	size.Instructions += countInstructions(code)
+	if o := cg.outlinedByName[name]; o != nil {
+		size.Outlined = countInstructions(o.body)
+	}
+}
+
+// PrintSizeReport writes the per-function stdlib code size report, largest
+// first
+func PrintSizeReport(w io.Writer, sizes []*StdlibSize) {
+	sorted := append([]*StdlibSize(nil), sizes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Instructions+sorted[i].Outlined > sorted[j].Instructions+sorted[j].Outlined
+	})
+	width := len("Function")
+	for _, s := range sorted {
+		width = max(width, len(s.Name))
+	}
+
+	fmt.Fprintf(w, "\n=== Stdlib Code Size (instructions) ===\n")
+	fmt.Fprintf(w, "  %-*s  %5s  %10s  %s\n", width, "Function", "Calls", "Call sites", "Outlined")
+	if len(sorted) == 0 {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+	total := 0
+	for _, s := range sorted {
+		outlined := "-"
+		if s.Outlined > 0 {
+			outlined = fmt.Sprintf("%d", s.Outlined)
+		}
+		fmt.Fprintf(w, "  %-*s  %5d  %10d  %s\n", width, s.Name, s.Calls, s.Instructions, outlined)
+		total += s.Instructions + s.Outlined
+	}
+	fmt.Fprintf(w, "  Total: %d\n", total)
+}
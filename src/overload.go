@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// overload.go - Function overloading
+// Functions may share a name when their parameter types differ:
+//
+//	fn int area(int side) { ret side * side; }
+//	fn int area(int w, int h) { ret w * h; }
+//	fn int size(string s) { ret str::len(s); }
+//	fn int size(int arr) { ret collections::array_int_len(arr); }
+//
+// Before analysis each overload is renamed to its name followed by its
+// parameter types (size.string, size.int) and every call is pointed at the
+// overload its arguments fit. An argument fits a parameter of the same type,
+// an integer fits any integer parameter, and an argument whose type cannot
+// be worked out (a stdlib call, a pointer) fits anything. When more than one
+// overload fits, the one with the most exact matches wins; a tie is an error.
+// Functions with a single definition keep their names. Calls to generic
+// functions (generics.go) are resolved in the same walk.
+
+// overloadResolver renames overloads and resolves calls to them
+type overloadResolver struct {
+	dm        *DiagnosticManager
+	file      string                           // File being walked, for diagnostics
+	overloads map[string][]*FunctionDefinition // Overloaded name -> its definitions
+	returns   map[string]TokenType             // Function name -> return type
+	constants map[string]TokenType             // Constant name -> type
+	vars      map[string]TokenType             // Variables of the function being walked
+	generics  map[string]*GenericFunction      // Generic functions by name
+	instances map[string]*FunctionDefinition   // Instantiated generics, nil when it failed
+	added     []ASTNode                        // Instances in order of first use
+}
+
+// ResolveOverloads renames overloaded functions in program and points each
+// call to one at the overload it resolves to. Calls to generic functions are
+// pointed at instances of them, which it returns to be added to the program.
+// Problems go to dm.
+func ResolveOverloads(program []ASTNode, dm *DiagnosticManager) []ASTNode {
+	or := &overloadResolver{
+		dm:        dm,
+		file:      dm.FilePath,
+		overloads: make(map[string][]*FunctionDefinition),
+		returns:   make(map[string]TokenType),
+		constants: make(map[string]TokenType),
+		generics:  make(map[string]*GenericFunction),
+		instances: make(map[string]*FunctionDefinition),
+	}
+	var names []string // In order of first definition, for stable diagnostics
+	for _, node := range program {
+		switch n := node.(type) {
+		case *FunctionDefinition:
+			if _, ok := or.overloads[n.Name]; !ok {
+				names = append(names, n.Name)
+			}
+			or.overloads[n.Name] = append(or.overloads[n.Name], n)
+		case *ConstantDeclaration:
+			or.constants[n.Name] = n.Type
+		case *GenericFunction:
+			if prev, ok := or.generics[n.Name]; ok {
+				or.errorAt(ErrRedefinition, n.File, n.Loc(), fmt.Sprintf("generic function '%s' is already defined at line %d", n.Name, prev.Loc().Line))
+			}
+			or.generics[n.Name] = n
+		}
+	}
+	for _, name := range names {
+		if gf, ok := or.generics[name]; ok {
+			defs := or.overloads[name]
+			or.errorAt(ErrRedefinition, defs[0].File, defs[0].Loc(), fmt.Sprintf("'%s' is also defined as a generic function at line %d", name, gf.Loc().Line))
+		}
+	}
+	for _, name := range names {
+		if defs := or.overloads[name]; len(defs) == 1 {
+			delete(or.overloads, name)
+			or.returns[name] = defs[0].ReturnType
+		}
+	}
+	if len(or.overloads) == 0 && len(or.generics) == 0 {
+		return nil
+	}
+	for _, name := range names {
+		if defs, ok := or.overloads[name]; ok {
+			or.rename(name, defs)
+		}
+	}
+
+	or.vars = make(map[string]TokenType)
+	for _, node := range program {
+		or.visit(node)
+	}
+	return or.added
+}
+
+// rename gives each overload of name its mangled name, reporting overloads
+// that cannot be told apart
+func (or *overloadResolver) rename(name string, defs []*FunctionDefinition) {
+	seen := make(map[string]*FunctionDefinition)
+	for _, fn := range defs {
+		mangled := name
+		for _, param := range fn.Parameters {
+			mangled += "." + TokenValue(Token{Type: param.Type})
+			if param.Variadic {
+				mangled += "..."
+			}
+		}
+		if name == "main" {
+			or.errorAt(ErrRedefinition, fn.File, fn.Loc(), "main cannot be overloaded")
+		} else if prev, ok := seen[mangled]; ok {
+			or.errorAt(ErrRedefinition, fn.File, fn.Loc(), fmt.Sprintf("'%s%s' is already defined at line %d",
+				name, signature(fn), prev.Loc().Line))
+		}
+		seen[mangled] = fn
+		fn.Name = mangled
+		or.returns[mangled] = fn.ReturnType
+	}
+}
+
+// signature formats fn's parameter types for messages: (int, string)
+func signature(fn *FunctionDefinition) string {
+	types := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		types[i] = TokenValue(Token{Type: param.Type})
+		if param.Variadic {
+			types[i] += "..."
+		}
+	}
+	return "(" + strings.Join(types, ", ") + ")"
+}
+
+// errorAt reports a problem in file, or the file being walked when file is ""
+func (or *overloadResolver) errorAt(code ErrorCode, file string, loc Location, message string) {
+	if file == "" {
+		file = or.file
+	}
+	or.dm.AddErrorWithCode(string(code), CategorySemantic, message, file, loc.Line, loc.Column, "")
+}
+
+// visit resolves the calls in node, after those in its arguments so that
+// nested calls have a return type
+func (or *overloadResolver) visit(node ASTNode) {
+	switch n := node.(type) {
+	case nil:
+		return
+	case *FunctionDefinition:
+		savedFile, savedVars := or.file, or.vars
+		if n.File != "" {
+			or.file = n.File
+		}
+		or.vars = make(map[string]TokenType)
+		for _, param := range n.Parameters {
+			or.vars[param.Name] = param.Type
+			if param.Variadic {
+				or.vars[param.Name] = TokenTypeInt // The array's address
+			}
+		}
+		or.visitAll(n.Body)
+		or.file, or.vars = savedFile, savedVars
+		return
+	case *VariableDeclaration:
+		or.visit(n.Value)
+		or.vars[n.Name] = n.Type
+		return
+	case *FunctionCall:
+		or.visitAll(n.Args)
+		or.resolve(n)
+		return
+	case *MethodCall:
+		or.visitAll(n.Args)
+		return
+	case *ComptimeBlock:
+		or.visitAll(n.Body)
+		return
+	case *ThrowStatement:
+		or.visit(n.Exception)
+		return
+	case *GotoStatement:
+		or.visit(n.Target)
+		return
+	case *TryStatement:
+		or.visitAll(n.TryBlock)
+		for _, clause := range n.CatchClauses {
+			or.visitAll(clause.Body)
+		}
+		or.visitAll(n.FinallyBlock)
+		return
+	}
+	children, _ := nodeChildren(node)
+	or.visitAll(children)
+}
+
+func (or *overloadResolver) visitAll(nodes []ASTNode) {
+	for _, node := range nodes {
+		or.visit(node)
+	}
+}
+
+// resolve renames call to the overload its arguments fit, if it calls an
+// overloaded function
+func (or *overloadResolver) resolve(call *FunctionCall) {
+	module, name, qualified := strings.Cut(call.Name, "::")
+	if !qualified {
+		name, module = module, ""
+	}
+	if gf, ok := or.generics[name]; ok {
+		or.resolveGeneric(call, gf)
+		return
+	}
+	defs, ok := or.overloads[name]
+	if !ok {
+		return
+	}
+
+	argTypes := make([]TokenType, len(call.Args))
+	for i, arg := range call.Args {
+		argTypes[i] = or.typeOf(arg)
+	}
+	var best []*FunctionDefinition
+	bestScore := -1
+	for _, fn := range defs {
+		score := overloadScore(fn, argTypes)
+		switch {
+		case score > bestScore:
+			best, bestScore = []*FunctionDefinition{fn}, score
+		case score == bestScore && score >= 0:
+			best = append(best, fn)
+		}
+	}
+
+	switch {
+	case bestScore < 0:
+		or.errorAt(ErrTypeMismatch, "", call.NameLoc, fmt.Sprintf("no overload of '%s' takes %s", name, argumentList(argTypes)))
+	case len(best) > 1 && best[0].Name != best[1].Name: // Duplicates are reported already
+		or.errorAt(ErrTypeMismatch, "", call.NameLoc, fmt.Sprintf("call to '%s' is ambiguous: %s and %s both fit %s",
+			name, signature(best[0]), signature(best[1]), argumentList(argTypes)))
+	default:
+		call.Name = best[0].Name
+		if qualified {
+			call.Name = module + "::" + best[0].Name
+		}
+	}
+}
+
+// resolveGeneric points call at the instance of gf its arguments select,
+// instantiating it on first use
+func (or *overloadResolver) resolveGeneric(call *FunctionCall, gf *GenericFunction) {
+	argTypes := make([]TokenType, len(call.Args))
+	for i, arg := range call.Args {
+		argTypes[i] = or.typeOf(arg)
+	}
+	types, err := gf.inferTypes(argTypes)
+	if err != nil {
+		or.errorAt(ErrTypeMismatch, "", call.NameLoc, err.Error())
+		return
+	}
+
+	name := gf.instanceName(types)
+	if _, ok := or.instances[name]; !ok {
+		fn, err := gf.instantiate(types)
+		if err != nil {
+			file := gf.File
+			if file == "" {
+				file = or.dm.FilePath
+			}
+			var perr *ParseError
+			if errors.As(err, &perr) {
+				or.dm.AddDiagnostic(perr.Diagnostic(file))
+			} else {
+				or.errorAt(ErrInvalidDeclaration, file, gf.Loc(), err.Error())
+			}
+		}
+		or.instances[name] = fn
+		if fn != nil {
+			or.returns[name] = fn.ReturnType
+			or.added = append(or.added, fn)
+			or.visit(fn)
+		}
+	}
+	if module, _, qualified := strings.Cut(call.Name, "::"); qualified {
+		name = module + "::" + name
+	}
+	call.Name = name
+}
+
+// overloadScore rates how well args fit fn: -1 when they do not, otherwise
+// the number of exact type matches
+func overloadScore(fn *FunctionDefinition, args []TokenType) int {
+	params := fn.Parameters
+	if fn.Variadic() {
+		params = params[:len(params)-1]
+		if len(args) < len(params) {
+			return -1
+		}
+	} else if len(args) != len(params) {
+		return -1
+	}
+	score := 0
+	for i, param := range params {
+		switch {
+		case args[i] == param.Type:
+			score++
+		case args[i] == TokenEOF, IsIntegerType(args[i]) && IsIntegerType(param.Type):
+		default:
+			return -1
+		}
+	}
+	return score
+}
+
+// argumentList formats argument types for messages, with ? for unknown ones
+func argumentList(types []TokenType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = "?"
+		if t != TokenEOF {
+			names[i] = TokenValue(Token{Type: t})
+		}
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// typeOf returns the type of expr, or TokenEOF when it cannot be worked out
+// without running the program
+func (or *overloadResolver) typeOf(expr ASTNode) TokenType {
+	switch e := expr.(type) {
+	case *IntLiteral, *CharLiteral:
+		return TokenTypeInt
+	case *StringLiteral:
+		return TokenTypeString
+	case *BoolLiteral, *Comparison, *LogicalOp:
+		return TokenTypeBool
+	case *FloatLiteral:
+		return TokenTypeFloat
+	case *Conversion:
+		return e.Type
+	case *Include:
+		if e.Text {
+			return TokenTypeString
+		}
+		return TokenTypeInt
+	case *Unwrap:
+		return or.typeOf(e.Value)
+	case *Identifier:
+		if t, ok := or.vars[e.Name]; ok {
+			return t
+		}
+		if t, ok := or.constants[e.Name]; ok {
+			return t
+		}
+	case *FunctionCall:
+		if t, ok := or.returns[e.Name]; ok {
+			return t
+		}
+	case *BinaryOp:
+		left, right := or.typeOf(e.Left), or.typeOf(e.Right)
+		if left == TokenTypeFloat || right == TokenTypeFloat {
+			return TokenTypeFloat
+		}
+		if IsIntegerType(left) && IsIntegerType(right) {
+			return TokenTypeInt
+		}
+	case *UnaryOp:
+		switch e.Operator {
+		case TokenMinus, TokenTilde:
+			return or.typeOf(e.Operand)
+		case TokenExclaim:
+			return TokenTypeBool
+		}
+	}
+	return TokenEOF
+}
@@ -1,6 +1,10 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // parser.go - Recursive descent parser for Lotus language
 // This file implements syntactic analysis, converting a token stream into an AST.
@@ -54,27 +58,58 @@ func (p *Parser) expect(expected TokenType) error {
 
 // formatError creates a detailed error with context
 func (p *Parser) formatError(msg string) error {
-	tok := p.current()
-	return fmt.Errorf("[%s] line %d, col %d: %s", ErrUnexpectedToken, tok.Line, tok.Column, msg)
+	return p.newTokenError(ErrUnexpectedToken, msg)
 }
 
 // formatErrorWithCode creates an error with a specific error code
 func (p *Parser) formatErrorWithCode(code ErrorCode, msg string) error {
-	tok := p.current()
-	return fmt.Errorf("[%s] line %d, col %d: %s", code, tok.Line, tok.Column, msg)
+	return p.newTokenError(code, msg)
 }
 
 // formatErrorWithSuggestion creates an error with a suggestion
 func (p *Parser) formatErrorWithSuggestion(msg, suggestion string) error {
+	return p.newTokenError(ErrUnexpectedToken, msg).WithSuggestion(suggestion)
+}
+
+// newTokenError builds a ParseError spanning the current token
+func (p *Parser) newTokenError(code ErrorCode, msg string) *ParseError {
 	tok := p.current()
-	if suggestion != "" {
-		return fmt.Errorf("[%s] line %d, col %d: %s\n  help: %s", ErrUnexpectedToken, tok.Line, tok.Column, msg, suggestion)
+	err := NewParseError(code, msg, tok.Line, tok.Column)
+	err.EndColumn = tok.Column + tokenWidth(tok)
+	return err
+}
+
+// tokenWidth returns the number of source columns a token occupies
+func tokenWidth(tok Token) int {
+	switch tok.Type {
+	case TokenEOF, TokenNewline:
+		return 1
+	case TokenString:
+		return len([]rune(tok.Value)) + 2
+	case TokenChar:
+		return len([]rune(tok.Value)) + 2
+	}
+	if tok.Value != "" {
+		return len([]rune(tok.Value))
+	}
+	if text := TokenValue(tok); text != "unknown" {
+		return len([]rune(text))
+	}
+	return 1
+}
+
+// setLocation records the source position of tok on a freshly parsed node
+func setLocation(node ASTNode, tok Token) {
+	if l, ok := node.(interface{ setLoc(Location) }); ok {
+		l.setLoc(Location{Line: tok.Line, Column: tok.Column})
 	}
-	return fmt.Errorf("[%s] line %d, col %d: %s", ErrUnexpectedToken, tok.Line, tok.Column, msg)
 }
 
 // Parse parses the token stream and returns an AST
 func (p *Parser) Parse() ([]ASTNode, error) {
+	if err := p.declareTypes(); err != nil {
+		return nil, err
+	}
 	var statements []ASTNode
 
 	for p.current().Type != TokenEOF {
@@ -84,7 +119,13 @@ func (p *Parser) Parse() ([]ASTNode, error) {
 			continue
 		}
 
-		stmt, err := p.parseStatement()
+		var stmt ASTNode
+		var err error
+		if p.isInitBlock() {
+			stmt, err = p.parseInitBlock()
+		} else {
+			stmt, err = p.parseStatement()
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -101,10 +142,27 @@ func (p *Parser) Parse() ([]ASTNode, error) {
 	return statements, nil
 }
 
-// parseStatement parses a single statement
+// parseStatement parses a single statement and records where it started
 func (p *Parser) parseStatement() (ASTNode, error) {
+	if p.current().Type == TokenAt {
+		return p.parseAttributedDeclaration()
+	}
+	start := p.current()
+	stmt, err := p.parseStatementKind()
+	if err != nil {
+		return nil, err
+	}
+	setLocation(stmt, start)
+	return stmt, nil
+}
+
+// parseStatementKind dispatches on the leading token of a statement
+func (p *Parser) parseStatementKind() (ASTNode, error) {
 	switch p.current().Type {
 	case TokenFn:
+		if p.isGenericFunction() {
+			return p.parseGenericFunction()
+		}
 		return p.parseFunctionDefinition()
 	case TokenUse:
 		return p.parseImportStatement()
@@ -120,6 +178,8 @@ func (p *Parser) parseStatement() (ASTNode, error) {
 		return p.parseForLoop()
 	case TokenConst:
 		return p.parseConstantDeclaration()
+	case TokenGoto:
+		return p.parseGotoStatement()
 	case TokenTypeInt, TokenTypeInt8, TokenTypeInt16, TokenTypeInt32, TokenTypeInt64,
 		TokenTypeUint, TokenTypeUint8, TokenTypeUint16, TokenTypeUint32, TokenTypeUint64,
 		TokenTypeString, TokenTypeBool, TokenTypeFloat:
@@ -134,7 +194,13 @@ func (p *Parser) parseStatement() (ASTNode, error) {
 		case TokenLParen:
 			// Back up to re-parse as function call
 			p.pos--
-			return p.parseFunctionCall()
+			start := p.current()
+			call, err := p.parseFunctionCall()
+			if err != nil {
+				return nil, err
+			}
+			setLocation(call, start)
+			return p.parseMethodCalls(call)
 		case TokenColon:
 			// Check for module-qualified function call: module::function()
 			if p.peek().Type == TokenColon {
@@ -143,7 +209,8 @@ func (p *Parser) parseStatement() (ASTNode, error) {
 				if p.current().Type != TokenIdentifier {
 					return nil, fmt.Errorf("expected function name after '::', got token type %d", p.current().Type)
 				}
-				funcName := p.current().Value
+				nameTok := p.current()
+				funcName := nameTok.Value
 				p.advance()
 				if p.current().Type != TokenLParen {
 					return nil, fmt.Errorf("expected '(' after function name, got token type %d", p.current().Type)
@@ -165,11 +232,21 @@ func (p *Parser) parseStatement() (ASTNode, error) {
 
 				// Return a FunctionCall with module-qualified name
 				return &FunctionCall{
-					Name: name + "::" + funcName,
-					Args: args,
+					Name:    name + "::" + funcName,
+					Args:    args,
+					NameLoc: Location{Line: nameTok.Line, Column: nameTok.Column},
 				}, nil
 			}
-			return nil, fmt.Errorf("unexpected single ':' after identifier %s", name)
+			// name: labels the next statement
+			p.advance()
+			return &LabelStatement{Name: name}, nil
+		case TokenDot:
+			if _, ok := StandardLibrary[name]; ok {
+				return nil, p.moduleDotError(name)
+			}
+			recv := &Identifier{Name: name}
+			setLocation(recv, p.tokens[p.pos-1])
+			return p.parseMethodCalls(recv)
 		case TokenAssign:
 			// Simple assignment: identifier = expression
 			p.advance()
@@ -370,9 +447,13 @@ func (p *Parser) parseReturnStatement() (*ReturnStatement, error) {
 	// Return value can be any expression (optional)
 	var value ASTNode
 	// If next token looks like start of an expression, parse it
+	startsValue := p.isConversion()
 	switch p.current().Type {
 	case TokenInt, TokenString, TokenBool, TokenFloat, TokenIdentifier, TokenLParen,
-		TokenMinus, TokenExclaim, TokenAmpersand, TokenStar:
+		TokenMinus, TokenExclaim, TokenAmpersand, TokenStar, TokenNull:
+		startsValue = true
+	}
+	if startsValue {
 		expr, err := p.parseExpression()
 		if err != nil {
 			return nil, err
@@ -385,8 +466,9 @@ func (p *Parser) parseReturnStatement() (*ReturnStatement, error) {
 
 // parseVariableDeclaration parses a variable declaration
 func (p *Parser) parseVariableDeclaration() (*VariableDeclaration, error) {
-	varType := p.current().Type
+	varType, typeName := p.current().Type, p.current().Value
 	p.advance()
+	nullable := p.parseNullable()
 
 	if p.current().Type != TokenIdentifier {
 		return nil, p.formatErrorWithCode(ErrExpectedToken, MsgMissingIdentifier+", got "+TokenTypeName(p.current().Type))
@@ -404,9 +486,11 @@ func (p *Parser) parseVariableDeclaration() (*VariableDeclaration, error) {
 	}
 
 	return &VariableDeclaration{
-		Name:  varName,
-		Type:  varType,
-		Value: value,
+		Name:     varName,
+		Type:     varType,
+		TypeName: typeName,
+		Nullable: nullable,
+		Value:    value,
 	}, nil
 }
 
@@ -420,7 +504,7 @@ func (p *Parser) parseConstantDeclaration() (*ConstantDeclaration, error) {
 	if !isTypeToken(p.current().Type) {
 		return nil, p.formatErrorWithCode(ErrExpectedToken, MsgMissingType+" after 'const', got "+TokenTypeName(p.current().Type))
 	}
-	constType := p.current().Type
+	constType, typeName := p.current().Type, p.current().Value
 	p.advance()
 
 	// Constant name
@@ -442,9 +526,10 @@ func (p *Parser) parseConstantDeclaration() (*ConstantDeclaration, error) {
 	}
 
 	return &ConstantDeclaration{
-		Name:  constName,
-		Type:  constType,
-		Value: value,
+		Name:     constName,
+		Type:     constType,
+		TypeName: typeName,
+		Value:    value,
 	}, nil
 }
 
@@ -463,12 +548,23 @@ func (p *Parser) parseImportStatement() (*ImportStatement, error) {
 	if p.current().Type != TokenString {
 		return nil, p.formatErrorWithCode(ErrExpectedToken, "expected module name (string) after 'use', got "+TokenTypeName(p.current().Type))
 	}
-	moduleName := p.current().Value
+	moduleTok := p.current()
 	p.advance()
 
 	stmt := &ImportStatement{
-		Module: moduleName,
-		Items:  []string{},
+		Module:    moduleTok.Value,
+		Items:     []string{},
+		ModuleLoc: Location{Line: moduleTok.Line, Column: moduleTok.Column},
+	}
+
+	// Item selection may also be written inside the string: use "module::function"
+	if module, item, ok := strings.Cut(moduleTok.Value, "::"); ok {
+		stmt.Module = module
+		if item == "*" {
+			stmt.IsWildcard = true
+		} else if item != "" {
+			stmt.Items = append(stmt.Items, item)
+		}
 	}
 
 	// Check for specific imports (::function or ::*)
@@ -514,7 +610,7 @@ func isTypeToken(t TokenType) bool {
 
 // parseFunctionCall parses a function call
 func (p *Parser) parseFunctionCall() (*FunctionCall, error) {
-	name := p.current().Value
+	nameTok := p.current()
 	p.advance()
 
 	if err := p.expect(TokenLParen); err != nil {
@@ -539,11 +635,34 @@ func (p *Parser) parseFunctionCall() (*FunctionCall, error) {
 	}
 
 	return &FunctionCall{
-		Name: name,
-		Args: args,
+		Name:    nameTok.Value,
+		Args:    args,
+		NameLoc: Location{Line: nameTok.Line, Column: nameTok.Column},
 	}, nil
 }
 
+// moduleDotError reports a module function written as `module.func` instead of
+// `module::func`, with a fix-it that also corrects a misspelled function name
+func (p *Parser) moduleDotError(module string) error {
+	dot := p.current()
+	err := p.newTokenError(ErrUnexpectedToken,
+		fmt.Sprintf("module functions are called with '::', not '.' (%s::name)", module))
+
+	fix := FixIt{Line: dot.Line, Column: dot.Column, EndLine: dot.Line, EndColumn: dot.Column + 1, Replacement: "::"}
+	if name := p.peek(); name.Type == TokenIdentifier {
+		funcName := name.Value
+		if _, ok := StandardLibrary[module].Functions[funcName]; !ok {
+			if match := suggestName(funcName, moduleFunctionNames(module)); match != "" {
+				funcName = match
+			}
+		}
+		fix.EndLine = name.Line
+		fix.EndColumn = name.Column + tokenWidth(name)
+		fix.Replacement = "::" + funcName
+	}
+	return err.WithSuggestion(fmt.Sprintf("did you mean '%s%s'?", module, fix.Replacement)).WithFix(fix)
+}
+
 // parseExpression parses an expression with operator precedence
 func (p *Parser) parseExpression() (ASTNode, error) {
 	return p.parseLogicalOr()
@@ -797,6 +916,9 @@ func (p *Parser) parseMultiplyDivide() (ASTNode, error) {
 
 // parseUnary handles unary operators including bitwise NOT (~)
 func (p *Parser) parseUnary() (ASTNode, error) {
+	if p.current().Type == TokenAnd {
+		return p.parseLabelAddress()
+	}
 	if p.current().Type == TokenMinus || p.current().Type == TokenExclaim || p.current().Type == TokenAmpersand || p.current().Type == TokenStar || p.current().Type == TokenTilde {
 		op := p.current().Type
 		p.advance()
@@ -824,8 +946,31 @@ func isUnaryContext(p *Parser, left ASTNode) bool {
 	return false
 }
 
-// parsePrimary handles primary expressions (literals, identifiers, parentheses)
+// parsePrimary handles primary expressions and records where each one started
 func (p *Parser) parsePrimary() (ASTNode, error) {
+	start := p.current()
+	expr, err := p.parsePrimaryKind()
+	if err != nil {
+		return nil, err
+	}
+	if start.Type != TokenLParen {
+		setLocation(expr, start)
+	}
+	expr, err = p.parseMethodCalls(p.parseUnwraps(expr))
+	if err != nil {
+		return nil, err
+	}
+	return p.parseUnwraps(expr), nil
+}
+
+// parsePrimaryKind handles primary expressions (literals, identifiers, parentheses)
+func (p *Parser) parsePrimaryKind() (ASTNode, error) {
+	if p.isConversion() {
+		return p.parseConversion()
+	}
+	if p.isInclude() {
+		return p.parseInclude()
+	}
 	switch p.current().Type {
 	case TokenInt:
 		val, _ := parseIntToken(p.current().Value)
@@ -863,7 +1008,8 @@ func (p *Parser) parsePrimary() (ASTNode, error) {
 			if p.current().Type != TokenIdentifier {
 				return nil, p.formatErrorWithCode(ErrExpectedToken, "expected function name after '::', got "+TokenTypeName(p.current().Type))
 			}
-			funcName := p.current().Value
+			nameTok := p.current()
+			funcName := nameTok.Value
 			p.advance()
 			if p.current().Type != TokenLParen {
 				return nil, p.formatErrorWithCode(ErrExpectedToken, MsgMissingParameterList+", got "+TokenTypeName(p.current().Type))
@@ -885,11 +1031,24 @@ func (p *Parser) parsePrimary() (ASTNode, error) {
 
 			// Return a FunctionCall with module-qualified name
 			return &FunctionCall{
-				Name: name + "::" + funcName,
-				Args: args,
+				Name:    name + "::" + funcName,
+				Args:    args,
+				NameLoc: Location{Line: nameTok.Line, Column: nameTok.Column},
 			}, nil
 		}
+		if _, ok := StandardLibrary[name]; ok && p.current().Type == TokenDot {
+			return nil, p.moduleDotError(name)
+		}
 		return &Identifier{Name: name}, nil
+	case TokenNull:
+		p.advance()
+		return &NullLiteral{}, nil
+	case TokenComptime:
+		return p.parseComptimeBlock()
+	case TokenLBracket:
+		return p.parseArrayLiteral()
+	case TokenLBrace:
+		return p.parseMapLiteral()
 	case TokenLParen:
 		p.advance()
 		expr, err := p.parseExpression()
@@ -912,10 +1071,8 @@ func parseIntToken(s string) (int, error) {
 	return val, err
 }
 
-func parseFloatToken(s string) (int64, error) {
-	var val float64
-	_, err := fmt.Sscanf(s, "%f", &val)
-	return int64(val * 1000), err
+func parseFloatToken(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
 }
 
 // parseFunctionDefinition parses a C-style function declaration prefixed with 'fn'
@@ -926,12 +1083,23 @@ func (p *Parser) parseFunctionDefinition() (*FunctionDefinition, error) {
 		return nil, err
 	}
 
+	// Receiver: fn (type name) ret method(...)
+	var params []FunctionParam
+	if p.current().Type == TokenLParen {
+		recv, err := p.parseReceiver()
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, recv)
+	}
+
 	// Return type
 	if !isTypeToken(p.current().Type) {
 		return nil, p.formatErrorWithCode(ErrExpectedToken, MsgMissingReturnType+", got "+TokenTypeName(p.current().Type))
 	}
-	retType := p.current().Type
+	retType, retName := p.current().Type, p.current().Value
 	p.advance()
+	retNullable := p.parseNullable()
 
 	// Function name
 	if p.current().Type != TokenIdentifier {
@@ -945,14 +1113,18 @@ func (p *Parser) parseFunctionDefinition() (*FunctionDefinition, error) {
 		return nil, err
 	}
 
-	var params []FunctionParam
 	for p.current().Type != TokenRParen {
 		// Parameter type
 		if !isTypeToken(p.current().Type) {
 			return nil, p.formatErrorWithCode(ErrExpectedToken, "expected parameter type, got "+TokenTypeName(p.current().Type))
 		}
-		pType := p.current().Type
+		pType, pTypeName := p.current().Type, p.current().Value
 		p.advance()
+		pNullable := p.parseNullable()
+		variadic, err := p.parseEllipsis()
+		if err != nil {
+			return nil, err
+		}
 
 		// Parameter name
 		if p.current().Type != TokenIdentifier {
@@ -961,11 +1133,17 @@ func (p *Parser) parseFunctionDefinition() (*FunctionDefinition, error) {
 		pName := p.current().Value
 		p.advance()
 
-		params = append(params, FunctionParam{Name: pName, Type: pType})
+		params = append(params, FunctionParam{Name: pName, Type: pType, TypeName: pTypeName, Nullable: pNullable, Variadic: variadic})
 
 		if p.current().Type == TokenComma {
 			p.advance()
 		}
+		if variadic && p.current().Type != TokenRParen {
+			return nil, p.formatErrorWithCode(ErrInvalidDeclaration, "variadic parameter '"+pName+"' must be the last parameter")
+		}
+		if variadic && len(params) > maxFixedParams+1 {
+			return nil, p.formatErrorWithCode(ErrInvalidDeclaration, fmt.Sprintf("a variadic function can have at most %d other parameters", maxFixedParams))
+		}
 	}
 
 	if err := p.expect(TokenRParen); err != nil {
@@ -1001,9 +1179,11 @@ func (p *Parser) parseFunctionDefinition() (*FunctionDefinition, error) {
 	}
 
 	return &FunctionDefinition{
-		Name:       name,
-		Parameters: params,
-		ReturnType: retType,
-		Body:       body,
+		Name:           name,
+		Parameters:     params,
+		ReturnType:     retType,
+		ReturnName:     retName,
+		ReturnNullable: retNullable,
+		Body:           body,
 	}, nil
 }
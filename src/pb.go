@@ -0,0 +1,193 @@
+package main
+
+// pb.go - Protocol Buffers wire format runtime
+// The pb module's functions call these routines, which are appended to the
+// program once when any of them is used. Like the MessagePack routines each
+// works on the buffer from %rdi up to %rsi, moves %rdi past what it wrote or
+// read, and returns 0 in %rax, or -ENOSPC when a value does not fit,
+// -ENODATA when the buffer ends inside a value and -EBADMSG when a value is
+// malformed:
+//
+//	.lotus_rt_pb_put_varint  writes %rax as a varint, all 64 bits of it
+//	.lotus_rt_pb_put_le      writes the low %rcx bytes of %rax, least
+//	                         significant first, as fixed32 and fixed64 are
+//	.lotus_rt_pb_put_len     writes the length %rax and then that many bytes
+//	                         from %r9, a length-delimited value
+//	.lotus_rt_pb_get_varint  reads a varint of at most 10 bytes into %rdx
+//	.lotus_rt_pb_get_le      reads %rcx bytes, least significant first, into %rdx
+//	.lotus_rt_pb_get_len     reads a length into %rdx, which must not run
+//	                         past the end of the buffer
+//	.lotus_rt_pb_skip        steps over a value of the wire type in %rdx
+//
+// They clobber %rcx, %rdx and %r8-%r10.
+
+// Wire types of a field's tag
+const (
+	pbWireVarint  = 0
+	pbWireFixed64 = 1
+	pbWireLen     = 2
+	pbWireFixed32 = 5
+	pbWireMax     = 5
+)
+
+// pbFieldMax is the largest field number a tag can hold
+const pbFieldMax = 1<<29 - 1
+
+// usePb appends the Protocol Buffers runtime to the program
+func (cg *CodeGenerator) usePb() {
+	cg.pb = true
+}
+
+// pbRuntime returns the encoding and decoding routines
+func (cg *CodeGenerator) pbRuntime() string {
+	return cg.localLabels("lotus_rt_pb", `
+# ---- Protocol Buffers ----
+.lotus_rt_pb_put_varint:
+    movq %rdi, %r8
+{l1}:
+    cmpq %rsi, %r8
+    jae {l3}
+    movl %eax, %ecx
+    andl $0x7f, %ecx
+    shrq $7, %rax
+    jz {l2}
+    orl $0x80, %ecx
+    movb %cl, (%r8)
+    incq %r8
+    jmp {l1}
+{l2}:
+    movb %cl, (%r8)
+    leaq 1(%r8), %rdi
+    xorl %eax, %eax
+    ret
+{l3}:
+    movq $-28, %rax  # ENOSPC
+    ret
+
+.lotus_rt_pb_put_le:
+    leaq (%rdi,%rcx), %r8
+    cmpq %rsi, %r8
+    ja {l5}
+{l4}:
+    movb %al, (%rdi)
+    shrq $8, %rax
+    incq %rdi
+    decq %rcx
+    jnz {l4}
+    xorl %eax, %eax
+    ret
+{l5}:
+    movq $-28, %rax  # ENOSPC
+    ret
+
+.lotus_rt_pb_put_len:
+    movq %rax, %r10
+    call .lotus_rt_pb_put_varint
+    testq %rax, %rax
+    jnz {l7}
+    movq %rsi, %r8
+    subq %rdi, %r8
+    cmpq %r8, %r10
+    ja {l6}
+    movq %r10, %rcx
+    pushq %rsi
+    movq %r9, %rsi
+    rep movsb
+    popq %rsi
+    ret
+{l6}:
+    movq $-28, %rax  # ENOSPC
+{l7}:
+    ret
+
+.lotus_rt_pb_get_varint:
+    xorl %edx, %edx
+    xorl %ecx, %ecx
+{l8}:
+    cmpq %rsi, %rdi
+    jae {l9}
+    cmpl $63, %ecx
+    ja {l10}  # more than 10 bytes
+    movzbl (%rdi), %eax
+    incq %rdi
+    movl %eax, %r8d
+    andl $0x7f, %r8d
+    shlq %cl, %r8
+    orq %r8, %rdx
+    addl $7, %ecx
+    testb $0x80, %al
+    jnz {l8}
+    xorl %eax, %eax
+    ret
+{l9}:
+    movq $-61, %rax  # ENODATA
+    ret
+{l10}:
+    movq $-74, %rax  # EBADMSG
+    ret
+
+.lotus_rt_pb_get_le:
+    leaq (%rdi,%rcx), %r8
+    cmpq %rsi, %r8
+    ja {l12}
+    xorl %edx, %edx
+{l11}:
+    decq %r8
+    shlq $8, %rdx
+    movzbl (%r8), %eax
+    orq %rax, %rdx
+    cmpq %rdi, %r8
+    ja {l11}
+    addq %rcx, %rdi
+    xorl %eax, %eax
+    ret
+{l12}:
+    movq $-61, %rax  # ENODATA
+    ret
+
+.lotus_rt_pb_get_len:
+    call .lotus_rt_pb_get_varint
+    testq %rax, %rax
+    jnz {l13}
+    movq %rsi, %r8
+    subq %rdi, %r8
+    cmpq %r8, %rdx
+    ja {l14}
+{l13}:
+    ret
+{l14}:
+    movq $-61, %rax  # ENODATA
+    ret
+
+.lotus_rt_pb_skip:
+    testq %rdx, %rdx
+    jz .lotus_rt_pb_get_varint
+    movl $8, %ecx
+    cmpq $1, %rdx
+    je {l15}
+    movl $4, %ecx
+    cmpq $5, %rdx
+    je {l15}
+    cmpq $2, %rdx
+    jne {l18}  # groups and unknown wire types
+    call .lotus_rt_pb_get_len
+    testq %rax, %rax
+    jnz {l16}
+    addq %rdx, %rdi
+    ret
+{l15}:
+    leaq (%rdi,%rcx), %r8
+    cmpq %rsi, %r8
+    ja {l17}
+    movq %r8, %rdi
+    xorl %eax, %eax
+{l16}:
+    ret
+{l17}:
+    movq $-61, %rax  # ENODATA
+    ret
+{l18}:
+    movq $-74, %rax  # EBADMSG
+    ret
+`)
+}
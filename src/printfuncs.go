@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"unicode"
 )
 
 // PrintFunction represents a print function that can be called in Lotus code
@@ -38,10 +39,15 @@ func generatePrintfCode(cg *CodeGenerator, args []ASTNode) {
 
 	cg.textSection.WriteString("    # Printf\n")
 
-	// Format-aware path for literal strings with %%, %d, %s, %b, %o, %x, %X, %c, %q, %v
+	if cg.floatValue(args[0]) {
+		emitPrintFloat(cg, args[0], floatPrecision, true)
+		return
+	}
+
+	// Format-aware path for literal strings with %%, %d, %s, %b, %o, %x, %X, %c, %q, %v, %f
 	if lit, ok := args[0].(*StringLiteral); ok {
 		fmtStr := lit.Value
-		textParts, placeholders := parsePlaceholders(fmtStr)
+		textParts, placeholders, precisions := parsePlaceholders(fmtStr)
 		if len(placeholders) == len(args)-1 {
 			argIdx := 1
 			for i, text := range textParts {
@@ -69,6 +75,8 @@ func generatePrintfCode(cg *CodeGenerator, args []ASTNode) {
 						emitPrintStringQuoted(cg, args[argIdx])
 					case 'v':
 						emitPrintValue(cg, args[argIdx])
+					case 'f':
+						emitPrintFloat(cg, args[argIdx], precisions[i], false)
 					}
 					argIdx++
 				}
@@ -81,6 +89,12 @@ func generatePrintfCode(cg *CodeGenerator, args []ASTNode) {
 		return
 	}
 
+	// An unwrapped value prints as it would have before it was nullable
+	if u, ok := args[0].(*Unwrap); ok {
+		emitPrintValue(cg, u)
+		return
+	}
+
 	// Handle identifier (variable reference)
 	if id, ok := args[0].(*Identifier); ok {
 		if v, exists := cg.variables[id.Name]; exists {
@@ -94,7 +108,7 @@ func generatePrintfCode(cg *CodeGenerator, args []ASTNode) {
 				}
 				cg.textSection.WriteString(fmt.Sprintf("    movq $%d, %%rdx\n", length))
 				cg.textSection.WriteString("    movq $1, %rdi\n")
-				cg.textSection.WriteString("    movq $1, %rax\n")
+				cg.asm().LoadSyscall("write")
 				cg.textSection.WriteString("    syscall\n")
 			} else if isIntegerType(v.Type) {
 				// Print integer variable - convert to string first
@@ -103,6 +117,9 @@ func generatePrintfCode(cg *CodeGenerator, args []ASTNode) {
 				// For other types, generate a comment
 				cg.textSection.WriteString(fmt.Sprintf("    # Printf with %v variable (not yet supported)\n", v.Type))
 			}
+		} else if c, exists := cg.constants[id.Name]; exists && c.Type == TokenTypeString {
+			cg.textSection.WriteString("    # Printf with string constant\n")
+			emitPrintString(cg, id)
 		}
 	}
 }
@@ -226,7 +243,7 @@ func emitPrintInteger(cg *CodeGenerator, v Variable) {
 	cg.textSection.WriteString("    addq %r8, %rcx\n")  // rcx = total length (minus + digits)
 	cg.textSection.WriteString("    movq %rcx, %rdx\n") // length
 	cg.textSection.WriteString("    movq $1, %rdi\n")   // stdout fd
-	cg.textSection.WriteString("    movq $1, %rax\n")   // write syscall
+	cg.asm().LoadSyscall("write")
 	cg.textSection.WriteString("    syscall\n")
 }
 
@@ -243,7 +260,7 @@ func generatePrintlnCode(cg *CodeGenerator, args []ASTNode) {
 	cg.textSection.WriteString(fmt.Sprintf("    leaq %s(%%rip), %%rsi\n", label))
 	cg.textSection.WriteString("    movq $1, %rdx\n")
 	cg.textSection.WriteString("    movq $1, %rdi\n")
-	cg.textSection.WriteString("    movq $1, %rax\n")
+	cg.asm().LoadSyscall("write")
 	cg.textSection.WriteString("    syscall\n")
 }
 
@@ -279,7 +296,7 @@ func generateFatalfCode(cg *CodeGenerator, args []ASTNode) {
 	generatePrintfCode(cg, args)
 	// Exit with code 1
 	cg.textSection.WriteString("    # Fatalf - exit with code 1\n")
-	cg.textSection.WriteString("    movq $60, %rax\n")
+	cg.asm().LoadSyscall("exit")
 	cg.textSection.WriteString("    movq $1, %rdi\n")
 	cg.textSection.WriteString("    syscall\n")
 }
@@ -290,7 +307,7 @@ func generateFatallnCode(cg *CodeGenerator, args []ASTNode) {
 	generatePrintlnCode(cg, args)
 	// Exit with code 1
 	cg.textSection.WriteString("    # Fatalln - exit with code 1\n")
-	cg.textSection.WriteString("    movq $60, %rax\n")
+	cg.asm().LoadSyscall("exit")
 	cg.textSection.WriteString("    movq $1, %rdi\n")
 	cg.textSection.WriteString("    syscall\n")
 }
@@ -359,15 +376,17 @@ func emitStringLiteral(cg *CodeGenerator, s string) (string, int) {
 
 // emitWriteLiteral emits a write syscall for a labeled string
 func emitWriteLiteral(cg *CodeGenerator, label string, length int) {
-	cg.textSection.WriteString(fmt.Sprintf("    leaq %s(%%rip), %%rsi\n", label))
-	cg.textSection.WriteString(fmt.Sprintf("    movq $%d, %%rdx\n", length))
-	cg.textSection.WriteString("    movq $1, %rdi\n")
-	cg.textSection.WriteString("    movq $1, %rax\n")
-	cg.textSection.WriteString("    syscall\n")
+	asm := cg.asm()
+	asm.LeaLabel(label, "rsi")
+	asm.MovImmReg(length, "rdx")
+	asm.MovImmReg(1, "rdi")
+	asm.MovImmReg(1, "rax")
+	asm.Syscall()
 }
 
 // emitPrintIntBase prints an integer with the given base; if asChar is true, emit a single byte
 func emitPrintIntBase(cg *CodeGenerator, expr ASTNode, base int, uppercase bool, asChar bool) {
+	lbl1 := cg.getLabel("emit_print_int_base")
 	cg.generateExpressionToReg(expr, "rax")
 
 	if asChar {
@@ -393,10 +412,10 @@ func emitPrintIntBase(cg *CodeGenerator, expr ASTNode, base int, uppercase bool,
 	cg.textSection.WriteString("    movq %rax, %rbx\n")
 	cg.textSection.WriteString("    movq $0, %r9\n")
 	cg.textSection.WriteString("    cmpq $0, %rbx\n")
-	cg.textSection.WriteString("    jge 1f\n")
+	cg.textSection.WriteString(fmt.Sprintf("    jge %s\n", lbl1))
 	cg.textSection.WriteString("    negq %rbx\n")
 	cg.textSection.WriteString("    movq $1, %r9\n")
-	cg.textSection.WriteString("1:\n")
+	cg.textSection.WriteString(fmt.Sprintf("%s:\n", lbl1))
 	cg.textSection.WriteString(fmt.Sprintf("    leaq %s(%%rip), %%rsi\n", bufLabel))
 	cg.textSection.WriteString("    addq $31, %rsi\n")
 	cg.textSection.WriteString("    movb $0, (%rsi)\n")
@@ -433,7 +452,7 @@ func emitPrintIntBase(cg *CodeGenerator, expr ASTNode, base int, uppercase bool,
 	cg.textSection.WriteString(fmt.Sprintf("%s:\n", endLabel))
 	cg.textSection.WriteString("    movq %rcx, %rdx\n")
 	cg.textSection.WriteString("    movq $1, %rdi\n")
-	cg.textSection.WriteString("    movq $1, %rax\n")
+	cg.asm().LoadSyscall("write")
 	cg.textSection.WriteString("    syscall\n")
 }
 
@@ -465,33 +484,76 @@ func emitPrintString(cg *CodeGenerator, expr ASTNode) {
 				cg.textSection.WriteString(fmt.Sprintf("%s:\n", lEnd))
 			}
 			cg.textSection.WriteString("    movq $1, %rdi\n")
-			cg.textSection.WriteString("    movq $1, %rax\n")
+			cg.asm().LoadSyscall("write")
 			cg.textSection.WriteString("    syscall\n")
+		} else if c, ok := cg.constants[v.Name]; ok && c.Type == TokenTypeString {
+			emitWriteLiteral(cg, ".const_"+v.Name, cg.stringLengths[v.Name])
 		}
 	case *FunctionCall:
 		// Evaluate the function call to get result in rax
 		cg.generateFunctionCall(v)
-		// Result is in rax; treat it as a string pointer and compute length
 		cg.textSection.WriteString("    # print function result string\n")
-		cg.textSection.WriteString("    movq %rax, %rsi\n")
-		lLoop := cg.getLabel("slen_loopc")
-		lEnd := cg.getLabel("slen_endc")
-		cg.textSection.WriteString("    movq %rsi, %rbx\n")
-		cg.textSection.WriteString("    movq $0, %rdx\n")
-		cg.textSection.WriteString(fmt.Sprintf("%s:\n", lLoop))
-		cg.textSection.WriteString("    movzbq (%rbx), %rax\n")
-		cg.textSection.WriteString("    testq %rax, %rax\n")
-		cg.textSection.WriteString(fmt.Sprintf("    je %s\n", lEnd))
-		cg.textSection.WriteString("    inc %rdx\n")
-		cg.textSection.WriteString("    inc %rbx\n")
-		cg.textSection.WriteString(fmt.Sprintf("    jmp %s\n", lLoop))
-		cg.textSection.WriteString(fmt.Sprintf("%s:\n", lEnd))
-		cg.textSection.WriteString("    movq $1, %rdi\n")
-		cg.textSection.WriteString("    movq $1, %rax\n")
-		cg.textSection.WriteString("    syscall\n")
+		emitWriteStringInRax(cg)
+	case *Unwrap:
+		// Stops the program first if the value is null
+		cg.generateUnwrap(v, "rax")
+		cg.textSection.WriteString("    # print unwrapped string\n")
+		emitWriteStringInRax(cg)
 	default:
-		// Unsupported expression kind; no-op
+		if cg.isStringExpr(expr) {
+			cg.generateExpressionToReg(expr, "rax")
+			emitWriteStringInRax(cg)
+			return
+		}
+		reportUnprintable(cg, expr)
+	}
+}
+
+// emitWriteStringInRax writes the NUL-terminated string %rax points to
+func emitWriteStringInRax(cg *CodeGenerator) {
+	cg.textSection.WriteString("    movq %rax, %rsi\n")
+	lLoop := cg.getLabel("slen_loopc")
+	lEnd := cg.getLabel("slen_endc")
+	cg.textSection.WriteString("    movq %rsi, %rbx\n")
+	cg.textSection.WriteString("    movq $0, %rdx\n")
+	cg.textSection.WriteString(fmt.Sprintf("%s:\n", lLoop))
+	cg.textSection.WriteString("    movzbq (%rbx), %rax\n")
+	cg.textSection.WriteString("    testq %rax, %rax\n")
+	cg.textSection.WriteString(fmt.Sprintf("    je %s\n", lEnd))
+	cg.textSection.WriteString("    inc %rdx\n")
+	cg.textSection.WriteString("    inc %rbx\n")
+	cg.textSection.WriteString(fmt.Sprintf("    jmp %s\n", lLoop))
+	cg.textSection.WriteString(fmt.Sprintf("%s:\n", lEnd))
+	cg.textSection.WriteString("    movq $1, %rdi\n")
+	cg.asm().LoadSyscall("write")
+	cg.textSection.WriteString("    syscall\n")
+}
+
+// reportUnprintable reports a value printed as a string that the print
+// functions cannot render, rather than printing nothing for it
+func reportUnprintable(cg *CodeGenerator, expr ASTNode) {
+	loc := exprStart(expr).Loc()
+	if loc.Line == 0 {
+		loc = cg.builtinLoc // Literals carry no position
 	}
+	cg.diagnostics.AddErrorWithCode(string(ErrTypeMismatch), CategorySemantic,
+		"cannot print this expression as a string; store it in a string variable first",
+		cg.diagnostics.FilePath, loc.Line, loc.Column, cg.diagnostics.getSourceLine(cg.diagnostics.FilePath, loc.Line))
+}
+
+// unwrapsString reports whether u unwraps a string. The stdlib functions
+// that can return null all return strings.
+func (cg *CodeGenerator) unwrapsString(u *Unwrap) bool {
+	switch e := u.Value.(type) {
+	case *Unwrap:
+		return cg.unwrapsString(e)
+	case *FunctionCall:
+		if fn, ok := UserDefinedFunctions[e.Name]; ok {
+			return fn.ReturnType == TokenTypeString
+		}
+		return true
+	}
+	return cg.isStringExpr(u.Value)
 }
 
 // emitPrintStringQuoted prints a string with surrounding quotes
@@ -524,32 +586,50 @@ func emitPrintStringQuoted(cg *CodeGenerator, expr ASTNode) {
 				cg.textSection.WriteString(fmt.Sprintf("%s:\n", lEnd))
 			}
 			cg.textSection.WriteString("    movq $1, %rdi\n")
-			cg.textSection.WriteString("    movq $1, %rax\n")
+			cg.asm().LoadSyscall("write")
 			cg.textSection.WriteString("    syscall\n")
 			// Trailing quote
 			rq, rqlen := emitStringLiteral(cg, "\"")
 			emitWriteLiteral(cg, rq, rqlen)
 		}
+	case *FunctionCall, *Unwrap:
+		lq, lqlen := emitStringLiteral(cg, "\"")
+		emitWriteLiteral(cg, lq, lqlen)
+		emitPrintString(cg, v)
+		rq, rqlen := emitStringLiteral(cg, "\"")
+		emitWriteLiteral(cg, rq, rqlen)
 	default:
-		// Unsupported expression kind; no-op
+		reportUnprintable(cg, expr)
 	}
 }
 
-// emitPrintValue chooses int or string rendering for %v
+// emitPrintValue chooses float, int or string rendering for %v
 func emitPrintValue(cg *CodeGenerator, expr ASTNode) {
-	switch expr.(type) {
+	if cg.floatValue(expr) {
+		emitPrintFloat(cg, expr, floatPrecision, true)
+		return
+	}
+	switch e := expr.(type) {
 	case *StringLiteral, *Identifier:
 		emitPrintString(cg, expr)
+	case *Unwrap:
+		if cg.unwrapsString(e) {
+			emitPrintString(cg, expr)
+		} else {
+			emitPrintIntBase(cg, expr, 10, false, false)
+		}
 	default:
 		emitPrintIntBase(cg, expr, 10, false, false)
 	}
 }
 
 // parsePlaceholders splits a format string into text parts and placeholder runes
-// Supports %%, %d, %s, %b, %o, %x, %X, %c, %q, %v
-func parsePlaceholders(s string) ([]string, []rune) {
+// Supports %%, %d, %s, %b, %o, %x, %X, %c, %q, %v, and %f and %.Nf, whose
+// number of decimals is returned for each placeholder
+func parsePlaceholders(s string) ([]string, []rune, []int) {
 	var texts []string
 	var placeholders []rune
+	var precisions []int
 	var sb strings.Builder
 	runes := []rune(s)
 	for i := 0; i < len(runes); i++ {
@@ -561,17 +641,33 @@ func parsePlaceholders(s string) ([]string, []rune) {
 					i++
 					continue
 				}
-				if strings.ContainsRune("dsboxXcvq", next) {
+				if strings.ContainsRune("dsboxXcvqf", next) {
 					texts = append(texts, sb.String())
 					sb.Reset()
 					placeholders = append(placeholders, next)
+					precisions = append(precisions, floatPrecision)
 					i++
 					continue
 				}
+				// %.Nf
+				if j := i + 2; next == '.' && j < len(runes) && unicode.IsDigit(runes[j]) {
+					prec := 0
+					for ; j < len(runes) && unicode.IsDigit(runes[j]); j++ {
+						prec = min(prec*10+int(runes[j]-'0'), floatMaxPrecision)
+					}
+					if j < len(runes) && runes[j] == 'f' {
+						texts = append(texts, sb.String())
+						sb.Reset()
+						placeholders = append(placeholders, 'f')
+						precisions = append(precisions, prec)
+						i = j
+						continue
+					}
+				}
 			}
 		}
 		sb.WriteRune(runes[i])
 	}
 	texts = append(texts, sb.String())
-	return texts, placeholders
+	return texts, placeholders, precisions
 }
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// profile.go - Function profiling (-instrument-functions)
+// Each function of an instrumented program calls .lotus_prof_enter after its
+// prologue and .lotus_prof_exit before its epilogue. Enter counts the call,
+// and exit adds the time stamp counter cycles since the outermost active call
+// of the function began to its total. A function's cycles so include those
+// of everything it calls, and a recursive one's are not counted twice. A self
+// tail call continues the call it is in. When the program exits, after any
+// os.atexit handlers, the table is written to lotus.prof in the working
+// directory, and
+//
+//	lotus profile report [-n count] [lotus.prof]
+//
+// prints it, hottest function first. A function left through os.exit has its
+// call counted but not its cycles.
+
+// ProfileFile is the file an instrumented program writes its table to
+const ProfileFile = "lotus.prof"
+
+// profileMagic starts a profile, followed by the number of records
+const profileMagic = "LOTUSPRF"
+
+const (
+	profileRecordSize = 80 // calls, cycles, depth, start, then the NUL-padded name
+	profileNameSize   = profileRecordSize - 32
+)
+
+// profileSyscalls are the system calls writing the profile makes
+var profileSyscalls = []string{"open", "write", "close"}
+
+func init() {
+	Subcommands["profile"] = &Subcommand{
+		Name:    "profile",
+		Summary: "print the profile an -instrument-functions build wrote (report [-n count] [file])",
+		Args:    []string{"report"},
+		Run:     runProfile,
+	}
+}
+
+// instrumentSupported reports why target cannot write a profile, or "" if it can
+func instrumentSupported(target *Target) string {
+	for _, name := range profileSyscalls {
+		if _, ok := target.Syscall(name); !ok {
+			return fmt.Sprintf("-instrument-functions needs the %s system call, which %s does not have", name, target.Triple)
+		}
+	}
+	return ""
+}
+
+// profileFunction adds a record for a function and returns its index
+func (cg *CodeGenerator) profileFunction(name string) int {
+	if cg.profiledFunctions == nil {
+		cg.useAtexit() // The table is written on the way out
+	}
+	cg.profiledFunctions = append(cg.profiledFunctions, name)
+	return len(cg.profiledFunctions) - 1
+}
+
+// profileCall returns a call to .lotus_prof_enter or .lotus_prof_exit for
+// record index
+func (cg *CodeGenerator) profileCall(index int, routine string) string {
+	return fmt.Sprintf("    # profile %s %s\n    leaq .lotus_prof_table+%d(%%rip), %%r11\n    call .lotus_prof_%s\n",
+		routine, cg.profiledFunctions[index], 16+index*profileRecordSize, routine)
+}
+
+// profileData returns the table the program fills in and writes out
+func (cg *CodeGenerator) profileData() string {
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_prof_table:\n")
+	fmt.Fprintf(&b, "    .ascii \"%s\"\n    .quad %d\n", profileMagic, len(cg.profiledFunctions))
+	for _, name := range cg.profiledFunctions {
+		if len(name) > profileNameSize-1 {
+			name = name[:profileNameSize-1]
+		}
+		fmt.Fprintf(&b, "    .quad 0, 0, 0, 0\n    .ascii \"%s\"\n    .zero %d\n", escapeAssemblyString(name), profileNameSize-len(name))
+	}
+	fmt.Fprintf(&b, ".lotus_prof_path:\n    .asciz \"%s\"\n", ProfileFile)
+	return b.String()
+}
+
+// profileRuntime returns the enter and exit routines, which take the record
+// in %r11 and preserve every other register, and .lotus_prof_dump
+func (cg *CodeGenerator) profileRuntime() string {
+	openNr, _ := cg.target.Syscall("open")
+	writeNr, _ := cg.target.Syscall("write")
+	closeNr, _ := cg.target.Syscall("close")
+	return cg.localLabels("lotus_rt_profile", fmt.Sprintf(`
+# ---- function profiling ----
+.lotus_prof_enter:
+    incq (%%r11)
+    incq 16(%%r11)
+    cmpq $1, 16(%%r11)
+    jne {l1}
+    pushq %%rax
+    pushq %%rdx
+    rdtsc
+    shlq $32, %%rdx
+    orq %%rdx, %%rax
+    movq %%rax, 24(%%r11)
+    popq %%rdx
+    popq %%rax
+{l1}:
+    ret
+.lotus_prof_exit:
+    decq 16(%%r11)
+    jnz {l2}
+    pushq %%rax
+    pushq %%rdx
+    rdtsc
+    shlq $32, %%rdx
+    orq %%rdx, %%rax
+    subq 24(%%r11), %%rax
+    addq %%rax, 8(%%r11)
+    popq %%rdx
+    popq %%rax
+{l2}:
+    ret
+
+# Write the table to lotus.prof. Clobbers the syscall registers.
+.lotus_prof_dump:
+    movq $%d, %%rax  # syscall: open
+    leaq .lotus_prof_path(%%rip), %%rdi
+    movq $577, %%rsi  # O_WRONLY|O_CREAT|O_TRUNC
+    movq $420, %%rdx  # 0644
+    syscall
+    testq %%rax, %%rax
+    js {l3}
+    pushq %%rax
+    movq %%rax, %%rdi
+    movq $%d, %%rax  # syscall: write
+    leaq .lotus_prof_table(%%rip), %%rsi
+    movq $%d, %%rdx
+    syscall
+    popq %%rdi
+    movq $%d, %%rax  # syscall: close
+    syscall
+{l3}:
+    ret
+`, openNr, writeNr, 16+len(cg.profiledFunctions)*profileRecordSize, closeNr))
+}
+
+// ProfileRecord is the count and time of one function in a profile
+type ProfileRecord struct {
+	Name   string
+	Calls  uint64
+	Cycles uint64 // Including the functions it called
+}
+
+// ReadProfile reads the table an instrumented program wrote
+func ReadProfile(path string) ([]ProfileRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 16 || string(data[:8]) != profileMagic {
+		return nil, fmt.Errorf("%s is not a Lotus profile", path)
+	}
+	n := binary.LittleEndian.Uint64(data[8:])
+	if uint64(len(data)-16)/profileRecordSize < n {
+		return nil, fmt.Errorf("profile %s is truncated", path)
+	}
+	records := make([]ProfileRecord, n)
+	for i := range records {
+		rec := data[16+i*profileRecordSize:]
+		name, _, _ := strings.Cut(string(rec[32:profileRecordSize]), "\x00")
+		records[i] = ProfileRecord{
+			Name:   name,
+			Calls:  binary.LittleEndian.Uint64(rec),
+			Cycles: binary.LittleEndian.Uint64(rec[8:]),
+		}
+	}
+	return records, nil
+}
+
+// PrintProfileReport writes up to limit of the functions that were called,
+// most cycles first, or all of them when limit is 0
+func PrintProfileReport(w io.Writer, records []ProfileRecord, limit int) {
+	var called []ProfileRecord
+	for _, r := range records {
+		if r.Calls > 0 {
+			called = append(called, r)
+		}
+	}
+	sort.SliceStable(called, func(i, j int) bool { return called[i].Cycles > called[j].Cycles })
+	if limit > 0 && len(called) > limit {
+		called = called[:limit]
+	}
+	width := len("Function")
+	for _, r := range called {
+		width = max(width, len(r.Name))
+	}
+
+	fmt.Fprintf(w, "=== Function Profile (cycles include callees) ===\n")
+	fmt.Fprintf(w, "  %-*s  %10s  %15s  %12s\n", width, "Function", "Calls", "Cycles", "Cycles/call")
+	if len(called) == 0 {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+	for _, r := range called {
+		fmt.Fprintf(w, "  %-*s  %10d  %15d  %12d\n", width, r.Name, r.Calls, r.Cycles, r.Cycles/r.Calls)
+	}
+}
+
+func runProfile(args []string) int {
+	if len(args) == 0 || args[0] != "report" {
+		fmt.Fprintln(os.Stderr, "Usage: lotus profile report [-n count] [file]")
+		return 2
+	}
+	fs := flag.NewFlagSet("lotus profile report", flag.ContinueOnError)
+	limit := fs.Int("n", 20, "show the `count` hottest functions, 0 for all")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: lotus profile report [-n count] [file]")
+		return 2
+	}
+
+	path := ProfileFile
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	records, err := ReadProfile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	PrintProfileReport(os.Stdout, records, *limit)
+	return 0
+}
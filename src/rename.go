@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rename.go - Renaming functions and variables (`lotus rename`)
+// Finds the references to a name with the classifier of semtokens.go and
+// rewrites those identifiers alone, so everything else in each file, its
+// layout and comments included, stays as it was:
+//
+//	lotus rename twice double             # the project in this directory
+//	lotus rename -I lib twice double main.lts
+//
+// Functions and constants share one namespace across the module graph
+// (modules.go), so renaming one rewrites its declaration and its uses in the
+// file and in every source module the file reaches. A top-level variable
+// belongs to its own file. The language server (lsp.go) also renames a local
+// or parameter at a position, within its scope. Stdlib functions, modules
+// and types are not renamed.
+//
+// A rename is refused when the new name is not an identifier, is already
+// declared at the top level, or would change what any name refers to, as
+// when a local of the new name would hide a renamed function; the renamed
+// files are classified again and compared with the originals.
+
+func init() {
+	Subcommands["rename"] = &Subcommand{
+		Name:    "rename",
+		Summary: "rename a function, constant or top-level variable ([flags] old new [dir | file.lts])",
+		Run:     runRename,
+	}
+}
+
+// renameFile is one file and the identifiers a rename rewrites in it
+type renameFile struct {
+	Path   string
+	Source string
+	Names  []Token // In source order
+}
+
+// renameSet is the files a rename looks through: the roots and the source
+// modules they reach
+type renameSet struct {
+	res     *moduleResolver
+	paths   []string // Roots first
+	sources map[string]string
+	tokens  map[string][]semanticToken
+	own     map[string]*fileSymbols // Names each file declares
+	visible map[string]*fileSymbols // Names each file can refer to
+}
+
+// runRename implements `lotus rename [flags] old new [dir | file.lts]`
+func runRename(args []string) int {
+	opts, rest, err := ParseFlags(args)
+	if err != nil {
+		return 2
+	}
+	if len(rest) < 2 || len(rest) > 3 {
+		fmt.Fprintln(os.Stderr, "Usage: lotus rename [flags] old new [dir | file.lts]")
+		return 2
+	}
+	oldName, newName := rest[0], rest[1]
+	path, vendor := ".", ""
+	if len(rest) == 3 {
+		path = rest[2]
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		manifestPath, err := FindManifest(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		manifest, err := LoadManifest(manifestPath)
+		if err == nil {
+			err = manifest.Apply(opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		path, vendor = manifest.EntryPath(), manifest.VendorPath()
+	}
+
+	rs, err := newRenameSet([]string{path}, newModuleResolver(opts.IncludeDirs, nil))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	files, err := rs.renameTopLevel(oldName, newName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, f := range files {
+		if rel, err := filepath.Rel(vendor, f.Path); vendor != "" && err == nil && !strings.HasPrefix(rel, "..") {
+			fmt.Fprintf(os.Stderr, "Error: '%s' is used in %s, a fetched dependency; rename it there first\n", oldName, f.Path)
+			return 1
+		}
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.Path, []byte(f.apply(newName)), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%s: %d occurrence(s)\n", f.Path, len(f.Names))
+	}
+	return 0
+}
+
+// newRenameSet classifies the files at roots and the modules they reach
+func newRenameSet(roots []string, res *moduleResolver) (*renameSet, error) {
+	rs := &renameSet{
+		res: res, sources: make(map[string]string), tokens: make(map[string][]semanticToken),
+		own: make(map[string]*fileSymbols), visible: make(map[string]*fileSymbols),
+	}
+	var modules []string
+	for _, path := range roots {
+		if err := rs.add(path); err != nil {
+			return nil, err
+		}
+		modules = append(modules, rs.visible[path].files...)
+	}
+	for _, path := range modules {
+		if _, done := rs.sources[path]; !done {
+			if err := rs.add(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rs, nil
+}
+
+// add reads and classifies one file
+func (rs *renameSet) add(path string) error {
+	source, err := rs.res.read(path)
+	if err != nil {
+		return err
+	}
+	rs.paths = append(rs.paths, path)
+	rs.sources[path] = source
+	rs.tokens[path], rs.visible[path] = classifySource(source, path, rs.res)
+	rs.own[path] = collectSymbols(significantTokens(source))
+	return nil
+}
+
+// renameTopLevel renames the function, constant or top-level variable of the
+// first root called oldName
+func (rs *renameSet) renameTopLevel(oldName, newName string) ([]renameFile, error) {
+	for _, path := range rs.paths {
+		own := rs.own[path]
+		switch {
+		case own.functions[oldName]:
+			return rs.rename(path, semanticToken{Tok: Token{Value: oldName}, Type: semFunction, Decl: -1}, newName)
+		case own.constants[oldName]:
+			return rs.rename(path, semanticToken{Tok: Token{Value: oldName}, Type: semVariable, Modifiers: semReadonly, Decl: -1}, newName)
+		}
+	}
+	if root := rs.paths[0]; rs.own[root].variables[oldName] {
+		return rs.rename(root, semanticToken{Tok: Token{Value: oldName}, Type: semVariable, Decl: -1}, newName)
+	}
+	return nil, fmt.Errorf("no function, constant or top-level variable named '%s' in %s or the modules it uses", oldName, rs.paths[0])
+}
+
+// renameAt renames what the identifier at a 0-based line and UTF-16
+// character offset in the file at path names
+func (rs *renameSet) renameAt(path string, line, character int, newName string) ([]renameFile, error) {
+	lines := strings.Split(rs.sources[path], "\n")
+	for _, st := range rs.tokens[path] {
+		if st.Tok.Line-1 != line || line >= len(lines) {
+			continue
+		}
+		start := utf16Column(lines[line], st.Tok.Column)
+		end := utf16Column(lines[line], st.Tok.Column+len([]rune(st.Tok.Value)))
+		if start <= character && character <= end {
+			return rs.rename(path, st, newName)
+		}
+	}
+	return nil, fmt.Errorf("no function or variable to rename here")
+}
+
+// rename renames what target, an identifier in the file at path, names
+func (rs *renameSet) rename(path string, target semanticToken, newName string) ([]renameFile, error) {
+	oldName := target.Tok.Value
+	toks := significantTokens(newName)
+	if len(toks) == 0 || toks[0].Type != TokenIdentifier || toks[0].Value != newName || newName == "type" {
+		return nil, fmt.Errorf("'%s' is not a valid name", newName)
+	}
+	if newName == oldName {
+		return nil, nil
+	}
+
+	// matches reports whether a classified identifier names what target does
+	var matches func(file string, st semanticToken) bool
+	switch {
+	case target.Decl >= 0 && target.Type != semType:
+		matches = func(file string, st semanticToken) bool {
+			return file == path && st.Decl == target.Decl
+		}
+	case target.Type == semFunction && target.Modifiers&semDefaultLibrary == 0,
+		target.Type == semVariable && target.Modifiers&semReadonly != 0:
+		if !rs.declared(oldName) {
+			return nil, fmt.Errorf("'%s' is not declared in %s or the modules it uses", oldName, rs.paths[0])
+		}
+		matches = func(file string, st semanticToken) bool {
+			return st.Decl < 0 && st.Type == target.Type && st.Modifiers&^semDeclaration == target.Modifiers&^semDeclaration
+		}
+	case target.Type == semVariable:
+		matches = func(file string, st semanticToken) bool {
+			return file == path && st.Decl < 0 && st.Type == semVariable && st.Modifiers&semReadonly == 0
+		}
+	default:
+		return nil, fmt.Errorf("'%s' is not a function or variable of this program", oldName)
+	}
+	if target.Decl < 0 {
+		for _, file := range rs.paths {
+			if syms := rs.visible[file]; syms.functions[newName] || syms.constants[newName] || syms.variables[newName] ||
+				syms.types[newName] || syms.modules[newName] != "" || RegisteredPrintFunctions[newName] != nil {
+				return nil, fmt.Errorf("'%s' is already declared", newName)
+			}
+		}
+	}
+
+	var files []renameFile
+	for _, file := range rs.paths {
+		f := renameFile{Path: file, Source: rs.sources[file]}
+		for _, st := range rs.tokens[file] {
+			if st.Tok.Value == oldName && matches(file, st) {
+				f.Names = append(f.Names, st.Tok)
+			}
+		}
+		if len(f.Names) > 0 {
+			files = append(files, f)
+		}
+	}
+	if err := rs.verify(files, newName); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// declared reports whether one of the files declares name at the top level
+func (rs *renameSet) declared(name string) bool {
+	for _, own := range rs.own {
+		if own.functions[name] || own.constants[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// verify classifies the files again with files renamed and reports the first
+// identifier the rename would give another meaning
+func (rs *renameSet) verify(files []renameFile, newName string) error {
+	renamed := make(map[string]string)
+	edited := make(map[string]map[Token]bool)
+	for _, f := range files {
+		renamed[f.Path] = f.apply(newName)
+		edited[f.Path] = make(map[Token]bool)
+		for _, tok := range f.Names {
+			edited[f.Path][tok] = true
+		}
+	}
+	res := &moduleResolver{loader: rs.res.loader, read: func(path string) (string, error) {
+		if text, ok := renamed[path]; ok {
+			return text, nil
+		}
+		return rs.res.read(path)
+	}}
+	for _, path := range rs.paths {
+		source, ok := renamed[path]
+		if !ok {
+			source = rs.sources[path]
+		}
+		after, _ := classifySource(source, path, res)
+		before := rs.tokens[path]
+		for k := range max(len(before), len(after)) {
+			if k < len(before) && k < len(after) {
+				b, a := before[k], after[k]
+				want := b.Tok.Value
+				if edited[path][b.Tok] {
+					want = newName
+				}
+				if a.Tok.Value == want && a.Tok.Line == b.Tok.Line && a.Type == b.Type && a.Modifiers == b.Modifiers && a.Decl == b.Decl {
+					continue
+				}
+			}
+			var tok Token
+			if k < len(before) {
+				tok = before[k].Tok
+			} else {
+				tok = after[k].Tok
+			}
+			return fmt.Errorf("renaming to '%s' would change what '%s' at %s:%d:%d refers to", newName, tok.Value, path, tok.Line, tok.Column)
+		}
+	}
+	return nil
+}
+
+// apply returns the file's source with each of its names replaced by newName
+func (f renameFile) apply(newName string) string {
+	lines := strings.SplitAfter(f.Source, "\n")
+	for i := len(f.Names) - 1; i >= 0; i-- {
+		tok := f.Names[i]
+		line := []rune(lines[tok.Line-1])
+		col := tok.Column - 1
+		lines[tok.Line-1] = string(line[:col]) + newName + string(line[col+len([]rune(tok.Value)):])
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// compileTo compiles testdata/reproducible.lts with flags into a new file in
+// dir, returning its contents
+func compileTo(t *testing.T, dir, name string, flags ...string) []byte {
+	t.Helper()
+	out := filepath.Join(dir, name)
+	opts, _, err := ParseFlags(append(flags, "-q", "-o", out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewCompiler(opts).CompileFile(filepath.Join("testdata", "reproducible.lts")); err != nil {
+		t.Fatalf("%v: %v", flags, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReproducibleBuilds(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "0")
+	for _, tc := range []struct {
+		name  string
+		ext   string // Of the output file
+		flags []string
+	}{
+		{"asm", ".s", []string{"-S"}},
+		{"asm-O2", ".s", []string{"-S", "-O2"}},
+		{"binary", ".bin", nil},
+		{"binary-O2", ".bin", []string{"-O2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.ext == ".bin" {
+				opts, _, err := ParseFlags(nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				target, err := LookupTarget(opts.Target)
+				if err == nil {
+					err = target.CheckToolchain()
+				}
+				if err != nil {
+					t.Skip(err)
+				}
+			}
+			dir := t.TempDir()
+			first := compileTo(t, dir, "first"+tc.ext, tc.flags...)
+			second := compileTo(t, dir, "second"+tc.ext, tc.flags...)
+			if len(first) == 0 {
+				t.Fatal("empty output")
+			}
+			if !bytes.Equal(first, second) {
+				t.Errorf("two builds differ: %d and %d bytes", len(first), len(second))
+			}
+		})
+	}
+}
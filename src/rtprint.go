@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rtprint.go - Runtime helpers that write diagnostics to stderr
+// The checking and tracing runtimes report through these routines, which are
+// appended to the program once when either is enabled. Each one clobbers
+// %rax, %rcx, %rdx, %rsi, %rdi and %r11.
+
+// rtprintData returns the strings the helpers use
+func rtprintData() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, ".lotus_rt_digits:\n    .asciz \"0123456789abcdef\"\n")
+	fmt.Fprintf(&b, ".lotus_rt_minus:\n    .asciz \"-\"\n")
+	return b.String()
+}
+
+// rtprintRuntime returns the helper routines
+func (cg *CodeGenerator) rtprintRuntime() string {
+	writeNr, _ := cg.target.Syscall("write")
+	return cg.localLabels("lotus_rt_print", strings.ReplaceAll(`
+# ---- stderr print helpers ----
+
+# Write the NUL-terminated string at %rsi to stderr
+.lotus_rt_puts:
+    movq %rsi, %rdx
+{l1}:
+    cmpb $0, (%rdx)
+    je {l2}
+    incq %rdx
+    jmp {l1}
+{l2}:
+    subq %rsi, %rdx
+    movq $2, %rdi
+    movq ${write}, %rax
+    syscall
+    ret
+
+# Write %rdi to stderr as a signed decimal
+.lotus_rt_putint:
+    testq %rdi, %rdi
+    jns .lotus_rt_putdec
+    pushq %rdi
+    leaq .lotus_rt_minus(%rip), %rsi
+    call .lotus_rt_puts
+    popq %rdi
+    negq %rdi
+    jmp .lotus_rt_putdec
+
+# Write %rdi to stderr in hex or unsigned decimal
+.lotus_rt_puthex:
+    movq $16, %rcx
+    jmp .lotus_rt_putnum
+.lotus_rt_putdec:
+    movq $10, %rcx
+.lotus_rt_putnum:
+    subq $32, %rsp
+    leaq 32(%rsp), %rsi
+    movq %rdi, %rax
+{l3}:
+    xorq %rdx, %rdx
+    divq %rcx
+    leaq .lotus_rt_digits(%rip), %rdi
+    movb (%rdi,%rdx), %dl
+    decq %rsi
+    movb %dl, (%rsi)
+    testq %rax, %rax
+    jnz {l3}
+    leaq 32(%rsp), %rdx
+    subq %rsi, %rdx
+    movq $2, %rdi
+    movq ${write}, %rax
+    syscall
+    addq $32, %rsp
+    ret
+`, "{write}", fmt.Sprint(writeNr)))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sample.go - Sampling profiler (-profile-sample)
+// At startup the program opens lotus.folded in the working directory and
+// starts an ITIMER_PROF timer, which sends SIGPROF after every millisecond of
+// CPU time, or every timer tick where the kernel's ticks are longer. The
+// handler finds the function the program was in from the interrupted %rip
+// and its callers by following the saved frame pointers, and appends the
+// stack to the file, root first, as one folded line:
+//
+//	main;parse;parse_expr 1
+//
+// flamegraph.pl, inferno and speedscope read the file as it is; they add up
+// repeated stacks. Time in code outside the program's functions, such as
+// top-level statements or the runtimes appended after the program, is shown
+// as [unknown], on top of the function that called it if there is one.
+// Functions are found by address, so the binary needs no symbols, and
+// stripped binaries profile as well.
+
+// SampleFile is the file a sampled program writes its stacks to
+const SampleFile = "lotus.folded"
+
+const (
+	sampleIntervalUsec = 1000 // CPU time between samples
+	sampleMaxFrames    = 64   // Deeper stacks lose their outermost frames
+	sampleMaxName      = 60   // Longer names are cut short
+	sigProf            = 27
+)
+
+// sampleSyscalls are the system calls the sampling profiler makes
+var sampleSyscalls = []string{"open", "write", "rt_sigaction", "rt_sigreturn", "setitimer"}
+
+// sampledFunction is the address range of a function the handler can name
+type sampledFunction struct {
+	start, end string // Labels
+	name       string // Label of the name string
+}
+
+// sampleSupported reports why target cannot be profiled by sampling, or ""
+// if it can
+func sampleSupported(target *Target) string {
+	for _, name := range sampleSyscalls {
+		if _, ok := target.Syscall(name); !ok {
+			return fmt.Sprintf("-profile-sample needs the %s system call, which %s does not have", name, target.Triple)
+		}
+	}
+	return ""
+}
+
+// sampleFunction adds the function starting at label to the address table,
+// returning the label to place after its last instruction
+func (cg *CodeGenerator) sampleFunction(name, label string) string {
+	nameLabel, _ := emitStringLiteral(cg, name)
+	end := cg.getLabel("fn_end")
+	cg.sampledFunctions = append(cg.sampledFunctions, sampledFunction{start: label, end: end, name: nameLabel})
+	return end
+}
+
+// sampleData returns the address table and the handler's state
+func (cg *CodeGenerator) sampleData() string {
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_sample_fd:\n    .quad -1\n")
+	b.WriteString(".lotus_sample_stack_top:\n    .quad 0\n")
+	b.WriteString(".lotus_sample_sigaction:\n")
+	b.WriteString("    .quad .lotus_rt_sample\n")
+	b.WriteString("    .quad 0x14000004  # SA_SIGINFO|SA_RESTART|SA_RESTORER\n")
+	b.WriteString("    .quad .lotus_rt_sample_restorer\n")
+	b.WriteString("    .quad 0\n")
+	fmt.Fprintf(&b, ".lotus_sample_timer:\n    .quad 0, %d, 0, %d  # interval, first\n", sampleIntervalUsec, sampleIntervalUsec)
+	fmt.Fprintf(&b, ".lotus_sample_functions:\n    .quad %d\n", len(cg.sampledFunctions))
+	for _, fn := range cg.sampledFunctions {
+		fmt.Fprintf(&b, "    .quad %s, %s, %s\n", fn.start, fn.end, fn.name)
+	}
+	fmt.Fprintf(&b, ".lotus_sample_frames:\n    .zero %d\n", 8*sampleMaxFrames)
+	fmt.Fprintf(&b, ".lotus_sample_line:\n    .zero %d\n", sampleMaxFrames*(sampleMaxName+1)+8)
+	fmt.Fprintf(&b, ".lotus_sample_path:\n    .asciz \"%s\"\n", SampleFile)
+	b.WriteString(".lotus_sample_unknown:\n    .asciz \"[unknown]\"\n")
+	return b.String()
+}
+
+// sampleSetup returns the startup code that opens the sample file, installs
+// the handler and starts the timer. If the file cannot be opened the program
+// runs unprofiled.
+func (cg *CodeGenerator) sampleSetup() string {
+	openNr, _ := cg.target.Syscall("open")
+	sigactionNr, _ := cg.target.Syscall("rt_sigaction")
+	setitimerNr, _ := cg.target.Syscall("setitimer")
+	done := cg.getLabel("sample_done")
+	var b strings.Builder
+	b.WriteString("    # Sampling profiler: open the sample file, install the handler, start the timer\n")
+	fmt.Fprintf(&b, "    movq %%rbp, .lotus_sample_stack_top(%%rip)\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rax  # syscall: open\n", openNr)
+	b.WriteString("    leaq .lotus_sample_path(%rip), %rdi\n")
+	b.WriteString("    movq $577, %rsi  # O_WRONLY|O_CREAT|O_TRUNC\n")
+	b.WriteString("    movq $420, %rdx  # 0644\n")
+	b.WriteString("    syscall\n")
+	b.WriteString("    testq %rax, %rax\n")
+	fmt.Fprintf(&b, "    js %s\n", done)
+	b.WriteString("    movq %rax, .lotus_sample_fd(%rip)\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rax  # syscall: rt_sigaction\n", sigactionNr)
+	fmt.Fprintf(&b, "    movq $%d, %%rdi  # SIGPROF\n", sigProf)
+	b.WriteString("    leaq .lotus_sample_sigaction(%rip), %rsi\n")
+	b.WriteString("    xorq %rdx, %rdx\n")
+	b.WriteString("    movq $8, %r10\n")
+	b.WriteString("    syscall\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rax  # syscall: setitimer\n", setitimerNr)
+	b.WriteString("    movq $2, %rdi  # ITIMER_PROF\n")
+	b.WriteString("    leaq .lotus_sample_timer(%rip), %rsi\n")
+	b.WriteString("    xorq %rdx, %rdx\n")
+	b.WriteString("    syscall\n")
+	fmt.Fprintf(&b, "%s:\n", done)
+	return b.String()
+}
+
+// sampleRuntime returns the SIGPROF handler, the address lookup it uses and
+// its signal return trampoline. The kernel restores every register when the
+// handler returns, so it uses them freely.
+func (cg *CodeGenerator) sampleRuntime() string {
+	writeNr, _ := cg.target.Syscall("write")
+	sigreturn, _ := cg.target.Syscall("rt_sigreturn")
+	return cg.localLabels("lotus_rt_sample", fmt.Sprintf(`
+# ---- sampling profiler ----
+# SIGPROF handler: %%rdx is the ucontext, whose saved registers start at 40
+.lotus_rt_sample:
+    movq .lotus_sample_fd(%%rip), %%rax
+    testq %%rax, %%rax
+    js {l7}
+    movq 120(%%rdx), %%r8  # interrupted %%rbp
+    movq 160(%%rdx), %%rsi  # interrupted %%rsp
+    movq 168(%%rdx), %%rax  # interrupted %%rip
+    leaq .lotus_sample_frames(%%rip), %%r9
+    xorq %%r10, %%r10
+    call .lotus_sample_lookup
+    testq %%rax, %%rax
+    jnz {l1}
+    # Outside the functions: whoever called it may be on top of the stack
+    leaq .lotus_sample_unknown(%%rip), %%rax
+    movq %%rax, (%%r9)
+    incq %%r10
+    movq (%%rsi), %%rax
+    decq %%rax
+    call .lotus_sample_lookup
+    testq %%rax, %%rax
+    jz {l2}
+{l1}:
+    movq %%rax, (%%r9,%%r10,8)
+    incq %%r10
+{l2}:
+    # Follow the saved frame pointers while they lead up the stack
+    cmpq $%d, %%r10
+    jae {l3}
+    cmpq %%rsi, %%r8
+    jb {l3}
+    movq .lotus_sample_stack_top(%%rip), %%rax
+    subq $16, %%rax
+    cmpq %%rax, %%r8
+    ja {l3}
+    testq $7, %%r8
+    jnz {l3}
+    movq 8(%%r8), %%rax  # return address
+    decq %%rax
+    call .lotus_sample_lookup
+    testq %%rax, %%rax
+    jz {l3}
+    movq %%rax, (%%r9,%%r10,8)
+    incq %%r10
+    leaq 16(%%r8), %%rsi
+    movq (%%r8), %%r8
+    jmp {l2}
+{l3}:
+    # Write the names root first, joined by ;, then the count
+    leaq .lotus_sample_line(%%rip), %%rdi
+{l4}:
+    decq %%r10
+    movq (%%r9,%%r10,8), %%rsi
+    movq $%d, %%rcx
+{l5}:
+    movb (%%rsi), %%al
+    testb %%al, %%al
+    jz {l6}
+    movb %%al, (%%rdi)
+    incq %%rsi
+    incq %%rdi
+    decq %%rcx
+    jnz {l5}
+{l6}:
+    movb $59, (%%rdi)  # ;
+    incq %%rdi
+    testq %%r10, %%r10
+    jnz {l4}
+    movb $32, -1(%%rdi)
+    movb $49, (%%rdi)  # 1
+    movb $10, 1(%%rdi)
+    addq $2, %%rdi
+    leaq .lotus_sample_line(%%rip), %%rsi
+    movq %%rdi, %%rdx
+    subq %%rsi, %%rdx
+    movq .lotus_sample_fd(%%rip), %%rdi
+    movq $%d, %%rax  # syscall: write
+    syscall
+{l7}:
+    ret
+
+# The name of the function containing the address in %%rax, or 0.
+# Clobbers %%rcx, %%r11.
+.lotus_sample_lookup:
+    leaq .lotus_sample_functions(%%rip), %%r11
+    movq (%%r11), %%rcx
+    addq $8, %%r11
+{l8}:
+    testq %%rcx, %%rcx
+    jz {l10}
+    cmpq (%%r11), %%rax
+    jb {l9}
+    cmpq 8(%%r11), %%rax
+    jae {l9}
+    movq 16(%%r11), %%rax
+    ret
+{l9}:
+    addq $24, %%r11
+    decq %%rcx
+    jmp {l8}
+{l10}:
+    xorq %%rax, %%rax
+    ret
+
+.lotus_rt_sample_restorer:
+    movq $%d, %%rax  # rt_sigreturn
+    syscall
+`, sampleMaxFrames, sampleMaxName, writeNr, sigreturn))
+}
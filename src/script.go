@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// script.go - `lotus script` and #! scripts
+// Compiles a program into the user's cache directory and runs it, so Lotus
+// files work as scripts:
+//
+//	#!/usr/bin/env lotus
+//	fn int main() { println("hello"); ret 0; }
+//
+// The kernel runs such a file as `lotus file.lts args...`, and a file given
+// to lotus that way, with no flags before it, runs as a script instead of
+// being compiled to a.out. The cached binary is reused until the build
+// metadata it carries shows that the compiler, the script or a module it
+// uses has changed; different flags get a cache entry of their own.
+
+func init() {
+	Subcommands["script"] = &Subcommand{
+		Name:    "script",
+		Summary: "compile a file into the cache and run it ([flags] file.lts [args])",
+		Run:     runScript,
+	}
+}
+
+// runScript implements `lotus script [flags] file.lts [args]`. On success it
+// does not return: the program replaces the compiler, so it gets the
+// terminal, signals and exit status to itself.
+func runScript(args []string) int {
+	opts, rest, err := ParseFlags(args)
+	if err != nil {
+		return 2
+	}
+	if len(rest) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lotus script [flags] file.lts [args]")
+		return 2
+	}
+	for _, name := range []string{"o", "S", "emit", "run"} {
+		if opts.IsSet(name) {
+			fmt.Fprintf(os.Stderr, "Error: -%s does not apply to scripts, which are built into the cache and run\n", name)
+			return 2
+		}
+	}
+	// Flag parsing rewrites arguments such as -O2, so the program's own
+	// arguments are taken as they were given
+	scriptArgs := args[len(args)-len(rest)+1:]
+
+	path, err := filepath.Abs(rest[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	binary, err := scriptBinary(path, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if !scriptUpToDate(binary) {
+		opts.OutPath = binary + ".tmp" + fmt.Sprint(os.Getpid())
+		compiler := NewCompiler(opts)
+		if err := compiler.CompileFile(path); err != nil {
+			os.Remove(opts.OutPath)
+			fmt.Fprintf(os.Stderr, "Compilation failed: %v\n", err)
+			return 1
+		}
+		// Scripts started together may build at once; each renames its own
+		// complete binary into place
+		if err := os.Rename(opts.OutPath, binary); err != nil {
+			os.Remove(opts.OutPath)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	argv := append([]string{rest[0]}, scriptArgs...)
+	err = syscall.Exec(binary, argv, os.Environ())
+	fmt.Fprintf(os.Stderr, "Error: failed to execute %s: %v\n", binary, err)
+	return 1
+}
+
+// scriptBinary returns the cache path of the binary built from the script at
+// path with opts, creating the cache directory
+func scriptBinary(path string, opts *CompilerOptions) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cache, "lotus", "scripts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create script cache: %w", err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", path, opts.Target, opts.Linker, strings.Join(metadataFlags(opts), " "))
+	for _, dir := range opts.IncludeDirs {
+		fmt.Fprintf(h, "-I%s\n", dir)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return filepath.Join(dir, name+"-"+hex.EncodeToString(h.Sum(nil))[:16]), nil
+}
+
+// scriptUpToDate reports whether the cached binary was built by this
+// compiler from the sources as they are now
+func scriptUpToDate(binary string) bool {
+	lines, err := ReadMetadata(binary)
+	if err != nil {
+		return false
+	}
+	// A rebuilt compiler may still call itself the same version
+	if self, err := os.Executable(); err == nil {
+		selfInfo, err1 := os.Stat(self)
+		binInfo, err2 := os.Stat(binary)
+		if err1 != nil || err2 != nil || selfInfo.ModTime().After(binInfo.ModTime()) {
+			return false
+		}
+	}
+
+	sources := 0
+	for _, line := range lines {
+		key, value, _ := strings.Cut(line, "=")
+		fields := strings.Fields(value)
+		switch {
+		case key == "compiler" && value != CompilerVersion:
+			return false
+		case key == "source" && len(fields) == 2, key == "module" && len(fields) == 3:
+			sources++
+			if !fileHasHash(fields[len(fields)-2], fields[len(fields)-1]) {
+				return false
+			}
+		}
+	}
+	return sources > 0
+}
+
+// fileHasHash reports whether the file at path hashes to want, written as
+// sha256:<hex> in build metadata
+func fileHasHash(path, want string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return want == fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// isScript reports whether path names a file that starts with a #! line
+func isScript(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return string(head) == "#!"
+}
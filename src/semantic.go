@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -16,6 +17,19 @@ type SemanticAnalyzer struct {
 	filePath    string
 	sourceLines []string
 	currentLine int // Approximate line tracking
+
+	functions     map[string]bool // User-defined functions, collected before analysis
+	signatures    map[string]*FunctionDefinition
+	imports       map[string]string // Import alias -> stdlib module name
+	importedFuncs map[string]bool   // Functions callable without qualification
+	sourceMods    map[string]bool   // Import aliases naming source modules
+	exports       map[string]string // @export symbol -> function name
+	placedCall    *FunctionCall     // Call that is the whole value of the statement being analyzed
+	newtypes      map[string]bool   // Newtype names seen, as declaredType gives them
+	returnType    string            // Declared result of the function being analyzed
+	returnNull    bool              // The function being analyzed may return null
+	guards        [][]func()        // Per scope, null checks to undo when it closes
+	sourceDir     string            // Directory embedded file paths are relative to
 }
 
 // SymbolInfo holds information about a declared symbol
@@ -23,10 +37,13 @@ type SymbolInfo struct {
 	Name         string
 	Kind         SymbolKind
 	TypeName     string
+	Type         TokenType // Underlying type, TokenEOF when unknown (typecheck.go)
 	DeclLine     int
 	IsUsed       bool
 	IsMutable    bool
 	ShadowsOuter bool
+	Nullable     bool // Declared type? (nullable.go)
+	Checked      bool // Nullable but known not to be null here
 }
 
 // SymbolKind represents the kind of symbol
@@ -49,6 +66,14 @@ func NewSemanticAnalyzer(diagnostics *DiagnosticManager, opts *CompilerOptions,
 		filePath:    filePath,
 		sourceLines: strings.Split(source, "\n"),
 		currentLine: 1,
+
+		functions:     make(map[string]bool),
+		signatures:    make(map[string]*FunctionDefinition),
+		imports:       make(map[string]string),
+		importedFuncs: make(map[string]bool),
+		sourceMods:    make(map[string]bool),
+		exports:       make(map[string]string),
+		newtypes:      make(map[string]bool),
 	}
 	// Push global scope
 	sa.pushScope()
@@ -58,6 +83,7 @@ func NewSemanticAnalyzer(diagnostics *DiagnosticManager, opts *CompilerOptions,
 // pushScope creates a new nested scope
 func (sa *SemanticAnalyzer) pushScope() {
 	sa.scopes = append(sa.scopes, make(map[string]*SymbolInfo))
+	sa.guards = append(sa.guards, nil)
 }
 
 // popScope removes the current scope and checks for unused variables
@@ -90,10 +116,14 @@ func (sa *SemanticAnalyzer) popScope() {
 	}
 
 	sa.scopes = sa.scopes[:len(sa.scopes)-1]
+	for _, undo := range sa.guards[len(sa.guards)-1] {
+		undo()
+	}
+	sa.guards = sa.guards[:len(sa.guards)-1]
 }
 
 // declareSymbol adds a symbol to the current scope
-func (sa *SemanticAnalyzer) declareSymbol(name string, kind SymbolKind, typeName string, line int) {
+func (sa *SemanticAnalyzer) declareSymbol(name string, kind SymbolKind, t TokenType, typeName string, line int) {
 	if len(sa.scopes) == 0 {
 		return
 	}
@@ -138,6 +168,7 @@ func (sa *SemanticAnalyzer) declareSymbol(name string, kind SymbolKind, typeName
 		Name:         name,
 		Kind:         kind,
 		TypeName:     typeName,
+		Type:         t,
 		DeclLine:     line,
 		IsUsed:       false,
 		IsMutable:    kind == SymbolVariable,
@@ -198,9 +229,18 @@ func (sa *SemanticAnalyzer) getSourceLine(line int) string {
 
 // Analyze performs semantic analysis on the AST
 func (sa *SemanticAnalyzer) Analyze(statements []ASTNode) {
+	// Functions may be called before their definition appears
+	for _, stmt := range statements {
+		if fn, ok := stmt.(*FunctionDefinition); ok {
+			sa.functions[fn.Name] = true
+			sa.signatures[fn.Name] = fn
+		}
+	}
+
 	for _, stmt := range statements {
 		sa.analyzeNode(stmt)
 	}
+	sa.checkLabels(statements)
 
 	// Pop global scope and check for unused at top level
 	sa.popScope()
@@ -219,6 +259,8 @@ func (sa *SemanticAnalyzer) analyzeNode(node ASTNode) {
 	}
 
 	switch n := node.(type) {
+	case *ImportStatement:
+		sa.analyzeImport(n)
 	case *FunctionDefinition:
 		sa.analyzeFunctionDefinition(n)
 	case *VariableDeclaration:
@@ -232,8 +274,17 @@ func (sa *SemanticAnalyzer) analyzeNode(node ASTNode) {
 	case *BinaryOp:
 		sa.analyzeNode(n.Left)
 		sa.analyzeNode(n.Right)
+		sa.checkOperands(n, n.Left, n.Right)
+		sa.checkFloatOperator(n)
+		sa.checkNotNull(n.Left, "as an operand")
+		sa.checkNotNull(n.Right, "as an operand")
 	case *UnaryOp:
 		sa.analyzeNode(n.Operand)
+		if n.Operator != TokenExclaim {
+			sa.checkNotNull(n.Operand, "as an operand")
+		}
+	case *Unwrap:
+		sa.analyzeNode(n.Value)
 	case *FunctionCall:
 		sa.analyzeFunctionCall(n)
 	case *IfStatement:
@@ -245,20 +296,51 @@ func (sa *SemanticAnalyzer) analyzeNode(node ASTNode) {
 	case *ReturnStatement:
 		if n.Value != nil {
 			sa.analyzeNode(n.Value)
+			sa.checkType(sa.returnType, sa.exprType(n.Value), n.Value, "in return")
+			if !sa.returnNull {
+				sa.checkNotNull(n.Value, "in return from a function without a nullable result")
+			}
 		}
 	case *ArrayLiteral:
 		for _, elem := range n.Elements {
 			sa.analyzeNode(elem)
 		}
+	case *MapLiteral:
+		for i, key := range n.Keys {
+			sa.analyzeNode(key)
+			sa.analyzeNode(n.Values[i])
+		}
 	case *ArrayAccess:
 		sa.analyzeNode(n.Array)
 		sa.analyzeNode(n.Index)
+		sa.checkNotNull(n.Array, "when indexed")
+		sa.checkNotNull(n.Index, "as an index")
 	case *Comparison:
 		sa.analyzeNode(n.Left)
 		sa.analyzeNode(n.Right)
+		sa.checkOperands(n, n.Left, n.Right)
+	case *Conversion:
+		sa.analyzeNode(n.Value)
+	case *Include:
+		sa.loadInclude(n)
+	case *ComptimeBlock:
+		sa.pushScope()
+		for _, stmt := range n.Body {
+			sa.analyzeNode(stmt)
+		}
+		sa.popScope()
 	case *LogicalOp:
 		sa.analyzeNode(n.Left)
+		// The right side runs only when the left has decided nothing
+		ifTrue, ifFalse := sa.nullChecks(n.Left)
+		if n.Operator == TokenOr {
+			ifTrue = ifFalse
+		}
+		undo := markChecked(ifTrue)
 		sa.analyzeNode(n.Right)
+		undo()
+	case *GotoStatement:
+		sa.analyzeNode(n.Target)
 	}
 }
 
@@ -268,18 +350,28 @@ func (sa *SemanticAnalyzer) analyzeFunctionDefinition(fn *FunctionDefinition) {
 	if line == 0 {
 		line = sa.currentLine
 	}
-	sa.declareSymbol(fn.Name, SymbolFunction, TokenTypeName(fn.ReturnType), line)
+	sa.declareSymbol(fn.Name, SymbolFunction, fn.ReturnType, sa.declaredType(fn.ReturnType, fn.ReturnName), line)
+	sa.checkFunctionAttributes(fn)
+	savedReturn, savedNull := sa.returnType, sa.returnNull
+	sa.returnType = sa.declaredType(fn.ReturnType, fn.ReturnName)
+	sa.returnNull = fn.ReturnNullable
+	defer func() { sa.returnType, sa.returnNull = savedReturn, savedNull }()
 
 	// Create new scope for function body
 	sa.pushScope()
 
 	// Declare parameters
 	for _, param := range fn.Parameters {
-		sa.declareSymbol(param.Name, SymbolParameter, TokenTypeName(param.Type), line)
+		t := param.Type
+		if param.Variadic {
+			t = TokenEOF // The trailing arguments, as an array
+		}
+		sa.declareSymbol(param.Name, SymbolParameter, t, sa.declaredType(param.Type, param.TypeName), line)
 		// Parameters are always "used" (passed by caller)
 		if len(sa.scopes) > 0 {
 			if info, ok := sa.scopes[len(sa.scopes)-1][param.Name]; ok {
 				info.IsUsed = true
+				info.Nullable = param.Nullable
 			}
 		}
 	}
@@ -288,6 +380,7 @@ func (sa *SemanticAnalyzer) analyzeFunctionDefinition(fn *FunctionDefinition) {
 	for _, stmt := range fn.Body {
 		sa.analyzeNode(stmt)
 	}
+	sa.checkLabels(fn.Body)
 
 	sa.popScope()
 }
@@ -295,29 +388,44 @@ func (sa *SemanticAnalyzer) analyzeFunctionDefinition(fn *FunctionDefinition) {
 func (sa *SemanticAnalyzer) analyzeVariableDeclaration(decl *VariableDeclaration) {
 	// Analyze initializer first (before declaring the variable)
 	if decl.Value != nil {
+		sa.placedCall, _ = decl.Value.(*FunctionCall)
 		sa.analyzeNode(decl.Value)
 	}
+	declType := sa.declaredType(decl.Type, decl.TypeName)
+	sa.checkType(declType, sa.exprType(decl.Value), decl, fmt.Sprintf("in declaration of '%s'", decl.Name))
+	sa.checkValue(decl.Type, decl.TypeName, decl.Value, fmt.Sprintf("in declaration of '%s'", decl.Name))
+	if !decl.Nullable {
+		sa.checkNotNull(decl.Value, fmt.Sprintf("in declaration of '%s'", decl.Name))
+	}
+	checked := sa.nullableValue(decl.Value) == ""
 
 	// Declare the variable
 	line := decl.Loc().Line
 	if line == 0 {
 		line = sa.currentLine
 	}
-	sa.declareSymbol(decl.Name, SymbolVariable, TokenTypeName(decl.Type), line)
+	sa.declareSymbol(decl.Name, SymbolVariable, decl.Type, declType, line)
+	if info, ok := sa.scopes[len(sa.scopes)-1][decl.Name]; ok && decl.Nullable {
+		info.Nullable, info.Checked = true, checked
+	}
 }
 
 func (sa *SemanticAnalyzer) analyzeConstantDeclaration(decl *ConstantDeclaration) {
+	sa.checkSectionAttribute(decl.Attributes)
 	// Analyze initializer first
 	if decl.Value != nil {
 		sa.analyzeNode(decl.Value)
 	}
+	declType := sa.declaredType(decl.Type, decl.TypeName)
+	sa.checkType(declType, sa.exprType(decl.Value), decl, fmt.Sprintf("in declaration of '%s'", decl.Name))
+	sa.checkValue(decl.Type, decl.TypeName, decl.Value, fmt.Sprintf("in declaration of '%s'", decl.Name))
 
 	// Declare the constant
 	line := decl.Loc().Line
 	if line == 0 {
 		line = sa.currentLine
 	}
-	sa.declareSymbol(decl.Name, SymbolConstant, TokenTypeName(decl.Type), line)
+	sa.declareSymbol(decl.Name, SymbolConstant, decl.Type, declType, line)
 }
 
 func (sa *SemanticAnalyzer) analyzeAssignment(assign *Assignment) {
@@ -328,8 +436,73 @@ func (sa *SemanticAnalyzer) analyzeAssignment(assign *Assignment) {
 
 	// Analyze the value
 	if assign.Value != nil {
+		sa.placedCall, _ = assign.Value.(*FunctionCall)
 		sa.analyzeNode(assign.Value)
 	}
+	if ident, ok := assign.Target.(*Identifier); ok {
+		if info := sa.lookupSymbol(ident.Name); info != nil && info.Kind != SymbolFunction {
+			sa.checkType(info.TypeName, sa.exprType(assign.Value), assign, fmt.Sprintf("in assignment to '%s'", ident.Name))
+			newtype := ""
+			if sa.newtypes[info.TypeName] {
+				newtype = strings.Trim(info.TypeName, "'")
+			}
+			sa.checkValue(info.Type, newtype, assign.Value, fmt.Sprintf("in assignment to '%s'", ident.Name))
+			if info.Nullable {
+				info.Checked = sa.nullableValue(assign.Value) == ""
+				return
+			}
+		}
+	}
+	sa.checkNotNull(assign.Value, "in assignment")
+}
+
+// DeclareFunctions makes the functions defined in statements callable, for
+// declarations that come from other files such as source modules
+func (sa *SemanticAnalyzer) DeclareFunctions(statements []ASTNode) {
+	for _, stmt := range statements {
+		if fn, ok := stmt.(*FunctionDefinition); ok {
+			sa.functions[fn.Name] = true
+			sa.signatures[fn.Name] = fn
+		}
+	}
+}
+
+func (sa *SemanticAnalyzer) analyzeImport(imp *ImportStatement) {
+	if imp.Source != "" {
+		alias := imp.Alias
+		if alias == "" {
+			alias = imp.Module
+		}
+		sa.sourceMods[alias] = true
+		return
+	}
+
+	alias := imp.Alias
+	if alias == "" {
+		alias = imp.Module
+	}
+
+	module, ok := StandardLibrary[imp.Module]
+	if !ok {
+		// The range covers the name inside the quotes
+		loc := Location{Line: imp.ModuleLoc.Line, Column: imp.ModuleLoc.Column + 1}
+		sa.reportUnknownName(ErrModuleNotFound,
+			fmt.Sprintf("module '%s' not found in standard library or module search path", imp.Module),
+			imp.Module, loc, stdlibModuleNames())
+		sa.imports[alias] = "" // Calls through a broken import are not reported again
+		return
+	}
+	sa.imports[alias] = imp.Module
+
+	if len(imp.Items) > 0 && !imp.IsWildcard {
+		for _, item := range imp.Items {
+			sa.importedFuncs[item] = true
+		}
+		return
+	}
+	for name := range module.Functions {
+		sa.importedFuncs[name] = true
+	}
 }
 
 func (sa *SemanticAnalyzer) analyzeFunctionCall(call *FunctionCall) {
@@ -338,6 +511,12 @@ func (sa *SemanticAnalyzer) analyzeFunctionCall(call *FunctionCall) {
 		sa.analyzeNode(arg)
 	}
 
+	sa.checkCallTarget(call)
+	sa.checkStackalloc(call)
+	sa.checkArguments(call)
+	sa.checkCall(call)
+	sa.checkNullArguments(call)
+
 	// Check for deprecated functions
 	if sa.shouldWarn(CategoryDeprecated) {
 		deprecatedFuncs := map[string]string{
@@ -359,30 +538,27 @@ func (sa *SemanticAnalyzer) analyzeFunctionCall(call *FunctionCall) {
 
 func (sa *SemanticAnalyzer) analyzeIfStatement(ifStmt *IfStatement) {
 	sa.analyzeNode(ifStmt.Condition)
+	ifTrue, ifFalse := sa.nullChecks(ifStmt.Condition)
 
-	sa.pushScope()
-	for _, stmt := range ifStmt.ThenBody {
-		sa.analyzeNode(stmt)
-	}
-	sa.popScope()
+	sa.analyzeGuarded(ifStmt.ThenBody, ifTrue)
 
 	if len(ifStmt.ElseBody) > 0 {
-		sa.pushScope()
-		for _, stmt := range ifStmt.ElseBody {
-			sa.analyzeNode(stmt)
-		}
-		sa.popScope()
+		sa.analyzeGuarded(ifStmt.ElseBody, ifFalse)
+	}
+
+	// Past an if that always leaves, its condition was false
+	if alwaysLeaves(ifStmt.ThenBody) {
+		sa.guardRest(ifFalse)
+	} else if alwaysLeaves(ifStmt.ElseBody) {
+		sa.guardRest(ifTrue)
 	}
 }
 
 func (sa *SemanticAnalyzer) analyzeWhileLoop(loop *WhileLoop) {
 	sa.analyzeNode(loop.Condition)
+	ifTrue, _ := sa.nullChecks(loop.Condition)
 
-	sa.pushScope()
-	for _, stmt := range loop.Body {
-		sa.analyzeNode(stmt)
-	}
-	sa.popScope()
+	sa.analyzeGuarded(loop.Body, ifTrue)
 }
 
 func (sa *SemanticAnalyzer) analyzeForLoop(loop *ForLoop) {
@@ -398,13 +574,215 @@ func (sa *SemanticAnalyzer) analyzeForLoop(loop *ForLoop) {
 		sa.analyzeNode(loop.Update)
 	}
 
+	ifTrue, _ := sa.nullChecks(loop.Condition)
+	undo := markChecked(ifTrue)
 	for _, stmt := range loop.Body {
 		sa.analyzeNode(stmt)
 	}
+	undo()
 
 	sa.popScope()
 }
 
+// checkStackalloc reports a mem::stackalloc call that is not the whole value
+// of a declaration or assignment. It moves rsp, which would break any
+// expression holding temporaries on the stack around it.
+func (sa *SemanticAnalyzer) checkStackalloc(call *FunctionCall) {
+	if call == sa.placedCall || !sa.isStackalloc(call) {
+		return
+	}
+	sa.diagnostics.AddErrorWithCode(string(ErrInvalidOperation), CategorySemantic,
+		"mem::stackalloc must be the whole value of a declaration or assignment",
+		sa.filePath, call.NameLoc.Line, call.NameLoc.Column, sa.getSourceLine(call.NameLoc.Line))
+}
+
+// isStackalloc reports whether call resolves to mem::stackalloc
+func (sa *SemanticAnalyzer) isStackalloc(call *FunctionCall) bool {
+	module, fn, ok := strings.Cut(call.Name, "::")
+	if !ok {
+		return call.Name == "stackalloc" && sa.importedFuncs[call.Name] && !sa.functions[call.Name]
+	}
+	if sa.sourceMods[module] {
+		return false
+	}
+	if resolved, isAlias := sa.imports[module]; isAlias {
+		module = resolved
+	}
+	return module == "mem" && fn == "stackalloc"
+}
+
+// checkCallTarget reports calls that resolve to no user, imported, print, or
+// module function, suggesting the closest valid name as a fix-it
+func (sa *SemanticAnalyzer) checkCallTarget(call *FunctionCall) {
+	loc := call.NameLoc
+	if loc.Line == 0 {
+		return // Synthesized call without position information
+	}
+
+	if moduleName, funcName, ok := strings.Cut(call.Name, "::"); ok {
+		if sa.sourceMods[moduleName] {
+			if !sa.functions[funcName] {
+				sa.reportUnknownName(ErrUndefinedFunction,
+					fmt.Sprintf("module '%s' has no function '%s'", moduleName, funcName),
+					funcName, loc, mapKeys(sa.functions))
+			}
+			return
+		}
+		if resolved, isAlias := sa.imports[moduleName]; isAlias {
+			if resolved == "" {
+				return
+			}
+			moduleName = resolved
+		}
+		if _, exists := StandardLibrary[moduleName]; !exists {
+			// The module name starts where the call does
+			start := call.Loc()
+			if start.Line == 0 {
+				return
+			}
+			sa.reportUnknownName(ErrModuleNotFound,
+				fmt.Sprintf("module '%s' not found in standard library", moduleName),
+				moduleName, start, stdlibModuleNames())
+			return
+		}
+		if GetModuleFunction(moduleName, funcName) == nil {
+			sa.reportUnknownName(ErrUndefinedFunction,
+				fmt.Sprintf("module '%s' has no function '%s'", moduleName, funcName),
+				funcName, loc, moduleFunctionNames(moduleName))
+		}
+		return
+	}
+
+	if sa.functions[call.Name] || sa.importedFuncs[call.Name] {
+		return
+	}
+	if _, hint := branchHint(call); hint != 0 {
+		return
+	}
+	if isAssert(call) {
+		sa.checkAssert(call)
+		return
+	}
+	if _, ok := RegisteredPrintFunctions[call.Name]; ok {
+		return
+	}
+
+	candidates := append(mapKeys(sa.functions), mapKeys(sa.importedFuncs)...)
+	for name := range RegisteredPrintFunctions {
+		candidates = append(candidates, name)
+	}
+	diag := sa.unknownNameDiagnostic(ErrUndefinedFunction,
+		fmt.Sprintf("call to undefined function '%s'", call.Name),
+		call.Name, loc, candidates)
+	if module := moduleProviding(call.Name); module != "" {
+		diag.Notes = append(diag.Notes,
+			fmt.Sprintf("'%s' is provided by module '%s'; add `use \"%s\";` or call %s::%s", call.Name, module, module, module, call.Name))
+	}
+	sa.diagnostics.AddDiagnostic(diag)
+}
+
+// reportUnknownName records an error for an unresolved name at loc
+func (sa *SemanticAnalyzer) reportUnknownName(code ErrorCode, message, name string, loc Location, candidates []string) {
+	sa.diagnostics.AddDiagnostic(sa.unknownNameDiagnostic(code, message, name, loc, candidates))
+}
+
+// unknownNameDiagnostic builds the error for an unresolved name, attaching a
+// fix-it that replaces it with the closest candidate when one is near enough
+func (sa *SemanticAnalyzer) unknownNameDiagnostic(code ErrorCode, message, name string, loc Location, candidates []string) Diagnostic {
+	end := loc.Column + len([]rune(name))
+	diag := Diagnostic{
+		Level:     DiagnosticError,
+		Category:  CategorySemantic,
+		Code:      string(code),
+		Message:   message,
+		FilePath:  sa.filePath,
+		Line:      loc.Line,
+		Column:    loc.Column,
+		EndLine:   loc.Line,
+		EndColumn: end,
+	}
+	if match := suggestName(name, candidates); match != "" {
+		diag.Suggestion = fmt.Sprintf("did you mean '%s'?", match)
+		diag.Fixes = []FixIt{{
+			Line:        loc.Line,
+			Column:      loc.Column,
+			EndLine:     loc.Line,
+			EndColumn:   end,
+			Replacement: match,
+		}}
+	}
+	return diag
+}
+
+// suggestName returns the candidate closest to name by edit distance, or ""
+// if none is close enough. Longer names tolerate proportionally more typos.
+func suggestName(name string, candidates []string) string {
+	maxDist := len(name) / 2
+	if maxDist < 1 {
+		maxDist = 1
+	}
+
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+
+	bestMatch := ""
+	bestDist := maxDist + 1
+	for _, candidate := range sorted {
+		if candidate == name {
+			continue
+		}
+		dist := levenshteinDistance(strings.ToLower(name), strings.ToLower(candidate))
+		if dist < bestDist {
+			bestDist = dist
+			bestMatch = candidate
+		}
+	}
+	return bestMatch
+}
+
+// mapKeys returns the keys of a name set
+func mapKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// stdlibModuleNames lists the standard library module names
+func stdlibModuleNames() []string {
+	names := make([]string, 0, len(StandardLibrary))
+	for name := range StandardLibrary {
+		names = append(names, name)
+	}
+	return names
+}
+
+// moduleFunctionNames lists the functions exported by a stdlib module
+func moduleFunctionNames(module string) []string {
+	mod, ok := StandardLibrary[module]
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(mod.Functions))
+	for name := range mod.Functions {
+		names = append(names, name)
+	}
+	return names
+}
+
+// moduleProviding returns the first stdlib module (alphabetically) exporting name
+func moduleProviding(name string) string {
+	modules := stdlibModuleNames()
+	sort.Strings(modules)
+	for _, module := range modules {
+		if _, ok := StandardLibrary[module].Functions[name]; ok {
+			return module
+		}
+	}
+	return ""
+}
+
 // levenshteinDistance computes the edit distance between two strings
 // Used for "did you mean?" suggestions
 func levenshteinDistance(s1, s2 string) int {
@@ -456,13 +834,16 @@ func levenshteinDistance(s1, s2 string) int {
 func (sa *SemanticAnalyzer) FindSimilarSymbol(name string) string {
 	bestMatch := ""
 	bestDist := 3 // Max distance for suggestions
+	bestScope := -1
 
 	for i := len(sa.scopes) - 1; i >= 0; i-- {
 		for symName := range sa.scopes[i] {
 			dist := levenshteinDistance(strings.ToLower(name), strings.ToLower(symName))
-			if dist < bestDist {
+			// Ties go to the name that sorts first, not map order
+			if dist < bestDist || (dist == bestDist && i == bestScope && symName < bestMatch) {
 				bestDist = dist
 				bestMatch = symName
+				bestScope = i
 			}
 		}
 	}
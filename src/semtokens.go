@@ -0,0 +1,560 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// semtokens.go - Semantic highlighting (textDocument/semanticTokens)
+// Classifies each identifier in a file by what it names, which a regex
+// grammar cannot tell from its spelling:
+//
+//	use "str" as s;
+//	use "helper";                   // defines twice
+//	const int LIMIT = 10;
+//
+//	fn int count(string text) {
+//	    int n = s::len(text);       // s namespace, len defaultLibrary function
+//	    ret twice(n) + LIMIT;       // twice function, LIMIT readonly variable
+//	}
+//
+// The classifier works on tokens rather than the AST, so a file that does
+// not parse while it is being edited is still highlighted. Top-level
+// functions, constants, variables and types are collected first, together
+// with the functions and constants of the source modules the file uses,
+// which share its namespace (modules.go). Then braces open and close scopes
+// of locals; a function's parameters and a for loop's header variables
+// belong to the block that follows them. Keywords, type keywords and
+// literals are left to the editor's grammar.
+
+// Semantic token types and modifiers, in the order of the legend sent at
+// initialize: a token's type is its index and its modifiers are bits
+var semanticTokenTypes = []string{"namespace", "type", "function", "parameter", "variable"}
+var semanticTokenModifiers = []string{"declaration", "readonly", "defaultLibrary"}
+
+const (
+	semNamespace = iota
+	semType
+	semFunction
+	semParameter
+	semVariable
+)
+
+const (
+	semDeclaration = 1 << iota
+	semReadonly
+	semDefaultLibrary
+)
+
+// semanticToken is one classified identifier
+type semanticToken struct {
+	Tok       Token
+	Type      int
+	Modifiers int
+	Decl      int // Index of the token declaring the local it names, or -1
+}
+
+// localName is a local in scope: a parameter, variable or type parameter
+type localName struct {
+	Type int
+	Decl int // Index of its declaring token
+}
+
+// fileSymbols are the top-level names a file can refer to anywhere
+type fileSymbols struct {
+	functions map[string]bool
+	constants map[string]bool
+	variables map[string]bool
+	types     map[string]bool
+	modules   map[string]string // Alias or name -> module
+	imported  map[string]string // Stdlib function -> module, from use "module::function"
+	wildcards []string          // Stdlib modules used with ::*
+	files     []string          // Source modules added by loadModules, by path
+}
+
+// moduleResolver finds and reads the source modules a file uses
+type moduleResolver struct {
+	loader *ModuleLoader
+	read   func(path string) (string, error)
+}
+
+// newModuleResolver resolves modules in searchDirs after the using file's
+// directory, reading them with read, or from disk when read is nil
+func newModuleResolver(searchDirs []string, read func(path string) (string, error)) *moduleResolver {
+	if read == nil {
+		read = func(path string) (string, error) {
+			data, err := os.ReadFile(path)
+			return string(data), err
+		}
+	}
+	loader := NewModuleLoader(searchDirs, NewDiagnosticManager(), func(path string) string { return path })
+	return &moduleResolver{loader: loader, read: read}
+}
+
+// significantTokens tokenizes source without its newline tokens
+func significantTokens(source string) []Token {
+	var toks []Token
+	for _, tok := range Tokenize(source) {
+		if tok.Type != TokenNewline {
+			toks = append(toks, tok)
+		}
+	}
+	return toks
+}
+
+// collectSymbols reads the top-level declarations in toks
+func collectSymbols(toks []Token) *fileSymbols {
+	syms := &fileSymbols{
+		functions: make(map[string]bool), constants: make(map[string]bool),
+		variables: make(map[string]bool), types: make(map[string]bool),
+		modules: make(map[string]string), imported: make(map[string]string),
+	}
+	syms.add(toks)
+	return syms
+}
+
+// add records the top-level declarations in toks
+func (s *fileSymbols) add(toks []Token) {
+	at := func(i int) Token { return tokenAt(toks, i) }
+	depth := 0
+	for i := 0; i < len(toks); i++ {
+		switch toks[i].Type {
+		case TokenLBrace:
+			depth++
+		case TokenRBrace:
+			depth--
+		}
+		if depth != 0 {
+			continue
+		}
+		switch t := toks[i]; {
+		case t.Type == TokenFn:
+			if name, _, params := functionName(toks, i); name >= 0 {
+				s.functions[toks[name].Value] = true
+				i = matchingParen(toks, params) // Parameters are not globals
+			}
+		case t.Type == TokenUse && at(i+1).Type == TokenString:
+			s.addImport(toks, i+1)
+		case t.Type == TokenIdentifier && t.Value == "type" && at(i+1).Type == TokenIdentifier:
+			s.types[at(i+1).Value] = true
+		case t.Type == TokenIdentifier && at(i+1).Type != TokenLParen && isTypePosition(toks, i-1, s.types):
+			if at(i-2).Type == TokenConst || at(i-3).Type == TokenConst && at(i-1).Type == TokenQuestion {
+				s.constants[t.Value] = true
+			} else {
+				s.variables[t.Value] = true
+			}
+		}
+	}
+}
+
+// addImport records the use statement whose module string is toks[i]
+func (s *fileSymbols) addImport(toks []Token, i int) {
+	module, item, _ := strings.Cut(toks[i].Value, "::")
+	if at := tokenAt(toks, i+1); at.Type == TokenColon && tokenAt(toks, i+2).Type == TokenColon {
+		switch next := tokenAt(toks, i+3); next.Type {
+		case TokenStar:
+			item = "*"
+		case TokenIdentifier:
+			item = next.Value
+		}
+	}
+	switch item {
+	case "":
+	case "*":
+		s.wildcards = append(s.wildcards, module)
+	default:
+		s.imported[item] = module
+	}
+	name := module[strings.LastIndex(module, "/")+1:]
+	for j := i + 1; j < len(toks) && toks[j].Type != TokenSemi; j++ {
+		if toks[j].Type == TokenAs && tokenAt(toks, j+1).Type == TokenIdentifier {
+			name = toks[j+1].Value
+		}
+	}
+	s.modules[name] = module
+}
+
+// loadModules adds the top-level names of the source modules the file in dir
+// uses, and of the modules they use in turn
+func (s *fileSymbols) loadModules(res *moduleResolver, dir string, seen map[string]bool) {
+	modules := make([]string, 0, len(s.modules))
+	for _, module := range s.modules {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		if _, isStdlib := StandardLibrary[module]; isStdlib {
+			continue
+		}
+		path, err := res.loader.Resolve(module, dir)
+		if err != nil || seen[path] {
+			continue
+		}
+		seen[path] = true
+		source, err := res.read(path)
+		if err != nil {
+			continue
+		}
+		mod := collectSymbols(significantTokens(source))
+		mod.loadModules(res, filepath.Dir(path), seen)
+		s.files = append(append(s.files, mod.files...), path)
+		for name := range mod.functions {
+			s.functions[name] = true
+		}
+		for name := range mod.constants {
+			s.constants[name] = true
+		}
+	}
+}
+
+// stdlibFunction reports whether name is a stdlib function the file imports
+// to be called unqualified
+func (s *fileSymbols) stdlibFunction(name string) bool {
+	if module, ok := s.imported[name]; ok {
+		return GetModuleFunction(module, name) != nil
+	}
+	for _, module := range s.wildcards {
+		if GetModuleFunction(module, name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenAt returns toks[i], or an EOF token when i is out of range
+func tokenAt(toks []Token, i int) Token {
+	if i < 0 || i >= len(toks) {
+		return Token{Type: TokenEOF}
+	}
+	return toks[i]
+}
+
+// isTypePosition reports whether toks[i] ends a type written before a
+// declared name: a type keyword or type name, possibly nullable
+func isTypePosition(toks []Token, i int, types map[string]bool) bool {
+	t := tokenAt(toks, i)
+	if t.Type == TokenQuestion {
+		t = tokenAt(toks, i-1)
+	}
+	return isTypeToken(t.Type) || t.Type == TokenIdentifier && types[t.Value]
+}
+
+// functionName finds the name of the function whose fn keyword is toks[i],
+// returning its index, the indices of its type parameters and the index
+// where its parameter list opens; name is -1 for a malformed header
+func functionName(toks []Token, i int) (name int, typeParams []int, params int) {
+	j := i + 1
+	if tokenAt(toks, j).Type == TokenLParen {
+		j = matchingParen(toks, j) + 1 // Receiver
+	}
+	for ; j < len(toks); j++ {
+		switch toks[j].Type {
+		case TokenLBrace, TokenSemi:
+			return -1, nil, -1
+		case TokenIdentifier:
+			next := tokenAt(toks, j+1).Type
+			if next != TokenLParen && next != TokenLess {
+				continue
+			}
+			k := j + 1
+			if next == TokenLess {
+				for ; k < len(toks) && toks[k].Type != TokenGreater; k++ {
+					if toks[k].Type == TokenIdentifier {
+						typeParams = append(typeParams, k)
+					}
+				}
+				k++
+			}
+			if tokenAt(toks, k).Type != TokenLParen {
+				return -1, nil, -1
+			}
+			return j, typeParams, k
+		}
+	}
+	return -1, nil, -1
+}
+
+// matchingParen returns the index of the ) closing the ( at toks[i], or the
+// last index when it is not closed
+func matchingParen(toks []Token, i int) int {
+	depth := 0
+	for j := i; j < len(toks); j++ {
+		switch toks[j].Type {
+		case TokenLParen:
+			depth++
+		case TokenRParen:
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(toks) - 1
+}
+
+// classifier walks a file's tokens, tracking the scopes of locals
+type classifier struct {
+	toks      []Token
+	syms      *fileSymbols
+	scopes    []map[string]localName // Innermost last
+	pending   map[string]localName   // Declared in a header, for the block that follows
+	headerEnd int                    // Index of the ) closing a for or fn header
+	out       []semanticToken
+}
+
+// classifySource classifies the identifiers in source, the contents of the
+// file at path, and returns them with the names the file can refer to
+func classifySource(source, path string, res *moduleResolver) ([]semanticToken, *fileSymbols) {
+	toks := significantTokens(source)
+	syms := collectSymbols(toks)
+	syms.loadModules(res, filepath.Dir(path), map[string]bool{path: true})
+	c := &classifier{toks: toks, syms: syms, pending: make(map[string]localName), headerEnd: -1}
+	for i := 0; i < len(toks); i++ {
+		i = c.token(i)
+	}
+	return c.out, syms
+}
+
+func (c *classifier) at(i int) Token { return tokenAt(c.toks, i) }
+
+func (c *classifier) emit(i, typ, mods int) {
+	c.emitLocal(i, localName{Type: typ, Decl: -1}, mods)
+}
+
+func (c *classifier) emitLocal(i int, local localName, mods int) {
+	c.out = append(c.out, semanticToken{Tok: c.toks[i], Type: local.Type, Modifiers: mods, Decl: local.Decl})
+}
+
+// declare emits the declaration of a local and adds it to the innermost
+// scope, or to the block that follows when i is in a header. Top-level
+// variables are not locals.
+func (c *classifier) declare(i, typ, mods int) {
+	local := localName{Type: typ, Decl: i}
+	switch {
+	case i < c.headerEnd:
+		c.pending[c.toks[i].Value] = local
+	case len(c.scopes) > 0:
+		c.scopes[len(c.scopes)-1][c.toks[i].Value] = local
+	default:
+		local.Decl = -1
+	}
+	c.emitLocal(i, local, mods|semDeclaration)
+}
+
+// lookup finds name among the locals in scope, those of a header first
+func (c *classifier) lookup(name string) (localName, bool) {
+	if local, ok := c.pending[name]; ok {
+		return local, true
+	}
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if local, ok := c.scopes[i][name]; ok {
+			return local, true
+		}
+	}
+	return localName{}, false
+}
+
+// isType reports whether name names a type where it is used
+func (c *classifier) isType(name string) bool {
+	local, ok := c.lookup(name)
+	return ok && local.Type == semType || !ok && c.syms.types[name]
+}
+
+// token classifies the token at i and returns the index of the last token
+// it consumed
+func (c *classifier) token(i int) int {
+	t := c.toks[i]
+	switch t.Type {
+	case TokenLBrace:
+		c.scopes = append(c.scopes, c.pending)
+		c.pending = make(map[string]localName)
+	case TokenRBrace:
+		if len(c.scopes) > 0 {
+			c.scopes = c.scopes[:len(c.scopes)-1]
+		}
+	case TokenSemi:
+		if i > c.headerEnd {
+			c.pending = make(map[string]localName) // A loop without a block
+		}
+	case TokenFor:
+		if c.at(i+1).Type == TokenLParen {
+			c.headerEnd = matchingParen(c.toks, i+1)
+		}
+	case TokenFn:
+		return c.function(i)
+	case TokenUse:
+		return c.use(i)
+	case TokenIdentifier:
+		return c.identifier(i)
+	}
+	return i
+}
+
+// use classifies the function and alias a use statement names
+func (c *classifier) use(i int) int {
+	j := i + 1
+	for ; j < len(c.toks) && c.toks[j].Type != TokenSemi; j++ {
+		switch {
+		case c.toks[j].Type == TokenAs && c.at(j+1).Type == TokenIdentifier:
+			j++
+			c.emit(j, semNamespace, semDeclaration)
+		case c.toks[j].Type == TokenIdentifier && c.at(j-1).Type == TokenColon:
+			c.emit(j, semFunction, semDefaultLibrary)
+		}
+	}
+	return j
+}
+
+// function classifies a function header, from fn to the ) closing its
+// parameters; the parameters and type parameters are in scope in its body
+func (c *classifier) function(i int) int {
+	name, typeParams, params := functionName(c.toks, i)
+	if name < 0 {
+		return i
+	}
+	c.pending = make(map[string]localName)
+	end := matchingParen(c.toks, params)
+	c.headerEnd = end
+	for _, k := range typeParams {
+		c.pending[c.toks[k].Value] = localName{Type: semType, Decl: k}
+	}
+	for j := i + 1; j <= end; j++ {
+		if c.toks[j].Type != TokenIdentifier {
+			continue
+		}
+		switch {
+		case j == name:
+			c.emit(j, semFunction, semDeclaration)
+		case inIntSlice(typeParams, j):
+			c.emitLocal(j, localName{Type: semType, Decl: j}, semDeclaration)
+		case c.at(j+1).Type == TokenComma || c.at(j+1).Type == TokenRParen:
+			c.declare(j, semParameter, 0) // Receiver or parameter
+		default:
+			local, ok := c.lookup(c.toks[j].Value) // Return or parameter type
+			if !ok || local.Type != semType {
+				local = localName{Type: semType, Decl: -1}
+			}
+			c.emitLocal(j, local, 0)
+		}
+	}
+	return end
+}
+
+// identifier classifies an identifier outside function headers
+func (c *classifier) identifier(i int) int {
+	name, prev, next := c.toks[i].Value, c.at(i-1).Type, c.at(i+1).Type
+	switch {
+	case prev == TokenAt:
+		return i // Attribute name
+	case name == "type" && next == TokenIdentifier && (prev == TokenEOF || prev == TokenSemi || prev == TokenRBrace):
+		c.emit(i+1, semType, semDeclaration)
+		return i + 1
+	case next == TokenColon && c.at(i+2).Type == TokenColon:
+		c.emit(i, semNamespace, 0)
+		if c.at(i+3).Type != TokenIdentifier {
+			return i + 2
+		}
+		module, ok := c.syms.modules[name]
+		if !ok {
+			module = name
+		}
+		mods := 0
+		if GetModuleFunction(module, c.toks[i+3].Value) != nil {
+			mods = semDefaultLibrary
+		}
+		c.emit(i+3, semFunction, mods)
+		return i + 3
+	case prev == TokenDot:
+		if next == TokenLParen {
+			c.emit(i, semFunction, 0) // Method call
+		}
+	case next != TokenLParen && prev != TokenDot && isTypePosition(c.toks, i-1, nil) || c.isDeclaredAfterName(i):
+		mods := 0
+		if c.at(i-2).Type == TokenConst || c.at(i-3).Type == TokenConst && prev == TokenQuestion {
+			mods = semReadonly
+		}
+		c.declare(i, semVariable, mods)
+	default:
+		c.reference(i)
+	}
+	return i
+}
+
+// isDeclaredAfterName reports whether the identifier at i is declared with a
+// type name before it, as in Fd sock = ...
+func (c *classifier) isDeclaredAfterName(i int) bool {
+	j := i - 1
+	if c.at(j).Type == TokenQuestion {
+		j--
+	}
+	return c.at(i+1).Type != TokenLParen && c.at(j).Type == TokenIdentifier && c.isType(c.at(j).Value) &&
+		c.at(j-1).Type != TokenDot
+}
+
+// reference classifies a use of a name
+func (c *classifier) reference(i int) {
+	name := c.toks[i].Value
+	if local, ok := c.lookup(name); ok {
+		c.emitLocal(i, local, 0)
+		return
+	}
+	s := c.syms
+	switch _, module := s.modules[name]; {
+	case s.types[name]:
+		c.emit(i, semType, 0)
+	case s.constants[name]:
+		c.emit(i, semVariable, semReadonly)
+	case s.variables[name]:
+		c.emit(i, semVariable, 0)
+	case s.functions[name]:
+		c.emit(i, semFunction, 0)
+	case module:
+		c.emit(i, semNamespace, 0)
+	case RegisteredPrintFunctions[name] != nil, s.stdlibFunction(name):
+		c.emit(i, semFunction, semDefaultLibrary)
+	}
+}
+
+// inIntSlice reports whether v is in list
+func inIntSlice(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeSemanticTokens encodes classified tokens as the protocol's integers:
+// for each, its line and start relative to the previous token, its length,
+// type and modifiers, with positions 0-based in UTF-16 code units
+func encodeSemanticTokens(source string, tokens []semanticToken) []int {
+	lines := strings.Split(source, "\n")
+	data := make([]int, 0, 5*len(tokens))
+	prevLine, prevStart := 0, 0
+	for _, st := range tokens {
+		line := st.Tok.Line - 1
+		if line < 0 || line >= len(lines) {
+			continue
+		}
+		start := utf16Column(lines[line], st.Tok.Column)
+		length := len(utf16.Encode([]rune(st.Tok.Value)))
+		deltaStart := start
+		if line == prevLine {
+			deltaStart = start - prevStart
+		}
+		data = append(data, line-prevLine, deltaStart, length, st.Type, st.Modifiers)
+		prevLine, prevStart = line, start
+	}
+	return data
+}
+
+// utf16Column converts a 1-based column in runes on line to the protocol's
+// 0-based offset in UTF-16 code units
+func utf16Column(line string, column int) int {
+	runes := []rune(line)
+	return len(utf16.Encode(runes[:max(0, min(column-1, len(runes)))]))
+}
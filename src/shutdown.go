@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// shutdown.go - Shutdown signal runtime
+// os.catch_shutdown installs .lotus_rt_shutdown for SIGTERM and SIGINT, which
+// records the signal in .lotus_shutdown for os.shutdown_requested to read.
+// The handler is installed without SA_RESTART, so a blocking read, accept or
+// sleep returns -EINTR and a service loop gets to check the flag and stop.
+
+// Signals that request a shutdown
+const (
+	sigInt  = 2
+	sigTerm = 15
+)
+
+// useShutdown appends the shutdown handler to the program
+func (cg *CodeGenerator) useShutdown() {
+	cg.shutdown = true
+}
+
+// shutdownData returns the flag and the sigaction the handler is installed with
+func shutdownData() string {
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_shutdown:\n    .quad 0\n")
+	b.WriteString(".lotus_shutdown_sigaction:\n")
+	b.WriteString("    .quad .lotus_rt_shutdown\n")
+	b.WriteString("    .quad 0x04000000  # SA_RESTORER\n")
+	b.WriteString("    .quad .lotus_rt_shutdown_restorer\n")
+	b.WriteString("    .quad 0\n")
+	return b.String()
+}
+
+// shutdownRuntime returns the handler and its signal return trampoline
+func (cg *CodeGenerator) shutdownRuntime() string {
+	sigreturn, _ := cg.target.Syscall("rt_sigreturn")
+	return fmt.Sprintf(`
+# ---- shutdown signal handler ----
+.lotus_rt_shutdown:
+    movq %%rdi, .lotus_shutdown(%%rip)
+    ret
+.lotus_rt_shutdown_restorer:
+    movq $%d, %%rax  # rt_sigreturn
+    syscall
+`, sigreturn)
+}
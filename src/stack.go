@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// stack.go - Stack usage accounting and stack probes
+// Each function's frame is recorded as it is generated, together with the
+// extra stack its body pushes (argument spills, inline stdlib buffers) and the
+// user functions it calls. -print-stack-usage reports these along with an
+// upper bound on the stack a call can reach. -stack-probe allocates frames of
+// a page or more one page at a time, touching each, so running off the end of
+// a small stack faults on the guard page instead of silently skipping past it.
+// Recursion and mem::stackalloc leave a call with no upper bound.
+
+// guardPageSize is the size of the unmapped page below a thread's stack
+const guardPageSize = 4096
+
+// maxUnrolledProbes is the largest frame, in pages, probed without a loop
+const maxUnrolledProbes = 4
+
+// StackFrame is the stack accounting for one generated function
+type StackFrame struct {
+	Function string
+	Frame    int      // Prologue allocation plus saved %rbp and return address
+	Scratch  int      // Peak stack used by the body beyond the frame
+	Callees  []string // User functions called, in order of first call
+	Dynamic  bool     // Body moves rsp by a run-time amount (mem::stackalloc)
+}
+
+// worstCaseStack results for calls with no bound
+const (
+	stackUnboundedRecursive = -1
+	stackUnboundedDynamic   = -2
+)
+
+var (
+	stackSubPattern  = regexp.MustCompile(`^\s+subq\s+\$(\d+),\s+%rsp`)
+	stackAddPattern  = regexp.MustCompile(`^\s+addq\s+\$(\d+),\s+%rsp`)
+	stackDynPattern  = regexp.MustCompile(`^\s+# dynamic stack allocation`)
+	stackPushPattern = regexp.MustCompile(`^\s+pushq\s`)
+	stackPopPattern  = regexp.MustCompile(`^\s+popq\s`)
+	userCallPattern  = regexp.MustCompile(`^\s+call\s+\.([\w.]+)\s*$`)
+)
+
+// measureStack builds the accounting for a function from its frame size and
+// the assembly generated for its body
+func measureStack(name string, frame int, body string) *StackFrame {
+	sf := &StackFrame{Function: name, Frame: frame}
+	seen := make(map[string]bool)
+	depth := 0
+
+	for _, line := range strings.Split(body, "\n") {
+		switch {
+		case stackPushPattern.MatchString(line):
+			depth += 8
+		case stackPopPattern.MatchString(line):
+			depth -= 8
+		case stackSubPattern.MatchString(line):
+			n, _ := strconv.Atoi(stackSubPattern.FindStringSubmatch(line)[1])
+			depth += n
+		case stackAddPattern.MatchString(line):
+			n, _ := strconv.Atoi(stackAddPattern.FindStringSubmatch(line)[1])
+			depth -= n
+		case stackDynPattern.MatchString(line):
+			sf.Dynamic = true
+		case userCallPattern.MatchString(line):
+			callee := userCallPattern.FindStringSubmatch(line)[1]
+			if _, ok := UserDefinedFunctions[callee]; ok && !seen[callee] {
+				seen[callee] = true
+				sf.Callees = append(sf.Callees, callee)
+			}
+		}
+		if depth > sf.Scratch {
+			sf.Scratch = depth
+		}
+	}
+	return sf
+}
+
+// worstCaseStack returns an upper bound on the stack a call to name can use,
+// or stackUnboundedRecursive or stackUnboundedDynamic when there is none
+func worstCaseStack(frames map[string]*StackFrame, name string, visiting map[string]bool, memo map[string]int) int {
+	if total, ok := memo[name]; ok {
+		return total
+	}
+	sf, ok := frames[name]
+	if !ok {
+		return 0
+	}
+	if visiting[name] {
+		return stackUnboundedRecursive
+	}
+	if sf.Dynamic {
+		memo[name] = stackUnboundedDynamic
+		return stackUnboundedDynamic
+	}
+
+	visiting[name] = true
+	deepest := 0
+	for _, callee := range sf.Callees {
+		total := worstCaseStack(frames, callee, visiting, memo)
+		if total < 0 {
+			deepest = total
+			break
+		}
+		if total > deepest {
+			deepest = total
+		}
+	}
+	delete(visiting, name)
+
+	total := deepest
+	if deepest >= 0 {
+		total = sf.Frame + sf.Scratch + deepest
+	}
+	memo[name] = total
+	return total
+}
+
+// PrintStackUsage writes the per-function stack report
+func PrintStackUsage(w io.Writer, frames []*StackFrame) {
+	byName := make(map[string]*StackFrame, len(frames))
+	width := len("Function")
+	for _, sf := range frames {
+		byName[sf.Function] = sf
+		if len(sf.Function) > width {
+			width = len(sf.Function)
+		}
+	}
+
+	memo := make(map[string]int)
+	fmt.Fprintf(w, "\n=== Stack Usage (bytes) ===\n")
+	fmt.Fprintf(w, "  %-*s  %7s  %7s  %s\n", width, "Function", "Frame", "Scratch", "Worst case")
+	for _, sf := range frames {
+		worst := "unbounded (recursive)"
+		switch total := worstCaseStack(byName, sf.Function, make(map[string]bool), memo); {
+		case total >= 0:
+			worst = strconv.Itoa(total)
+		case total == stackUnboundedDynamic:
+			worst = "unbounded (stackalloc)"
+		}
+		fmt.Fprintf(w, "  %-*s  %7d  %7d  %s\n", width, sf.Function, sf.Frame, sf.Scratch, worst)
+	}
+}
+
+// stackAllocation returns the prologue code reserving size bytes of frame.
+// With probing enabled, frames of a page or more are reserved a page at a
+// time and each page is touched before moving past it.
+func (cg *CodeGenerator) stackAllocation(size int) string {
+	if !cg.stackProbe || size < guardPageSize {
+		return fmt.Sprintf("    subq $%d, %%rsp\n", size)
+	}
+
+	var b strings.Builder
+	pages := size / guardPageSize
+	b.WriteString("    # Stack probe: touch each page of the frame\n")
+	if pages <= maxUnrolledProbes {
+		for i := 0; i < pages; i++ {
+			fmt.Fprintf(&b, "    subq $%d, %%rsp\n", guardPageSize)
+			b.WriteString("    orq $0, (%rsp)\n")
+		}
+	} else {
+		probeLabel := cg.getLabel("probe")
+		fmt.Fprintf(&b, "    movq $%d, %%r11\n", pages)
+		fmt.Fprintf(&b, "%s:\n", probeLabel)
+		fmt.Fprintf(&b, "    subq $%d, %%rsp\n", guardPageSize)
+		b.WriteString("    orq $0, (%rsp)\n")
+		b.WriteString("    decq %r11\n")
+		fmt.Fprintf(&b, "    jnz %s\n", probeLabel)
+	}
+	if rest := size % guardPageSize; rest > 0 {
+		fmt.Fprintf(&b, "    subq $%d, %%rsp\n", rest)
+	}
+	return b.String()
+}
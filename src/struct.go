@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 // StructDefinition represents a struct type definition
 type StructDefinition struct {
@@ -76,8 +79,13 @@ func (cg *CodeGenerator) generateStructLiteral(lit *StructLiteral) {
 	cg.textSection.WriteString("    call malloc@PLT\n")
 	cg.textSection.WriteString("    pushq %rax\n") // Save struct pointer
 
-	// Initialize each field
-	for fieldName, valueExpr := range lit.Fields {
+	// Initialize each field, in declaration order so output is reproducible
+	declared := make([]string, len(structDef.Fields))
+	for i, field := range structDef.Fields {
+		declared[i] = field.Name
+	}
+	for _, fieldName := range literalFieldOrder(lit.Fields, declared) {
+		valueExpr := lit.Fields[fieldName]
 		// Find field definition
 		var fieldDef *StructField
 		for i := range structDef.Fields {
@@ -116,6 +124,25 @@ func (cg *CodeGenerator) generateStructLiteral(lit *StructLiteral) {
 	cg.textSection.WriteString("    popq %rax\n")
 }
 
+// literalFieldOrder returns the fields a literal sets: declared fields in
+// declaration order, then any unknown ones by name
+func literalFieldOrder(fields map[string]ASTNode, declared []string) []string {
+	order := make([]string, 0, len(fields))
+	for _, name := range declared {
+		if _, ok := fields[name]; ok {
+			order = append(order, name)
+		}
+	}
+	var unknown []string
+	for name := range fields {
+		if !containsString(declared, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return append(order, unknown...)
+}
+
 // generateFieldAccess generates assembly for struct field access
 func (cg *CodeGenerator) generateFieldAccess(access *FieldAccess) {
 	// Evaluate object to get struct pointer
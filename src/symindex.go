@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// symindex.go - Symbol index for tooling (-symbol-index dir)
+// Writes dir/<module>.json for the file being compiled and for each source
+// module it uses, listing the functions and constants each declares with
+// their signatures, positions and doc comments, so editors, the language
+// server and documentation tools read one file instead of parsing Lotus
+// themselves. A doc comment is the run of // lines directly above a
+// declaration and its attributes:
+//
+//	// twice doubles x.
+//	// It never fails.
+//	fn int twice(int x) { ret x * 2; }
+//
+// Each file is parsed on its own, before overload resolution renames
+// functions, so the names are the ones written in the source.
+
+// symbolIndexVersion is the schema version written in each index
+const symbolIndexVersion = 1
+
+// Symbol index schema (version 1). Positions are 1-based.
+type indexParam struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type indexFunction struct {
+	Name       string       `json:"name"`
+	Signature  string       `json:"signature"`
+	Params     []indexParam `json:"params"`
+	Returns    string       `json:"returns"`
+	TypeParams []string     `json:"type_params,omitempty"` // Generic functions
+	Attributes []string     `json:"attributes,omitempty"`
+	Line       int          `json:"line"`
+	Column     int          `json:"column"`
+	Doc        string       `json:"doc,omitempty"`
+}
+
+type indexConstant struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Value  string `json:"value,omitempty"` // Literal values only, as written
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Doc    string `json:"doc,omitempty"`
+}
+
+type symbolIndex struct {
+	Version   int             `json:"version"`
+	Module    string          `json:"module"`
+	File      string          `json:"file"`
+	Imports   []string        `json:"imports"`
+	Functions []indexFunction `json:"functions"`
+	Constants []indexConstant `json:"constants"`
+}
+
+// writeSymbolIndexes writes the index of the program and of its modules
+func (c *Compiler) writeSymbolIndexes(inputPath string, source []byte) error {
+	dir := c.Options.SymbolIndexDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create symbol index directory: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+	if err := c.writeSymbolIndex(dir, name, inputPath, string(source)); err != nil {
+		return err
+	}
+	for _, mod := range c.Modules {
+		if err := c.writeSymbolIndex(dir, mod.Name, mod.Path, mod.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSymbolIndex indexes the module name, read from path, into dir
+func (c *Compiler) writeSymbolIndex(dir, name, path, source string) error {
+	index, err := buildSymbolIndex(name, c.displayPath(path), source)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false) // Signatures of generic functions hold < and >
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(index); err != nil {
+		return err
+	}
+	out := filepath.Join(dir, strings.ReplaceAll(name, "/", ".")+".json")
+	if err := os.WriteFile(out, data.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write symbol index: %w", err)
+	}
+	return nil
+}
+
+// buildSymbolIndex parses source and lists its top-level declarations
+func buildSymbolIndex(name, file, source string) (*symbolIndex, error) {
+	statements, err := NewParser(Tokenize(source)).Parse()
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(source, "\n")
+	index := &symbolIndex{
+		Version: symbolIndexVersion, Module: name, File: file,
+		Imports: []string{}, Functions: []indexFunction{}, Constants: []indexConstant{},
+	}
+	for _, stmt := range statements {
+		loc := stmt.Loc()
+		doc := docComment(lines, loc.Line)
+		switch n := stmt.(type) {
+		case *ImportStatement:
+			index.Imports = append(index.Imports, n.Module)
+		case *FunctionDefinition:
+			if n.Init {
+				continue
+			}
+			fn := indexFunction{
+				Name: n.Name, Returns: typeDisplay(n.ReturnType, n.ReturnName, n.ReturnNullable),
+				Params: []indexParam{}, Attributes: attributeStrings(n.Attributes),
+				Line: loc.Line, Column: loc.Column, Doc: doc,
+			}
+			params := make([]string, len(n.Parameters))
+			for i, p := range n.Parameters {
+				typ := typeDisplay(p.Type, p.TypeName, p.Nullable)
+				if p.Variadic {
+					typ += "..."
+				}
+				fn.Params = append(fn.Params, indexParam{Name: p.Name, Type: typ})
+				params[i] = typ + " " + p.Name
+			}
+			fn.Signature = fmt.Sprintf("fn %s %s(%s)", fn.Returns, n.Name, strings.Join(params, ", "))
+			index.Functions = append(index.Functions, fn)
+		case *GenericFunction:
+			index.Functions = append(index.Functions, genericIndexEntry(n, doc))
+		case *ConstantDeclaration:
+			index.Constants = append(index.Constants, indexConstant{
+				Name: n.Name, Type: typeDisplay(n.Type, n.TypeName, false), Value: literalText(n.Value),
+				Line: loc.Line, Column: loc.Column, Doc: doc,
+			})
+		}
+	}
+	return index, nil
+}
+
+// genericIndexEntry describes a generic function from its saved tokens:
+// fn, the return type, the name, then the parameter list
+func genericIndexEntry(g *GenericFunction, doc string) indexFunction {
+	fn := indexFunction{
+		Name: g.Name, TypeParams: g.TypeParams, Params: []indexParam{}, Attributes: attributeStrings(g.Attributes),
+		Line: g.Line, Column: g.Column, Doc: doc,
+	}
+	toks := g.Tokens
+	if len(toks) > 2 {
+		fn.Returns = toks[1].Value
+		if fn.Returns == "" {
+			fn.Returns = TokenValue(toks[1])
+		}
+	}
+	var params []string
+	var words []string
+	for i := 4; i < len(toks) && toks[i].Type != TokenRParen; i++ {
+		if toks[i].Type == TokenComma {
+			params = append(params, strings.Join(words, " "))
+			words = nil
+			continue
+		}
+		word := toks[i].Value
+		if word == "" {
+			word = TokenValue(toks[i])
+		}
+		words = append(words, word)
+	}
+	if len(words) > 0 {
+		params = append(params, strings.Join(words, " "))
+	}
+	for _, p := range params {
+		typ, name := p, ""
+		if i := strings.LastIndex(p, " "); i >= 0 {
+			typ, name = p[:i], p[i+1:]
+		}
+		fn.Params = append(fn.Params, indexParam{Name: name, Type: strings.ReplaceAll(typ, " ", "")})
+	}
+	fn.Signature = fmt.Sprintf("fn %s %s<%s>(%s)", fn.Returns, g.Name, strings.Join(g.TypeParams, ", "), strings.Join(params, ", "))
+	return fn
+}
+
+// typeDisplay names a declared type, preferring the newtype it was written as
+func typeDisplay(t TokenType, newtype string, nullable bool) string {
+	name := newtype
+	if name == "" {
+		name = TokenValue(Token{Type: t})
+	}
+	if nullable {
+		name += "?"
+	}
+	return name
+}
+
+// attributeStrings writes attributes as they appear in source: @name(args)
+func attributeStrings(attrs []Attribute) []string {
+	var out []string
+	for _, attr := range attrs {
+		if len(attr.Args) == 0 {
+			out = append(out, "@"+attr.Name)
+			continue
+		}
+		args := make([]string, len(attr.Args))
+		for i, arg := range attr.Args {
+			args[i] = TokenValue(arg)
+		}
+		out = append(out, fmt.Sprintf("@%s(%s)", attr.Name, strings.Join(args, ", ")))
+	}
+	return out
+}
+
+// literalText returns a literal constant value as source text, or ""
+func literalText(node ASTNode) string {
+	switch v := node.(type) {
+	case *IntLiteral:
+		return strconv.Itoa(v.Value)
+	case *StringLiteral:
+		return strconv.Quote(v.Value)
+	case *CharLiteral:
+		return "'" + v.Value + "'"
+	case *BoolLiteral:
+		return strconv.FormatBool(v.Value)
+	case *FloatLiteral:
+		return strconv.FormatFloat(v.Value, 'g', -1, 64)
+	}
+	return ""
+}
+
+// docComment returns the // lines directly above line, skipping the
+// declaration's attribute lines, without their comment markers
+func docComment(lines []string, line int) string {
+	i := line - 2 // Index of the line above
+	for i >= 0 && strings.HasPrefix(strings.TrimSpace(lines[i]), "@") {
+		i--
+	}
+	var doc []string
+	for ; i >= 0; i-- {
+		text := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(text, "//") {
+			break
+		}
+		text = strings.TrimPrefix(text, "//")
+		doc = append([]string{strings.TrimPrefix(text, " ")}, doc...)
+	}
+	return strings.Join(doc, "\n")
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sysaudit.go - System call audit (-print-syscalls) and seccomp filter (-seccomp)
+// Lotus programs make system calls directly, so the compiler can list every
+// one a binary is able to make. Each syscall instruction in the final
+// assembly is matched with the number loaded into %rax before it, and with
+// the code that emitted it: a stdlib or print call, a function, the startup
+// code or one of the runtimes. With -seccomp, the list becomes a seccomp-bpf
+// filter installed at startup, and any other system call kills the process.
+
+// seccompAuditArch is the AUDIT_ARCH value a filter checks, by architecture
+var seccompAuditArch = map[string]uint32{
+	"x86_64":  0xc000003e,
+	"aarch64": 0xc00000b7,
+}
+
+// seccomp-bpf encoding
+const (
+	bpfLoadAbs      = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJumpEq       = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfReturn       = 0x06 // BPF_RET | BPF_K
+	seccompKill     = 0x80000000
+	seccompAllow    = 0x7fff0000
+	seccompDataNr   = 0 // Offset of the syscall number in seccomp_data
+	seccompDataArch = 4 // Offset of the architecture in seccomp_data
+)
+
+// Instructions that set %rax to a known syscall number
+var raxLoadPattern = regexp.MustCompile(`^\s+movq\s+\$(\d+),\s+%rax\b`)
+var raxClearPattern = regexp.MustCompile(`^\s+xor[lq]\s+%[er]ax,\s*%[er]ax\b`)
+
+// syscallOrigin attributes the syscalls in a span of the text section
+type syscallOrigin struct {
+	start, end int
+	function   string // Enclosing function, or "" at top level
+	what       string // Call or statement that emitted the span, or "" for a whole function
+	line       int
+}
+
+// SyscallSite is one place a program makes a system call
+type SyscallSite struct {
+	Nr    int    // Syscall number, or -1 when it is only known at run time
+	Name  string // Name on the target, or "" if the number is not in its table
+	Where string
+}
+
+// noteSyscallOrigin records that the code generated since start came from what
+func (cg *CodeGenerator) noteSyscallOrigin(start int, what string, loc Location) {
+	origin := syscallOrigin{start: start, end: cg.textSection.Len(), what: what, line: loc.Line}
+	if cg.currentFunction != nil {
+		origin.function = cg.currentFunction.Name
+	}
+	cg.syscallOrigins = append(cg.syscallOrigins, origin)
+}
+
+// syscallNumbers calls visit with the index of each syscall instruction in
+// lines and the number last loaded into %rax within the same straight-line
+// run, or -1 if there is none
+func syscallNumbers(lines []string, visit func(i, nr int)) {
+	nr := -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case raxLoadPattern.MatchString(line):
+			nr, _ = strconv.Atoi(raxLoadPattern.FindStringSubmatch(line)[1])
+		case raxClearPattern.MatchString(line):
+			nr = 0
+		case trimmed == "syscall":
+			visit(i, nr)
+			nr = -1
+		case strings.HasSuffix(trimmed, ":"), strings.HasPrefix(trimmed, "j"),
+			strings.HasPrefix(trimmed, "call"), strings.HasPrefix(trimmed, "ret"),
+			writesRax(trimmed):
+			nr = -1
+		}
+	}
+}
+
+// auditSyscalls lists the system calls in code, all attributed to where
+func (cg *CodeGenerator) auditSyscalls(code, where string) []SyscallSite {
+	var sites []SyscallSite
+	syscallNumbers(strings.Split(code, "\n"), func(_, nr int) {
+		sites = append(sites, cg.syscallSite(nr, where))
+	})
+	return sites
+}
+
+// auditProgramSyscalls lists the system calls in the program code, which
+// starts with the text section, attributing each to its recorded origin
+func (cg *CodeGenerator) auditProgramSyscalls(code string) []SyscallSite {
+	lines := strings.Split(code, "\n")
+	offsets := make([]int, len(lines))
+	offset := 0
+	for i, line := range lines {
+		offsets[i] = offset
+		offset += len(line) + 1
+	}
+
+	var sites []SyscallSite
+	syscallNumbers(lines, func(i, nr int) {
+		sites = append(sites, cg.syscallSite(nr, cg.syscallOriginAt(offsets[i])))
+	})
+	return sites
+}
+
+// originAt returns the innermost origin containing offset, or nil
+func (cg *CodeGenerator) originAt(offset int) *syscallOrigin {
+	var best *syscallOrigin
+	for i := range cg.syscallOrigins {
+		o := &cg.syscallOrigins[i]
+		if o.start <= offset && offset < o.end && (best == nil || o.start >= best.start) {
+			best = o
+		}
+	}
+	return best
+}
+
+// syscallOriginAt describes the innermost origin containing offset
+func (cg *CodeGenerator) syscallOriginAt(offset int) string {
+	best := cg.originAt(offset)
+	if best == nil && offset >= cg.textSection.Len() {
+		return "program exit"
+	}
+	if best == nil {
+		return "(top level)"
+	}
+	function := best.function
+	if function == "" {
+		function = "(top level)"
+	}
+	if best.what == "" {
+		return function
+	}
+	return fmt.Sprintf("%s: %s, line %d", function, best.what, best.line)
+}
+
+// syscallSite names syscall nr on the target
+func (cg *CodeGenerator) syscallSite(nr int, where string) SyscallSite {
+	site := SyscallSite{Nr: nr, Where: where}
+	for name, n := range cg.target.Syscalls {
+		if n == nr {
+			site.Name = name
+		}
+	}
+	return site
+}
+
+// PrintSyscallReport writes the system calls a program can make, grouped by
+// syscall, with the places each one is made from
+func PrintSyscallReport(w io.Writer, sites []SyscallSite) {
+	byNr := make(map[int][]string)
+	names := make(map[int]string)
+	for _, site := range sites {
+		if !containsString(byNr[site.Nr], site.Where) {
+			byNr[site.Nr] = append(byNr[site.Nr], site.Where)
+		}
+		names[site.Nr] = site.Name
+	}
+	nrs := make([]int, 0, len(byNr))
+	for nr := range byNr {
+		nrs = append(nrs, nr)
+	}
+	// Syscalls with a run-time number go last
+	sort.Slice(nrs, func(i, j int) bool {
+		if (nrs[i] < 0) != (nrs[j] < 0) {
+			return nrs[j] < 0
+		}
+		return nrs[i] < nrs[j]
+	})
+
+	fmt.Fprintf(w, "\n=== System Calls ===\n")
+	if len(nrs) == 0 {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+	for _, nr := range nrs {
+		switch {
+		case nr < 0:
+			fmt.Fprintf(w, "  ? (number set at run time)\n")
+		case names[nr] == "":
+			fmt.Fprintf(w, "  %d\n", nr)
+		default:
+			fmt.Fprintf(w, "  %s (%d)\n", names[nr], nr)
+		}
+		for _, where := range byNr[nr] {
+			fmt.Fprintf(w, "      %s\n", where)
+		}
+	}
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// seccompSupported reports why target cannot install a seccomp filter, or ""
+// if it can
+func seccompSupported(target *Target) string {
+	if _, ok := seccompAuditArch[target.Arch]; !ok || target.Freestanding() {
+		return fmt.Sprintf("-seccomp needs a Linux target, not %s", target.Triple)
+	}
+	for _, name := range []string{"prctl", "exit"} {
+		if _, ok := target.Syscall(name); !ok {
+			return fmt.Sprintf("-seccomp needs the %s system call, which %s does not have", name, target.Triple)
+		}
+	}
+	return ""
+}
+
+// seccompFilter returns the filter program allowing exactly the syscalls in
+// sites. A syscall whose number is only known at run time cannot be allowed,
+// so it is reported as an error.
+func (cg *CodeGenerator) seccompFilter(sites []SyscallSite) string {
+	var allowed []int
+	for _, site := range sites {
+		if site.Nr < 0 {
+			cg.diagnostics.AddErrorWithCode(string(ErrDynamicSyscall), CategoryGeneral,
+				fmt.Sprintf("-seccomp: the system call made in %s has a number only known at run time", site.Where),
+				cg.diagnostics.FilePath, 0, 0, "")
+			continue
+		}
+		if !containsInt(allowed, site.Nr) {
+			allowed = append(allowed, site.Nr)
+		}
+	}
+	sort.Ints(allowed)
+
+	type insn struct {
+		code   int
+		jt, jf int
+		k      uint32
+	}
+	prog := []insn{
+		{bpfLoadAbs, 0, 0, seccompDataArch},
+		{bpfJumpEq, 1, 0, seccompAuditArch[cg.target.Arch]},
+		{bpfReturn, 0, 0, seccompKill},
+		{bpfLoadAbs, 0, 0, seccompDataNr},
+	}
+	for i, nr := range allowed {
+		// Jump over the remaining checks and the kill to the allow
+		prog = append(prog, insn{bpfJumpEq, len(allowed) - i, 0, uint32(nr)})
+	}
+	prog = append(prog, insn{bpfReturn, 0, 0, seccompKill}, insn{bpfReturn, 0, 0, seccompAllow})
+
+	var b strings.Builder
+	b.WriteString("    .balign 8\n")
+	b.WriteString(".lotus_seccomp_prog:\n")
+	fmt.Fprintf(&b, "    .short %d\n    .zero 6\n    .quad .lotus_seccomp_filter\n", len(prog))
+	b.WriteString(".lotus_seccomp_filter:\n")
+	for _, in := range prog {
+		fmt.Fprintf(&b, "    .short 0x%02x; .byte %d, %d; .long 0x%x\n", in.code, in.jt, in.jf, in.k)
+	}
+	return b.String()
+}
+
+// containsInt reports whether list holds n
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+// seccompSetup returns the startup code that installs the filter. If the
+// kernel refuses it, the program exits rather than run unconfined.
+func (cg *CodeGenerator) seccompSetup() string {
+	lbl1 := cg.getLabel("seccomp_setup")
+	prctlNr, _ := cg.target.Syscall("prctl")
+	exitNr, _ := cg.target.Syscall("exit")
+	var b strings.Builder
+	b.WriteString("    # seccomp: allow only the system calls this program makes\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rax  # syscall: prctl\n", prctlNr)
+	b.WriteString("    movq $38, %rdi  # PR_SET_NO_NEW_PRIVS\n")
+	b.WriteString("    movq $1, %rsi\n")
+	b.WriteString("    xorq %rdx, %rdx\n")
+	b.WriteString("    xorq %r10, %r10\n")
+	b.WriteString("    xorq %r8, %r8\n")
+	b.WriteString("    syscall\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rax  # syscall: prctl\n", prctlNr)
+	b.WriteString("    movq $22, %rdi  # PR_SET_SECCOMP\n")
+	b.WriteString("    movq $2, %rsi  # SECCOMP_MODE_FILTER\n")
+	b.WriteString("    leaq .lotus_seccomp_prog(%rip), %rdx\n")
+	b.WriteString("    syscall\n")
+	b.WriteString("    testq %rax, %rax\n")
+	fmt.Fprintf(&b, "    jz %s\n", lbl1)
+	b.WriteString("    movq $1, %rdi\n")
+	fmt.Fprintf(&b, "    movq $%d, %%rax  # syscall: exit\n", exitNr)
+	b.WriteString("    syscall\n")
+	fmt.Fprintf(&b, "%s:\n", lbl1)
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tables.go - Lookup tables computed at compile time
+// Table-driven stdlib routines describe each table as a small Lotus function
+// of the entry index. The comptime interpreter runs it for every index, and
+// the table is emitted once into .rodata however many call sites use it.
+
+// LookupTable is a read-only table whose entries a Lotus function computes
+type LookupTable struct {
+	Name   string // The table is emitted at .lotus_table_<Name>
+	Source string // Lotus source defining fn int entry(int i)
+	Len    int    // Number of entries
+	Width  int    // Bytes per entry: 1, 2, 4 or 8
+}
+
+// crc32Table holds the CRC-32 (IEEE 802.3, reflected) remainder of each byte
+var crc32Table = &LookupTable{Name: "crc32", Len: 256, Width: 4, Source: `
+const int POLY = 3988292384; // 0xEDB88320
+fn int entry(int i) {
+    int crc = i;
+    for (int bit = 0; bit < 8; bit += 1) {
+        if crc & 1 {
+            crc = (crc >> 1) ^ POLY;
+        } else {
+            crc = crc >> 1;
+        }
+    }
+    return crc;
+}`}
+
+// Label returns the assembly label of the table
+func (t *LookupTable) Label() string {
+	return ".lotus_table_" + t.Name
+}
+
+// Entries runs the table's entry function for each index
+func (t *LookupTable) Entries() ([]int, error) {
+	tokens := Tokenize(t.Source)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("tokenization failed")
+	}
+	program, err := NewParser(tokens).Parse()
+	if err != nil {
+		return nil, err
+	}
+	in := NewInterpreter(program)
+	entries := make([]int, t.Len)
+	for i := range entries {
+		v, err := in.Call("entry", IntValue(i))
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i, err)
+		}
+		entries[i] = v.Int
+	}
+	return entries, nil
+}
+
+// useTable returns the label of table t, which is emitted with the program
+func (cg *CodeGenerator) useTable(t *LookupTable) string {
+	for _, used := range cg.tables {
+		if used == t {
+			return t.Label()
+		}
+	}
+	cg.tables = append(cg.tables, t)
+	return t.Label()
+}
+
+// tableData returns the .rodata holding every table the program uses
+func (cg *CodeGenerator) tableData() string {
+	if len(cg.tables) == 0 {
+		return ""
+	}
+	directive := map[int]string{1: ".byte", 2: ".short", 4: ".long", 8: ".quad"}
+	var b strings.Builder
+	b.WriteString("    .section .rodata\n")
+	for _, t := range cg.tables {
+		entries, err := t.Entries()
+		if err != nil {
+			cg.diagnostics.AddErrorWithCode("", CategoryGeneral,
+				fmt.Sprintf("internal error: lookup table %s: %v", t.Name, err), cg.diagnostics.FilePath, 0, 0, "")
+			continue
+		}
+		fmt.Fprintf(&b, "    .balign %d\n%s:\n", t.Width, t.Label())
+		mask := uint64(1)<<(8*uint(t.Width)) - 1
+		if t.Width == 8 {
+			mask = ^uint64(0)
+		}
+		for i := 0; i < len(entries); i += 8 {
+			values := make([]string, 0, 8)
+			for _, entry := range entries[i:min(i+8, len(entries))] {
+				values = append(values, fmt.Sprintf("0x%0*x", 2*t.Width, uint64(entry)&mask))
+			}
+			fmt.Fprintf(&b, "    %s %s\n", directive[t.Width], strings.Join(values, ", "))
+		}
+	}
+	b.WriteString(DataSectionDirective + "\n")
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package main
+
+import "fmt"
+
+// tailcall.go - Tail-call optimization for self-recursive functions
+// A `return f(args)` inside f reuses f's stack frame: the new arguments are
+// evaluated, stored over the parameter slots, and control jumps back to the
+// start of the body instead of calling. Recursion depth then no longer grows
+// the stack. This applies at -O1 and above, and to any return marked
+// @musttail, which is an error when the call cannot become a jump.
+
+// maxTailCallParams is the number of parameters passed in registers; the rest
+// live in the caller's frame, which a jump cannot rewrite
+const maxTailCallParams = 6
+
+// tailCallProblem explains why ret cannot be compiled as a tail call, or
+// returns "" if it can
+func (cg *CodeGenerator) tailCallProblem(ret *ReturnStatement) string {
+	fn := cg.currentFunction
+	if fn == nil {
+		return "return is not inside a function"
+	}
+	call, ok := ret.Value.(*FunctionCall)
+	if !ok {
+		return "the returned value is not a function call"
+	}
+	if call.Name != fn.Name {
+		return fmt.Sprintf("'%s' is not a call to '%s' itself; only self-recursive calls can be tail calls", call.Name, fn.Name)
+	}
+	if fn.Variadic() {
+		return fmt.Sprintf("'%s' is variadic; its trailing arguments live in the caller's frame", fn.Name)
+	}
+	if len(call.Args) != len(fn.Parameters) {
+		return fmt.Sprintf("'%s' takes %d argument(s), got %d", fn.Name, len(fn.Parameters), len(call.Args))
+	}
+	if len(fn.Parameters) > maxTailCallParams {
+		return fmt.Sprintf("'%s' has more than %d parameters", fn.Name, maxTailCallParams)
+	}
+	if cg.currentFunctionBodyLbl == "" {
+		return "the call is not in a position that can become a jump"
+	}
+	return ""
+}
+
+// generateTailCall rewrites the parameters of the current function with the
+// call's arguments and jumps back to the start of its body. All arguments are
+// evaluated before any parameter is overwritten, since they may read them.
+func (cg *CodeGenerator) generateTailCall(call *FunctionCall) {
+	cg.textSection.WriteString(fmt.Sprintf("    # tail call %s\n", call.Name))
+	for _, arg := range call.Args {
+		cg.generateExpressionToReg(arg, "rax")
+		cg.textSection.WriteString("    pushq %rax\n")
+	}
+	for i := len(call.Args) - 1; i >= 0; i-- {
+		cg.textSection.WriteString(fmt.Sprintf("    popq -%d(%%rbp)\n", (i+1)*8))
+	}
+	cg.textSection.WriteString(fmt.Sprintf("    jmp %s\n", cg.currentFunctionBodyLbl))
+}
+
+// hasSelfTailCall reports whether body returns a call to the function name,
+// which needs a label at the start of the body to jump to
+func hasSelfTailCall(body []ASTNode, name string) bool {
+	for _, node := range body {
+		var nested [][]ASTNode
+		switch n := node.(type) {
+		case *ReturnStatement:
+			if call, ok := n.Value.(*FunctionCall); ok && call.Name == name {
+				return true
+			}
+		case *IfStatement:
+			nested = [][]ASTNode{n.ThenBody, n.ElseBody}
+		case *WhileLoop:
+			nested = [][]ASTNode{n.Body}
+		case *ForLoop:
+			nested = [][]ASTNode{n.Body}
+		case *TryStatement:
+			nested = [][]ASTNode{n.TryBlock, n.FinallyBlock}
+			for _, clause := range n.CatchClauses {
+				nested = append(nested, clause.Body)
+			}
+		}
+		for _, stmts := range nested {
+			if hasSelfTailCall(stmts, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
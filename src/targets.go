@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// targets.go - Compilation targets
+// Each target names an architecture/OS pair, the syscall numbers generated code
+// uses on it, and the compiler driver that assembles and links its output.
+// Code generators name the syscall they make, with asm().LoadSyscall("mmap"),
+// and the number comes from the target's table.
+
+// SyscallTable maps syscall names to their numbers on a target OS/arch
+type SyscallTable map[string]int
+
+var linuxAMD64Syscalls = SyscallTable{
+	"read": 0, "write": 1, "open": 2, "close": 3, "stat": 4, "fstat": 5, "poll": 7, "lseek": 8, "mmap": 9,
+	"mprotect": 10, "munmap": 11, "rt_sigaction": 13, "rt_sigreturn": 15, "ioctl": 16, "pread64": 17,
+	"madvise": 28, "dup2": 33, "nanosleep": 35, "setitimer": 38, "getpid": 39, "sendfile": 40, "socket": 41,
+	"connect": 42, "accept": 43, "sendto": 44, "recvfrom": 45, "bind": 49, "listen": 50, "fork": 57,
+	"execve": 59, "exit": 60, "wait4": 61, "kill": 62, "fcntl": 72, "flock": 73, "fsync": 74, "ftruncate": 77,
+	"getcwd": 79, "chdir": 80, "rename": 82, "mkdir": 83, "unlink": 87, "getrusage": 98, "setsid": 112,
+	"mlock": 149, "munlock": 150, "prctl": 157, "time": 201, "futex": 202, "getdents64": 217,
+	"clock_gettime": 228, "exit_group": 231, "openat": 257, "timerfd_create": 283, "timerfd_settime": 286,
+	"eventfd2": 290, "pipe2": 293, "prlimit64": 302, "getrandom": 318, "copy_file_range": 326,
+}
+
+// linuxARM64Syscalls uses the generic table, which has no open, stat, poll,
+// dup2, fork, mkdir, unlink, rename or time; their *at and other
+// replacements are listed instead
+var linuxARM64Syscalls = SyscallTable{
+	"getcwd": 17, "eventfd2": 19, "dup3": 24, "fcntl": 25, "ioctl": 29, "flock": 32, "mkdirat": 34,
+	"unlinkat": 35, "renameat": 38, "ftruncate": 46, "chdir": 49, "openat": 56, "close": 57, "pipe2": 59,
+	"getdents64": 61, "lseek": 62, "read": 63, "write": 64, "pread64": 67, "sendfile": 71, "ppoll": 73,
+	"fstat": 80, "fsync": 82, "timerfd_create": 85, "timerfd_settime": 86, "exit": 93, "exit_group": 94,
+	"futex": 98, "nanosleep": 101, "setitimer": 103, "clock_gettime": 113, "rt_sigaction": 134,
+	"rt_sigreturn": 139, "setsid": 157, "getrusage": 165, "prctl": 167, "getpid": 172, "socket": 198,
+	"bind": 200, "listen": 201, "accept": 202, "connect": 203, "sendto": 206, "recvfrom": 207, "munmap": 215,
+	"clone": 220, "execve": 221, "mmap": 222, "mprotect": 226, "mlock": 228, "munlock": 229, "madvise": 233,
+	"wait4": 260, "prlimit64": 261, "kill": 129, "getrandom": 278, "copy_file_range": 285,
+}
+
+// Target describes one entry of the -target matrix
+type Target struct {
+	Triple    string       // Canonical name, e.g. x86_64-linux
+	Aliases   []string     // Other accepted spellings
+	Arch      string       // x86_64 or aarch64
+	OS        string       // linux, or none for freestanding
+	Driver    string       // Compiler driver used to assemble and link
+	LinkFlags []string     // Flags placed before the output and input files
+	Syscalls  SyscallTable // nil when there is no OS to call
+	Codegen   bool         // false when the code generator cannot emit this arch yet
+}
+
+// Freestanding reports whether the target has no operating system
+func (t *Target) Freestanding() bool {
+	return t.OS == "none"
+}
+
+// Syscall returns the syscall number for name, or false if the target lacks it
+func (t *Target) Syscall(name string) (int, bool) {
+	n, ok := t.Syscalls[name]
+	return n, ok
+}
+
+// CheckToolchain verifies that the target's driver is installed
+func (t *Target) CheckToolchain() error {
+	if _, err := exec.LookPath(t.Driver); err != nil {
+		return fmt.Errorf("toolchain for %s not found: %s is not in PATH (install it, or pass -S to emit assembly only)",
+			t.Triple, t.Driver)
+	}
+	return nil
+}
+
+// CanRunOnHost reports whether binaries for the target run on this machine
+func (t *Target) CanRunOnHost() bool {
+	hostArch := map[string]string{"amd64": "x86_64", "arm64": "aarch64"}[runtime.GOARCH]
+	return t.OS == runtime.GOOS && t.Arch == hostArch
+}
+
+// Targets is the supported target matrix
+var Targets = []*Target{
+	{
+		Triple:    "x86_64-linux",
+		Aliases:   []string{"linux-amd64", "amd64-linux", "x86_64-linux-gnu"},
+		Arch:      "x86_64",
+		OS:        "linux",
+		Driver:    "gcc",
+		LinkFlags: []string{"-nostartfiles", "-no-pie"},
+		Syscalls:  linuxAMD64Syscalls,
+		Codegen:   true,
+	},
+	{
+		Triple:    "aarch64-linux",
+		Aliases:   []string{"linux-arm64", "arm64-linux", "aarch64-linux-gnu"},
+		Arch:      "aarch64",
+		OS:        "linux",
+		Driver:    "aarch64-linux-gnu-gcc",
+		LinkFlags: []string{"-nostartfiles", "-no-pie"},
+		Syscalls:  linuxARM64Syscalls,
+		Codegen:   false,
+	},
+	{
+		Triple:    "x86_64-none",
+		Aliases:   []string{"freestanding", "x86_64-freestanding", "x86_64-elf"},
+		Arch:      "x86_64",
+		OS:        "none",
+		Driver:    "gcc",
+		LinkFlags: []string{"-nostdlib", "-static", "-no-pie", "-ffreestanding"},
+		Codegen:   true,
+	},
+}
+
+// LookupTarget finds a target by triple or alias
+func LookupTarget(name string) (*Target, error) {
+	for _, t := range Targets {
+		if t.Triple == name {
+			return t, nil
+		}
+		for _, alias := range t.Aliases {
+			if alias == name {
+				return t, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported target %q (supported: %s)", name, strings.Join(TargetNames(), ", "))
+}
+
+// freestandingTarget returns the no-OS target for an architecture
+func freestandingTarget(arch string) (*Target, error) {
+	for _, t := range Targets {
+		if t.Arch == arch && t.Freestanding() {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no freestanding target for %s", arch)
+}
+
+// TargetNames lists the canonical target triples
+func TargetNames() []string {
+	names := make([]string, 0, len(Targets))
+	for _, t := range Targets {
+		names = append(names, t.Triple)
+	}
+	return names
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// test.go - `lotus test` subcommand
+// Each *_test.lts file is a program whose main returns 0 when its checks
+// pass. The runner builds and runs every test file it is given (or finds in
+// the given directories) and reports which failed. With -cover, the programs
+// are instrumented and the runner reports how much of each source module the
+// tests executed.
+
+// TestFileSuffix marks the source files `lotus test` runs
+const TestFileSuffix = "_test.lts"
+
+func init() {
+	Subcommands["test"] = &Subcommand{
+		Name:    "test",
+		Summary: "build and run *" + TestFileSuffix + " programs ([-cover] [-cover-report] [-v] [paths])",
+		Run:     runTest,
+	}
+}
+
+// runTest implements `lotus test [-cover] [-cover-report] [-v] [-I dir] [paths]`
+func runTest(args []string) int {
+	fs := flag.NewFlagSet("lotus test", flag.ContinueOnError)
+	cover := fs.Bool("cover", false, "report the share of statements the tests run")
+	coverReport := fs.Bool("cover-report", false, "also print each covered file annotated with execution counts (implies -cover)")
+	verbose := fs.Bool("v", false, "show the output of passing tests")
+	var includeDirs []string
+	fs.Func("I", "add include `dir` to search path (repeatable)", func(val string) error {
+		includeDirs = append(includeDirs, val)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	*cover = *cover || *coverReport
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := findTestFiles(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no *%s files in %s\n", TestFileSuffix, strings.Join(paths, ", "))
+		return 1
+	}
+
+	workDir, err := os.MkdirTemp("", "lotus-test")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(workDir)
+
+	profile := NewCoverageProfile()
+	failed := 0
+	for i, file := range files {
+		opts, _, err := ParseFlags(nil)
+		if err != nil {
+			return 2
+		}
+		opts.IncludeDirs = includeDirs
+		opts.OutPath = filepath.Join(workDir, fmt.Sprintf("test%d", i))
+		if *cover {
+			opts.CoverageFile = opts.OutPath + ".cov"
+		}
+
+		ok, output := runTestFile(file, opts, profile)
+		status := "ok  "
+		if !ok {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s %s\n", status, file)
+		if !ok || *verbose {
+			os.Stdout.Write(output)
+		}
+	}
+
+	if *cover {
+		PrintCoverageSummary(os.Stdout, profile)
+	}
+	if *coverReport {
+		for _, file := range profile.Files() {
+			if err := PrintAnnotatedSource(os.Stdout, profile, file); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d test file(s) failed\n", failed, len(files))
+		return 1
+	}
+	return 0
+}
+
+// runTestFile builds and runs one test program, adding its counts to profile
+// when coverage is on. It returns whether the test passed and its output.
+func runTestFile(file string, opts *CompilerOptions, profile *CoverageProfile) (bool, []byte) {
+	compiler := NewCompiler(opts)
+	if err := compiler.CompileFile(file); err != nil {
+		return false, []byte(fmt.Sprintf("    build failed: %v\n", err))
+	}
+	if compiler.Coverage != nil {
+		if err := CreateCoverageFile(opts.CoverageFile, compiler.Coverage); err != nil {
+			return false, []byte(fmt.Sprintf("    %v\n", err))
+		}
+	}
+
+	output, err := exec.Command(opts.OutPath).CombinedOutput()
+	passed := err == nil
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		output = append(output, fmt.Sprintf("    exit status %d\n", exitErr.ExitCode())...)
+	} else if err != nil {
+		output = append(output, fmt.Sprintf("    %v\n", err)...)
+	}
+
+	// A failing test still executed code, so its counts are kept
+	if compiler.Coverage != nil {
+		counts, err := ReadCoverageCounts(opts.CoverageFile, compiler.Coverage)
+		if err != nil {
+			return false, append(output, fmt.Sprintf("    %v\n", err)...)
+		}
+		profile.Add(compiler.Coverage, counts)
+	}
+	return passed, output
+}
+
+// findTestFiles expands paths into the test files to run: files are taken as
+// given, and directories contribute their *_test.lts files
+func findTestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*"+TestFileSuffix))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
@@ -16,6 +16,7 @@ func Tokenize(input string) []Token {
 	// Track line and column for error messages
 	line := 1
 	col := 1
+	lineStart := 0 // Rune index of the first character on the current line
 	startLine := 1
 	startCol := 1
 
@@ -24,8 +25,19 @@ func Tokenize(input string) []Token {
 		return Token{Type: t, Value: v, Line: startLine, Column: startCol}
 	}
 
-	for i := 0; i < len(runes); i++ {
+	// A #! first line, as in #!/usr/bin/env lotus, is for the kernel
+	start := 0
+	if strings.HasPrefix(input, "#!") {
+		for start < len(runes) && runes[start] != '\n' {
+			start++
+		}
+	}
+
+	for i := start; i < len(runes); i++ {
 		c := runes[i]
+		// Columns are derived from the line start so multi-character tokens
+		// don't skew the positions of everything after them
+		col = i - lineStart + 1
 		startLine = line
 		startCol = col
 
@@ -33,6 +45,7 @@ func Tokenize(input string) []Token {
 			tokens = append(tokens, makeToken(TokenNewline, ""))
 			line++
 			col = 1
+			lineStart = i + 1
 			continue
 		}
 
@@ -186,7 +199,7 @@ func Tokenize(input string) []Token {
 				tokens = append(tokens, makeToken(TokenTypeChar, ""))
 			case "bool":
 				tokens = append(tokens, makeToken(TokenTypeBool, ""))
-			case "float":
+			case "float", "float64":
 				tokens = append(tokens, makeToken(TokenTypeFloat, ""))
 			case "struct":
 				tokens = append(tokens, makeToken(TokenStruct, ""))
@@ -210,6 +223,10 @@ func Tokenize(input string) []Token {
 				tokens = append(tokens, makeToken(TokenUse, ""))
 			case "as":
 				tokens = append(tokens, makeToken(TokenAs, ""))
+			case "comptime":
+				tokens = append(tokens, makeToken(TokenComptime, ""))
+			case "goto":
+				tokens = append(tokens, makeToken(TokenGoto, ""))
 			case "try":
 				tokens = append(tokens, makeToken(TokenTry, ""))
 			case "catch":
@@ -256,6 +273,20 @@ func Tokenize(input string) []Token {
 				buf.WriteRune(runes[i])
 				i++
 			}
+			// An exponent makes a float too: 1e9, 2.5E-3
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				j := i + 1
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				if j < len(runes) && unicode.IsDigit(runes[j]) {
+					isFloat = true
+					for i < j || i < len(runes) && unicode.IsDigit(runes[i]) {
+						buf.WriteRune(runes[i])
+						i++
+					}
+				}
+			}
 			i-- // Compensate for the loop increment
 
 			numStr := buf.String()
@@ -408,6 +439,8 @@ func Tokenize(input string) []Token {
 			tokens = append(tokens, makeToken(TokenTilde, ""))
 		} else if c == '?' {
 			tokens = append(tokens, makeToken(TokenQuestion, ""))
+		} else if c == '@' {
+			tokens = append(tokens, makeToken(TokenAt, ""))
 		} else {
 			fmt.Fprintf(os.Stderr, "line %d, col %d: unable to parse '%c'\n", line, col, c)
 			return []Token{}
@@ -578,6 +611,8 @@ func TokenValue(t Token) string {
 		return "%="
 	case TokenQuestion:
 		return "?"
+	case TokenAt:
+		return "@"
 	case TokenIf:
 		return "if"
 	case TokenElse:
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// trace.go - Stdlib call tracing (-trace-stdlib)
+// After each call into a stdlib module, a stub writes a line to stderr:
+//
+//	[trace] collections::array_int_push(140737354125312, 7) = 1
+//
+// Literal arguments are printed as written. Other arguments are printed as
+// integers (addresses, for pointers and strings) when they can be evaluated
+// again without side effects, and as _ when they cannot; an argument that is
+// itself a stdlib call has its own line just above. The stub saves and
+// restores every register and the flags, so the traced code runs unchanged.
+
+// traceSyscalls are the system calls the tracing stubs make
+var traceSyscalls = []string{"write"}
+
+// traceSavedRegs are saved around a stub, in push order
+var traceSavedRegs = []string{"rax", "rbx", "rcx", "rdx", "rsi", "rdi", "rbp",
+	"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"}
+
+// traceStdlibSupported reports why target cannot trace stdlib calls, or "" if it can
+func traceStdlibSupported(target *Target) string {
+	for _, name := range traceSyscalls {
+		if _, ok := target.Syscall(name); !ok {
+			return fmt.Sprintf("-trace-stdlib needs the %s system call, which %s does not have", name, target.Triple)
+		}
+	}
+	return ""
+}
+
+// generateStdlibCall emits a call to a stdlib module function, expanded in
+// place or outlined, followed by its trace stub under -trace-stdlib
+func (cg *CodeGenerator) generateStdlibCall(call *FunctionCall, fn *StdlibFunction) {
+	cg.requireModuleLibs(call, fn)
+	start := cg.textSection.Len()
+	if o := cg.outline(fn, len(call.Args)); o != nil {
+		cg.generateOutlinedCall(call, o)
+	} else {
+		cg.generateBuiltinCall(call, fn.CodeGen)
+	}
+	cg.noteStdlibSize(fn, cg.textSection.String()[start:])
+	if cg.traceStdlib {
+		cg.generateTraceStub(fn.Module+"::"+fn.Name, call.Args)
+	}
+}
+
+// generateTraceStub logs a finished call and the value it left in %rax
+func (cg *CodeGenerator) generateTraceStub(name string, args []ASTNode) {
+	cg.textSection.WriteString(fmt.Sprintf("    # trace %s\n", name))
+	cg.textSection.WriteString("    pushfq\n")
+	for _, reg := range traceSavedRegs {
+		cg.textSection.WriteString(fmt.Sprintf("    pushq %%%s\n", reg))
+	}
+
+	// Fixed text is gathered up and written between runtime values
+	var text strings.Builder
+	flush := func() {
+		if text.Len() == 0 {
+			return
+		}
+		label, _ := emitStringLiteral(cg, text.String())
+		cg.textSection.WriteString(fmt.Sprintf("    leaq %s(%%rip), %%rsi\n", label))
+		cg.textSection.WriteString("    call .lotus_rt_puts\n")
+		text.Reset()
+	}
+	putValue := func() {
+		cg.textSection.WriteString("    movq %rax, %rdi\n")
+		cg.textSection.WriteString("    call .lotus_rt_putint\n")
+	}
+
+	text.WriteString("[trace] " + name + "(")
+	for i, arg := range args {
+		if i > 0 {
+			text.WriteString(", ")
+		}
+		if lit, ok := traceLiteral(arg); ok {
+			text.WriteString(lit)
+		} else if cg.tracePure(arg) {
+			flush()
+			cg.generateExpressionToReg(arg, "rax")
+			putValue()
+		} else {
+			text.WriteString("_")
+		}
+	}
+	text.WriteString(") = ")
+	flush()
+	cg.textSection.WriteString(fmt.Sprintf("    movq %d(%%rsp), %%rax  # result\n", 8*(len(traceSavedRegs)-1)))
+	putValue()
+	text.WriteString("\n")
+	flush()
+
+	for i := len(traceSavedRegs) - 1; i >= 0; i-- {
+		cg.textSection.WriteString(fmt.Sprintf("    popq %%%s\n", traceSavedRegs[i]))
+	}
+	cg.textSection.WriteString("    popfq\n")
+}
+
+// traceLiteral renders a literal argument as it appears in source
+func traceLiteral(arg ASTNode) (string, bool) {
+	switch a := arg.(type) {
+	case *IntLiteral:
+		return strconv.Itoa(a.Value), true
+	case *StringLiteral:
+		return strconv.Quote(a.Value), true
+	case *CharLiteral:
+		return "'" + a.Value + "'", true
+	case *BoolLiteral:
+		return strconv.FormatBool(a.Value), true
+	case *NullLiteral:
+		return "null", true
+	}
+	return "", false
+}
+
+// tracePure reports whether arg can be evaluated a second time without side
+// effects or faults: variables, and arithmetic on them
+func (cg *CodeGenerator) tracePure(arg ASTNode) bool {
+	switch a := arg.(type) {
+	case *IntLiteral:
+		return true
+	case *Identifier:
+		_, isVar := cg.variables[a.Name]
+		_, isConst := cg.constants[a.Name]
+		return isVar || isConst
+	case *BinaryOp:
+		return cg.tracePure(a.Left) && cg.tracePure(a.Right)
+	case *BitwiseOp:
+		return cg.tracePure(a.Left) && cg.tracePure(a.Right)
+	case *UnaryOp:
+		return (a.Operator == TokenMinus || a.Operator == TokenTilde || a.Operator == TokenExclaim) && cg.tracePure(a.Operand)
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// typecheck.go - Argument counts and value types
+// Semantic analysis checks each call against the function it resolves to
+// before any code is generated. A user function takes one argument per
+// parameter, or at least one per parameter before a variadic one; a stdlib
+// function takes NumArgs of them unless it is variadic. Each argument must
+// have the type the callee declares: a user function's parameter types, or
+// a stdlib function's ArgTypes, where an argument left out is an integer or
+// handle. The values of declarations and assignments are checked the same
+// way.
+//
+// Types are inferred from literals, declared variables, parameters and
+// constants, operators, and the results of functions that declare one
+// (RetType for stdlib functions). They are compared by class: integers of
+// every width, char and bool all hold integers and mix freely, strings are
+// apart from them, and a value whose type is not known fits anywhere. An
+// integer also fits where a float is wanted and is converted (float.go); a
+// float never fits where an integer is, and needs int() to truncate it.
+// Programs keep addresses, such as buffers from mem::alloc, in 64-bit
+// integers, so a string and a 64-bit integer variable fit each other; only a
+// literal is held to its own type:
+//
+//	fn int twice(int x) { ret x * 2; }
+//
+//	twice("4");          // error: cannot use 'string' value as 'int' ...
+//	twice(1, 2);         // error: 'twice' takes 1 argument(s), got 2
+//	twice(2.5);          // error: cannot use 'float' value as 'int' ...
+//	str::len(42);        // error: cannot use 'int' value as 'string' ...
+//	string s = buf;      // int buf = mem::alloc(16): fine
+//	float f = 1;         // fine: 1.0
+//
+// Newtypes are told apart by name in typedefs.go; a value of one is left to
+// that check.
+
+// typeClass groups the types whose values mix freely, returning "" for types
+// the checker does not compare
+func typeClass(t TokenType) string {
+	switch {
+	case IsIntegerType(t), t == TokenTypeChar, t == TokenTypeBool:
+		return "integer"
+	case t == TokenTypeString:
+		return "string"
+	case t == TokenTypeFloat:
+		return "float"
+	}
+	return ""
+}
+
+// holdsAddress reports whether values of type t can be addresses
+func holdsAddress(t TokenType) bool {
+	switch t {
+	case TokenTypeInt, TokenTypeInt64, TokenTypeUint, TokenTypeUint64:
+		return true
+	}
+	return false
+}
+
+// isLiteral reports whether expr is written as a literal value
+func isLiteral(expr ASTNode) bool {
+	switch expr.(type) {
+	case *IntLiteral, *StringLiteral, *CharLiteral, *BoolLiteral, *FloatLiteral:
+		return true
+	}
+	return false
+}
+
+// typeOf infers the type of expr, or returns TokenEOF when it is not known
+func (sa *SemanticAnalyzer) typeOf(expr ASTNode) TokenType {
+	switch e := expr.(type) {
+	case *IntLiteral:
+		return TokenTypeInt
+	case *CharLiteral:
+		return TokenTypeChar
+	case *StringLiteral:
+		return TokenTypeString
+	case *FloatLiteral:
+		return TokenTypeFloat
+	case *BoolLiteral, *Comparison, *LogicalOp:
+		return TokenTypeBool
+	case *Conversion:
+		return e.Type
+	case *Unwrap:
+		return sa.typeOf(e.Value)
+	case *Identifier:
+		if info := sa.lookupSymbol(e.Name); info != nil && info.Kind != SymbolFunction {
+			return info.Type
+		}
+	case *FunctionCall:
+		if fn := sa.calledFunction(e); fn != nil {
+			return fn.ReturnType
+		}
+		if fn := sa.stdlibCallee(e); fn != nil && typeClass(fn.RetType) != "" {
+			return fn.RetType
+		}
+	case *BinaryOp:
+		// A string plus an integer is a pointer into the string, so only
+		// numeric arithmetic has a known type
+		left, right := typeClass(sa.typeOf(e.Left)), typeClass(sa.typeOf(e.Right))
+		switch {
+		case left == "integer" && right == "integer":
+			return TokenTypeInt
+		case left == "float" && (right == "float" || right == "integer"),
+			right == "float" && left == "integer":
+			return TokenTypeFloat
+		}
+	case *UnaryOp:
+		switch e.Operator {
+		case TokenMinus, TokenTilde:
+			switch typeClass(sa.typeOf(e.Operand)) {
+			case "integer":
+				return TokenTypeInt
+			case "float":
+				if e.Operator == TokenMinus {
+					return TokenTypeFloat
+				}
+			}
+		case TokenExclaim:
+			return TokenTypeBool
+		}
+	}
+	return TokenEOF
+}
+
+// checkCall checks the number and types of call's arguments against the
+// user or stdlib function it calls
+func (sa *SemanticAnalyzer) checkCall(call *FunctionCall) {
+	if fn := sa.calledFunction(call); fn != nil {
+		sa.checkUserCall(call, fn)
+	} else if fn := sa.stdlibCallee(call); fn != nil {
+		sa.checkStdlibCall(call, fn)
+	}
+}
+
+// checkUserCall checks a call to a user function against its parameters
+func (sa *SemanticAnalyzer) checkUserCall(call *FunctionCall, fn *FunctionDefinition) {
+	name, _, _ := strings.Cut(fn.Name, ".") // Overloads are mangled
+	fixed := fn.Parameters
+	if fn.Variadic() {
+		fixed = fixed[:len(fixed)-1]
+	}
+	if len(call.Args) < len(fixed) || !fn.Variadic() && len(call.Args) > len(fixed) {
+		sa.argumentCountError(call, name, len(fixed), fn.Variadic())
+		return
+	}
+	for i, arg := range call.Args {
+		param := fn.Parameters[min(i, len(fn.Parameters)-1)]
+		sa.checkValue(param.Type, param.TypeName, arg, fmt.Sprintf("for parameter '%s' of '%s'", param.Name, name))
+	}
+}
+
+// checkStdlibCall checks a call to a stdlib function against its NumArgs and
+// ArgTypes. Arguments past the end of ArgTypes are integers or handles, so
+// only the strings, buffers and values of any type need listing; a variadic
+// function without ArgTypes, such as io::printf, takes anything.
+func (sa *SemanticAnalyzer) checkStdlibCall(call *FunctionCall, fn *StdlibFunction) {
+	name := fn.Module + "::" + fn.Name
+	if fn.NumArgs >= 0 && len(call.Args) != fn.NumArgs {
+		sa.argumentCountError(call, name, fn.NumArgs, false)
+		return
+	}
+	for i, arg := range call.Args {
+		want := TokenTypeInt
+		switch {
+		case i < len(fn.ArgTypes):
+			want = fn.ArgTypes[i]
+		case fn.NumArgs < 0 && len(fn.ArgTypes) > 0:
+			want = fn.ArgTypes[len(fn.ArgTypes)-1] // The last type repeats
+		case fn.NumArgs < 0:
+			want = TokenEOF
+		}
+		sa.checkValue(want, "", arg, fmt.Sprintf("for argument %d of '%s'", i+1, name))
+	}
+}
+
+// checkValue reports value used where a value of type want, declared as the
+// newtype newtype if that is not "", is needed, when their types are of
+// different classes
+func (sa *SemanticAnalyzer) checkValue(want TokenType, newtype string, value ASTNode, context string) {
+	if value == nil {
+		return
+	}
+	have := sa.typeOf(value)
+	if typeClass(want) == "" || typeClass(have) == "" || typeClass(want) == typeClass(have) {
+		return
+	}
+	if typeClass(want) == "float" && typeClass(have) == "integer" {
+		return // Converted
+	}
+	if !isLiteral(value) && (holdsAddress(want) && have == TokenTypeString || want == TokenTypeString && holdsAddress(have)) {
+		return
+	}
+	// Named values are compared with newtypes by name, in typedefs.go
+	if named := sa.exprType(value); named != "" && (newtype != "" || sa.newtypes[named]) {
+		return
+	}
+	wantName := TokenTypeName(want)
+	if newtype != "" {
+		wantName = "'" + newtype + "'"
+	}
+	sa.typeError(exprStart(value), fmt.Sprintf("cannot use %s value as %s %s", TokenTypeName(have), wantName, context))
+}
+
+// checkFloatOperator reports % on floats, which has no SSE instruction
+func (sa *SemanticAnalyzer) checkFloatOperator(op *BinaryOp) {
+	if op.Operator != TokenPercent {
+		return
+	}
+	for _, operand := range []ASTNode{op.Left, op.Right} {
+		if typeClass(sa.typeOf(operand)) == "float" {
+			sa.typeError(exprStart(operand), "operator % is not defined on float values")
+			return
+		}
+	}
+}
+
+// argumentCountError reports a call to name with the wrong number of
+// arguments for a function taking want of them, or at least want when it is
+// variadic
+func (sa *SemanticAnalyzer) argumentCountError(call *FunctionCall, name string, want int, variadic bool) {
+	loc := call.NameLoc
+	if loc.Line == 0 {
+		loc.Line = sa.currentLine
+	}
+	takes := fmt.Sprint(want)
+	if variadic {
+		takes = "at least " + takes
+	}
+	sa.diagnostics.AddErrorWithCode(string(ErrArgumentCount), CategorySemantic,
+		fmt.Sprintf("'%s' takes %s argument(s), got %d", name, takes, len(call.Args)),
+		sa.filePath, loc.Line, loc.Column, sa.getSourceLine(loc.Line))
+}
+
+// exprStart returns the located node an expression starts with; operators
+// carry no position of their own
+func exprStart(expr ASTNode) ASTNode {
+	for expr.Loc().Line == 0 {
+		switch e := expr.(type) {
+		case *BinaryOp:
+			expr = e.Left
+		case *Comparison:
+			expr = e.Left
+		case *LogicalOp:
+			expr = e.Left
+		default:
+			return expr
+		}
+	}
+	return expr
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// analyze parses and checks source as the compiler does, returning the
+// diagnostics it reports
+func analyze(t *testing.T, source string) *DiagnosticManager {
+	t.Helper()
+	opts, _, err := ParseFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCompiler(opts)
+	path := filepath.Join(t.TempDir(), "main.lts")
+	dm := c.newDiagnosticManager(path, source)
+	c.generate(Tokenize(source), dm, path, source)
+	return dm
+}
+
+// errorMessages returns the messages of the errors in dm
+func errorMessages(dm *DiagnosticManager) []string {
+	var msgs []string
+	for _, d := range dm.Diagnostics {
+		if d.Level == DiagnosticError {
+			msgs = append(msgs, d.Message)
+		}
+	}
+	return msgs
+}
+
+// stdlibArgTests has a call passing a wrong-typed argument for every stdlib
+// module, and the start of the error it gets
+var stdlibArgTests = map[string]struct{ call, want string }{
+	"io":          {`io::hexdump("abc", "3")`, "cannot use 'string' value as 'int' for argument 2 of 'io::hexdump'"},
+	"mem":         {`mem::malloc("16")`, "cannot use 'string' value as 'int' for argument 1 of 'mem::malloc'"},
+	"math":        {`math::abs("5")`, "cannot use 'string' value as 'int' for argument 1 of 'math::abs'"},
+	"str":         {`str::len(42)`, "cannot use 'int' value as 'string' for argument 1 of 'str::len'"},
+	"num":         {`num::htons("80")`, "cannot use 'string' value as 'int' for argument 1 of 'num::htons'"},
+	"hash":        {`hash::djb2(7)`, "cannot use 'int' value as 'string' for argument 1 of 'hash::djb2'"},
+	"compress":    {`compress::gzip_bound("64")`, "cannot use 'string' value as 'int' for argument 1 of 'compress::gzip_bound'"},
+	"archive":     {`archive::tar_create(3)`, "cannot use 'int' value as 'string' for argument 1 of 'archive::tar_create'"},
+	"db":          {`db::exec(0, 1)`, "cannot use 'int' value as 'string' for argument 2 of 'db::exec'"},
+	"kv":          {`kv::open(2.5)`, "cannot use 'float' value as 'string' for argument 1 of 'kv::open'"},
+	"collections": {`collections::hashmap_int_new("x")`, "cannot use 'string' value as 'int' for argument 1 of 'collections::hashmap_int_new'"},
+	"net":         {`net::socket(2, "stream", 0)`, "cannot use 'string' value as 'int' for argument 2 of 'net::socket'"},
+	"http":        {`http::fetch("http://example.com/", "buf", 64, 5)`, "cannot use 'string' value as 'int' for argument 2 of 'http::fetch'"},
+	"file":        {`file::open("notes.txt", "r")`, "cannot use 'string' value as 'int' for argument 2 of 'file::open'"},
+	"os":          {`os::exit("1")`, "cannot use 'string' value as 'int' for argument 1 of 'os::exit'"},
+	"proc":        {`proc::self_status(1)`, "cannot use 'int' value as 'string' for argument 1 of 'proc::self_status'"},
+	"rl":          {`rl::read_line("> ", "buf", 64)`, "cannot use 'string' value as 'int' for argument 2 of 'rl::read_line'"},
+	"time":        {`time::sleep(1.5)`, "cannot use 'float' value as 'int' for argument 1 of 'time::sleep'"},
+	"event":       {`event::wait("fd")`, "cannot use 'string' value as 'int' for argument 1 of 'event::wait'"},
+	"metrics":     {`metrics::counter_new(1)`, "cannot use 'int' value as 'string' for argument 1 of 'metrics::counter_new'"},
+	"log":         {`log::set_level("warn")`, "cannot use 'string' value as 'int' for argument 1 of 'log::set_level'"},
+	"msgpack":     {`msgpack::encode_str(0, 16, 5)`, "cannot use 'int' value as 'string' for argument 3 of 'msgpack::encode_str'"},
+	"pb":          {`pb::write_tag(0, 16, "id", 0)`, "cannot use 'string' value as 'int' for argument 3 of 'pb::write_tag'"},
+}
+
+func TestStdlibArgumentTypes(t *testing.T) {
+	for module := range StandardLibrary {
+		if _, ok := stdlibArgTests[module]; !ok {
+			t.Errorf("no argument type test for module %s", module)
+		}
+	}
+	for module, tc := range stdlibArgTests {
+		t.Run(module, func(t *testing.T) {
+			msgs := errorMessages(analyze(t, "fn int main() {\n    "+tc.call+";\n    ret 0;\n}\n"))
+			if len(msgs) != 1 || !strings.HasPrefix(msgs[0], tc.want) {
+				t.Errorf("%s: got errors %q, want one starting %q", tc.call, msgs, tc.want)
+			}
+		})
+	}
+}
+
+func TestStdlibArgumentTypesAccepted(t *testing.T) {
+	// Strings and buffers held in int variables, null pointers where a
+	// function takes them, and values of any type in containers
+	source := `fn int main() {
+    int buf = mem::malloc(64);
+    string name = "notes.txt";
+    int fd = file::open(name, 0);
+    file::write(1, "hi\n", 3);
+    file::write(1, buf, 0);
+    io::hexdump("abc", 3);
+    int m = collections::hashmap_str_new(8);
+    collections::hashmap_str_put(m, "k", "v");
+    collections::hashmap_str_put(m, name, 7);
+    int a = collections::array_int_new(4);
+    collections::array_int_push(a, "ls");
+    int p = mem::shm_create(0, 4096);
+    pb::write_string(buf, 64, 1, 0);
+    http::fetch("http://example.com/", buf, 64, 5, 0, 0);
+    ret 0;
+}
+`
+	if msgs := errorMessages(analyze(t, source)); len(msgs) > 0 {
+		t.Errorf("got errors %q", msgs)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// typedefs.go - Type aliases and newtypes
+// A file can name types of its own at the top level:
+//
+//	type Count = int;  // alias: another name for int
+//	type Fd int;       // newtype: an int the checker keeps apart
+//
+//	Fd sock = Fd(net::socket(2, 1, 0));
+//	int raw = int(sock);
+//
+// An alias is interchangeable with its type. A newtype has its type's
+// representation but is distinct from it and from every other newtype, so an
+// Fd cannot be stored in an int, passed for a Bytes parameter or added to a
+// pool handle without a conversion, written as a call to the type: Fd(n),
+// int(fd). Literals fit any type, and stdlib functions take and return plain
+// values, so newtypes pass to them unconverted but their results need one.
+//
+// Type names are resolved before parsing: each use becomes the underlying
+// type's token, carrying a newtype's name as its value, and the parser
+// records that name on declarations. The checks run during semantic analysis.
+// Conversions cost nothing; code generation only evaluates the value.
+
+// Conversion converts a value to another type: Fd(n), int(fd)
+type Conversion struct {
+	BaseNode
+	Type     TokenType
+	TypeName string // Newtype converted to, or ""
+	Value    ASTNode
+}
+
+func (c *Conversion) astNode() {}
+
+// declareTypes reads the file's type declarations, removes them from the
+// token stream and replaces each use of a declared name with the token of
+// the type it stands for
+func (p *Parser) declareTypes() error {
+	types := make(map[string]Token) // Declared name -> token it becomes
+	kept := p.tokens[:0:0]
+	depth := 0
+	for i := 0; i < len(p.tokens); i++ {
+		switch p.tokens[i].Type {
+		case TokenLBrace:
+			depth++
+		case TokenRBrace:
+			depth--
+		}
+		if depth != 0 || !p.isTypeDeclaration(i) {
+			kept = append(kept, p.tokens[i])
+			continue
+		}
+
+		// type name [=] base [;]
+		name, j := p.tokens[i+1], i+2
+		alias := p.tokens[j].Type == TokenAssign
+		if alias {
+			j++
+		}
+		base := p.tokens[j]
+		if t, ok := types[base.Value]; ok && base.Type == TokenIdentifier {
+			base = t
+		}
+		if !isTypeToken(base.Type) {
+			p.pos = j
+			return p.formatErrorWithCode(ErrExpectedToken, "expected type after 'type "+name.Value+"', got "+TokenTypeName(base.Type))
+		}
+		if _, ok := types[name.Value]; ok {
+			p.pos = i + 1
+			return p.formatErrorWithCode(ErrRedefinition, fmt.Sprintf("type '%s' is already declared", name.Value))
+		}
+		if alias {
+			types[name.Value] = Token{Type: base.Type, Value: base.Value}
+		} else {
+			types[name.Value] = Token{Type: base.Type, Value: name.Value}
+		}
+		i = j
+		if i+1 < len(p.tokens) && p.tokens[i+1].Type == TokenSemi {
+			i++
+		}
+	}
+
+	for i, tok := range kept {
+		if t, ok := types[tok.Value]; ok && tok.Type == TokenIdentifier {
+			kept[i].Type, kept[i].Value = t.Type, t.Value
+		}
+	}
+	p.tokens = kept
+	return nil
+}
+
+// isTypeDeclaration reports whether a type declaration starts at token i:
+// the word type at the start of a statement, then a name
+func (p *Parser) isTypeDeclaration(i int) bool {
+	if i+3 >= len(p.tokens) {
+		return false
+	}
+	tok, name := p.tokens[i], p.tokens[i+1]
+	if tok.Type != TokenIdentifier || tok.Value != "type" || name.Type != TokenIdentifier {
+		return false
+	}
+	if i > 0 {
+		switch p.tokens[i-1].Type {
+		case TokenSemi, TokenNewline, TokenRBrace:
+		default:
+			return false
+		}
+	}
+	switch p.tokens[i+2].Type {
+	case TokenAssign, TokenIdentifier:
+		return true
+	}
+	return isTypeToken(p.tokens[i+2].Type)
+}
+
+// isConversion reports whether a conversion comes next: type(
+func (p *Parser) isConversion() bool {
+	return isTypeToken(p.current().Type) && p.peek().Type == TokenLParen
+}
+
+// parseConversion parses type(value)
+func (p *Parser) parseConversion() (*Conversion, error) {
+	tok := p.current()
+	p.advance()
+	if err := p.expect(TokenLParen); err != nil {
+		return nil, err
+	}
+	value, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(TokenRParen); err != nil {
+		return nil, err
+	}
+	return &Conversion{Type: tok.Type, TypeName: tok.Value, Value: value}, nil
+}
+
+// declaredType names type t for the checker, or the newtype it was declared
+// with when there is one
+func (sa *SemanticAnalyzer) declaredType(t TokenType, newtype string) string {
+	if newtype == "" {
+		return TokenTypeName(t)
+	}
+	name := "'" + newtype + "'"
+	sa.newtypes[name] = true
+	return name
+}
+
+// exprType names the type of expr as declaredType does, or returns "" when
+// expr is a literal, which fits any type, or its type is not known
+func (sa *SemanticAnalyzer) exprType(expr ASTNode) string {
+	switch e := expr.(type) {
+	case *Identifier:
+		if info := sa.lookupSymbol(e.Name); info != nil && info.Kind != SymbolFunction {
+			return info.TypeName
+		}
+	case *Conversion:
+		return sa.declaredType(e.Type, e.TypeName)
+	case *Unwrap:
+		return sa.exprType(e.Value)
+	case *FunctionCall:
+		if fn := sa.calledFunction(e); fn != nil {
+			return sa.declaredType(fn.ReturnType, fn.ReturnName)
+		}
+	case *BinaryOp:
+		left, right := sa.exprType(e.Left), sa.exprType(e.Right)
+		switch {
+		case left == "":
+			return right
+		case right == "" || left == right:
+			return left
+		}
+	case *UnaryOp:
+		if e.Operator == TokenMinus || e.Operator == TokenTilde {
+			return sa.exprType(e.Operand)
+		}
+	}
+	return ""
+}
+
+// calledFunction returns the user function call resolves to, or nil for a
+// stdlib call
+func (sa *SemanticAnalyzer) calledFunction(call *FunctionCall) *FunctionDefinition {
+	name := call.Name
+	if module, fn, ok := strings.Cut(name, "::"); ok {
+		if !sa.sourceMods[module] {
+			return nil
+		}
+		name = fn
+	}
+	return sa.signatures[name]
+}
+
+// checkType reports a value of type have used where want is declared, when
+// either is a newtype and they differ
+func (sa *SemanticAnalyzer) checkType(want, have string, node ASTNode, context string) {
+	if want == "" || have == "" || want == have || !sa.newtypes[want] && !sa.newtypes[have] {
+		return
+	}
+	sa.typeError(node, fmt.Sprintf("cannot use %s value as %s %s (convert it with %s(...))",
+		have, want, context, strings.Trim(want, "'")))
+}
+
+// checkOperands reports an operator applied to values of different types
+// when either is a newtype
+func (sa *SemanticAnalyzer) checkOperands(node, left, right ASTNode) {
+	l, r := sa.exprType(left), sa.exprType(right)
+	if l == "" || r == "" || l == r || !sa.newtypes[l] && !sa.newtypes[r] {
+		return
+	}
+	if node.Loc().Line == 0 {
+		node = left // Operators are not located; their expression starts here
+	}
+	sa.typeError(node, fmt.Sprintf("mismatched types %s and %s", l, r))
+}
+
+// checkArguments checks the arguments of a call to a user function against
+// its parameters' types
+func (sa *SemanticAnalyzer) checkArguments(call *FunctionCall) {
+	fn := sa.calledFunction(call)
+	if fn == nil {
+		return
+	}
+	name, _, _ := strings.Cut(fn.Name, ".") // Overloads are mangled
+	for i, param := range fn.Parameters {
+		if param.Variadic || i >= len(call.Args) {
+			break
+		}
+		sa.checkType(sa.declaredType(param.Type, param.TypeName), sa.exprType(call.Args[i]),
+			call.Args[i], fmt.Sprintf("for parameter '%s' of '%s'", param.Name, name))
+	}
+}
+
+// typeError reports a type problem at node
+func (sa *SemanticAnalyzer) typeError(node ASTNode, message string) {
+	loc := node.Loc()
+	if loc.Line == 0 {
+		loc.Line = sa.currentLine
+	}
+	sa.diagnostics.AddErrorWithCode(string(ErrTypeMismatch), CategorySemantic, message,
+		sa.filePath, loc.Line, loc.Column, sa.getSourceLine(loc.Line))
+}
+
+// generateConversion evaluates a conversion's value, which already has the
+// representation of the type it converts to
+func (cg *CodeGenerator) generateConversion(c *Conversion, reg string) {
+	cg.generateExpressionToReg(c.Value, reg)
+}
@@ -0,0 +1,72 @@
+package main
+
+import "fmt"
+
+// varargs.go - Variadic user functions
+// A function's last parameter can be marked with ... to take any number of
+// trailing arguments:
+//
+//	fn int sum(int first, int... rest) {
+//	    int total = first;
+//	    for (int i = 0; i < collections::array_int_len(rest); i += 1) {
+//	        total += collections::array_int_get(rest, i);
+//	    }
+//	    ret total;
+//	}
+//
+//	sum(1, 2, 3, 4);
+//
+// The caller lays the extra arguments out in its own frame as an array_int
+// (header, then one 8-byte slot per argument) and passes the array's address
+// in the register after the fixed parameters, where the callee finds it as
+// the variadic parameter. The collections array functions read it: len for
+// the count, get to index. The array belongs to the caller and goes away when
+// the call returns, so the callee must not grow, free or keep it; copy it
+// with array_int_extend to hold on to the values.
+
+// maxFixedParams is the most fixed parameters a variadic function can have,
+// leaving the last argument register for the array
+const maxFixedParams = 5
+
+// Variadic reports whether f's last parameter takes the trailing arguments
+func (f *FunctionDefinition) Variadic() bool {
+	return len(f.Parameters) > 0 && f.Parameters[len(f.Parameters)-1].Variadic
+}
+
+// parseEllipsis consumes ... if it comes next, reporting whether it did
+func (p *Parser) parseEllipsis() (bool, error) {
+	if p.current().Type != TokenDot {
+		return false, nil
+	}
+	for i := 0; i < 3; i++ {
+		if err := p.expect(TokenDot); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// varargsBlockSize returns the frame space for an array of n arguments,
+// kept a multiple of 16 so calls made with it in place stay aligned
+func varargsBlockSize(n int) int {
+	return (collectionsHeaderSize + 8*n + 15) &^ 15
+}
+
+// generateVarargs evaluates args into an array_int below the stack pointer,
+// where it stays for the call, and returns the bytes it took
+func (cg *CodeGenerator) generateVarargs(args []ASTNode) int {
+	size := varargsBlockSize(len(args))
+	cg.textSection.WriteString(fmt.Sprintf("    # %d variadic argument(s)\n", len(args)))
+	cg.textSection.WriteString(fmt.Sprintf("    subq $%d, %%rsp\n", size))
+	for i, arg := range args {
+		cg.generateExpressionToReg(arg, "rax")
+		cg.textSection.WriteString(fmt.Sprintf("    movq %%rax, %d(%%rsp)\n", collectionsHeaderSize+8*i))
+	}
+	cg.textSection.WriteString(fmt.Sprintf("    movq $%d, (%%rsp)\n", len(args)))  // len
+	cg.textSection.WriteString(fmt.Sprintf("    movq $%d, 8(%%rsp)\n", len(args))) // cap
+	cg.textSection.WriteString("    movq $0, 16(%rsp)\n")                          // head
+	cg.textSection.WriteString("    movq $0, 24(%rsp)\n")                          // tail
+	cg.textSection.WriteString(fmt.Sprintf("    leaq %d(%%rsp), %%rax\n", collectionsHeaderSize))
+	cg.textSection.WriteString("    movq %rax, 32(%rsp)\n") // data ptr
+	return size
+}
@@ -0,0 +1,1274 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// x86asm.go - Built-in assembler
+// -emit=obj assembles the generated program itself instead of handing the
+// text to as. Assemble reads the part of GNU as syntax the code generator
+// writes: labels, numeric labels such as 1: referred to as 1f and 1b, the
+// data, alignment and section directives, .globl, .type and .size, .file and
+// .loc, and the instructions x86enc.go encodes. It chooses encodings as GNU
+// as does, each jump taking its two-byte form when the target is near
+// enough, so the object holds the bytes an external assembler would have
+// produced from the same text.
+
+// asmExpr is a value of the form sym - minus + add; either symbol may be empty
+type asmExpr struct {
+	sym, minus string
+	add        int64
+}
+
+// constant reports whether the expression names no symbol
+func (e asmExpr) constant() bool {
+	return e.sym == "" && e.minus == ""
+}
+
+// asmItemKind says what an asmItem holds
+type asmItemKind int
+
+const (
+	asmBytes asmItemKind = iota // Encoded bytes and the fixups in them
+	asmJump                     // Jump, in its short or long form
+	asmAlign                    // Padding up to an alignment
+	asmLabel                    // Definition of a symbol
+	asmLine                     // Source position from .loc
+)
+
+// asmItem is a piece of a section; layout settles where each one goes
+type asmItem struct {
+	kind   asmItemKind
+	offset int // In the section
+	size   int
+	line   int // Of the assembly text, for errors
+
+	data   []byte
+	fixups []asmFixup
+
+	cond      int     // Jump condition, or -1 for jmp
+	shortOp   byte    // Opcode of a jump that has no long form
+	target    asmExpr // Jump target
+	long      bool    // Jump takes a 32-bit displacement
+	relaxable bool    // Target is in the same section, so the short form may do
+
+	align int
+	fill  byte // Padding outside code sections
+
+	sym *asmSymbol
+	loc ObjLine // File, line and column of a .loc
+}
+
+// asmFixup is a field of an asmBytes item that holds the value of expr,
+// known once the layout is, or left to the linker as a relocation
+type asmFixup struct {
+	at, size int
+	expr     asmExpr
+	pcrel    bool         // Relative to the end of the instruction, at end
+	end      int          // Offset in the item of the end of the instruction
+	branch   bool         // call target: a global symbol gets R_X86_64_PLT32
+	rtype    elf.R_X86_64 // Relocation of an absolute value
+}
+
+// asmSymbol is a label, or a symbol named by a directive or operand
+type asmSymbol struct {
+	name     string
+	section  *asmSection
+	label    *asmItem // Definition, or nil while undefined
+	global   bool
+	typ      elf.SymType
+	size     *asmExpr
+	internal bool // Numeric and .L labels stay out of the symbol table
+	obj      *ObjSymbol
+}
+
+// asmSection collects the items of one section in order
+type asmSection struct {
+	obj   *ObjSection
+	items []*asmItem
+}
+
+// assembler holds the state of one Assemble call
+type assembler struct {
+	obj       *ObjectFile
+	sections  []*asmSection
+	current   *asmSection
+	previous  *asmSection   // For .previous
+	stack     []*asmSection // Of .pushsection
+	symbols   map[string]*asmSymbol
+	order     []*asmSymbol // Symbols in the order they were first named
+	numeric   map[string]int
+	temps     int // Labels made for "." in expressions
+	lineFiles map[int]string
+	line      int
+}
+
+// Assemble assembles program text into a relocatable object
+func Assemble(text string) (*ObjectFile, error) {
+	a := &assembler{
+		obj:       &ObjectFile{},
+		symbols:   make(map[string]*asmSymbol),
+		numeric:   make(map[string]int),
+		lineFiles: make(map[int]string),
+	}
+	// GNU as starts every object with these three, in this order
+	for _, name := range []string{".text", ".data", ".bss"} {
+		if _, err := a.section(name, nil, ""); err != nil {
+			return nil, err
+		}
+	}
+	a.current = a.sections[0]
+
+	for i, line := range strings.Split(text, "\n") {
+		a.line = i + 1
+		for _, stmt := range splitStatements(line) {
+			if err := a.statement(strings.TrimSpace(stmt)); err != nil {
+				return nil, fmt.Errorf("line %d: %v: %s", a.line, err, strings.TrimSpace(line))
+			}
+		}
+	}
+	if err := a.layout(); err != nil {
+		return nil, err
+	}
+	if err := a.finish(); err != nil {
+		return nil, err
+	}
+	return a.obj, nil
+}
+
+// splitStatements drops the comment from line and splits the rest at
+// semicolons, leaving strings and character constants whole
+func splitStatements(line string) []string {
+	var stmts []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		case '\'':
+			i++
+			if i < len(line) && line[i] == '\\' {
+				i++
+			}
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+			}
+		case '#':
+			return append(stmts, line[start:i])
+		case ';':
+			stmts = append(stmts, line[start:i])
+			start = i + 1
+		}
+	}
+	return append(stmts, line[start:])
+}
+
+// isSymbolChar reports whether c can appear in a symbol name
+func isSymbolChar(c byte) bool {
+	return c == '_' || c == '.' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// statement assembles one statement: labels, then a directive or instruction
+func (a *assembler) statement(s string) error {
+	for {
+		end := 0
+		for end < len(s) && isSymbolChar(s[end]) {
+			end++
+		}
+		if end == 0 || end == len(s) || s[end] != ':' {
+			break
+		}
+		if err := a.defineLabel(s[:end]); err != nil {
+			return err
+		}
+		s = strings.TrimSpace(s[end+1:])
+	}
+	switch {
+	case s == "":
+		return nil
+	case s[0] == '.':
+		return a.directive(s)
+	default:
+		return a.instruction(s)
+	}
+}
+
+// numericLabel names the n-th definition of a numeric label
+func numericLabel(name string, n int) string {
+	return fmt.Sprintf(".L%s\x02%d", name, n)
+}
+
+// symbol returns the symbol called name, creating it on first use
+func (a *assembler) symbol(name string) *asmSymbol {
+	sym, ok := a.symbols[name]
+	if !ok {
+		sym = &asmSymbol{name: name, internal: strings.HasPrefix(name, ".L")}
+		a.symbols[name] = sym
+		a.order = append(a.order, sym)
+	}
+	return sym
+}
+
+// defineLabel defines name at the current position
+func (a *assembler) defineLabel(name string) error {
+	if name[0] >= '0' && name[0] <= '9' {
+		for _, c := range name {
+			if c < '0' || c > '9' {
+				return fmt.Errorf("invalid label %s", name)
+			}
+		}
+		a.numeric[name]++
+		name = numericLabel(name, a.numeric[name])
+	}
+	sym := a.symbol(name)
+	if sym.label != nil {
+		return fmt.Errorf("symbol %s is already defined", name)
+	}
+	sym.section = a.current
+	sym.label = a.add(&asmItem{kind: asmLabel, sym: sym})
+	return nil
+}
+
+// here returns a label for the current position, which "." stands for
+func (a *assembler) here() string {
+	a.temps++
+	name := fmt.Sprintf(".L.%d", a.temps)
+	a.defineLabel(name)
+	return name
+}
+
+// add appends an item to the current section
+func (a *assembler) add(item *asmItem) *asmItem {
+	item.line = a.line
+	a.current.items = append(a.current.items, item)
+	return item
+}
+
+// emitBytes appends data, whose fixups are relative to its start
+func (a *assembler) emitBytes(data []byte, fixups []asmFixup) error {
+	if a.current.obj.Type == elf.SHT_NOBITS {
+		for _, c := range data {
+			if c != 0 || len(fixups) > 0 {
+				return fmt.Errorf("data in %s, which holds only zeroes", a.current.obj.Name)
+			}
+		}
+	}
+	items := a.current.items
+	if n := len(items); n > 0 && items[n-1].kind == asmBytes {
+		last := items[n-1]
+		for _, f := range fixups {
+			f.at += len(last.data)
+			f.end += len(last.data)
+			last.fixups = append(last.fixups, f)
+		}
+		last.data = append(last.data, data...)
+		return nil
+	}
+	a.add(&asmItem{kind: asmBytes, data: data, fixups: fixups})
+	return nil
+}
+
+// section returns the section called name, creating it with the flags and
+// type given, or with the defaults GNU as gives that name
+func (a *assembler) section(name string, flags *string, typ string) (*asmSection, error) {
+	for _, s := range a.sections {
+		if s.obj.Name == name {
+			return s, nil
+		}
+	}
+	sec := &ObjSection{Name: name, Type: elf.SHT_PROGBITS, Align: 1}
+	prefixed := func(p string) bool { return name == p || strings.HasPrefix(name, p+".") }
+	switch {
+	case prefixed(".text"):
+		sec.Flags = elf.SHF_ALLOC | elf.SHF_EXECINSTR
+	case prefixed(".data"):
+		sec.Flags = elf.SHF_ALLOC | elf.SHF_WRITE
+	case prefixed(".bss"):
+		sec.Flags, sec.Type = elf.SHF_ALLOC|elf.SHF_WRITE, elf.SHT_NOBITS
+	case prefixed(".rodata"):
+		sec.Flags = elf.SHF_ALLOC
+	case strings.HasPrefix(name, ".note"):
+		sec.Type = elf.SHT_NOTE
+	}
+	if flags != nil {
+		sec.Flags = 0
+		for _, f := range *flags {
+			switch f {
+			case 'a':
+				sec.Flags |= elf.SHF_ALLOC
+			case 'w':
+				sec.Flags |= elf.SHF_WRITE
+			case 'x':
+				sec.Flags |= elf.SHF_EXECINSTR
+			case 'M':
+				sec.Flags |= elf.SHF_MERGE
+			case 'S':
+				sec.Flags |= elf.SHF_STRINGS
+			default:
+				return nil, fmt.Errorf("unsupported section flag %q", f)
+			}
+		}
+	}
+	switch strings.TrimLeft(typ, "@%") {
+	case "":
+	case "progbits":
+		sec.Type = elf.SHT_PROGBITS
+	case "nobits":
+		sec.Type = elf.SHT_NOBITS
+	case "note":
+		sec.Type = elf.SHT_NOTE
+	default:
+		return nil, fmt.Errorf("unsupported section type %s", typ)
+	}
+	s := &asmSection{obj: sec}
+	a.sections = append(a.sections, s)
+	a.obj.Sections = append(a.obj.Sections, sec)
+	return s, nil
+}
+
+// splitArgs splits directive or operand text at the commas outside strings
+// and parentheses
+func splitArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+		case '\'':
+			i++
+			if i < len(s) && s[i] == '\\' {
+				i++
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				i++
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" || len(args) > 0 {
+		args = append(args, rest)
+	}
+	return args
+}
+
+// directive carries out an assembler directive
+func (a *assembler) directive(s string) error {
+	name, rest := s, ""
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		name, rest = s[:i], strings.TrimSpace(s[i+1:])
+	}
+	args := splitArgs(rest)
+
+	switch name {
+	case ".text", ".data", ".bss":
+		sec, err := a.section(name, nil, "")
+		if err != nil {
+			return err
+		}
+		a.previous, a.current = a.current, sec
+	case ".section", ".pushsection":
+		if len(args) == 0 {
+			return fmt.Errorf("%s needs a section name", name)
+		}
+		var flags *string
+		typ := ""
+		if len(args) > 1 {
+			f, err := strconv.Unquote(args[1])
+			if err != nil {
+				return fmt.Errorf("bad section flags %s", args[1])
+			}
+			flags = &f
+		}
+		if len(args) > 2 {
+			typ = args[2]
+		}
+		sec, err := a.section(args[0], flags, typ)
+		if err != nil {
+			return err
+		}
+		if name == ".pushsection" {
+			a.stack = append(a.stack, a.current)
+		}
+		a.previous, a.current = a.current, sec
+	case ".popsection":
+		if len(a.stack) == 0 {
+			return fmt.Errorf(".popsection without .pushsection")
+		}
+		a.previous, a.current = a.current, a.stack[len(a.stack)-1]
+		a.stack = a.stack[:len(a.stack)-1]
+	case ".previous":
+		if a.previous != nil {
+			a.previous, a.current = a.current, a.previous
+		}
+	case ".globl", ".global":
+		for _, arg := range args {
+			a.symbol(arg).global = true
+		}
+	case ".type":
+		if len(args) != 2 {
+			return fmt.Errorf(".type takes a symbol and a type")
+		}
+		switch strings.TrimLeft(args[1], "@%") {
+		case "function":
+			a.symbol(args[0]).typ = elf.STT_FUNC
+		case "object":
+			a.symbol(args[0]).typ = elf.STT_OBJECT
+		case "notype":
+			a.symbol(args[0]).typ = elf.STT_NOTYPE
+		default:
+			return fmt.Errorf("unsupported symbol type %s", args[1])
+		}
+	case ".size":
+		if len(args) != 2 {
+			return fmt.Errorf(".size takes a symbol and a size")
+		}
+		size, err := a.parseExpr(args[1])
+		if err != nil {
+			return err
+		}
+		a.symbol(args[0]).size = &size
+	case ".file":
+		return a.fileDirective(rest)
+	case ".loc":
+		return a.locDirective(rest)
+	case ".byte":
+		return a.dataDirective(args, 1)
+	case ".short", ".value", ".word", ".2byte":
+		return a.dataDirective(args, 2)
+	case ".long", ".int", ".4byte":
+		return a.dataDirective(args, 4)
+	case ".quad", ".8byte":
+		return a.dataDirective(args, 8)
+	case ".ascii", ".asciz", ".string":
+		for _, arg := range args {
+			str, err := parseAsmString(arg)
+			if err != nil {
+				return err
+			}
+			if name != ".ascii" {
+				str = append(str, 0)
+			}
+			if err := a.emitBytes(str, nil); err != nil {
+				return err
+			}
+		}
+	case ".zero", ".space", ".skip":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("%s takes a size and an optional fill byte", name)
+		}
+		n, err := a.constant(args[0])
+		if err != nil {
+			return err
+		}
+		fill := int64(0)
+		if len(args) == 2 {
+			if fill, err = a.constant(args[1]); err != nil {
+				return err
+			}
+		}
+		if n < 0 {
+			return fmt.Errorf("negative size %d", n)
+		}
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(fill)
+		}
+		return a.emitBytes(data, nil)
+	case ".fill":
+		return a.fillDirective(args)
+	case ".balign", ".align", ".p2align":
+		return a.alignDirective(name, args)
+	default:
+		return fmt.Errorf("unsupported directive %s", name)
+	}
+	return nil
+}
+
+// constant parses an expression that must not name a symbol
+func (a *assembler) constant(s string) (int64, error) {
+	e, err := a.parseExpr(s)
+	if err != nil {
+		return 0, err
+	}
+	if !e.constant() {
+		return 0, fmt.Errorf("%s is not a constant", s)
+	}
+	return e.add, nil
+}
+
+// fileDirective records the file name of .file "name", or a numbered file
+// of the line table from .file n "path"
+func (a *assembler) fileDirective(rest string) error {
+	if strings.HasPrefix(rest, "\"") {
+		name, err := parseAsmString(rest)
+		if err != nil {
+			return err
+		}
+		a.obj.SourceFile = string(name)
+		return nil
+	}
+	num, path, ok := strings.Cut(rest, " ")
+	n, err := strconv.Atoi(num)
+	if !ok || err != nil || n < 1 {
+		return fmt.Errorf("bad .file directive")
+	}
+	name, err := parseAsmString(strings.TrimSpace(path))
+	if err != nil {
+		return err
+	}
+	a.lineFiles[n] = string(name)
+	return nil
+}
+
+// locDirective records the source position of the code that follows
+func (a *assembler) locDirective(rest string) error {
+	fields := strings.Fields(rest)
+	if len(fields) < 2 {
+		return fmt.Errorf(".loc takes a file, a line and a column")
+	}
+	var nums [3]int
+	for i := 0; i < len(fields) && i < 3; i++ {
+		n, err := strconv.Atoi(fields[i])
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad .loc number %s", fields[i])
+		}
+		nums[i] = n
+	}
+	if _, ok := a.lineFiles[nums[0]]; !ok {
+		return fmt.Errorf("file %d of .loc has no .file directive", nums[0])
+	}
+	a.add(&asmItem{kind: asmLine, loc: ObjLine{File: nums[0], Line: nums[1], Column: nums[2]}})
+	return nil
+}
+
+// dataRelocs are the relocations of .byte through .quad values that name a
+// symbol, and of those relative to a place in their own section
+var dataRelocs = map[int][2]elf.R_X86_64{
+	1: {elf.R_X86_64_8, elf.R_X86_64_PC8},
+	2: {elf.R_X86_64_16, elf.R_X86_64_PC16},
+	4: {elf.R_X86_64_32, elf.R_X86_64_PC32},
+	8: {elf.R_X86_64_64, elf.R_X86_64_PC64},
+}
+
+// dataDirective emits each value in args in size bytes
+func (a *assembler) dataDirective(args []string, size int) error {
+	for _, arg := range args {
+		e, err := a.parseExpr(arg)
+		if err != nil {
+			return err
+		}
+		data := make([]byte, size)
+		var fixups []asmFixup
+		if e.constant() {
+			if !fitsUnsigned(e.add, size) {
+				return fmt.Errorf("%d does not fit in %d bytes", e.add, size)
+			}
+			putLittleEndian(data, e.add)
+		} else {
+			fixups = append(fixups, asmFixup{size: size, expr: e, rtype: dataRelocs[size][0]})
+		}
+		if err := a.emitBytes(data, fixups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillDirective emits .fill repeat, size, value
+func (a *assembler) fillDirective(args []string) error {
+	if len(args) < 1 || len(args) > 3 {
+		return fmt.Errorf(".fill takes a count, a size and a value")
+	}
+	vals := []int64{0, 1, 0}
+	for i, arg := range args {
+		v, err := a.constant(arg)
+		if err != nil {
+			return err
+		}
+		vals[i] = v
+	}
+	if vals[0] < 0 || vals[1] < 0 || vals[1] > 8 {
+		return fmt.Errorf("bad .fill count or size")
+	}
+	data := make([]byte, vals[0]*vals[1])
+	for i := int64(0); i < vals[0]; i++ {
+		// As in GNU as, bytes past the fourth are zero
+		elem := data[i*vals[1] : (i+1)*vals[1]]
+		putLittleEndian(elem[:min(len(elem), 4)], vals[2])
+	}
+	return a.emitBytes(data, nil)
+}
+
+// alignDirective pads to an alignment: .balign and .align take it in bytes,
+// .p2align as a power of two
+func (a *assembler) alignDirective(name string, args []string) error {
+	if len(args) < 1 || len(args) > 3 {
+		return fmt.Errorf("%s takes an alignment and an optional fill byte", name)
+	}
+	n, err := a.constant(args[0])
+	if err != nil {
+		return err
+	}
+	if name == ".p2align" {
+		if n < 0 || n > 16 {
+			return fmt.Errorf("bad alignment %d", n)
+		}
+		n = 1 << n
+	}
+	if n < 1 || n&(n-1) != 0 {
+		return fmt.Errorf("alignment %d is not a power of two", n)
+	}
+	fill := int64(0)
+	if len(args) > 1 && args[1] != "" {
+		if fill, err = a.constant(args[1]); err != nil {
+			return err
+		}
+	}
+	a.add(&asmItem{kind: asmAlign, align: int(n), fill: byte(fill)})
+	if uint64(n) > a.current.obj.Align {
+		a.current.obj.Align = uint64(n)
+	}
+	return nil
+}
+
+// parseAsmString decodes a quoted string with C escapes, as in .ascii
+func parseAsmString(s string) ([]byte, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return nil, fmt.Errorf("expected a quoted string, got %s", s)
+	}
+	s = s[1 : len(s)-1]
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			out = append(out, c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			return nil, fmt.Errorf("string ends in a backslash")
+		}
+		value, n := asmEscape(s[i:])
+		out = append(out, value)
+		i += n - 1
+	}
+	return out, nil
+}
+
+// asmEscape decodes the escape at the start of s, which follows a
+// backslash, and returns the byte and the length of the escape
+func asmEscape(s string) (byte, int) {
+	switch c := s[0]; {
+	case c >= '0' && c <= '7':
+		v, n := 0, 0
+		for n < 3 && n < len(s) && s[n] >= '0' && s[n] <= '7' {
+			v = v*8 + int(s[n]-'0')
+			n++
+		}
+		return byte(v), n
+	case c == 'x' || c == 'X':
+		v, n := 0, 1
+		for n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[n]) >= 0 {
+			d, _ := strconv.ParseUint(s[n:n+1], 16, 8)
+			v = v*16 + int(d)
+			n++
+		}
+		return byte(v), n
+	default:
+		escapes := map[byte]byte{'n': '\n', 't': '\t', 'r': '\r', 'b': '\b', 'f': '\f', 'v': '\v', 'a': '\a', 'e': 0x1b}
+		if v, ok := escapes[c]; ok {
+			return v, 1
+		}
+		return c, 1
+	}
+}
+
+// fitsUnsigned reports whether v fits in size bytes, signed or unsigned
+func fitsUnsigned(v int64, size int) bool {
+	if size >= 8 {
+		return true
+	}
+	bits := uint(size * 8)
+	return v >= -(1<<(bits-1)) && v < 1<<bits
+}
+
+// fitsSigned reports whether v fits in size bytes as a signed number
+func fitsSigned(v int64, size int) bool {
+	if size >= 8 {
+		return true
+	}
+	bits := uint(size * 8)
+	return v >= -(1<<(bits-1)) && v < 1<<(bits-1)
+}
+
+// putLittleEndian stores the low len(b) bytes of v in b
+func putLittleEndian(b []byte, v int64) {
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+}
+
+// exprParser reads an expression of an operand or directive
+type exprParser struct {
+	a   *assembler
+	s   string
+	pos int
+}
+
+// parseExpr parses an expression made of numbers, character constants,
+// symbols and ".", with the arithmetic of constants and a symbol plus or
+// minus a constant or another symbol
+func (a *assembler) parseExpr(s string) (asmExpr, error) {
+	p := &exprParser{a: a, s: strings.TrimSpace(s)}
+	if p.s == "" {
+		return asmExpr{}, fmt.Errorf("missing expression")
+	}
+	e, err := p.sum()
+	if err != nil {
+		return e, err
+	}
+	p.space()
+	if p.pos < len(p.s) {
+		return e, fmt.Errorf("unexpected %q in expression %s", p.s[p.pos:], s)
+	}
+	return e, nil
+}
+
+func (p *exprParser) space() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// peek returns the operator at the current position, if it is one of ops
+func (p *exprParser) peek(ops ...string) string {
+	p.space()
+	for _, op := range ops {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// sum parses terms joined by + and -, the only operators that take symbols
+func (p *exprParser) sum() (asmExpr, error) {
+	e, err := p.bitwise()
+	for err == nil {
+		op := p.peek("+", "-")
+		if op == "" {
+			break
+		}
+		p.pos++
+		var r asmExpr
+		if r, err = p.bitwise(); err != nil {
+			break
+		}
+		if op == "-" {
+			if r.minus != "" {
+				return e, fmt.Errorf("cannot subtract a difference of symbols")
+			}
+			r = asmExpr{minus: r.sym, add: -r.add}
+		}
+		if (e.sym != "" && r.sym != "") || (e.minus != "" && r.minus != "") {
+			return e, fmt.Errorf("expression adds two symbols")
+		}
+		e = asmExpr{sym: e.sym + r.sym, minus: e.minus + r.minus, add: e.add + r.add}
+		if e.sym == e.minus {
+			e.sym, e.minus = "", ""
+		}
+	}
+	return e, err
+}
+
+// bitwise parses products joined by |, & and ^
+func (p *exprParser) bitwise() (asmExpr, error) {
+	return p.binary(p.product, "|", "&", "^")
+}
+
+// product parses operands joined by *, /, %, << and >>
+func (p *exprParser) product() (asmExpr, error) {
+	return p.binary(p.unary, "<<", ">>", "*", "/", "%")
+}
+
+// binary parses operands joined by operators that only take constants
+func (p *exprParser) binary(next func() (asmExpr, error), ops ...string) (asmExpr, error) {
+	e, err := next()
+	for err == nil {
+		op := p.peek(ops...)
+		if op == "" {
+			break
+		}
+		p.pos += len(op)
+		var r asmExpr
+		if r, err = next(); err != nil {
+			break
+		}
+		if !e.constant() || !r.constant() {
+			return e, fmt.Errorf("operator %s takes constants", op)
+		}
+		switch op {
+		case "|":
+			e.add |= r.add
+		case "&":
+			e.add &= r.add
+		case "^":
+			e.add ^= r.add
+		case "<<":
+			e.add <<= uint64(r.add)
+		case ">>":
+			e.add >>= uint64(r.add)
+		case "*":
+			e.add *= r.add
+		case "/", "%":
+			if r.add == 0 {
+				return e, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				e.add /= r.add
+			} else {
+				e.add %= r.add
+			}
+		}
+	}
+	return e, err
+}
+
+// unary parses a number, character, symbol, "." or parenthesized
+// expression, after any - or ~
+func (p *exprParser) unary() (asmExpr, error) {
+	p.space()
+	if p.pos == len(p.s) {
+		return asmExpr{}, fmt.Errorf("expression %s ends early", p.s)
+	}
+	switch c := p.s[p.pos]; {
+	case c == '-' || c == '~':
+		p.pos++
+		e, err := p.unary()
+		if err == nil && !e.constant() {
+			if c == '~' || e.minus != "" {
+				return e, fmt.Errorf("operator %c takes a constant", c)
+			}
+			return asmExpr{minus: e.sym, add: -e.add}, nil
+		}
+		if c == '-' {
+			e.add = -e.add
+		} else {
+			e.add = ^e.add
+		}
+		return e, err
+	case c == '(':
+		p.pos++
+		e, err := p.sum()
+		if err == nil && p.peek(")") == "" {
+			err = fmt.Errorf("missing ) in %s", p.s)
+		}
+		p.pos++
+		return e, err
+	case c == '\'':
+		p.pos++
+		if p.pos == len(p.s) {
+			return asmExpr{}, fmt.Errorf("empty character constant")
+		}
+		v, n := p.s[p.pos], 1
+		if v == '\\' && p.pos+1 < len(p.s) {
+			v, n = asmEscape(p.s[p.pos+1:])
+			n++
+		}
+		p.pos += n
+		if p.pos < len(p.s) && p.s[p.pos] == '\'' {
+			p.pos++
+		}
+		return asmExpr{add: int64(v)}, nil
+	case c >= '0' && c <= '9':
+		return p.number()
+	case isSymbolChar(c):
+		start := p.pos
+		for p.pos < len(p.s) && isSymbolChar(p.s[p.pos]) {
+			p.pos++
+		}
+		name := p.s[start:p.pos]
+		if name == "." {
+			name = p.a.here()
+		}
+		// call malloc@PLT: calls to global and undefined symbols get
+		// R_X86_64_PLT32 with or without it
+		if strings.HasPrefix(p.s[p.pos:], "@PLT") {
+			p.pos += len("@PLT")
+		}
+		p.a.symbol(name)
+		return asmExpr{sym: name}, nil
+	default:
+		return asmExpr{}, fmt.Errorf("unexpected %q in expression", c)
+	}
+}
+
+// number parses a decimal, 0x hex, 0b binary or 0 octal number, or a
+// reference to a numeric label such as 1f or 2b
+func (p *exprParser) number() (asmExpr, error) {
+	start := p.pos
+	for p.pos < len(p.s) && isSymbolChar(p.s[p.pos]) {
+		p.pos++
+	}
+	text := p.s[start:p.pos]
+	if n := len(text); n > 1 && (text[n-1] == 'f' || text[n-1] == 'b') && strings.Trim(text[:n-1], "0123456789") == "" {
+		label := text[:n-1]
+		count := p.a.numeric[label]
+		if text[n-1] == 'f' {
+			count++
+		} else if count == 0 {
+			return asmExpr{}, fmt.Errorf("label %s is not defined before %s", label, text)
+		}
+		name := numericLabel(label, count)
+		p.a.symbol(name)
+		return asmExpr{sym: name}, nil
+	}
+	base, digits := 10, text
+	switch {
+	case strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X"):
+		base, digits = 16, text[2:]
+	case strings.HasPrefix(text, "0b") || strings.HasPrefix(text, "0B"):
+		base, digits = 2, text[2:]
+	case len(text) > 1 && text[0] == '0':
+		base, digits = 8, text[1:]
+	}
+	v, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return asmExpr{}, fmt.Errorf("bad number %s", text)
+	}
+	return asmExpr{add: int64(v)}, nil
+}
+
+// Jump encodings: the short form has an 8-bit displacement
+const (
+	jmpShortSize = 2
+	jmpLongSize  = 5
+	jccLongSize  = 6
+)
+
+// jumpSize returns the size of a jump item in its current form
+func jumpSize(item *asmItem) int {
+	switch {
+	case !item.long:
+		return jmpShortSize
+	case item.cond < 0:
+		return jmpLongSize
+	default:
+		return jccLongSize
+	}
+}
+
+// offset returns the offset of a defined symbol in its section
+func (sym *asmSymbol) offset() int {
+	return sym.label.offset
+}
+
+// layout places every item, growing jumps whose target is out of reach of
+// the short form until none is, which is how GNU as relaxes them
+func (a *assembler) layout() error {
+	for _, sec := range a.sections {
+		for _, item := range sec.items {
+			if item.kind != asmJump {
+				continue
+			}
+			target := a.symbols[item.target.sym]
+			item.relaxable = target != nil && target.label != nil && target.section == sec && item.target.minus == ""
+			item.long = !item.relaxable
+			if item.shortOp != 0 && !item.relaxable {
+				return fmt.Errorf("line %d: the target of this jump must be a label in the same section", item.line)
+			}
+		}
+	}
+	for {
+		for _, sec := range a.sections {
+			offset := 0
+			for _, item := range sec.items {
+				item.offset = offset
+				switch item.kind {
+				case asmBytes:
+					item.size = len(item.data)
+				case asmJump:
+					item.size = jumpSize(item)
+				case asmAlign:
+					item.size = (item.align - offset%item.align) % item.align
+				}
+				offset += item.size
+			}
+		}
+		grown := false
+		for _, sec := range a.sections {
+			for _, item := range sec.items {
+				if item.kind != asmJump || item.long {
+					continue
+				}
+				disp := a.symbols[item.target.sym].offset() + int(item.target.add) - (item.offset + item.size)
+				if disp < -128 || disp > 127 {
+					if item.shortOp != 0 {
+						return fmt.Errorf("line %d: jump target is out of range", item.line)
+					}
+					item.long = true
+					grown = true
+				}
+			}
+		}
+		if !grown {
+			return nil
+		}
+	}
+}
+
+// x86NopPatterns are the no-op instructions of each length GNU as pads code
+// with; longer padding repeats the longest
+var x86NopPatterns = [][]byte{
+	nil,
+	{0x90},
+	{0x66, 0x90},
+	{0x0f, 0x1f, 0x00},
+	{0x0f, 0x1f, 0x40, 0x00},
+	{0x0f, 0x1f, 0x44, 0x00, 0x00},
+	{0x66, 0x0f, 0x1f, 0x44, 0x00, 0x00},
+	{0x0f, 0x1f, 0x80, 0x00, 0x00, 0x00, 0x00},
+	{0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00},
+	{0x66, 0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00},
+	{0x66, 0x2e, 0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00},
+	{0x66, 0x66, 0x2e, 0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00},
+}
+
+// finish writes out the sections, symbols, relocations and line rows
+func (a *assembler) finish() error {
+	for _, sec := range a.sections {
+		sec.obj.Symbol = &ObjSymbol{Section: sec.obj, Type: elf.STT_SECTION}
+	}
+	for _, sym := range a.order {
+		if sym.internal {
+			continue
+		}
+		sym.obj = &ObjSymbol{Name: sym.name, Type: sym.typ, Global: sym.global}
+		if sym.label != nil {
+			sym.obj.Section = sym.section.obj
+			sym.obj.Value = uint64(sym.offset())
+		}
+		if sym.size != nil {
+			size, err := a.difference(*sym.size)
+			if err != nil {
+				return fmt.Errorf("size of %s: %v", sym.name, err)
+			}
+			sym.obj.Size = uint64(size)
+		}
+	}
+	// Defined symbols in the order they were defined, then undefined ones
+	var defined, undefined []*ObjSymbol
+	for _, sym := range a.order {
+		switch {
+		case sym.obj == nil:
+		case sym.label != nil:
+			defined = append(defined, sym.obj)
+		default:
+			undefined = append(undefined, sym.obj)
+		}
+	}
+	sort.SliceStable(defined, func(i, j int) bool {
+		return a.obj.sectionIndex(defined[i].Section) < a.obj.sectionIndex(defined[j].Section) ||
+			defined[i].Section == defined[j].Section && defined[i].Value < defined[j].Value
+	})
+	a.obj.Symbols = append(defined, undefined...)
+
+	for _, sec := range a.sections {
+		// GNU as emits the relocations of long jumps after all the others
+		var data []byte
+		var jumps []ObjReloc
+		for _, item := range sec.items {
+			switch item.kind {
+			case asmBytes:
+				start := len(data)
+				data = append(data, item.data...)
+				for _, f := range item.fixups {
+					if err := a.resolve(sec, item, f, data[start:]); err != nil {
+						return fmt.Errorf("line %d: %v", item.line, err)
+					}
+				}
+			case asmJump:
+				relocs := len(sec.obj.Relocs)
+				code, err := a.jump(sec, item)
+				if err != nil {
+					return fmt.Errorf("line %d: %v", item.line, err)
+				}
+				data = append(data, code...)
+				jumps = append(jumps, sec.obj.Relocs[relocs:]...)
+				sec.obj.Relocs = sec.obj.Relocs[:relocs]
+			case asmAlign:
+				if !sec.obj.Executable() {
+					for i := 0; i < item.size; i++ {
+						data = append(data, item.fill)
+					}
+					break
+				}
+				for n := item.size; n > 0; {
+					k := min(n, len(x86NopPatterns)-1)
+					data = append(data, x86NopPatterns[k]...)
+					n -= k
+				}
+			case asmLine:
+				line := item.loc
+				line.Section, line.Offset = sec.obj, uint64(item.offset)
+				a.obj.Lines = append(a.obj.Lines, line)
+			}
+		}
+		sec.obj.Relocs = append(sec.obj.Relocs, jumps...)
+		if sec.obj.Type == elf.SHT_NOBITS {
+			sec.obj.Size = uint64(len(data))
+		} else {
+			sec.obj.Data = data
+		}
+	}
+
+	if len(a.obj.Lines) > 0 {
+		files := 0
+		for n := range a.lineFiles {
+			files = max(files, n)
+		}
+		a.obj.LineFiles = make([]string, files)
+		for n, path := range a.lineFiles {
+			a.obj.LineFiles[n-1] = path
+		}
+	}
+	return nil
+}
+
+// difference evaluates an expression that must come to a constant, such as
+// the distance between two labels of a section
+func (a *assembler) difference(e asmExpr) (int64, error) {
+	if e.constant() {
+		return e.add, nil
+	}
+	sym, minus := a.symbols[e.sym], a.symbols[e.minus]
+	if sym == nil || minus == nil || sym.label == nil || minus.label == nil || sym.section != minus.section {
+		return 0, fmt.Errorf("not a constant")
+	}
+	return int64(sym.offset()-minus.offset()) + e.add, nil
+}
+
+// relocSymbol returns the symbol a relocation against sym names: a local
+// symbol is named through its section, with its offset added to addend
+func (a *assembler) relocSymbol(sym *asmSymbol, addend *int64) (*ObjSymbol, error) {
+	if sym.label != nil && !sym.global {
+		*addend += int64(sym.offset())
+		return sym.section.obj.Symbol, nil
+	}
+	if sym.internal {
+		return nil, fmt.Errorf("label %s is not defined", strings.ReplaceAll(sym.name, "\x02", "#"))
+	}
+	return sym.obj, nil
+}
+
+// resolve fills in one fixup of item, whose bytes start at field, or adds
+// a relocation for the linker to
+func (a *assembler) resolve(sec *asmSection, item *asmItem, f asmFixup, field []byte) error {
+	place := item.offset + f.at
+	field = field[f.at : f.at+f.size]
+	e := f.expr
+	target := a.symbols[e.sym]
+	reloc := func(rtype elf.R_X86_64, addend int64) error {
+		sym, err := a.relocSymbol(target, &addend)
+		if err != nil {
+			return err
+		}
+		sec.obj.Relocs = append(sec.obj.Relocs, ObjReloc{Offset: uint64(place), Type: rtype, Symbol: sym, Addend: addend})
+		return nil
+	}
+
+	if e.minus != "" {
+		minus := a.symbols[e.minus]
+		if minus.label == nil {
+			return fmt.Errorf("symbol %s is not defined", e.minus)
+		}
+		if target != nil && target.label != nil && target.section == minus.section {
+			v, _ := a.difference(e)
+			if !fitsUnsigned(v, f.size) {
+				return fmt.Errorf("value %d does not fit in %d bytes", v, f.size)
+			}
+			putLittleEndian(field, v)
+			return nil
+		}
+		// sym - here: relative to a place in this section
+		if minus.section != sec || target == nil || f.pcrel {
+			return fmt.Errorf("cannot subtract %s, which is in another section", e.minus)
+		}
+		return reloc(dataRelocs[f.size][1], e.add+int64(place-minus.offset()))
+	}
+
+	if target == nil {
+		putLittleEndian(field, e.add)
+		return nil
+	}
+	if !f.pcrel {
+		return reloc(f.rtype, e.add)
+	}
+	if target.label != nil && target.section == sec && !target.global {
+		v := int64(target.offset()) + e.add - int64(item.offset+f.end)
+		if !fitsSigned(v, f.size) {
+			return fmt.Errorf("displacement %d does not fit in %d bytes", v, f.size)
+		}
+		putLittleEndian(field, v)
+		return nil
+	}
+	rtype := elf.R_X86_64_PC32
+	if f.branch && (target.global || target.label == nil) {
+		rtype = elf.R_X86_64_PLT32
+	}
+	return reloc(rtype, e.add-int64(f.end-f.at))
+}
+
+// jump encodes a jump item in the form layout chose
+func (a *assembler) jump(sec *asmSection, item *asmItem) ([]byte, error) {
+	target := a.symbols[item.target.sym]
+	if !item.long {
+		op := item.shortOp
+		if op == 0 {
+			op = 0xeb
+			if item.cond >= 0 {
+				op = 0x70 + byte(item.cond)
+			}
+		}
+		disp := target.offset() + int(item.target.add) - (item.offset + item.size)
+		return []byte{op, byte(int8(disp))}, nil
+	}
+	code := []byte{0xe9}
+	if item.cond >= 0 {
+		code = []byte{0x0f, 0x80 + byte(item.cond)}
+	}
+	field := make([]byte, 4)
+	f := asmFixup{at: len(code), size: 4, expr: item.target, pcrel: true, end: len(code) + 4, branch: true}
+	if item.relaxable {
+		// Relaxed jumps reach any label of their section, global ones too
+		disp := target.offset() + int(item.target.add) - (item.offset + item.size)
+		putLittleEndian(field, int64(disp))
+		return append(code, field...), nil
+	}
+	code = append(code, field...)
+	if err := a.resolve(sec, item, f, code); err != nil {
+		return nil, err
+	}
+	return code, nil
+}
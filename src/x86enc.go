@@ -0,0 +1,1300 @@
+package main
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// x86enc.go - x86-64 instruction encoding
+// Encodes the AT&T instructions the built-in assembler reads. Where x86-64
+// has several encodings for an instruction the one GNU as picks is used:
+// sign-extended 8-bit immediates where the value fits, the short forms for
+// %al, %ax, %eax and %rax, the one-byte shifts by one, register-to-register
+// moves and arithmetic in their store forms, and a 32-bit immediate for any
+// 64-bit mov whose value sign-extends from one.
+
+// asmOperandKind says what an asmOperand is
+type asmOperandKind int
+
+const (
+	opReg asmOperandKind = iota // General register
+	opXmm                       // SSE register
+	opImm                       // $ immediate
+	opMem                       // Memory reference, or the target of a direct jump
+)
+
+// x86Register describes a register operand
+type x86Register struct {
+	num  byte // 0-15
+	size int  // In bytes, 16 for the SSE registers
+	rex  bool // %spl, %bpl, %sil and %dil need a REX prefix
+	high bool // %ah, %ch, %dh and %bh cannot have one
+}
+
+// x86Registers maps register names, without the %, to registers
+var x86Registers = func() map[string]x86Register {
+	regs := make(map[string]x86Register)
+	names := [][4]string{
+		{"rax", "eax", "ax", "al"}, {"rcx", "ecx", "cx", "cl"},
+		{"rdx", "edx", "dx", "dl"}, {"rbx", "ebx", "bx", "bl"},
+		{"rsp", "esp", "sp", "spl"}, {"rbp", "ebp", "bp", "bpl"},
+		{"rsi", "esi", "si", "sil"}, {"rdi", "edi", "di", "dil"},
+	}
+	for i, n := range names {
+		num := byte(i)
+		regs[n[0]] = x86Register{num: num, size: 8}
+		regs[n[1]] = x86Register{num: num, size: 4}
+		regs[n[2]] = x86Register{num: num, size: 2}
+		regs[n[3]] = x86Register{num: num, size: 1, rex: i >= 4}
+	}
+	for i, n := range []string{"ah", "ch", "dh", "bh"} {
+		regs[n] = x86Register{num: byte(4 + i), size: 1, high: true}
+	}
+	for i := byte(8); i < 16; i++ {
+		n := fmt.Sprintf("r%d", i)
+		regs[n] = x86Register{num: i, size: 8}
+		regs[n+"d"] = x86Register{num: i, size: 4}
+		regs[n+"w"] = x86Register{num: i, size: 2}
+		regs[n+"b"] = x86Register{num: i, size: 1}
+	}
+	for i := byte(0); i < 16; i++ {
+		regs[fmt.Sprintf("xmm%d", i)] = x86Register{num: i, size: 16}
+	}
+	return regs
+}()
+
+// asmOperand is a parsed instruction operand
+type asmOperand struct {
+	kind     asmOperandKind
+	reg      x86Register
+	imm      asmExpr // Immediate value, or displacement of a memory operand
+	base     int     // Base register of a memory operand, or -1
+	index    int     // Index register, or -1
+	scale    int
+	rip      bool // Relative to %rip
+	indirect bool // Starts with *, as an indirect call or jump target
+}
+
+// isAccumulator reports whether op is %al, %ax, %eax or %rax
+func (op *asmOperand) isAccumulator() bool {
+	return op.kind == opReg && op.reg.num == 0 && !op.reg.high
+}
+
+// operand parses one instruction operand
+func (a *assembler) operand(s string) (*asmOperand, error) {
+	op := &asmOperand{base: -1, index: -1, scale: 1}
+	if strings.HasPrefix(s, "*") {
+		op.indirect = true
+		s = strings.TrimSpace(s[1:])
+	}
+	switch {
+	case strings.HasPrefix(s, "%"):
+		reg, ok := x86Registers[s[1:]]
+		if !ok {
+			return nil, fmt.Errorf("unknown register %s", s)
+		}
+		op.kind, op.reg = opReg, reg
+		if reg.size == 16 {
+			op.kind = opXmm
+		}
+		return op, nil
+	case strings.HasPrefix(s, "$"):
+		e, err := a.parseExpr(s[1:])
+		op.kind, op.imm = opImm, e
+		return op, err
+	}
+
+	op.kind = opMem
+	disp := s
+	if open := strings.LastIndexByte(s, '('); open >= 0 && strings.HasSuffix(s, ")") {
+		inner := strings.TrimSpace(s[open+1 : len(s)-1])
+		if strings.HasPrefix(inner, "%") || strings.HasPrefix(inner, ",") {
+			disp = s[:open]
+			parts := strings.Split(inner, ",")
+			if len(parts) > 3 {
+				return nil, fmt.Errorf("bad memory operand %s", s)
+			}
+			if base := strings.TrimSpace(parts[0]); base == "%rip" {
+				op.rip = true
+			} else if base != "" {
+				reg, err := addressRegister(base)
+				if err != nil {
+					return nil, err
+				}
+				op.base = reg
+			}
+			if len(parts) > 1 {
+				reg, err := addressRegister(strings.TrimSpace(parts[1]))
+				if err != nil {
+					return nil, err
+				}
+				if reg == 4 {
+					return nil, fmt.Errorf("%%rsp cannot be an index register")
+				}
+				op.index = reg
+			}
+			if len(parts) > 2 {
+				scale, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+				if err != nil || scale != 1 && scale != 2 && scale != 4 && scale != 8 {
+					return nil, fmt.Errorf("scale factor must be 1, 2, 4 or 8")
+				}
+				op.scale = scale
+			}
+			if op.rip && op.index >= 0 {
+				return nil, fmt.Errorf("%%rip cannot have an index register")
+			}
+		}
+	}
+	if strings.TrimSpace(disp) != "" {
+		e, err := a.parseExpr(disp)
+		if err != nil {
+			return nil, err
+		}
+		op.imm = e
+	}
+	return op, nil
+}
+
+// addressRegister returns the number of a 64-bit register in an address
+func addressRegister(s string) (int, error) {
+	reg, ok := x86Registers[strings.TrimPrefix(s, "%")]
+	if !strings.HasPrefix(s, "%") || !ok || reg.size != 8 {
+		return 0, fmt.Errorf("bad address register %s", s)
+	}
+	return int(reg.num), nil
+}
+
+// REX prefix bits
+const (
+	rexB = 1 << iota // Extends the ModRM r/m, SIB base or opcode register
+	rexX             // Extends the SIB index
+	rexR             // Extends the ModRM reg field
+	rexW             // 64-bit operand size
+)
+
+// x86Insn is an instruction being encoded
+type x86Insn struct {
+	size     int    // Operand size: 2 adds the 0x66 prefix and 8 sets REX.W
+	prefix   []byte // lock and rep
+	sse      byte   // Mandatory prefix of an SSE instruction
+	rex      byte   // REX bits the operands do not imply
+	opcode   []byte
+	reg      byte        // ModRM reg field: a register, or an opcode extension
+	rm       *asmOperand // ModRM r/m operand, or nil without a ModRM byte
+	imm      asmExpr
+	immSize  int
+	immReloc elf.R_X86_64 // Relocates an immediate that names a symbol
+	branch   bool         // The immediate is a call target, relative to the end
+	operands []*asmOperand
+}
+
+// errOperands reports operands an instruction has no form for
+var errOperands = errors.New("invalid operands")
+
+// setImm gives the instruction an immediate of width bytes, for an operation
+// of size bytes, and checks that a constant value fits
+func (in *x86Insn) setImm(e asmExpr, width, size int) error {
+	in.imm, in.immSize = e, width
+	if !e.constant() {
+		switch {
+		case width == 8:
+			in.immReloc = elf.R_X86_64_64
+		case width == 4 && size == 8:
+			in.immReloc = elf.R_X86_64_32S
+		default:
+			in.immReloc = dataRelocs[width][0]
+		}
+		return nil
+	}
+	v, ok := immValue(e.add, size)
+	if !ok || !fitsSigned(v, width) {
+		return fmt.Errorf("immediate %d does not fit the instruction", e.add)
+	}
+	in.imm.add = v
+	return nil
+}
+
+// immValue returns v as the signed value of a size-byte operand, and
+// whether v fits in that size at all
+func immValue(v int64, size int) (int64, bool) {
+	if size >= 8 {
+		return v, true
+	}
+	if !fitsUnsigned(v, size) {
+		return 0, false
+	}
+	shift := uint(64 - size*8)
+	return v << shift >> shift, true
+}
+
+// immWidth returns the width of a full immediate for an operation of size
+// bytes; 64-bit operations take 32 bits, sign-extended
+func immWidth(size int) int {
+	return min(size, 4)
+}
+
+// fitsImm8 reports whether an immediate can take the sign-extended 8-bit form
+func fitsImm8(e asmExpr, size int) bool {
+	if !e.constant() {
+		return false
+	}
+	v, ok := immValue(e.add, size)
+	return ok && fitsSigned(v, 1)
+}
+
+// wide returns the opcode of an operation for its size: the byte form, or the
+// next opcode up for the others
+func wide(op byte, size int) byte {
+	if size == 1 {
+		return op
+	}
+	return op + 1
+}
+
+// plusReg ends the opcode with r added to its last byte
+func (in *x86Insn) plusReg(r x86Register, opcode ...byte) {
+	opcode[len(opcode)-1] += r.num & 7
+	in.opcode = opcode
+	if r.num >= 8 {
+		in.rex |= rexB
+	}
+}
+
+// modrm sets the ModRM fields of the instruction
+func (in *x86Insn) modrm(reg byte, rm *asmOperand) {
+	in.reg, in.rm = reg, rm
+}
+
+// operandSize returns the operation size a suffix gives, or else the size of
+// the general register operands, which must agree with it and each other
+func operandSize(suffix int, ops ...*asmOperand) (int, error) {
+	size := suffix
+	for _, op := range ops {
+		if op.kind != opReg {
+			continue
+		}
+		if size == 0 {
+			size = op.reg.size
+		} else if op.reg.size != size {
+			return 0, fmt.Errorf("operand size mismatch")
+		}
+	}
+	if size == 0 {
+		return 0, fmt.Errorf("no suffix or register gives the operand size")
+	}
+	return size, nil
+}
+
+// emitInsn encodes the instruction and appends it to the current section
+func (a *assembler) emitInsn(in *x86Insn) error {
+	var code []byte
+	if in.size == 2 {
+		code = append(code, 0x66)
+	}
+	code = append(code, in.prefix...)
+	if in.sse != 0 {
+		code = append(code, in.sse)
+	}
+	rex := in.rex
+	if in.size == 8 {
+		rex |= rexW
+	}
+	forceRex, noRex := false, false
+	for _, op := range in.operands {
+		if op.kind == opReg {
+			forceRex = forceRex || op.reg.rex
+			noRex = noRex || op.reg.high
+		}
+	}
+
+	var modrm []byte
+	var disp *asmFixup
+	if in.rm != nil {
+		if in.reg >= 8 {
+			rex |= rexR
+		}
+		switch in.rm.kind {
+		case opReg, opXmm:
+			if in.rm.reg.num >= 8 {
+				rex |= rexB
+			}
+			modrm = []byte{0xc0 | (in.reg&7)<<3 | in.rm.reg.num&7}
+		case opMem:
+			var err error
+			if modrm, disp, err = encodeAddress(in.reg&7, in.rm, &rex); err != nil {
+				return err
+			}
+		default:
+			return errOperands
+		}
+	}
+	if rex != 0 || forceRex {
+		if noRex {
+			return fmt.Errorf("%%ah, %%bh, %%ch and %%dh cannot be used with a REX prefix")
+		}
+		code = append(code, 0x40|rex)
+	}
+	code = append(code, in.opcode...)
+	if disp != nil {
+		disp.at += len(code)
+	}
+	code = append(code, modrm...)
+
+	var fixups []asmFixup
+	if in.immSize > 0 {
+		field := make([]byte, in.immSize)
+		switch {
+		case in.branch:
+			if in.imm.constant() {
+				return fmt.Errorf("call target must be a symbol")
+			}
+			fixups = append(fixups, asmFixup{at: len(code), size: in.immSize, expr: in.imm, pcrel: true, branch: true})
+		case in.imm.constant():
+			putLittleEndian(field, in.imm.add)
+		default:
+			fixups = append(fixups, asmFixup{at: len(code), size: in.immSize, expr: in.imm, rtype: in.immReloc})
+		}
+		code = append(code, field...)
+	}
+	if disp != nil {
+		fixups = append(fixups, *disp)
+	}
+	for i := range fixups {
+		fixups[i].end = len(code)
+	}
+	return a.emitBytes(code, fixups)
+}
+
+// encodeAddress encodes a memory operand as ModRM, SIB and displacement
+// bytes, with a fixup for a displacement that names a symbol
+func encodeAddress(reg byte, m *asmOperand, rex *byte) ([]byte, *asmFixup, error) {
+	d := m.imm
+	if m.rip {
+		return []byte{reg<<3 | 5, 0, 0, 0, 0}, &asmFixup{at: 1, size: 4, expr: d, pcrel: true}, nil
+	}
+
+	mod, dispSize := byte(0), 0
+	switch {
+	case m.base < 0:
+		dispSize = 4
+	case !d.constant():
+		mod, dispSize = 2, 4
+	case d.add == 0 && m.base&7 != 5:
+	case fitsSigned(d.add, 1):
+		mod, dispSize = 1, 1
+	default:
+		mod, dispSize = 2, 4
+	}
+	var code []byte
+	if m.index < 0 && m.base >= 0 && m.base&7 != 4 {
+		code = []byte{mod<<6 | reg<<3 | byte(m.base&7)}
+	} else {
+		index, base := byte(4), byte(5)
+		if m.index >= 0 {
+			index = byte(m.index & 7)
+		}
+		if m.base >= 0 {
+			base = byte(m.base & 7)
+		}
+		scale := map[int]byte{1: 0, 2: 1, 4: 2, 8: 3}[m.scale]
+		code = []byte{mod<<6 | reg<<3 | 4, scale<<6 | index<<3 | base}
+	}
+	if m.base >= 8 {
+		*rex |= rexB
+	}
+	if m.index >= 8 {
+		*rex |= rexX
+	}
+
+	field := make([]byte, dispSize)
+	if !d.constant() {
+		fixup := &asmFixup{at: len(code), size: 4, expr: d, rtype: elf.R_X86_64_32S}
+		return append(code, field...), fixup, nil
+	}
+	if !fitsSigned(d.add, 4) {
+		return nil, nil, fmt.Errorf("displacement %d does not fit in 32 bits", d.add)
+	}
+	putLittleEndian(field, d.add)
+	return append(code, field...), nil, nil
+}
+
+// x86Prefixes are the prefixes written before a mnemonic
+var x86Prefixes = map[string]byte{
+	"lock": 0xf0, "rep": 0xf3, "repe": 0xf3, "repz": 0xf3, "repne": 0xf2, "repnz": 0xf2,
+}
+
+// x86Conditions maps the condition suffixes of jcc, setcc and cmovcc to
+// their condition numbers
+var x86Conditions = map[string]byte{
+	"o": 0, "no": 1, "b": 2, "c": 2, "nae": 2, "ae": 3, "nb": 3, "nc": 3,
+	"e": 4, "z": 4, "ne": 5, "nz": 5, "be": 6, "na": 6, "a": 7, "nbe": 7,
+	"s": 8, "ns": 9, "p": 10, "pe": 10, "np": 11, "po": 11,
+	"l": 12, "nge": 12, "ge": 13, "nl": 13, "le": 14, "ng": 14, "g": 15, "nle": 15,
+}
+
+// x86Suffixes maps size suffixes to operand sizes
+var x86Suffixes = map[byte]int{'b': 1, 'w': 2, 'l': 4, 'q': 8}
+
+// x86Fixed are the instructions without operands
+var x86Fixed = map[string][]byte{
+	"ret": {0xc3}, "retq": {0xc3}, "leave": {0xc9}, "leaveq": {0xc9},
+	"nop": {0x90}, "hlt": {0xf4}, "int3": {0xcc}, "ud2": {0x0f, 0x0b},
+	"syscall": {0x0f, 0x05}, "rdtsc": {0x0f, 0x31}, "cpuid": {0x0f, 0xa2}, "pause": {0xf3, 0x90},
+	"mfence": {0x0f, 0xae, 0xf0}, "lfence": {0x0f, 0xae, 0xe8}, "sfence": {0x0f, 0xae, 0xf8},
+	"pushfq": {0x9c}, "popfq": {0x9d}, "cld": {0xfc}, "std": {0xfd}, "clc": {0xf8}, "stc": {0xf9},
+	"cbtw": {0x66, 0x98}, "cwtl": {0x98}, "cltq": {0x48, 0x98}, "cdqe": {0x48, 0x98},
+	"cwtd": {0x66, 0x99}, "cltd": {0x99}, "cdq": {0x99}, "cqto": {0x48, 0x99}, "cqo": {0x48, 0x99},
+}
+
+// x86Op is an instruction that takes a size suffix: its encoder, and the
+// opcode extension or opcode that picks it out of its family
+type x86Op struct {
+	encode func(a *assembler, in *x86Insn, ext byte, size int, ops []*asmOperand) error
+	ext    byte
+}
+
+// x86Ops maps the mnemonics that take size suffixes, without the suffix
+var x86Ops = map[string]x86Op{
+	"add": {aluInsn, 0}, "or": {aluInsn, 1}, "adc": {aluInsn, 2}, "sbb": {aluInsn, 3},
+	"and": {aluInsn, 4}, "sub": {aluInsn, 5}, "xor": {aluInsn, 6}, "cmp": {aluInsn, 7},
+	"rol": {shiftInsn, 0}, "ror": {shiftInsn, 1}, "rcl": {shiftInsn, 2}, "rcr": {shiftInsn, 3},
+	"shl": {shiftInsn, 4}, "sal": {shiftInsn, 4}, "shr": {shiftInsn, 5}, "sar": {shiftInsn, 7},
+	"not": {unaryInsn, 2}, "neg": {unaryInsn, 3}, "mul": {unaryInsn, 4}, "div": {unaryInsn, 6}, "idiv": {unaryInsn, 7},
+	"inc": {incInsn, 0}, "dec": {incInsn, 1},
+	"imul": {imulInsn, 0}, "mov": {movInsn, 0}, "movabs": {movInsn, 1}, "test": {testInsn, 0},
+	"lea": {leaInsn, 0}, "xchg": {xchgInsn, 0}, "xadd": {exchangeInsn, 0xc0}, "cmpxchg": {exchangeInsn, 0xb0},
+	"push": {pushInsn, 0}, "pop": {popInsn, 0}, "bswap": {bswapInsn, 0},
+	"bt": {bitInsn, 4}, "bts": {bitInsn, 5}, "btr": {bitInsn, 6}, "btc": {bitInsn, 7},
+	"bsf": {scanInsn, 0xbc}, "bsr": {scanInsn, 0xbd},
+	"popcnt": {countInsn, 0xb8}, "lzcnt": {countInsn, 0xbd}, "tzcnt": {countInsn, 0xbc},
+	"movs": {stringInsn, 0xa4}, "cmps": {stringInsn, 0xa6}, "stos": {stringInsn, 0xaa},
+	"lods": {stringInsn, 0xac}, "scas": {stringInsn, 0xae},
+}
+
+// x86Extends are the zero and sign extending moves
+var x86Extends = map[string]struct {
+	size   int
+	opcode []byte
+}{
+	"movzbw": {2, []byte{0x0f, 0xb6}}, "movzbl": {4, []byte{0x0f, 0xb6}}, "movzbq": {8, []byte{0x0f, 0xb6}},
+	"movzwl": {4, []byte{0x0f, 0xb7}}, "movzwq": {8, []byte{0x0f, 0xb7}},
+	"movsbw": {2, []byte{0x0f, 0xbe}}, "movsbl": {4, []byte{0x0f, 0xbe}}, "movsbq": {8, []byte{0x0f, 0xbe}},
+	"movswl": {4, []byte{0x0f, 0xbf}}, "movswq": {8, []byte{0x0f, 0xbf}}, "movslq": {8, []byte{0x63}},
+}
+
+// x86SSE are the SSE instructions on XMM registers: the mandatory prefix,
+// the opcode that loads the destination register, and the opcode that
+// stores a register to memory where there is one
+var x86SSE = map[string]struct{ prefix, load, store byte }{
+	"movsd": {0xf2, 0x10, 0x11}, "movss": {0xf3, 0x10, 0x11},
+	"movapd": {0x66, 0x28, 0x29}, "movaps": {0, 0x28, 0x29},
+	"movupd": {0x66, 0x10, 0x11}, "movups": {0, 0x10, 0x11},
+	"addsd": {0xf2, 0x58, 0}, "mulsd": {0xf2, 0x59, 0}, "subsd": {0xf2, 0x5c, 0}, "divsd": {0xf2, 0x5e, 0},
+	"minsd": {0xf2, 0x5d, 0}, "maxsd": {0xf2, 0x5f, 0}, "sqrtsd": {0xf2, 0x51, 0},
+	"addss": {0xf3, 0x58, 0}, "mulss": {0xf3, 0x59, 0}, "subss": {0xf3, 0x5c, 0}, "divss": {0xf3, 0x5e, 0},
+	"minss": {0xf3, 0x5d, 0}, "maxss": {0xf3, 0x5f, 0}, "sqrtss": {0xf3, 0x51, 0},
+	"cvtsd2ss": {0xf2, 0x5a, 0}, "cvtss2sd": {0xf3, 0x5a, 0},
+	"ucomisd": {0x66, 0x2e, 0}, "comisd": {0x66, 0x2f, 0}, "ucomiss": {0, 0x2e, 0}, "comiss": {0, 0x2f, 0},
+	"andpd": {0x66, 0x54, 0}, "andps": {0, 0x54, 0}, "andnpd": {0x66, 0x55, 0}, "andnps": {0, 0x55, 0},
+	"orpd": {0x66, 0x56, 0}, "orps": {0, 0x56, 0}, "xorpd": {0x66, 0x57, 0}, "xorps": {0, 0x57, 0},
+	"unpcklpd": {0x66, 0x14, 0}, "pxor": {0x66, 0xef, 0}, "pand": {0x66, 0xdb, 0}, "por": {0x66, 0xeb, 0},
+}
+
+// instruction assembles one instruction with its prefixes and operands
+func (a *assembler) instruction(s string) error {
+	var prefix []byte
+	for {
+		mnem, rest := s, ""
+		if i := strings.IndexAny(s, " \t"); i >= 0 {
+			mnem, rest = s[:i], strings.TrimSpace(s[i+1:])
+		}
+		p, ok := x86Prefixes[mnem]
+		if !ok {
+			var ops []*asmOperand
+			for _, arg := range splitArgs(rest) {
+				op, err := a.operand(arg)
+				if err != nil {
+					return err
+				}
+				ops = append(ops, op)
+			}
+			err := a.encode(mnem, prefix, ops)
+			if err == errOperands {
+				return fmt.Errorf("invalid operands for %s", mnem)
+			}
+			return err
+		}
+		prefix = append(prefix, p)
+		if rest == "" {
+			return a.emitBytes(prefix, nil)
+		}
+		s = rest
+	}
+}
+
+// encode encodes an instruction by its mnemonic
+func (a *assembler) encode(mnem string, prefix []byte, ops []*asmOperand) error {
+	in := &x86Insn{prefix: prefix, operands: ops}
+	hasXmm := false
+	for _, op := range ops {
+		hasXmm = hasXmm || op.kind == opXmm
+	}
+	if code, ok := x86Fixed[mnem]; ok && len(ops) == 0 {
+		in.opcode = code
+		return a.emitInsn(in)
+	}
+
+	switch mnem {
+	case "jmp", "jmpq":
+		return a.jumpInsn(in, -1, 0, ops)
+	case "jrcxz":
+		return a.jumpInsn(in, -1, 0xe3, ops)
+	case "loop":
+		return a.jumpInsn(in, -1, 0xe2, ops)
+	case "call", "callq":
+		return a.callInsn(in, ops)
+	case "ret", "retq":
+		if len(ops) != 1 || ops[0].kind != opImm {
+			return errOperands
+		}
+		in.opcode = []byte{0xc2}
+		if err := in.setImm(ops[0].imm, 2, 2); err != nil {
+			return err
+		}
+		return a.emitInsn(in)
+	case "int":
+		if len(ops) != 1 || ops[0].kind != opImm {
+			return errOperands
+		}
+		in.opcode = []byte{0xcd}
+		if err := in.setImm(ops[0].imm, 1, 1); err != nil {
+			return err
+		}
+		return a.emitInsn(in)
+	case "movq", "movd":
+		if hasXmm {
+			return a.movqInsn(in, mnem == "movq", ops)
+		}
+	}
+	if cc, ok := x86Conditions[strings.TrimPrefix(mnem, "j")]; ok && mnem[0] == 'j' {
+		return a.jumpInsn(in, int(cc), 0, ops)
+	}
+	if ext, ok := x86Extends[mnem]; ok {
+		if len(ops) != 2 || ops[0].kind == opImm || ops[0].kind == opXmm || ops[1].kind != opReg || ops[1].reg.size != ext.size {
+			return errOperands
+		}
+		if ops[0].kind == opReg && ops[0].reg.size != map[byte]int{'b': 1, 'w': 2, 'l': 4}[mnem[len(mnem)-2]] {
+			return fmt.Errorf("operand size mismatch")
+		}
+		in.size = ext.size
+		in.opcode = ext.opcode
+		in.modrm(ops[1].reg.num, ops[0])
+		return a.emitInsn(in)
+	}
+	if sse, ok := x86SSE[mnem]; ok {
+		return a.sseInsn(in, sse.prefix, sse.load, sse.store, ops)
+	}
+	if base, ok := x86Conversion(mnem); ok {
+		return a.convertInsn(in, mnem, base, ops)
+	}
+	if strings.HasPrefix(mnem, "set") {
+		if cc, ok := conditionSuffix(mnem[3:], 'b'); ok {
+			if len(ops) != 1 || ops[0].kind == opImm || ops[0].kind == opXmm || ops[0].kind == opReg && ops[0].reg.size != 1 {
+				return errOperands
+			}
+			in.opcode = []byte{0x0f, 0x90 + cc}
+			in.modrm(0, ops[0])
+			return a.emitInsn(in)
+		}
+	}
+	if strings.HasPrefix(mnem, "cmov") {
+		if cc, size, ok := conditionSized(mnem[4:]); ok {
+			if len(ops) != 2 || ops[1].kind != opReg || ops[0].kind != opReg && ops[0].kind != opMem {
+				return errOperands
+			}
+			size, err := operandSize(size, ops...)
+			if err != nil {
+				return err
+			}
+			if size == 1 {
+				return errOperands
+			}
+			in.size = size
+			in.opcode = []byte{0x0f, 0x40 + cc}
+			in.modrm(ops[1].reg.num, ops[0])
+			return a.emitInsn(in)
+		}
+	}
+
+	op, ok := x86Ops[mnem]
+	size := 0
+	if n := len(mnem); !ok && n > 1 {
+		if size, ok = x86Suffixes[mnem[n-1]]; ok {
+			op, ok = x86Ops[mnem[:n-1]]
+		}
+	}
+	if !ok {
+		return fmt.Errorf("unknown instruction %s", mnem)
+	}
+	if hasXmm {
+		return errOperands
+	}
+	return op.encode(a, in, op.ext, size, ops)
+}
+
+// conditionSuffix returns the condition a mnemonic ends with, which may be
+// followed by the one size suffix the instruction allows
+func conditionSuffix(s string, suffix byte) (byte, bool) {
+	if cc, ok := x86Conditions[s]; ok {
+		return cc, true
+	}
+	if n := len(s); n > 1 && s[n-1] == suffix {
+		cc, ok := x86Conditions[s[:n-1]]
+		return cc, ok
+	}
+	return 0, false
+}
+
+// conditionSized returns the condition a mnemonic ends with, and the size
+// of the suffix after it, if any
+func conditionSized(s string) (byte, int, bool) {
+	if cc, ok := x86Conditions[s]; ok {
+		return cc, 0, true
+	}
+	if n := len(s); n > 1 {
+		if size, ok := x86Suffixes[s[n-1]]; ok {
+			cc, ok := x86Conditions[s[:n-1]]
+			return cc, size, ok
+		}
+	}
+	return 0, 0, false
+}
+
+// jumpInsn assembles jmp, jcc and the jumps that only have a short form;
+// direct jumps become items for layout to size
+func (a *assembler) jumpInsn(in *x86Insn, cond int, shortOp byte, ops []*asmOperand) error {
+	if len(ops) != 1 {
+		return errOperands
+	}
+	target := ops[0]
+	if target.indirect {
+		if cond >= 0 || shortOp != 0 || target.kind == opReg && target.reg.size != 8 {
+			return errOperands
+		}
+		in.opcode = []byte{0xff}
+		in.modrm(4, target)
+		return a.emitInsn(in)
+	}
+	if target.kind != opMem || target.base >= 0 || target.index >= 0 || target.rip || target.imm.constant() {
+		return errOperands
+	}
+	if len(in.prefix) > 0 {
+		if err := a.emitBytes(in.prefix, nil); err != nil {
+			return err
+		}
+	}
+	a.add(&asmItem{kind: asmJump, cond: cond, shortOp: shortOp, target: target.imm})
+	return nil
+}
+
+// callInsn assembles a direct call, to a rel32 target, or an indirect one
+func (a *assembler) callInsn(in *x86Insn, ops []*asmOperand) error {
+	if len(ops) != 1 {
+		return errOperands
+	}
+	target := ops[0]
+	if target.indirect {
+		if target.kind == opReg && target.reg.size != 8 {
+			return errOperands
+		}
+		in.opcode = []byte{0xff}
+		in.modrm(2, target)
+		return a.emitInsn(in)
+	}
+	if target.kind != opMem || target.base >= 0 || target.index >= 0 || target.rip {
+		return errOperands
+	}
+	in.opcode = []byte{0xe8}
+	in.imm, in.immSize, in.branch = target.imm, 4, true
+	return a.emitInsn(in)
+}
+
+// aluInsn assembles the two-operand arithmetic and logic instructions
+func aluInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 2 {
+		return errOperands
+	}
+	src, dst := ops[0], ops[1]
+	switch {
+	case src.kind == opImm && (dst.kind == opReg || dst.kind == opMem):
+		size, err := operandSize(suffix, dst)
+		if err != nil {
+			return err
+		}
+		in.size = size
+		width := immWidth(size)
+		switch {
+		case size != 1 && fitsImm8(src.imm, size):
+			in.opcode = []byte{0x83}
+			in.modrm(ext, dst)
+			width = 1
+		case dst.isAccumulator():
+			in.opcode = []byte{wide(0x04+ext*8, size)}
+		default:
+			in.opcode = []byte{wide(0x80, size)}
+			in.modrm(ext, dst)
+		}
+		if err := in.setImm(src.imm, width, size); err != nil {
+			return err
+		}
+	case src.kind == opReg && (dst.kind == opReg || dst.kind == opMem):
+		size, err := operandSize(suffix, src, dst)
+		if err != nil {
+			return err
+		}
+		in.size = size
+		in.opcode = []byte{wide(ext*8, size)}
+		in.modrm(src.reg.num, dst)
+	case src.kind == opMem && dst.kind == opReg:
+		size, err := operandSize(suffix, dst)
+		if err != nil {
+			return err
+		}
+		in.size = size
+		in.opcode = []byte{wide(ext*8+2, size)}
+		in.modrm(dst.reg.num, src)
+	default:
+		return errOperands
+	}
+	return a.emitInsn(in)
+}
+
+// shiftInsn assembles the shifts and rotates, by an immediate, by %cl, or
+// by one when there is no count
+func shiftInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) < 1 || len(ops) > 2 {
+		return errOperands
+	}
+	dst := ops[len(ops)-1]
+	if dst.kind != opReg && dst.kind != opMem {
+		return errOperands
+	}
+	size, err := operandSize(suffix, dst)
+	if err != nil {
+		return err
+	}
+	in.size = size
+	in.modrm(ext, dst)
+	switch count := ops[0]; {
+	case len(ops) == 1 || count.kind == opImm && count.imm.constant() && count.imm.add == 1:
+		in.opcode = []byte{wide(0xd0, size)}
+	case count.kind == opReg && count.reg.size == 1 && count.reg.num == 1 && !count.reg.high:
+		in.opcode = []byte{wide(0xd2, size)}
+	case count.kind == opImm:
+		in.opcode = []byte{wide(0xc0, size)}
+		if err := in.setImm(count.imm, 1, 1); err != nil {
+			return err
+		}
+	default:
+		return errOperands
+	}
+	return a.emitInsn(in)
+}
+
+// unaryInsn assembles not, neg, mul, div and idiv
+func unaryInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 1 || ops[0].kind != opReg && ops[0].kind != opMem {
+		return errOperands
+	}
+	size, err := operandSize(suffix, ops[0])
+	if err != nil {
+		return err
+	}
+	in.size = size
+	in.opcode = []byte{wide(0xf6, size)}
+	in.modrm(ext, ops[0])
+	return a.emitInsn(in)
+}
+
+// incInsn assembles inc and dec
+func incInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 1 || ops[0].kind != opReg && ops[0].kind != opMem {
+		return errOperands
+	}
+	size, err := operandSize(suffix, ops[0])
+	if err != nil {
+		return err
+	}
+	in.size = size
+	in.opcode = []byte{wide(0xfe, size)}
+	in.modrm(ext, ops[0])
+	return a.emitInsn(in)
+}
+
+// imulInsn assembles the one, two and three operand forms of imul
+func imulInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) == 1 {
+		return unaryInsn(a, in, 5, suffix, ops)
+	}
+	if len(ops) == 2 && ops[0].kind == opImm {
+		ops = []*asmOperand{ops[0], ops[1], ops[1]}
+	}
+	if len(ops) < 2 || len(ops) > 3 {
+		return errOperands
+	}
+	src, dst := ops[len(ops)-2], ops[len(ops)-1]
+	if dst.kind != opReg || src.kind != opReg && src.kind != opMem {
+		return errOperands
+	}
+	size, err := operandSize(suffix, src, dst)
+	if err != nil {
+		return err
+	}
+	if size == 1 {
+		return errOperands
+	}
+	in.size = size
+	in.modrm(dst.reg.num, src)
+	if len(ops) == 2 {
+		in.opcode = []byte{0x0f, 0xaf}
+		return a.emitInsn(in)
+	}
+	if ops[0].kind != opImm {
+		return errOperands
+	}
+	in.opcode, ext = []byte{0x69}, byte(immWidth(size))
+	if fitsImm8(ops[0].imm, size) {
+		in.opcode, ext = []byte{0x6b}, 1
+	}
+	if err := in.setImm(ops[0].imm, int(ext), size); err != nil {
+		return err
+	}
+	return a.emitInsn(in)
+}
+
+// movInsn assembles mov, and movabs when ext is set
+func movInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 2 {
+		return errOperands
+	}
+	src, dst := ops[0], ops[1]
+	switch {
+	case src.kind == opImm && dst.kind == opReg:
+		size, err := operandSize(suffix, dst)
+		if err != nil {
+			return err
+		}
+		in.size = size
+		width := size
+		switch {
+		case size == 1:
+			in.plusReg(dst.reg, 0xb0)
+		case size < 8:
+			in.plusReg(dst.reg, 0xb8)
+		case ext == 0 && (!src.imm.constant() || fitsSigned(src.imm.add, 4)):
+			in.opcode = []byte{0xc7}
+			in.modrm(0, dst)
+			width = 4
+		default:
+			in.plusReg(dst.reg, 0xb8)
+		}
+		if err := in.setImm(src.imm, width, size); err != nil {
+			return err
+		}
+	case ext != 0:
+		return errOperands
+	case src.kind == opImm && dst.kind == opMem:
+		size, err := operandSize(suffix)
+		if err != nil {
+			return err
+		}
+		in.size = size
+		in.opcode = []byte{wide(0xc6, size)}
+		in.modrm(0, dst)
+		if err := in.setImm(src.imm, immWidth(size), size); err != nil {
+			return err
+		}
+	case src.kind == opReg && (dst.kind == opReg || dst.kind == opMem):
+		size, err := operandSize(suffix, src, dst)
+		if err != nil {
+			return err
+		}
+		in.size = size
+		in.opcode = []byte{wide(0x88, size)}
+		in.modrm(src.reg.num, dst)
+	case src.kind == opMem && dst.kind == opReg:
+		size, err := operandSize(suffix, dst)
+		if err != nil {
+			return err
+		}
+		in.size = size
+		in.opcode = []byte{wide(0x8a, size)}
+		in.modrm(dst.reg.num, src)
+	default:
+		return errOperands
+	}
+	return a.emitInsn(in)
+}
+
+// testInsn assembles test, which has no sign-extended 8-bit immediate form
+func testInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 2 {
+		return errOperands
+	}
+	src, dst := ops[0], ops[1]
+	switch {
+	case src.kind == opImm && (dst.kind == opReg || dst.kind == opMem):
+		size, err := operandSize(suffix, dst)
+		if err != nil {
+			return err
+		}
+		in.size = size
+		if dst.isAccumulator() {
+			in.opcode = []byte{wide(0xa8, size)}
+		} else {
+			in.opcode = []byte{wide(0xf6, size)}
+			in.modrm(0, dst)
+		}
+		if err := in.setImm(src.imm, immWidth(size), size); err != nil {
+			return err
+		}
+	case src.kind == opReg && (dst.kind == opReg || dst.kind == opMem),
+		src.kind == opMem && dst.kind == opReg:
+		size, err := operandSize(suffix, src, dst)
+		if err != nil {
+			return err
+		}
+		in.size = size
+		in.opcode = []byte{wide(0x84, size)}
+		if src.kind == opReg {
+			in.modrm(src.reg.num, dst)
+		} else {
+			in.modrm(dst.reg.num, src)
+		}
+	default:
+		return errOperands
+	}
+	return a.emitInsn(in)
+}
+
+// leaInsn assembles lea
+func leaInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 2 || ops[0].kind != opMem || ops[1].kind != opReg {
+		return errOperands
+	}
+	size, err := operandSize(suffix, ops[1])
+	if err != nil {
+		return err
+	}
+	if size == 1 {
+		return errOperands
+	}
+	in.size = size
+	in.opcode = []byte{0x8d}
+	in.modrm(ops[1].reg.num, ops[0])
+	return a.emitInsn(in)
+}
+
+// xchgInsn assembles xchg, in its one-byte form when one operand is the
+// accumulator
+func xchgInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 2 {
+		return errOperands
+	}
+	first, second := ops[0], ops[1]
+	size, err := operandSize(suffix, first, second)
+	if err != nil {
+		return err
+	}
+	in.size = size
+	if first.kind == opReg && second.kind == opReg && size > 1 {
+		other := first
+		if first.isAccumulator() {
+			other = second
+		}
+		if (first.isAccumulator() || second.isAccumulator()) && !(size == 4 && other.reg.num == 0) {
+			in.plusReg(other.reg, 0x90)
+			return a.emitInsn(in)
+		}
+	}
+	in.opcode = []byte{wide(0x86, size)}
+	switch {
+	case first.kind == opReg && (second.kind == opReg || second.kind == opMem):
+		in.modrm(first.reg.num, second)
+	case first.kind == opMem && second.kind == opReg:
+		in.modrm(second.reg.num, first)
+	default:
+		return errOperands
+	}
+	return a.emitInsn(in)
+}
+
+// exchangeInsn assembles xadd and cmpxchg, whose register is the source
+func exchangeInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 2 || ops[0].kind != opReg || ops[1].kind != opReg && ops[1].kind != opMem {
+		return errOperands
+	}
+	size, err := operandSize(suffix, ops...)
+	if err != nil {
+		return err
+	}
+	in.size = size
+	in.opcode = []byte{0x0f, wide(ext, size)}
+	in.modrm(ops[0].reg.num, ops[1])
+	return a.emitInsn(in)
+}
+
+// pushInsn assembles push, which is always 64-bit
+func pushInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 1 || suffix != 0 && suffix != 8 {
+		return errOperands
+	}
+	switch op := ops[0]; op.kind {
+	case opReg:
+		if op.reg.size != 8 {
+			return errOperands
+		}
+		in.plusReg(op.reg, 0x50)
+	case opImm:
+		in.opcode = []byte{0x68}
+		width := 4
+		if fitsImm8(op.imm, 8) {
+			in.opcode, width = []byte{0x6a}, 1
+		}
+		if err := in.setImm(op.imm, width, 8); err != nil {
+			return err
+		}
+	case opMem:
+		in.opcode = []byte{0xff}
+		in.modrm(6, op)
+	default:
+		return errOperands
+	}
+	return a.emitInsn(in)
+}
+
+// popInsn assembles pop, which is always 64-bit
+func popInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 1 || suffix != 0 && suffix != 8 {
+		return errOperands
+	}
+	switch op := ops[0]; op.kind {
+	case opReg:
+		if op.reg.size != 8 {
+			return errOperands
+		}
+		in.plusReg(op.reg, 0x58)
+	case opMem:
+		in.opcode = []byte{0x8f}
+		in.modrm(0, op)
+	default:
+		return errOperands
+	}
+	return a.emitInsn(in)
+}
+
+// bswapInsn assembles bswap of a 32 or 64-bit register
+func bswapInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 1 || ops[0].kind != opReg {
+		return errOperands
+	}
+	size, err := operandSize(suffix, ops[0])
+	if err != nil {
+		return err
+	}
+	if size < 4 {
+		return errOperands
+	}
+	in.size = size
+	in.plusReg(ops[0].reg, 0x0f, 0xc8)
+	return a.emitInsn(in)
+}
+
+// bitInsn assembles bt, bts, btr and btc
+func bitInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 2 || ops[1].kind != opReg && ops[1].kind != opMem {
+		return errOperands
+	}
+	src, dst := ops[0], ops[1]
+	size, err := operandSize(suffix, ops...)
+	if err != nil {
+		return err
+	}
+	if size == 1 {
+		return errOperands
+	}
+	in.size = size
+	switch src.kind {
+	case opImm:
+		in.opcode = []byte{0x0f, 0xba}
+		in.modrm(ext, dst)
+		if err := in.setImm(src.imm, 1, 1); err != nil {
+			return err
+		}
+	case opReg:
+		in.opcode = []byte{0x0f, 0xa3 + (ext-4)*8}
+		in.modrm(src.reg.num, dst)
+	default:
+		return errOperands
+	}
+	return a.emitInsn(in)
+}
+
+// scanInsn assembles bsf and bsr
+func scanInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 2 || ops[1].kind != opReg || ops[0].kind != opReg && ops[0].kind != opMem {
+		return errOperands
+	}
+	size, err := operandSize(suffix, ops...)
+	if err != nil {
+		return err
+	}
+	if size == 1 {
+		return errOperands
+	}
+	in.size = size
+	in.opcode = []byte{0x0f, ext}
+	in.modrm(ops[1].reg.num, ops[0])
+	return a.emitInsn(in)
+}
+
+// countInsn assembles popcnt, lzcnt and tzcnt, which are encoded as bsf and
+// bsr are with an f3 prefix
+func countInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	in.sse = 0xf3
+	return scanInsn(a, in, ext, suffix, ops)
+}
+
+// stringInsn assembles the string instructions, which take no operands
+func stringInsn(a *assembler, in *x86Insn, ext byte, suffix int, ops []*asmOperand) error {
+	if len(ops) != 0 || suffix == 0 {
+		return errOperands
+	}
+	in.size = suffix
+	in.opcode = []byte{wide(ext, suffix)}
+	return a.emitInsn(in)
+}
+
+// sseInsn assembles an SSE instruction from x86SSE
+func (a *assembler) sseInsn(in *x86Insn, prefix, load, store byte, ops []*asmOperand) error {
+	if len(ops) != 2 {
+		return errOperands
+	}
+	src, dst := ops[0], ops[1]
+	in.sse = prefix
+	switch {
+	case dst.kind == opXmm && (src.kind == opXmm || src.kind == opMem):
+		in.opcode = []byte{0x0f, load}
+		in.modrm(dst.reg.num, src)
+	case store != 0 && src.kind == opXmm && dst.kind == opMem:
+		in.opcode = []byte{0x0f, store}
+		in.modrm(src.reg.num, dst)
+	default:
+		return errOperands
+	}
+	return a.emitInsn(in)
+}
+
+// movqInsn assembles movq and movd between SSE registers, general registers
+// and memory
+func (a *assembler) movqInsn(in *x86Insn, quad bool, ops []*asmOperand) error {
+	if len(ops) != 2 {
+		return errOperands
+	}
+	src, dst := ops[0], ops[1]
+	size := 4
+	if quad {
+		size = 8
+	}
+	in.sse = 0x66
+	switch {
+	case quad && dst.kind == opXmm && src.kind == opXmm:
+		in.sse, in.opcode = 0xf3, []byte{0x0f, 0x7e}
+		in.modrm(dst.reg.num, src)
+	case dst.kind == opXmm && (src.kind == opReg || src.kind == opMem):
+		if src.kind == opReg && src.reg.size != size {
+			return fmt.Errorf("operand size mismatch")
+		}
+		in.opcode = []byte{0x0f, 0x6e}
+		in.modrm(dst.reg.num, src)
+	case src.kind == opXmm && (dst.kind == opReg || dst.kind == opMem):
+		if dst.kind == opReg && dst.reg.size != size {
+			return fmt.Errorf("operand size mismatch")
+		}
+		in.opcode = []byte{0x0f, 0x7e}
+		in.modrm(src.reg.num, dst)
+	default:
+		return errOperands
+	}
+	if quad {
+		in.rex |= rexW
+	}
+	return a.emitInsn(in)
+}
+
+// x86Conversions are the conversions between integers and floating point:
+// the mandatory prefix, the opcode, and whether the integer is the source
+var x86Conversions = map[string]struct {
+	prefix, opcode byte
+	fromInt        bool
+}{
+	"cvtsi2sd": {0xf2, 0x2a, true}, "cvtsi2ss": {0xf3, 0x2a, true},
+	"cvttsd2si": {0xf2, 0x2c, false}, "cvtsd2si": {0xf2, 0x2d, false},
+	"cvttss2si": {0xf3, 0x2c, false}, "cvtss2si": {0xf3, 0x2d, false},
+}
+
+// x86Conversion returns the conversion a mnemonic names, which may have an
+// l or q suffix
+func x86Conversion(mnem string) (string, bool) {
+	if _, ok := x86Conversions[mnem]; ok {
+		return mnem, true
+	}
+	if n := len(mnem); n > 1 && (mnem[n-1] == 'l' || mnem[n-1] == 'q') {
+		_, ok := x86Conversions[mnem[:n-1]]
+		return mnem[:n-1], ok
+	}
+	return "", false
+}
+
+// convertInsn assembles a conversion between an integer and a float
+func (a *assembler) convertInsn(in *x86Insn, mnem, base string, ops []*asmOperand) error {
+	conv := x86Conversions[base]
+	if len(ops) != 2 {
+		return errOperands
+	}
+	src, dst := ops[0], ops[1]
+	suffix := 0
+	if mnem != base {
+		suffix = x86Suffixes[mnem[len(mnem)-1]]
+	}
+	var size int
+	var err error
+	if conv.fromInt {
+		if dst.kind != opXmm || src.kind != opReg && src.kind != opMem {
+			return errOperands
+		}
+		size, err = operandSize(suffix, src)
+		in.modrm(dst.reg.num, src)
+	} else {
+		if dst.kind != opReg || src.kind != opXmm && src.kind != opMem {
+			return errOperands
+		}
+		size, err = operandSize(suffix, dst)
+		in.modrm(dst.reg.num, src)
+	}
+	if err != nil {
+		return err
+	}
+	if size != 4 && size != 8 {
+		return errOperands
+	}
+	if size == 8 {
+		in.rex |= rexW
+	}
+	in.sse = conv.prefix
+	in.opcode = []byte{0x0f, conv.opcode}
+	return a.emitInsn(in)
+}